@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var Check = &cobra.Command{
+	Use:   "check",
+	Short: "Check the connectivity of the kafka-proxy configuration",
+	Long: `Check takes the flags of the server. The listener addresses are bound and closed, every configured broker is dialed
+and authenticated (TLS, gateway and SASL). The report of the listeners and the brokers is printed, the exit code is 1
+when a check failed.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return initConfig()
+	},
+	Run: RunCheck,
+}
+
+func RunCheck(_ *cobra.Command, _ []string) {
+	pluginOptions, closePlugins := newPluginOptions()
+	proxyServer, err := proxy.New(c, pluginOptions...)
+	if err != nil {
+		closePlugins()
+		logrus.Fatal(err)
+	}
+	results, err := proxyServer.Check()
+	closePlugins()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if !printCheckResults(os.Stdout, results) {
+		os.Exit(1)
+	}
+}
+
+// printCheckResults prints the report of the checks and returns whether all checks passed
+func printCheckResults(out io.Writer, results []proxy.CheckResult) bool {
+	passed := true
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tADDRESS\tCLUSTER\tSTATUS\tDURATION\tERROR")
+	for _, result := range results {
+		status, message := "OK", ""
+		if result.Err != nil {
+			passed = false
+			status, message = "FAILED", result.Err.Error()
+			if result.Stage != "" {
+				status = "FAILED (" + result.Stage + ")"
+			}
+		}
+		cluster := result.Cluster
+		if cluster == "" {
+			cluster = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\n", result.Kind, result.Address, cluster, status, result.Duration.Round(time.Millisecond), message)
+	}
+	w.Flush()
+	return passed
+}
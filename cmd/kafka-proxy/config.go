@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var printEffectiveConfig bool
+
+var Config = &cobra.Command{
+	Use:   "config",
+	Short: "Validate the kafka-proxy configuration",
+}
+
+var configValidate = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration given by the server flags without starting the proxy",
+	Long: `Validate takes the flags of the server. The secrets are resolved, the server mapping file is loaded and the configuration
+is validated including the settings of the upstream clusters. With --print the effective configuration is printed as YAML
+with the secrets redacted.`,
+	SilenceUsage: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := initConfig(); err != nil {
+			return err
+		}
+		return c.ValidateClusterSettings()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !printEffectiveConfig {
+			fmt.Println("Configuration is valid")
+			return nil
+		}
+		effectiveConfig, err := c.EffectiveConfig()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(effectiveConfig))
+		return nil
+	},
+}
+
+func init() {
+	configValidate.Flags().BoolVar(&printEffectiveConfig, "print", false, "Print the effective configuration with the secrets redacted")
+	Config.AddCommand(configValidate)
+}
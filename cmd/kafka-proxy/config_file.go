@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configFileFlag is not accepted in the configuration file
+const configFileFlag = "config-file"
+
+// only the names of the environment variables are interpolated, so ${1+32400} of the address mapping rules is kept
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadConfigFile sets the flags which are not given on the command line from the YAML, JSON or TOML configuration file.
+// The keys are the flag names, the keys of the nested tables are joined with - e.g. tls: {enable: true} is tls-enable.
+// The lists set the repeated flags, the ${NAME} references of the string values are replaced by the environment variables.
+func loadConfigFile(flags *pflag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		if err = yaml.UnmarshalStrict(data, &values); err != nil {
+			return fmt.Errorf("configuration file %s is invalid: %v", path, err)
+		}
+	case ".toml":
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return fmt.Errorf("configuration file %s is invalid: %v", path, err)
+		}
+		values = tree.ToMap()
+	default:
+		return fmt.Errorf("configuration file %s must have the extension .yaml, .yml, .json or .toml", path)
+	}
+	settings := make(map[string]interface{})
+	if err = flattenConfigValues("", values, settings); err != nil {
+		return fmt.Errorf("configuration file %s: %v", path, err)
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil || name == configFileFlag {
+			return fmt.Errorf("configuration file %s has unknown key %s", path, name)
+		}
+		if flag.Changed {
+			// the command line takes precedence
+			continue
+		}
+		items, ok := settings[name].([]interface{})
+		if !ok {
+			items = []interface{}{settings[name]}
+		}
+		for _, item := range items {
+			value, err := configValue(item)
+			if err != nil {
+				return fmt.Errorf("configuration file %s key %s: %v", path, name, err)
+			}
+			if err = flags.Set(name, value); err != nil {
+				return fmt.Errorf("configuration file %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flattenConfigValues joins the keys of the nested tables with -
+func flattenConfigValues(prefix string, values interface{}, settings map[string]interface{}) error {
+	switch m := values.(type) {
+	case map[string]interface{}:
+		for key, value := range m {
+			if err := flattenConfigValue(prefix+key, value, settings); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range m {
+			if err := flattenConfigValue(prefix+fmt.Sprint(key), value, settings); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func flattenConfigValue(name string, value interface{}, settings map[string]interface{}) error {
+	switch value.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return flattenConfigValues(name+"-", value, settings)
+	}
+	if _, ok := settings[name]; ok {
+		return fmt.Errorf("key %s is given twice", name)
+	}
+	settings[name] = value
+	return nil
+}
+
+// configValue returns the flag value of the scalar, the environment variables of the strings are interpolated
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		var err error
+		result := envReferencePattern.ReplaceAllStringFunc(v, func(reference string) string {
+			name := envReferencePattern.FindStringSubmatch(reference)[1]
+			env, ok := os.LookupEnv(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %s is not set", name)
+			}
+			return env
+		})
+		return result, err
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+		return "", fmt.Errorf("nested lists and tables are not supported")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testConfigFlags struct {
+	mappings    []string
+	rules       []string
+	tlsEnable   bool
+	password    string
+	dialTimeout time.Duration
+	retries     int
+}
+
+func testConfigFlagSet(flags *testConfigFlags) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringArrayVar(&flags.mappings, "bootstrap-server-mapping", []string{}, "")
+	fs.StringArrayVar(&flags.rules, "address-mapping-rule", []string{}, "")
+	fs.BoolVar(&flags.tlsEnable, "tls-enable", false, "")
+	fs.StringVar(&flags.password, "sasl-password", "", "")
+	fs.DurationVar(&flags.dialTimeout, "kafka-dial-timeout", 15*time.Second, "")
+	fs.IntVar(&flags.retries, "kafka-dial-retries", 0, "")
+	fs.StringVar(&configFile, configFileFlag, "", "")
+	return fs
+}
+
+func testConfigFile(a *assert.Assertions, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	a.Nil(ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "config-file")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_SASL_PASSWORD", "secret")
+	defer os.Unsetenv("TEST_SASL_PASSWORD")
+
+	yamlFile := testConfigFile(a, dir, "kafka-proxy.yaml", `
+bootstrap-server-mapping:
+  - kafka-0:9092,0.0.0.0:32400
+  - kafka-1:9092,0.0.0.0:32401
+address-mapping-rule: kafka-*:9092,0.0.0.0:${1+32400}
+tls:
+  enable: true
+sasl-password: pass-${TEST_SASL_PASSWORD}
+kafka:
+  dial-timeout: 3s
+  dial-retries: 2
+`)
+	var flags testConfigFlags
+	fs := testConfigFlagSet(&flags)
+	a.Nil(fs.Parse([]string{"--kafka-dial-retries", "5"}))
+	a.Nil(loadConfigFile(fs, yamlFile))
+	a.Equal([]string{"kafka-0:9092,0.0.0.0:32400", "kafka-1:9092,0.0.0.0:32401"}, flags.mappings)
+	a.Equal([]string{"kafka-*:9092,0.0.0.0:${1+32400}"}, flags.rules)
+	a.True(flags.tlsEnable)
+	a.Equal("pass-secret", flags.password)
+	a.Equal(3*time.Second, flags.dialTimeout)
+	// the command line takes precedence
+	a.Equal(5, flags.retries)
+
+	tomlFile := testConfigFile(a, dir, "kafka-proxy.toml", `
+bootstrap-server-mapping = ["kafka-0:9092,0.0.0.0:32400"]
+tls-enable = true
+
+[kafka]
+dial-retries = 3
+`)
+	flags = testConfigFlags{}
+	fs = testConfigFlagSet(&flags)
+	a.Nil(loadConfigFile(fs, tomlFile))
+	a.Equal([]string{"kafka-0:9092,0.0.0.0:32400"}, flags.mappings)
+	a.True(flags.tlsEnable)
+	a.Equal(3, flags.retries)
+	a.Equal(15*time.Second, flags.dialTimeout)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown.yaml", "tls-enabled: true", "configuration file %s has unknown key tls-enabled"},
+		{"config-file.yaml", "config-file: other.yaml", "configuration file %s has unknown key config-file"},
+		{"env.yaml", "sasl-password: ${TEST_UNSET_PASSWORD}", "configuration file %s key sasl-password: environment variable TEST_UNSET_PASSWORD is not set"},
+		{"twice.yaml", "tls-enable: true\ntls:\n  enable: false", "configuration file %s: key tls-enable is given twice"},
+		{"invalid.yaml", "kafka-dial-timeout: 3", "configuration file %s: invalid argument \"3\" for \"--kafka-dial-timeout\" flag: time: missing unit in duration \"3\""},
+		{"nested.yaml", "bootstrap-server-mapping: [[a, b]]", "configuration file %s key bootstrap-server-mapping: nested lists and tables are not supported"},
+		{"kafka-proxy.ini", "tls-enable = true", "configuration file %s must have the extension .yaml, .yml, .json or .toml"},
+	}
+	for _, test := range tests {
+		path := testConfigFile(a, dir, test.name, test.content)
+		fs = testConfigFlagSet(&testConfigFlags{})
+		a.EqualError(loadConfigFile(fs, path), fmt.Sprintf(test.err, path), test.name)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+var healthCheckFlags struct {
+	listenAddress string
+	healthPath    string
+	unixSocket    string
+	timeout       time.Duration
+}
+
+var HealthCheck = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check the health endpoint of the local kafka-proxy",
+	Long: `Healthcheck requests the health endpoint of the kafka-proxy running on the same host or in the same container,
+on the HTTP listen address or on the Unix socket. The exit code is 0 when the proxy is healthy and 1 otherwise,
+so the container health checks and the exec probes need no curl or wget.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := healthCheck(healthCheckFlags.listenAddress, healthCheckFlags.healthPath, healthCheckFlags.unixSocket, healthCheckFlags.timeout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	// the flags are named and defaulted as the flags of the server
+	HealthCheck.Flags().StringVar(&healthCheckFlags.listenAddress, "http-listen-address", "0.0.0.0:9080", "Address that kafka-proxy is listening on, the unspecified host is requested on the loopback address")
+	HealthCheck.Flags().StringVar(&healthCheckFlags.healthPath, "http-health-path", "/health", "Path on which to health endpoint")
+	HealthCheck.Flags().StringVar(&healthCheckFlags.unixSocket, "http-unix-socket", "", "Unix socket on which the HTTP endpoints are served, it is requested instead of the listen address")
+	HealthCheck.Flags().DurationVar(&healthCheckFlags.timeout, "timeout", 5*time.Second, "How long to wait for the health endpoint")
+}
+
+// healthCheck requests the health endpoint, the health check fails unless the response status is 200 OK
+func healthCheck(listenAddress string, healthPath string, unixSocket string, timeout time.Duration) error {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	transport := &http.Transport{DisableKeepAlives: true}
+	if unixSocket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", unixSocket)
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	response, err := client.Get("http://" + net.JoinHostPort(host, port) + healthPath)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("health endpoint returned %s: %s", response.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	a := assert.New(t)
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`OK`))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer listener.Close()
+	go http.Serve(listener, handler)
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	a.Nil(err)
+
+	// the unspecified host is requested on the loopback address
+	a.Nil(healthCheck("0.0.0.0:"+port, "/health", "", time.Second))
+	a.Nil(healthCheck(":"+port, "/health", "", time.Second))
+	a.EqualError(healthCheck("0.0.0.0:"+port, "/ready", "", time.Second), "health endpoint returned 404 Not Found: 404 page not found\n")
+
+	dir, err := ioutil.TempDir("", "healthcheck")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "http.sock")
+	// the stale socket file is removed
+	a.Nil(ioutil.WriteFile(socket, nil, 0600))
+	socketListener, err := listenUnixSocket(socket)
+	a.Nil(err)
+	defer socketListener.Close()
+	go http.Serve(socketListener, handler)
+
+	a.Nil(healthCheck("0.0.0.0:1", "/health", socket, time.Second))
+	a.NotNil(healthCheck("0.0.0.0:1", "/health", "", time.Second))
+	a.NotNil(healthCheck("0.0.0.0:"+port, "/health", filepath.Join(dir, "missing.sock"), time.Second))
+}
@@ -0,0 +1,31 @@
+//go:build go1.21
+// +build go1.21
+
+package server
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy"
+	"log/slog"
+	"os"
+)
+
+// newSlogLogger creates the slog logger of the proxy with the log format and the log level
+func newSlogLogger(format string, level string) (proxy.Logger, error) {
+	slogLevel := slog.LevelInfo
+	switch level {
+	case "trace", "debug":
+		slogLevel = slog.LevelDebug
+	case "warn", "warning":
+		slogLevel = slog.LevelWarn
+	case "error", "fatal", "panic":
+		slogLevel = slog.LevelError
+	}
+	options := &slog.HandlerOptions{Level: slogLevel}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	return proxy.NewSlogLogger(slog.New(handler)), nil
+}
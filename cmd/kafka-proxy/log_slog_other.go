@@ -0,0 +1,13 @@
+//go:build !go1.21
+// +build !go1.21
+
+package server
+
+import (
+	"errors"
+	"github.com/grepplabs/kafka-proxy/proxy"
+)
+
+func newSlogLogger(format string, level string) (proxy.Logger, error) {
+	return nil, errors.New("slog log backend requires Go 1.21")
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/proxy"
@@ -9,6 +10,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"net"
 	"net/http"
@@ -23,21 +25,33 @@ import (
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	gatewayclient "github.com/grepplabs/kafka-proxy/plugin/gateway-client/shared"
 	gatewayserver "github.com/grepplabs/kafka-proxy/plugin/gateway-server/shared"
+	keysigner "github.com/grepplabs/kafka-proxy/plugin/key-signer/shared"
 	localauth "github.com/grepplabs/kafka-proxy/plugin/local-auth/shared"
+	requestauthz "github.com/grepplabs/kafka-proxy/plugin/request-authz/shared"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
 	"strings"
 
 	"github.com/grepplabs/kafka-proxy/pkg/registry"
 	// built-in plugins
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/clientid-filter"
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/file-auth"
 	_ "github.com/grepplabs/kafka-proxy/pkg/libs/googleid-info"
 	_ "github.com/grepplabs/kafka-proxy/pkg/libs/googleid-provider"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/kms"
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/ldap-auth"
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/oidc-info"
+	_ "github.com/grepplabs/kafka-proxy/pkg/libs/wasm-filter"
 	"github.com/spf13/viper"
 )
 
 var (
 	c = new(config.Config)
 
+	// the flags of the server shared by the check and the config validate commands, they are set by the configuration file
+	serverFlags *pflag.FlagSet
+	configFile  string
+
 	bootstrapServersMapping = make([]string, 0)
 	externalServersMapping  = make([]string, 0)
 )
@@ -46,23 +60,48 @@ var Server = &cobra.Command{
 	Use:   "server",
 	Short: "Run the kafka-proxy server",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		SetLogger()
+		return initConfig()
+	},
+	Run: Run,
+}
 
-		if err := c.InitSASLCredentials(); err != nil {
-			return err
-		}
-		if err := c.InitBootstrapServers(getOrEnvStringSlice(bootstrapServersMapping, "BOOTSTRAP_SERVER_MAPPING")); err != nil {
-			return err
-		}
-		if err := c.InitExternalServers(getOrEnvStringSlice(externalServersMapping, "EXTERNAL_SERVER_MAPPING")); err != nil {
+// initConfig sets the logger and initializes and validates the configuration given by the flags and the configuration file
+func initConfig() error {
+	if configFile != "" {
+		if err := loadConfigFile(serverFlags, configFile); err != nil {
 			return err
 		}
-		if err := c.Validate(); err != nil {
-			return err
-		}
-		return nil
-	},
-	Run: Run,
+	}
+	SetLogger()
+
+	if err := c.InitSASLCredentials(); err != nil {
+		return err
+	}
+	if c.Vault.Address == "" {
+		c.Vault.Address = os.Getenv("VAULT_ADDR")
+	}
+	if c.Vault.Token == "" {
+		c.Vault.Token = config.Secret(os.Getenv("VAULT_TOKEN"))
+	}
+	secretResolver := kms.NewSecretResolver(c.Secrets.Timeout)
+	if err := c.ResolveSecrets(func(value string) (string, error) {
+		return secretResolver.Resolve(context.Background(), value)
+	}); err != nil {
+		return err
+	}
+	if err := c.InitBootstrapServers(getOrEnvStringSlice(bootstrapServersMapping, "BOOTSTRAP_SERVER_MAPPING")); err != nil {
+		return err
+	}
+	if err := c.InitExternalServers(getOrEnvStringSlice(externalServersMapping, "EXTERNAL_SERVER_MAPPING")); err != nil {
+		return err
+	}
+	if err := c.InitServerMappingFile(); err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return nil
 }
 
 func getOrEnvStringSlice(value []string, envKey string) []string {
@@ -74,14 +113,28 @@ func getOrEnvStringSlice(value []string, envKey string) []string {
 
 func init() {
 	initFlags()
+	// the check and the config validate commands take the server flags
+	Check.Flags().AddFlagSet(Server.Flags())
+	configValidate.Flags().AddFlagSet(Server.Flags())
 }
 
 func initFlags() {
+	serverFlags = Server.Flags()
+
+	// configuration file
+	Server.Flags().StringVar(&configFile, configFileFlag, "", "YAML, JSON or TOML file with the flags as keys e.g. tls-enable: true, the keys of the nested tables are joined with - e.g. tls: {enable: true}. The lists set the repeated flags, the ${NAME} references are replaced by the environment variables. The flags given on the command line take precedence")
+
 	// proxy
 	Server.Flags().StringVar(&c.Proxy.DefaultListenerIP, "default-listener-ip", "127.0.0.1", "Default listener IP")
-	Server.Flags().StringArrayVar(&bootstrapServersMapping, "bootstrap-server-mapping", []string{}, "Mapping of Kafka bootstrap server address to local address (host:port,host:port(,advhost:advport))")
+	Server.Flags().StringArrayVar(&bootstrapServersMapping, "bootstrap-server-mapping", []string{}, "Mapping of Kafka bootstrap server address to local address (host:port,host:port(,advhost:advport)). The local host can be a network interface name prefixed with % e.g. %eth1, its address is resolved at startup")
 	Server.Flags().StringArrayVar(&externalServersMapping, "external-server-mapping", []string{}, "Mapping of Kafka server address to external address (host:port,host:port). A listener for the external address is not started")
+	Server.Flags().StringArrayVar(&c.Proxy.AddressMappingRules, "address-mapping-rule", []string{}, "Mapping rule of the Kafka server addresses without server mapping (pattern,host:port(,advhost:advport)). The pattern * is a capture group, a pattern prefixed with ~ is a regular expression. The addresses refer to the capture groups as $1 or ${1+32400}")
+	Server.Flags().StringVar(&c.Proxy.AdvertisedHostTemplate, "advertised-host-template", "", "Go template of the advertised host of the brokers e.g. b{{.BrokerID}}.proxy.example.com. If provided, the advertised hosts are rendered from the broker ids and the ports of the mappings are kept")
+	Server.Flags().StringVar(&c.Proxy.ServerMapping.File, "server-mapping-file", "", "YAML or JSON file with bootstrap-server-mapping and external-server-mapping lists. The file is watched, the listeners of added and removed mappings are started and closed")
 	Server.Flags().BoolVar(&c.Proxy.DisableDynamicListeners, "dynamic-listeners-disable", false, "Disable dynamic listeners.")
+	Server.Flags().StringVar(&c.Proxy.AddressLookup.Url, "address-lookup-url", "", "URL of the HTTP service which maps the brokers without bootstrap or external server mapping. GET url?broker=host:port returns {\"listener_address\":\"host:port\",\"advertised_address\":\"host:port\"} or 404")
+	Server.Flags().DurationVar(&c.Proxy.AddressLookup.TTL, "address-lookup-ttl", 1*time.Minute, "How long the looked up broker address mappings are cached")
+	Server.Flags().DurationVar(&c.Proxy.AddressLookup.Timeout, "address-lookup-timeout", 5*time.Second, "How long to wait for the address lookup service")
 
 	Server.Flags().IntVar(&c.Proxy.RequestBufferSize, "proxy-request-buffer-size", 4096, "Request buffer size pro tcp connection")
 	Server.Flags().IntVar(&c.Proxy.ResponseBufferSize, "proxy-response-buffer-size", 4096, "Response buffer size pro tcp connection")
@@ -89,48 +142,175 @@ func initFlags() {
 	Server.Flags().IntVar(&c.Proxy.ListenerReadBufferSize, "proxy-listener-read-buffer-size", 0, "Size of the operating system's receive buffer associated with the connection. If zero, system default is used")
 	Server.Flags().IntVar(&c.Proxy.ListenerWriteBufferSize, "proxy-listener-write-buffer-size", 0, "Sets the size of the operating system's transmit buffer associated with the connection. If zero, system default is used")
 	Server.Flags().DurationVar(&c.Proxy.ListenerKeepAlive, "proxy-listener-keep-alive", 60*time.Second, "Keep alive period for an active network connection. If zero, keep-alives are disabled")
+	Server.Flags().DurationVar(&c.Proxy.ListenerKeepAliveInterval, "proxy-listener-keep-alive-interval", 0, "Time between the unacknowledged keep-alive probes of the client connections. If zero, system default is used")
+	Server.Flags().IntVar(&c.Proxy.ListenerKeepAliveCount, "proxy-listener-keep-alive-count", 0, "Unacknowledged keep-alive probes before the client connection is dropped. If zero, system default is used")
+	Server.Flags().BoolVar(&c.Proxy.ListenerNoDelay, "proxy-listener-no-delay", true, "Set TCP_NODELAY on the client connections. If false, the Nagle's algorithm delays the small writes")
+	Server.Flags().BoolVar(&c.Proxy.ListenerReusePort, "proxy-listener-reuse-port", false, "Set SO_REUSEPORT on the listeners and the HTTP listener, so multiple proxy processes can share the ports e.g. for CPU scaling and zero-downtime restarts")
+	Server.Flags().StringArrayVar(&c.Proxy.TransparentListeners, "proxy-transparent-listener", []string{}, "Listener address whose clients reach the advertised broker addresses directly e.g. 0.0.0.0:32401. The Metadata and FindCoordinator responses are not rewritten, the responses are relayed without copying them to user space when possible")
 
 	Server.Flags().BoolVar(&c.Proxy.TLS.Enable, "proxy-listener-tls-enable", false, "Whether or not to use TLS listener")
 	Server.Flags().StringVar(&c.Proxy.TLS.ListenerCertFile, "proxy-listener-cert-file", "", "PEM encoded file with server certificate")
 	Server.Flags().StringVar(&c.Proxy.TLS.ListenerKeyFile, "proxy-listener-key-file", "", "PEM encoded file with private key for the server certificate")
-	Server.Flags().StringVar(&c.Proxy.TLS.ListenerKeyPassword, "proxy-listener-key-password", "", "Password to decrypt rsa private key")
+	Server.Flags().Var(&c.Proxy.TLS.ListenerKeyPassword, "proxy-listener-key-password", "Password to decrypt rsa private key")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerKeySigner.Enable, "proxy-listener-key-signer-enable", false, "Sign with the private key of the listener certificate held by the key signer instead of the key file")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerKeySigner.Command, "proxy-listener-key-signer-command", "", "Name of the built-in key signer aws-kms or gcp-kms, or path to the key signer plugin binary e.g. a PKCS#11 HSM signer")
+	Server.Flags().StringArrayVar(&c.Proxy.TLS.ListenerKeySigner.Parameters, "proxy-listener-key-signer-param", []string{}, "Key signer parameter")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerKeySigner.LogLevel, "proxy-listener-key-signer-log-level", "trace", "Log level of the key signer plugin")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerKeySigner.Timeout, "proxy-listener-key-signer-timeout", 5*time.Second, "How long to wait for the signature of the key signer")
 	Server.Flags().StringVar(&c.Proxy.TLS.CAChainCertFile, "proxy-listener-ca-chain-cert-file", "", "PEM encoded CA's certificate file. If provided, client certificate is required and verified")
 	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCipherSuites, "proxy-listener-cipher-suites", []string{}, "List of supported cipher suites")
 	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCurvePreferences, "proxy-listener-curve-preferences", []string{}, "List of curve preferences")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerMinVersion, "proxy-listener-tls-min-version", "TLS1.2", "Minimum TLS version TLS1.0, TLS1.1, TLS1.2 or TLS1.3")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerMaxVersion, "proxy-listener-tls-max-version", "", "Maximum TLS version TLS1.0, TLS1.1, TLS1.2 or TLS1.3. If empty, the highest supported version")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerSessionTicketsDisable, "proxy-listener-session-tickets-disable", false, "Disable the TLS session resumption with session tickets")
+	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerCRLs, "proxy-listener-crl", []string{}, "List of PEM or DER encoded CRL files or http(s) URLs used to check the revocation of the client certificates")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerOCSPEnable, "proxy-listener-ocsp-enable", false, "Check the revocation of the client certificates with the OCSP responders of the certificates")
+	Server.Flags().BoolVar(&c.Proxy.TLS.ListenerALPN.Enable, "proxy-listener-alpn-enable", false, "Serve the HTTP endpoints on the TLS listeners to the clients negotiating http/1.1 or h2 with ALPN, the other clients are Kafka clients")
+	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerALPN.KafkaProtocols, "proxy-listener-alpn-kafka-protocols", []string{}, "List of ALPN protocols of the Kafka clients besides the HTTP protocols e.g. kafka. The clients without ALPN are always Kafka clients")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerALPN.HandshakeTimeout, "proxy-listener-alpn-handshake-timeout", 10*time.Second, "How long to wait for the TLS handshake which selects the Kafka or the HTTP protocol")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerVaultPKI.Path, "proxy-listener-vault-pki-path", "", "Vault PKI issue path e.g. pki/issue/kafka-proxy. If provided, the listener certificate is issued by Vault instead of the cert and key files and issued again before it expires")
+	Server.Flags().StringVar(&c.Proxy.TLS.ListenerVaultPKI.CommonName, "proxy-listener-vault-pki-common-name", "", "Common name of the listener certificate issued by Vault")
+	Server.Flags().StringSliceVar(&c.Proxy.TLS.ListenerVaultPKI.AltNames, "proxy-listener-vault-pki-alt-names", []string{}, "List of DNS and email subject alternative names of the listener certificate issued by Vault")
+	Server.Flags().DurationVar(&c.Proxy.TLS.ListenerVaultPKI.TTL, "proxy-listener-vault-pki-ttl", 0, "TTL of the listener certificate issued by Vault. If zero, the TTL of the role")
+
+	// frame filter
+	Server.Flags().BoolVar(&c.Proxy.Filter.Enable, "proxy-filter-enable", false, "Enable the built-in frame filter which observes or mutates requests and responses")
+	Server.Flags().StringVar(&c.Proxy.Filter.Name, "proxy-filter-name", "", "Name of the built-in frame filter: client-id or wasm")
+	Server.Flags().StringArrayVar(&c.Proxy.Filter.Parameters, "proxy-filter-param", []string{}, "Frame filter parameter")
+
+	// record headers
+	Server.Flags().StringArrayVar(&c.Proxy.RecordHeaders, "proxy-record-header", []string{}, "Header added to every produced record given as name=source. The source is principal, client-ip, client-id or proxy-instance-id. Headers with the same name sent by the client are removed")
+	Server.Flags().StringVar(&c.Proxy.InstanceID, "proxy-instance-id", "", "Id of the proxy instance used by the proxy-instance-id record header. If empty, the hostname is used")
+	Server.Flags().StringVar(&c.Proxy.TopicPrefix.Default, "topic-prefix", "", "Prefix added to the topic names in requests and removed in responses for all clients without a principal prefix e.g. tenant-a.")
+	Server.Flags().StringArrayVar(&c.Proxy.TopicPrefix.Principals, "topic-prefix-principal", []string{}, "Topic prefix of the principal authenticated by the local authentication given as principal=prefix. An empty prefix disables the default prefix for the principal")
+	Server.Flags().BoolVar(&c.Proxy.TopicPrefix.Groups, "topic-prefix-groups", false, "Add the topic prefix also to consumer group ids and transactional ids")
+	Server.Flags().BoolVar(&c.Proxy.ResponseCache.Enable, "response-cache-enable", false, "Cache the ApiVersions and Metadata responses of each broker and serve them to the clients without a broker round trip")
+	Server.Flags().DurationVar(&c.Proxy.ResponseCache.TTL, "response-cache-ttl", 1*time.Second, "How long the ApiVersions and Metadata responses are cached")
+	Server.Flags().BoolVar(&c.Proxy.BrokerMigration.Enable, "proxy-broker-migration-enable", false, "Move the clients of a broker whose address changed in the Metadata responses or in the server mapping file to the new address. The new connections to the old address are connected to the new address and the existing ones are closed when idle. The Metadata responses are buffered")
+	Server.Flags().DurationVar(&c.Proxy.BrokerMigration.IdleTimeout, "proxy-broker-migration-idle-timeout", 30*time.Second, "Idle time without requests and responses after which a connection to the old broker address is closed")
+	Server.Flags().DurationVar(&c.Proxy.BrokerMigration.GracePeriod, "proxy-broker-migration-grace-period", 0, "Time after the address change when the busy connections to the old broker address are closed. If 0, they are kept until idle")
+	Server.Flags().BoolVar(&c.Proxy.ReadOnly.Enable, "read-only-enable", false, "Reject Produce, the admin, ACL and config requests and the other requests changing the cluster with the authorization errors")
+	Server.Flags().BoolVar(&c.Proxy.ReadOnly.AllowOffsetCommit, "read-only-allow-offset-commit", false, "Allow the consumer groups to commit their offsets in the read-only mode")
+	Server.Flags().BoolVar(&c.Proxy.ClientTelemetry.Terminate, "client-telemetry-terminate", false, "Answer the client telemetry requests (KIP-714) in the proxy and export the pushed client metrics with the proxy metrics instead of forwarding them to the brokers")
+	Server.Flags().DurationVar(&c.Proxy.ClientTelemetry.PushInterval, "client-telemetry-push-interval", 1*time.Minute, "How often the clients push their metrics to the proxy")
+	Server.Flags().IntVar(&c.Proxy.ClientTelemetry.MaxBytes, "client-telemetry-max-bytes", 1024*1024, "Max size of the metrics pushed by a client")
+	Server.Flags().StringSliceVar(&c.Proxy.ClientTelemetry.Metrics, "client-telemetry-metrics", []string{}, "Prefixes of the client metric names requested from the clients e.g. org.apache.kafka.producer. If empty, all metrics are requested")
+	Server.Flags().DurationVar(&c.Proxy.SlowConsumer.Threshold, "proxy-slow-consumer-threshold", 0, "Time a write of a Fetch response to the client may block before the client is a slow consumer. If 0, the slow consumers are not detected")
+	Server.Flags().StringVar(&c.Proxy.SlowConsumer.Policy, "proxy-slow-consumer-policy", "log", "Policy applied to the slow consumers: log logs and counts them, throttle also delays their next Fetch request by the time the write blocked, disconnect closes their connections")
+	Server.Flags().Int64Var(&c.Proxy.ClientQuota.ProduceByteRate, "proxy-client-quota-produce-byte-rate", 0, "Produced bytes per second of a client id above which the Produce responses get a throttle time, so the client backs off. If 0, unlimited")
+	Server.Flags().Int64Var(&c.Proxy.ClientQuota.FetchByteRate, "proxy-client-quota-fetch-byte-rate", 0, "Fetched bytes per second of a client id above which the Fetch responses get a throttle time, so the client backs off. If 0, unlimited")
+	Server.Flags().Float64Var(&c.Proxy.ClientQuota.RequestRate, "proxy-client-quota-request-rate", 0, "Requests per second of a client id above which its responses get a throttle time, so the client backs off. If 0, unlimited")
+	Server.Flags().DurationVar(&c.Proxy.ClientQuota.Window, "proxy-client-quota-window", 10*time.Second, "Time window the rates of the client quotas are measured over")
+	Server.Flags().DurationVar(&c.Proxy.ClientQuota.MaxThrottle, "proxy-client-quota-max-throttle", 30*time.Second, "Max throttle time set by the client quotas. If 0, unlimited")
+	Server.Flags().StringVar(&c.Proxy.CompressionTranscoding.Produce, "proxy-produce-compression", "", "Compression of the record batches of the Produce requests sent to the brokers: none or gzip. The batches compressed with snappy, lz4 or zstd are sent unchanged. If empty, the batches are not transcoded")
+	Server.Flags().StringVar(&c.Proxy.CompressionTranscoding.Fetch, "proxy-fetch-compression", "", "Compression of the record batches of the Fetch responses sent to the clients: none or gzip. The batches compressed with snappy, lz4 or zstd are sent unchanged. If empty, the batches are not transcoded")
+	Server.Flags().StringArrayVar(&c.Proxy.OldClients.MinApiVersions, "proxy-min-api-version", []string{}, "Min version of the requests with the api key given as apiKey=version e.g. 0=3 and 1=4 for the message format of 0.11 or 18=1 for the clients sending only ApiVersions v0. The older requests are handled by the old clients policy")
+	Server.Flags().StringVar(&c.Proxy.OldClients.Policy, "proxy-old-clients-policy", "reject", "Policy applied to the requests older than the min api versions: reject answers them with the UNSUPPORTED_VERSION error and closes the connections of the requests without a response, log only logs the client id and counts them")
+	Server.Flags().Int64Var(&c.Proxy.MemoryBudget.MaxBytes, "proxy-memory-budget-max-bytes", 0, "Max bytes of the requests and responses buffered on all connections. While the budget is exhausted the sockets are not read. If 0, the bytes are not limited")
+	Server.Flags().BoolVar(&c.Proxy.MemoryBudget.RejectConnections, "proxy-memory-budget-reject-connections", false, "Close the new connections while the memory budget is exhausted")
+	Server.Flags().StringArrayVar(&c.Proxy.KillSwitch.ClientIDs, "proxy-kill-switch-client-id", []string{}, "Regexp matching the whole client id of the killed clients. Their connections are closed after the first request")
+	Server.Flags().StringArrayVar(&c.Proxy.KillSwitch.Principals, "proxy-kill-switch-principal", []string{}, "Regexp matching the whole principal authenticated by local SASL of the killed clients. Their connections are closed after the authentication")
+	Server.Flags().BoolVar(&c.Proxy.HotRestart.Enable, "proxy-hot-restart-enable", false, "On SIGUSR2 start a new process of the same binary with the same arguments, hand the listeners over to it and drain the connections of the old process")
+	Server.Flags().DurationVar(&c.Proxy.HotRestart.DrainTimeout, "proxy-hot-restart-drain-timeout", 5*time.Minute, "How long the old process drains its connections after a hot restart before closing them")
 
 	// local authentication plugin
 	Server.Flags().BoolVar(&c.Auth.Local.Enable, "auth-local-enable", false, "Enable local SASL/PLAIN authentication performed by listener - SASL handshake will not be passed to kafka brokers")
-	Server.Flags().StringVar(&c.Auth.Local.Command, "auth-local-command", "", "Path to authentication plugin binary")
+	Server.Flags().StringVar(&c.Auth.Local.Command, "auth-local-command", "", "Name of the built-in or in-process registered authentication plugin e.g. file-auth, or path to authentication plugin binary")
 	Server.Flags().StringArrayVar(&c.Auth.Local.Parameters, "auth-local-param", []string{}, "Authentication plugin parameter")
 	Server.Flags().StringVar(&c.Auth.Local.LogLevel, "auth-local-log-level", "trace", "Log level of the auth plugin")
 	Server.Flags().DurationVar(&c.Auth.Local.Timeout, "auth-local-timeout", 10*time.Second, "Authentication timeout")
+	Server.Flags().DurationVar(&c.Auth.Local.SessionLifetime, "auth-local-session-lifetime", 0, "Lifetime of the SASL session returned to the clients by SaslAuthenticate v1. The clients must re-authenticate before it expires, otherwise the connection is closed. If 0, the sessions do not expire")
+	Server.Flags().IntVar(&c.Auth.Local.ConnectionLimit.PerPrincipal, "auth-local-connection-limit", 0, "Maximum number of concurrent connections of a principal authenticated by local SASL. If 0, the connections are not limited")
+	Server.Flags().StringArrayVar(&c.Auth.Local.ConnectionLimit.Principals, "auth-local-connection-limit-principal", []string{}, "Maximum number of concurrent connections of the principal as principal=limit, it replaces auth-local-connection-limit")
+	Server.Flags().IntVar(&c.Auth.Local.ConnectionLimit.PerTenant, "auth-local-connection-limit-tenant", 0, "Maximum number of concurrent connections of the principals with the same topic prefix. If 0, the connections are not limited")
 
 	Server.Flags().BoolVar(&c.Auth.Gateway.Client.Enable, "auth-gateway-client-enable", false, "Enable gateway client authentication")
-	Server.Flags().StringVar(&c.Auth.Gateway.Client.Command, "auth-gateway-client-command", "", "Path to authentication plugin binary")
+	Server.Flags().StringVar(&c.Auth.Gateway.Client.Command, "auth-gateway-client-command", "", "Name of the built-in or in-process registered token provider e.g. google-id-provider, or path to authentication plugin binary")
 	Server.Flags().StringArrayVar(&c.Auth.Gateway.Client.Parameters, "auth-gateway-client-param", []string{}, "Authentication plugin parameter")
 	Server.Flags().StringVar(&c.Auth.Gateway.Client.LogLevel, "auth-gateway-client-log-level", "trace", "Log level of the auth plugin")
 	Server.Flags().StringVar(&c.Auth.Gateway.Client.Method, "auth-gateway-client-method", "", "Authentication method")
 	Server.Flags().Uint64Var(&c.Auth.Gateway.Client.Magic, "auth-gateway-client-magic", 0, "Magic bytes sent in the handshake")
 	Server.Flags().DurationVar(&c.Auth.Gateway.Client.Timeout, "auth-gateway-client-timeout", 10*time.Second, "Authentication timeout")
+	Server.Flags().BoolVar(&c.Auth.Gateway.Client.TokenCache.Enable, "auth-gateway-client-token-cache-enable", false, "Cache the authentication token for new broker connections and refresh it before it expires")
+	Server.Flags().DurationVar(&c.Auth.Gateway.Client.TokenCache.TTL, "auth-gateway-client-token-cache-ttl", 5*time.Minute, "Lifetime of the cached tokens which are not JWTs with the exp claim")
+	Server.Flags().DurationVar(&c.Auth.Gateway.Client.TokenCache.RefreshBefore, "auth-gateway-client-token-cache-refresh-before", 1*time.Minute, "How long before the expiry the cached token is refreshed in the background, up to a quarter more is added as jitter")
 
 	Server.Flags().BoolVar(&c.Auth.Gateway.Server.Enable, "auth-gateway-server-enable", false, "Enable proxy server authentication")
-	Server.Flags().StringVar(&c.Auth.Gateway.Server.Command, "auth-gateway-server-command", "", "Path to authentication plugin binary")
+	Server.Flags().StringVar(&c.Auth.Gateway.Server.Command, "auth-gateway-server-command", "", "Name of the built-in or in-process registered token info e.g. google-id-info, or path to authentication plugin binary")
 	Server.Flags().StringArrayVar(&c.Auth.Gateway.Server.Parameters, "auth-gateway-server-param", []string{}, "Authentication plugin parameter")
 	Server.Flags().StringVar(&c.Auth.Gateway.Server.LogLevel, "auth-gateway-server-log-level", "trace", "Log level of the auth plugin")
 	Server.Flags().StringVar(&c.Auth.Gateway.Server.Method, "auth-gateway-server-method", "", "Authentication method")
 	Server.Flags().Uint64Var(&c.Auth.Gateway.Server.Magic, "auth-gateway-server-magic", 0, "Magic bytes sent in the handshake")
 	Server.Flags().DurationVar(&c.Auth.Gateway.Server.Timeout, "auth-gateway-server-timeout", 10*time.Second, "Authentication timeout")
+	Server.Flags().BoolVar(&c.Auth.Gateway.Server.ExpiryEnable, "auth-gateway-server-expiry-enable", false, "Close the client connections when their token expires. The expiry is returned by the token info plugin or is the exp claim of a JWT, the connections of tokens without expiry are not closed")
+	Server.Flags().DurationVar(&c.Auth.Gateway.Server.ExpiryGracePeriod, "auth-gateway-server-expiry-grace-period", 0, "Time after the token expiry before the connection is closed")
+
+	// request authorization plugin
+	Server.Flags().BoolVar(&c.Auth.Authz.Enable, "auth-authz-enable", false, "Enable authorization of every client request by the authorization plugin")
+	Server.Flags().StringVar(&c.Auth.Authz.Command, "auth-authz-command", "", "Name of the in-process registered authorization plugin, or path to authorization plugin binary")
+	Server.Flags().StringArrayVar(&c.Auth.Authz.Parameters, "auth-authz-param", []string{}, "Authorization plugin parameter")
+	Server.Flags().StringVar(&c.Auth.Authz.LogLevel, "auth-authz-log-level", "trace", "Log level of the authorization plugin")
+	Server.Flags().DurationVar(&c.Auth.Authz.Timeout, "auth-authz-timeout", 5*time.Second, "Authorization timeout")
+
+	// per-listener auth
+	Server.Flags().StringArrayVar(&c.Auth.Listeners, "auth-listener-setting", []string{}, "Auth setting of the listener given as listener-address,setting=value, it replaces the global flag of the same name for the connections accepted by the listener. Supported are the auth-local, auth-gateway-client, auth-gateway-server and auth-authz enable and timeout flags, and the gateway method and magic flags e.g. 0.0.0.0:32401,auth-gateway-client-enable=true")
+
+	// role policies
+	Server.Flags().StringArrayVar(&c.Policy.Roles, "policy-role", []string{}, "Role given as role=api-key,api-key, the principals of the role are allowed only its api keys. The api keys are given by name or number, groups (consumer group APIs), transactions (transactional producer APIs) or * (all api keys) e.g. read-only=Fetch,Metadata,ListOffsets,groups")
+	Server.Flags().StringArrayVar(&c.Policy.Topics, "policy-role-topics", []string{}, "Topics of the role given as role=regexp, the regexp must match the whole topic name. The role without topics is allowed all topics")
+	Server.Flags().StringArrayVar(&c.Policy.Principals, "policy-principal", []string{}, "Role of the principal given as principal=role")
+	Server.Flags().StringVar(&c.Policy.DefaultRole, "policy-default-role", "", "Role of the principals without a role and of the unauthenticated clients. If empty, their requests are denied")
+	Server.Flags().BoolVar(&c.Policy.ClientCertPrincipal, "policy-client-cert-principal", false, "The common name of the verified client certificate is the principal of the connections without local SASL authentication")
+
+	// plugin supervision
+	Server.Flags().DurationVar(&c.Plugin.HealthCheckInterval, "plugin-health-check-interval", 10*time.Second, "How often the plugin subprocesses are checked, the plugin which exited or does not respond is restarted. If zero, the plugins are not checked nor restarted")
+	Server.Flags().DurationVar(&c.Plugin.RestartInitialBackoff, "plugin-restart-initial-backoff", time.Second, "How long to wait before the first restart of a failed plugin. The backoff is doubled for every failed restart")
+	Server.Flags().DurationVar(&c.Plugin.RestartMaxBackoff, "plugin-restart-max-backoff", time.Minute, "Maximal backoff between the restarts of a failed plugin")
 
 	// kafka
 	Server.Flags().StringVar(&c.Kafka.ClientID, "kafka-client-id", "kafka-proxy", "An optional identifier to track the source of requests")
+	Server.Flags().StringVar(&c.Kafka.ExpectedClusterID, "kafka-expected-cluster-id", "", "Cluster id of the brokers verified with a Metadata request after the broker connection is authenticated, the connections to the brokers of another cluster are closed. Disabled when empty")
 	Server.Flags().IntVar(&c.Kafka.MaxOpenRequests, "kafka-max-open-requests", 256, "Maximal number of open requests pro tcp connection before sending on it blocks")
+	Server.Flags().IntVar(&c.Kafka.MaxFetchResponseSize, "kafka-max-fetch-response-size", 0, "Max bytes of a Fetch response read from the brokers. The larger responses are discarded without buffering and the clients get the MESSAGE_TOO_LARGE error, e.g. the consumers with too high fetch.max.bytes. If 0, the Fetch responses are not limited")
 	Server.Flags().DurationVar(&c.Kafka.DialTimeout, "kafka-dial-timeout", 15*time.Second, "How long to wait for the initial connection")
 	Server.Flags().DurationVar(&c.Kafka.WriteTimeout, "kafka-write-timeout", 30*time.Second, "How long to wait for a transmit")
 	Server.Flags().DurationVar(&c.Kafka.ReadTimeout, "kafka-read-timeout", 30*time.Second, "How long to wait for a response")
+	Server.Flags().DurationVar(&c.Kafka.RequestTimeout, "kafka-request-timeout", 0, "How long to wait for the broker response before the request is answered with REQUEST_TIMED_OUT and the connection is kept. The broker wait time of the request e.g. the Fetch max wait time is added. If 0, the requests do not time out")
 	Server.Flags().DurationVar(&c.Kafka.KeepAlive, "kafka-keep-alive", 60*time.Second, "Keep alive period for an active network connection. If zero, keep-alives are disabled")
+	Server.Flags().DurationVar(&c.Kafka.KeepAliveInterval, "kafka-keep-alive-interval", 0, "Time between the unacknowledged keep-alive probes of the broker connections. If zero, system default is used")
+	Server.Flags().IntVar(&c.Kafka.KeepAliveCount, "kafka-keep-alive-count", 0, "Unacknowledged keep-alive probes before the broker connection is dropped. If zero, system default is used")
+	Server.Flags().BoolVar(&c.Kafka.NoDelay, "kafka-no-delay", true, "Set TCP_NODELAY on the broker connections. If false, the Nagle's algorithm delays the small writes")
+	Server.Flags().DurationVar(&c.Kafka.DialFallbackDelay, "kafka-dial-fallback-delay", 300*time.Millisecond, "How long to wait before trying the other address family when a broker has both IPv4 and IPv6 addresses (happy-eyeballs). If negative, dual-stack fallback is disabled")
+	Server.Flags().StringVar(&c.Kafka.DialSourceAddress, "kafka-dial-source-address", "", "Local IP or network interface name the broker connections are dialed from e.g. the allowlisted egress IP of a multi-homed host. The first IPv4 address of the interface is preferred. If empty, the system chooses it")
 	Server.Flags().IntVar(&c.Kafka.ConnectionReadBufferSize, "kafka-connection-read-buffer-size", 0, "Size of the operating system's receive buffer associated with the connection. If zero, system default is used")
+	Server.Flags().DurationVar(&c.Kafka.DNS.TTL, "kafka-dns-ttl", 0, "Fixed duration resolved broker addresses are cached before they are resolved again (record TTLs are not used). If zero, the broker host names are resolved by the dialer without caching")
+	Server.Flags().BoolVar(&c.Kafka.DNS.LookupOnDial, "kafka-dns-lookup-on-dial", false, "Resolve broker host names on every new connection. Cached addresses are used only when the lookup fails")
+	Server.Flags().DurationVar(&c.Kafka.DNS.MaxStale, "kafka-dns-max-stale", 5*time.Minute, "How long after expiry cached broker addresses are used when the lookup fails")
+	Server.Flags().IntVar(&c.Kafka.DialRetry.Retries, "kafka-dial-retries", 0, "How many times a failed connection to the broker is retried before the client connection is closed. If zero, the connections are not retried")
+	Server.Flags().DurationVar(&c.Kafka.DialRetry.InitialBackoff, "kafka-dial-retry-initial-backoff", 100*time.Millisecond, "How long to wait before the first retry of a failed broker connection. The backoff is doubled for every retry and jittered by +/-50%")
+	Server.Flags().DurationVar(&c.Kafka.DialRetry.MaxBackoff, "kafka-dial-retry-max-backoff", 2*time.Second, "Maximal backoff between the retries of a failed broker connection")
+	Server.Flags().BoolVar(&c.Kafka.CircuitBreaker.Enable, "kafka-circuit-breaker-enable", false, "Fail the connections to a broker fast after its dials failed repeatedly")
+	Server.Flags().IntVar(&c.Kafka.CircuitBreaker.FailureThreshold, "kafka-circuit-breaker-failure-threshold", 3, "Number of consecutive failed TCP dials or TLS handshakes which open the circuit of the broker")
+	Server.Flags().DurationVar(&c.Kafka.CircuitBreaker.Backoff, "kafka-circuit-breaker-backoff", 10*time.Second, "How long the connections to the broker fail fast before it is dialed again")
+	Server.Flags().IntVar(&c.Kafka.ConnectionPool.Size, "kafka-connection-pool-size", 0, "Number of the authenticated connections to each broker of the bootstrap server mapping established at the start and taken by the new client connections. If zero, the brokers are dialed for every client connection")
+	Server.Flags().DurationVar(&c.Kafka.ConnectionPool.PingInterval, "kafka-connection-pool-ping-interval", 30*time.Second, "How often the idle pooled connections are kept warm with ApiVersions requests")
 	Server.Flags().IntVar(&c.Kafka.ConnectionWriteBufferSize, "kafka-connection-write-buffer-size", 0, "Sets the size of the operating system's transmit buffer associated with the connection. If zero, system default is used")
 
+	// upstream clusters
+	Server.Flags().StringArrayVar(&c.Kafka.Clusters.Servers, "kafka-cluster", []string{}, "Additional upstream cluster given as name=host:port,host:port with its bootstrap servers. The cluster of the bootstrap-server-mapping is named default")
+	Server.Flags().StringArrayVar(&c.Kafka.Clusters.TopicRoutes, "kafka-cluster-topic-route", []string{}, "Route of the topics matching the regexp to the cluster given as name=regexp. The first matching route is used, other topics are routed to the default cluster")
+	Server.Flags().StringArrayVar(&c.Kafka.Clusters.GroupRoutes, "kafka-cluster-group-route", []string{}, "Route of the consumer groups and transactional ids matching the regexp to the cluster given as name=regexp. The first matching route is used, other groups are routed to the default cluster")
+	Server.Flags().StringArrayVar(&c.Kafka.Clusters.Settings, "kafka-cluster-setting", []string{}, "Upstream setting of the cluster given as name:setting=value, it replaces the global flag of the same name. Supported are kafka-dial-timeout, kafka-dial-source-address, kafka-expected-cluster-id, tls-*, sasl-*, forward-proxy, forward-proxy-socks5-resolve and forward-proxy-http-auth flags of the broker connections e.g. new:tls-ca-chain-cert-file=/etc/new-ca.pem")
+
+	// shadow cluster
+	Server.Flags().StringVar(&c.Kafka.Shadow.Cluster, "kafka-shadow-cluster", "", "Shadow cluster given as name=host:port,host:port receiving the copies of the Produce requests. The copies are sent asynchronously and best-effort, the clients receive only the responses of the primary cluster. Its upstream settings are given by kafka-cluster-setting")
+	Server.Flags().StringArrayVar(&c.Kafka.Shadow.Topics, "kafka-shadow-topic", []string{}, "Regexp of the topics shadowed to the shadow cluster. All topics are shadowed when not set")
+	Server.Flags().IntVar(&c.Kafka.Shadow.QueueSize, "kafka-shadow-queue-size", 1000, "Maximum number of the Produce requests waiting to be sent to the shadow cluster, further requests are not shadowed")
+	// canary cluster
+	Server.Flags().StringVar(&c.Kafka.Canary.Cluster, "kafka-canary-cluster", "", "Canary cluster given as name=host:port,host:port whose Metadata and ListOffsets responses are compared with the primary cluster. The requests are repeated asynchronously, the differences are logged and counted. Its upstream settings are given by kafka-cluster-setting")
+	Server.Flags().Float64Var(&c.Kafka.Canary.SampleRate, "kafka-canary-sample-rate", 1, "Fraction of the Metadata and ListOffsets requests repeated to the canary cluster")
+	Server.Flags().IntVar(&c.Kafka.Canary.QueueSize, "kafka-canary-queue-size", 1000, "Maximum number of the responses waiting to be compared with the canary cluster, further responses are not compared")
+
 	// http://kafka.apache.org/protocol.html#protocol_api_keys
 	Server.Flags().IntSliceVar(&c.Kafka.ForbiddenApiKeys, "forbidden-api-keys", []int{}, "Forbidden Kafka request types. The restriction should prevent some Kafka operations e.g. 20 - DeleteTopics")
 
@@ -139,31 +319,205 @@ func initFlags() {
 	Server.Flags().BoolVar(&c.Kafka.TLS.InsecureSkipVerify, "tls-insecure-skip-verify", false, "It controls whether a client verifies the server's certificate chain and host name")
 	Server.Flags().StringVar(&c.Kafka.TLS.ClientCertFile, "tls-client-cert-file", "", "PEM encoded file with client certificate")
 	Server.Flags().StringVar(&c.Kafka.TLS.ClientKeyFile, "tls-client-key-file", "", "PEM encoded file with private key for the client certificate")
-	Server.Flags().StringVar(&c.Kafka.TLS.ClientKeyPassword, "tls-client-key-password", "", "Password to decrypt rsa private key")
+	Server.Flags().Var(&c.Kafka.TLS.ClientKeyPassword, "tls-client-key-password", "Password to decrypt rsa private key")
 	Server.Flags().StringVar(&c.Kafka.TLS.CAChainCertFile, "tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.CipherSuites, "tls-cipher-suites", []string{}, "List of supported cipher suites. If empty, the Go defaults")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.CurvePreferences, "tls-curve-preferences", []string{}, "List of curve preferences. If empty, the Go defaults")
+	Server.Flags().StringVar(&c.Kafka.TLS.MinVersion, "tls-min-version", "", "Minimum TLS version TLS1.0, TLS1.1, TLS1.2 or TLS1.3. If empty, the Go default")
+	Server.Flags().StringVar(&c.Kafka.TLS.MaxVersion, "tls-max-version", "", "Maximum TLS version TLS1.0, TLS1.1, TLS1.2 or TLS1.3. If empty, the highest supported version")
+	Server.Flags().BoolVar(&c.Kafka.TLS.SessionTicketsDisable, "tls-session-tickets-disable", false, "Disable the TLS session resumption with session tickets")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.PinnedSPKIHashes, "tls-pinned-spki-sha256", []string{}, "List of base64 encoded SHA-256 hashes of the subject public key info, a certificate of the broker chain must match. Verified in addition to the CA unless the verification is skipped")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.CRLs, "tls-crl", []string{}, "List of PEM or DER encoded CRL files or http(s) URLs used to check the revocation of the broker certificates")
+	Server.Flags().BoolVar(&c.Kafka.TLS.OCSPEnable, "tls-ocsp-enable", false, "Check the revocation of the broker certificates with the stapled OCSP response or the OCSP responders of the certificates")
+	Server.Flags().StringVar(&c.Kafka.TLS.ClientVaultPKI.Path, "tls-client-vault-pki-path", "", "Vault PKI issue path e.g. pki/issue/kafka-proxy. If provided, the client certificate is issued by Vault instead of the cert and key files and issued again before it expires")
+	Server.Flags().StringVar(&c.Kafka.TLS.ClientVaultPKI.CommonName, "tls-client-vault-pki-common-name", "", "Common name of the client certificate issued by Vault")
+	Server.Flags().StringSliceVar(&c.Kafka.TLS.ClientVaultPKI.AltNames, "tls-client-vault-pki-alt-names", []string{}, "List of DNS and email subject alternative names of the client certificate issued by Vault")
+	Server.Flags().DurationVar(&c.Kafka.TLS.ClientVaultPKI.TTL, "tls-client-vault-pki-ttl", 0, "TTL of the client certificate issued by Vault. If zero, the TTL of the role")
+
+	// Revocation of the peer certificates
+	Server.Flags().DurationVar(&c.Revocation.CRLRefreshInterval, "revocation-crl-refresh-interval", time.Hour, "How often the CRLs are reloaded, a CRL is reloaded earlier when its next update is due")
+	Server.Flags().DurationVar(&c.Revocation.Timeout, "revocation-timeout", 5*time.Second, "Timeout of the CRL downloads and the OCSP requests")
+	Server.Flags().BoolVar(&c.Revocation.HardFail, "revocation-hard-fail", false, "Reject the certificates whose revocation status is unknown e.g. the OCSP responder is unreachable")
+
+	// Vault
+	Server.Flags().StringVar(&c.Vault.Address, "vault-address", "", "Address of the Vault server used to fetch the certificates and the SASL credentials. If empty, VAULT_ADDR")
+	Server.Flags().Var(&c.Vault.Token, "vault-token", "Vault token. If empty, VAULT_TOKEN")
+	Server.Flags().StringVar(&c.Vault.TokenFile, "vault-token-file", "", "File with the Vault token e.g. the token sink of the Vault agent. The file is read for every request")
+	Server.Flags().StringVar(&c.Vault.CACertFile, "vault-ca-cert-file", "", "PEM encoded CA's certificate file used to verify the Vault server")
+	Server.Flags().DurationVar(&c.Vault.Timeout, "vault-timeout", 10*time.Second, "Timeout of the Vault requests")
+	Server.Flags().DurationVar(&c.Vault.RefreshInterval, "vault-refresh-interval", 5*time.Minute, "How often the secrets without a lease e.g. KV secrets are fetched. The secrets with a lease and the certificates are fetched after two thirds of their lifetime")
+
+	// Cloud secret managers
+	Server.Flags().DurationVar(&c.Secrets.RefreshInterval, "secrets-refresh-interval", 5*time.Minute, "How often the SASL credentials given as secret references are resolved again to pick up the rotated secrets")
+	Server.Flags().DurationVar(&c.Secrets.Timeout, "secrets-timeout", 10*time.Second, "Timeout of the secret manager requests")
+
+	// Rotation of the mounted credentials
+	Server.Flags().BoolVar(&c.CredentialsWatch.Enable, "credentials-watch-enable", false, "Watch the listener and broker certificate, key and CA files and the SASL JAAS config file e.g. the mounted Kubernetes Secrets. The changed files are used by the new connections, the broker connections with SASL re-authentication re-authenticate with the changed credentials")
 
 	// SASL
-	Server.Flags().BoolVar(&c.Kafka.SASL.Enable, "sasl-enable", false, "Connect using SASL/PLAIN")
+	Server.Flags().BoolVar(&c.Kafka.SASL.Enable, "sasl-enable", false, "Connect using SASL")
+	Server.Flags().StringVar(&c.Kafka.SASL.Mechanism, "sasl-mechanism", "PLAIN", "SASL mechanism: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
 	Server.Flags().StringVar(&c.Kafka.SASL.Username, "sasl-username", "", "SASL user name")
-	Server.Flags().StringVar(&c.Kafka.SASL.Password, "sasl-password", "", "SASL user password")
+	Server.Flags().Var(&c.Kafka.SASL.Password, "sasl-password", "SASL user password")
+	Server.Flags().StringVar(&c.Kafka.SASL.VaultPath, "sasl-vault-path", "", "Vault KV (version 1 or 2) or database secrets path with the SASL username and password e.g. secret/data/kafka or database/creds/kafka. The credentials are fetched again before the lease expires")
 	Server.Flags().StringVar(&c.Kafka.SASL.JaasConfigFile, "sasl-jaas-config-file", "", "Location of JAAS config file with SASL username and password")
+	Server.Flags().BoolVar(&c.Kafka.SASL.TokenAuth, "sasl-token-auth", false, "SASL username and password are the id and the HMAC of a delegation token, requires a SCRAM mechanism. Enabled by tokenauth=\"true\" in the JAAS config file")
+	Server.Flags().BoolVar(&c.Kafka.SASL.Reauthentication, "sasl-reauthentication-enable", false, "Send the SASL tokens in SaslAuthenticate requests (Kafka 1.0+) and re-authenticate the connections before the session lifetime returned by the broker expires")
+	Server.Flags().BoolVar(&c.Kafka.SASL.DelegationToken.Enable, "sasl-delegation-token-enable", false, "Obtain a delegation token with the SASL credentials and authenticate the broker connections with the token")
+	Server.Flags().StringVar(&c.Kafka.SASL.DelegationToken.Mechanism, "sasl-delegation-token-mechanism", "SCRAM-SHA-256", "SCRAM mechanism of the delegation token authentication")
+	Server.Flags().DurationVar(&c.Kafka.SASL.DelegationToken.MaxLifetime, "sasl-delegation-token-max-lifetime", 0, "Max lifetime of the delegation token. If zero, the broker default is used")
+	Server.Flags().DurationVar(&c.Kafka.SASL.DelegationToken.RenewInterval, "sasl-delegation-token-renew-interval", 1*time.Hour, "How often the delegation token is renewed")
 
 	// Web
 	Server.Flags().BoolVar(&c.Http.Disable, "http-disable", false, "Disable HTTP endpoints")
 	Server.Flags().StringVar(&c.Http.ListenAddress, "http-listen-address", "0.0.0.0:9080", "Address that kafka-proxy is listening on")
+	Server.Flags().StringVar(&c.Http.UnixSocket, "http-unix-socket", "", "Unix socket on which the HTTP endpoints are served in addition to the listen address e.g. for the healthcheck command. A stale socket file is removed")
 	Server.Flags().StringVar(&c.Http.MetricsPath, "http-metrics-path", "/metrics", "Path on which to expose metrics")
+	Server.Flags().StringSliceVar(&c.Http.MetricsLabels, "http-metrics-labels", []string{"broker", "api_key", "api_version"}, "Labels of the connection and request metrics: "+strings.Join(config.MetricsLabels, ", "))
+	Server.Flags().BoolVar(&c.Http.MetricsInstanceLabel, "http-metrics-instance-label", false, "Add the proxy_instance label with the proxy-instance-id to all metrics. If the proxy-instance-id is empty, the hostname and the process id are used")
+	Server.Flags().BoolVar(&c.Http.MetricsResponseErrors, "http-metrics-response-errors", false, "Count the error codes of the Produce, Fetch, ListOffsets, Metadata, offset and group API responses. The decoded responses are buffered")
+	Server.Flags().BoolVar(&c.Http.MetricsTopics.Enable, "http-metrics-topics-enable", false, "Count the bytes and the records per topic of the Produce requests and the Fetch responses. The requests and responses are buffered")
+	Server.Flags().StringArrayVar(&c.Http.MetricsTopics.Topics, "http-metrics-topic", []string{}, "Topic with the throughput metrics, the other topics are counted as <other>. The topic ending with * is a prefix. If not set, all topics are counted")
 	Server.Flags().StringVar(&c.Http.HealthPath, "http-health-path", "/health", "Path on which to health endpoint")
+	Server.Flags().BoolVar(&c.Http.Drain.Enable, "http-drain-enable", false, "Enable the HTTP drain endpoint which rejects the new connections to a broker taken down for maintenance and optionally closes the existing ones after a grace period")
+	Server.Flags().StringVar(&c.Http.Drain.Path, "http-drain-path", "/drain", "Path of the HTTP drain endpoint: GET returns the drained brokers, POST with the JSON {\"broker\":\"host:port\",\"grace_period\":\"30s\"} drains and DELETE with the query parameter broker=host:port resumes the broker")
+	Server.Flags().BoolVar(&c.Http.Maintenance.Enable, "http-maintenance-enable", false, "Enable the HTTP maintenance endpoint. In maintenance mode the first request of a new connection is answered with the BROKER_NOT_AVAILABLE error and the connection is closed, the existing connections drain")
+	Server.Flags().StringVar(&c.Http.Maintenance.Path, "http-maintenance-path", "/maintenance", "Path of the HTTP maintenance endpoint: GET returns the maintenance mode, POST with the optional JSON {\"grace_period\":\"5m\"} enters it, the existing connections are closed after the grace period, and DELETE leaves it")
+	Server.Flags().DurationVar(&c.Http.Maintenance.Timeout, "http-maintenance-timeout", 10*time.Second, "Time to wait for the first request of a connection rejected in maintenance mode")
+	Server.Flags().BoolVar(&c.Http.Connections.Enable, "http-connections-enable", false, "Enable the HTTP connections endpoint which lists the client connections with their broker, listener, principal, client id, start and bytes")
+	Server.Flags().StringVar(&c.Http.Connections.Path, "http-connections-path", "/connections", "Path of the HTTP connections endpoint: GET returns the connections selected by the optional query parameters broker, listener, client, principal, client_id and older_than e.g. ?principal=alice&older_than=1h")
+	Server.Flags().BoolVar(&c.Http.PartitionLeaders.Enable, "http-partition-leaders-enable", false, "Enable the HTTP partition leaders endpoint and metrics which track the partition leaders of the Metadata responses and the listeners the clients are routed to. The responses are buffered")
+	Server.Flags().StringVar(&c.Http.PartitionLeaders.Path, "http-partition-leaders-path", "/partition-leaders", "Path of the HTTP partition leaders endpoint: GET returns the partitions led by the brokers and the leaders selected by the optional query parameters topic (ending with * is a prefix) and listener e.g. ?topic=orders-*")
+	Server.Flags().BoolVar(&c.Http.ClientInventory.Enable, "http-client-inventory-enable", false, "Enable the HTTP client inventory endpoint and metrics which aggregate the client ids, the principals and the client software named by the ApiVersions requests v3+ of the first requests of the connections")
+	Server.Flags().StringVar(&c.Http.ClientInventory.Path, "http-client-inventory-path", "/client-inventory", "Path of the HTTP client inventory endpoint: GET returns the clients selected by the optional query parameter software_name e.g. ?software_name=apache-kafka-java, DELETE forgets them")
+	Server.Flags().BoolVar(&c.Http.KillSwitch.Enable, "http-kill-switch-enable", false, "Enable the HTTP kill switch endpoint which changes the client id and principal patterns of the killed clients at runtime")
+	Server.Flags().StringVar(&c.Http.KillSwitch.Path, "http-kill-switch-path", "/kill-switch", "Path of the HTTP kill switch endpoint: GET returns the patterns, POST or PUT with the JSON {\"client_ids\":[\"batch-.*\"],\"principals\":[\"alice\"]} adds the patterns and closes the matching connections, DELETE with the JSON removes the patterns")
+	Server.Flags().BoolVar(&c.Http.Tracing.Enable, "http-tracing-enable", false, "Enable the HTTP tracing endpoint which logs the request and response pairs of the selected client connections with the correlation id, api key, version, sizes and time")
+	Server.Flags().StringVar(&c.Http.Tracing.Path, "http-tracing-path", "/tracing", "Path of the HTTP tracing endpoint: GET returns the traced connections, POST or PUT traces the connections selected by the query parameters of the connections endpoint for the optional duration (default 10m) e.g. ?client_id=orders-app&duration=5m, DELETE stops the tracing")
+	Server.Flags().BoolVar(&c.Http.Tuning.Enable, "http-tuning-enable", false, "Enable the HTTP tuning endpoint which changes the max open requests and the request and response buffer sizes of a live proxy")
+	Server.Flags().StringVar(&c.Http.Tuning.Path, "http-tuning-path", "/tuning", "Path of the HTTP tuning endpoint: GET returns the settings, POST or PUT with the JSON {\"max_open_requests\":512,\"request_buffer_size\":8192,\"response_buffer_size\":8192} changes the given settings. The buffer sizes apply to the next requests and responses, the max open requests to the new connections")
+
+	// StatsD
+	Server.Flags().BoolVar(&c.Statsd.Enable, "statsd-enable", false, "Push the metrics to a StatsD or DogStatsD endpoint")
+	Server.Flags().StringVar(&c.Statsd.Address, "statsd-address", "127.0.0.1:8125", "UDP address of the StatsD endpoint")
+	Server.Flags().StringVar(&c.Statsd.Format, "statsd-format", "statsd", "StatsD line format statsd or dogstatsd. The labels are appended to the metric name (statsd) or sent as tags (dogstatsd)")
+	Server.Flags().StringVar(&c.Statsd.Prefix, "statsd-prefix", "kafka_proxy.", "Prefix of the StatsD metric names")
+	Server.Flags().DurationVar(&c.Statsd.Interval, "statsd-interval", 10*time.Second, "How often the metrics are pushed")
+	Server.Flags().StringArrayVar(&c.Statsd.Tags, "statsd-tag", []string{}, "DogStatsD tag added to all metrics e.g. env:prod")
+
+	// OpenTelemetry
+	Server.Flags().BoolVar(&c.Otlp.Enable, "otlp-enable", false, "Push the metrics to an OpenTelemetry collector over OTLP/gRPC")
+	Server.Flags().StringVar(&c.Otlp.Endpoint, "otlp-endpoint", "localhost:4317", "Address of the OTLP/gRPC endpoint")
+	Server.Flags().BoolVar(&c.Otlp.Insecure, "otlp-insecure", false, "Connect to the OTLP endpoint without TLS")
+	Server.Flags().StringVar(&c.Otlp.CAChainCertFile, "otlp-ca-chain-cert-file", "", "PEM encoded CA's certificate file. If not set, the system CAs are used")
+	Server.Flags().StringArrayVar(&c.Otlp.Headers, "otlp-header", []string{}, "Header sent with the metrics e.g. api-key=secret")
+	Server.Flags().StringVar(&c.Otlp.ServiceName, "otlp-service-name", "kafka-proxy", "Value of the service.name resource attribute")
+	Server.Flags().DurationVar(&c.Otlp.Interval, "otlp-interval", 60*time.Second, "How often the metrics are pushed")
+	Server.Flags().DurationVar(&c.Otlp.Timeout, "otlp-timeout", 10*time.Second, "Timeout of the metrics push")
+
+	// events
+	Server.Flags().StringVar(&c.Events.Webhook.Url, "events-webhook-url", "", "URL of the webhook receiving the events of the proxy (connection_opened, connection_closed, auth_success, auth_failure, request_denied, broker_unreachable, config_reloaded) as JSON arrays")
+	Server.Flags().StringArrayVar(&c.Events.Webhook.Headers, "events-webhook-header", []string{}, "Header sent with the events e.g. Authorization=Bearer token")
+	Server.Flags().IntVar(&c.Events.Webhook.BatchSize, "events-webhook-batch-size", 100, "Maximum number of the events sent in one request")
+	Server.Flags().DurationVar(&c.Events.Webhook.FlushInterval, "events-webhook-flush-interval", 5*time.Second, "How often the queued events are sent when the batch is not full")
+	Server.Flags().DurationVar(&c.Events.Webhook.Timeout, "events-webhook-timeout", 10*time.Second, "Timeout of the webhook request")
+	Server.Flags().IntVar(&c.Events.Webhook.Retries, "events-webhook-retries", 3, "Number of the retries of the failed webhook request, the events are dropped after the last retry")
+	Server.Flags().DurationVar(&c.Events.Webhook.RetryBackoff, "events-webhook-retry-backoff", 1*time.Second, "Backoff before the first retry, it is doubled after each retry")
+	Server.Flags().IntVar(&c.Events.Webhook.QueueSize, "events-webhook-queue-size", 10000, "Maximum number of the events waiting to be sent, further events are dropped")
+	Server.Flags().StringVar(&c.Events.Audit.Topic, "events-audit-topic", "", "Topic of the default cluster receiving the audit events (auth_success, auth_failure, request_denied, connection_opened, connection_closed) as JSON records. The records are produced with the broker connection settings of the proxy")
+	Server.Flags().IntVar(&c.Events.Audit.BatchSize, "events-audit-batch-size", 100, "Maximum number of the audit events produced in one request")
+	Server.Flags().DurationVar(&c.Events.Audit.FlushInterval, "events-audit-flush-interval", 1*time.Second, "How often the queued audit events are produced when the batch is not full")
+	Server.Flags().IntVar(&c.Events.Audit.QueueSize, "events-audit-queue-size", 10000, "Maximum number of the audit events waiting to be produced, further events are dropped")
+	Server.Flags().Float64Var(&c.Events.RequestMirror.SampleRate, "events-request-mirror-sample-rate", 0, "Fraction of the requests whose decoded metadata (api key, version, client id, principal, topics, size) is mirrored to the file, topic or URL for the traffic analysis e.g. 0.01. The payloads are not mirrored")
+	Server.Flags().StringVar(&c.Events.RequestMirror.File, "events-request-mirror-file", "", "File the request samples are appended to as JSON lines")
+	Server.Flags().StringVar(&c.Events.RequestMirror.Topic, "events-request-mirror-topic", "", "Topic of the default cluster receiving the request samples as JSON records. The records are produced with the broker connection settings of the proxy")
+	Server.Flags().StringVar(&c.Events.RequestMirror.Url, "events-request-mirror-url", "", "HTTP endpoint the request samples are posted to as JSON arrays")
+	Server.Flags().IntVar(&c.Events.RequestMirror.BatchSize, "events-request-mirror-batch-size", 100, "Maximum number of the request samples written at once")
+	Server.Flags().DurationVar(&c.Events.RequestMirror.FlushInterval, "events-request-mirror-flush-interval", 1*time.Second, "How often the queued request samples are written when the batch is not full")
+	Server.Flags().DurationVar(&c.Events.RequestMirror.Timeout, "events-request-mirror-timeout", 10*time.Second, "Timeout of the posts of the request samples to the URL")
+	Server.Flags().IntVar(&c.Events.RequestMirror.QueueSize, "events-request-mirror-queue-size", 10000, "Maximum number of the request samples waiting to be written, further samples are dropped")
+
+	// systemd
+	Server.Flags().StringVar(&c.Kubernetes.Service, "kubernetes-service", "", "LoadBalancer or NodePort service of the proxy running in Kubernetes. If provided, its external address is discovered by the API server and advertised instead of the mapped addresses")
+	Server.Flags().StringVar(&c.Kubernetes.Namespace, "kubernetes-namespace", "", "Namespace of the Kubernetes service. If empty, the namespace of the pod")
+	Server.Flags().DurationVar(&c.Kubernetes.Timeout, "kubernetes-timeout", 2*time.Minute, "How long to wait for the external address of the Kubernetes service e.g. of the provisioned load balancer")
+	Server.Flags().BoolVar(&c.Systemd.SocketActivation, "systemd-socket-activation", false, "Use the listening sockets passed by systemd socket activation for the listeners with the matching addresses. The other listeners are opened by the proxy")
+	Server.Flags().BoolVar(&c.Systemd.Notify, "systemd-notify", false, "Notify systemd (Type=notify) when all listeners and plugins are up and send the watchdog keepalives when WatchdogSec is set")
 
 	// Debug
 	Server.Flags().BoolVar(&c.Debug.Enabled, "debug-enable", false, "Enable Debug endpoint")
 	Server.Flags().StringVar(&c.Debug.ListenAddress, "debug-listen-address", "0.0.0.0:6060", "Debug listen address")
+	Server.Flags().StringVar(&c.Debug.Capture.Dir, "debug-capture-dir", "", "Directory of the pcap files of the captured Kafka frames. The capture is enabled and disabled at runtime by the HTTP capture endpoint")
+	Server.Flags().BoolVar(&c.Debug.Faults.Enable, "debug-faults-enable", false, "Enable the HTTP faults endpoint which injects latency, dropped responses, error responses and disconnects into the requests to test the client retries")
+	Server.Flags().StringVar(&c.Debug.Faults.Path, "debug-faults-path", "/faults", "Path of the HTTP faults endpoint: GET returns the faults, POST with the JSON {\"api_keys\":[0],\"clients\":[\"ip\"],\"brokers\":[\"host:port\"],\"latency\":\"200ms\",\"drop_probability\":0.1,\"error_code\":7,\"error_probability\":0.1,\"disconnect_probability\":0.01} enables and DELETE disables them")
+	Server.Flags().StringVar(&c.Debug.Capture.Path, "debug-capture-path", "/capture", "Path of the HTTP capture endpoint: GET returns the capture status, POST with the JSON filter {\"api_keys\":[0,1],\"clients\":[\"ip\"],\"brokers\":[\"host:port\"]} enables and DELETE disables the capture")
 
 	// Logging
 	Server.Flags().StringVar(&c.Log.Format, "log-format", "text", "Log format text or json")
 	Server.Flags().StringVar(&c.Log.Level, "log-level", "info", "Log level debug, info, warning, error, fatal or panic")
+	Server.Flags().StringVar(&c.Log.Backend, "log-backend", "logrus", "Logger of the proxy logrus or slog. The slog logger uses the log format and the log level")
+	Server.Flags().Float64Var(&c.Log.RequestSampleRate, "request-log-sample-rate", 0, "Fraction of the requests whose decoded headers (api key, version, correlation id, client id, size) are logged e.g. 0.001")
 
 	// Connect through Socks5 or HTTP CONNECT to Kafka
-	Server.Flags().StringVar(&c.ForwardProxy.Url, "forward-proxy", "", "URL of the forward proxy. Supported schemas are socks5 and http")
+	Server.Flags().StringVar(&c.ForwardProxy.Url, "forward-proxy", "", "URL of the forward proxy. Supported schemas are socks5, http, https and ssh. Multiple comma separated URLs are used for failover in order of preference")
+	Server.Flags().DurationVar(&c.ForwardProxy.HealthCheckInterval, "forward-proxy-health-check-interval", 10*time.Second, "How often forward proxies are checked when multiple forward proxies are provided")
+	Server.Flags().DurationVar(&c.ForwardProxy.HealthCheckTimeout, "forward-proxy-health-check-timeout", 3*time.Second, "How long to wait for the forward proxy health check connection")
+	Server.Flags().BoolVar(&c.ForwardProxy.TLS.InsecureSkipVerify, "forward-proxy-tls-insecure-skip-verify", false, "It controls whether a client verifies the HTTPS forward proxy's certificate chain and host name")
+	Server.Flags().StringVar(&c.ForwardProxy.TLS.ClientCertFile, "forward-proxy-tls-client-cert-file", "", "PEM encoded file with client certificate presented to the HTTPS forward proxy")
+	Server.Flags().StringVar(&c.ForwardProxy.TLS.ClientKeyFile, "forward-proxy-tls-client-key-file", "", "PEM encoded file with private key for the HTTPS forward proxy client certificate")
+	Server.Flags().Var(&c.ForwardProxy.TLS.ClientKeyPassword, "forward-proxy-tls-client-key-password", "Password to decrypt rsa private key")
+	Server.Flags().StringVar(&c.ForwardProxy.TLS.CAChainCertFile, "forward-proxy-tls-ca-chain-cert-file", "", "PEM encoded CA's certificate file used to verify the HTTPS forward proxy")
+	Server.Flags().StringVar(&c.ForwardProxy.SSH.PrivateKeyFile, "forward-proxy-ssh-private-key-file", "", "PEM encoded file with private key used for the SSH tunnel authentication")
+	Server.Flags().Var(&c.ForwardProxy.SSH.PrivateKeyPassword, "forward-proxy-ssh-private-key-password", "Passphrase to decrypt the SSH private key")
+	Server.Flags().BoolVar(&c.ForwardProxy.SSH.AgentEnable, "forward-proxy-ssh-agent-enable", false, "Authenticate the SSH tunnel with keys provided by the ssh-agent (SSH_AUTH_SOCK)")
+	Server.Flags().StringVar(&c.ForwardProxy.SSH.KnownHostsFile, "forward-proxy-ssh-known-hosts-file", "", "Location of the known_hosts file used to verify the SSH server host key")
+	Server.Flags().BoolVar(&c.ForwardProxy.SSH.InsecureIgnoreHostKey, "forward-proxy-ssh-insecure-ignore-host-key", false, "Do not verify the SSH server host key")
+	Server.Flags().StringVar(&c.ForwardProxy.SOCKS5.Resolve, "forward-proxy-socks5-resolve", "remote", "Where the broker host names are resolved when connecting through the SOCKS5 forward proxy: remote sends them to the SOCKS5 server (SOCKS5h), e.g. for the internal DNS names only the proxy network can resolve, local resolves them on this host and sends the IP")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Auth, "forward-proxy-http-auth", "basic", "Authentication of the CONNECT requests to the HTTP(S) forward proxy: basic, ntlm or negotiate. The NTLM user can be given as DOMAIN\\user, negotiate sends the SPNEGO token with the Kerberos service ticket of the proxy and falls back to NTLM with the URL credentials when Kerberos fails")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Kerberos.ConfigFile, "forward-proxy-http-kerberos-config-file", "/etc/krb5.conf", "Kerberos config with the realms and their KDCs used by the negotiate authentication to the HTTP(S) forward proxy")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Kerberos.Realm, "forward-proxy-http-kerberos-realm", "", "Kerberos realm of the forward proxy URL user given without @REALM. If empty, the default realm of the Kerberos config")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Kerberos.KeytabFile, "forward-proxy-http-kerberos-keytab-file", "", "Keytab of the forward proxy URL user. If empty, the Kerberos credentials cache or the URL password is used")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Kerberos.CCacheFile, "forward-proxy-http-kerberos-ccache-file", "", "Kerberos credentials cache with the TGT of the user e.g. obtained by kinit")
+	Server.Flags().StringVar(&c.ForwardProxy.HTTP.Kerberos.ServicePrincipal, "forward-proxy-http-kerberos-service-principal", "", "Kerberos service principal name of the forward proxy. If empty, HTTP/<proxy host>")
+	Server.Flags().DurationVar(&c.ForwardProxy.SSH.KeepAliveInterval, "forward-proxy-ssh-keep-alive-interval", 30*time.Second, "How often keep-alive requests are sent to the SSH server. The tunnel is re-established when a request is not answered within the interval. If zero, keep-alives are disabled")
+
+	// tunnel
+	Server.Flags().StringVar(&c.Tunnel.Client.Address, "tunnel-client-address", "", "Address of the tunnel server. The broker connections are multiplexed over the mutually authenticated TLS connections to the tunnel server, which dials the brokers")
+	Server.Flags().IntVar(&c.Tunnel.Client.Connections, "tunnel-client-connections", 2, "Number of the TLS connections to the tunnel server")
+	Server.Flags().StringVar(&c.Tunnel.Client.CertFile, "tunnel-client-cert-file", "", "PEM encoded file with client certificate presented to the tunnel server")
+	Server.Flags().StringVar(&c.Tunnel.Client.KeyFile, "tunnel-client-key-file", "", "PEM encoded file with private key for the tunnel client certificate")
+	Server.Flags().Var(&c.Tunnel.Client.KeyPassword, "tunnel-client-key-password", "Password to decrypt rsa private key")
+	Server.Flags().StringVar(&c.Tunnel.Client.CAChainCertFile, "tunnel-client-ca-chain-cert-file", "", "PEM encoded CA's certificate file used to verify the tunnel server")
+	Server.Flags().StringVar(&c.Tunnel.Client.Transport, "tunnel-client-transport", "tls", "Transport of the tunnel connections: tls, websocket or quic. The websocket transport passes the middleboxes permitting only HTTPS, the tunnel server is dialed through the forward proxy when it is set. The quic transport dials the UDP port of the tunnel server and falls back to tls when the QUIC connection cannot be established")
+	Server.Flags().StringVar(&c.Tunnel.Client.Compression, "tunnel-client-compression", "none", "Compression of the tunnel streams requested from the tunnel server: none or deflate. The streams are not compressed when the server does not accept it")
+	Server.Flags().StringVar(&c.Tunnel.Server.ListenAddress, "tunnel-server-listen-address", "", "Address on which the tunnel server accepts the connections of the tunnel clients e.g. 0.0.0.0:8443")
+	Server.Flags().StringVar(&c.Tunnel.Server.CertFile, "tunnel-server-cert-file", "", "PEM encoded file with server certificate presented to the tunnel clients")
+	Server.Flags().StringVar(&c.Tunnel.Server.KeyFile, "tunnel-server-key-file", "", "PEM encoded file with private key for the tunnel server certificate")
+	Server.Flags().Var(&c.Tunnel.Server.KeyPassword, "tunnel-server-key-password", "Password to decrypt rsa private key")
+	Server.Flags().StringVar(&c.Tunnel.Server.CAChainCertFile, "tunnel-server-ca-chain-cert-file", "", "PEM encoded CA's certificate file used to verify the tunnel client certificates")
+	Server.Flags().StringVar(&c.Tunnel.Server.Transport, "tunnel-server-transport", "tls", "Transport of the tunnel connections accepted by the tunnel server: tls, websocket or quic. The quic transport accepts the QUIC connections on the UDP port of the listen address and the tls connections on the TCP port")
+	Server.Flags().StringSliceVar(&c.Tunnel.Server.Compressions, "tunnel-server-compressions", []string{"deflate"}, "Compression of the tunnel streams accepted from the tunnel clients: deflate")
+	Server.Flags().StringSliceVar(&c.Tunnel.Server.AllowedBrokers, "tunnel-server-allowed-brokers", []string{}, "Broker addresses host:port which the tunnel clients may connect to, the patterns may contain * wildcards. All brokers are allowed when empty")
+	Server.Flags().DurationVar(&c.Tunnel.KeepAliveInterval, "tunnel-keep-alive-interval", 30*time.Second, "How often keep-alive pings are sent over the tunnel connections")
+	Server.Flags().StringVar(&c.Tunnel.WebSocketPath, "tunnel-websocket-path", "/tunnel", "Path of the WebSocket upgrade request of the websocket transport")
+
+	// record encryption
+	Server.Flags().BoolVar(&c.Encryption.Enable, "encryption-enable", false, "Enable encryption of record values in Produce requests and decryption in Fetch responses")
+	Server.Flags().StringVar(&c.Encryption.KMS, "encryption-kms", "", "Name of the built-in key management service: static-kms, vault-transit, aws-kms or gcp-kms")
+	Server.Flags().StringArrayVar(&c.Encryption.Parameters, "encryption-kms-param", []string{}, "Key management service parameter")
+	Server.Flags().StringArrayVar(&c.Encryption.TopicKeys, "encryption-topic-key", []string{}, "Key of the encrypted topic given as topic=key-id. The topic ending with * is a prefix")
+	Server.Flags().DurationVar(&c.Encryption.DataKeyTTL, "encryption-data-key-ttl", 1*time.Hour, "How long a data key is used for encryption and cached for decryption")
+	Server.Flags().DurationVar(&c.Encryption.Timeout, "encryption-kms-timeout", 10*time.Second, "How long to wait for the key management service")
+
+	// schema registry validation
+	Server.Flags().BoolVar(&c.SchemaRegistry.Enable, "schema-registry-validation-enable", false, "Enable validation of the schema ids of produced records against the schema registry. Invalid records are rejected with INVALID_RECORD")
+	Server.Flags().StringVar(&c.SchemaRegistry.Url, "schema-registry-url", "", "URL of the schema registry")
+	Server.Flags().StringVar(&c.SchemaRegistry.Username, "schema-registry-username", "", "Username of the schema registry basic authentication")
+	Server.Flags().Var(&c.SchemaRegistry.Password, "schema-registry-password", "Password of the schema registry basic authentication")
+	Server.Flags().StringArrayVar(&c.SchemaRegistry.Topics, "schema-registry-topic", []string{}, "Validated topic. The topic ending with * is a prefix. If not set, all topics are validated")
+	Server.Flags().BoolVar(&c.SchemaRegistry.ValidateKeys, "schema-registry-validate-keys", false, "Validate record keys against the <topic>-key subject")
+	Server.Flags().DurationVar(&c.SchemaRegistry.CacheTTL, "schema-registry-cache-ttl", 5*time.Minute, "How long validation results are cached")
+	Server.Flags().DurationVar(&c.SchemaRegistry.Timeout, "schema-registry-timeout", 5*time.Second, "How long to wait for the schema registry")
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
@@ -172,163 +526,406 @@ func initFlags() {
 func Run(_ *cobra.Command, _ []string) {
 	logrus.Infof("Starting kafka-proxy version %s", config.Version)
 
+	pluginOptions, closePlugins := newPluginOptions()
+	defer closePlugins()
+
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if c.Http.MetricsInstanceLabel {
+		gatherer = proxy.NewInstanceGatherer(gatherer, proxy.MetricsInstanceID(c.Proxy.InstanceID))
+	}
+
+	var hotRestart *proxy.HotRestart
+	if c.Proxy.HotRestart.Enable {
+		var err error
+		if hotRestart, err = proxy.NewHotRestart(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	var addressListener proxy.AddressListener = hotRestart
+	if c.Systemd.SocketActivation {
+		socketActivation, err := proxy.NewSocketActivation()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		addressListener = socketActivation
+	}
+
+	var g group.Group
+	var frameCapture *proxy.FrameCapture
+	var brokerDrains *proxy.BrokerDrains
+	var maintenance *proxy.Maintenance
+	var processorTuning *proxy.ProcessorTuning
+	var connections *proxy.ConnSet
+	var killSwitch *proxy.KillSwitch
+	var connTracing *proxy.ConnTracing
+	var faultInjection *proxy.FaultInjection
+	var partitionLeaders *proxy.PartitionLeaders
+	var clientInventory *proxy.ClientInventory
+	proxyOptions := append(pluginOptions, proxy.WithAddressListener(addressListener))
+	if c.Events.Webhook.Url != "" {
+		eventWebhook, err := proxy.NewEventWebhook(c)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		proxyOptions = append(proxyOptions, proxy.WithEventHandler(eventWebhook))
+		g.Add(func() error {
+			eventWebhook.Run()
+			return nil
+		}, func(error) {
+			eventWebhook.Close()
+		})
+	}
+	proxyServer, err := proxy.New(c, proxyOptions...)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	{
+		if err := proxyServer.Start(context.Background()); err != nil {
+			logrus.Fatal(err)
+		}
+		proxyClient := proxyServer.Client()
+		frameCapture = proxyClient.FrameCapture()
+		brokerDrains = proxyClient.BrokerDrains()
+		maintenance = proxyClient.Maintenance()
+		processorTuning = proxyClient.ProcessorTuning()
+		connections = proxyClient.Connections()
+		killSwitch = proxyClient.KillSwitch()
+		connTracing = proxyClient.ConnTracing()
+		faultInjection = proxyClient.FaultInjection()
+		partitionLeaders = proxyClient.PartitionLeaders()
+		clientInventory = proxyClient.ClientInventory()
+		g.Add(func() error {
+			<-proxyServer.Done()
+			return nil
+		}, func(error) {
+			proxyServer.Close()
+		})
+	}
+	{
+		cancelInterrupt := make(chan struct{})
+		g.Add(func() error {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			if hotRestart != nil {
+				signal.Notify(signals, proxy.HotRestartSignal)
+			}
+			for {
+				select {
+				case sig := <-signals:
+					if hotRestart == nil || sig != proxy.HotRestartSignal {
+						return fmt.Errorf("received signal %s", sig)
+					}
+					process, err := hotRestart.Restart()
+					if err != nil {
+						logrus.Errorf("Hot restart failed: %v", err)
+						continue
+					}
+					logrus.Infof("Listeners were handed over to process %d, draining connections", process.Pid)
+					return drainConnections(proxyServer, c.Proxy.HotRestart.DrainTimeout, signals, cancelInterrupt)
+				case <-cancelInterrupt:
+					return nil
+				}
+			}
+		}, func(error) {
+			close(cancelInterrupt)
+		})
+	}
+	if !c.Http.Disable {
+		httpHandler := NewHTTPHandler(gatherer, frameCapture, brokerDrains, maintenance, processorTuning, connections, killSwitch, connTracing, faultInjection, partitionLeaders, clientInventory)
+		httpListener, err := addressListener.Listen(c.Http.ListenAddress, c.Proxy.ListenerReusePort, true)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		g.Add(func() error {
+			return http.Serve(httpListener, httpHandler)
+		}, func(error) {
+			httpListener.Close()
+		})
+		if alpnListener := proxyServer.HTTPListener(); alpnListener != nil {
+			g.Add(func() error {
+				return http.Serve(alpnListener, httpHandler)
+			}, func(error) {
+				alpnListener.Close()
+			})
+		}
+		if c.Http.UnixSocket != "" {
+			socketListener, err := listenUnixSocket(c.Http.UnixSocket)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			g.Add(func() error {
+				return http.Serve(socketListener, httpHandler)
+			}, func(error) {
+				socketListener.Close()
+			})
+		}
+	}
+	if c.Statsd.Enable {
+		statsdExporter, err := proxy.NewStatsdExporter(gatherer, c.Statsd.Address, c.Statsd.Format == "dogstatsd", c.Statsd.Prefix, c.Statsd.Tags, c.Statsd.Interval)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		g.Add(func() error {
+			statsdExporter.Run()
+			return nil
+		}, func(error) {
+			statsdExporter.Close()
+		})
+	}
+	if c.Otlp.Enable {
+		otlpExporter, err := proxy.NewOTLPExporter(gatherer, c)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		g.Add(func() error {
+			otlpExporter.Run()
+			return nil
+		}, func(error) {
+			otlpExporter.Close()
+		})
+	}
+	if c.Debug.Enabled {
+		// https://golang.org/pkg/net/http/pprof/
+		// https://jvns.ca/blog/2017/09/24/profiling-go-with-pprof/
+		debugListener, err := addressListener.Listen(c.Debug.ListenAddress, false, true)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		g.Add(func() error {
+			return http.Serve(debugListener, http.DefaultServeMux)
+		}, func(error) {
+			debugListener.Close()
+		})
+	}
+
+	if c.Systemd.Notify {
+		if interval := proxy.SystemdWatchdogInterval(); interval > 0 {
+			stopWatchdog := make(chan struct{})
+			g.Add(func() error {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if err := proxy.SystemdNotify("WATCHDOG=1"); err != nil {
+							logrus.Warnf("Systemd watchdog keepalive failed: %v", err)
+						}
+					case <-stopWatchdog:
+						return nil
+					}
+				}
+			}, func(error) {
+				close(stopWatchdog)
+			})
+		}
+	}
+
+	hotRestart.Ready()
+	if c.Systemd.Notify {
+		if err := proxy.SystemdNotify("READY=1"); err != nil {
+			logrus.Warnf("Systemd readiness notification failed: %v", err)
+		}
+	}
+	err = g.Run()
+	logrus.Info("Exit ", err)
+}
+
+// newPluginOptions loads the built-in components and starts the plugins of the configuration, the plugins are stopped by the returned func
+func newPluginOptions() ([]proxy.Option, func()) {
+	closers := make([]func(), 0)
+	closePlugins := func() {
+		for _, closer := range closers {
+			closer()
+		}
+	}
+
 	var passwordAuthenticator apis.PasswordAuthenticator
-	if c.Auth.Local.Enable {
+	if c.AuthEnabled(func(c *config.Config) bool { return c.Auth.Local.Enable }) {
 		var err error
 		factory, ok := registry.GetComponent(new(apis.PasswordAuthenticatorFactory), c.Auth.Local.Command).(apis.PasswordAuthenticatorFactory)
 		if ok {
-			logrus.Infof("Using built-in PasswordAuthenticator")
+			logrus.Infof("Using built-in PasswordAuthenticator %s", c.Auth.Local.Command)
 			passwordAuthenticator, err = factory.New(c.Auth.Local.Parameters)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 		} else {
-			client := NewPluginClient(localauth.Handshake, localauth.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
-			defer client.Kill()
+			supervisor := newPluginSupervisor("local-auth", "passwordAuthenticator", localauth.Handshake, localauth.PluginMap, c.Auth.Local.LogLevel, c.Auth.Local.Command, c.Auth.Local.Parameters)
+			closers = append(closers, supervisor.Close)
 
-			rpcClient, err := client.Client()
-			if err != nil {
-				logrus.Fatal(err)
-			}
-			raw, err := rpcClient.Dispense("passwordAuthenticator")
-			if err != nil {
-				logrus.Fatal(err)
-			}
-			passwordAuthenticator, ok = raw.(apis.PasswordAuthenticator)
-			if !ok {
+			raw, _ := supervisor.Get()
+			if _, ok = raw.(apis.PasswordAuthenticator); !ok {
 				logrus.Fatal(errors.New("unsupported PasswordAuthenticator plugin type"))
 			}
+			passwordAuthenticator = supervisor.PasswordAuthenticator()
 		}
 	}
 
 	var tokenProvider apis.TokenProvider
-	if c.Auth.Gateway.Client.Enable {
+	if c.AuthEnabled(func(c *config.Config) bool { return c.Auth.Gateway.Client.Enable }) {
 		var err error
 		factory, ok := registry.GetComponent(new(apis.TokenProviderFactory), c.Auth.Gateway.Client.Command).(apis.TokenProviderFactory)
 		if ok {
-			logrus.Infof("Using built-in TokenProvider")
+			logrus.Infof("Using built-in TokenProvider %s", c.Auth.Gateway.Client.Command)
 			tokenProvider, err = factory.New(c.Auth.Gateway.Client.Parameters)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 		} else {
-			client := NewPluginClient(gatewayclient.Handshake, gatewayclient.PluginMap, c.Auth.Gateway.Client.LogLevel, c.Auth.Gateway.Client.Command, c.Auth.Gateway.Client.Parameters)
-			defer client.Kill()
+			supervisor := newPluginSupervisor("gateway-client", "tokenProvider", gatewayclient.Handshake, gatewayclient.PluginMap, c.Auth.Gateway.Client.LogLevel, c.Auth.Gateway.Client.Command, c.Auth.Gateway.Client.Parameters)
+			closers = append(closers, supervisor.Close)
 
-			rpcClient, err := client.Client()
-			if err != nil {
-				logrus.Fatal(err)
-			}
-			raw, err := rpcClient.Dispense("tokenProvider")
-			if err != nil {
-				logrus.Fatal(err)
-			}
-			tokenProvider, ok = raw.(apis.TokenProvider)
-			if !ok {
+			raw, _ := supervisor.Get()
+			if _, ok = raw.(apis.TokenProvider); !ok {
 				logrus.Fatal(errors.New("unsupported TokenProvider plugin type"))
 			}
+			tokenProvider = supervisor.TokenProvider()
 		}
 	}
 
 	var tokenInfo apis.TokenInfo
-	if c.Auth.Gateway.Server.Enable {
+	if c.AuthEnabled(func(c *config.Config) bool { return c.Auth.Gateway.Server.Enable }) {
 		var err error
 		factory, ok := registry.GetComponent(new(apis.TokenInfoFactory), c.Auth.Gateway.Server.Command).(apis.TokenInfoFactory)
 		if ok {
-			logrus.Infof("Using built-in TokenInfo")
+			logrus.Infof("Using built-in TokenInfo %s", c.Auth.Gateway.Server.Command)
 
 			tokenInfo, err = factory.New(c.Auth.Gateway.Server.Parameters)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 		} else {
-			client := NewPluginClient(gatewayserver.Handshake, gatewayserver.PluginMap, c.Auth.Gateway.Server.LogLevel, c.Auth.Gateway.Server.Command, c.Auth.Gateway.Server.Parameters)
-			defer client.Kill()
+			supervisor := newPluginSupervisor("gateway-server", "tokenInfo", gatewayserver.Handshake, gatewayserver.PluginMap, c.Auth.Gateway.Server.LogLevel, c.Auth.Gateway.Server.Command, c.Auth.Gateway.Server.Parameters)
+			closers = append(closers, supervisor.Close)
 
-			rpcClient, err := client.Client()
-			if err != nil {
-				logrus.Fatal(err)
+			raw, _ := supervisor.Get()
+			if _, ok = raw.(apis.TokenInfo); !ok {
+				logrus.Fatal(errors.New("unsupported TokenInfo plugin type"))
 			}
-			raw, err := rpcClient.Dispense("tokenInfo")
+			tokenInfo = supervisor.TokenInfo()
+		}
+	}
+
+	var requestAuthorizer apis.RequestAuthorizer
+	if c.AuthEnabled(func(c *config.Config) bool { return c.Auth.Authz.Enable }) {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.RequestAuthorizerFactory), c.Auth.Authz.Command).(apis.RequestAuthorizerFactory)
+		if ok {
+			logrus.Infof("Using built-in RequestAuthorizer %s", c.Auth.Authz.Command)
+
+			requestAuthorizer, err = factory.New(c.Auth.Authz.Parameters)
 			if err != nil {
 				logrus.Fatal(err)
 			}
-			tokenInfo, ok = raw.(apis.TokenInfo)
-			if !ok {
-				logrus.Fatal(errors.New("unsupported TokenInfo plugin type"))
+		} else {
+			supervisor := newPluginSupervisor("request-authz", "requestAuthorizer", requestauthz.Handshake, requestauthz.PluginMap, c.Auth.Authz.LogLevel, c.Auth.Authz.Command, c.Auth.Authz.Parameters)
+			closers = append(closers, supervisor.Close)
+
+			raw, _ := supervisor.Get()
+			if _, ok = raw.(apis.RequestAuthorizer); !ok {
+				logrus.Fatal(errors.New("unsupported RequestAuthorizer plugin type"))
 			}
+			requestAuthorizer = supervisor.RequestAuthorizer()
 		}
 	}
 
-	var g group.Group
-	{
-		// All active connections are stored in this variable.
-		connset := proxy.NewConnSet()
-		prometheus.MustRegister(proxy.NewCollector(connset))
-		listeners, err := proxy.NewListeners(c)
-		if err != nil {
-			logrus.Fatal(err)
+	var frameFilter apis.FrameFilter
+	if c.Proxy.Filter.Enable {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.FrameFilterFactory), c.Proxy.Filter.Name).(apis.FrameFilterFactory)
+		if !ok {
+			logrus.Fatal(fmt.Errorf("unknown built-in frame filter %s", c.Proxy.Filter.Name))
 		}
-		connSrc, err := listeners.ListenInstances(c.Proxy.BootstrapServers)
+		frameFilter, err = factory.New(c.Proxy.Filter.Parameters)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		proxyClient, err := proxy.NewClient(connset, c, listeners.GetNetAddressMapping, passwordAuthenticator, tokenProvider, tokenInfo)
+		if closer, ok := frameFilter.(interface{ Close() }); ok {
+			closers = append(closers, closer.Close)
+		}
+	}
+
+	var keyManagementService apis.KeyManagementService
+	if c.Encryption.Enable {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.KeyManagementServiceFactory), c.Encryption.KMS).(apis.KeyManagementServiceFactory)
+		if !ok {
+			logrus.Fatal(fmt.Errorf("unknown built-in key management service %s", c.Encryption.KMS))
+		}
+		keyManagementService, err = factory.New(c.Encryption.Parameters)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-		g.Add(func() error {
-			logrus.Print("Ready for new connections")
-			return proxyClient.Run(connSrc)
-		}, func(error) {
-			proxyClient.Close()
-		})
 	}
-	{
-		cancelInterrupt := make(chan struct{})
-		g.Add(func() error {
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-			select {
-			case sig := <-c:
-				return fmt.Errorf("received signal %s", sig)
-			case <-cancelInterrupt:
-				return nil
+
+	var keySigner apis.KeySigner
+	if c.Proxy.TLS.Enable && c.Proxy.TLS.ListenerKeySigner.Enable {
+		var err error
+		factory, ok := registry.GetComponent(new(apis.KeySignerFactory), c.Proxy.TLS.ListenerKeySigner.Command).(apis.KeySignerFactory)
+		if ok {
+			logrus.Infof("Using built-in KeySigner %s", c.Proxy.TLS.ListenerKeySigner.Command)
+
+			keySigner, err = factory.New(c.Proxy.TLS.ListenerKeySigner.Parameters)
+			if err != nil {
+				logrus.Fatal(err)
 			}
-		}, func(error) {
-			close(cancelInterrupt)
-		})
-	}
-	if !c.Http.Disable {
-		httpListener, err := net.Listen("tcp", c.Http.ListenAddress)
-		if err != nil {
-			logrus.Fatal(err)
+		} else {
+			supervisor := newPluginSupervisor("key-signer", "keySigner", keysigner.Handshake, keysigner.PluginMap, c.Proxy.TLS.ListenerKeySigner.LogLevel, c.Proxy.TLS.ListenerKeySigner.Command, c.Proxy.TLS.ListenerKeySigner.Parameters)
+			closers = append(closers, supervisor.Close)
+
+			raw, _ := supervisor.Get()
+			if _, ok = raw.(apis.KeySigner); !ok {
+				logrus.Fatal(errors.New("unsupported KeySigner plugin type"))
+			}
+			keySigner = supervisor.KeySigner()
 		}
-		g.Add(func() error {
-			return http.Serve(httpListener, NewHTTPHandler())
-		}, func(error) {
-			httpListener.Close()
-		})
 	}
-	if c.Debug.Enabled {
-		// https://golang.org/pkg/net/http/pprof/
-		// https://jvns.ca/blog/2017/09/24/profiling-go-with-pprof/
-		debugListener, err := net.Listen("tcp", c.Debug.ListenAddress)
-		if err != nil {
-			logrus.Fatal(err)
+
+	return []proxy.Option{
+		proxy.WithKeySigner(keySigner),
+		proxy.WithPasswordAuthenticator(passwordAuthenticator),
+		proxy.WithTokenProvider(tokenProvider),
+		proxy.WithTokenInfo(tokenInfo),
+		proxy.WithRequestAuthorizer(requestAuthorizer),
+		proxy.WithFrameFilter(frameFilter),
+		proxy.WithKeyManagementService(keyManagementService),
+	}, closePlugins
+}
+
+// drainConnections waits until the clients close their connections after the hot restart, the remaining connections
+// are closed by the proxy client after the timeout or the next signal
+func drainConnections(proxyServer *proxy.Server, timeout time.Duration, signals <-chan os.Signal, cancel <-chan struct{}) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		count := proxyServer.Connections()
+		if count == 0 {
+			return errors.New("hot restart: connections were drained")
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return fmt.Errorf("hot restart: %d connections were not drained within %v", count, timeout)
+		case sig := <-signals:
+			return fmt.Errorf("received signal %s while draining %d connections", sig, count)
+		case <-cancel:
+			return nil
 		}
-		g.Add(func() error {
-			return http.Serve(debugListener, http.DefaultServeMux)
-		}, func(error) {
-			debugListener.Close()
-		})
 	}
+}
 
-	err := g.Run()
-	logrus.Info("Exit ", err)
+// listenUnixSocket listens on the Unix socket, the socket file left by a stopped process is removed
+func listenUnixSocket(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
 }
 
-func NewHTTPHandler() http.Handler {
+func NewHTTPHandler(gatherer prometheus.Gatherer, frameCapture *proxy.FrameCapture, brokerDrains *proxy.BrokerDrains, maintenance *proxy.Maintenance, processorTuning *proxy.ProcessorTuning, connections *proxy.ConnSet, killSwitch *proxy.KillSwitch, connTracing *proxy.ConnTracing, faultInjection *proxy.FaultInjection, partitionLeaders *proxy.PartitionLeaders, clientInventory *proxy.ClientInventory) http.Handler {
 	m := http.NewServeMux()
 	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(
@@ -345,7 +942,37 @@ func NewHTTPHandler() http.Handler {
 	m.HandleFunc(c.Http.HealthPath, func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`OK`))
 	})
-	m.Handle(c.Http.MetricsPath, promhttp.Handler())
+	m.Handle(c.Http.MetricsPath, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	if frameCapture != nil {
+		m.Handle(c.Debug.Capture.Path, frameCapture)
+	}
+	if brokerDrains != nil {
+		m.Handle(c.Http.Drain.Path, brokerDrains)
+	}
+	if maintenance != nil {
+		m.Handle(c.Http.Maintenance.Path, maintenance)
+	}
+	if processorTuning != nil {
+		m.Handle(c.Http.Tuning.Path, processorTuning)
+	}
+	if connections != nil {
+		m.Handle(c.Http.Connections.Path, connections)
+	}
+	if killSwitch != nil {
+		m.Handle(c.Http.KillSwitch.Path, killSwitch)
+	}
+	if connTracing != nil {
+		m.Handle(c.Http.Tracing.Path, connTracing)
+	}
+	if faultInjection != nil {
+		m.Handle(c.Debug.Faults.Path, faultInjection)
+	}
+	if partitionLeaders != nil {
+		m.Handle(c.Http.PartitionLeaders.Path, partitionLeaders)
+	}
+	if clientInventory != nil {
+		m.Handle(c.Http.ClientInventory.Path, clientInventory)
+	}
 
 	return m
 }
@@ -370,6 +997,43 @@ func SetLogger() {
 		level = logrus.InfoLevel
 	}
 	logrus.SetLevel(level)
+
+	if c.Log.Backend == "slog" {
+		logger, err := newSlogLogger(c.Log.Format, c.Log.Level)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		proxy.SetLogger(logger)
+	}
+}
+
+// newPluginSupervisor starts the plugin, it is restarted when it exits or does not respond
+func newPluginSupervisor(name string, dispense string, handshakeConfig plugin.HandshakeConfig, plugins map[string]plugin.Plugin, logLevel string, command string, params []string) *proxy.PluginSupervisor {
+	supervisor, err := proxy.NewPluginSupervisor(name, dispense, func() proxy.PluginClient {
+		return NewPluginClient(handshakeConfig, plugins, logLevel, command, params)
+	}, c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return supervisor
+}
+
+// pluginLogger redacts the secret parameters of the plugin command logged by the plugin client at debug level
+type pluginLogger struct {
+	hclog.Logger
+}
+
+func (l pluginLogger) Debug(msg string, args ...interface{}) {
+	for i := 1; i < len(args); i += 2 {
+		if params, ok := args[i].([]string); ok && args[i-1] == "args" {
+			redacted := make([]string, len(params))
+			for j, param := range params {
+				redacted[j] = config.RedactParameter(param)
+			}
+			args[i] = redacted
+		}
+	}
+	l.Logger.Debug(msg, args...)
 }
 
 func NewPluginClient(handshakeConfig plugin.HandshakeConfig, plugins map[string]plugin.Plugin, logLevel string, command string, params []string) *plugin.Client {
@@ -388,7 +1052,7 @@ func NewPluginClient(handshakeConfig plugin.HandshakeConfig, plugins map[string]
 	return plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig: handshakeConfig,
 		Plugins:         plugins,
-		Logger:          logger,
+		Logger:          pluginLogger{Logger: logger},
 		Cmd:             exec.Command(command, params...),
 		AllowedProtocols: []plugin.Protocol{
 			plugin.ProtocolNetRPC, plugin.ProtocolGRPC},
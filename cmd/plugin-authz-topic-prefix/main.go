@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/request-authz/shared"
+	"github.com/hashicorp/go-plugin"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return strings.Join(*i, ",")
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// RequestAuthorizer allows requests when all topics start with one of the prefixes granted to the principal.
+// Requests without topics are allowed. Requests with unknown topics are denied unless the principal is granted the empty prefix.
+type RequestAuthorizer struct {
+	Allow arrayFlags
+
+	prefixes map[string][]string
+}
+
+func (ra *RequestAuthorizer) Authorize(ctx context.Context, request apis.AuthorizeRequest) (apis.AuthorizeResponse, error) {
+	if request.TopicsUnknown && !ra.allowed(request.Principal, "") {
+		logrus.Infof("Denied api key %d version %d with unknown topics of principal '%s' from %s", request.ApiKey, request.ApiVersion, request.Principal, request.ClientAddress)
+		return apis.AuthorizeResponse{Allowed: false}, nil
+	}
+	for _, topic := range request.Topics {
+		if !ra.allowed(request.Principal, topic) {
+			logrus.Infof("Denied api key %d for topic %s of principal '%s' from %s", request.ApiKey, topic, request.Principal, request.ClientAddress)
+			return apis.AuthorizeResponse{Allowed: false}, nil
+		}
+	}
+	return apis.AuthorizeResponse{Allowed: true}, nil
+}
+
+func (ra *RequestAuthorizer) allowed(principal string, topic string) bool {
+	for _, p := range []string{principal, "*"} {
+		for _, prefix := range ra.prefixes[p] {
+			if strings.HasPrefix(topic, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ra *RequestAuthorizer) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("authz plugin settings", flag.ContinueOnError)
+	fs.Var(&ra.Allow, "allow", "Topic prefix granted to the principal (principal:prefix). Principal * matches all principals")
+	return fs
+}
+
+func (ra *RequestAuthorizer) init() error {
+	ra.prefixes = make(map[string][]string)
+	for _, allow := range ra.Allow {
+		pair := strings.SplitN(allow, ":", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return fmt.Errorf("allow must be in form 'principal:prefix', got '%s'", allow)
+		}
+		ra.prefixes[pair[0]] = append(ra.prefixes[pair[0]], pair[1])
+	}
+	return nil
+}
+
+func main() {
+	requestAuthorizer := &RequestAuthorizer{}
+	flags := requestAuthorizer.flagSet()
+	flags.Parse(os.Args[1:])
+	if err := requestAuthorizer.init(); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: shared.Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"requestAuthorizer": &shared.RequestAuthorizerPlugin{Impl: requestAuthorizer},
+		},
+		// A non-nil value here enables gRPC serving for this plugin...
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultClusterName is the name of the upstream cluster of the bootstrap servers
+const DefaultClusterName = "default"
+
+type clusterSetting func(c *Config, value string) error
+
+// upstream settings of the clusters named as the global flags
+var clusterSettings = map[string]clusterSetting{
+	"kafka-dial-timeout": func(c *Config, value string) (err error) {
+		c.Kafka.DialTimeout, err = time.ParseDuration(value)
+		return err
+	},
+	"kafka-expected-cluster-id": func(c *Config, value string) error { c.Kafka.ExpectedClusterID = value; return nil },
+	"kafka-dial-source-address": func(c *Config, value string) error { c.Kafka.DialSourceAddress = value; return nil },
+	"tls-enable": func(c *Config, value string) (err error) {
+		c.Kafka.TLS.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"tls-insecure-skip-verify": func(c *Config, value string) (err error) {
+		c.Kafka.TLS.InsecureSkipVerify, err = strconv.ParseBool(value)
+		return err
+	},
+	"tls-client-cert-file":    func(c *Config, value string) error { c.Kafka.TLS.ClientCertFile = value; return nil },
+	"tls-client-key-file":     func(c *Config, value string) error { c.Kafka.TLS.ClientKeyFile = value; return nil },
+	"tls-client-key-password": func(c *Config, value string) error { c.Kafka.TLS.ClientKeyPassword = Secret(value); return nil },
+	"tls-ca-chain-cert-file":  func(c *Config, value string) error { c.Kafka.TLS.CAChainCertFile = value; return nil },
+	"tls-min-version":         func(c *Config, value string) error { c.Kafka.TLS.MinVersion = value; return nil },
+	"tls-max-version":         func(c *Config, value string) error { c.Kafka.TLS.MaxVersion = value; return nil },
+	"tls-cipher-suites": func(c *Config, value string) error {
+		c.Kafka.TLS.CipherSuites = strings.Split(value, ",")
+		return nil
+	},
+	"tls-curve-preferences": func(c *Config, value string) error {
+		c.Kafka.TLS.CurvePreferences = strings.Split(value, ",")
+		return nil
+	},
+	"tls-session-tickets-disable": func(c *Config, value string) (err error) {
+		c.Kafka.TLS.SessionTicketsDisable, err = strconv.ParseBool(value)
+		return err
+	},
+	"tls-pinned-spki-sha256": func(c *Config, value string) error {
+		c.Kafka.TLS.PinnedSPKIHashes = strings.Split(value, ",")
+		return nil
+	},
+	"tls-crl": func(c *Config, value string) error {
+		c.Kafka.TLS.CRLs = strings.Split(value, ",")
+		return nil
+	},
+	"tls-ocsp-enable": func(c *Config, value string) (err error) {
+		c.Kafka.TLS.OCSPEnable, err = strconv.ParseBool(value)
+		return err
+	},
+	"tls-client-vault-pki-path": func(c *Config, value string) error { c.Kafka.TLS.ClientVaultPKI.Path = value; return nil },
+	"sasl-enable": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"sasl-mechanism": func(c *Config, value string) error { c.Kafka.SASL.Mechanism = value; return nil },
+	"sasl-username":  func(c *Config, value string) error { c.Kafka.SASL.Username = value; return nil },
+	"sasl-password":  func(c *Config, value string) error { c.Kafka.SASL.Password = Secret(value); return nil },
+	"sasl-jaas-config-file": func(c *Config, value string) error {
+		c.Kafka.SASL.JaasConfigFile = value
+		return c.InitSASLCredentials()
+	},
+	"sasl-token-auth": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.TokenAuth, err = strconv.ParseBool(value)
+		return err
+	},
+	"sasl-vault-path": func(c *Config, value string) error { c.Kafka.SASL.VaultPath = value; return nil },
+	"sasl-reauthentication-enable": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.Reauthentication, err = strconv.ParseBool(value)
+		return err
+	},
+	"sasl-delegation-token-enable": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.DelegationToken.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"sasl-delegation-token-mechanism": func(c *Config, value string) error { c.Kafka.SASL.DelegationToken.Mechanism = value; return nil },
+	"sasl-delegation-token-max-lifetime": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.DelegationToken.MaxLifetime, err = time.ParseDuration(value)
+		return err
+	},
+	"sasl-delegation-token-renew-interval": func(c *Config, value string) (err error) {
+		c.Kafka.SASL.DelegationToken.RenewInterval, err = time.ParseDuration(value)
+		return err
+	},
+	"forward-proxy": func(c *Config, value string) error {
+		c.ForwardProxy.Url = value
+		c.ForwardProxy.Proxies = nil
+		return nil
+	},
+	"forward-proxy-socks5-resolve": func(c *Config, value string) error { c.ForwardProxy.SOCKS5.Resolve = value; return nil },
+	"forward-proxy-http-auth":      func(c *Config, value string) error { c.ForwardProxy.HTTP.Auth = value; return nil },
+}
+
+// parseClusterSetting parses the cluster setting given as name:setting=value
+func parseClusterSetting(value string) (name string, setting string, settingValue string, err error) {
+	pair := strings.SplitN(value, ":", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return "", "", "", fmt.Errorf("Kafka.Clusters.Settings entry '%s' must be name:setting=value", RedactParameter(value))
+	}
+	kv := strings.SplitN(pair[1], "=", 2)
+	if len(kv) != 2 {
+		return "", "", "", fmt.Errorf("Kafka.Clusters.Settings entry '%s' must be name:setting=value", RedactParameter(value))
+	}
+	if _, ok := clusterSettings[kv[0]]; !ok {
+		return "", "", "", fmt.Errorf("Kafka.Clusters.Settings entry '%s' has unknown setting %s", RedactParameter(value), kv[0])
+	}
+	return pair[0], kv[0], kv[1], nil
+}
+
+// ClusterConfig returns the configuration of the connections to the upstream cluster: the global configuration
+// with the settings of the cluster. The configuration is validated when the cluster has settings.
+func (c *Config) ClusterConfig(name string) (*Config, error) {
+	clusterConfig := *c
+	changed := false
+	for _, value := range c.Kafka.Clusters.Settings {
+		clusterName, setting, settingValue, err := parseClusterSetting(value)
+		if err != nil {
+			return nil, err
+		}
+		if clusterName != name {
+			continue
+		}
+		if err = clusterSettings[setting](&clusterConfig, settingValue); err != nil {
+			return nil, fmt.Errorf("Kafka.Clusters.Settings entry '%s' is invalid: %v", RedactParameter(value), err)
+		}
+		changed = true
+	}
+	if !changed {
+		return c, nil
+	}
+	if err := clusterConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration of cluster %s is invalid: %v", name, err)
+	}
+	return &clusterConfig, nil
+}
+
+// ValidateClusterSettings validates the configurations of the clusters with settings, which are otherwise validated
+// when the upstream connections are created
+func (c *Config) ValidateClusterSettings() error {
+	for _, value := range c.Kafka.Clusters.Settings {
+		name, _, _, err := parseClusterSetting(value)
+		if err != nil {
+			return err
+		}
+		if _, err = c.ClusterConfig(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
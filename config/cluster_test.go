@@ -0,0 +1,116 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClusterConfig(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"old-0:9092,127.0.0.1:32400"}))
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "secret"
+	c.Kafka.Clusters.Servers = []string{"new=new-0:9092"}
+	c.Kafka.Clusters.Settings = []string{"new:sasl-username=bob", "new:sasl-password=pass=word", "new:kafka-dial-timeout=3s", "new:kafka-expected-cluster-id=new-id", "new:forward-proxy=socks5://proxy:1080", "new:tls-min-version=TLS1.3", "new:tls-cipher-suites=ECDHE-RSA-AES256-GCM-SHA384,ECDHE-RSA-AES128-GCM-SHA256"}
+	a.Nil(c.Validate())
+
+	defaultConfig, err := c.ClusterConfig(DefaultClusterName)
+	a.Nil(err)
+	a.True(defaultConfig == c)
+
+	newConfig, err := c.ClusterConfig("new")
+	a.Nil(err)
+	a.Equal("bob", newConfig.Kafka.SASL.Username)
+	a.Equal("pass=word", newConfig.Kafka.SASL.Password.Value())
+	a.Equal(3*time.Second, newConfig.Kafka.DialTimeout)
+	a.Equal("new-id", newConfig.Kafka.ExpectedClusterID)
+	a.Equal([]ForwardProxyConfig{{Scheme: "socks5", Address: "proxy:1080"}}, newConfig.ForwardProxy.Proxies)
+	a.Equal("TLS1.3", newConfig.Kafka.TLS.MinVersion)
+	a.Equal([]string{"ECDHE-RSA-AES256-GCM-SHA384", "ECDHE-RSA-AES128-GCM-SHA256"}, newConfig.Kafka.TLS.CipherSuites)
+	// the global configuration is not changed
+	a.Equal("alice", c.Kafka.SASL.Username)
+	a.Empty(c.ForwardProxy.Proxies)
+
+	c.Kafka.Clusters.Settings = []string{"new:sasl-enable=yes"}
+	_, err = c.ClusterConfig("new")
+	a.EqualError(err, "Kafka.Clusters.Settings entry 'new:sasl-enable=yes' is invalid: strconv.ParseBool: parsing \"yes\": invalid syntax")
+
+	c.Kafka.Clusters.Settings = []string{"new:sasl-password="}
+	_, err = c.ClusterConfig("new")
+	a.EqualError(err, "configuration of cluster new is invalid: SASL.Username and SASL.Password are required when SASL is enabled")
+
+	c.Kafka.Clusters.Settings = []string{"new:sasl-mechanism=SCRAM-SHA-512", "new:sasl-delegation-token-enable=true"}
+	newConfig, err = c.ClusterConfig("new")
+	a.Nil(err)
+	a.Equal("SCRAM-SHA-512", newConfig.Kafka.SASL.Mechanism)
+	a.True(newConfig.Kafka.SASL.DelegationToken.Enable)
+	a.False(c.Kafka.SASL.DelegationToken.Enable)
+
+	c.Kafka.Clusters.Settings = []string{"new:sasl-token-auth=true"}
+	_, err = c.ClusterConfig("new")
+	a.EqualError(err, "configuration of cluster new is invalid: SASL.TokenAuth requires a SCRAM mechanism")
+
+	c.Kafka.Clusters.Settings = []string{"other:sasl-enable=false"}
+	a.EqualError(c.Validate(), "Kafka.Clusters.Settings entry 'other:sasl-enable=false' refers to unknown cluster other")
+	c.Kafka.Clusters.Settings = []string{"new:kafka-dial-source-address=no-such-interface0"}
+	a.EqualError(c.ValidateClusterSettings(), "configuration of cluster new is invalid: DialSourceAddress no-such-interface0 is neither an IP nor a network interface")
+	c.Kafka.Clusters.Settings = []string{"new:read-timeout=1s"}
+	a.EqualError(c.Validate(), "Kafka.Clusters.Settings entry 'new:read-timeout=1s' has unknown setting read-timeout")
+}
+
+func TestShadowConfig(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"old-0:9092,127.0.0.1:32400"}))
+	c.Kafka.Clusters.Servers = []string{"new=new-0:9092"}
+	c.Kafka.Shadow.Topics = []string{"orders\\..*"}
+	a.EqualError(c.Validate(), "Kafka.Shadow.Topics require Kafka.Shadow.Cluster")
+
+	c.Kafka.Shadow.Cluster = "new=shadow-0:9092"
+	a.EqualError(c.Validate(), "Kafka.Shadow.Cluster name new is already used by an upstream cluster")
+	c.Kafka.Shadow.Cluster = "shadow=shadow-0"
+	a.EqualError(c.Validate(), "Kafka.Shadow.Cluster 'shadow=shadow-0' has invalid address shadow-0: address shadow-0: missing port in address")
+	c.Kafka.Shadow.Cluster = "shadow=shadow-0:9092,shadow-1:9092"
+	c.Kafka.Shadow.QueueSize = 0
+	a.EqualError(c.Validate(), "Kafka.Shadow.QueueSize must be greater than 0")
+
+	// the settings of the shadow cluster are accepted, the shadow cluster cannot be routed to
+	c.Kafka.Shadow.QueueSize = 100
+	c.Kafka.Clusters.Settings = []string{"shadow:sasl-username=bob"}
+	a.Nil(c.Validate())
+	c.Kafka.Clusters.TopicRoutes = []string{"shadow=t.*"}
+	a.EqualError(c.Validate(), "Kafka.Clusters.TopicRoutes entry 'shadow=t.*' refers to unknown cluster shadow")
+}
+
+func TestCanaryConfig(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"old-0:9092,127.0.0.1:32400"}))
+	c.Kafka.Clusters.Servers = []string{"new=new-0:9092"}
+	c.Kafka.Shadow.Cluster = "shadow=shadow-0:9092"
+	c.Kafka.Canary.Cluster = "canary"
+	a.EqualError(c.Validate(), "Kafka.Canary.Cluster 'canary' must be name=host:port,host:port")
+	c.Kafka.Canary.Cluster = "shadow=canary-0:9092"
+	a.EqualError(c.Validate(), "Kafka.Canary.Cluster name shadow is already used by an upstream or the shadow cluster")
+	c.Kafka.Canary.Cluster = "canary=canary-0"
+	a.EqualError(c.Validate(), "Kafka.Canary.Cluster 'canary=canary-0' has invalid address canary-0: address canary-0: missing port in address")
+	c.Kafka.Canary.Cluster = "canary=canary-0:9092,canary-1:9092"
+	c.Kafka.Canary.SampleRate = 1.5
+	a.EqualError(c.Validate(), "Kafka.Canary.SampleRate must be greater than 0 and at most 1")
+	c.Kafka.Canary.SampleRate = 0.1
+	c.Kafka.Canary.QueueSize = 0
+	a.EqualError(c.Validate(), "Kafka.Canary.QueueSize must be greater than 0")
+
+	// the settings of the canary cluster are accepted, the canary cluster cannot be routed to
+	c.Kafka.Canary.QueueSize = 100
+	c.Kafka.Clusters.Settings = []string{"canary:sasl-username=bob"}
+	a.Nil(c.Validate())
+	c.Kafka.Clusters.GroupRoutes = []string{"canary=g.*"}
+	a.EqualError(c.Validate(), "Kafka.Clusters.GroupRoutes entry 'canary=g.*' refers to unknown cluster canary")
+}
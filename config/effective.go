@@ -0,0 +1,96 @@
+package config
+
+import (
+	"gopkg.in/yaml.v2"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	secretType   = reflect.TypeOf(Secret(""))
+)
+
+// EffectiveConfig returns the configuration as YAML in the order of the fields, the durations are given as strings e.g. 1m0s.
+// The secrets are redacted: the Secret fields, the header values, the passwords of the URLs and the plugin parameters
+// and cluster settings whose names end with password, secret or token.
+func (c *Config) EffectiveConfig() ([]byte, error) {
+	return yaml.Marshal(effectiveValue("", reflect.ValueOf(*c)))
+}
+
+func effectiveValue(name string, v reflect.Value) interface{} {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Type() == secretType:
+		return v.Interface().(Secret).String()
+	case v.Kind() == reflect.Struct:
+		fields := make(yaml.MapSlice, 0, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fields = append(fields, yaml.MapItem{Key: field.Name, Value: effectiveValue(field.Name, v.Field(i))})
+		}
+		return fields
+	case v.Kind() == reflect.Slice:
+		items := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if v.Index(i).Kind() == reflect.String {
+				items = append(items, redactListValue(name, v.Index(i).String()))
+				continue
+			}
+			items = append(items, effectiveValue(name, v.Index(i)))
+		}
+		return items
+	case v.Kind() == reflect.String:
+		return redactValue(name, v.String())
+	default:
+		return v.Interface()
+	}
+}
+
+// redactValue redacts the passwords of the URLs
+func redactValue(name string, value string) string {
+	switch {
+	case value == "":
+		return value
+	case strings.HasSuffix(name, "Url") || name == "Endpoint":
+		// ForwardProxy.Url is a comma separated list
+		urls := strings.Split(value, ",")
+		for i, rawUrl := range urls {
+			urls[i] = redactUrl(rawUrl)
+		}
+		return strings.Join(urls, ",")
+	}
+	return value
+}
+
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawUrl
+	}
+	u.User = url.UserPassword(u.User.Username(), redactedValue)
+	return u.String()
+}
+
+// redactListValue redacts the header values (key=value), the secret plugin parameters (--name=value)
+// and the secret cluster settings (name:setting=value)
+func redactListValue(name string, value string) string {
+	switch name {
+	case "Headers":
+		if pair := strings.SplitN(value, "=", 2); len(pair) == 2 {
+			return pair[0] + "=" + redactedValue
+		}
+	case "Parameters", "Settings":
+		return RedactParameter(value)
+	}
+	return value
+}
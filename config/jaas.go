@@ -9,13 +9,15 @@ import (
 )
 
 var (
-	regexUsername = regexp.MustCompile(`(?m:username[[:blank:]]*=[[:blank:]]*"(.*?)")`)
-	regexPassword = regexp.MustCompile(`(?m:password[[:blank:]]*=[[:blank:]]*"(.*?)")`)
+	regexUsername  = regexp.MustCompile(`(?m:username[[:blank:]]*=[[:blank:]]*"(.*?)")`)
+	regexPassword  = regexp.MustCompile(`(?m:password[[:blank:]]*=[[:blank:]]*"(.*?)")`)
+	regexTokenAuth = regexp.MustCompile(`(?m:tokenauth[[:blank:]]*=[[:blank:]]*"?(true|false)"?)`)
 )
 
 type JaasCredentials struct {
-	Username string
-	Password string
+	Username  string
+	Password  string
+	TokenAuth bool // the credentials are the id and the HMAC of a delegation token
 }
 
 func NewJaasCredentialFromFile(filename string) (*JaasCredentials, error) {
@@ -35,7 +37,11 @@ func NewJaasCredentials(s string) (*JaasCredentials, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot retrieve jaas password: %s", err.Error())
 	}
-	return &JaasCredentials{Username: username, Password: password}, nil
+	tokenAuth := false
+	if submatch := regexTokenAuth.FindStringSubmatch(s); submatch != nil {
+		tokenAuth = submatch[1] == "true"
+	}
+	return &JaasCredentials{Username: username, Password: password, TokenAuth: tokenAuth}, nil
 }
 
 func getJaasAttr(submatch [][]string) (string, error) {
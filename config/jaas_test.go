@@ -22,6 +22,23 @@ func TestExtractsJaasCredentials(t *testing.T) {
 	a.Equal("veyaiThai5que0ieb5le", credentials.Password)
 }
 
+func TestExtractsJaasTokenCredentials(t *testing.T) {
+	jaas := `
+		KafkaClient {
+		  org.apache.kafka.common.security.scram.ScramLoginModule required
+		  username="MTIz"
+		  password="aGVsbG8="
+		  tokenauth="true";
+		};
+	`
+	credentials, err := NewJaasCredentials(jaas)
+	a := assert.New(t)
+	a.Nil(err)
+	a.Equal("MTIz", credentials.Username)
+	a.Equal("aGVsbG8=", credentials.Password)
+	a.True(credentials.TokenAuth)
+}
+
 func TestExtractsJaasCredentialsFromFile(t *testing.T) {
 	jaas := `
 		KafkaClient {
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type listenerAuthSetting func(c *Config, value string) error
+
+// auth settings of the listeners named as the global flags, the plugins are configured by the global flags
+var listenerAuthSettings = map[string]listenerAuthSetting{
+	"auth-local-enable": func(c *Config, value string) (err error) {
+		c.Auth.Local.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"auth-local-timeout": func(c *Config, value string) (err error) {
+		c.Auth.Local.Timeout, err = time.ParseDuration(value)
+		return err
+	},
+	"auth-gateway-client-enable": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Client.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"auth-gateway-client-method": func(c *Config, value string) error { c.Auth.Gateway.Client.Method = value; return nil },
+	"auth-gateway-client-magic": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Client.Magic, err = strconv.ParseUint(value, 10, 64)
+		return err
+	},
+	"auth-gateway-client-timeout": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Client.Timeout, err = time.ParseDuration(value)
+		return err
+	},
+	"auth-gateway-server-enable": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Server.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"auth-gateway-server-method": func(c *Config, value string) error { c.Auth.Gateway.Server.Method = value; return nil },
+	"auth-gateway-server-magic": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Server.Magic, err = strconv.ParseUint(value, 10, 64)
+		return err
+	},
+	"auth-gateway-server-timeout": func(c *Config, value string) (err error) {
+		c.Auth.Gateway.Server.Timeout, err = time.ParseDuration(value)
+		return err
+	},
+	"auth-authz-enable": func(c *Config, value string) (err error) {
+		c.Auth.Authz.Enable, err = strconv.ParseBool(value)
+		return err
+	},
+	"auth-authz-timeout": func(c *Config, value string) (err error) {
+		c.Auth.Authz.Timeout, err = time.ParseDuration(value)
+		return err
+	},
+}
+
+// parseListenerAuthSetting parses the listener auth setting given as listener-address,setting=value
+func parseListenerAuthSetting(value string) (listenerAddress string, setting string, settingValue string, err error) {
+	pair := strings.SplitN(value, ",", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return "", "", "", fmt.Errorf("Auth.Listeners entry '%s' must be listener-address,setting=value", value)
+	}
+	kv := strings.SplitN(pair[1], "=", 2)
+	if len(kv) != 2 {
+		return "", "", "", fmt.Errorf("Auth.Listeners entry '%s' must be listener-address,setting=value", value)
+	}
+	if _, ok := listenerAuthSettings[kv[0]]; !ok {
+		return "", "", "", fmt.Errorf("Auth.Listeners entry '%s' has unknown setting %s", value, kv[0])
+	}
+	return pair[0], kv[0], kv[1], nil
+}
+
+// AuthListeners returns the listener addresses with auth settings in the order of the settings
+func (c *Config) AuthListeners() []string {
+	listeners := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, value := range c.Auth.Listeners {
+		listenerAddress, _, _, err := parseListenerAuthSetting(value)
+		if err != nil || seen[listenerAddress] {
+			continue
+		}
+		seen[listenerAddress] = true
+		listeners = append(listeners, listenerAddress)
+	}
+	return listeners
+}
+
+// ListenerAuthConfig returns the configuration of the connections accepted by the listener: the global configuration
+// with the auth settings of the listener. The configuration is validated when the listener has settings.
+func (c *Config) ListenerAuthConfig(listenerAddress string) (*Config, error) {
+	listenerConfig := *c
+	changed := false
+	for _, value := range c.Auth.Listeners {
+		address, setting, settingValue, err := parseListenerAuthSetting(value)
+		if err != nil {
+			return nil, err
+		}
+		if address != listenerAddress {
+			continue
+		}
+		if err = listenerAuthSettings[setting](&listenerConfig, settingValue); err != nil {
+			return nil, fmt.Errorf("Auth.Listeners entry '%s' is invalid: %v", value, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return c, nil
+	}
+	// the settings of the listener are applied already
+	listenerConfig.Auth.Listeners = nil
+	if err := listenerConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("auth configuration of listener %s is invalid: %v", listenerAddress, err)
+	}
+	return &listenerConfig, nil
+}
+
+// AuthEnabled reports whether the auth is enabled by the global flags or by the settings of a listener
+func (c *Config) AuthEnabled(enabled func(c *Config) bool) bool {
+	if enabled(c) {
+		return true
+	}
+	for _, listenerAddress := range c.AuthListeners() {
+		if listenerConfig, err := c.ListenerAuthConfig(listenerAddress); err == nil && enabled(listenerConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateListenerAuth() error {
+	for _, value := range c.Auth.Listeners {
+		if _, _, _, err := parseListenerAuthSetting(value); err != nil {
+			return err
+		}
+	}
+	for _, listenerAddress := range c.AuthListeners() {
+		if _, err := c.ListenerAuthConfig(listenerAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
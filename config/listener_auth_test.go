@@ -0,0 +1,49 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListenerAuthConfig(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	a.Nil(c.InitBootstrapServers([]string{"broker-0:9092,0.0.0.0:32400", "broker-1:9092,0.0.0.0:32401"}))
+	c.Auth.Local.Enable = true
+	c.Auth.Local.Command = "file-auth"
+	c.Auth.Local.Timeout = 10 * time.Second
+	c.Auth.Gateway.Client.Command = "google-id-provider"
+	c.Auth.Listeners = []string{"0.0.0.0:32401,auth-local-enable=false", "0.0.0.0:32401,auth-gateway-client-enable=true", "0.0.0.0:32401,auth-gateway-client-method=google-id", "0.0.0.0:32401,auth-gateway-client-magic=3285573610483682037", "0.0.0.0:32401,auth-gateway-client-timeout=5s"}
+	a.Nil(c.Validate())
+	a.Equal([]string{"0.0.0.0:32401"}, c.AuthListeners())
+
+	defaultConfig, err := c.ListenerAuthConfig("0.0.0.0:32400")
+	a.Nil(err)
+	a.True(defaultConfig == c)
+
+	listenerConfig, err := c.ListenerAuthConfig("0.0.0.0:32401")
+	a.Nil(err)
+	a.False(listenerConfig.Auth.Local.Enable)
+	a.True(listenerConfig.Auth.Gateway.Client.Enable)
+	a.Equal("google-id", listenerConfig.Auth.Gateway.Client.Method)
+	a.Equal(uint64(3285573610483682037), listenerConfig.Auth.Gateway.Client.Magic)
+	a.Equal(5*time.Second, listenerConfig.Auth.Gateway.Client.Timeout)
+	// the global configuration is not changed
+	a.True(c.Auth.Local.Enable)
+	a.False(c.Auth.Gateway.Client.Enable)
+
+	a.True(c.AuthEnabled(func(c *Config) bool { return c.Auth.Local.Enable }))
+	a.True(c.AuthEnabled(func(c *Config) bool { return c.Auth.Gateway.Client.Enable }))
+	a.False(c.AuthEnabled(func(c *Config) bool { return c.Auth.Gateway.Server.Enable }))
+
+	c.Auth.Listeners = []string{"0.0.0.0:32401,auth-gateway-server-enable=true"}
+	a.EqualError(c.Validate(), "auth configuration of listener 0.0.0.0:32401 is invalid: Command, Method and Magic are required when Auth.Gateway.Server.Enable is enabled")
+	c.Auth.Listeners = []string{"0.0.0.0:32401,auth-authz-timeout=1"}
+	a.EqualError(c.Validate(), "Auth.Listeners entry '0.0.0.0:32401,auth-authz-timeout=1' is invalid: time: missing unit in duration \"1\"")
+	c.Auth.Listeners = []string{"0.0.0.0:32401,auth-local-command=other"}
+	a.EqualError(c.Validate(), "Auth.Listeners entry '0.0.0.0:32401,auth-local-command=other' has unknown setting auth-local-command")
+	c.Auth.Listeners = []string{"auth-local-enable=false"}
+	a.EqualError(c.Validate(), "Auth.Listeners entry 'auth-local-enable=false' must be listener-address,setting=value")
+}
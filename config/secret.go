@@ -0,0 +1,58 @@
+package config
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// redactedValue replaces the secrets in the logs, the error messages and the effective configuration
+const redactedValue = "REDACTED"
+
+// the names of the secret plugin parameters and cluster settings
+var secretNamePattern = regexp.MustCompile(`(?i)(password|secret|token)$`)
+
+// Secret is a password, a key passphrase or a token of the configuration. The fmt verbs, the loggers and the YAML and JSON
+// encoders print it redacted, the value is only returned by Value. The empty secret is printed as the empty string.
+type Secret string
+
+// Value returns the secret in plain text
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// GoString redacts the secret printed with %#v
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Set implements pflag.Value, the secrets are set by the string flags
+func (s *Secret) Set(value string) error {
+	*s = Secret(value)
+	return nil
+}
+
+func (s *Secret) Type() string {
+	return "string"
+}
+
+// RedactParameter redacts the value of the plugin parameter (--name=value) or the cluster setting (name:setting=value)
+// whose name ends with password, secret or token
+func RedactParameter(param string) string {
+	pair := strings.SplitN(param, "=", 2)
+	if len(pair) != 2 || !secretNamePattern.MatchString(pair[0]) {
+		return param
+	}
+	return pair[0] + "=" + redactedValue
+}
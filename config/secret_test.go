@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewConfig()
+	c.Kafka.SASL.Username = "alice"
+	c.Kafka.SASL.Password = "alice-secret"
+	c.Vault.Token = "s.token"
+
+	a.Equal("alice-secret", c.Kafka.SASL.Password.Value())
+	a.Equal("REDACTED", fmt.Sprint(c.Kafka.SASL.Password))
+	a.Equal(`REDACTED "REDACTED"`, fmt.Sprintf("%s %q", c.Vault.Token, c.Vault.Token))
+	a.Equal("", fmt.Sprint(c.Kafka.TLS.ClientKeyPassword))
+
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		printed := fmt.Sprintf(format, c.Kafka.SASL)
+		a.Contains(printed, "alice")
+		a.NotContains(printed, "alice-secret", format)
+		a.NotContains(fmt.Sprintf(format, c.Vault), "s.token", format)
+	}
+	data, err := json.Marshal(c.Kafka.SASL)
+	a.Nil(err)
+	a.Contains(string(data), `"Password":"REDACTED"`)
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Var(&c.Kafka.SASL.Password, "sasl-password", "SASL user password")
+	a.Nil(flags.Parse([]string{"--sasl-password", "bob-secret"}))
+	a.Equal("bob-secret", c.Kafka.SASL.Password.Value())
+	a.Equal("REDACTED", flags.Lookup("sasl-password").Value.String())
+
+	a.Equal("--client-secret=REDACTED", RedactParameter("--client-secret=abc"))
+	a.Equal("new:sasl-password=REDACTED", RedactParameter("new:sasl-password=abc"))
+	a.Equal("--audience=kafka", RedactParameter("--audience=kafka"))
+	a.Equal("--token", RedactParameter("--token"))
+
+	c.Kafka.Clusters.Servers = []string{"new=new-0:9092"}
+	c.Kafka.Clusters.Settings = []string{"other:sasl-password=abc"}
+	a.EqualError(c.validateClusters(), "Kafka.Clusters.Settings entry 'other:sasl-password=REDACTED' refers to unknown cluster other")
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// serverMappingFile has the mappings in the form of the bootstrap-server-mapping and external-server-mapping flags, JSON is valid YAML
+type serverMappingFile struct {
+	BootstrapServerMapping []string `yaml:"bootstrap-server-mapping"`
+	ExternalServerMapping  []string `yaml:"external-server-mapping"`
+}
+
+func LoadServerMappingFile(filename string) (bootstrapServers []ListenerConfig, externalServers []ListenerConfig, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	bootstrapServers, externalServers, err = parseServerMappingFile(data)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid server mapping file %s", filename)
+	}
+	return bootstrapServers, externalServers, nil
+}
+
+func parseServerMappingFile(data []byte) (bootstrapServers []ListenerConfig, externalServers []ListenerConfig, err error) {
+	mappingFile := &serverMappingFile{}
+	if err = yaml.UnmarshalStrict(data, mappingFile); err != nil {
+		return nil, nil, err
+	}
+	if bootstrapServers, err = getListenerConfigs(mappingFile.BootstrapServerMapping); err != nil {
+		return nil, nil, err
+	}
+	if externalServers, err = getListenerConfigs(mappingFile.ExternalServerMapping); err != nil {
+		return nil, nil, err
+	}
+	return bootstrapServers, externalServers, nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseServerMappingFile(t *testing.T) {
+	a := assert.New(t)
+
+	bootstrapServers, externalServers, err := parseServerMappingFile([]byte(`
+bootstrap-server-mapping:
+  - kafka-0:9092,127.0.0.1:32400
+  - kafka-1:9092,0.0.0.0:32401,proxy-1:32401
+external-server-mapping:
+  - kafka-2:9092,proxy-2:32402
+`))
+	a.Nil(err)
+	a.Equal([]ListenerConfig{
+		{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:32400", AdvertisedAddress: "127.0.0.1:32400"},
+		{BrokerAddress: "kafka-1:9092", ListenerAddress: "0.0.0.0:32401", AdvertisedAddress: "proxy-1:32401"},
+	}, bootstrapServers)
+	a.Equal([]ListenerConfig{{BrokerAddress: "kafka-2:9092", ListenerAddress: "proxy-2:32402", AdvertisedAddress: "proxy-2:32402"}}, externalServers)
+
+	bootstrapServers, externalServers, err = parseServerMappingFile([]byte("{\n\t\"bootstrap-server-mapping\": [\"kafka-0:9092,127.0.0.1:32400\"]\n}"))
+	a.Nil(err)
+	a.Equal([]ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:32400", AdvertisedAddress: "127.0.0.1:32400"}}, bootstrapServers)
+	a.Empty(externalServers)
+
+	_, _, err = parseServerMappingFile([]byte("bootstrap-server-mappings: []"))
+	a.NotNil(err)
+	_, _, err = parseServerMappingFile([]byte("bootstrap-server-mapping: [kafka-0:9092]"))
+	a.EqualError(err, "server-mapping must be in form 'remotehost:remoteport,localhost:localport(,advhost:advport)'")
+}
+
+func TestLoadServerMappingFileNotFound(t *testing.T) {
+	a := assert.New(t)
+
+	_, _, err := LoadServerMappingFile("/nonexistent/server-mapping.yaml")
+	a.NotNil(err)
+}
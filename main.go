@@ -20,6 +20,9 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(server.Server)
+	RootCmd.AddCommand(server.Check)
+	RootCmd.AddCommand(server.Config)
+	RootCmd.AddCommand(server.HealthCheck)
 	RootCmd.AddCommand(server.Version)
 	RootCmd.AddCommand(tools.Tools)
 }
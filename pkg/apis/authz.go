@@ -0,0 +1,33 @@
+package apis
+
+import (
+	"context"
+)
+
+type AuthorizeRequest struct {
+	// Principal is the user name authenticated by the local SASL, empty when local SASL is disabled
+	Principal     string
+	ClientAddress string
+	ClientID      string
+	ApiKey        int32
+	ApiVersion    int32
+	Topics        []string
+	// TopicsUnknown is set when the topics of the request could not be decoded or the request refers to all topics
+	TopicsUnknown bool
+}
+
+type AuthorizeResponse struct {
+	Allowed bool
+	// Status is the Kafka error code returned to the client for a denied request. When zero, TOPIC_AUTHORIZATION_FAILED (29)
+	// is returned for requests with topics and CLUSTER_AUTHORIZATION_FAILED (31) for other requests
+	Status int32
+}
+
+type RequestAuthorizer interface {
+	// Authorize decides whether the Kafka request can be forwarded to the broker. The returned error is only used by the underlying rpc protocol
+	Authorize(ctx context.Context, request AuthorizeRequest) (AuthorizeResponse, error)
+}
+
+type RequestAuthorizerFactory interface {
+	New(params []string) (RequestAuthorizer, error)
+}
@@ -0,0 +1,24 @@
+package apis
+
+import "fmt"
+
+type FrameFilter interface {
+	// FilterRequest returns the request (without the size field) which is sent to the broker. ApiKey, ApiVersion and CorrelationId must not be changed
+	FilterRequest(request []byte) ([]byte, error)
+	// FilterResponse returns the response body (without the size and CorrelationId fields) which is sent to the client
+	FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error)
+}
+
+type FrameFilterFactory interface {
+	New(params []string) (FrameFilter, error)
+}
+
+// FrameRejection is returned by FilterRequest to answer the request with the Kafka error code instead of sending it to the broker
+type FrameRejection struct {
+	ErrorCode int16
+	Reason    string
+}
+
+func (r *FrameRejection) Error() string {
+	return fmt.Sprintf("request rejected with error code %d: %s", r.ErrorCode, r.Reason)
+}
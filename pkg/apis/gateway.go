@@ -25,11 +25,15 @@ type TokenProviderFactory interface {
 type VerifyRequest struct {
 	Token  string
 	Params []string
+	// AuthContext describes the client connection, it is empty for the calls of the plugins by older proxy versions
+	AuthContext AuthContext
 }
 
 type VerifyResponse struct {
 	Success bool
 	Status  int32
+	// ExpiresAt is the expiry of the token in unix seconds, 0 if unknown. It is 0 when returned by older plugins
+	ExpiresAt int64
 }
 
 type TokenInfo interface {
@@ -0,0 +1,16 @@
+package apis
+
+import (
+	"context"
+)
+
+type KeyManagementService interface {
+	// GenerateDataKey returns a new data key in plaintext and encrypted with the master key identified by keyID
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext []byte, ciphertext []byte, err error)
+	// DecryptDataKey returns the plaintext of the data key encrypted with the master key identified by keyID
+	DecryptDataKey(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+type KeyManagementServiceFactory interface {
+	New(params []string) (KeyManagementService, error)
+}
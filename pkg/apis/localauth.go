@@ -4,6 +4,25 @@ type PasswordAuthenticator interface {
 	Authenticate(username, password string) (bool, int32, error)
 }
 
+// AuthContext describes the client connection which is authenticated
+type AuthContext struct {
+	// ClientAddress is the host:port of the client
+	ClientAddress string
+	// ListenerAddress is the local address of the proxy listener which accepted the connection
+	ListenerAddress string
+	// ServerName is the SNI requested by the TLS client, empty without TLS
+	ServerName string
+	// ClientCertSubject is the subject of the verified TLS client certificate, empty when the client did not present a certificate
+	ClientCertSubject string
+}
+
+// ContextPasswordAuthenticator is implemented by the password authenticators which decide based on the client connection
+// e.g. IP allowlists or a tenant per listener. The authenticators implementing only PasswordAuthenticator are called without the context.
+type ContextPasswordAuthenticator interface {
+	PasswordAuthenticator
+	AuthenticateWithContext(authContext AuthContext, username, password string) (bool, int32, error)
+}
+
 type PasswordAuthenticatorFactory interface {
 	New(params []string) (PasswordAuthenticator, error)
 }
@@ -0,0 +1,24 @@
+package apis
+
+import (
+	"context"
+)
+
+type SignRequest struct {
+	// KeyType is the type of the public key of the certificate: RSA or ECDSA
+	KeyType string
+	// Hash is the hash function of the digest: SHA-256, SHA-384 or SHA-512
+	Hash string
+	// PSS requests the RSASSA-PSS signature with the salt length equal to the hash length, RSASSA-PKCS1-v1_5 otherwise
+	PSS    bool
+	Digest []byte
+}
+
+type KeySigner interface {
+	// Sign signs the digest with the private key held by the signer e.g. in an HSM or a cloud KMS. The ECDSA signature is ASN.1 DER encoded
+	Sign(ctx context.Context, request SignRequest) ([]byte, error)
+}
+
+type KeySignerFactory interface {
+	New(params []string) (KeySigner, error)
+}
@@ -0,0 +1,38 @@
+package clientidfilter
+
+import (
+	"flag"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.FrameFilterFactory))
+	registry.Register(new(Factory), "client-id")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("client-id filter settings", flag.ContinueOnError)
+	return fs
+}
+
+type pluginMeta struct {
+	prefix   string
+	clientID string
+}
+
+type Factory struct {
+}
+
+// New implements apis.FrameFilterFactory
+func (t *Factory) New(params []string) (apis.FrameFilter, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	fs.StringVar(&pluginMeta.prefix, "prefix", "", "Prefix added to the client id of every request")
+	fs.StringVar(&pluginMeta.clientID, "client-id", "", "Client id replacing the one sent by the client")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewClientIDFilter(pluginMeta.prefix, pluginMeta.clientID)
+}
@@ -0,0 +1,58 @@
+package clientidfilter
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math"
+)
+
+// ClientIDFilter rewrites the client id in the request header e.g. to tag the requests of a tenant.
+type ClientIDFilter struct {
+	prefix   string
+	clientID string
+}
+
+func NewClientIDFilter(prefix string, clientID string) (*ClientIDFilter, error) {
+	if prefix == "" && clientID == "" {
+		return nil, errors.New("prefix or client-id parameter is required")
+	}
+	if len(prefix)+len(clientID) > math.MaxInt16 {
+		return nil, errors.New("client id is too long")
+	}
+	return &ClientIDFilter{prefix: prefix, clientID: clientID}, nil
+}
+
+// FilterRequest implements apis.FrameFilter
+func (f *ClientIDFilter) FilterRequest(request []byte) ([]byte, error) {
+	// ApiKey => int16, ApiVersion => int16, CorrelationId => int32, ClientId => nullable string
+	if len(request) < 10 {
+		return nil, errors.Errorf("request of length %d is too short", len(request))
+	}
+	offset := 10
+	clientID := ""
+	if length := int16(binary.BigEndian.Uint16(request[8:])); length > 0 {
+		offset += int(length)
+		if offset > len(request) {
+			return nil, errors.Errorf("client id of length %d exceeds the request", length)
+		}
+		clientID = string(request[10:offset])
+	}
+	if f.clientID != "" {
+		clientID = f.clientID
+	}
+	clientID = f.prefix + clientID
+	if len(clientID) > math.MaxInt16 {
+		return nil, errors.Errorf("client id of length %d is too long", len(clientID))
+	}
+
+	result := make([]byte, 0, 10+len(clientID)+len(request)-offset)
+	result = append(result, request[:8]...)
+	result = append(result, byte(len(clientID)>>8), byte(len(clientID)))
+	result = append(result, clientID...)
+	return append(result, request[offset:]...), nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (f *ClientIDFilter) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	return response, nil
+}
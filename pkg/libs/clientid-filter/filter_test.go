@@ -0,0 +1,42 @@
+package clientidfilter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Metadata v0 request with client id "c" and topic "t1"
+var testRequest = []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'}
+
+func TestFilterRequestPrefix(t *testing.T) {
+	a := assert.New(t)
+
+	filter, err := NewClientIDFilter("tenant-", "")
+	a.Nil(err)
+	request, err := filter.FilterRequest(testRequest)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x08, 't', 'e', 'n', 'a', 'n', 't', '-', 'c', 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'}, request)
+
+	// null client id
+	request, err = filter.FilterRequest([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x07, 't', 'e', 'n', 'a', 'n', 't', '-'}, request)
+}
+
+func TestFilterRequestClientID(t *testing.T) {
+	a := assert.New(t)
+
+	filter, err := new(Factory).New([]string{"--client-id", "ab"})
+	a.Nil(err)
+	request, err := filter.FilterRequest(testRequest)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x02, 'a', 'b', 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'}, request)
+
+	_, err = filter.FilterRequest(testRequest[:9])
+	a.NotNil(err)
+	_, err = filter.FilterRequest([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x05, 'c'})
+	a.NotNil(err)
+
+	_, err = new(Factory).New([]string{})
+	a.NotNil(err)
+}
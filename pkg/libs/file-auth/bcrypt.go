@@ -0,0 +1,91 @@
+package fileauth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/blowfish"
+	"strconv"
+	"strings"
+)
+
+const (
+	bcryptMinCost = 4
+	bcryptMaxCost = 31
+	bcryptSaltLen = 22
+	bcryptHashLen = 31
+)
+
+var (
+	bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+	bcryptMagicCipherData = []byte("OrpheanBeholderScryDoubt")
+)
+
+// bcryptCredential is the $2a$, $2b$ or $2y$ bcrypt hash e.g. created with htpasswd -nbB
+type bcryptCredential struct {
+	cost int
+	salt []byte
+	hash []byte
+}
+
+func parseBcryptCredential(value string) (*bcryptCredential, error) {
+	// $2y$10$<22 chars of salt><31 chars of hash>
+	parts := strings.Split(value, "$")
+	if len(parts) != 4 || parts[0] != "" {
+		return nil, fmt.Errorf("invalid bcrypt hash format")
+	}
+	switch parts[1] {
+	case "2a", "2b", "2y":
+	default:
+		return nil, fmt.Errorf("unsupported bcrypt version %s", parts[1])
+	}
+	cost, err := strconv.Atoi(parts[2])
+	if err != nil || cost < bcryptMinCost || cost > bcryptMaxCost {
+		return nil, fmt.Errorf("invalid bcrypt cost %s", parts[2])
+	}
+	if len(parts[3]) != bcryptSaltLen+bcryptHashLen {
+		return nil, fmt.Errorf("invalid bcrypt hash length")
+	}
+	salt, err := bcryptEncoding.DecodeString(parts[3][:bcryptSaltLen])
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt salt: %v", err)
+	}
+	hash, err := bcryptEncoding.DecodeString(parts[3][bcryptSaltLen:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt hash: %v", err)
+	}
+	return &bcryptCredential{cost: cost, salt: salt, hash: hash}, nil
+}
+
+func (c *bcryptCredential) verify(password string) bool {
+	hash, err := bcrypt([]byte(password), c.cost, c.salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, c.hash) == 1
+}
+
+// bcrypt returns the first 23 bytes of the encrypted magic cipher data, as the hash is encoded with 31 characters
+func bcrypt(password []byte, cost int, salt []byte) ([]byte, error) {
+	// the key includes the NUL terminator, only the first 72 bytes are used by the key expansion
+	key := make([]byte, len(password)+1)
+	copy(key, password)
+
+	c, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(salt, c)
+	}
+	cipherData := make([]byte, len(bcryptMagicCipherData))
+	copy(cipherData, bcryptMagicCipherData)
+	for i := 0; i < len(cipherData); i += blowfish.BlockSize {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(cipherData[i:i+blowfish.BlockSize], cipherData[i:i+blowfish.BlockSize])
+		}
+	}
+	return cipherData[:len(cipherData)-1], nil
+}
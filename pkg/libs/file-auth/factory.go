@@ -0,0 +1,41 @@
+package fileauth
+
+import (
+	"flag"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.PasswordAuthenticatorFactory))
+	registry.Register(new(Factory), "file-auth")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("file auth settings", flag.ContinueOnError)
+
+	fs.StringVar(&f.file, "file", "", "Credentials file with lines username:credential. The credential is a bcrypt hash (eg: htpasswd -nbB) or a SCRAM credential SCRAM-SHA-256=[salt=...,stored_key=...,server_key=...,iterations=...]")
+	fs.BoolVar(&f.watch, "watch", true, "Reload the credentials file on change")
+	return fs
+}
+
+type pluginMeta struct {
+	file  string
+	watch bool
+}
+
+type Factory struct {
+}
+
+// New implements apis.PasswordAuthenticatorFactory
+func (t *Factory) New(params []string) (apis.PasswordAuthenticator, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewFileAuthenticator(FileAuthenticatorOptions{
+		File:  pluginMeta.file,
+		Watch: pluginMeta.watch,
+	})
+}
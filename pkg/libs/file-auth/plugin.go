@@ -0,0 +1,140 @@
+package fileauth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const (
+	StatusOK = 0 // also returned for unknown users and invalid credentials
+)
+
+type FileAuthenticatorOptions struct {
+	File  string
+	Watch bool
+}
+
+type credential interface {
+	verify(password string) bool
+}
+
+// FileAuthenticator authenticates the users with the bcrypt hashes or the SCRAM credentials of the credentials file.
+// The file is reloaded on change, the credentials are kept if the changed file is invalid.
+type FileAuthenticator struct {
+	file        string
+	credentials map[string]credential
+	l           sync.RWMutex
+
+	done chan bool
+}
+
+func NewFileAuthenticator(options FileAuthenticatorOptions) (*FileAuthenticator, error) {
+	if options.File == "" {
+		return nil, errors.New("parameter file is required")
+	}
+	credentials, err := loadCredentials(options.File)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("loaded %d users from credentials file %s", len(credentials), options.File)
+
+	pa := &FileAuthenticator{
+		file:        options.File,
+		credentials: credentials,
+		done:        make(chan bool, 1),
+	}
+	if options.Watch {
+		if err = util.WatchForUpdates(options.File, pa.done, pa.reload); err != nil {
+			return nil, errors.Wrap(err, "cannot watch credentials file")
+		}
+	}
+	return pa, nil
+}
+
+func (pa *FileAuthenticator) reload() {
+	credentials, err := loadCredentials(pa.file)
+	if err != nil {
+		logrus.Errorf("error while reloading credentials file: %v", err)
+		return
+	}
+	logrus.Infof("reloaded %d users from credentials file %s", len(credentials), pa.file)
+	pa.l.Lock()
+	defer pa.l.Unlock()
+	pa.credentials = credentials
+}
+
+// Authenticate implements apis.PasswordAuthenticator
+func (pa *FileAuthenticator) Authenticate(username, password string) (bool, int32, error) {
+	pa.l.RLock()
+	credential, ok := pa.credentials[username]
+	pa.l.RUnlock()
+
+	if !ok {
+		logrus.Errorf("user %s is unknown", username)
+		return false, StatusOK, nil
+	}
+	if !credential.verify(password) {
+		logrus.Errorf("user %s credentials are invalid", username)
+		return false, StatusOK, nil
+	}
+	return true, StatusOK, nil
+}
+
+func loadCredentials(filename string) (map[string]credential, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := parseCredentials(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid credentials file %s", filename)
+	}
+	return credentials, nil
+}
+
+// parseCredentials parses the lines username:credential, empty lines and lines starting with # are skipped
+func parseCredentials(data []byte) (map[string]credential, error) {
+	credentials := make(map[string]credential)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pair := strings.SplitN(line, ":", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("line %d must be username:credential", n)
+		}
+		if _, ok := credentials[pair[0]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %s", n, pair[0])
+		}
+		credential, err := parseCredential(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		credentials[pair[0]] = credential
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// parseCredential parses the bcrypt hash or the SCRAM credential SCRAM-SHA-256=[salt=...,stored_key=...,server_key=...,iterations=...]
+func parseCredential(value string) (credential, error) {
+	if strings.HasPrefix(value, "$") {
+		return parseBcryptCredential(value)
+	}
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) == 2 && strings.HasPrefix(pair[0], "SCRAM-") && strings.HasPrefix(pair[1], "[") && strings.HasSuffix(pair[1], "]") {
+		return parseSCRAMCredential(pair[0], pair[1][1:len(pair[1])-1])
+	}
+	return nil, errors.New("credential must be a bcrypt hash or a SCRAM credential")
+}
@@ -0,0 +1,136 @@
+package fileauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testSCRAMCredential(password string, salt []byte, iterations int) string {
+	saltedPassword := util.PBKDF2(sha256.New, []byte(password), salt, iterations)
+	keyOf := func(name string) []byte {
+		mac := hmac.New(sha256.New, saltedPassword)
+		mac.Write([]byte(name))
+		return mac.Sum(nil)
+	}
+	storedKey := sha256.Sum256(keyOf("Client Key"))
+	return "SCRAM-SHA-256=[salt=" + base64.StdEncoding.EncodeToString(salt) + ",stored_key=" + base64.StdEncoding.EncodeToString(storedKey[:]) +
+		",server_key=" + base64.StdEncoding.EncodeToString(keyOf("Server Key")) + ",iterations=" + strconv.Itoa(iterations) + "]"
+}
+
+func TestBcryptCredential(t *testing.T) {
+	a := assert.New(t)
+
+	// test vectors of https://www.openwall.com/crypt/
+	for password, hash := range map[string]string{
+		"U*U":  "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"U*U*": "$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK",
+		"":     "$2a$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy",
+	} {
+		c, err := parseBcryptCredential(hash)
+		a.Nil(err)
+		a.True(c.verify(password), password)
+		a.False(c.verify(password+"U"), password)
+	}
+
+	_, err := parseBcryptCredential("$1$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW")
+	a.EqualError(err, "unsupported bcrypt version 1")
+	_, err = parseBcryptCredential("$2a$3$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW")
+	a.EqualError(err, "invalid bcrypt cost 3")
+	_, err = parseBcryptCredential("$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOe")
+	a.EqualError(err, "invalid bcrypt hash length")
+}
+
+func TestSCRAMCredential(t *testing.T) {
+	a := assert.New(t)
+
+	value := testSCRAMCredential("secret", []byte("salt-of-alice"), 4096)
+	c, err := parseCredential(value)
+	a.Nil(err)
+	a.True(c.verify("secret"))
+	a.False(c.verify("wrong"))
+
+	_, err = parseCredential("SCRAM-SHA-1=[salt=c2FsdA==,stored_key=c2FsdA==,iterations=4096]")
+	a.EqualError(err, "unsupported SCRAM mechanism SCRAM-SHA-1")
+	_, err = parseCredential("SCRAM-SHA-256=[salt=c2FsdA==,stored_key=c2FsdA==,iterations=1024]")
+	a.EqualError(err, "SCRAM iterations must be at least 4096")
+	_, err = parseCredential("SCRAM-SHA-256=[salt=c2FsdA==,iterations=4096]")
+	a.EqualError(err, "SCRAM attributes salt and stored_key are required")
+	_, err = parseCredential("secret")
+	a.EqualError(err, "credential must be a bcrypt hash or a SCRAM credential")
+}
+
+func TestParseCredentials(t *testing.T) {
+	a := assert.New(t)
+
+	credentials, err := parseCredentials([]byte("# users\n\nalice:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW\n bob:" + testSCRAMCredential("secret", []byte("salt"), 4096) + " \n"))
+	a.Nil(err)
+	a.Len(credentials, 2)
+	a.IsType(&bcryptCredential{}, credentials["alice"])
+	a.IsType(&scramCredential{}, credentials["bob"])
+
+	_, err = parseCredentials([]byte("alice"))
+	a.EqualError(err, "line 1 must be username:credential")
+	_, err = parseCredentials([]byte("# users\nalice:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW\nalice:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"))
+	a.EqualError(err, "line 3: duplicate user alice")
+	_, err = parseCredentials([]byte("alice:$2a$05$CCC"))
+	a.EqualError(err, "line 1: invalid bcrypt hash length")
+}
+
+func TestFileAuthenticatorReload(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "file-auth")
+	a.Nil(err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("alice:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW\n")
+	a.Nil(err)
+	a.Nil(file.Close())
+
+	pa, err := NewFileAuthenticator(FileAuthenticatorOptions{File: file.Name(), Watch: true})
+	a.Nil(err)
+	defer close(pa.done)
+
+	ok, status, err := pa.Authenticate("alice", "U*U")
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(int32(StatusOK), status)
+	ok, _, err = pa.Authenticate("bob", "secret")
+	a.Nil(err)
+	a.False(ok)
+
+	// the invalid file is ignored
+	a.Nil(ioutil.WriteFile(file.Name(), []byte("alice\n"), 0600))
+	time.Sleep(200 * time.Millisecond)
+	ok, _, err = pa.Authenticate("alice", "U*U")
+	a.Nil(err)
+	a.True(ok)
+
+	a.Nil(ioutil.WriteFile(file.Name(), []byte("bob:"+testSCRAMCredential("secret", []byte("salt"), 4096)+"\n"), 0600))
+	for i := 0; i < 100; i++ {
+		if ok, _, _ = pa.Authenticate("bob", "secret"); ok {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	a.True(ok)
+	ok, _, err = pa.Authenticate("alice", "U*U")
+	a.Nil(err)
+	a.False(ok)
+}
+
+func TestNewFileAuthenticatorOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewFileAuthenticator(FileAuthenticatorOptions{})
+	a.EqualError(err, "parameter file is required")
+	_, err = NewFileAuthenticator(FileAuthenticatorOptions{File: "/nonexistent/credentials"})
+	a.EqualError(err, "open /nonexistent/credentials: no such file or directory")
+}
@@ -0,0 +1,77 @@
+package fileauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	scramMinIterations = 4096
+)
+
+// scramCredential is the SCRAM credential in the format used by Kafka (salt=...,stored_key=...,server_key=...,iterations=...).
+// The password is verified with the stored key, the server key is not needed.
+type scramCredential struct {
+	hash       func() hash.Hash
+	salt       []byte
+	storedKey  []byte
+	iterations int
+}
+
+func parseSCRAMCredential(mechanism string, value string) (*scramCredential, error) {
+	c := &scramCredential{}
+	switch mechanism {
+	case "SCRAM-SHA-256":
+		c.hash = sha256.New
+	case "SCRAM-SHA-512":
+		c.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism %s", mechanism)
+	}
+	for _, attr := range strings.Split(value, ",") {
+		pair := strings.SplitN(attr, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("SCRAM attribute '%s' must be name=value", attr)
+		}
+		var err error
+		switch pair[0] {
+		case "salt":
+			c.salt, err = base64.StdEncoding.DecodeString(pair[1])
+		case "stored_key":
+			c.storedKey, err = base64.StdEncoding.DecodeString(pair[1])
+		case "server_key":
+			_, err = base64.StdEncoding.DecodeString(pair[1])
+		case "iterations":
+			c.iterations, err = strconv.Atoi(pair[1])
+		default:
+			err = fmt.Errorf("unknown attribute")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCRAM attribute %s: %v", pair[0], err)
+		}
+	}
+	if len(c.salt) == 0 || len(c.storedKey) == 0 {
+		return nil, fmt.Errorf("SCRAM attributes salt and stored_key are required")
+	}
+	if c.iterations < scramMinIterations {
+		return nil, fmt.Errorf("SCRAM iterations must be at least %d", scramMinIterations)
+	}
+	return c, nil
+}
+
+// verify computes the stored key of the password (https://tools.ietf.org/html/rfc5802#section-3)
+func (c *scramCredential) verify(password string) bool {
+	saltedPassword := util.PBKDF2(c.hash, []byte(password), c.salt, c.iterations)
+	mac := hmac.New(c.hash, saltedPassword)
+	mac.Write([]byte("Client Key"))
+	h := c.hash()
+	h.Write(mac.Sum(nil))
+	return hmac.Equal(h.Sum(nil), c.storedKey)
+}
@@ -190,7 +190,7 @@ func (p *TokenInfo) VerifyToken(parent context.Context, request apis.VerifyReque
 	if err != nil {
 		return getVerifyResponseResponse(StatusWrongSignature)
 	}
-	return apis.VerifyResponse{Success: true}, nil
+	return apis.VerifyResponse{Success: true, ExpiresAt: token.ClaimSet.Exp}, nil
 }
 
 func (p *TokenInfo) checkEmail(email string) bool {
@@ -0,0 +1,129 @@
+package kms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awsService     = "kms"
+	awsContentType = "application/x-amz-json-1.1"
+	awsDateFormat  = "20060102T150405Z"
+)
+
+type AWSOptions struct {
+	Region          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSKMS generates and decrypts the data keys with AWS KMS, the key id is the id, ARN or alias of the KMS key.
+type AWSKMS struct {
+	options AWSOptions
+	client  *http.Client
+}
+
+type awsDataKeyResponse struct {
+	CiphertextBlob []byte
+	Plaintext      []byte
+}
+
+func NewAWSKMS(options AWSOptions) (*AWSKMS, error) {
+	if options.Region == "" {
+		return nil, errors.New("region parameter is required")
+	}
+	if options.AccessKeyID == "" || options.SecretAccessKey == "" {
+		return nil, errors.New("access-key-id and secret-access-key parameters are required")
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", options.Region)
+	}
+	return &AWSKMS{options: options, client: &http.Client{}}, nil
+}
+
+func (k *AWSKMS) post(ctx context.Context, target string, body interface{}, result interface{}) error {
+	return postJSON(ctx, k.client, k.options.Endpoint+"/", awsContentType, body, result, func(req *http.Request, payload []byte) error {
+		req.Header.Set("X-Amz-Target", "TrentService."+target)
+		if k.options.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", k.options.SessionToken)
+		}
+		signV4(req, payload, k.options.AccessKeyID, k.options.SecretAccessKey, k.options.Region, awsService, time.Now())
+		return nil
+	})
+}
+
+// GenerateDataKey implements apis.KeyManagementService
+func (k *AWSKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	result := &awsDataKeyResponse{}
+	err := k.post(ctx, "GenerateDataKey", map[string]interface{}{"KeyId": keyID, "KeySpec": "AES_256"}, result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Plaintext, result.CiphertextBlob, nil
+}
+
+// DecryptDataKey implements apis.KeyManagementService
+func (k *AWSKMS) DecryptDataKey(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	result := &awsDataKeyResponse{}
+	err := k.post(ctx, "Decrypt", map[string]interface{}{"KeyId": keyID, "CiphertextBlob": ciphertext}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
+
+// signV4 adds the X-Amz-Date and Authorization headers of the AWS Signature Version 4, all request headers are signed
+func signV4(req *http.Request, payload []byte, accessKeyID string, secretAccessKey string, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format(awsDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, value := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, value)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
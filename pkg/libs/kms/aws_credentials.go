@@ -0,0 +1,142 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAWSMetadataEndpoint  = "http://169.254.169.254"
+	defaultAWSContainerEndpoint = "http://169.254.170.2"
+	// the temporary credentials and the access tokens are fetched again before they expire
+	credentialsRefreshBefore = 5 * time.Minute
+)
+
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// awsCredentialsProvider returns the credentials of the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables,
+// of the ECS task role or of the EC2 instance profile (IMDSv2). The temporary credentials are fetched again before they expire.
+type awsCredentialsProvider struct {
+	client            *http.Client
+	metadataEndpoint  string
+	containerEndpoint string
+
+	mu          sync.Mutex
+	credentials *awsCredentials
+}
+
+func newAWSCredentialsProvider(client *http.Client) *awsCredentialsProvider {
+	return &awsCredentialsProvider{client: client, metadataEndpoint: defaultAWSMetadataEndpoint, containerEndpoint: defaultAWSContainerEndpoint}
+}
+
+func (p *awsCredentialsProvider) get(ctx context.Context) (*awsCredentials, error) {
+	if accessKeyID, secretAccessKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); accessKeyID != "" && secretAccessKey != "" {
+		return &awsCredentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.credentials != nil && time.Now().Add(credentialsRefreshBefore).Before(p.credentials.Expiration) {
+		return p.credentials, nil
+	}
+	var (
+		credentials *awsCredentials
+		err         error
+	)
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		credentials, err = p.containerCredentials(ctx)
+	} else {
+		credentials, err = p.instanceCredentials(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "AWS credentials")
+	}
+	p.credentials = credentials
+	return credentials, nil
+}
+
+func (p *awsCredentialsProvider) containerCredentials(ctx context.Context) (*awsCredentials, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if url == "" {
+		url = p.containerEndpoint + os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	}
+	header := http.Header{}
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authorization = strings.TrimSpace(string(data))
+	}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+	credentials := &awsCredentials{}
+	if err := getJSON(ctx, p.client, url, header, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (p *awsCredentialsProvider) instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	req, err := http.NewRequest(http.MethodPut, p.metadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	token, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata token request failed with status %d", resp.StatusCode)
+	}
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token", string(token))
+
+	roleURL := p.metadataEndpoint + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequest(http.MethodGet, roleURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header = header
+	resp, err = p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	roles, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if resp.StatusCode != http.StatusOK || role == "" {
+		return nil, fmt.Errorf("instance profile role request failed with status %d", resp.StatusCode)
+	}
+	credentials := &awsCredentials{}
+	if err = getJSON(ctx, p.client, roleURL+role, header, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
@@ -0,0 +1,139 @@
+package kms
+
+import (
+	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+	"os"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.KeyManagementServiceFactory))
+	registry.Register(new(StaticFactory), "static-kms")
+	registry.Register(new(VaultFactory), "vault-transit")
+	registry.Register(new(AWSFactory), "aws-kms")
+	registry.Register(new(GCPFactory), "gcp-kms")
+
+	registry.NewComponentInterface(new(apis.KeySignerFactory))
+	registry.Register(new(AWSSignerFactory), "aws-kms")
+	registry.Register(new(GCPSignerFactory), "gcp-kms")
+}
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return fmt.Sprintf("%v", *i)
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+type StaticFactory struct {
+}
+
+// New implements apis.KeyManagementServiceFactory
+func (t *StaticFactory) New(params []string) (apis.KeyManagementService, error) {
+	var keyFiles arrayFlags
+	fs := flag.NewFlagSet("static kms settings", flag.ContinueOnError)
+	fs.Var(&keyFiles, "key-file", "Master key given as key-id=path, the file contains 32 base64 encoded bytes")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewStaticKMS(keyFiles)
+}
+
+type VaultFactory struct {
+}
+
+// New implements apis.KeyManagementServiceFactory
+func (t *VaultFactory) New(params []string) (apis.KeyManagementService, error) {
+	options := VaultOptions{}
+	fs := flag.NewFlagSet("vault transit settings", flag.ContinueOnError)
+	fs.StringVar(&options.Address, "address", os.Getenv("VAULT_ADDR"), "Address of the Vault server")
+	fs.StringVar(&options.Token, "token", os.Getenv("VAULT_TOKEN"), "Vault token")
+	fs.StringVar(&options.TokenFile, "token-file", "", "Location of the file with the Vault token")
+	fs.StringVar(&options.Mount, "mount", "transit", "Mount path of the transit secrets engine")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewVaultKMS(options)
+}
+
+type AWSFactory struct {
+}
+
+// New implements apis.KeyManagementServiceFactory
+func (t *AWSFactory) New(params []string) (apis.KeyManagementService, error) {
+	options := AWSOptions{}
+	fs := flag.NewFlagSet("aws kms settings", flag.ContinueOnError)
+	fs.StringVar(&options.Region, "region", os.Getenv("AWS_REGION"), "AWS region")
+	fs.StringVar(&options.Endpoint, "endpoint", "", "KMS endpoint, default https://kms.<region>.amazonaws.com")
+	fs.StringVar(&options.AccessKeyID, "access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key id")
+	fs.StringVar(&options.SecretAccessKey, "secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
+	fs.StringVar(&options.SessionToken, "session-token", os.Getenv("AWS_SESSION_TOKEN"), "AWS session token")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewAWSKMS(options)
+}
+
+type GCPFactory struct {
+}
+
+// New implements apis.KeyManagementServiceFactory
+func (t *GCPFactory) New(params []string) (apis.KeyManagementService, error) {
+	options := GCPOptions{}
+	fs := flag.NewFlagSet("gcp kms settings", flag.ContinueOnError)
+	fs.StringVar(&options.Endpoint, "endpoint", defaultGCPEndpoint, "Cloud KMS endpoint")
+	fs.StringVar(&options.CredentialsFile, "credentials-file", "", "Location of the JSON file with the service account credentials, Google Application Default Credentials are used when not set")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewGCPKMS(options)
+}
+
+type AWSSignerFactory struct {
+}
+
+// New implements apis.KeySignerFactory
+func (t *AWSSignerFactory) New(params []string) (apis.KeySigner, error) {
+	options := AWSOptions{}
+	var keyID string
+	fs := flag.NewFlagSet("aws kms signer settings", flag.ContinueOnError)
+	fs.StringVar(&options.Region, "region", os.Getenv("AWS_REGION"), "AWS region")
+	fs.StringVar(&options.Endpoint, "endpoint", "", "KMS endpoint, default https://kms.<region>.amazonaws.com")
+	fs.StringVar(&options.AccessKeyID, "access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key id")
+	fs.StringVar(&options.SecretAccessKey, "secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key")
+	fs.StringVar(&options.SessionToken, "session-token", os.Getenv("AWS_SESSION_TOKEN"), "AWS session token")
+	fs.StringVar(&keyID, "key-id", "", "Id, ARN or alias of the asymmetric KMS key")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewAWSSigner(options, keyID)
+}
+
+type GCPSignerFactory struct {
+}
+
+// New implements apis.KeySignerFactory
+func (t *GCPSignerFactory) New(params []string) (apis.KeySigner, error) {
+	options := GCPOptions{}
+	var keyVersion string
+	fs := flag.NewFlagSet("gcp kms signer settings", flag.ContinueOnError)
+	fs.StringVar(&options.Endpoint, "endpoint", defaultGCPEndpoint, "Cloud KMS endpoint")
+	fs.StringVar(&options.CredentialsFile, "credentials-file", "", "Location of the JSON file with the service account credentials, Google Application Default Credentials are used when not set")
+	fs.StringVar(&keyVersion, "key-version", "", "Resource name of the asymmetric key version projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewGCPSigner(options, keyVersion)
+}
@@ -0,0 +1,81 @@
+package kms
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultGCPEndpoint = "https://cloudkms.googleapis.com"
+	gcpScope           = "https://www.googleapis.com/auth/cloudkms"
+)
+
+type GCPOptions struct {
+	Endpoint        string
+	CredentialsFile string
+}
+
+// GCPKMS encrypts and decrypts locally generated data keys with Cloud KMS,
+// the key id is the resource name projects/*/locations/*/keyRings/*/cryptoKeys/*.
+type GCPKMS struct {
+	endpoint string
+	client   *http.Client
+}
+
+type gcpResponse struct {
+	Plaintext  []byte `json:"plaintext"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func NewGCPKMS(options GCPOptions) (*GCPKMS, error) {
+	var client *http.Client
+	if options.CredentialsFile != "" {
+		data, err := ioutil.ReadFile(options.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		conf, err := google.JWTConfigFromJSON(data, gcpScope)
+		if err != nil {
+			return nil, err
+		}
+		client = conf.Client(context.Background())
+	} else {
+		var err error
+		if client, err = google.DefaultClient(context.Background(), gcpScope); err != nil {
+			return nil, errors.Wrap(err, "google application default credentials")
+		}
+	}
+	return &GCPKMS{endpoint: strings.TrimSuffix(options.Endpoint, "/"), client: client}, nil
+}
+
+func (k *GCPKMS) post(ctx context.Context, keyID string, operation string, body interface{}, result interface{}) error {
+	return postJSON(ctx, k.client, k.endpoint+"/v1/"+keyID+":"+operation, "application/json", body, result, nil)
+}
+
+// GenerateDataKey implements apis.KeyManagementService
+func (k *GCPKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	plaintext, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &gcpResponse{}
+	err = k.post(ctx, keyID, "encrypt", map[string]interface{}{"plaintext": plaintext}, result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, result.Ciphertext, nil
+}
+
+// DecryptDataKey implements apis.KeyManagementService
+func (k *GCPKMS) DecryptDataKey(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	result := &gcpResponse{}
+	err := k.post(ctx, keyID, "decrypt", map[string]interface{}{"ciphertext": ciphertext}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
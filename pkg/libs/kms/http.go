@@ -0,0 +1,72 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const maxResponseSize = 1 << 20
+
+// postJSON sends the request body and decodes the response body, sign is invoked with the request and the encoded body
+func postJSON(ctx context.Context, client *http.Client, url string, contentType string, body interface{}, result interface{}, sign func(*http.Request, []byte) error) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if sign != nil {
+		if err = sign(req, data); err != nil {
+			return err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms request to %s failed with status %d: %s", url, resp.StatusCode, string(respData))
+	}
+	return json.Unmarshal(respData, result)
+}
+
+// getJSON sends the GET request with the headers and decodes the response body
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to %s failed with status %d: %s", url, resp.StatusCode, string(respData))
+	}
+	return json.Unmarshal(respData, result)
+}
@@ -0,0 +1,161 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticKMS(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "static-kms")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	a.Nil(ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600))
+
+	kms, err := new(StaticFactory).New([]string{"--key-file", "k1=" + keyFile})
+	a.Nil(err)
+
+	plaintext, ciphertext, err := kms.GenerateDataKey(context.Background(), "k1")
+	a.Nil(err)
+	a.Len(plaintext, 32)
+	decrypted, err := kms.DecryptDataKey(context.Background(), "k1", ciphertext)
+	a.Nil(err)
+	a.Equal(plaintext, decrypted)
+
+	_, _, err = kms.GenerateDataKey(context.Background(), "k2")
+	a.EqualError(err, "unknown master key k2")
+
+	_, err = new(StaticFactory).New([]string{"--key-file", keyFile})
+	a.NotNil(err)
+}
+
+func TestVaultKMS(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/orders":
+			a.Equal(float64(256), body["bits"])
+			w.Write([]byte(`{"data":{"plaintext":"AQID","ciphertext":"vault:v1:abc"}}`))
+		case "/v1/transit/decrypt/orders":
+			a.Equal("vault:v1:abc", body["ciphertext"])
+			w.Write([]byte(`{"data":{"plaintext":"AQID"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	kms, err := NewVaultKMS(VaultOptions{Address: server.URL + "/", Token: "s.token", Mount: "transit"})
+	a.Nil(err)
+	plaintext, ciphertext, err := kms.GenerateDataKey(context.Background(), "orders")
+	a.Nil(err)
+	a.Equal([]byte{1, 2, 3}, plaintext)
+	a.Equal([]byte("vault:v1:abc"), ciphertext)
+
+	plaintext, err = kms.DecryptDataKey(context.Background(), "orders", ciphertext)
+	a.Nil(err)
+	a.Equal([]byte{1, 2, 3}, plaintext)
+
+	_, _, err = kms.GenerateDataKey(context.Background(), "payments")
+	a.NotNil(err)
+
+	_, err = NewVaultKMS(VaultOptions{Address: server.URL})
+	a.NotNil(err)
+}
+
+func TestSignV4(t *testing.T) {
+	a := assert.New(t)
+
+	// get-vanilla of the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	a.Nil(err)
+	signV4(req, []byte{}, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	a.Equal("20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	a.Equal("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
+
+func TestAWSKMS(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal(awsContentType, r.Header.Get("Content-Type"))
+		a.Contains(r.Header.Get("Authorization"), "Credential=AKID/")
+		a.Equal("token", r.Header.Get("X-Amz-Security-Token"))
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		a.Equal("alias/orders", body["KeyId"])
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			a.Equal("AES_256", body["KeySpec"])
+			w.Write([]byte(`{"CiphertextBlob":"BAU=","Plaintext":"AQID","KeyId":"arn:aws:kms:eu-west-1:1:key/1"}`))
+		case "TrentService.Decrypt":
+			a.Equal("BAU=", body["CiphertextBlob"])
+			w.Write([]byte(`{"Plaintext":"AQID"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	kms, err := NewAWSKMS(AWSOptions{Region: "eu-west-1", Endpoint: server.URL, AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"})
+	a.Nil(err)
+	plaintext, ciphertext, err := kms.GenerateDataKey(context.Background(), "alias/orders")
+	a.Nil(err)
+	a.Equal([]byte{1, 2, 3}, plaintext)
+	a.Equal([]byte{4, 5}, ciphertext)
+
+	plaintext, err = kms.DecryptDataKey(context.Background(), "alias/orders", ciphertext)
+	a.Nil(err)
+	a.Equal([]byte{1, 2, 3}, plaintext)
+}
+
+func TestAWSSigner(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("TrentService.Sign", r.Header.Get("X-Amz-Target"))
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		a.Equal("alias/listener", body["KeyId"])
+		a.Equal("AQID", body["Message"])
+		a.Equal("DIGEST", body["MessageType"])
+		w.Write([]byte(`{"Signature":"` + base64.StdEncoding.EncodeToString([]byte(body["SigningAlgorithm"].(string))) + `"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewAWSSigner(AWSOptions{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}, "")
+	a.EqualError(err, "key-id parameter is required")
+
+	signer, err := NewAWSSigner(AWSOptions{Region: "eu-west-1", Endpoint: server.URL, AccessKeyID: "AKID", SecretAccessKey: "secret"}, "alias/listener")
+	a.Nil(err)
+	signature, err := signer.Sign(context.Background(), apis.SignRequest{KeyType: "RSA", Hash: "SHA-256", PSS: true, Digest: []byte{1, 2, 3}})
+	a.Nil(err)
+	a.Equal("RSASSA_PSS_SHA_256", string(signature))
+	signature, err = signer.Sign(context.Background(), apis.SignRequest{KeyType: "RSA", Hash: "SHA-384", Digest: []byte{1, 2, 3}})
+	a.Nil(err)
+	a.Equal("RSASSA_PKCS1_V1_5_SHA_384", string(signature))
+	signature, err = signer.Sign(context.Background(), apis.SignRequest{KeyType: "ECDSA", Hash: "SHA-256", Digest: []byte{1, 2, 3}})
+	a.Nil(err)
+	a.Equal("ECDSA_SHA_256", string(signature))
+	_, err = signer.Sign(context.Background(), apis.SignRequest{KeyType: "RSA", Hash: "SHA-1", Digest: []byte{1, 2, 3}})
+	a.EqualError(err, "unsupported hash SHA-1")
+}
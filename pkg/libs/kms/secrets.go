@@ -0,0 +1,231 @@
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	awsSecretsManagerScheme = "aws-sm://"
+	gcpSecretManagerScheme  = "gcp-sm://"
+	azureKeyVaultScheme     = "azure-kv://"
+
+	defaultGCPSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+	gcpSecretManagerScope           = "https://www.googleapis.com/auth/cloud-platform"
+	defaultAzureIdentityEndpoint    = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureKeyVaultResource           = "https://vault.azure.net"
+	azureKeyVaultAPIVersion         = "7.4"
+)
+
+// IsSecretReference returns true for the references to the secrets of the cloud secret managers
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, awsSecretsManagerScheme) || strings.HasPrefix(value, gcpSecretManagerScheme) || strings.HasPrefix(value, azureKeyVaultScheme)
+}
+
+// SecretResolver resolves the references to the secrets of AWS Secrets Manager (aws-sm://secret-id), GCP Secret Manager
+// (gcp-sm://projects/*/secrets/*[/versions/*]) and Azure Key Vault (azure-kv://vault-name/secret-name[/version]).
+// The reference ending with #key selects the key of a JSON secret. The clients authenticate with the workload identity:
+// the AWS task or instance role, the Google Application Default Credentials and the Azure managed identity.
+type SecretResolver struct {
+	client *http.Client
+
+	awsEndpoint           string // default https://secretsmanager.<region>.amazonaws.com
+	awsCredentials        *awsCredentialsProvider
+	gcpEndpoint           string
+	azureEndpoint         string // default https://<vault-name>.vault.azure.net
+	azureIdentityEndpoint string
+
+	mu         sync.Mutex
+	gcpClient  *http.Client
+	azureToken string
+	azureExp   time.Time
+}
+
+func NewSecretResolver(timeout time.Duration) *SecretResolver {
+	client := &http.Client{Timeout: timeout}
+	return &SecretResolver{
+		client:                client,
+		awsCredentials:        newAWSCredentialsProvider(client),
+		gcpEndpoint:           defaultGCPSecretManagerEndpoint,
+		azureIdentityEndpoint: defaultAzureIdentityEndpoint,
+	}
+}
+
+// Resolve returns the secret of the reference, other values are returned unchanged
+func (r *SecretResolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsSecretReference(value) {
+		return value, nil
+	}
+	reference, key := value, ""
+	if i := strings.LastIndex(value, "#"); i != -1 {
+		reference, key = value[:i], value[i+1:]
+	}
+	var (
+		secret string
+		err    error
+	)
+	switch {
+	case strings.HasPrefix(reference, awsSecretsManagerScheme):
+		secret, err = r.resolveAWS(ctx, strings.TrimPrefix(reference, awsSecretsManagerScheme))
+	case strings.HasPrefix(reference, gcpSecretManagerScheme):
+		secret, err = r.resolveGCP(ctx, strings.TrimPrefix(reference, gcpSecretManagerScheme))
+	default:
+		secret, err = r.resolveAzure(ctx, strings.TrimPrefix(reference, azureKeyVaultScheme))
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "secret %s cannot be resolved", reference)
+	}
+	if key == "" {
+		return secret, nil
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", errors.Wrapf(err, "secret %s is not a JSON object", reference)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", reference, key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(field), nil
+}
+
+func (r *SecretResolver) resolveAWS(ctx context.Context, secretID string) (string, error) {
+	// the region of the ARN arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	region := os.Getenv("AWS_REGION")
+	if parts := strings.Split(secretID, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return "", errors.New("AWS region is required, set AWS_REGION or use the secret ARN")
+	}
+	endpoint := r.awsEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", region)
+	}
+	credentials, err := r.awsCredentials.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	result := &struct {
+		SecretString string
+		SecretBinary []byte
+	}{}
+	err = postJSON(ctx, r.client, endpoint+"/", awsContentType, map[string]interface{}{"SecretId": secretID}, result, func(req *http.Request, payload []byte) error {
+		req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+		if credentials.Token != "" {
+			req.Header.Set("X-Amz-Security-Token", credentials.Token)
+		}
+		signV4(req, payload, credentials.AccessKeyID, credentials.SecretAccessKey, region, "secretsmanager", time.Now())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.SecretString != "" {
+		return result.SecretString, nil
+	}
+	return string(result.SecretBinary), nil
+}
+
+func (r *SecretResolver) resolveGCP(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	r.mu.Lock()
+	if r.gcpClient == nil {
+		client, err := google.DefaultClient(context.Background(), gcpSecretManagerScope)
+		if err != nil {
+			r.mu.Unlock()
+			return "", errors.Wrap(err, "google application default credentials")
+		}
+		client.Timeout = r.client.Timeout
+		r.gcpClient = client
+	}
+	client := r.gcpClient
+	r.mu.Unlock()
+
+	result := &struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err := getJSON(ctx, client, r.gcpEndpoint+"/v1/"+name+":access", nil, result); err != nil {
+		return "", err
+	}
+	return string(result.Payload.Data), nil
+}
+
+func (r *SecretResolver) resolveAzure(ctx context.Context, reference string) (string, error) {
+	parts := strings.SplitN(reference, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", errors.New("azure-kv://vault-name/secret-name[/version] is expected")
+	}
+	endpoint := r.azureEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + parts[0] + ".vault.azure.net"
+	}
+	secretURL := endpoint + "/secrets/" + url.PathEscape(parts[1])
+	if len(parts) == 3 {
+		secretURL += "/" + url.PathEscape(parts[2])
+	}
+	token, err := r.azureAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	result := &struct {
+		Value string `json:"value"`
+	}{}
+	if err = getJSON(ctx, r.client, secretURL+"?api-version="+azureKeyVaultAPIVersion, header, result); err != nil {
+		return "", err
+	}
+	return result.Value, nil
+}
+
+// azureAccessToken returns the Key Vault access token of the managed identity, the user assigned identity is selected by AZURE_CLIENT_ID
+func (r *SecretResolver) azureAccessToken(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.azureToken != "" && time.Now().Add(credentialsRefreshBefore).Before(r.azureExp) {
+		return r.azureToken, nil
+	}
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", azureKeyVaultResource)
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	header := http.Header{}
+	header.Set("Metadata", "true")
+	result := &struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	if err := getJSON(ctx, r.client, r.azureIdentityEndpoint+"?"+query.Encode(), header, result); err != nil {
+		return "", errors.Wrap(err, "azure managed identity")
+	}
+	expiresOn, err := strconv.ParseInt(result.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", errors.Wrap(err, "azure managed identity token expiry")
+	}
+	r.azureToken, r.azureExp = result.AccessToken, time.Unix(expiresOn, 0)
+	return r.azureToken, nil
+}
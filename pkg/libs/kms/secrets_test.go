@@ -0,0 +1,101 @@
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretResolver(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		// AWS instance metadata (IMDSv2)
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			a.Equal("imds-token", r.Header.Get("X-aws-ec2-metadata-token"))
+			w.Write([]byte("kafka-proxy-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/kafka-proxy-role":
+			json.NewEncoder(w).Encode(map[string]interface{}{"AccessKeyId": "ASIA", "SecretAccessKey": "secret", "Token": "session", "Expiration": time.Now().Add(time.Hour)})
+		// AWS Secrets Manager
+		case r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue":
+			a.Equal("session", r.Header.Get("X-Amz-Security-Token"))
+			a.True(strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ASIA/"))
+			a.Contains(r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request")
+			body, _ := ioutil.ReadAll(r.Body)
+			request := make(map[string]string)
+			a.Nil(json.Unmarshal(body, &request))
+			if request["SecretId"] != "kafka/sasl" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"SecretString": `{"username":"alice","password":"alice-secret"}`})
+		// GCP Secret Manager
+		case r.URL.Path == "/v1/projects/p1/secrets/key-password/versions/latest:access":
+			json.NewEncoder(w).Encode(map[string]interface{}{"payload": map[string]interface{}{"data": []byte("gcp-secret")}})
+		// Azure managed identity and Key Vault
+		case r.URL.Path == "/metadata/identity/oauth2/token":
+			a.Equal("true", r.Header.Get("Metadata"))
+			a.Equal(azureKeyVaultResource, r.URL.Query().Get("resource"))
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "azure-token", "expires_on": strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)})
+		case r.URL.Path == "/secrets/plugin-token/v2":
+			a.Equal("Bearer azure-token", r.Header.Get("Authorization"))
+			a.Equal(azureKeyVaultAPIVersion, r.URL.Query().Get("api-version"))
+			json.NewEncoder(w).Encode(map[string]interface{}{"value": "azure-secret"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AZURE_CLIENT_ID"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+	defer os.Setenv("AWS_REGION", os.Getenv("AWS_REGION"))
+	os.Setenv("AWS_REGION", "eu-west-1")
+
+	resolver := NewSecretResolver(time.Second)
+	resolver.awsEndpoint = server.URL
+	resolver.awsCredentials.metadataEndpoint = server.URL
+	resolver.gcpEndpoint = server.URL
+	resolver.gcpClient = &http.Client{}
+	resolver.azureEndpoint = server.URL
+	resolver.azureIdentityEndpoint = server.URL + "/metadata/identity/oauth2/token"
+	ctx := context.Background()
+
+	value, err := resolver.Resolve(ctx, "plain-password")
+	a.Nil(err)
+	a.Equal("plain-password", value)
+
+	value, err = resolver.Resolve(ctx, "aws-sm://kafka/sasl#password")
+	a.Nil(err)
+	a.Equal("alice-secret", value)
+	value, err = resolver.Resolve(ctx, "aws-sm://kafka/sasl")
+	a.Nil(err)
+	a.Equal(`{"username":"alice","password":"alice-secret"}`, value)
+	_, err = resolver.Resolve(ctx, "aws-sm://kafka/sasl#token")
+	a.EqualError(err, "secret aws-sm://kafka/sasl has no key token")
+	_, err = resolver.Resolve(ctx, "aws-sm://kafka/unknown")
+	a.NotNil(err)
+
+	value, err = resolver.Resolve(ctx, "gcp-sm://projects/p1/secrets/key-password")
+	a.Nil(err)
+	a.Equal("gcp-secret", value)
+
+	value, err = resolver.Resolve(ctx, "azure-kv://kafka-vault/plugin-token/v2")
+	a.Nil(err)
+	a.Equal("azure-secret", value)
+	_, err = resolver.Resolve(ctx, "azure-kv://kafka-vault")
+	a.EqualError(err, "secret azure-kv://kafka-vault cannot be resolved: azure-kv://vault-name/secret-name[/version] is expected")
+}
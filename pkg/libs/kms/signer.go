@@ -0,0 +1,96 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+var signerHashNames = map[string]string{
+	"SHA-256": "SHA_256",
+	"SHA-384": "SHA_384",
+	"SHA-512": "SHA_512",
+}
+
+// AWSSigner signs with the asymmetric AWS KMS key, the key id is the id, ARN or alias of the KMS key
+type AWSSigner struct {
+	kms   *AWSKMS
+	keyID string
+}
+
+type awsSignResponse struct {
+	Signature []byte
+}
+
+func NewAWSSigner(options AWSOptions, keyID string) (*AWSSigner, error) {
+	if keyID == "" {
+		return nil, errors.New("key-id parameter is required")
+	}
+	kms, err := NewAWSKMS(options)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSSigner{kms: kms, keyID: keyID}, nil
+}
+
+// Sign implements apis.KeySigner
+func (s *AWSSigner) Sign(ctx context.Context, request apis.SignRequest) ([]byte, error) {
+	hash, ok := signerHashNames[request.Hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %s", request.Hash)
+	}
+	var algorithm string
+	switch {
+	case request.KeyType == "ECDSA":
+		algorithm = "ECDSA_" + hash
+	case request.KeyType == "RSA" && request.PSS:
+		algorithm = "RSASSA_PSS_" + hash
+	case request.KeyType == "RSA":
+		algorithm = "RSASSA_PKCS1_V1_5_" + hash
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", request.KeyType)
+	}
+	result := &awsSignResponse{}
+	err := s.kms.post(ctx, "Sign", map[string]interface{}{"KeyId": s.keyID, "Message": request.Digest, "MessageType": "DIGEST", "SigningAlgorithm": algorithm}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Signature, nil
+}
+
+// GCPSigner signs with the asymmetric Cloud KMS key version projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*,
+// the signature algorithm e.g. PKCS#1 v1.5 or PSS is given by the key version
+type GCPSigner struct {
+	kms        *GCPKMS
+	keyVersion string
+}
+
+type gcpSignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+func NewGCPSigner(options GCPOptions, keyVersion string) (*GCPSigner, error) {
+	if keyVersion == "" {
+		return nil, errors.New("key-version parameter is required")
+	}
+	kms, err := NewGCPKMS(options)
+	if err != nil {
+		return nil, err
+	}
+	return &GCPSigner{kms: kms, keyVersion: keyVersion}, nil
+}
+
+// Sign implements apis.KeySigner
+func (s *GCPSigner) Sign(ctx context.Context, request apis.SignRequest) ([]byte, error) {
+	if _, ok := signerHashNames[request.Hash]; !ok {
+		return nil, fmt.Errorf("unsupported hash %s", request.Hash)
+	}
+	digest := map[string]interface{}{strings.ToLower(strings.Replace(request.Hash, "-", "", 1)): request.Digest}
+	result := &gcpSignResponse{}
+	if err := s.kms.post(ctx, s.keyVersion, "asymmetricSign", map[string]interface{}{"digest": digest}, result); err != nil {
+		return nil, err
+	}
+	return result.Signature, nil
+}
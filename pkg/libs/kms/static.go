@@ -0,0 +1,94 @@
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const dataKeyLength = 32
+
+// StaticKMS wraps the data keys with master keys read from files. It is meant for tests and single node setups.
+type StaticKMS struct {
+	keys map[string]cipher.AEAD
+}
+
+func NewStaticKMS(keyFiles []string) (*StaticKMS, error) {
+	if len(keyFiles) == 0 {
+		return nil, errors.New("key-file parameter is required")
+	}
+	kms := &StaticKMS{keys: make(map[string]cipher.AEAD)}
+	for _, keyFile := range keyFiles {
+		pair := strings.SplitN(keyFile, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("key-file '%s' must be key-id=path", keyFile)
+		}
+		data, err := ioutil.ReadFile(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "master key %s is not base64 encoded", pair[0])
+		}
+		if kms.keys[pair[0]], err = newStaticKey(key); err != nil {
+			return nil, errors.Wrapf(err, "master key %s", pair[0])
+		}
+	}
+	return kms, nil
+}
+
+func newStaticKey(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeyLength {
+		return nil, fmt.Errorf("key length must be %d bytes", dataKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateDataKey implements apis.KeyManagementService
+func (k *StaticKMS) GenerateDataKey(_ context.Context, keyID string) ([]byte, []byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+	plaintext, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, aead.Seal(nonce, nonce, plaintext, []byte(keyID)), nil
+}
+
+// DecryptDataKey implements apis.KeyManagementService
+func (k *StaticKMS) DecryptDataKey(_ context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("encrypted data key is too short")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(keyID))
+}
+
+func newDataKey() ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
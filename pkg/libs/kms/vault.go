@@ -0,0 +1,94 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type VaultOptions struct {
+	Address   string
+	Token     string
+	TokenFile string
+	Mount     string
+}
+
+// VaultKMS generates and decrypts the data keys with the transit secrets engine, the key id is the name of the transit key.
+type VaultKMS struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+}
+
+func NewVaultKMS(options VaultOptions) (*VaultKMS, error) {
+	if options.Address == "" {
+		return nil, errors.New("address parameter is required")
+	}
+	token := options.Token
+	if options.TokenFile != "" {
+		data, err := ioutil.ReadFile(options.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, errors.New("token or token-file parameter is required")
+	}
+	return &VaultKMS{
+		address: strings.TrimSuffix(options.Address, "/"),
+		token:   token,
+		mount:   strings.Trim(options.Mount, "/"),
+		client:  &http.Client{},
+	}, nil
+}
+
+func (k *VaultKMS) post(ctx context.Context, operation string, keyID string, body interface{}) (*vaultResponse, error) {
+	result := &vaultResponse{}
+	err := postJSON(ctx, k.client, k.address+"/v1/"+k.mount+"/"+operation+"/"+url.PathEscape(keyID), "application/json", body, result,
+		func(req *http.Request, _ []byte) error {
+			req.Header.Set("X-Vault-Token", k.token)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GenerateDataKey implements apis.KeyManagementService
+func (k *VaultKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	result, err := k.post(ctx, "datakey/plaintext", keyID, map[string]interface{}{"bits": dataKeyLength * 8})
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(result.Data.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.Data.Ciphertext == "" {
+		return nil, nil, errors.New("vault response without ciphertext")
+	}
+	return plaintext, []byte(result.Data.Ciphertext), nil
+}
+
+// DecryptDataKey implements apis.KeyManagementService
+func (k *VaultKMS) DecryptDataKey(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	result, err := k.post(ctx, "decrypt", keyID, map[string]interface{}{"ciphertext": string(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Data.Plaintext)
+}
@@ -0,0 +1,74 @@
+package ldapauth
+
+import (
+	"flag"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.PasswordAuthenticatorFactory))
+	registry.Register(new(Factory), "ldap-auth")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("ldap auth settings", flag.ContinueOnError)
+
+	fs.StringVar(&f.url, "url", "", "LDAP URL to connect to (eg: ldaps://127.0.0.1:636). Multiple URLs can be specified by concatenating them with commas.")
+	fs.BoolVar(&f.startTLS, "start-tls", true, "Issue a StartTLS command after establishing unencrypted connection (optional)")
+	fs.StringVar(&f.caCertFile, "ca-cert-file", "", "PEM encoded CA certificates of the LDAP servers. If empty, the system CAs are used")
+	fs.BoolVar(&f.insecureSkipVerify, "insecure-skip-verify", false, "Do not verify the certificates of the LDAP servers")
+	fs.IntVar(&f.timeout, "timeout", 10, "Request timeout in seconds")
+	fs.IntVar(&f.maxIdleConns, "max-idle-conns", 4, "Maximum number of idle connections kept for the next binds")
+	fs.StringVar(&f.upnDomain, "upn-domain", "", "Enables userPrincipalDomain login with [username]@UPNDomain (optional)")
+	fs.StringVar(&f.userDN, "user-dn", "", "LDAP domain to use for users (eg: cn=users,dc=example,dc=org)")
+	fs.StringVar(&f.userAttr, "user-attr", "uid", "Attribute used for users")
+	fs.StringVar(&f.bindDN, "bind-dn", "", "DN of the group search, if empty the group search is done as the user (optional)")
+	fs.StringVar(&f.bindPassword, "bind-password", "", "Password of the bind-dn (optional)")
+	fs.StringVar(&f.groupBaseDN, "group-base-dn", "", "Base DN of the group search (eg: ou=groups,dc=example,dc=org)")
+	fs.StringVar(&f.groupFilter, "group-filter", "", "Filter which must find an entry for the user to be authenticated, {username} and {userdn} are replaced by the escaped values (eg: (&(objectClass=groupOfNames)(cn=kafka)(member={userdn}))) (optional)")
+	return fs
+}
+
+type pluginMeta struct {
+	url                string
+	startTLS           bool
+	caCertFile         string
+	insecureSkipVerify bool
+	timeout            int
+	maxIdleConns       int
+	upnDomain          string
+	userDN             string
+	userAttr           string
+	bindDN             string
+	bindPassword       string
+	groupBaseDN        string
+	groupFilter        string
+}
+
+type Factory struct {
+}
+
+// New implements apis.PasswordAuthenticatorFactory
+func (t *Factory) New(params []string) (apis.PasswordAuthenticator, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewLdapAuthenticator(LdapAuthenticatorOptions{
+		Urls:               pluginMeta.url,
+		StartTLS:           pluginMeta.startTLS,
+		CACertFile:         pluginMeta.caCertFile,
+		InsecureSkipVerify: pluginMeta.insecureSkipVerify,
+		Timeout:            pluginMeta.timeout,
+		MaxIdleConns:       pluginMeta.maxIdleConns,
+		UPNDomain:          pluginMeta.upnDomain,
+		UserDN:             pluginMeta.userDN,
+		UserAttr:           pluginMeta.userAttr,
+		BindDN:             pluginMeta.bindDN,
+		BindPassword:       pluginMeta.bindPassword,
+		GroupBaseDN:        pluginMeta.groupBaseDN,
+		GroupFilter:        pluginMeta.groupFilter,
+	})
+}
@@ -0,0 +1,297 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/ldap.v2"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK                = 0 // also returned for invalid credentials
+	StatusDialFailed        = 1
+	StatusBindFailed        = 2
+	StatusNotInGroup        = 3
+	StatusGroupSearchFailed = 4
+)
+
+type LdapAuthenticatorOptions struct {
+	Urls               string // comma separated
+	StartTLS           bool
+	CACertFile         string
+	InsecureSkipVerify bool
+	Timeout            int
+	MaxIdleConns       int
+	UPNDomain          string
+	UserDN             string
+	UserAttr           string
+	BindDN             string
+	BindPassword       string
+	GroupBaseDN        string
+	GroupFilter        string
+}
+
+// ldapConn is the part of *ldap.Conn used by the authenticator
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// LdapAuthenticator authenticates the users with the LDAP bind, the user is optionally required to be found by the group filter.
+// The connections are reused for the next binds.
+type LdapAuthenticator struct {
+	upnDomain    string
+	userDN       string
+	userAttr     string
+	bindDN       string
+	bindPassword string
+	groupBaseDN  string
+	groupFilter  string
+	timeout      time.Duration
+
+	dial         func() (ldapConn, error)
+	maxIdleConns int
+	idleConns    []ldapConn
+	l            sync.Mutex
+}
+
+func NewLdapAuthenticator(options LdapAuthenticatorOptions) (*LdapAuthenticator, error) {
+	urls, err := getUrls(options.Urls)
+	if err != nil {
+		return nil, err
+	}
+	if options.UPNDomain == "" && (options.UserDN == "" || options.UserAttr == "") {
+		return nil, errors.New("parameters user-dn and user-attr are required")
+	}
+	if options.GroupFilter != "" && options.GroupBaseDN == "" {
+		return nil, errors.New("parameter group-base-dn is required with group-filter")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no CA certificates found in %s", options.CACertFile)
+		}
+	}
+	timeout := time.Duration(options.Timeout) * time.Second
+	logrus.Infof("LDAP authentication with %v", urls)
+
+	return &LdapAuthenticator{
+		upnDomain:    options.UPNDomain,
+		userDN:       options.UserDN,
+		userAttr:     options.UserAttr,
+		bindDN:       options.BindDN,
+		bindPassword: options.BindPassword,
+		groupBaseDN:  options.GroupBaseDN,
+		groupFilter:  options.GroupFilter,
+		timeout:      timeout,
+		maxIdleConns: options.MaxIdleConns,
+		dial: func() (ldapConn, error) {
+			return dialLDAP(urls, options.StartTLS, tlsConfig, timeout)
+		},
+	}, nil
+}
+
+// Authenticate implements apis.PasswordAuthenticator
+func (pa *LdapAuthenticator) Authenticate(username, password string) (bool, int32, error) {
+	// the bind with an empty password is an unauthenticated bind which succeeds
+	if username == "" || password == "" {
+		return false, StatusOK, nil
+	}
+	conn, pooled, err := pa.getConn()
+	if err != nil {
+		logrus.Errorf("user %s ldap dial error %v", username, err)
+		return false, StatusDialFailed, nil
+	}
+	ok, status, err := pa.authenticate(conn, username, password)
+	if err != nil && pooled && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		// the idle connection was closed by the server
+		conn.Close()
+		if conn, err = pa.dial(); err != nil {
+			logrus.Errorf("user %s ldap dial error %v", username, err)
+			return false, StatusDialFailed, nil
+		}
+		ok, status, err = pa.authenticate(conn, username, password)
+	}
+	if err != nil {
+		conn.Close()
+		logrus.Errorf("user %s ldap error %v", username, err)
+		return false, status, nil
+	}
+	pa.putConn(conn)
+	return ok, status, nil
+}
+
+func (pa *LdapAuthenticator) authenticate(conn ldapConn, username, password string) (bool, int32, error) {
+	userBindDN := pa.getUserBindDN(username)
+	if err := conn.Bind(userBindDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			logrus.Errorf("user %s credentials are invalid", username)
+			return false, StatusOK, nil
+		}
+		return false, StatusBindFailed, err
+	}
+	if pa.groupFilter == "" {
+		return true, StatusOK, nil
+	}
+	if pa.bindDN != "" {
+		if err := conn.Bind(pa.bindDN, pa.bindPassword); err != nil {
+			return false, StatusBindFailed, err
+		}
+	}
+	filter := strings.NewReplacer("{username}", ldap.EscapeFilter(username), "{userdn}", ldap.EscapeFilter(userBindDN)).Replace(pa.groupFilter)
+	searchRequest := ldap.NewSearchRequest(pa.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(pa.timeout.Seconds()), false, filter, []string{"dn"}, nil)
+	result, err := conn.Search(searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false, StatusGroupSearchFailed, err
+	}
+	if result == nil || len(result.Entries) == 0 {
+		logrus.Errorf("user %s is not found by the group filter", username)
+		return false, StatusNotInGroup, nil
+	}
+	return true, StatusOK, nil
+}
+
+func (pa *LdapAuthenticator) getConn() (conn ldapConn, pooled bool, err error) {
+	pa.l.Lock()
+	if n := len(pa.idleConns); n != 0 {
+		conn = pa.idleConns[n-1]
+		pa.idleConns = pa.idleConns[:n-1]
+		pa.l.Unlock()
+		return conn, true, nil
+	}
+	pa.l.Unlock()
+	conn, err = pa.dial()
+	return conn, false, err
+}
+
+func (pa *LdapAuthenticator) putConn(conn ldapConn) {
+	pa.l.Lock()
+	defer pa.l.Unlock()
+	if len(pa.idleConns) < pa.maxIdleConns {
+		pa.idleConns = append(pa.idleConns, conn)
+		return
+	}
+	conn.Close()
+}
+
+func (pa *LdapAuthenticator) getUserBindDN(username string) string {
+	if pa.upnDomain != "" {
+		return fmt.Sprintf("%s@%s", escapeLDAPValue(username), pa.upnDomain)
+	}
+	return fmt.Sprintf("%s=%s,%s", pa.userAttr, escapeLDAPValue(username), pa.userDN)
+}
+
+// escapeLDAPValue escapes the attribute value of the DN (https://tools.ietf.org/html/rfc4514#section-2.4)
+func escapeLDAPValue(input string) string {
+	var b strings.Builder
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '"' || c == '+' || c == ',' || c == ';' || c == '<' || c == '>' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == ' ' && i == len(input)-1:
+			b.WriteString("\\ ")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func getUrls(value string) ([]*url.URL, error) {
+	result := make([]*url.URL, 0)
+	for _, uut := range strings.Split(value, ",") {
+		if uut == "" {
+			continue
+		}
+		u, err := url.Parse(uut)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "ldap", "ldaps":
+			result = append(result, u)
+		default:
+			return nil, fmt.Errorf("invalid LDAP scheme in url %q", uut)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("empty LDAP url list")
+	}
+	return result, nil
+}
+
+func dialLDAP(urls []*url.URL, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (ldapConn, error) {
+	var retErr *multierror.Error
+	for _, u := range urls {
+		conn, err := dialURL(u, startTLS, tlsConfig, timeout)
+		if err == nil {
+			return conn, nil
+		}
+		retErr = multierror.Append(retErr, fmt.Errorf("error connecting to host %q: %s", u.String(), err.Error()))
+	}
+	return nil, retErr.ErrorOrNil()
+}
+
+func dialURL(u *url.URL, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*ldap.Conn, error) {
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	config := tlsConfig.Clone()
+	config.ServerName = host
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn *ldap.Conn
+	switch u.Scheme {
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		tlsConn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), config)
+		if err != nil {
+			return nil, err
+		}
+		conn = ldap.NewConn(tlsConn, true)
+		conn.Start()
+	default:
+		if port == "" {
+			port = "389"
+		}
+		rawConn, err := dialer.Dial("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, err
+		}
+		conn = ldap.NewConn(rawConn, false)
+		conn.Start()
+		if startTLS {
+			if err = conn.StartTLS(config); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	conn.SetTimeout(timeout)
+	return conn, nil
+}
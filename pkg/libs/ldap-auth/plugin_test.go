@@ -0,0 +1,142 @@
+package ldapauth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+	"testing"
+)
+
+// testLdapConn accepts the passwords of the DNs and finds the entries of the filters
+type testLdapConn struct {
+	passwords map[string]string
+	entries   map[string]int
+	binds     []string
+	filters   []string
+	closed    bool
+	err       error
+}
+
+func (c *testLdapConn) Bind(username, password string) error {
+	if c.closed || c.err != nil {
+		return ldap.NewError(ldap.ErrorNetwork, c.err)
+	}
+	c.binds = append(c.binds, username)
+	if p, ok := c.passwords[username]; !ok || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+	}
+	return nil
+}
+
+func (c *testLdapConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.filters = append(c.filters, searchRequest.Filter)
+	result := &ldap.SearchResult{}
+	for i := 0; i < c.entries[searchRequest.Filter]; i++ {
+		result.Entries = append(result.Entries, ldap.NewEntry("cn=kafka,ou=groups,dc=example,dc=org", nil))
+	}
+	return result, nil
+}
+
+func (c *testLdapConn) Close() {
+	c.closed = true
+}
+
+func newTestLdapAuthenticator(a *assert.Assertions, options LdapAuthenticatorOptions, conns ...*testLdapConn) (*LdapAuthenticator, *int) {
+	options.Urls = "ldap://127.0.0.1:389"
+	options.MaxIdleConns = 1
+	pa, err := NewLdapAuthenticator(options)
+	a.Nil(err)
+	dials := 0
+	pa.dial = func() (ldapConn, error) {
+		conn := conns[dials]
+		dials++
+		return conn, nil
+	}
+	return pa, &dials
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	a := assert.New(t)
+
+	conn := &testLdapConn{passwords: map[string]string{"uid=alice,ou=users,dc=example,dc=org": "secret"}}
+	pa, dials := newTestLdapAuthenticator(a, LdapAuthenticatorOptions{UserDN: "ou=users,dc=example,dc=org", UserAttr: "uid"}, conn)
+
+	ok, status, err := pa.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(int32(StatusOK), status)
+
+	// the connection is reused
+	ok, status, err = pa.Authenticate("alice", "wrong")
+	a.Nil(err)
+	a.False(ok)
+	a.Equal(int32(StatusOK), status)
+	a.Equal(1, *dials)
+
+	// unauthenticated bind is not allowed
+	ok, _, err = pa.Authenticate("alice", "")
+	a.Nil(err)
+	a.False(ok)
+	a.Equal([]string{"uid=alice,ou=users,dc=example,dc=org", "uid=alice,ou=users,dc=example,dc=org"}, conn.binds)
+}
+
+func TestLdapAuthenticateClosedConnection(t *testing.T) {
+	a := assert.New(t)
+
+	first := &testLdapConn{passwords: map[string]string{"alice@example.org": "secret"}}
+	second := &testLdapConn{passwords: map[string]string{"alice@example.org": "secret"}}
+	pa, dials := newTestLdapAuthenticator(a, LdapAuthenticatorOptions{UPNDomain: "example.org"}, first, second)
+
+	ok, _, err := pa.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+
+	// the idle connection closed by the server is replaced
+	first.closed = true
+	ok, _, err = pa.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(2, *dials)
+	a.Equal([]ldapConn{second}, pa.idleConns)
+}
+
+func TestLdapAuthenticateGroupFilter(t *testing.T) {
+	a := assert.New(t)
+
+	conn := &testLdapConn{
+		passwords: map[string]string{"uid=alice,ou=users,dc=example,dc=org": "secret", "uid=bob\\,admin,ou=users,dc=example,dc=org": "secret", "cn=search,dc=example,dc=org": "search"},
+		entries:   map[string]int{"(&(cn=kafka)(member=uid=alice,ou=users,dc=example,dc=org))": 1},
+	}
+	pa, _ := newTestLdapAuthenticator(a, LdapAuthenticatorOptions{UserDN: "ou=users,dc=example,dc=org", UserAttr: "uid", BindDN: "cn=search,dc=example,dc=org", BindPassword: "search",
+		GroupBaseDN: "ou=groups,dc=example,dc=org", GroupFilter: "(&(cn=kafka)(member={userdn}))"}, conn)
+
+	ok, status, err := pa.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(int32(StatusOK), status)
+
+	ok, status, err = pa.Authenticate("bob,admin", "secret")
+	a.Nil(err)
+	a.False(ok)
+	a.Equal(int32(StatusNotInGroup), status)
+	a.Equal("(&(cn=kafka)(member=uid=bob\\5c,admin,ou=users,dc=example,dc=org))", conn.filters[1])
+	a.Equal([]string{"uid=alice,ou=users,dc=example,dc=org", "cn=search,dc=example,dc=org", "uid=bob\\,admin,ou=users,dc=example,dc=org", "cn=search,dc=example,dc=org"}, conn.binds)
+}
+
+func TestNewLdapAuthenticatorOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewLdapAuthenticator(LdapAuthenticatorOptions{Urls: "http://127.0.0.1", UserDN: "ou=users", UserAttr: "uid"})
+	a.EqualError(err, "invalid LDAP scheme in url \"http://127.0.0.1\"")
+	_, err = NewLdapAuthenticator(LdapAuthenticatorOptions{Urls: "ldaps://127.0.0.1"})
+	a.EqualError(err, "parameters user-dn and user-attr are required")
+	_, err = NewLdapAuthenticator(LdapAuthenticatorOptions{Urls: "ldaps://127.0.0.1", UPNDomain: "example.org", GroupFilter: "(cn=kafka)"})
+	a.EqualError(err, "parameter group-base-dn is required with group-filter")
+}
+
+func TestEscapeLDAPValue(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("alice", escapeLDAPValue("alice"))
+	a.Equal("\\#alice\\,\\+admin\\ ", escapeLDAPValue("#alice,+admin "))
+	a.Equal("", escapeLDAPValue(""))
+}
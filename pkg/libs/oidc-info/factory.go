@@ -0,0 +1,71 @@
+package oidcinfo
+
+import (
+	"flag"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.TokenInfoFactory))
+	registry.Register(new(Factory), "oidc-info")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("oidc info settings", flag.ContinueOnError)
+	return fs
+}
+
+type pluginMeta struct {
+	timeout             int
+	jwksRefreshInterval int
+	clockSkew           int
+	jwksUrl             string
+	issuer              arrayFlags
+	audience            arrayFlags
+	claims              arrayFlags
+}
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return fmt.Sprintf("%v", *i)
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+type Factory struct {
+}
+
+// New implements apis.TokenInfoFactory
+func (t *Factory) New(params []string) (apis.TokenInfo, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	fs.IntVar(&pluginMeta.timeout, "timeout", 10, "Request timeout in seconds")
+	fs.IntVar(&pluginMeta.jwksRefreshInterval, "jwks-refresh-interval", 60*60, "JWKS refresh interval in seconds")
+	fs.IntVar(&pluginMeta.clockSkew, "clock-skew", 60, "Allowed clock skew of the exp, nbf and iat claims in seconds")
+	fs.StringVar(&pluginMeta.jwksUrl, "jwks-url", "", "URL of the JSON Web Key Set. If empty, it is discovered from the openid-configuration of the issuer")
+	fs.Var(&pluginMeta.issuer, "issuer", "The issuer of a token")
+	fs.Var(&pluginMeta.audience, "audience", "The audience of a token")
+	fs.Var(&pluginMeta.claims, "claim", "Required claim given as name=regex, a string claim or an element of a string array claim must match")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+
+	opts := TokenInfoOptions{
+		Timeout:             pluginMeta.timeout,
+		JWKSRefreshInterval: pluginMeta.jwksRefreshInterval,
+		ClockSkew:           pluginMeta.clockSkew,
+		JWKSUrl:             pluginMeta.jwksUrl,
+		Issuers:             pluginMeta.issuer,
+		Audience:            pluginMeta.audience,
+		Claims:              pluginMeta.claims,
+	}
+
+	return NewTokenInfo(opts)
+}
@@ -0,0 +1,199 @@
+package oidcinfo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+const maxResponseSize = 1 << 20
+
+// https://tools.ietf.org/html/rfc7517#section-5
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// https://tools.ietf.org/html/rfc7518#section-6
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (key *jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", key.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// getPublicKeys fetches the signature keys of the key set by the key id
+func getPublicKeys(ctx context.Context, client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	var keySet jwks
+	if err := getJSON(ctx, client, url, &keySet); err != nil {
+		return nil, err
+	}
+	publicKeys := make(map[string]crypto.PublicKey)
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse public key %s: %v", key.Kid, err)
+		}
+		publicKeys[key.Kid] = publicKey
+	}
+	if len(publicKeys) == 0 {
+		return nil, errors.New("JWKS signature keys must not be empty")
+	}
+	return publicKeys, nil
+}
+
+// discoverJWKSUrl returns the jwks_uri of the OpenID Provider Configuration of the issuer
+func discoverJWKSUrl(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	var configuration struct {
+		JWKSUri string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &configuration); err != nil {
+		return "", err
+	}
+	if configuration.JWKSUri == "" {
+		return "", fmt.Errorf("openid-configuration of %s has no jwks_uri", issuer)
+	}
+	return configuration.JWKSUri, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to %s failed with status %d: %s", url, resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, result)
+}
+
+// verifySignature verifies the JWS signature with the RS*, PS* or ES* algorithm, other algorithms are rejected
+func verifySignature(alg string, publicKey crypto.PublicKey, signed []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires RSA key", alg)
+		}
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		}
+		return rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires EC key", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ECDSA signature size")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+}
@@ -0,0 +1,321 @@
+package oidcinfo
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/cenkalti/backoff"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK                      = 0
+	StatusEmptyToken              = 1
+	StatusParseJWTFailed          = 2
+	StatusUnsupportedAlgorithm    = 3
+	StatusNoExpirationTimeInToken = 4
+	StatusPublicKeyNotFound       = 5
+	StatusWrongIssuer             = 6
+	StatusWrongSignature          = 7
+	StatusTokenTooEarly           = 8
+	StatusTokenExpired            = 9
+	StatusWrongAudience           = 10
+	StatusWrongClaim              = 11
+)
+
+var (
+	nowFn = time.Now
+
+	// minimal interval of the JWKS refreshes caused by unknown key ids
+	minKeysRefreshInterval = 1 * time.Minute
+)
+
+type TokenInfoOptions struct {
+	Timeout             int
+	JWKSRefreshInterval int
+	ClockSkew           int
+	JWKSUrl             string
+	Issuers             []string
+	Audience            []string
+	Claims              []string // name=regex
+}
+
+// TokenInfo validates the JWTs of an OpenID Connect provider: the signature with the keys of the JWKS endpoint,
+// the issuer, the audience, the expiry and the required claims.
+type TokenInfo struct {
+	client    *http.Client
+	timeout   time.Duration
+	clockSkew time.Duration
+	jwksUrl   string
+	issuers   map[string]struct{}
+	audience  map[string]struct{}
+	claims    []claimRegex
+
+	publicKeys    map[string]crypto.PublicKey
+	keysRefreshed time.Time
+	l             sync.RWMutex
+	refreshLock   sync.Mutex
+}
+
+type claimRegex struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func NewTokenInfo(options TokenInfoOptions) (*TokenInfo, error) {
+	claims := make([]claimRegex, 0)
+	for _, claim := range options.Claims {
+		pair := strings.SplitN(claim, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("claim '%s' must be name=regex", claim)
+		}
+		re, err := regexp.Compile(pair[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot compile claim regex %s", claim)
+		}
+		claims = append(claims, claimRegex{name: pair[0], regex: re})
+	}
+	issuers := make(map[string]struct{})
+	for _, elem := range options.Issuers {
+		issuers[elem] = struct{}{}
+	}
+	audience := make(map[string]struct{})
+	for _, elem := range options.Audience {
+		audience[elem] = struct{}{}
+	}
+	if options.JWKSUrl == "" && len(options.Issuers) != 1 {
+		return nil, errors.New("parameter jwks-url or exactly one issuer is required")
+	}
+	logrus.Infof("JWT issuers: %v", options.Issuers)
+	logrus.Infof("JWT target audience: %v", options.Audience)
+	logrus.Infof("JWT claims regexp: %v", options.Claims)
+
+	timeout := time.Duration(options.Timeout) * time.Second
+	tokenInfo := &TokenInfo{
+		client:    &http.Client{Timeout: timeout},
+		timeout:   timeout,
+		clockSkew: time.Duration(options.ClockSkew) * time.Second,
+		jwksUrl:   options.JWKSUrl,
+		issuers:   issuers,
+		audience:  audience,
+		claims:    claims,
+	}
+	op := func() error {
+		if tokenInfo.jwksUrl == "" {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			jwksUrl, err := discoverJWKSUrl(ctx, tokenInfo.client, options.Issuers[0])
+			if err != nil {
+				return err
+			}
+			tokenInfo.jwksUrl = jwksUrl
+			logrus.Infof("Discovered JWKS URL: %s", jwksUrl)
+		}
+		return tokenInfo.refreshKeys()
+	}
+	err := backoff.Retry(op, backoff.WithMaxTries(backoff.NewConstantBackOff(1*time.Second), 3))
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting of JWKS failed")
+	}
+	go tokenInfo.refreshLoop(time.Duration(options.JWKSRefreshInterval) * time.Second)
+	return tokenInfo, nil
+}
+
+func (p *TokenInfo) refreshLoop(interval time.Duration) {
+	logrus.Infof("Refreshing JWKS every: %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := p.refreshKeys(); err != nil {
+			logrus.Errorf("JWKS refresh failed: %v", err)
+		}
+	}
+}
+
+func (p *TokenInfo) refreshKeys() error {
+	p.refreshLock.Lock()
+	defer p.refreshLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	publicKeys, err := getPublicKeys(ctx, p.client, p.jwksUrl)
+	if err != nil {
+		return err
+	}
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.publicKeys = publicKeys
+	p.keysRefreshed = nowFn()
+	return nil
+}
+
+// getPublicKey returns the key, the keys are refreshed for an unknown key id e.g. after the key rotation
+func (p *TokenInfo) getPublicKey(kid string) crypto.PublicKey {
+	p.l.RLock()
+	publicKey, refresh := p.publicKeys[kid], nowFn().Sub(p.keysRefreshed) >= minKeysRefreshInterval
+	p.l.RUnlock()
+
+	if publicKey != nil || !refresh {
+		return publicKey
+	}
+	if err := p.refreshKeys(); err != nil {
+		logrus.Errorf("JWKS refresh failed: %v", err)
+		return nil
+	}
+	p.l.RLock()
+	defer p.l.RUnlock()
+	return p.publicKeys[kid]
+}
+
+// verify token implements apis.TokenInfo VerifyToken method
+func (p *TokenInfo) VerifyToken(parent context.Context, request apis.VerifyRequest) (apis.VerifyResponse, error) {
+	if request.Token == "" {
+		return getVerifyResponseResponse(StatusEmptyToken)
+	}
+	parts := strings.Split(request.Token, ".")
+	if len(parts) != 3 {
+		return getVerifyResponseResponse(StatusParseJWTFailed)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return getVerifyResponseResponse(StatusParseJWTFailed)
+	}
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return getVerifyResponseResponse(StatusParseJWTFailed)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return getVerifyResponseResponse(StatusParseJWTFailed)
+	}
+	if !supportedAlgorithm(header.Alg) {
+		return getVerifyResponseResponse(StatusUnsupportedAlgorithm)
+	}
+	if len(p.issuers) != 0 {
+		issuer, _ := claims["iss"].(string)
+		if _, ok := p.issuers[issuer]; !ok {
+			return getVerifyResponseResponse(StatusWrongIssuer)
+		}
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return getVerifyResponseResponse(StatusNoExpirationTimeInToken)
+	}
+	unix := nowFn().Unix()
+	skew := int64(p.clockSkew.Seconds())
+	if unix > exp+skew {
+		return getVerifyResponseResponse(StatusTokenExpired)
+	}
+	for _, name := range []string{"nbf", "iat"} {
+		if notBefore, ok := numericClaim(claims, name); ok && unix < notBefore-skew {
+			return getVerifyResponseResponse(StatusTokenTooEarly)
+		}
+	}
+	if len(p.audience) != 0 && !p.checkAudience(claims["aud"]) {
+		return getVerifyResponseResponse(StatusWrongAudience)
+	}
+	for _, claim := range p.claims {
+		if !matchClaim(claims[claim.name], claim.regex) {
+			return getVerifyResponseResponse(StatusWrongClaim)
+		}
+	}
+	publicKey := p.getPublicKey(header.Kid)
+	if publicKey == nil {
+		return getVerifyResponseResponse(StatusPublicKeyNotFound)
+	}
+	if err = verifySignature(header.Alg, publicKey, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return getVerifyResponseResponse(StatusWrongSignature)
+	}
+	return apis.VerifyResponse{Success: true, ExpiresAt: exp}, nil
+}
+
+func (p *TokenInfo) checkAudience(aud interface{}) bool {
+	for _, value := range stringValues(aud) {
+		if _, ok := p.audience[value]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchClaim(claim interface{}, regex *regexp.Regexp) bool {
+	for _, value := range stringValues(claim) {
+		if regex.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringValues returns the string or the strings of the array claim
+func stringValues(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, elem := range value {
+			if s, ok := elem.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	value, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	if i, err := value.Int64(); err == nil {
+		return i, true
+	}
+	f, err := value.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func supportedAlgorithm(alg string) bool {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512":
+		return true
+	default:
+		return false
+	}
+}
+
+func getVerifyResponseResponse(status int) (apis.VerifyResponse, error) {
+	success := status == StatusOK
+	return apis.VerifyResponse{Success: success, Status: int32(status)}, nil
+}
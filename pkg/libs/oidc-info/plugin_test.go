@@ -0,0 +1,134 @@
+package oidcinfo
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testJWT(a *assert.Assertions, alg string, kid string, claims map[string]interface{}, key crypto.Signer) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	a.Nil(err)
+	payload, err := json.Marshal(claims)
+	a.Nil(err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		a.Nil(err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		a.Nil(err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testJWKSServer(a *assert.Assertions, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *httptest.Server {
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	keys := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "use": "sig", "kid": "rsa-1", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+		{"kty": "RSA", "use": "enc", "kid": "enc-1", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+	}}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":"%s","jwks_uri":"%s/keys"}`, server.URL, server.URL)
+		case "/keys":
+			a.Nil(json.NewEncoder(w).Encode(keys))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestVerifyToken(t *testing.T) {
+	a := assert.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.Nil(err)
+	server := testJWKSServer(a, rsaKey, ecKey)
+	defer server.Close()
+
+	tokenInfo, err := NewTokenInfo(TokenInfoOptions{Timeout: 5, JWKSRefreshInterval: 3600, ClockSkew: 60, Issuers: []string{server.URL}, Audience: []string{"kafka"}, Claims: []string{"groups=^kafka-(admins|users)$"}})
+	a.Nil(err)
+	a.Equal(server.URL+"/keys", tokenInfo.jwksUrl)
+	a.Len(tokenInfo.publicKeys, 2)
+
+	now := time.Now().Unix()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": server.URL, "aud": []string{"other", "kafka"}, "iat": now, "exp": now + 300, "groups": []string{"kafka-users"}}
+	}
+	verify := func(token string) int32 {
+		response, err := tokenInfo.VerifyToken(context.Background(), apis.VerifyRequest{Token: token})
+		a.Nil(err)
+		a.Equal(response.Status == StatusOK, response.Success)
+		return response.Status
+	}
+
+	a.Equal(int32(StatusOK), verify(testJWT(a, "RS256", "rsa-1", claims(), rsaKey)))
+	a.Equal(int32(StatusOK), verify(testJWT(a, "ES256", "ec-1", claims(), ecKey)))
+	a.Equal(int32(StatusEmptyToken), verify(""))
+	a.Equal(int32(StatusParseJWTFailed), verify("a.b"))
+	a.Equal(int32(StatusUnsupportedAlgorithm), verify(testJWT(a, "HS256", "rsa-1", claims(), rsaKey)))
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	a.Equal(int32(StatusWrongSignature), verify(testJWT(a, "RS256", "rsa-1", claims(), otherKey)))
+	a.Equal(int32(StatusWrongSignature), verify(testJWT(a, "ES256", "rsa-1", claims(), ecKey)))
+	a.Equal(int32(StatusPublicKeyNotFound), verify(testJWT(a, "RS256", "enc-1", claims(), rsaKey)))
+
+	c := claims()
+	c["iss"] = "https://other"
+	a.Equal(int32(StatusWrongIssuer), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	c["aud"] = "other"
+	a.Equal(int32(StatusWrongAudience), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	delete(c, "exp")
+	a.Equal(int32(StatusNoExpirationTimeInToken), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	c["exp"] = now - 120
+	a.Equal(int32(StatusTokenExpired), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	c["nbf"] = now + 120
+	a.Equal(int32(StatusTokenTooEarly), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	c["groups"] = "kafka-guests"
+	a.Equal(int32(StatusWrongClaim), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+	c = claims()
+	delete(c, "groups")
+	a.Equal(int32(StatusWrongClaim), verify(testJWT(a, "RS256", "rsa-1", c, rsaKey)))
+}
+
+func TestNewTokenInfoOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewTokenInfo(TokenInfoOptions{Timeout: 1})
+	a.EqualError(err, "parameter jwks-url or exactly one issuer is required")
+	_, err = NewTokenInfo(TokenInfoOptions{Timeout: 1, JWKSUrl: "http://127.0.0.1:1/keys", Claims: []string{"groups"}})
+	a.EqualError(err, "claim 'groups' must be name=regex")
+}
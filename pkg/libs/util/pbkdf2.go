@@ -0,0 +1,25 @@
+package util
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// PBKDF2 derives the key of the hash size (https://tools.ietf.org/html/rfc2898#section-5.2)
+func PBKDF2(h func() hash.Hash, password []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(h, password)
+	prf.Write(salt)
+	prf.Write([]byte{0x00, 0x00, 0x00, 0x01})
+	u := prf.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
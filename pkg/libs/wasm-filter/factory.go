@@ -0,0 +1,41 @@
+package wasmfilter
+
+import (
+	"flag"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/registry"
+	"time"
+)
+
+func init() {
+	registry.NewComponentInterface(new(apis.FrameFilterFactory))
+	registry.Register(new(Factory), "wasm")
+}
+
+func (f *pluginMeta) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("wasm filter settings", flag.ContinueOnError)
+	return fs
+}
+
+type pluginMeta struct {
+	module    string
+	instances int
+	timeout   time.Duration
+}
+
+type Factory struct {
+}
+
+// New implements apis.FrameFilterFactory
+func (t *Factory) New(params []string) (apis.FrameFilter, error) {
+	pluginMeta := &pluginMeta{}
+	fs := pluginMeta.flagSet()
+	fs.StringVar(&pluginMeta.module, "module", "", "Path of the WASM module with the filter functions")
+	fs.IntVar(&pluginMeta.instances, "instances", 4, "Number of the module instances filtering the frames concurrently")
+	fs.DurationVar(&pluginMeta.timeout, "timeout", 100*time.Millisecond, "Max time of a filter call, the instance is replaced after the timeout")
+
+	if err := fs.Parse(params); err != nil {
+		return nil, err
+	}
+	return NewWasmFilter(pluginMeta.module, pluginMeta.instances, pluginMeta.timeout)
+}
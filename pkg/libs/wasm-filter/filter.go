@@ -0,0 +1,264 @@
+package wasmfilter
+
+import (
+	"context"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"io/ioutil"
+	"reflect"
+	"time"
+)
+
+// The host ABI of the WASM filters. The module exports its memory, alloc and at least one of the filter functions:
+//
+//	alloc(size i32) i32                                                    returns the buffer the host writes the frame to
+//	filter_request(ptr i32, len i32) i64                                   request without the size field
+//	filter_response(api_key i32, api_version i32, ptr i32, len i32) i64    response without the size and correlation id fields
+//
+// The filter functions return 0 to pass the frame unchanged, or ptr<<32|len of the frame replacing it. The buffers are owned
+// by the module, the returned frame is copied before the next call. The module can import from the kafka_proxy module:
+//
+//	log(ptr i32, len i32)                          logs the message
+//	reject(error_code i32, ptr i32, len i32)       answers the request with the Kafka error code and the reason instead of sending it
+//
+// WASI is available, the reactor modules are initialized by _initialize.
+const (
+	hostModuleName     = "kafka_proxy"
+	allocFunction      = "alloc"
+	requestFunction    = "filter_request"
+	responseFunction   = "filter_response"
+	initializeFunction = "_initialize"
+)
+
+var (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+// WasmFilter runs the request and response filters of a WASM module. A module instance is not safe for concurrent use, the
+// calls take an instance from the pool.
+type WasmFilter struct {
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	instances chan *instance
+	timeout   time.Duration
+
+	filtersRequests  bool
+	filtersResponses bool
+}
+
+type instance struct {
+	module         api.Module
+	alloc          api.Function
+	filterRequest  api.Function
+	filterResponse api.Function
+}
+
+// callKey is the context key of the call, the reject host function sets its rejection
+type callKey struct{}
+
+type call struct {
+	rejection *apis.FrameRejection
+}
+
+func NewWasmFilter(modulePath string, instances int, timeout time.Duration) (*WasmFilter, error) {
+	if modulePath == "" {
+		return nil, errors.New("module parameter is required")
+	}
+	if instances <= 0 {
+		return nil, errors.New("instances parameter must be greater than 0")
+	}
+	if timeout <= 0 {
+		return nil, errors.New("timeout parameter must be greater than 0")
+	}
+	binary, err := ioutil.ReadFile(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	// the calls running over the timeout are stopped
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	f, err := newWasmFilter(ctx, runtime, binary, instances, timeout)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, errors.Wrapf(err, "WASM module %s", modulePath)
+	}
+	return f, nil
+}
+
+func newWasmFilter(ctx context.Context, runtime wazero.Runtime, binary []byte, instances int, timeout time.Duration) (*WasmFilter, error) {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, err
+	}
+	_, err := runtime.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().WithFunc(hostLog).Export("log").
+		NewFunctionBuilder().WithFunc(hostReject).Export("reject").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+	if len(compiled.ExportedMemories()) == 0 {
+		return nil, errors.New("module must export its memory")
+	}
+	exports := compiled.ExportedFunctions()
+	if err = checkSignature(exports, allocFunction, []api.ValueType{i32}, []api.ValueType{i32}); err != nil {
+		return nil, err
+	}
+	f := &WasmFilter{
+		runtime:          runtime,
+		compiled:         compiled,
+		instances:        make(chan *instance, instances),
+		timeout:          timeout,
+		filtersRequests:  exports[requestFunction] != nil,
+		filtersResponses: exports[responseFunction] != nil,
+	}
+	if !f.filtersRequests && !f.filtersResponses {
+		return nil, fmt.Errorf("module must export %s or %s", requestFunction, responseFunction)
+	}
+	if f.filtersRequests {
+		if err = checkSignature(exports, requestFunction, []api.ValueType{i32, i32}, []api.ValueType{i64}); err != nil {
+			return nil, err
+		}
+	}
+	if f.filtersResponses {
+		if err = checkSignature(exports, responseFunction, []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i64}); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < instances; i++ {
+		inst, err := f.instantiate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f.instances <- inst
+	}
+	return f, nil
+}
+
+func checkSignature(exports map[string]api.FunctionDefinition, name string, params []api.ValueType, results []api.ValueType) error {
+	def := exports[name]
+	if def == nil {
+		return fmt.Errorf("module must export %s", name)
+	}
+	if !reflect.DeepEqual(def.ParamTypes(), params) || !reflect.DeepEqual(def.ResultTypes(), results) {
+		return fmt.Errorf("%s has the signature %v -> %v, expected %v -> %v", name, valueTypeNames(def.ParamTypes()), valueTypeNames(def.ResultTypes()), valueTypeNames(params), valueTypeNames(results))
+	}
+	return nil
+}
+
+func valueTypeNames(types []api.ValueType) []string {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, api.ValueTypeName(t))
+	}
+	return names
+}
+
+func (f *WasmFilter) instantiate(ctx context.Context) (*instance, error) {
+	// the instances are anonymous, so the module can be instantiated more than once
+	module, err := f.runtime.InstantiateModule(ctx, f.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions(initializeFunction))
+	if err != nil {
+		return nil, err
+	}
+	return &instance{
+		module:         module,
+		alloc:          module.ExportedFunction(allocFunction),
+		filterRequest:  module.ExportedFunction(requestFunction),
+		filterResponse: module.ExportedFunction(responseFunction),
+	}, nil
+}
+
+// FilterRequest implements apis.FrameFilter
+func (f *WasmFilter) FilterRequest(request []byte) ([]byte, error) {
+	if !f.filtersRequests {
+		return request, nil
+	}
+	return f.filter(func(inst *instance) api.Function { return inst.filterRequest }, request)
+}
+
+// FilterResponse implements apis.FrameFilter
+func (f *WasmFilter) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if !f.filtersResponses {
+		return response, nil
+	}
+	return f.filter(func(inst *instance) api.Function { return inst.filterResponse }, response, api.EncodeI32(int32(apiKey)), api.EncodeI32(int32(apiVersion)))
+}
+
+func (f *WasmFilter) filter(function func(*instance) api.Function, frame []byte, params ...uint64) ([]byte, error) {
+	inst := <-f.instances
+	result, err := f.call(inst, function(inst), frame, params)
+	if err != nil {
+		if _, rejected := err.(*apis.FrameRejection); !rejected {
+			// the trapped or stopped instance is replaced, it is put back closed and replaced by the next call when it cannot be
+			inst.module.Close(context.Background())
+			if replacement, ierr := f.instantiate(context.Background()); ierr != nil {
+				logrus.Errorf("WASM filter instance cannot be replaced: %v", ierr)
+			} else {
+				inst = replacement
+			}
+		}
+	}
+	f.instances <- inst
+	return result, err
+}
+
+func (f *WasmFilter) call(inst *instance, function api.Function, frame []byte, params []uint64) ([]byte, error) {
+	c := &call{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), callKey{}, c), f.timeout)
+	defer cancel()
+
+	results, err := inst.alloc.Call(ctx, uint64(len(frame)))
+	if err != nil {
+		return nil, errors.Wrap(err, allocFunction)
+	}
+	ptr := api.DecodeU32(results[0])
+	if !inst.module.Memory().Write(ptr, frame) {
+		return nil, fmt.Errorf("%s returned the buffer %d of length %d out of the memory", allocFunction, ptr, len(frame))
+	}
+	results, err = function.Call(ctx, append(params, uint64(ptr), uint64(len(frame)))...)
+	if err != nil {
+		return nil, errors.Wrap(err, function.Definition().Name())
+	}
+	if c.rejection != nil {
+		return nil, c.rejection
+	}
+	if results[0] == 0 {
+		return frame, nil
+	}
+	resultPtr, resultLen := uint32(results[0]>>32), uint32(results[0])
+	result, ok := inst.module.Memory().Read(resultPtr, resultLen)
+	if !ok {
+		return nil, fmt.Errorf("%s returned the frame %d of length %d out of the memory", function.Definition().Name(), resultPtr, resultLen)
+	}
+	// the memory view is reused by the next call
+	return append([]byte(nil), result...), nil
+}
+
+// Close releases the module instances
+func (f *WasmFilter) Close() {
+	f.runtime.Close(context.Background())
+}
+
+func hostLog(ctx context.Context, m api.Module, ptr uint32, length uint32) {
+	if message, ok := m.Memory().Read(ptr, length); ok {
+		logrus.Infof("WASM filter: %s", message)
+	}
+}
+
+func hostReject(ctx context.Context, m api.Module, errorCode int32, ptr uint32, length uint32) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+	reason, _ := m.Memory().Read(ptr, length)
+	c.rejection = &apis.FrameRejection{ErrorCode: int16(errorCode), Reason: string(reason)}
+}
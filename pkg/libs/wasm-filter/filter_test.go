@@ -0,0 +1,195 @@
+package wasmfilter
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testTimeout = 100 * time.Millisecond
+
+func testULEB(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func testVector(items ...[]byte) []byte {
+	b := testULEB(uint32(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func testName(name string) []byte {
+	return append(testULEB(uint32(len(name))), name...)
+}
+
+func testSection(id byte, items ...[]byte) []byte {
+	contents := testVector(items...)
+	return append(append([]byte{id}, testULEB(uint32(len(contents)))...), contents...)
+}
+
+func testFunctionBody(code ...byte) []byte {
+	// no locals
+	body := append([]byte{0x00}, code...)
+	return append(testULEB(uint32(len(body))), body...)
+}
+
+// testModule encodes the filter module:
+//
+//	alloc returns the buffer at 1024
+//	filter_request rejects Produce with the error code 29, loops forever on ApiVersions and replaces the first byte of the client id by X
+//	filter_response logs the response and drops the last byte of the Metadata responses
+func testModule() []byte {
+	const i32, i64 = 0x7f, 0x7e
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, testSection(1,
+		[]byte{0x60, 1, i32, 1, i32},
+		[]byte{0x60, 2, i32, i32, 1, i64},
+		[]byte{0x60, 4, i32, i32, i32, i32, 1, i64},
+		[]byte{0x60, 2, i32, i32, 0},
+		[]byte{0x60, 3, i32, i32, i32, 0},
+	)...)
+	module = append(module, testSection(2,
+		append(append(testName("kafka_proxy"), testName("log")...), 0x00, 3),
+		append(append(testName("kafka_proxy"), testName("reject")...), 0x00, 4),
+	)...)
+	module = append(module, testSection(3, []byte{0}, []byte{1}, []byte{2})...)
+	module = append(module, testSection(5, []byte{0x00, 1})...)
+	module = append(module, testSection(7,
+		append(testName("memory"), 0x02, 0),
+		append(testName("alloc"), 0x00, 2),
+		append(testName("filter_request"), 0x00, 3),
+		append(testName("filter_response"), 0x00, 4),
+	)...)
+	module = append(module, testSection(10,
+		testFunctionBody(0x41, 0x80, 0x08, 0x0b),
+		testFunctionBody(
+			// if the api key is 0: reject(29, 0, 19), return 0
+			0x20, 0, 0x2d, 0, 1, 0x45, 0x04, 0x40,
+			0x41, 29, 0x41, 0, 0x41, 19, 0x10, 1, 0x42, 0, 0x0f,
+			0x0b,
+			// if the api key is 18: loop forever
+			0x20, 0, 0x2d, 0, 1, 0x41, 18, 0x46, 0x04, 0x40,
+			0x03, 0x40, 0x0c, 0, 0x0b,
+			0x0b,
+			// request[10] = 'X', return ptr<<32|len
+			0x20, 0, 0x41, 0xd8, 0x00, 0x3a, 0, 10,
+			0x20, 0, 0xad, 0x42, 32, 0x86, 0x20, 1, 0xad, 0x84,
+			0x0b,
+		),
+		testFunctionBody(
+			// log(ptr, len)
+			0x20, 2, 0x20, 3, 0x10, 0,
+			// if the api key is 3: return ptr<<32|len-1, else 0
+			0x20, 0, 0x41, 3, 0x46, 0x04, i64,
+			0x20, 2, 0xad, 0x42, 32, 0x86, 0x20, 3, 0x41, 1, 0x6b, 0xad, 0x84,
+			0x05, 0x42, 0, 0x0b,
+			0x0b,
+		),
+	)...)
+	return append(module, testSection(11,
+		append([]byte{0x00, 0x41, 0, 0x0b}, testName("produce is disabled")...),
+	)...)
+}
+
+func testModuleFile(a *assert.Assertions, dir string, module []byte) string {
+	path := filepath.Join(dir, "filter.wasm")
+	a.Nil(ioutil.WriteFile(path, module, 0600))
+	return path
+}
+
+func TestWasmFilterRequest(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "wasm-filter")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	path := testModuleFile(a, dir, testModule())
+
+	filter, err := new(Factory).New([]string{"--module", path, "--instances", "2"})
+	a.Nil(err)
+	defer filter.(*WasmFilter).Close()
+
+	// Metadata v0 request with client id "c" and topic "t1"
+	request := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'}
+	filtered, err := filter.FilterRequest(request)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'X', 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'}, filtered)
+	a.Equal(byte('c'), request[10])
+
+	_, err = filter.FilterRequest([]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 'c'})
+	a.Equal(&apis.FrameRejection{ErrorCode: 29, Reason: "produce is disabled"}, err)
+}
+
+func TestWasmFilterResponse(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "wasm-filter")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filter, err := NewWasmFilter(testModuleFile(a, dir, testModule()), 1, testTimeout)
+	a.Nil(err)
+	defer filter.Close()
+
+	filtered, err := filter.FilterResponse(3, 0, []byte{0x01, 0x02, 0x03})
+	a.Nil(err)
+	a.Equal([]byte{0x01, 0x02}, filtered)
+	filtered, err = filter.FilterResponse(1, 0, []byte{0x01, 0x02, 0x03})
+	a.Nil(err)
+	a.Equal([]byte{0x01, 0x02, 0x03}, filtered)
+}
+
+func TestWasmFilterTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "wasm-filter")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filter, err := NewWasmFilter(testModuleFile(a, dir, testModule()), 1, testTimeout)
+	a.Nil(err)
+	defer filter.Close()
+
+	// the ApiVersions request never returns, the only instance is replaced
+	_, err = filter.FilterRequest([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.NotNil(err)
+	filtered, err := filter.FilterRequest([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 'c'})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 'X'}, filtered)
+}
+
+func TestNewWasmFilter(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "wasm-filter")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	_, err = new(Factory).New([]string{})
+	a.EqualError(err, "module parameter is required")
+	_, err = new(Factory).New([]string{"--module", "filter.wasm", "--instances", "0"})
+	a.EqualError(err, "instances parameter must be greater than 0")
+
+	// the module without the memory and the functions
+	path := testModuleFile(a, dir, []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00})
+	_, err = NewWasmFilter(path, 1, testTimeout)
+	a.EqualError(err, "WASM module "+path+": module must export its memory")
+
+	path = testModuleFile(a, dir, []byte("not a module"))
+	_, err = NewWasmFilter(path, 1, testTimeout)
+	a.NotNil(err)
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+)
+
+// The in-process plugins are linked into a custom build and selected by the name given as the plugin command
+// e.g. --auth-local-command=<name>, the plugin subprocess and the RPC calls are avoided. They are registered by init()
+// of a package imported by the main package of the build, the component interfaces are declared before.
+func init() {
+	NewComponentInterface(new(apis.PasswordAuthenticatorFactory))
+	NewComponentInterface(new(apis.TokenProviderFactory))
+	NewComponentInterface(new(apis.TokenInfoFactory))
+	NewComponentInterface(new(apis.RequestAuthorizerFactory))
+	NewComponentInterface(new(apis.KeySignerFactory))
+}
+
+// RegisterPasswordAuthenticator registers the PasswordAuthenticator selected by --auth-local-command=<name>
+func RegisterPasswordAuthenticator(name string, factory apis.PasswordAuthenticatorFactory) error {
+	return registerPlugin(new(apis.PasswordAuthenticatorFactory), name, factory)
+}
+
+// RegisterTokenProvider registers the TokenProvider selected by --auth-gateway-client-command=<name>
+func RegisterTokenProvider(name string, factory apis.TokenProviderFactory) error {
+	return registerPlugin(new(apis.TokenProviderFactory), name, factory)
+}
+
+// RegisterTokenInfo registers the TokenInfo selected by --auth-gateway-server-command=<name>
+func RegisterTokenInfo(name string, factory apis.TokenInfoFactory) error {
+	return registerPlugin(new(apis.TokenInfoFactory), name, factory)
+}
+
+// RegisterRequestAuthorizer registers the RequestAuthorizer selected by --auth-authz-command=<name>
+func RegisterRequestAuthorizer(name string, factory apis.RequestAuthorizerFactory) error {
+	return registerPlugin(new(apis.RequestAuthorizerFactory), name, factory)
+}
+
+// RegisterKeySigner registers the KeySigner selected by --proxy-listener-key-signer-command=<name>
+func RegisterKeySigner(name string, factory apis.KeySignerFactory) error {
+	return registerPlugin(new(apis.KeySignerFactory), name, factory)
+}
+
+func registerPlugin(iface interface{}, name string, factory interface{}) error {
+	if name == "" {
+		return errors.New("plugin name is required")
+	}
+	if factory == nil {
+		return fmt.Errorf("factory of plugin %s is nil", name)
+	}
+	ep := NewComponentInterface(iface)
+	if !ep.register(factory, name) {
+		return fmt.Errorf("%s %s is already registered", ep.iface.Name(), name)
+	}
+	return nil
+}
@@ -53,3 +53,28 @@ func TestRegistry(t *testing.T) {
 	a.True(ok)
 	a.NotNil(component2)
 }
+
+type passwordAuthenticatorFactory struct {
+}
+
+func (t *passwordAuthenticatorFactory) New(params []string) (apis.PasswordAuthenticator, error) {
+	return nil, nil
+}
+
+func TestRegisterPlugins(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(RegisterPasswordAuthenticator("in-process-auth", new(passwordAuthenticatorFactory)))
+	component, ok := GetComponent(new(apis.PasswordAuthenticatorFactory), "in-process-auth").(apis.PasswordAuthenticatorFactory)
+	a.True(ok)
+	a.NotNil(component)
+
+	a.EqualError(RegisterPasswordAuthenticator("in-process-auth", new(passwordAuthenticatorFactory)), "PasswordAuthenticatorFactory in-process-auth is already registered")
+	a.EqualError(RegisterPasswordAuthenticator("", new(passwordAuthenticatorFactory)), "plugin name is required")
+	a.EqualError(RegisterTokenInfo("in-process-info", nil), "factory of plugin in-process-info is nil")
+
+	// the name is unique per component interface
+	a.Nil(RegisterTokenProvider("in-process-auth", new(tokenProviderFactory)))
+	_, ok = GetComponent(new(apis.TokenProviderFactory), "in-process-auth").(apis.TokenProviderFactory)
+	a.True(ok)
+}
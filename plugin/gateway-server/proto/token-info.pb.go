@@ -34,8 +34,12 @@ var _ = math.Inf
 const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type VerifyRequest struct {
-	Token  string   `protobuf:"bytes,1,opt,name=token" json:"token,omitempty"`
-	Params []string `protobuf:"bytes,2,rep,name=params" json:"params,omitempty"`
+	Token             string   `protobuf:"bytes,1,opt,name=token" json:"token,omitempty"`
+	Params            []string `protobuf:"bytes,2,rep,name=params" json:"params,omitempty"`
+	ClientAddress     string   `protobuf:"bytes,3,opt,name=client_address,json=clientAddress" json:"client_address,omitempty"`
+	ListenerAddress   string   `protobuf:"bytes,4,opt,name=listener_address,json=listenerAddress" json:"listener_address,omitempty"`
+	ServerName        string   `protobuf:"bytes,5,opt,name=server_name,json=serverName" json:"server_name,omitempty"`
+	ClientCertSubject string   `protobuf:"bytes,6,opt,name=client_cert_subject,json=clientCertSubject" json:"client_cert_subject,omitempty"`
 }
 
 func (m *VerifyRequest) Reset()                    { *m = VerifyRequest{} }
@@ -57,9 +61,38 @@ func (m *VerifyRequest) GetParams() []string {
 	return nil
 }
 
+func (m *VerifyRequest) GetClientAddress() string {
+	if m != nil {
+		return m.ClientAddress
+	}
+	return ""
+}
+
+func (m *VerifyRequest) GetListenerAddress() string {
+	if m != nil {
+		return m.ListenerAddress
+	}
+	return ""
+}
+
+func (m *VerifyRequest) GetServerName() string {
+	if m != nil {
+		return m.ServerName
+	}
+	return ""
+}
+
+func (m *VerifyRequest) GetClientCertSubject() string {
+	if m != nil {
+		return m.ClientCertSubject
+	}
+	return ""
+}
+
 type VerifyResponse struct {
-	Success bool  `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
-	Status  int32 `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	Success   bool  `protobuf:"varint,1,opt,name=success" json:"success,omitempty"`
+	Status    int32 `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	ExpiresAt int64 `protobuf:"varint,3,opt,name=expires_at,json=expiresAt" json:"expires_at,omitempty"`
 }
 
 func (m *VerifyResponse) Reset()                    { *m = VerifyResponse{} }
@@ -81,6 +114,13 @@ func (m *VerifyResponse) GetStatus() int32 {
 	return 0
 }
 
+func (m *VerifyResponse) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
 func init() {
 	proto1.RegisterType((*VerifyRequest)(nil), "proto.VerifyRequest")
 	proto1.RegisterType((*VerifyResponse)(nil), "proto.VerifyResponse")
@@ -161,17 +201,23 @@ var _TokenInfo_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("token-info.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 178 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x28, 0xc9, 0xcf, 0x4e,
-	0xcd, 0xd3, 0xcd, 0xcc, 0x4b, 0xcb, 0xd7, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05, 0x53,
-	0x4a, 0xb6, 0x5c, 0xbc, 0x61, 0xa9, 0x45, 0x99, 0x69, 0x95, 0x41, 0xa9, 0x85, 0xa5, 0xa9, 0xc5,
-	0x25, 0x42, 0x22, 0x5c, 0xac, 0x60, 0xb5, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x10, 0x8e,
-	0x90, 0x18, 0x17, 0x5b, 0x41, 0x62, 0x51, 0x62, 0x6e, 0xb1, 0x04, 0x93, 0x02, 0xb3, 0x06, 0x67,
-	0x10, 0x94, 0xa7, 0xe4, 0xc4, 0xc5, 0x07, 0xd3, 0x5e, 0x5c, 0x90, 0x9f, 0x57, 0x9c, 0x2a, 0x24,
-	0xc1, 0xc5, 0x5e, 0x5c, 0x9a, 0x9c, 0x9c, 0x5a, 0x5c, 0x0c, 0x36, 0x81, 0x23, 0x08, 0xc6, 0x05,
-	0x99, 0x51, 0x5c, 0x92, 0x58, 0x52, 0x0a, 0x32, 0x83, 0x51, 0x83, 0x35, 0x08, 0xca, 0x33, 0x72,
-	0xe7, 0xe2, 0x0c, 0x01, 0x59, 0xe2, 0x99, 0x97, 0x96, 0x2f, 0x64, 0xc5, 0xc5, 0x0d, 0x31, 0x10,
-	0x2c, 0x24, 0x24, 0x02, 0x71, 0xad, 0x1e, 0x8a, 0x1b, 0xa5, 0x44, 0xd1, 0x44, 0x21, 0x56, 0x27,
-	0xb1, 0x81, 0x45, 0x8d, 0x01, 0x01, 0x00, 0x00, 0xff, 0xff, 0x44, 0xc5, 0xe0, 0x91, 0xed, 0x00,
-	0x00, 0x00,
+	// 283 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5d, 0x90, 0x41, 0x4b, 0xc3, 0x40,
+	0x10, 0x85, 0x89, 0x31, 0xd1, 0x4c, 0x69, 0xad, 0x6b, 0x95, 0x45, 0x10, 0x4b, 0x41, 0xa8, 0x07,
+	0x73, 0xd0, 0x9b, 0xb7, 0xe2, 0x41, 0xbc, 0x78, 0x88, 0xe2, 0x35, 0x6c, 0xd3, 0x29, 0x44, 0xdb,
+	0x4d, 0xdc, 0x99, 0x88, 0xfe, 0x5b, 0x7f, 0x8a, 0x9b, 0xdd, 0x44, 0xd0, 0xd3, 0xf2, 0xbe, 0x79,
+	0xcb, 0xcc, 0x7b, 0x30, 0xe6, 0xea, 0x0d, 0xf5, 0x55, 0xa9, 0xd7, 0x55, 0x5a, 0x9b, 0x8a, 0x2b,
+	0x11, 0xb9, 0x67, 0xf6, 0x1d, 0xc0, 0xf0, 0x05, 0x4d, 0xb9, 0xfe, 0xca, 0xf0, 0xbd, 0x41, 0x62,
+	0x31, 0x81, 0xc8, 0x99, 0x65, 0x30, 0x0d, 0xe6, 0x49, 0xe6, 0x85, 0x38, 0x81, 0xb8, 0x56, 0x46,
+	0x6d, 0x49, 0xee, 0x4c, 0x43, 0x8b, 0x3b, 0x25, 0x2e, 0x60, 0x54, 0x6c, 0x4a, 0xd4, 0x9c, 0xab,
+	0xd5, 0xca, 0x20, 0x91, 0x0c, 0xdd, 0xb7, 0xa1, 0xa7, 0x0b, 0x0f, 0xc5, 0x25, 0x8c, 0x37, 0x25,
+	0x31, 0x6a, 0x34, 0xbf, 0xc6, 0x5d, 0x67, 0x3c, 0xe8, 0x79, 0x6f, 0x3d, 0x87, 0x01, 0xa1, 0xf9,
+	0xb0, 0x46, 0xad, 0xb6, 0x28, 0x23, 0xe7, 0x02, 0x8f, 0x1e, 0x2d, 0x11, 0x29, 0x1c, 0x75, 0x2b,
+	0x0b, 0x34, 0x9c, 0x53, 0xb3, 0x7c, 0xc5, 0x82, 0x65, 0xec, 0x8c, 0x87, 0x7e, 0x74, 0x67, 0x27,
+	0x4f, 0x7e, 0x30, 0x53, 0x30, 0xea, 0x13, 0x52, 0x5d, 0x69, 0x42, 0x21, 0x61, 0x8f, 0x9a, 0xa2,
+	0x68, 0x8f, 0x68, 0x43, 0xee, 0x67, 0xbd, 0x6c, 0x63, 0x12, 0x2b, 0x6e, 0xda, 0x98, 0xc1, 0x3c,
+	0xca, 0x3a, 0x25, 0xce, 0x00, 0xf0, 0xb3, 0x2e, 0xed, 0x81, 0xb9, 0x62, 0x17, 0x31, 0xcc, 0x92,
+	0x8e, 0x2c, 0xf8, 0xfa, 0x1e, 0x92, 0xe7, 0xb6, 0xa6, 0x07, 0xdb, 0xaf, 0xb8, 0x85, 0x81, 0xdf,
+	0xe7, 0x90, 0x98, 0xf8, 0xc2, 0xd3, 0x3f, 0x2d, 0x9f, 0x1e, 0xff, 0xa3, 0xfe, 0xb2, 0x65, 0xec,
+	0xe8, 0xcd, 0x0f, 0x46, 0xed, 0xad, 0x26, 0xb0, 0x01, 0x00, 0x00,
 }
@@ -14,8 +14,18 @@ type GRPCClient struct {
 }
 
 func (m *GRPCClient) VerifyToken(ctx context.Context, request apis.VerifyRequest) (apis.VerifyResponse, error) {
-	resp, err := m.client.VerifyToken(ctx, &proto.VerifyRequest{Token: request.Token, Params: request.Params})
-	return apis.VerifyResponse{Success: resp.Success, Status: resp.Status}, err
+	resp, err := m.client.VerifyToken(ctx, &proto.VerifyRequest{
+		Token:             request.Token,
+		Params:            request.Params,
+		ClientAddress:     request.AuthContext.ClientAddress,
+		ListenerAddress:   request.AuthContext.ListenerAddress,
+		ServerName:        request.AuthContext.ServerName,
+		ClientCertSubject: request.AuthContext.ClientCertSubject,
+	})
+	if err != nil {
+		return apis.VerifyResponse{}, err
+	}
+	return apis.VerifyResponse{Success: resp.Success, Status: resp.Status, ExpiresAt: resp.ExpiresAt}, nil
 }
 
 // Here is the gRPC server that GRPCClient talks to.
@@ -27,6 +37,12 @@ type GRPCServer struct {
 func (m *GRPCServer) VerifyToken(
 	ctx context.Context,
 	req *proto.VerifyRequest) (*proto.VerifyResponse, error) {
-	resp, err := m.Impl.VerifyToken(ctx, apis.VerifyRequest{Token: req.Token, Params: req.Params})
-	return &proto.VerifyResponse{Success: resp.Success, Status: resp.Status}, err
+	authContext := apis.AuthContext{
+		ClientAddress:     req.ClientAddress,
+		ListenerAddress:   req.ListenerAddress,
+		ServerName:        req.ServerName,
+		ClientCertSubject: req.ClientCertSubject,
+	}
+	resp, err := m.Impl.VerifyToken(ctx, apis.VerifyRequest{Token: req.Token, Params: req.Params, AuthContext: authContext})
+	return &proto.VerifyResponse{Success: resp.Success, Status: resp.Status, ExpiresAt: resp.ExpiresAt}, err
 }
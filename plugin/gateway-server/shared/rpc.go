@@ -11,10 +11,19 @@ type RPCClient struct{ client *rpc.Client }
 func (m *RPCClient) VerifyToken(ctx context.Context, request apis.VerifyRequest) (apis.VerifyResponse, error) {
 	var resp map[string]interface{}
 	err := m.client.Call("Plugin.VerifyToken", map[string]interface{}{
-		"token":  request.Token,
-		"params": request.Params,
+		"token":               request.Token,
+		"params":              request.Params,
+		"client_address":      request.AuthContext.ClientAddress,
+		"listener_address":    request.AuthContext.ListenerAddress,
+		"server_name":         request.AuthContext.ServerName,
+		"client_cert_subject": request.AuthContext.ClientCertSubject,
 	}, &resp)
-	return apis.VerifyResponse{Success: resp["success"].(bool), Status: resp["status"].(int32)}, err
+	if err != nil {
+		return apis.VerifyResponse{}, err
+	}
+	// the expiry is missing in the responses of older plugins
+	expiresAt, _ := resp["expires_at"].(int64)
+	return apis.VerifyResponse{Success: resp["success"].(bool), Status: resp["status"].(int32), ExpiresAt: expiresAt}, nil
 }
 
 type RPCServer struct {
@@ -23,10 +32,22 @@ type RPCServer struct {
 
 func (m *RPCServer) VerifyToken(args map[string]interface{}, resp *map[string]interface{}) error {
 
-	r, err := m.Impl.VerifyToken(context.Background(), apis.VerifyRequest{Token: args["token"].(string), Params: args["params"].([]string)})
+	value := func(key string) string {
+		v, _ := args[key].(string)
+		return v
+	}
+	// the context keys are missing in the calls by older proxies
+	authContext := apis.AuthContext{
+		ClientAddress:     value("client_address"),
+		ListenerAddress:   value("listener_address"),
+		ServerName:        value("server_name"),
+		ClientCertSubject: value("client_cert_subject"),
+	}
+	r, err := m.Impl.VerifyToken(context.Background(), apis.VerifyRequest{Token: args["token"].(string), Params: args["params"].([]string), AuthContext: authContext})
 	*resp = map[string]interface{}{
-		"success": r.Success,
-		"status":  r.Status,
+		"success":    r.Success,
+		"status":     r.Status,
+		"expires_at": r.ExpiresAt,
 	}
 	return err
 }
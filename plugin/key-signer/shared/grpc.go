@@ -0,0 +1,45 @@
+package shared
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/key-signer/proto"
+	"github.com/hashicorp/go-plugin"
+	"golang.org/x/net/context"
+)
+
+// GRPCClient is an implementation of KeySigner that talks over gRPC.
+type GRPCClient struct {
+	broker *plugin.GRPCBroker
+	client proto.KeySignerClient
+}
+
+func (m *GRPCClient) Sign(ctx context.Context, request apis.SignRequest) ([]byte, error) {
+	resp, err := m.client.Sign(ctx, &proto.SignRequest{
+		KeyType: request.KeyType,
+		Hash:    request.Hash,
+		Pss:     request.PSS,
+		Digest:  request.Digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// Here is the gRPC server that GRPCClient talks to.
+type GRPCServer struct {
+	broker *plugin.GRPCBroker
+	Impl   apis.KeySigner
+}
+
+func (m *GRPCServer) Sign(
+	ctx context.Context,
+	req *proto.SignRequest) (*proto.SignResponse, error) {
+	signature, err := m.Impl.Sign(ctx, apis.SignRequest{
+		KeyType: req.KeyType,
+		Hash:    req.Hash,
+		PSS:     req.Pss,
+		Digest:  req.Digest,
+	})
+	return &proto.SignResponse{Signature: signature}, err
+}
@@ -0,0 +1,40 @@
+package shared
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"net/rpc"
+)
+
+type RPCClient struct{ client *rpc.Client }
+
+func (m *RPCClient) Sign(ctx context.Context, request apis.SignRequest) ([]byte, error) {
+	var resp map[string]interface{}
+	err := m.client.Call("Plugin.Sign", map[string]interface{}{
+		"keyType": request.KeyType,
+		"hash":    request.Hash,
+		"pss":     request.PSS,
+		"digest":  request.Digest,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp["signature"].([]byte), nil
+}
+
+type RPCServer struct {
+	Impl apis.KeySigner
+}
+
+func (m *RPCServer) Sign(args map[string]interface{}, resp *map[string]interface{}) error {
+	signature, err := m.Impl.Sign(context.Background(), apis.SignRequest{
+		KeyType: args["keyType"].(string),
+		Hash:    args["hash"].(string),
+		PSS:     args["pss"].(bool),
+		Digest:  args["digest"].([]byte),
+	})
+	*resp = map[string]interface{}{
+		"signature": signature,
+	}
+	return err
+}
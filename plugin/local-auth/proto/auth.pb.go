@@ -34,8 +34,12 @@ var _ = math.Inf
 const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type CredentialsRequest struct {
-	Username string `protobuf:"bytes,1,opt,name=username" json:"username,omitempty"`
-	Password string `protobuf:"bytes,2,opt,name=password" json:"password,omitempty"`
+	Username          string `protobuf:"bytes,1,opt,name=username" json:"username,omitempty"`
+	Password          string `protobuf:"bytes,2,opt,name=password" json:"password,omitempty"`
+	ClientAddress     string `protobuf:"bytes,3,opt,name=client_address,json=clientAddress" json:"client_address,omitempty"`
+	ListenerAddress   string `protobuf:"bytes,4,opt,name=listener_address,json=listenerAddress" json:"listener_address,omitempty"`
+	ServerName        string `protobuf:"bytes,5,opt,name=server_name,json=serverName" json:"server_name,omitempty"`
+	ClientCertSubject string `protobuf:"bytes,6,opt,name=client_cert_subject,json=clientCertSubject" json:"client_cert_subject,omitempty"`
 }
 
 func (m *CredentialsRequest) Reset()                    { *m = CredentialsRequest{} }
@@ -57,6 +61,34 @@ func (m *CredentialsRequest) GetPassword() string {
 	return ""
 }
 
+func (m *CredentialsRequest) GetClientAddress() string {
+	if m != nil {
+		return m.ClientAddress
+	}
+	return ""
+}
+
+func (m *CredentialsRequest) GetListenerAddress() string {
+	if m != nil {
+		return m.ListenerAddress
+	}
+	return ""
+}
+
+func (m *CredentialsRequest) GetServerName() string {
+	if m != nil {
+		return m.ServerName
+	}
+	return ""
+}
+
+func (m *CredentialsRequest) GetClientCertSubject() string {
+	if m != nil {
+		return m.ClientCertSubject
+	}
+	return ""
+}
+
 type AuthenticateResponse struct {
 	Authenticated bool  `protobuf:"varint,1,opt,name=authenticated" json:"authenticated,omitempty"`
 	Status        int32 `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
@@ -161,17 +193,22 @@ var _PasswordAuthenticator_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("auth.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 187 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4a, 0x2c, 0x2d, 0xc9,
-	0xd0, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05, 0x53, 0x4a, 0x3e, 0x5c, 0x42, 0xce, 0x45,
-	0xa9, 0x29, 0xa9, 0x79, 0x25, 0x99, 0x89, 0x39, 0xc5, 0x41, 0xa9, 0x85, 0xa5, 0xa9, 0xc5, 0x25,
-	0x42, 0x52, 0x5c, 0x1c, 0xa5, 0xc5, 0xa9, 0x45, 0x79, 0x89, 0xb9, 0xa9, 0x12, 0x8c, 0x0a, 0x8c,
-	0x1a, 0x9c, 0x41, 0x70, 0x3e, 0x48, 0xae, 0x20, 0xb1, 0xb8, 0xb8, 0x3c, 0xbf, 0x28, 0x45, 0x82,
-	0x09, 0x22, 0x07, 0xe3, 0x2b, 0x85, 0x70, 0x89, 0x38, 0x96, 0x96, 0x64, 0x80, 0x4c, 0x4b, 0x4e,
-	0x2c, 0x49, 0x0d, 0x4a, 0x2d, 0x2e, 0xc8, 0xcf, 0x2b, 0x4e, 0x15, 0x52, 0xe1, 0xe2, 0x4d, 0x44,
-	0x12, 0x4f, 0x01, 0x1b, 0xca, 0x11, 0x84, 0x2a, 0x28, 0x24, 0xc6, 0xc5, 0x56, 0x5c, 0x92, 0x58,
-	0x52, 0x5a, 0x0c, 0x36, 0x97, 0x35, 0x08, 0xca, 0x33, 0x8a, 0xe7, 0x12, 0x0d, 0x80, 0xda, 0x80,
-	0x64, 0x7a, 0x7e, 0x91, 0x90, 0x1b, 0x17, 0x0f, 0xb2, 0x75, 0x42, 0x92, 0x10, 0xbf, 0xe9, 0x61,
-	0xfa, 0x48, 0x4a, 0x1a, 0x2a, 0x85, 0xcd, 0x79, 0x49, 0x6c, 0x60, 0x39, 0x63, 0x40, 0x00, 0x00,
-	0x00, 0xff, 0xff, 0x87, 0xdc, 0x40, 0x61, 0x20, 0x01, 0x00, 0x00,
+	// 268 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6d, 0x90, 0xcb, 0x4a, 0xc4, 0x40,
+	0x10, 0x45, 0x89, 0x9a, 0x30, 0x96, 0x8e, 0x8f, 0xf6, 0x41, 0x1c, 0x17, 0xca, 0xa0, 0xa0, 0x9b,
+	0x2c, 0xf4, 0x0b, 0x86, 0x01, 0x97, 0x22, 0xad, 0xfb, 0xd0, 0x93, 0x2e, 0x30, 0x12, 0x93, 0xd8,
+	0x55, 0xad, 0x9f, 0xed, 0x2f, 0xd8, 0xe9, 0x4e, 0x86, 0x88, 0xae, 0x8a, 0xba, 0xf7, 0x50, 0x8f,
+	0x0b, 0xa0, 0x2c, 0xbf, 0x66, 0xad, 0x69, 0xb8, 0x11, 0xb1, 0x2f, 0xf3, 0xef, 0x08, 0xc4, 0xd2,
+	0xa0, 0xc6, 0x9a, 0x4b, 0x55, 0x91, 0xc4, 0x0f, 0x8b, 0xc4, 0x62, 0x06, 0x13, 0x4b, 0x68, 0x6a,
+	0xf5, 0x8e, 0x69, 0x74, 0x19, 0xdd, 0x6c, 0xcb, 0x75, 0xdf, 0x79, 0xad, 0x22, 0xfa, 0x6a, 0x8c,
+	0x4e, 0x37, 0x82, 0x37, 0xf4, 0xe2, 0x1a, 0xf6, 0x8a, 0xaa, 0x74, 0xc3, 0x72, 0xa5, 0xb5, 0x41,
+	0xa2, 0x74, 0xd3, 0x13, 0xd3, 0xa0, 0x2e, 0x82, 0x28, 0x6e, 0xe1, 0xa0, 0x2a, 0x89, 0xb1, 0x46,
+	0xb3, 0x06, 0xb7, 0x3c, 0xb8, 0x3f, 0xe8, 0x03, 0x7a, 0x01, 0x3b, 0x6e, 0xf1, 0xa7, 0x03, 0xfd,
+	0x31, 0xb1, 0xa7, 0x20, 0x48, 0x8f, 0xdd, 0x39, 0x19, 0x1c, 0xf5, 0x2b, 0x0b, 0x34, 0x9c, 0x93,
+	0x5d, 0xbd, 0x61, 0xc1, 0x69, 0xe2, 0xc1, 0xc3, 0x60, 0x2d, 0x9d, 0xf3, 0x1c, 0x8c, 0xf9, 0x0b,
+	0x1c, 0x2f, 0x5c, 0x0c, 0xdd, 0xc3, 0x85, 0x62, 0x94, 0x48, 0x6d, 0x53, 0x13, 0x8a, 0x2b, 0x98,
+	0xaa, 0x91, 0xae, 0xfd, 0xdf, 0x13, 0xf9, 0x5b, 0x14, 0xa7, 0x90, 0x10, 0x2b, 0xb6, 0xe4, 0x5f,
+	0x8f, 0x65, 0xdf, 0xdd, 0xe5, 0x70, 0xf2, 0xd4, 0x87, 0x30, 0x9a, 0xde, 0x18, 0xf1, 0x00, 0xbb,
+	0xe3, 0x75, 0xe2, 0x2c, 0xe4, 0x9f, 0xfd, 0x0d, 0x7d, 0x76, 0xde, 0x5b, 0xff, 0x9d, 0xb7, 0x4a,
+	0xbc, 0x77, 0xff, 0x03, 0x81, 0x6f, 0x04, 0x5a, 0xc4, 0x01, 0x00, 0x00,
 }
@@ -14,9 +14,17 @@ type GRPCClient struct {
 }
 
 func (m *GRPCClient) Authenticate(username, password string) (bool, int32, error) {
+	return m.AuthenticateWithContext(apis.AuthContext{}, username, password)
+}
+
+func (m *GRPCClient) AuthenticateWithContext(authContext apis.AuthContext, username, password string) (bool, int32, error) {
 	resp, err := m.client.Authenticate(context.Background(), &proto.CredentialsRequest{
-		Username: username,
-		Password: password,
+		Username:          username,
+		Password:          password,
+		ClientAddress:     authContext.ClientAddress,
+		ListenerAddress:   authContext.ListenerAddress,
+		ServerName:        authContext.ServerName,
+		ClientCertSubject: authContext.ClientCertSubject,
 	})
 	if err != nil {
 		return false, 0, err
@@ -33,6 +41,16 @@ type GRPCServer struct {
 func (m *GRPCServer) Authenticate(
 	ctx context.Context,
 	req *proto.CredentialsRequest) (*proto.AuthenticateResponse, error) {
+	if impl, ok := m.Impl.(apis.ContextPasswordAuthenticator); ok {
+		authContext := apis.AuthContext{
+			ClientAddress:     req.ClientAddress,
+			ListenerAddress:   req.ListenerAddress,
+			ServerName:        req.ServerName,
+			ClientCertSubject: req.ClientCertSubject,
+		}
+		a, s, err := impl.AuthenticateWithContext(authContext, req.Username, req.Password)
+		return &proto.AuthenticateResponse{Authenticated: a, Status: s}, err
+	}
 	a, s, err := m.Impl.Authenticate(req.Username, req.Password)
 	return &proto.AuthenticateResponse{Authenticated: a, Status: s}, err
 }
@@ -8,10 +8,18 @@ import (
 type RPCClient struct{ client *rpc.Client }
 
 func (m *RPCClient) Authenticate(username, password string) (bool, int32, error) {
+	return m.AuthenticateWithContext(apis.AuthContext{}, username, password)
+}
+
+func (m *RPCClient) AuthenticateWithContext(authContext apis.AuthContext, username, password string) (bool, int32, error) {
 	var resp map[string]interface{}
 	err := m.client.Call("Plugin.Authenticate", map[string]interface{}{
-		"username": username,
-		"password": password,
+		"username":            username,
+		"password":            password,
+		"client_address":      authContext.ClientAddress,
+		"listener_address":    authContext.ListenerAddress,
+		"server_name":         authContext.ServerName,
+		"client_cert_subject": authContext.ClientCertSubject,
 	}, &resp)
 	return resp["authenticated"].(bool), resp["status"].(int32), err
 }
@@ -21,10 +29,33 @@ type RPCServer struct {
 }
 
 func (m *RPCServer) Authenticate(args map[string]interface{}, resp *map[string]interface{}) error {
-	a, s, err := m.Impl.Authenticate(args["username"].(string), args["password"].(string))
+	var (
+		a   bool
+		s   int32
+		err error
+	)
+	if impl, ok := m.Impl.(apis.ContextPasswordAuthenticator); ok {
+		a, s, err = impl.AuthenticateWithContext(rpcAuthContext(args), args["username"].(string), args["password"].(string))
+	} else {
+		a, s, err = m.Impl.Authenticate(args["username"].(string), args["password"].(string))
+	}
 	*resp = map[string]interface{}{
 		"authenticated": a,
 		"status":        s,
 	}
 	return err
 }
+
+// rpcAuthContext returns the context of the arguments, the keys are missing in the calls by older proxies
+func rpcAuthContext(args map[string]interface{}) apis.AuthContext {
+	value := func(key string) string {
+		v, _ := args[key].(string)
+		return v
+	}
+	return apis.AuthContext{
+		ClientAddress:     value("client_address"),
+		ListenerAddress:   value("listener_address"),
+		ServerName:        value("server_name"),
+		ClientCertSubject: value("client_cert_subject"),
+	}
+}
@@ -0,0 +1,51 @@
+package shared
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/request-authz/proto"
+	"github.com/hashicorp/go-plugin"
+	"golang.org/x/net/context"
+)
+
+// GRPCClient is an implementation of RequestAuthorizer that talks over gRPC.
+type GRPCClient struct {
+	broker *plugin.GRPCBroker
+	client proto.RequestAuthorizerClient
+}
+
+func (m *GRPCClient) Authorize(ctx context.Context, request apis.AuthorizeRequest) (apis.AuthorizeResponse, error) {
+	resp, err := m.client.Authorize(ctx, &proto.AuthorizeRequest{
+		Principal:     request.Principal,
+		ClientAddress: request.ClientAddress,
+		ClientId:      request.ClientID,
+		ApiKey:        request.ApiKey,
+		ApiVersion:    request.ApiVersion,
+		Topics:        request.Topics,
+		TopicsUnknown: request.TopicsUnknown,
+	})
+	if err != nil {
+		return apis.AuthorizeResponse{}, err
+	}
+	return apis.AuthorizeResponse{Allowed: resp.Allowed, Status: resp.Status}, nil
+}
+
+// Here is the gRPC server that GRPCClient talks to.
+type GRPCServer struct {
+	broker *plugin.GRPCBroker
+	Impl   apis.RequestAuthorizer
+}
+
+func (m *GRPCServer) Authorize(
+	ctx context.Context,
+	req *proto.AuthorizeRequest) (*proto.AuthorizeResponse, error) {
+	resp, err := m.Impl.Authorize(ctx, apis.AuthorizeRequest{
+		Principal:     req.Principal,
+		ClientAddress: req.ClientAddress,
+		ClientID:      req.ClientId,
+		ApiKey:        req.ApiKey,
+		ApiVersion:    req.ApiVersion,
+		Topics:        req.Topics,
+		TopicsUnknown: req.TopicsUnknown,
+	})
+	return &proto.AuthorizeResponse{Allowed: resp.Allowed, Status: resp.Status}, err
+}
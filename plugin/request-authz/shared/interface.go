@@ -0,0 +1,50 @@
+// Package shared contains shared data between the host and plugins.
+package shared
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/plugin/request-authz/proto"
+	"github.com/hashicorp/go-plugin"
+	"net/rpc"
+)
+
+// Handshake is a common handshake that is shared by plugin and host.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "REQUEST_AUTHZ_PLUGIN",
+	MagicCookieValue: "hello",
+}
+
+var PluginMap = map[string]plugin.Plugin{
+	"requestAuthorizer": &RequestAuthorizerPlugin{},
+}
+
+type RequestAuthorizerPlugin struct {
+	Impl apis.RequestAuthorizer
+}
+
+func (p *RequestAuthorizerPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	proto.RegisterRequestAuthorizerServer(s, &GRPCServer{
+		Impl:   p.Impl,
+		broker: broker,
+	})
+	return nil
+}
+
+func (p *RequestAuthorizerPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &GRPCClient{
+		client: proto.NewRequestAuthorizerClient(c),
+		broker: broker,
+	}, nil
+}
+
+func (p *RequestAuthorizerPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &RPCServer{Impl: p.Impl}, nil
+}
+
+func (*RequestAuthorizerPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &RPCClient{client: c}, nil
+}
@@ -0,0 +1,47 @@
+package shared
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"net/rpc"
+)
+
+type RPCClient struct{ client *rpc.Client }
+
+func (m *RPCClient) Authorize(ctx context.Context, request apis.AuthorizeRequest) (apis.AuthorizeResponse, error) {
+	var resp map[string]interface{}
+	err := m.client.Call("Plugin.Authorize", map[string]interface{}{
+		"principal":     request.Principal,
+		"clientAddress": request.ClientAddress,
+		"clientID":      request.ClientID,
+		"apiKey":        request.ApiKey,
+		"apiVersion":    request.ApiVersion,
+		"topics":        request.Topics,
+		"topicsUnknown": request.TopicsUnknown,
+	}, &resp)
+	if err != nil {
+		return apis.AuthorizeResponse{}, err
+	}
+	return apis.AuthorizeResponse{Allowed: resp["allowed"].(bool), Status: resp["status"].(int32)}, nil
+}
+
+type RPCServer struct {
+	Impl apis.RequestAuthorizer
+}
+
+func (m *RPCServer) Authorize(args map[string]interface{}, resp *map[string]interface{}) error {
+	r, err := m.Impl.Authorize(context.Background(), apis.AuthorizeRequest{
+		Principal:     args["principal"].(string),
+		ClientAddress: args["clientAddress"].(string),
+		ClientID:      args["clientID"].(string),
+		ApiKey:        args["apiKey"].(int32),
+		ApiVersion:    args["apiVersion"].(int32),
+		Topics:        args["topics"].([]string),
+		TopicsUnknown: args["topicsUnknown"].(bool),
+	})
+	*resp = map[string]interface{}{
+		"allowed": r.Allowed,
+		"status":  r.Status,
+	}
+	return err
+}
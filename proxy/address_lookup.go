@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const maxAddressLookupResponseSize = 64 << 10
+
+// addressMapping is the response of the lookup service for a broker, a listener is not started without the listener address
+// e.g. when the broker is served by another proxy
+type addressMapping struct {
+	ListenerAddress   string `json:"listener_address"`
+	AdvertisedAddress string `json:"advertised_address"`
+}
+
+type addressLookupResult struct {
+	mapping *addressMapping // nil when the lookup service has no mapping for the broker
+	expires time.Time
+}
+
+// addressLookup resolves the broker address mappings with an HTTP lookup service: GET url?broker=host:port returns
+// the listener and the advertised address of the broker or 404 when the broker is not mapped. The results are cached for the TTL,
+// the expired result is used when the lookup service fails.
+type addressLookup struct {
+	url     string
+	ttl     time.Duration
+	timeout time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	results map[string]addressLookupResult // by broker address
+}
+
+func newAddressLookup(lookupUrl string, ttl time.Duration, timeout time.Duration) (*addressLookup, error) {
+	if _, err := url.Parse(lookupUrl); err != nil {
+		return nil, errors.Wrap(err, "invalid address lookup url")
+	}
+	return &addressLookup{
+		url:     lookupUrl,
+		ttl:     ttl,
+		timeout: timeout,
+		client:  &http.Client{},
+		results: make(map[string]addressLookupResult),
+	}, nil
+}
+
+// lookup returns the mapping of the broker, nil when the broker is not mapped by the lookup service
+func (l *addressLookup) lookup(brokerAddress string) (*addressMapping, error) {
+	now := time.Now()
+	l.mu.Lock()
+	result, ok := l.results[brokerAddress]
+	l.mu.Unlock()
+	if ok && now.Before(result.expires) {
+		return result.mapping, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	mapping, err := l.get(ctx, brokerAddress)
+	if err != nil {
+		proxyAddressLookupFailuresTotal.Inc()
+		if ok {
+			logger.Warnf("Address lookup of broker %s failed, the expired mapping is used: %v", brokerAddress, err)
+			return result.mapping, nil
+		}
+		return nil, errors.Wrapf(err, "address lookup of broker %s failed", brokerAddress)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results[brokerAddress] = addressLookupResult{mapping: mapping, expires: now.Add(l.ttl)}
+	return mapping, nil
+}
+
+func (l *addressLookup) get(ctx context.Context, brokerAddress string) (*addressMapping, error) {
+	req, err := http.NewRequest(http.MethodGet, l.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	query := req.URL.Query()
+	query.Set("broker", brokerAddress)
+	req.URL.RawQuery = query.Encode()
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAddressLookupResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("address lookup status %d: %s", resp.StatusCode, string(data))
+	}
+	mapping := &addressMapping{}
+	if err = json.Unmarshal(data, mapping); err != nil {
+		return nil, err
+	}
+	if _, _, err = util.SplitHostPort(mapping.AdvertisedAddress); err != nil {
+		return nil, errors.Wrapf(err, "invalid advertised address '%s' in the mapping of broker %s", mapping.AdvertisedAddress, brokerAddress)
+	}
+	if mapping.ListenerAddress != "" {
+		if _, _, err = util.SplitHostPort(mapping.ListenerAddress); err != nil {
+			return nil, errors.Wrapf(err, "invalid listener address '%s' in the mapping of broker %s", mapping.ListenerAddress, brokerAddress)
+		}
+	}
+	return mapping, nil
+}
+
+func (m *addressMapping) listenerConfig(brokerAddress string) config.ListenerConfig {
+	return config.ListenerConfig{BrokerAddress: brokerAddress, ListenerAddress: m.ListenerAddress, AdvertisedAddress: m.AdvertisedAddress}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testAddressLookupServer(mappings map[string]string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		mapping, ok := mappings[r.URL.Query().Get("broker")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if mapping == "error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, mapping)
+	}))
+}
+
+func TestAddressLookup(t *testing.T) {
+	a := assert.New(t)
+
+	requests := 0
+	mappings := map[string]string{
+		"kafka-0:9092": `{"listener_address":"0.0.0.0:32400","advertised_address":"proxy-0:32400"}`,
+		"kafka-1:9092": `{"advertised_address":"invalid"}`,
+	}
+	server := testAddressLookupServer(mappings, &requests)
+	defer server.Close()
+
+	lookup, err := newAddressLookup(server.URL, time.Minute, time.Second)
+	a.Nil(err)
+
+	mapping, err := lookup.lookup("kafka-0:9092")
+	a.Nil(err)
+	a.Equal(&addressMapping{ListenerAddress: "0.0.0.0:32400", AdvertisedAddress: "proxy-0:32400"}, mapping)
+	// cached
+	mapping, err = lookup.lookup("kafka-0:9092")
+	a.Nil(err)
+	a.Equal("proxy-0:32400", mapping.AdvertisedAddress)
+	a.Equal(1, requests)
+
+	mapping, err = lookup.lookup("kafka-2:9092")
+	a.Nil(err)
+	a.Nil(mapping)
+
+	_, err = lookup.lookup("kafka-1:9092")
+	a.NotNil(err)
+
+	// the expired mapping is used when the lookup fails
+	lookup.ttl = 0
+	mappings["kafka-0:9092"] = "error"
+	lookup.results["kafka-0:9092"] = addressLookupResult{mapping: &addressMapping{AdvertisedAddress: "proxy-0:32400"}, expires: time.Now()}
+	mapping, err = lookup.lookup("kafka-0:9092")
+	a.Nil(err)
+	a.Equal("proxy-0:32400", mapping.AdvertisedAddress)
+	delete(lookup.results, "kafka-0:9092")
+	_, err = lookup.lookup("kafka-0:9092")
+	a.NotNil(err)
+}
+
+func TestGetNetAddressMappingLookup(t *testing.T) {
+	a := assert.New(t)
+
+	requests := 0
+	mappings := map[string]string{
+		"kafka-0:9092": `{"listener_address":"127.0.0.1:0","advertised_address":"proxy-0:32400"}`,
+		// served by another proxy
+		"kafka-1:9092": `{"advertised_address":"proxy-1:32401"}`,
+	}
+	server := testAddressLookupServer(mappings, &requests)
+	defer server.Close()
+
+	c := config.NewConfig()
+	c.Proxy.DisableDynamicListeners = true
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: "kafka-2:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-2:32402"}}
+	c.Proxy.AddressLookup.Url = server.URL
+	c.Proxy.AddressLookup.TTL = 0
+	listeners, err := NewListeners(c, nil, nil)
+	a.Nil(err)
+
+	host, port, err := listeners.GetNetAddressMapping("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("proxy-0", host)
+	a.Equal(int32(32400), port)
+
+	mappings["kafka-0:9092"] = `{"listener_address":"127.0.0.1:0","advertised_address":"proxy-0:32410"}`
+	host, port, err = listeners.GetNetAddressMapping("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("proxy-0", host)
+	a.Equal(int32(32410), port)
+
+	host, port, err = listeners.GetNetAddressMapping("kafka-1", 9092)
+	a.Nil(err)
+	a.Equal("proxy-1", host)
+	a.Equal(int32(32401), port)
+	a.Len(listeners.lookupListeners, 2)
+
+	// the static mappings are not looked up
+	host, _, err = listeners.GetNetAddressMapping("kafka-2", 9092)
+	a.Nil(err)
+	a.Equal("proxy-2", host)
+	a.Equal(3, requests)
+
+	_, _, err = listeners.GetNetAddressMapping("kafka-3", 9092)
+	a.EqualError(err, "net address mapping for kafka-3:9092 was not found")
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// $1, ${1} or ${1+32400} / ${1-1} for the port arithmetic
+var addressTemplateVariable = regexp.MustCompile(`\$(?:(\d+)|\{(\d+)(?:([+-])(\d+))?\})`)
+
+// addressMappingRule maps the brokers matching the pattern without a server mapping e.g. kafka-*.broker.internal:9092,0.0.0.0:${1+32400},kafka-$1.proxy.example.com:${1+32400}
+// The pattern is a wildcard pattern, * matches a part of the host name or port and is a capture group, or a regular expression when prefixed with ~.
+// The listener and advertised address templates refer to the capture groups.
+type addressMappingRule struct {
+	pattern           *regexp.Regexp
+	listenerAddress   string
+	advertisedAddress string
+}
+
+func newAddressMappingRules(rules []string) ([]*addressMappingRule, error) {
+	result := make([]*addressMappingRule, 0, len(rules))
+	for _, v := range rules {
+		rule, err := newAddressMappingRule(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func newAddressMappingRule(rule string) (*addressMappingRule, error) {
+	parts := strings.Split(rule, ",")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("address mapping rule '%s' must be in form 'pattern,listenerhost:listenerport(,advhost:advport)'", rule)
+	}
+	var expr string
+	if strings.HasPrefix(parts[0], "~") {
+		expr = parts[0][1:]
+	} else {
+		expr = strings.Replace(regexp.QuoteMeta(parts[0]), `\*`, `([^.:]+)`, -1)
+	}
+	pattern, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern of address mapping rule '%s'", rule)
+	}
+	result := &addressMappingRule{pattern: pattern, listenerAddress: parts[1], advertisedAddress: parts[1]}
+	if len(parts) == 3 {
+		result.advertisedAddress = parts[2]
+	}
+	for _, template := range []string{result.listenerAddress, result.advertisedAddress} {
+		for _, match := range addressTemplateVariable.FindAllStringSubmatch(template, -1) {
+			group, _ := strconv.Atoi(match[1] + match[2])
+			if group > pattern.NumSubexp() {
+				return nil, fmt.Errorf("address mapping rule '%s' refers to the capture group %d, the pattern has %d", rule, group, pattern.NumSubexp())
+			}
+		}
+	}
+	return result, nil
+}
+
+// apply returns the listener config of the broker, false when the broker does not match the pattern
+func (r *addressMappingRule) apply(brokerAddress string) (config.ListenerConfig, bool, error) {
+	groups := r.pattern.FindStringSubmatch(brokerAddress)
+	if groups == nil {
+		return config.ListenerConfig{}, false, nil
+	}
+	listenerAddress, err := expandAddressTemplate(r.listenerAddress, groups)
+	if err != nil {
+		return config.ListenerConfig{}, false, err
+	}
+	advertisedAddress, err := expandAddressTemplate(r.advertisedAddress, groups)
+	if err != nil {
+		return config.ListenerConfig{}, false, err
+	}
+	return config.ListenerConfig{BrokerAddress: brokerAddress, ListenerAddress: listenerAddress, AdvertisedAddress: advertisedAddress}, true, nil
+}
+
+func expandAddressTemplate(template string, groups []string) (string, error) {
+	var err error
+	address := addressTemplateVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		match := addressTemplateVariable.FindStringSubmatch(variable)
+		group, _ := strconv.Atoi(match[1] + match[2])
+		value := groups[group]
+		if match[3] == "" {
+			return value
+		}
+		n, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			err = fmt.Errorf("capture group %d '%s' is not a number", group, value)
+			return variable
+		}
+		delta, _ := strconv.Atoi(match[4])
+		if match[3] == "-" {
+			delta = -delta
+		}
+		return strconv.Itoa(n + delta)
+	})
+	if err != nil {
+		return "", err
+	}
+	_, port, err := util.SplitHostPort(address)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid address '%s'", address)
+	}
+	if port < 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port of address '%s'", address)
+	}
+	return address, nil
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAddressMappingRule(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		rule          string
+		brokerAddress string
+		matched       bool
+		listener      string
+		advertised    string
+		err           string
+	}{
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+32400},kafka-$1.proxy.example.com:3209$1", "kafka-1.broker.internal:9092", true, "0.0.0.0:32401", "kafka-1.proxy.example.com:32091", ""},
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+32400}", "kafka-12.broker.internal:9092", true, "0.0.0.0:32412", "0.0.0.0:32412", ""},
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+32400}", "kafka-1.broker.internal:9093", false, "", "", ""},
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+32400}", "kafka-1.other.broker.internal:9092", false, "", "", ""},
+		{"*.*.internal:*,0.0.0.0:${3-9000},$2-$1.example.com:${3}", "b1.c1.internal:9092", true, "0.0.0.0:92", "c1-b1.example.com:9092", ""},
+		{`~broker-(\d+)\.internal:9092,0.0.0.0:${1+32400},broker-${1}.example.com:9092`, "broker-7.internal:9092", true, "0.0.0.0:32407", "broker-7.example.com:9092", ""},
+		{`~broker-(\d+)\.internal:9092,0.0.0.0:${1+32400}`, "broker-7a.internal:9092", false, "", "", ""},
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+32400}", "kafka-a.broker.internal:9092", false, "", "", "capture group 1 'a' is not a number"},
+		{"kafka-*.broker.internal:9092,0.0.0.0:${1+65535}", "kafka-1.broker.internal:9092", false, "", "", "invalid port of address '0.0.0.0:65536'"},
+	}
+	for _, tt := range tests {
+		rule, err := newAddressMappingRule(tt.rule)
+		a.Nil(err)
+		cfg, matched, err := rule.apply(tt.brokerAddress)
+		if tt.err != "" {
+			a.EqualError(err, tt.err)
+			continue
+		}
+		a.Nil(err)
+		a.Equal(tt.matched, matched, tt.brokerAddress)
+		if matched {
+			a.Equal(config.ListenerConfig{BrokerAddress: tt.brokerAddress, ListenerAddress: tt.listener, AdvertisedAddress: tt.advertised}, cfg)
+		}
+	}
+
+	for _, rule := range []string{"kafka-*:9092", "kafka-*:9092,0.0.0.0:${2+32400}", "~kafka-(:9092,0.0.0.0:32400"} {
+		_, err := newAddressMappingRule(rule)
+		a.NotNil(err, rule)
+	}
+}
+
+func TestGetNetAddressMappingRule(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.DisableDynamicListeners = true
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: "kafka-0.broker.internal:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-0:32400"}}
+	c.Proxy.AddressMappingRules = []string{"kafka-*.broker.internal:9092,127.0.0.1:0,kafka-$1.proxy.example.com:${1+32400}"}
+	listeners, err := NewListeners(c, nil, nil)
+	a.Nil(err)
+
+	host, port, err := listeners.GetNetAddressMapping("kafka-3.broker.internal", 9092)
+	a.Nil(err)
+	a.Equal("kafka-3.proxy.example.com", host)
+	a.Equal(int32(32403), port)
+	a.Contains(listeners.brokerToListenerConfig, "kafka-3.broker.internal:9092")
+
+	// the server mappings take precedence
+	host, _, err = listeners.GetNetAddressMapping("kafka-0.broker.internal", 9092)
+	a.Nil(err)
+	a.Equal("proxy-0", host)
+
+	_, _, err = listeners.GetNetAddressMapping("kafka-x.broker.internal", 9092)
+	a.EqualError(err, "address mapping rule of broker kafka-x.broker.internal:9092 failed: capture group 1 'x' is not a number")
+	_, _, err = listeners.GetNetAddressMapping("zookeeper-0.internal", 9092)
+	a.EqualError(err, "net address mapping for zookeeper-0.internal:9092 was not found")
+
+	c.Proxy.AddressMappingRules = []string{"kafka-*.broker.internal:9092"}
+	_, err = NewListeners(c, nil, nil)
+	a.NotNil(err)
+}
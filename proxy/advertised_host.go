@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"text/template"
+)
+
+// advertisedHostTemplate renders the advertised hosts of the brokers from their node ids e.g. b{{.BrokerID}}.proxy.example.com,
+// so the DNS names of Kubernetes services or ingresses route the clients instead of the static host mappings
+type advertisedHostTemplate struct {
+	template *template.Template
+}
+
+// newAdvertisedHostFunc returns the advertised host function of the template, nil when the template is empty
+func newAdvertisedHostFunc(text string) (protocol.AdvertisedHostFunc, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("advertised-host").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("advertised host template '%s' is invalid: %v", text, err)
+	}
+	t := &advertisedHostTemplate{template: tmpl}
+	return t.host, nil
+}
+
+func (t *advertisedHostTemplate) host(nodeID int32) (string, error) {
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, struct{ BrokerID int32 }{BrokerID: nodeID}); err != nil {
+		return "", fmt.Errorf("advertised host of broker %d: %v", nodeID, err)
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("advertised host of broker %d is empty", nodeID)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAdvertisedHostFunc(t *testing.T) {
+	a := assert.New(t)
+
+	fn, err := newAdvertisedHostFunc("")
+	a.Nil(err)
+	a.Nil(fn)
+
+	fn, err = newAdvertisedHostFunc("b{{.BrokerID}}.proxy.example.com")
+	a.Nil(err)
+	host, err := fn(2)
+	a.Nil(err)
+	a.Equal("b2.proxy.example.com", host)
+
+	fn, err = newAdvertisedHostFunc("{{if gt .BrokerID 9}}{{.BrokerID}}{{end}}")
+	a.Nil(err)
+	_, err = fn(1)
+	a.EqualError(err, "advertised host of broker 1 is empty")
+
+	_, err = newAdvertisedHostFunc("b{{.BrokerID")
+	a.NotNil(err)
+}
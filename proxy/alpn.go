@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ALPN protocols of the clients served by the HTTP endpoints
+var alpnHTTPProtocols = []string{"h2", "http/1.1"}
+
+// alpnListener accepts the Kafka connections of the TLS listener, the connections negotiating HTTP with ALPN are handed over
+// to the HTTP listener. The TLS handshakes are done in the background, so a slow client does not block the listener.
+type alpnListener struct {
+	net.Listener
+	httpListener     *ALPNHTTPListener
+	handshakeTimeout time.Duration
+
+	conns chan net.Conn
+	// closed when the TLS listener fails, the error is returned by Accept
+	acceptDone chan struct{}
+	acceptErr  error
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newALPNListener(l net.Listener, httpListener *ALPNHTTPListener, handshakeTimeout time.Duration) *alpnListener {
+	al := &alpnListener{
+		Listener:         l,
+		httpListener:     httpListener,
+		handshakeTimeout: handshakeTimeout,
+		conns:            make(chan net.Conn),
+		acceptDone:       make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	go withRecover(al.acceptLoop)
+	return al
+}
+
+func (l *alpnListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.acceptErr = err
+			close(l.acceptDone)
+			return
+		}
+		go withRecover(func() { l.route(conn) })
+	}
+}
+
+// route completes the TLS handshake and passes the connection to the HTTP listener or to Accept by the negotiated protocol
+func (l *alpnListener) route(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		l.accepted(conn)
+		return
+	}
+	if err := tlsConn.SetDeadline(time.Now().Add(l.handshakeTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Infof("TLS handshake with %s on %s failed: %v", conn.RemoteAddr(), conn.LocalAddr(), err)
+		conn.Close()
+		return
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return
+	}
+	protocol := tlsConn.ConnectionState().NegotiatedProtocol
+	for _, httpProtocol := range alpnHTTPProtocols {
+		if protocol == httpProtocol {
+			l.httpListener.put(conn)
+			return
+		}
+	}
+	l.accepted(conn)
+}
+
+func (l *alpnListener) accepted(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next Kafka connection
+func (l *alpnListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.acceptDone:
+		return nil, l.acceptErr
+	}
+}
+
+func (l *alpnListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// ALPNHTTPListener accepts the connections of the TLS listeners which negotiated HTTP with ALPN,
+// so the HTTP endpoints are served on the Kafka ports
+type ALPNHTTPListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewALPNHTTPListener creates the listener of the HTTP connections
+func NewALPNHTTPListener() *ALPNHTTPListener {
+	return &ALPNHTTPListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *ALPNHTTPListener) put(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next HTTP connection
+func (l *ALPNHTTPListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("ALPN HTTP listener is closed")
+	}
+}
+
+// Close stops the listener, the TLS listeners are not closed
+func (l *ALPNHTTPListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener, the connections are accepted on the addresses of the TLS listeners
+func (l *ALPNHTTPListener) Addr() net.Addr {
+	return alpnAddr{}
+}
+
+type alpnAddr struct{}
+
+func (alpnAddr) Network() string { return "alpn" }
+func (alpnAddr) String() string  { return "alpn" }
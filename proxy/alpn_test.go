@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestALPNListener(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.ListenerALPN.Enable = true
+	c.Proxy.TLS.ListenerALPN.KafkaProtocols = []string{"kafka"}
+	serverConfig, err := newTLSListenerConfig(c, nil)
+	a.Nil(err)
+	a.Equal([]string{"h2", "http/1.1", "kafka"}, serverConfig.NextProtos)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	httpListener := NewALPNHTTPListener()
+	defer httpListener.Close()
+	l := newALPNListener(tls.NewListener(ln, serverConfig), httpListener, time.Second)
+	defer l.Close()
+	go http.Serve(httpListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+	// the Kafka connections echo the client
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	// the HTTP clients are served by the HTTP endpoints
+	for _, http2 := range []bool{true, false} {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: http2}
+		if !http2 {
+			transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		}
+		resp, err := (&http.Client{Transport: transport, Timeout: 3 * time.Second}).Get("https://" + ln.Addr().String() + "/metrics")
+		a.Nil(err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		a.Nil(err)
+		a.Equal("metrics", string(body))
+		if http2 {
+			a.Equal(2, resp.ProtoMajor)
+		} else {
+			a.Equal(1, resp.ProtoMajor)
+		}
+		transport.CloseIdleConnections()
+	}
+
+	// the clients without ALPN or with the Kafka protocol are Kafka clients
+	for _, nextProtos := range [][]string{nil, {"kafka"}} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: nextProtos})
+		a.Nil(err)
+		_, err = conn.Write([]byte("ping"))
+		a.Nil(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		a.Nil(err)
+		a.Equal("ping", string(buf))
+		conn.Close()
+	}
+
+	// the listener is closed
+	l.Close()
+	_, err = l.Accept()
+	a.NotNil(err)
+	httpListener.Close()
+	_, err = httpListener.Accept()
+	a.EqualError(err, "ALPN HTTP listener is closed")
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// auditEventTypes are the events produced to the audit topic
+var auditEventTypes = map[EventType]bool{
+	EventConnectionOpened: true,
+	EventConnectionClosed: true,
+	EventAuthSuccess:      true,
+	EventAuthFailure:      true,
+	EventRequestDenied:    true,
+}
+
+// AuditTopic produces the audit events (the authentication results, the denied requests and the connection lifecycle) as JSON records
+// to the topic of the upstream cluster. The records are sent over the connections of the proxy to the brokers, so they are authenticated
+// as the proxy. The events are batched, each batch is written to the next partition of the topic. A batch which cannot be produced
+// after the leaders were refreshed is dropped.
+type AuditTopic struct {
+	topic         string
+	batchSize     int
+	flushInterval time.Duration
+
+	queue     chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// owned by Run
+	producer *topicProducer
+}
+
+// NewAuditTopic creates the producer of the audit events to the topic of the cluster with the bootstrap servers
+func NewAuditTopic(topic string, bootstrapServers []string, batchSize int, flushInterval time.Duration, queueSize int, dial func(brokerAddress string) (net.Conn, error), timeout time.Duration, clientID string) (*AuditTopic, error) {
+	if len(bootstrapServers) == 0 {
+		return nil, errors.New("audit topic requires bootstrap servers")
+	}
+	if batchSize <= 0 || flushInterval <= 0 || queueSize <= 0 {
+		return nil, errors.New("audit topic batch size, flush interval and queue size must be greater than 0")
+	}
+	return &AuditTopic{
+		topic:         topic,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		queue:         make(chan Event, queueSize),
+		done:          make(chan struct{}),
+		producer:      newTopicProducer(topic, bootstrapServers, dial, timeout, clientID),
+	}, nil
+}
+
+// HandleEvent queues the audit event, the event is dropped when the queue is full
+func (s *AuditTopic) HandleEvent(event Event) {
+	if !auditEventTypes[event.Type] {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		proxyAuditEventsDroppedTotal.WithLabelValues("queue").Inc()
+	}
+}
+
+// Run produces the queued events until the audit topic is closed, the remaining events are produced on close
+func (s *AuditTopic) Run() {
+	logger.Infof("Audit events are produced to topic %s", s.topic)
+	defer s.producer.close()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	batch := make([]Event, 0, s.batchSize)
+	for {
+		select {
+		case event := <-s.queue:
+			batch = append(batch, event)
+			if len(batch) >= s.batchSize {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+		case <-s.done:
+			for {
+				select {
+				case event := <-s.queue:
+					batch = append(batch, event)
+					if len(batch) >= s.batchSize {
+						batch = s.flush(batch)
+					}
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *AuditTopic) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// flush produces the batch and returns the emptied batch
+func (s *AuditTopic) flush(batch []Event) []Event {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := s.produce(batch); err != nil {
+		logger.Warnf("Producing %d audit events to topic %s failed, the events are dropped: %v", len(batch), s.topic, err)
+		proxyAuditEventsDroppedTotal.WithLabelValues("produce").Add(float64(len(batch)))
+	} else {
+		proxyAuditEventsProducedTotal.Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+// produce sends the batch to the leader of the next partition of the topic
+func (s *AuditTopic) produce(batch []Event) error {
+	recordSet, err := encodeAuditRecords(batch)
+	if err != nil {
+		return err
+	}
+	return s.producer.produce(recordSet)
+}
+
+// topicProducer produces the record sets to the partitions of the topic in turn, the leaders are fetched from the bootstrap servers.
+// It is not safe for the concurrent use.
+type topicProducer struct {
+	topic            string
+	bootstrapServers []string
+	dial             func(brokerAddress string) (net.Conn, error)
+	timeout          time.Duration
+	clientID         string
+
+	leaders       *protocol.PartitionLeaders
+	partitions    []int32
+	next          int
+	correlationID int32
+	conns         map[string]net.Conn
+}
+
+func newTopicProducer(topic string, bootstrapServers []string, dial func(brokerAddress string) (net.Conn, error), timeout time.Duration, clientID string) *topicProducer {
+	return &topicProducer{
+		topic:            topic,
+		bootstrapServers: bootstrapServers,
+		dial:             dial,
+		timeout:          timeout,
+		clientID:         clientID,
+		conns:            make(map[string]net.Conn),
+	}
+}
+
+// produce sends the record set to the leader of the next partition, the record set is sent once more after the leaders were refreshed
+func (s *topicProducer) produce(recordSet []byte) error {
+	err := s.send(recordSet)
+	if err == nil {
+		return nil
+	}
+	logger.Debugf("Records were not produced to topic %s, refreshing the leaders: %v", s.topic, err)
+	if err = s.refresh(); err != nil {
+		return err
+	}
+	return s.send(recordSet)
+}
+
+func (s *topicProducer) close() {
+	for address, conn := range s.conns {
+		conn.Close()
+		delete(s.conns, address)
+	}
+}
+
+func (s *topicProducer) send(recordSet []byte) error {
+	if len(s.partitions) == 0 {
+		return errors.Errorf("leaders of topic %s are unknown", s.topic)
+	}
+	partition := s.partitions[s.next%len(s.partitions)]
+	s.next++
+	address, _ := s.leaders.Leader(s.topic, partition)
+	conn, ok := s.conns[address]
+	if !ok {
+		var err error
+		if conn, err = s.dial(address); err != nil {
+			return err
+		}
+		s.conns[address] = conn
+	}
+	s.correlationID++
+	response, err := roundTripRequest(conn, protocol.EncodeProduceRequest(s.correlationID, s.clientID, -1, s.timeout, s.topic, partition, recordSet), s.timeout)
+	if err != nil {
+		conn.Close()
+		delete(s.conns, address)
+		return err
+	}
+	errorCodes, err := protocol.ProduceResponseErrorCodes(response)
+	if err != nil {
+		return err
+	}
+	if len(errorCodes) != 0 {
+		return fmt.Errorf("partition %d responded with errors %v", partition, errorCodes)
+	}
+	return nil
+}
+
+// refresh fetches the leaders of the partitions of the topic from the first bootstrap server which responds
+func (s *topicProducer) refresh() error {
+	s.correlationID++
+	request := protocol.EncodeShadowMetadataRequest(s.correlationID, s.clientID, []string{s.topic})
+	var err error
+	for _, brokerAddress := range s.bootstrapServers {
+		var leaders *protocol.PartitionLeaders
+		if leaders, err = s.fetchLeaders(brokerAddress, request); err != nil {
+			logger.Infof("Metadata request to %s for topic %s failed: %v", brokerAddress, s.topic, err)
+			continue
+		}
+		partitions := make([]int32, 0)
+		for partition := range leaders.Leaders[s.topic] {
+			if _, ok := leaders.Leader(s.topic, partition); ok {
+				partitions = append(partitions, partition)
+			}
+		}
+		if len(partitions) == 0 {
+			return errors.Errorf("topic %s has no partitions with a leader", s.topic)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		s.leaders, s.partitions = leaders, partitions
+		return nil
+	}
+	return err
+}
+
+func (s *topicProducer) fetchLeaders(brokerAddress string, request []byte) (*protocol.PartitionLeaders, error) {
+	conn, err := s.dial(brokerAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	response, err := roundTripRequest(conn, request, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.DecodeShadowMetadataResponse(response)
+}
+
+// encodeAuditRecords encodes the events as the JSON values of the records with the event type header
+func encodeAuditRecords(batch []Event) ([]byte, error) {
+	firstTimestamp := batch[0].Time.UnixNano() / int64(time.Millisecond)
+	for _, event := range batch {
+		if timestamp := event.Time.UnixNano() / int64(time.Millisecond); timestamp < firstTimestamp {
+			firstTimestamp = timestamp
+		}
+	}
+	records := make([]*protocol.Record, 0, len(batch))
+	for _, event := range batch {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &protocol.Record{
+			TimestampDelta: event.Time.UnixNano()/int64(time.Millisecond) - firstTimestamp,
+			Value:          value,
+			Headers:        []protocol.RecordHeader{{Key: []byte("type"), Value: []byte(event.Type)}},
+		})
+	}
+	return protocol.EncodeRecordBatch(firstTimestamp, records), nil
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func testAuditEvents(a *assert.Assertions, request []byte) []Event {
+	var events []Event
+	_, err := protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		a.Equal("t1", topic)
+		batches, _, err := protocol.DecodeRecordBatches(recordSet)
+		a.Nil(err)
+		for _, batch := range batches {
+			for _, record := range batch.Records {
+				var event Event
+				a.Nil(json.Unmarshal(record.Value, &event))
+				a.Equal(string(event.Type), string(record.Headers[0].Value))
+				events = append(events, event)
+			}
+		}
+		return recordSet, nil
+	})
+	a.Nil(err)
+	return events
+}
+
+func TestNewAuditTopic(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewAuditTopic("audit", nil, 10, time.Second, 10, nil, time.Second, "test")
+	a.EqualError(err, "audit topic requires bootstrap servers")
+	_, err = NewAuditTopic("audit", []string{"kafka-0:9092"}, 0, time.Second, 10, nil, time.Second, "test")
+	a.NotNil(err)
+}
+
+func TestAuditTopic(t *testing.T) {
+	a := assert.New(t)
+
+	produced := make(chan []byte, 10)
+	s, err := NewAuditTopic("t1", []string{"shadow-0:9092"}, 2, time.Hour, 10, testShadowDial(a, produced), time.Second, "test")
+	a.Nil(err)
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+
+	// the events which are not audited are ignored, the full batch is produced to the leader fetched from the bootstrap server
+	s.HandleEvent(Event{Type: EventConfigReloaded})
+	s.HandleEvent(Event{Type: EventAuthFailure, Time: time.Now(), Client: "10.0.0.1:50000", Principal: "alice"})
+	s.HandleEvent(Event{Type: EventRequestDenied, Time: time.Now(), Principal: "alice", Message: "api key 0 version 3 denied by authorizer"})
+	events := testAuditEvents(a, <-produced)
+	a.Len(events, 2)
+	a.Equal(EventAuthFailure, events[0].Type)
+	a.Equal("alice", events[0].Principal)
+	a.Equal("api key 0 version 3 denied by authorizer", events[1].Message)
+
+	// the remaining events are produced on close
+	s.HandleEvent(Event{Type: EventConnectionClosed, Time: time.Now(), Broker: "kafka-0:9092"})
+	s.Close()
+	<-done
+	events = testAuditEvents(a, <-produced)
+	a.Len(events, 1)
+	a.Equal("kafka-0:9092", events[0].Broker)
+}
+
+func TestAuditTopicDropped(t *testing.T) {
+	a := assert.New(t)
+
+	dial := func(string) (net.Conn, error) { return nil, io.ErrUnexpectedEOF }
+	s, err := NewAuditTopic("t1", []string{"kafka-0:9092"}, 10, time.Hour, 1, dial, time.Second, "test")
+	a.Nil(err)
+
+	queueDrops := testCounterValue(a, proxyAuditEventsDroppedTotal.WithLabelValues("queue"))
+	s.HandleEvent(Event{Type: EventAuthSuccess, Time: time.Now()})
+	s.HandleEvent(Event{Type: EventAuthSuccess, Time: time.Now()})
+	a.Equal(queueDrops+1, testCounterValue(a, proxyAuditEventsDroppedTotal.WithLabelValues("queue")))
+
+	// the leaders cannot be fetched
+	produceDrops := testCounterValue(a, proxyAuditEventsDroppedTotal.WithLabelValues("produce"))
+	a.Len(s.flush([]Event{<-s.queue}), 0)
+	a.Equal(produceDrops+1, testCounterValue(a, proxyAuditEventsDroppedTotal.WithLabelValues("produce")))
+}
@@ -2,12 +2,13 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"io"
+	"net"
 	"strings"
 	"time"
 )
@@ -19,25 +20,40 @@ type AuthClient struct {
 	timeout time.Duration
 
 	tokenProvider apis.TokenProvider
+	// optional cache of the tokens
+	tokenCache *tokenCache
 }
 
-//TODO: reset deadlines after method - ok
-func (b *AuthClient) sendAndReceiveGatewayAuth(conn DeadlineReaderWriter) error {
+func (b *AuthClient) getToken() (string, error) {
+	if b.tokenCache != nil {
+		return b.tokenCache.getToken()
+	}
 	//TODO: timeout
 	//	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
 	//	defer cancel()
+	return requestToken(context.Background(), b.tokenProvider)
+}
 
-	resp, err := b.tokenProvider.GetToken(context.Background(), apis.TokenRequest{})
+func requestToken(ctx context.Context, tokenProvider apis.TokenProvider) (string, error) {
+	resp, err := tokenProvider.GetToken(ctx, apis.TokenRequest{})
 	if err != nil {
-		return err
+		return "", err
 	}
 	if !resp.Success {
-		return fmt.Errorf("get token failed with status: %d", resp.Status)
+		return "", fmt.Errorf("get token failed with status: %d", resp.Status)
 	}
 	if resp.Token == "" {
-		return errors.New("get token returned empty token")
+		return "", errors.New("get token returned empty token")
+	}
+	return resp.Token, nil
+}
+
+//TODO: reset deadlines after method - ok
+func (b *AuthClient) sendAndReceiveGatewayAuth(conn DeadlineReaderWriter) error {
+	data, err := b.getToken()
+	if err != nil {
+		return err
 	}
-	data := resp.Token
 
 	length := len(b.method) + 1 + len(data)
 	// 8 - bytes magic, 4 bytes length
@@ -75,23 +91,28 @@ type AuthServer struct {
 	timeout time.Duration
 
 	tokenInfo apis.TokenInfo
+
+	// the connections are closed when the token expires
+	expiryEnable      bool
+	expiryGracePeriod time.Duration
 }
 
 //TODO: reset deadlines after method - ok
-func (b *AuthServer) receiveAndSendGatewayAuth(conn DeadlineReaderWriter) error {
+// receiveAndSendGatewayAuth returns the time when the connection of the verified token is closed, zero when it is not closed
+func (b *AuthServer) receiveAndSendGatewayAuth(conn DeadlineReaderWriter) (time.Time, error) {
 	err := conn.SetDeadline(time.Now().Add(b.timeout))
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	headerBuf := make([]byte, 12) // magic 8 + length 4
 	_, err = io.ReadFull(conn, headerBuf)
 	if err != nil {
-		return errors.Wrap(err, "Failed to read gateway bytes magic")
+		return time.Time{}, errors.Wrap(err, "Failed to read gateway bytes magic")
 	}
 
 	magic := binary.BigEndian.Uint64(headerBuf[:8])
 	if magic != b.magic {
-		return errors.New("gateway handshake magic bytes mismatch")
+		return time.Time{}, errors.New("gateway handshake magic bytes mismatch")
 	}
 
 	length := binary.BigEndian.Uint32(headerBuf[8:])
@@ -99,33 +120,69 @@ func (b *AuthServer) receiveAndSendGatewayAuth(conn DeadlineReaderWriter) error
 	payload := make([]byte, length)
 	_, err = io.ReadFull(conn, payload)
 	if err != nil {
-		return errors.Wrap(err, "failed to read gateway handshake payload")
+		return time.Time{}, errors.Wrap(err, "failed to read gateway handshake payload")
 	}
 	tokens := strings.Split(string(payload), "\x00")
 	if len(tokens) != 2 {
-		return fmt.Errorf("invalid gateway handshake: expected 2 tokens, got %d", len(tokens))
+		return time.Time{}, fmt.Errorf("invalid gateway handshake: expected 2 tokens, got %d", len(tokens))
 	}
 	if tokens[0] != b.method {
-		return fmt.Errorf("gateway handshake method mismatch: expected %s , got %s", b.method, tokens[0])
+		return time.Time{}, fmt.Errorf("gateway handshake method mismatch: expected %s , got %s", b.method, tokens[0])
 	}
 	data := tokens[1]
 
 	//TODO: timeout
 	//	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
 	//	defer cancel()
-	resp, err := b.tokenInfo.VerifyToken(context.Background(), apis.VerifyRequest{Token: data})
+	resp, err := b.tokenInfo.VerifyToken(context.Background(), apis.VerifyRequest{Token: data, AuthContext: newAuthContext(conn)})
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	if !resp.Success {
-		return fmt.Errorf("verify token failed with status: %d", resp.Status)
+		return time.Time{}, fmt.Errorf("verify token failed with status: %d", resp.Status)
+	}
+	expiry := b.expiry(data, resp)
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		return time.Time{}, fmt.Errorf("gateway token expired at %v", expiry)
 	}
 
-	logrus.Debugf("gateway handshake payload: %s", data)
+	logger.Debugf("gateway handshake payload: %s", data)
 
 	header := make([]byte, 4)
 	if _, err := conn.Write(header); err != nil {
-		return err
+		return time.Time{}, err
 	}
-	return nil
+	return expiry, nil
+}
+
+// expiry returns the token expiry with the grace period, zero when the connections are not closed or the expiry is unknown
+func (b *AuthServer) expiry(token string, resp apis.VerifyResponse) time.Time {
+	if !b.expiryEnable {
+		return time.Time{}
+	}
+	expiresAt := tokenExpiry(token, time.Time{})
+	if resp.ExpiresAt != 0 {
+		expiresAt = time.Unix(resp.ExpiresAt, 0)
+	}
+	if expiresAt.IsZero() {
+		return expiresAt
+	}
+	return expiresAt.Add(b.expiryGracePeriod)
+}
+
+// newAuthContext describes the client connection to the auth plugins, the TLS handshake is completed by the reads before the authentication
+func newAuthContext(conn interface{}) apis.AuthContext {
+	authContext := apis.AuthContext{}
+	if c, ok := conn.(net.Conn); ok {
+		authContext.ClientAddress = c.RemoteAddr().String()
+		authContext.ListenerAddress = c.LocalAddr().String()
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		state := c.ConnectionState()
+		authContext.ServerName = state.ServerName
+		if len(state.VerifiedChains) != 0 && len(state.VerifiedChains[0]) != 0 {
+			authContext.ClientCertSubject = state.VerifiedChains[0][0].Subject.String()
+		}
+	}
+	return authContext
 }
@@ -3,8 +3,10 @@ package proxy
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/stretchr/testify/assert"
 	"net"
@@ -66,12 +68,61 @@ func testAuthHandshake(a *assert.Assertions, mp func() (c1, c2 net.Conn, stop fu
 		cerr := client.sendAndReceiveGatewayAuth(c1)
 		clientResult <- cerr
 	}()
-	serr := server.receiveAndSendGatewayAuth(c2)
+	_, serr := server.receiveAndSendGatewayAuth(c2)
 	a.Nil(serr)
 	cerr := <-clientResult
 	a.Nil(cerr)
 }
 
+func TestAuthExpiry(t *testing.T) {
+	a := assert.New(t)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	server := &AuthServer{expiryEnable: true, expiryGracePeriod: time.Minute}
+	a.Equal(time.Unix(expiresAt, 0).Add(time.Minute), server.expiry("my-test-token", apis.VerifyResponse{Success: true, ExpiresAt: expiresAt}))
+	// exp claim of a JWT
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"kafka","exp":1500}`))
+	a.Equal(time.Unix(1500, 0).Add(time.Minute), server.expiry("eyJhbGciOiJSUzI1NiJ9."+payload+".c2ln", apis.VerifyResponse{Success: true}))
+	a.True(server.expiry("my-test-token", apis.VerifyResponse{Success: true}).IsZero())
+	server.expiryEnable = false
+	a.True(server.expiry("my-test-token", apis.VerifyResponse{Success: true, ExpiresAt: expiresAt}).IsZero())
+
+	magic, err := RandomUint64()
+	a.Nil(err)
+	client := &AuthClient{enabled: true, magic: magic, method: "google-id", timeout: 10 * time.Second,
+		tokenProvider: &testTokenProvider{response: apis.TokenResponse{Success: true, Token: "my-test-token"}}}
+	server = &AuthServer{enabled: true, magic: magic, method: "google-id", timeout: 10 * time.Second,
+		tokenInfo: &testTokenInfo{token: "my-test-token", expiresAt: time.Now().Add(-time.Minute).Unix()}, expiryEnable: true}
+
+	// expired token
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go client.sendAndReceiveGatewayAuth(c1)
+	_, err = server.receiveAndSendGatewayAuth(c2)
+	a.NotNil(err)
+	a.Contains(err.Error(), "gateway token expired at")
+	c2.Close()
+
+	// within the grace period
+	server.expiryGracePeriod = time.Hour
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	clientResult := make(chan error, 1)
+	go func() {
+		clientResult <- client.sendAndReceiveGatewayAuth(c1)
+	}()
+	expiry, err := server.receiveAndSendGatewayAuth(c2)
+	a.Nil(err)
+	a.Nil(<-clientResult)
+	a.WithinDuration(time.Now().Add(59*time.Minute), expiry, 5*time.Second)
+
+	// the connection is closed after the expiry
+	ctx := &RequestsLoopContext{gatewayExpiry: time.Now().Add(50 * time.Millisecond), clientAddress: "pipe"}
+	_, err = (&DefaultRequestHandler{}).handleRequest(c1, c2, ctx)
+	a.EqualError(err, "gateway token of client pipe expired")
+}
+
 type testTokenProvider struct {
 	response apis.TokenResponse
 	err      error
@@ -83,14 +134,15 @@ func (p *testTokenProvider) GetToken(ctx context.Context, request apis.TokenRequ
 }
 
 type testTokenInfo struct {
-	token string
-	err   error
+	token     string
+	expiresAt int64
+	err       error
 }
 
 // Implements apis.TokenProvider.GetToken
 func (p *testTokenInfo) VerifyToken(ctx context.Context, request apis.VerifyRequest) (apis.VerifyResponse, error) {
 	if p.token == request.Token {
-		return apis.VerifyResponse{Success: true}, p.err
+		return apis.VerifyResponse{Success: true, ExpiresAt: p.expiresAt}, p.err
 	}
 	return apis.VerifyResponse{Success: false}, p.err
 }
@@ -105,3 +157,51 @@ func RandomUint64() (uint64, error) {
 
 	return binary.LittleEndian.Uint64(b[:]), nil
 }
+
+type testContextPasswordAuthenticator struct {
+	testPasswordAuthenticator
+	authContext apis.AuthContext
+}
+
+func (t *testContextPasswordAuthenticator) AuthenticateWithContext(authContext apis.AuthContext, username, password string) (bool, int32, error) {
+	t.authContext = authContext
+	return t.Authenticate(username, password)
+}
+
+func TestAuthContext(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.CAChainCertFile = bundle.CACert.Name()
+	c.Kafka.TLS.CAChainCertFile = bundle.ServerCert.Name()
+	c.Kafka.TLS.ClientCertFile = bundle.ClientCert.Name()
+	c.Kafka.TLS.ClientKeyFile = bundle.ClientKey.Name()
+
+	c1, c2, stop, err := makeTLSPipe(c)
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	defer stop()
+	pingPong(t, c1, c2)
+
+	authContext := newAuthContext(c2)
+	a.Equal(c1.LocalAddr().String(), authContext.ClientAddress)
+	a.Equal(c1.RemoteAddr().String(), authContext.ListenerAddress)
+	a.Contains(authContext.ClientCertSubject, "CN=localhost")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	a.Equal(apis.AuthContext{ClientAddress: "pipe", ListenerAddress: "pipe"}, newAuthContext(server))
+
+	authenticator := &testContextPasswordAuthenticator{testPasswordAuthenticator: testPasswordAuthenticator{"alice": "secret"}}
+	localSasl := &LocalSasl{enabled: true, localAuthenticator: authenticator}
+	username, err := localSasl.doLocalAuth([]byte("\x00alice\x00secret"), authContext)
+	a.Nil(err)
+	a.Equal("alice", username)
+	a.Equal(authContext, authenticator.authContext)
+}
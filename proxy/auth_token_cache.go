@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCache caches the token of the gateway client authentication. The token is refreshed in the background
+// before it expires, the refresh time is jittered so the proxies do not refresh at the same time.
+// Concurrent connections wait for one request to the token provider.
+type tokenCache struct {
+	tokenProvider apis.TokenProvider
+	timeout       time.Duration
+	// lifetime of the tokens without the exp claim
+	ttl time.Duration
+	// how long before the expiry the token is refreshed
+	refreshBefore time.Duration
+
+	now    func() time.Time
+	jitter func(max time.Duration) time.Duration
+
+	token     string
+	refreshAt time.Time
+	expiresAt time.Time
+	refresh   *tokenRefresh
+	lock      sync.Mutex
+}
+
+// tokenRefresh is the request to the token provider shared by the waiting connections
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func newTokenCache(tokenProvider apis.TokenProvider, timeout time.Duration, ttl time.Duration, refreshBefore time.Duration) *tokenCache {
+	return &tokenCache{
+		tokenProvider: tokenProvider,
+		timeout:       timeout,
+		ttl:           ttl,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+		jitter: func(max time.Duration) time.Duration {
+			if max <= 0 {
+				return 0
+			}
+			return time.Duration(rand.Int63n(int64(max)))
+		},
+	}
+}
+
+func (c *tokenCache) getToken() (string, error) {
+	c.lock.Lock()
+	now := c.now()
+	if c.token != "" && now.Before(c.expiresAt) {
+		token := c.token
+		if !now.Before(c.refreshAt) {
+			c.startRefresh()
+		}
+		c.lock.Unlock()
+		return token, nil
+	}
+	refresh := c.startRefresh()
+	c.lock.Unlock()
+
+	<-refresh.done
+	return refresh.token, refresh.err
+}
+
+// startRefresh requests the token unless a request is in flight, the lock must be held
+func (c *tokenCache) startRefresh() *tokenRefresh {
+	if c.refresh != nil {
+		return c.refresh
+	}
+	refresh := &tokenRefresh{done: make(chan struct{})}
+	c.refresh = refresh
+	go withRecover(func() {
+		defer close(refresh.done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		refresh.token, refresh.err = requestToken(ctx, c.tokenProvider)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.refresh = nil
+		if refresh.err != nil {
+			logger.Warnf("gateway auth token refresh failed: %v", refresh.err)
+			return
+		}
+		now := c.now()
+		c.token = refresh.token
+		c.expiresAt = tokenExpiry(refresh.token, now.Add(c.ttl))
+		// refresh up to a quarter of refreshBefore earlier
+		c.refreshAt = c.expiresAt.Add(-c.refreshBefore - c.jitter(c.refreshBefore/4))
+		if c.refreshAt.Before(now) {
+			c.refreshAt = now
+		}
+	})
+	return refresh
+}
+
+// tokenExpiry returns the time of the exp claim of a JWT or the default expiry
+func tokenExpiry(token string, defaultExpiry time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return defaultExpiry
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return defaultExpiry
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return defaultExpiry
+	}
+	return time.Unix(claims.Exp, 0)
+}
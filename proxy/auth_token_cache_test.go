@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCountingTokenProvider returns the tokens token-1, token-2, ... after the release channel is readable
+type testCountingTokenProvider struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (p *testCountingTokenProvider) GetToken(ctx context.Context, request apis.TokenRequest) (apis.TokenResponse, error) {
+	calls := atomic.AddInt32(&p.calls, 1)
+	if p.release != nil {
+		<-p.release
+	}
+	return apis.TokenResponse{Success: true, Token: fmt.Sprintf("token-%d", calls)}, nil
+}
+
+func newTestTokenCache(tokenProvider apis.TokenProvider, now *time.Time) *tokenCache {
+	c := newTokenCache(tokenProvider, time.Second, 10*time.Minute, time.Minute)
+	c.now = func() time.Time { return *now }
+	c.jitter = func(max time.Duration) time.Duration { return max }
+	return c
+}
+
+func waitTokenRefresh(c *tokenCache) {
+	c.lock.Lock()
+	refresh := c.refresh
+	c.lock.Unlock()
+	if refresh != nil {
+		<-refresh.done
+	}
+}
+
+func TestTokenCacheSingleFlight(t *testing.T) {
+	a := assert.New(t)
+
+	tokenProvider := &testCountingTokenProvider{release: make(chan struct{})}
+	now := time.Unix(1000, 0)
+	c := newTestTokenCache(tokenProvider, &now)
+
+	var wg sync.WaitGroup
+	tokens := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := c.getToken()
+			a.Nil(err)
+			tokens <- token
+		}()
+	}
+	close(tokenProvider.release)
+	wg.Wait()
+	close(tokens)
+	for token := range tokens {
+		a.Equal("token-1", token)
+	}
+	a.Equal(int32(1), atomic.LoadInt32(&tokenProvider.calls))
+	// expiry 10m with the refresh 1m and the jitter 15s before
+	a.Equal(time.Unix(1600, 0), c.expiresAt)
+	a.Equal(time.Unix(1525, 0), c.refreshAt)
+}
+
+func TestTokenCacheProactiveRefresh(t *testing.T) {
+	a := assert.New(t)
+
+	tokenProvider := &testCountingTokenProvider{}
+	now := time.Unix(1000, 0)
+	c := newTestTokenCache(tokenProvider, &now)
+
+	token, err := c.getToken()
+	a.Nil(err)
+	a.Equal("token-1", token)
+
+	now = time.Unix(1500, 0)
+	token, err = c.getToken()
+	a.Nil(err)
+	a.Equal("token-1", token)
+	a.Equal(int32(1), atomic.LoadInt32(&tokenProvider.calls))
+
+	// the cached token is returned while the token is refreshed
+	now = time.Unix(1530, 0)
+	token, err = c.getToken()
+	a.Nil(err)
+	a.Equal("token-1", token)
+	waitTokenRefresh(c)
+	token, err = c.getToken()
+	a.Nil(err)
+	a.Equal("token-2", token)
+
+	// the expired token is not returned
+	now = time.Unix(3000, 0)
+	token, err = c.getToken()
+	a.Nil(err)
+	a.Equal("token-3", token)
+}
+
+func TestTokenCacheError(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	c := newTestTokenCache(&testTokenProvider{response: apis.TokenResponse{Success: false, Status: 401}}, &now)
+	_, err := c.getToken()
+	a.EqualError(err, "get token failed with status: 401")
+	a.Equal("", c.token)
+}
+
+func TestTokenExpiry(t *testing.T) {
+	a := assert.New(t)
+
+	defaultExpiry := time.Unix(1000, 0)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"kafka","exp":1500}`))
+	a.Equal(time.Unix(1500, 0), tokenExpiry("eyJhbGciOiJSUzI1NiJ9."+payload+".c2ln", defaultExpiry))
+	a.Equal(defaultExpiry, tokenExpiry("my-test-token", defaultExpiry))
+	a.Equal(defaultExpiry, tokenExpiry("a.b.c", defaultExpiry))
+	payload = base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"kafka"}`))
+	a.Equal(defaultExpiry, tokenExpiry("eyJhbGciOiJSUzI1NiJ9."+payload+".c2ln", defaultExpiry))
+}
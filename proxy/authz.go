@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// RequestAuthz asks the request authorizer whether a client request can be forwarded to the broker.
+type RequestAuthz struct {
+	enabled           bool
+	timeout           time.Duration
+	requestAuthorizer apis.RequestAuthorizer
+}
+
+// authorize checks whether the request (without the size field) can be forwarded to the broker. For a denied request
+// the error response to be returned to the client is provided, it is nil when the client does not expect a response (Produce with acks 0).
+// An error is returned when the request cannot be authorized or the error response for the denied request cannot be encoded.
+func (a *RequestAuthz) authorize(principal string, clientAddress string, request []byte) (allowed bool, errorResponse []byte, err error) {
+	if a.requestAuthorizer == nil {
+		return false, nil, protocol.PacketDecodingError{Info: "Request authorizer is not set"}
+	}
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return false, nil, err
+	}
+	apiKey := strconv.Itoa(int(info.ApiKey))
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	resp, err := a.requestAuthorizer.Authorize(ctx, apis.AuthorizeRequest{
+		Principal:     principal,
+		ClientAddress: clientAddress,
+		ClientID:      info.ClientID,
+		ApiKey:        int32(info.ApiKey),
+		ApiVersion:    int32(info.ApiVersion),
+		Topics:        info.Topics,
+		TopicsUnknown: info.TopicsUnknown,
+	})
+	if err != nil {
+		proxyRequestAuthzTotal.WithLabelValues(apiKey, "error").Inc()
+		return false, nil, err
+	}
+	proxyRequestAuthzTotal.WithLabelValues(apiKey, strconv.FormatBool(resp.Allowed)).Inc()
+
+	if resp.Allowed {
+		return true, nil, nil
+	}
+	errorCode := int16(resp.Status)
+	if errorCode == 0 {
+		if len(info.Topics) != 0 {
+			errorCode = int16(protocol.ErrTopicAuthorizationFailed)
+		} else {
+			errorCode = int16(protocol.ErrClusterAuthorizationFailed)
+		}
+	}
+	errorResponse, err = protocol.EncodeErrorResponse(request, errorCode)
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "request with api key %d version %d of principal '%s' from %s was denied", info.ApiKey, info.ApiVersion, principal, clientAddress)
+	}
+	logger.Infof("Request with api key %d version %d for topics %v of principal '%s' from %s was denied with error code %d", info.ApiKey, info.ApiVersion, info.Topics, principal, clientAddress, errorCode)
+	return false, errorResponse, nil
+}
+
+// substituteRequest returns the ApiVersions v0 request with the correlation id of the denied request (without the size field).
+// It is sent to the broker instead of the denied request to keep the order of responses, the broker response is replaced by the error response.
+func substituteRequest(request []byte) (keyVersionBuf []byte, requestBuf []byte) {
+	requestBuf = make([]byte, 10) // ApiKey => int16, ApiVersion => int16, CorrelationId => int32, ClientId => null string
+	binary.BigEndian.PutUint16(requestBuf[0:], uint16(apiKeyApiApiVersions))
+	copy(requestBuf[4:8], request[4:8])
+	binary.BigEndian.PutUint16(requestBuf[8:], 0xffff)
+
+	keyVersionBuf = make([]byte, 8)
+	binary.BigEndian.PutUint32(keyVersionBuf, uint32(len(requestBuf)))
+	copy(keyVersionBuf[4:], requestBuf[:4])
+	return keyVersionBuf, requestBuf
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// Metadata v4 request for topic t1 with the size field
+var testMetadataRequest = []byte{
+	0x00, 0x00, 0x00, 0x14,
+	0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x01, 'c',
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x02, 't', '1',
+	0x01,
+}
+
+// Metadata v4 error response for topic t1 with UNKNOWN_TOPIC_OR_PARTITION
+var testMetadataErrorResponse = []byte{
+	// throttle_time_ms, brokers, cluster_id, controller_id
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff,
+	// topics
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x03, 0x00, 0x02, 't', '1', 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+type testRequestAuthorizer struct {
+	allowed  bool
+	status   int32
+	err      error
+	requests []apis.AuthorizeRequest
+}
+
+func (t *testRequestAuthorizer) Authorize(ctx context.Context, request apis.AuthorizeRequest) (apis.AuthorizeResponse, error) {
+	t.requests = append(t.requests, request)
+	return apis.AuthorizeResponse{Allowed: t.allowed, Status: t.status}, t.err
+}
+
+func TestRequestAuthzAuthorize(t *testing.T) {
+	a := assert.New(t)
+
+	authorizer := &testRequestAuthorizer{allowed: true}
+	requestAuthz := &RequestAuthz{enabled: true, timeout: time.Second, requestAuthorizer: authorizer}
+
+	allowed, errorResponse, err := requestAuthz.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.True(allowed)
+	a.Nil(errorResponse)
+	a.Equal([]apis.AuthorizeRequest{{Principal: "alice", ClientAddress: "10.0.0.1:5000", ClientID: "c", ApiKey: 3, ApiVersion: 4, Topics: []string{"t1"}}}, authorizer.requests)
+
+	authorizer.allowed = false
+	authorizer.status = 3
+	allowed, errorResponse, err = requestAuthz.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.False(allowed)
+	a.Equal(testMetadataErrorResponse, errorResponse)
+
+	// status 0 selects TOPIC_AUTHORIZATION_FAILED
+	authorizer.status = 0
+	_, errorResponse, err = requestAuthz.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x1d}, errorResponse[18:20])
+
+	// the error response to the denied ApiVersions request cannot be encoded
+	_, _, err = requestAuthz.authorize("alice", "10.0.0.1:5000", []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.NotNil(err)
+
+	authorizer.err = errors.New("policy service unavailable")
+	_, _, err = requestAuthz.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Equal(authorizer.err, err)
+
+	// malformed request is not forwarded
+	_, _, err = requestAuthz.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:10])
+	a.NotNil(err)
+}
+
+func TestDefaultRequestHandlerAuthz(t *testing.T) {
+	a := assert.New(t)
+
+	for _, allowed := range []bool{true, false} {
+		client, local := net.Pipe()
+		remote, broker := net.Pipe()
+
+		authorizer := &testRequestAuthorizer{allowed: allowed, status: 3}
+		openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+		nextResponseHandlerChannel := make(chan ResponseHandler, 1)
+		ctx := &RequestsLoopContext{
+			openRequestsChannel:        openRequestsChannel,
+			nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+			nextResponseHandlerChannel: nextResponseHandlerChannel,
+			timeout:                    time.Second,
+			buf:                        make([]byte, 16),
+			localSasl:                  &LocalSasl{},
+			requestAuthz:               &RequestAuthz{enabled: true, timeout: time.Second, requestAuthorizer: authorizer},
+			clientAddress:              "10.0.0.1:5000",
+		}
+
+		go func() {
+			client.Write(testMetadataRequest)
+			client.Close()
+		}()
+		received := make(chan []byte, 1)
+		go func() {
+			buf, _ := ioutil.ReadAll(broker)
+			received <- buf
+		}()
+
+		_, err := defaultRequestHandler.handleRequest(remote, local, ctx)
+		remote.Close()
+		a.Nil(err)
+		openRequest := <-openRequestsChannel
+		if allowed {
+			a.Equal(testMetadataRequest, <-received)
+			a.Nil(openRequest.LocalResponse)
+		} else {
+			// ApiVersions v0 request with the correlation id of the denied request
+			a.Equal([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff}, <-received)
+			a.Equal(testMetadataErrorResponse, openRequest.LocalResponse)
+		}
+		a.Equal(defaultResponseHandler, <-nextResponseHandlerChannel)
+		a.Len(authorizer.requests, 1)
+	}
+}
+
+func TestDefaultRequestHandlerAuthzProduceWithoutAcks(t *testing.T) {
+	a := assert.New(t)
+
+	// Produce v3 request with acks 0 for topic t1
+	request := []byte{
+		0x00, 0x00, 0x00, 0x26,
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x01,
+		0xff, 0xff,
+		0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00, 0x75, 0x30,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+	}
+
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+
+	authorizer := &testRequestAuthorizer{allowed: false}
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	nextRequestHandlerChannel := make(chan RequestHandler, 1)
+	nextResponseHandlerChannel := make(chan ResponseHandler, 1)
+	ctx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  nextRequestHandlerChannel,
+		nextResponseHandlerChannel: nextResponseHandlerChannel,
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{enabled: true, timeout: time.Second, requestAuthorizer: authorizer},
+	}
+
+	go func() {
+		client.Write(request)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+
+	_, err := defaultRequestHandler.handleRequest(remote, local, ctx)
+	remote.Close()
+	a.Nil(err)
+	// the denied request is dropped
+	a.Empty(<-received)
+	a.Len(openRequestsChannel, 0)
+	a.Len(nextResponseHandlerChannel, 0)
+	a.Equal(defaultRequestHandler, <-nextRequestHandlerChannel)
+}
+
+func TestDefaultResponseHandlerLocalResponse(t *testing.T) {
+	a := assert.New(t)
+
+	local, client := net.Pipe()
+	broker, remote := net.Pipe()
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	ctx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		buf:                 make([]byte, 16),
+	}
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 3, ApiVersion: 4, Length: 0x14, LocalResponse: testMetadataErrorResponse}
+
+	go func() {
+		// ApiVersions v0 response to the substitute request
+		broker.Write([]byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x12, 0x00, 0x00, 0x00, 0x02})
+		broker.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(client)
+		received <- buf
+	}()
+
+	_, err := defaultResponseHandler.handleResponse(local, remote, ctx)
+	local.Close()
+	a.Nil(err)
+	a.Equal(append([]byte{0x00, 0x00, 0x00, 0x21, 0x00, 0x00, 0x00, 0x01}, testMetadataErrorResponse...), <-received)
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// brokerMigrationCheckInterval is how often the connections to the old broker addresses are checked
+const brokerMigrationCheckInterval = time.Second
+
+// brokerMigration is the change of the address of a broker
+type brokerMigration struct {
+	address string // the new broker address
+	since   time.Time
+}
+
+// brokerMigrations move the clients of a broker whose address changed in the Metadata responses or in the server mapping file
+// to its new address. The new connections to the old address are connected to the new address, the existing connections are closed
+// when they are idle or after the grace period, so the clients reconnect.
+type brokerMigrations struct {
+	conns       *ConnSet
+	idleTimeout time.Duration
+	gracePeriod time.Duration // the busy connections are kept when 0
+
+	mu sync.Mutex
+	// broker address by node id of the Metadata responses
+	nodes map[int32]string
+	// by the old broker address
+	migrations map[string]brokerMigration
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func newBrokerMigrations(conns *ConnSet, idleTimeout time.Duration, gracePeriod time.Duration) *brokerMigrations {
+	return &brokerMigrations{
+		conns:       conns,
+		idleTimeout: idleTimeout,
+		gracePeriod: gracePeriod,
+		nodes:       make(map[int32]string),
+		migrations:  make(map[string]brokerMigration),
+		stop:        make(chan struct{}),
+	}
+}
+
+// observeMetadata migrates the brokers whose node ids have a new address in the Metadata response (without the size and the correlation id)
+func (m *brokerMigrations) observeMetadata(apiVersion int16, response []byte) {
+	if m == nil {
+		return
+	}
+	leaders, err := protocol.DecodeMetadataLeaders(apiVersion, response)
+	if err != nil {
+		logger.Debugf("Broker addresses of the Metadata response version %d cannot be decoded: %v", apiVersion, err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, broker := range leaders.Brokers {
+		address := net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		if previous, ok := m.nodes[broker.NodeID]; ok && previous != address {
+			m.migrateLocked(previous, address)
+		}
+		m.nodes[broker.NodeID] = address
+	}
+}
+
+// migrate moves the clients of the old broker address to the new one
+func (m *brokerMigrations) migrate(oldAddress string, newAddress string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrateLocked(oldAddress, newAddress)
+}
+
+func (m *brokerMigrations) migrateLocked(oldAddress string, newAddress string) {
+	// the new address is not stale anymore, the earlier migrations to the old address move on to the new one
+	if _, ok := m.migrations[newAddress]; ok {
+		delete(m.migrations, newAddress)
+		proxyStaleBrokerConnections.DeleteLabelValues(newAddress)
+	}
+	for address, migration := range m.migrations {
+		if migration.address == oldAddress {
+			migration.address = newAddress
+			m.migrations[address] = migration
+		}
+	}
+	m.migrations[oldAddress] = brokerMigration{address: newAddress, since: time.Now()}
+	logger.Infof("Broker address changed from %s to %s, the new connections are connected to %s and the existing ones are closed when idle", oldAddress, newAddress, newAddress)
+}
+
+// brokerAddress returns the address the new connections to the broker address are connected to
+func (m *brokerMigrations) brokerAddress(address string) string {
+	if m == nil {
+		return address
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if migration, ok := m.migrations[address]; ok {
+		return migration.address
+	}
+	return address
+}
+
+// start closes the idle connections to the old broker addresses until the migrations are closed
+func (m *brokerMigrations) start() {
+	if m == nil {
+		return
+	}
+	go withRecover(func() {
+		ticker := time.NewTicker(brokerMigrationCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.closeStaleConns(time.Now())
+			case <-m.stop:
+				return
+			}
+		}
+	})
+}
+
+func (m *brokerMigrations) close() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// closeStaleConns closes the connections to the old broker addresses which are idle or whose grace period ended,
+// the remaining connections are counted by the stale broker connections metric
+func (m *brokerMigrations) closeStaleConns(now time.Time) {
+	m.mu.Lock()
+	migrations := make(map[string]brokerMigration, len(m.migrations))
+	for address, migration := range m.migrations {
+		migrations[address] = migration
+	}
+	m.mu.Unlock()
+
+	for address, migration := range migrations {
+		expired := m.gracePeriod > 0 && now.Sub(migration.since) >= m.gracePeriod
+		stale := 0
+		for _, conn := range m.conns.Conns(address) {
+			metadata := m.conns.metadata(conn)
+			if expired || (metadata != nil && metadata.idle(now) >= m.idleTimeout) {
+				logger.Infof("Closing connection from %s to the old broker address %s, the broker moved to %s", conn.RemoteAddr(), address, migration.address)
+				conn.Close()
+				continue
+			}
+			stale++
+		}
+		proxyStaleBrokerConnections.WithLabelValues(address).Set(float64(stale))
+	}
+}
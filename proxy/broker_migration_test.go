@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBrokerMigrationsMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	m := newBrokerMigrations(NewConnSet(), time.Minute, 0)
+	m.observeMetadata(0, testMetadataResponseV0(1, "kafka-1", "orders"))
+	a.Equal("kafka-1:9092", m.brokerAddress("kafka-1:9092"))
+
+	// the broker 1 moved
+	m.observeMetadata(0, testMetadataResponseV0(1, "kafka-1-new", "orders"))
+	a.Equal("kafka-1-new:9092", m.brokerAddress("kafka-1:9092"))
+	a.Equal("kafka-1-new:9092", m.brokerAddress("kafka-1-new:9092"))
+
+	// the earlier migrations follow the broker, the new address is not stale anymore
+	m.migrate("kafka-1-new:9092", "kafka-1-next:9092")
+	a.Equal("kafka-1-next:9092", m.brokerAddress("kafka-1:9092"))
+	m.migrate("kafka-1-next:9092", "kafka-1:9092")
+	a.Equal("kafka-1:9092", m.brokerAddress("kafka-1:9092"))
+	a.Equal("kafka-1:9092", m.brokerAddress("kafka-1-new:9092"))
+
+	// the responses which cannot be decoded are ignored
+	m.observeMetadata(0, []byte{0x00, 0x01})
+
+	// disabled
+	var disabled *brokerMigrations
+	disabled.observeMetadata(0, testMetadataResponseV0(1, "kafka-1", "orders"))
+	a.Equal("kafka-1:9092", disabled.brokerAddress("kafka-1:9092"))
+}
+
+func TestBrokerMigrationsCloseStaleConns(t *testing.T) {
+	a := assert.New(t)
+
+	conns := NewConnSet()
+	idleConn, idlePeer := net.Pipe()
+	busyConn, busyPeer := net.Pipe()
+	defer idlePeer.Close()
+	defer busyPeer.Close()
+	conns.Add("kafka-1:9092", idleConn)
+	conns.Add("kafka-1:9092", busyConn)
+	now := time.Now()
+	atomic.StoreInt64(&conns.metadata(idleConn).lastActive, now.Add(-time.Minute).UnixNano())
+
+	m := newBrokerMigrations(conns, 30*time.Second, time.Hour)
+	m.migrate("kafka-1:9092", "kafka-1-new:9092")
+	m.closeStaleConns(now)
+	_, err := idlePeer.Read(make([]byte, 1))
+	a.Equal(io.EOF, err)
+	a.Equal(float64(1), testGaugeValue(a, proxyStaleBrokerConnections.WithLabelValues("kafka-1:9092")))
+	conns.Remove("kafka-1:9092", idleConn)
+
+	// the busy connection is closed after the grace period
+	m.closeStaleConns(now.Add(2 * time.Hour))
+	_, err = busyPeer.Read(make([]byte, 1))
+	a.Equal(io.EOF, err)
+	a.Equal(float64(0), testGaugeValue(a, proxyStaleBrokerConnections.WithLabelValues("kafka-1:9092")))
+}
+
+func TestBrokerMigrationsServerMappingFile(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.DefaultListenerIP = "127.0.0.1"
+	listeners, err := NewListeners(c, nil, nil)
+	a.Nil(err)
+	defer listeners.Close()
+	m := newBrokerMigrations(NewConnSet(), time.Minute, 0)
+	listeners.setBrokerMigrations(m)
+
+	a.Nil(listeners.updateServerMappings([]config.ListenerConfig{{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-1:32401"}}, nil))
+	a.Equal("kafka-1:9092", m.brokerAddress("kafka-1:9092"))
+	a.Nil(listeners.updateServerMappings([]config.ListenerConfig{{BrokerAddress: "kafka-1-new:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-1:32401"}}, nil))
+	a.Equal("kafka-1-new:9092", m.brokerAddress("kafka-1:9092"))
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net"
+	"sync"
+	"time"
+)
+
+// brokerPool keeps the authenticated connections to the configured brokers, so the first client connections after a start do not
+// all pay the dial, TLS handshake and SASL authentication at once. The idle connections are kept warm with ApiVersions requests,
+// the connection taken by a client is replaced in the background.
+type brokerPool struct {
+	brokers      []string
+	size         int
+	pingInterval time.Duration
+	timeout      time.Duration
+	clientID     string
+	dial         func(brokerAddress string) (net.Conn, error)
+
+	mu      sync.Mutex
+	idle    map[string][]net.Conn
+	filling map[string]bool
+	closed  bool
+	stop    chan struct{}
+}
+
+func newBrokerPool(brokers []string, size int, pingInterval time.Duration, timeout time.Duration, clientID string, dial func(brokerAddress string) (net.Conn, error)) *brokerPool {
+	idle := make(map[string][]net.Conn)
+	for _, broker := range brokers {
+		idle[broker] = nil
+	}
+	return &brokerPool{
+		brokers:      brokers,
+		size:         size,
+		pingInterval: pingInterval,
+		timeout:      timeout,
+		clientID:     clientID,
+		dial:         dial,
+		idle:         idle,
+		filling:      make(map[string]bool),
+		stop:         make(chan struct{}),
+	}
+}
+
+// start fills the pool and pings the idle connections until the pool is closed
+func (p *brokerPool) start() {
+	if p == nil {
+		return
+	}
+	for _, broker := range p.brokers {
+		broker := broker
+		go withRecover(func() { p.fill(broker) })
+	}
+	go withRecover(func() {
+		ticker := time.NewTicker(p.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, broker := range p.brokers {
+					p.ping(broker)
+					p.fill(broker)
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	})
+}
+
+// take returns an idle connection to the broker, nil when there is none. The pool is refilled in the background.
+func (p *brokerPool) take(brokerAddress string) net.Conn {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	conns, ok := p.idle[brokerAddress]
+	if !ok {
+		// not a configured broker
+		p.mu.Unlock()
+		return nil
+	}
+	var conn net.Conn
+	for conn == nil && len(conns) != 0 {
+		conn, conns = conns[len(conns)-1], conns[:len(conns)-1]
+		if sc, ok := conn.(*saslConn); ok && sc.session.expiring() {
+			// the session would be re-authenticated by the first request
+			conn.Close()
+			conn = nil
+		}
+	}
+	p.idle[brokerAddress] = conns
+	proxyBrokerPoolIdleConnections.WithLabelValues(brokerAddress).Set(float64(len(conns)))
+	p.mu.Unlock()
+
+	result := "hit"
+	if conn == nil {
+		result = "miss"
+	}
+	proxyBrokerPoolTakesTotal.WithLabelValues(brokerAddress, result).Inc()
+	go withRecover(func() { p.fill(brokerAddress) })
+	return conn
+}
+
+// fill dials the missing connections of the broker, only one fill of the broker runs at a time
+func (p *brokerPool) fill(brokerAddress string) {
+	p.mu.Lock()
+	if p.closed || p.filling[brokerAddress] {
+		p.mu.Unlock()
+		return
+	}
+	p.filling[brokerAddress] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.filling[brokerAddress] = false
+		p.mu.Unlock()
+	}()
+
+	for {
+		p.mu.Lock()
+		missing := !p.closed && len(p.idle[brokerAddress]) < p.size
+		p.mu.Unlock()
+		if !missing {
+			return
+		}
+		conn, err := p.dial(brokerAddress)
+		if err != nil {
+			logger.Infof("couldn't connect the pool to %s: %v", brokerAddress, err)
+			return
+		}
+		if !p.put(brokerAddress, conn) {
+			return
+		}
+	}
+}
+
+// put adds the idle connection, the connection is closed when the pool is full or closed
+func (p *brokerPool) put(brokerAddress string, conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle[brokerAddress]) >= p.size {
+		conn.Close()
+		return false
+	}
+	p.idle[brokerAddress] = append(p.idle[brokerAddress], conn)
+	proxyBrokerPoolIdleConnections.WithLabelValues(brokerAddress).Set(float64(len(p.idle[brokerAddress])))
+	return true
+}
+
+// ping sends the ApiVersions request on the idle connections of the broker, the failed connections are closed
+func (p *brokerPool) ping(brokerAddress string) {
+	p.mu.Lock()
+	conns := p.idle[brokerAddress]
+	p.idle[brokerAddress] = nil
+	p.mu.Unlock()
+
+	for _, conn := range conns {
+		if err := p.pingConn(conn); err != nil {
+			logger.Infof("closing the pooled connection to %s: %v", brokerAddress, err)
+			conn.Close()
+			continue
+		}
+		p.put(brokerAddress, conn)
+	}
+	p.mu.Lock()
+	proxyBrokerPoolIdleConnections.WithLabelValues(brokerAddress).Set(float64(len(p.idle[brokerAddress])))
+	p.mu.Unlock()
+}
+
+func (p *brokerPool) pingConn(conn net.Conn) error {
+	response, err := roundTripRequest(conn, protocol.EncodeApiVersionsRequest(0, p.clientID), p.timeout)
+	if err != nil {
+		return err
+	}
+	if err = protocol.DecodeApiVersionsError(response); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// close stops the pings and closes the idle connections
+func (p *brokerPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	for broker, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		p.idle[broker] = nil
+		proxyBrokerPoolIdleConnections.WithLabelValues(broker).Set(0)
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPingBroker answers the requests with an ApiVersions v0 response without api keys
+func testPingBroker(t *testing.T) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pings int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					header := make([]byte, 12)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					if _, err := io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint32(header)-8)); err != nil {
+						return
+					}
+					atomic.AddInt32(&pings, 1)
+					response := []byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+					copy(response[4:8], header[8:12])
+					if _, err := conn.Write(response); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l, &pings
+}
+
+func waitIdleConnections(p *brokerPool, brokerAddress string, n int) bool {
+	for i := 0; i < 200; i++ {
+		p.mu.Lock()
+		idle := len(p.idle[brokerAddress])
+		p.mu.Unlock()
+		if idle == n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestBrokerPool(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *brokerPool
+	disabled.start()
+	a.Nil(disabled.take("broker:9092"))
+	disabled.close()
+
+	l, pings := testPingBroker(t)
+	defer l.Close()
+	broker := l.Addr().String()
+	var dials int32
+	pool := newBrokerPool([]string{broker}, 2, time.Hour, time.Second, "kafka-proxy", func(brokerAddress string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return net.Dial("tcp", brokerAddress)
+	})
+	pool.start()
+	a.True(waitIdleConnections(pool, broker, 2))
+
+	// the idle connections are pinged
+	pool.ping(broker)
+	a.Equal(int32(2), atomic.LoadInt32(pings))
+	a.True(waitIdleConnections(pool, broker, 2))
+
+	// the taken connection is replaced
+	conn := pool.take(broker)
+	a.NotNil(conn)
+	defer conn.Close()
+	a.True(waitIdleConnections(pool, broker, 2))
+	a.Equal(int32(3), atomic.LoadInt32(&dials))
+	a.Nil(pool.take("unknown:9092"))
+
+	// the taken connection is ready for the requests of the client
+	response, err := roundTripRequest(conn, []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00}, time.Second)
+	a.Nil(err)
+	a.Len(response, 6)
+
+	pool.close()
+	a.True(waitIdleConnections(pool, broker, 0))
+	a.Nil(pool.take(broker))
+}
+
+func TestBrokerPoolPingFailure(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	// the broker closes the connections after the first request
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.Read(make([]byte, 1))
+				conn.Close()
+			}()
+		}
+	}()
+	broker := l.Addr().String()
+	pool := newBrokerPool([]string{broker}, 1, time.Hour, time.Second, "kafka-proxy", func(brokerAddress string) (net.Conn, error) {
+		return net.Dial("tcp", brokerAddress)
+	})
+	defer pool.close()
+	pool.fill(broker)
+	a.True(waitIdleConnections(pool, broker, 1))
+
+	pool.ping(broker)
+	a.True(waitIdleConnections(pool, broker, 0))
+}
@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// canaryMetadataRefreshBackoff is the minimal interval between the Metadata requests refreshing the leaders of the canary cluster
+	canaryMetadataRefreshBackoff = time.Second
+	// maxCanaryLoggedDifferences limits the differences logged for one comparison, all differences are counted
+	maxCanaryLoggedDifferences = 10
+)
+
+// canaryDifference is a difference between the responses of the primary and the canary cluster
+type canaryDifference struct {
+	kind   string // topic, error_code, partitions or offset
+	detail string
+}
+
+// canaryComparison is the request sent to the broker and the broker response (both without the size) compared with the canary cluster
+type canaryComparison struct {
+	request  []byte
+	response []byte
+}
+
+// ClusterCanary compares the Metadata and ListOffsets responses of the primary cluster with the responses of the canary cluster
+// to validate the configuration of a migration target before the cutover. The requests sent to the brokers are repeated
+// asynchronously to the canary cluster, the clients receive only the responses of the primary cluster. The canary cluster is
+// only read: its Metadata requests do not create the topics. The differences are logged and counted, the comparisons which
+// cannot be queued or completed are skipped.
+type ClusterCanary struct {
+	name             string
+	bootstrapServers []string
+	sampleRate       float64
+	dial             func(brokerAddress string) (net.Conn, error)
+	timeout          time.Duration
+	clientID         string
+
+	queue     chan canaryComparison
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// owned by Run
+	leaders       *protocol.PartitionLeaders
+	knownTopics   map[string]struct{}
+	refreshed     time.Time
+	correlationID int32
+	conns         map[string]net.Conn
+	// set when a ListOffsets request failed, the leaders may have moved
+	stale bool
+}
+
+// NewClusterCanary creates the canary comparing the sample of the responses with the cluster of the bootstrap servers
+func NewClusterCanary(name string, bootstrapServers []string, sampleRate float64, queueSize int, dial func(brokerAddress string) (net.Conn, error), timeout time.Duration, clientID string) (*ClusterCanary, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, errors.New("canary sample rate must be between 0 and 1")
+	}
+	if queueSize <= 0 {
+		return nil, errors.New("canary queue size must be greater than 0")
+	}
+	return &ClusterCanary{
+		name:             name,
+		bootstrapServers: bootstrapServers,
+		sampleRate:       sampleRate,
+		dial:             dial,
+		timeout:          timeout,
+		clientID:         clientID,
+		queue:            make(chan canaryComparison, queueSize),
+		done:             make(chan struct{}),
+		knownTopics:      make(map[string]struct{}),
+		conns:            make(map[string]net.Conn),
+	}, nil
+}
+
+// compares decides whether the response to the request is compared with the canary cluster, false when the canary is disabled
+func (c *ClusterCanary) compares(apiKey int16, apiVersion int16) bool {
+	if c == nil || (apiKey != apiKeyMetadata && apiKey != apiKeyListOffsets) || rand.Float64() >= c.sampleRate {
+		return false
+	}
+	if !protocol.CanaryVersionSupported(apiKey, apiVersion) {
+		proxyCanarySkippedTotal.WithLabelValues("unsupported").Inc()
+		return false
+	}
+	return true
+}
+
+// compare queues the comparison of the broker response (without the size and the correlation id) to the request (without the size),
+// the request and the response must not be modified afterwards
+func (c *ClusterCanary) compare(request []byte, response []byte) {
+	select {
+	case c.queue <- canaryComparison{request: request, response: response}:
+	default:
+		proxyCanarySkippedTotal.WithLabelValues("queue").Inc()
+	}
+}
+
+// Run compares the queued responses until the canary is closed
+func (c *ClusterCanary) Run() {
+	logger.Infof("Metadata and ListOffsets responses are compared with canary cluster %s %v", c.name, c.bootstrapServers)
+	defer func() {
+		for address, conn := range c.conns {
+			conn.Close()
+			delete(c.conns, address)
+		}
+	}()
+	for {
+		select {
+		case <-c.done:
+			return
+		case comparison := <-c.queue:
+			c.check(comparison)
+		}
+	}
+}
+
+func (c *ClusterCanary) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// check compares the responses, logs and counts their differences
+func (c *ClusterCanary) check(comparison canaryComparison) {
+	apiKey := int16(binary.BigEndian.Uint16(comparison.request))
+	apiVersion := int16(binary.BigEndian.Uint16(comparison.request[2:]))
+	var differences []canaryDifference
+	var err error
+	switch apiKey {
+	case apiKeyMetadata:
+		differences, err = c.compareMetadata(apiVersion, comparison.request, comparison.response)
+	case apiKeyListOffsets:
+		differences, err = c.compareListOffsets(apiVersion, comparison.request, comparison.response)
+	default:
+		err = fmt.Errorf("api key %d is not compared", apiKey)
+	}
+	if err != nil {
+		logger.Debugf("%s response is not compared with canary cluster %s: %v", protocol.ApiKeyName(apiKey), c.name, err)
+		proxyCanarySkippedTotal.WithLabelValues("error").Inc()
+		return
+	}
+	apiName := protocol.ApiKeyName(apiKey)
+	proxyCanaryComparisonsTotal.WithLabelValues(apiName).Inc()
+	if len(differences) == 0 {
+		return
+	}
+	details := make([]string, 0, maxCanaryLoggedDifferences)
+	for i, difference := range differences {
+		proxyCanaryDifferencesTotal.WithLabelValues(apiName, difference.kind).Inc()
+		if i < maxCanaryLoggedDifferences {
+			details = append(details, difference.detail)
+		}
+	}
+	if len(differences) > maxCanaryLoggedDifferences {
+		details = append(details, fmt.Sprintf("%d more", len(differences)-maxCanaryLoggedDifferences))
+	}
+	logger.Infof("%s response of canary cluster %s differs: %s", apiName, c.name, strings.Join(details, ", "))
+}
+
+// compareMetadata compares the topics of the Metadata responses. The canary cluster is asked for the topics of the request,
+// the topics only in the canary cluster are reported when the request asks for all topics. The brokers and the leaders are not compared.
+func (c *ClusterCanary) compareMetadata(apiVersion int16, request []byte, response []byte) ([]canaryDifference, error) {
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := protocol.DecodeMetadataLeaders(apiVersion, response)
+	if err != nil {
+		return nil, err
+	}
+	// the Metadata requests up to v8 are decoded, their unknown topics are all topics
+	var topics []string
+	if !info.TopicsUnknown {
+		if len(info.Topics) == 0 {
+			return nil, nil
+		}
+		topics = info.Topics
+	}
+	c.correlationID++
+	canaryResponse, err := c.bootstrapRoundTrip(protocol.EncodeCanaryMetadataRequest(c.correlationID, c.clientID, topics))
+	if err != nil {
+		return nil, err
+	}
+	canary, err := protocol.DecodeMetadataLeaders(protocol.CanaryMetadataVersion, canaryResponse)
+	if err != nil {
+		return nil, err
+	}
+	return diffMetadata(primary, canary, info.TopicsUnknown), nil
+}
+
+// diffMetadata compares the error codes and the partition counts of the topics, the topics only in one of the responses are
+// reported when all topics were requested
+func diffMetadata(primary *protocol.MetadataLeaders, canary *protocol.MetadataLeaders, allTopics bool) []canaryDifference {
+	canaryTopics := make(map[string]protocol.MetadataTopic, len(canary.Topics))
+	for _, topic := range canary.Topics {
+		canaryTopics[topic.Name] = topic
+	}
+	var differences []canaryDifference
+	primaryTopics := make(map[string]struct{}, len(primary.Topics))
+	for _, topic := range primary.Topics {
+		primaryTopics[topic.Name] = struct{}{}
+		canaryTopic, ok := canaryTopics[topic.Name]
+		switch {
+		case !ok:
+			if allTopics {
+				differences = append(differences, canaryDifference{kind: "topic", detail: fmt.Sprintf("topic %s is missing", topic.Name)})
+			}
+		case topic.ErrorCode != canaryTopic.ErrorCode:
+			differences = append(differences, canaryDifference{kind: "error_code",
+				detail: fmt.Sprintf("topic %s error code %d, primary %d", topic.Name, canaryTopic.ErrorCode, topic.ErrorCode)})
+		case len(topic.Leaders) != len(canaryTopic.Leaders):
+			differences = append(differences, canaryDifference{kind: "partitions",
+				detail: fmt.Sprintf("topic %s has %d partitions, primary %d", topic.Name, len(canaryTopic.Leaders), len(topic.Leaders))})
+		}
+	}
+	if allTopics {
+		for _, topic := range canary.Topics {
+			if _, ok := primaryTopics[topic.Name]; !ok {
+				differences = append(differences, canaryDifference{kind: "topic", detail: fmt.Sprintf("topic %s is not in primary", topic.Name)})
+			}
+		}
+	}
+	return differences
+}
+
+// compareListOffsets compares the partitions of the ListOffsets responses. The request is split by the leaders of the partitions
+// in the canary cluster.
+func (c *ClusterCanary) compareListOffsets(apiVersion int16, request []byte, response []byte) ([]canaryDifference, error) {
+	primary, err := protocol.DecodeListOffsetsResponse(apiVersion, response)
+	if err != nil {
+		return nil, err
+	}
+	requests, unknown, err := protocol.SplitListOffsetsRequest(request, c.leaders.Leader)
+	if err == nil && (len(unknown) != 0 || c.stale) && c.refresh(unknown) {
+		requests, _, err = protocol.SplitListOffsetsRequest(request, c.leaders.Leader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	canary := make(map[string]map[int32]protocol.ListOffsetsPartition)
+	for brokerAddress, canaryRequest := range requests {
+		canaryResponse, err := c.roundTrip(brokerAddress, canaryRequest)
+		if err != nil {
+			c.stale = true
+			return nil, err
+		}
+		partitions, err := protocol.DecodeListOffsetsResponse(apiVersion, canaryResponse)
+		if err != nil {
+			return nil, err
+		}
+		for topic, topicPartitions := range partitions {
+			if canary[topic] == nil {
+				canary[topic] = make(map[int32]protocol.ListOffsetsPartition)
+			}
+			for partition, offsets := range topicPartitions {
+				canary[topic][partition] = offsets
+			}
+		}
+	}
+	return diffListOffsets(primary, canary), nil
+}
+
+// diffListOffsets compares the error codes and the offsets of the partitions of the primary response, the partitions without
+// a leader in the canary cluster are missing
+func diffListOffsets(primary map[string]map[int32]protocol.ListOffsetsPartition, canary map[string]map[int32]protocol.ListOffsetsPartition) []canaryDifference {
+	topics := make([]string, 0, len(primary))
+	for topic := range primary {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var differences []canaryDifference
+	for _, topic := range topics {
+		partitions := make([]int32, 0, len(primary[topic]))
+		for partition := range primary[topic] {
+			partitions = append(partitions, partition)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		for _, partition := range partitions {
+			offsets := primary[topic][partition]
+			canaryOffsets, ok := canary[topic][partition]
+			switch {
+			case !ok:
+				differences = append(differences, canaryDifference{kind: "partitions", detail: fmt.Sprintf("topic %s partition %d is missing", topic, partition)})
+			case offsets.ErrorCode != canaryOffsets.ErrorCode:
+				differences = append(differences, canaryDifference{kind: "error_code",
+					detail: fmt.Sprintf("topic %s partition %d error code %d, primary %d", topic, partition, canaryOffsets.ErrorCode, offsets.ErrorCode)})
+			case offsets.Offset != canaryOffsets.Offset:
+				differences = append(differences, canaryDifference{kind: "offset",
+					detail: fmt.Sprintf("topic %s partition %d offset %d, primary %d", topic, partition, canaryOffsets.Offset, offsets.Offset)})
+			}
+		}
+	}
+	return differences
+}
+
+// refresh fetches the leaders of the known topics and the new topics, it returns false when the leaders were not refreshed
+func (c *ClusterCanary) refresh(newTopics []string) bool {
+	for _, topic := range newTopics {
+		c.knownTopics[topic] = struct{}{}
+	}
+	if time.Since(c.refreshed) < canaryMetadataRefreshBackoff {
+		return false
+	}
+	c.refreshed = time.Now()
+
+	topics := make([]string, 0, len(c.knownTopics))
+	for topic := range c.knownTopics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	c.correlationID++
+	response, err := c.bootstrapRoundTrip(protocol.EncodeCanaryMetadataRequest(c.correlationID, c.clientID, topics))
+	if err != nil {
+		logger.Infof("Metadata request to canary cluster %s failed: %v", c.name, err)
+		return false
+	}
+	metadata, err := protocol.DecodeMetadataLeaders(protocol.CanaryMetadataVersion, response)
+	if err != nil {
+		logger.Infof("Metadata response of canary cluster %s cannot be decoded: %v", c.name, err)
+		return false
+	}
+	c.leaders = metadata.PartitionLeaders()
+	c.stale = false
+	return true
+}
+
+// bootstrapRoundTrip sends the request to the first bootstrap server which responds
+func (c *ClusterCanary) bootstrapRoundTrip(request []byte) ([]byte, error) {
+	var err error
+	for _, brokerAddress := range c.bootstrapServers {
+		var response []byte
+		if response, err = c.roundTrip(brokerAddress, request); err == nil {
+			return response, nil
+		}
+		logger.Debugf("Request to %s of canary cluster %s failed: %v", brokerAddress, c.name, err)
+	}
+	return nil, err
+}
+
+// roundTrip sends the request over the connection to the broker, the connection is closed when the request fails
+func (c *ClusterCanary) roundTrip(brokerAddress string, request []byte) ([]byte, error) {
+	conn, ok := c.conns[brokerAddress]
+	if !ok {
+		var err error
+		if conn, err = c.dial(brokerAddress); err != nil {
+			return nil, err
+		}
+		c.conns[brokerAddress] = conn
+	}
+	response, err := roundTripRequest(conn, request, c.timeout)
+	if err != nil {
+		conn.Close()
+		delete(c.conns, brokerAddress)
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Metadata v4 response with the broker 1 leading the partition 0 of the topic
+func testCanaryMetadataResponse(host string, topic string) []byte {
+	responseV1 := testShadowMetadataResponse(host, topic)
+	brokersLength := 4 + 4 + 2 + len(host) + 4 + 2
+	// throttle_time_ms, brokers, cluster_id
+	response := append([]byte{0x00, 0x00, 0x00, 0x00}, responseV1[:brokersLength]...)
+	response = append(response, 0xff, 0xff)
+	return append(response, responseV1[brokersLength:]...)
+}
+
+// ListOffsets v1 request (without the size) for the latest offset of the partition 0 of the topic
+func testListOffsetsRequest(topic string) []byte {
+	request := []byte{0x00, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x00, 0x01, 'c', 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01, 0x00, byte(len(topic))}
+	request = append(request, topic...)
+	request = append(request, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	return append(request, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+}
+
+// ListOffsets v1 response with the offset of the partition 0 of the topic
+func testListOffsetsResponse(topic string, offset int64) []byte {
+	response := []byte{0x00, 0x00, 0x00, 0x01, 0x00, byte(len(topic))}
+	response = append(response, topic...)
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	return append(response, byte(offset>>56), byte(offset>>48), byte(offset>>40), byte(offset>>32), byte(offset>>24), byte(offset>>16), byte(offset>>8), byte(offset))
+}
+
+// testCanaryDial answers the Metadata requests of the bootstrap server and the ListOffsets requests of the leader with the offset 40
+func testCanaryDial(a *assert.Assertions, metadataRequests chan<- []byte) func(string) (net.Conn, error) {
+	return func(brokerAddress string) (net.Conn, error) {
+		if brokerAddress != "canary-0:9092" && brokerAddress != "canary-1:9092" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			for {
+				request := testReadFrame(a, server)
+				if request == nil {
+					return
+				}
+				switch int16(binary.BigEndian.Uint16(request)) {
+				case apiKeyMetadata:
+					metadataRequests <- request
+					testWriteResponse(a, server, request, testCanaryMetadataResponse("canary-1", "t1"))
+				case apiKeyListOffsets:
+					testWriteResponse(a, server, request, testListOffsetsResponse("t1", 40))
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func TestNewClusterCanary(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewClusterCanary("canary", []string{"canary-0:9092"}, 0, 10, nil, time.Second, "test")
+	a.EqualError(err, "canary sample rate must be between 0 and 1")
+	_, err = NewClusterCanary("canary", []string{"canary-0:9092"}, 1, 0, nil, time.Second, "test")
+	a.EqualError(err, "canary queue size must be greater than 0")
+
+	c, err := NewClusterCanary("canary", []string{"canary-0:9092"}, 1, 1, nil, time.Second, "test")
+	a.Nil(err)
+	a.True(c.compares(apiKeyMetadata, 8))
+	a.True(c.compares(apiKeyListOffsets, 1))
+	a.False(c.compares(apiKeyFetch, 4))
+	skipped := testCounterValue(a, proxyCanarySkippedTotal.WithLabelValues("unsupported"))
+	a.False(c.compares(apiKeyMetadata, 12))
+	a.Equal(skipped+1, testCounterValue(a, proxyCanarySkippedTotal.WithLabelValues("unsupported")))
+
+	skipped = testCounterValue(a, proxyCanarySkippedTotal.WithLabelValues("queue"))
+	c.compare(testListOffsetsRequest("t1"), testListOffsetsResponse("t1", 42))
+	c.compare(testListOffsetsRequest("t1"), testListOffsetsResponse("t1", 42))
+	a.Equal(skipped+1, testCounterValue(a, proxyCanarySkippedTotal.WithLabelValues("queue")))
+
+	var disabled *ClusterCanary
+	a.False(disabled.compares(apiKeyMetadata, 1))
+}
+
+func TestClusterCanary(t *testing.T) {
+	a := assert.New(t)
+
+	metadataRequests := make(chan []byte, 2)
+	c, err := NewClusterCanary("canary", []string{"canary-0:9092"}, 1, 10, testCanaryDial(a, metadataRequests), time.Second, "test")
+	a.Nil(err)
+	// the connections to the canary cluster are closed by Run after Close
+	defer c.Run()
+	defer c.Close()
+
+	// the leaders are fetched from the bootstrap server, the partition is asked for its leader in the canary cluster
+	offsets := testCounterValue(a, proxyCanaryDifferencesTotal.WithLabelValues("ListOffsets", "offset"))
+	c.check(canaryComparison{request: testListOffsetsRequest("t1"), response: testListOffsetsResponse("t1", 42)})
+	a.Equal(offsets+1, testCounterValue(a, proxyCanaryDifferencesTotal.WithLabelValues("ListOffsets", "offset")))
+	request := <-metadataRequests
+	info, err := protocol.DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(int16(protocol.CanaryMetadataVersion), info.ApiVersion)
+	a.Equal([]string{"t1"}, info.Topics)
+
+	comparisons := testCounterValue(a, proxyCanaryComparisonsTotal.WithLabelValues("ListOffsets"))
+	c.check(canaryComparison{request: testListOffsetsRequest("t1"), response: testListOffsetsResponse("t1", 40)})
+	a.Equal(comparisons+1, testCounterValue(a, proxyCanaryComparisonsTotal.WithLabelValues("ListOffsets")))
+	a.Equal(offsets+1, testCounterValue(a, proxyCanaryDifferencesTotal.WithLabelValues("ListOffsets", "offset")))
+
+	// all topics of the Metadata v1 request are compared, the topic t2 is not in the canary cluster
+	topics := testCounterValue(a, proxyCanaryDifferencesTotal.WithLabelValues("Metadata", "topic"))
+	allTopics := []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x00, 0x01, 'c', 0xff, 0xff, 0xff, 0xff}
+	primary := testShadowMetadataResponse("kafka-1", "t2")
+	c.check(canaryComparison{request: allTopics, response: primary})
+	a.Equal(topics+2, testCounterValue(a, proxyCanaryDifferencesTotal.WithLabelValues("Metadata", "topic")))
+	info, err = protocol.DecodeRequestInfo(<-metadataRequests)
+	a.Nil(err)
+	a.True(info.TopicsUnknown)
+}
+
+func TestCanaryDifferences(t *testing.T) {
+	a := assert.New(t)
+
+	primary := &protocol.MetadataLeaders{Topics: []protocol.MetadataTopic{
+		{Name: "t1", Leaders: map[int32]int32{0: 1, 1: 2}},
+		{Name: "t2", Leaders: map[int32]int32{0: 1}},
+		{Name: "t3", Leaders: map[int32]int32{0: 1}},
+		{Name: "t4", Leaders: map[int32]int32{0: 1}},
+	}}
+	canary := &protocol.MetadataLeaders{Topics: []protocol.MetadataTopic{
+		{Name: "t1", Leaders: map[int32]int32{0: 3, 1: 3}},
+		{Name: "t2", Leaders: map[int32]int32{0: 1, 1: 1}},
+		{Name: "t3", ErrorCode: 3, Leaders: map[int32]int32{}},
+		{Name: "t5", Leaders: map[int32]int32{0: 1}},
+	}}
+	// the leaders are not compared
+	a.Equal([]canaryDifference{
+		{kind: "partitions", detail: "topic t2 has 2 partitions, primary 1"},
+		{kind: "error_code", detail: "topic t3 error code 3, primary 0"},
+	}, diffMetadata(primary, canary, false))
+	a.Equal([]canaryDifference{
+		{kind: "partitions", detail: "topic t2 has 2 partitions, primary 1"},
+		{kind: "error_code", detail: "topic t3 error code 3, primary 0"},
+		{kind: "topic", detail: "topic t4 is missing"},
+		{kind: "topic", detail: "topic t5 is not in primary"},
+	}, diffMetadata(primary, canary, true))
+
+	primaryOffsets := map[string]map[int32]protocol.ListOffsetsPartition{
+		"t1": {0: {Offset: 10}, 1: {Offset: 20}, 2: {Offset: 30}},
+		"t2": {0: {Offset: 10}},
+	}
+	canaryOffsets := map[string]map[int32]protocol.ListOffsetsPartition{
+		"t1": {0: {Offset: 10}, 1: {Offset: 15}, 2: {ErrorCode: 6, Offset: -1}},
+	}
+	a.Equal([]canaryDifference{
+		{kind: "offset", detail: "topic t1 partition 1 offset 15, primary 20"},
+		{kind: "error_code", detail: "topic t1 partition 2 error code 6, primary 0"},
+		{kind: "partitions", detail: "topic t2 partition 0 is missing"},
+	}, diffListOffsets(primaryOffsets, canaryOffsets))
+}
@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	captureRequest  = 0
+	captureResponse = 1
+
+	pcapLinkTypeRaw   = 101 // IPv4 packets without link layer
+	pcapSnapLen       = 262144
+	maxCaptureSegment = 65535 - 40 // IPv4 total length without the IPv4 and TCP headers
+)
+
+// CaptureFilter selects the captured connections and frames, an empty list matches all
+type CaptureFilter struct {
+	ApiKeys []int16  `json:"api_keys"`
+	Clients []string `json:"clients"` // client IP addresses
+	Brokers []string `json:"brokers"` // broker addresses host:port
+}
+
+func (f *CaptureFilter) matches(clientIP string, brokerAddress string, apiKey int16) bool {
+	if apiKey == apiKeySaslHandshake || apiKey == apiKeySaslAuthenticate {
+		// the credentials are not captured
+		return false
+	}
+	if len(f.ApiKeys) != 0 {
+		found := false
+		for _, v := range f.ApiKeys {
+			found = found || v == apiKey
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchesCaptureValue(f.Clients, clientIP) && matchesCaptureValue(f.Brokers, brokerAddress)
+}
+
+func matchesCaptureValue(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// FrameCapture writes the Kafka frames of the selected connections to pcap files in the directory, a file per connection.
+// The frames are written as seen by the client (requests before and responses after their modification by the proxy)
+// in TCP segments between the client and the broker address, so they can be decoded by the Kafka dissector of Wireshark.
+// The capture is enabled and disabled at runtime, the files are closed when the capture is disabled.
+type FrameCapture struct {
+	dir    string
+	active int32 // fast path of the disabled capture
+
+	mu     sync.Mutex
+	filter *CaptureFilter // nil when disabled
+	files  map[*connectionCapture]struct{}
+}
+
+func NewFrameCapture(dir string) (*FrameCapture, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("frame capture directory %s is not a directory", dir)
+	}
+	return &FrameCapture{dir: dir, files: make(map[*connectionCapture]struct{})}, nil
+}
+
+// Enable starts the capture with the filter, the files of the previous capture are closed
+func (c *FrameCapture) Enable(filter CaptureFilter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeFiles()
+	c.filter = &filter
+	atomic.StoreInt32(&c.active, 1)
+	logger.Infof("Frame capture to %s enabled: api keys %v, clients %v, brokers %v", c.dir, filter.ApiKeys, filter.Clients, filter.Brokers)
+}
+
+func (c *FrameCapture) Disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeFiles()
+	if c.filter != nil {
+		logger.Infof("Frame capture to %s disabled", c.dir)
+	}
+	c.filter = nil
+	atomic.StoreInt32(&c.active, 0)
+}
+
+// Filter returns the filter of the enabled capture, nil when disabled
+func (c *FrameCapture) Filter() *CaptureFilter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filter
+}
+
+func (c *FrameCapture) closeFiles() {
+	for cc := range c.files {
+		cc.file.Close()
+		cc.file = nil
+	}
+	c.files = make(map[*connectionCapture]struct{})
+}
+
+// ServeHTTP returns the filter of the enabled capture on GET, enables the capture with the filter in the body on POST or PUT
+// and disables it on DELETE
+func (c *FrameCapture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		filter := CaptureFilter{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+				http.Error(w, fmt.Sprintf("invalid capture filter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		c.Enable(filter)
+	case http.MethodDelete:
+		c.Disable()
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := c.Filter()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Enabled bool           `json:"enabled"`
+		Filter  *CaptureFilter `json:"filter,omitempty"`
+	}{Enabled: filter != nil, Filter: filter})
+}
+
+// connection returns the capture of the connection, nil when the capture is not configured
+func (c *FrameCapture) connection(clientAddress string, brokerAddress string) *connectionCapture {
+	if c == nil {
+		return nil
+	}
+	cc := &connectionCapture{capture: c, clientAddress: clientAddress, brokerAddress: brokerAddress}
+	cc.clientIP, cc.clientPort = captureEndpoint(clientAddress, net.IPv4(127, 0, 0, 1))
+	cc.brokerIP, cc.brokerPort = captureEndpoint(brokerAddress, net.IPv4(127, 0, 0, 2))
+	if host, _, err := net.SplitHostPort(clientAddress); err == nil {
+		cc.clientHost = host
+	}
+	return cc
+}
+
+// captureEndpoint returns the IPv4 address and port of the address, the default IP is used for the host names and IPv6 addresses
+func captureEndpoint(address string, defaultIP net.IP) (net.IP, uint16) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return defaultIP.To4(), 0
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		return ip, uint16(port)
+	}
+	return defaultIP.To4(), uint16(port)
+}
+
+type connectionCapture struct {
+	capture       *FrameCapture
+	clientAddress string
+	clientHost    string
+	brokerAddress string
+	clientIP      net.IP
+	clientPort    uint16
+	brokerIP      net.IP
+	brokerPort    uint16
+
+	// guarded by capture.mu
+	file   *os.File
+	seq    [2]uint32 // by direction
+	closed bool
+}
+
+// enabled returns true when the frames of the api key are captured
+func (cc *connectionCapture) enabled(apiKey int16) bool {
+	if cc == nil || atomic.LoadInt32(&cc.capture.active) == 0 {
+		return false
+	}
+	cc.capture.mu.Lock()
+	defer cc.capture.mu.Unlock()
+	return cc.capture.filter != nil && cc.capture.filter.matches(cc.clientHost, cc.brokerAddress, apiKey)
+}
+
+// write captures the frame of the direction, the frame parts are concatenated
+func (cc *connectionCapture) write(direction int, frame ...[]byte) {
+	if cc == nil {
+		return
+	}
+	cc.capture.mu.Lock()
+	defer cc.capture.mu.Unlock()
+
+	if cc.closed || cc.capture.filter == nil {
+		return
+	}
+	if cc.file == nil {
+		file, err := cc.create()
+		if err != nil {
+			logger.Errorf("Frame capture of %s to %s failed: %v", cc.clientAddress, cc.brokerAddress, err)
+			return
+		}
+		cc.file = file
+		cc.capture.files[cc] = struct{}{}
+	}
+	payload := make([]byte, 0)
+	for _, v := range frame {
+		payload = append(payload, v...)
+	}
+	now := time.Now()
+	for len(payload) != 0 {
+		segment := payload
+		if len(segment) > maxCaptureSegment {
+			segment = segment[:maxCaptureSegment]
+		}
+		payload = payload[len(segment):]
+		if _, err := cc.file.Write(cc.packet(now, direction, segment)); err != nil {
+			logger.Errorf("Frame capture of %s to %s failed: %v", cc.clientAddress, cc.brokerAddress, err)
+			return
+		}
+		cc.seq[direction] += uint32(len(segment))
+	}
+}
+
+func (cc *connectionCapture) close() {
+	if cc == nil {
+		return
+	}
+	cc.capture.mu.Lock()
+	defer cc.capture.mu.Unlock()
+
+	cc.closed = true
+	if cc.file != nil {
+		cc.file.Close()
+		cc.file = nil
+		delete(cc.capture.files, cc)
+	}
+}
+
+func (cc *connectionCapture) create() (*os.File, error) {
+	name := fmt.Sprintf("%s-%s-%s.pcap", time.Now().Format("20060102T150405.000000"), cc.clientAddress, cc.brokerAddress)
+	name = strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(name)
+	file, err := os.OpenFile(filepath.Join(cc.capture.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	if _, err = file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// packet returns the pcap record of the IPv4 packet with the TCP segment
+func (cc *connectionCapture) packet(ts time.Time, direction int, segment []byte) []byte {
+	srcIP, srcPort, dstIP, dstPort := cc.clientIP, cc.clientPort, cc.brokerIP, cc.brokerPort
+	if direction == captureResponse {
+		srcIP, srcPort, dstIP, dstPort = dstIP, dstPort, srcIP, srcPort
+	}
+	packetLen := 40 + len(segment)
+	buf := make([]byte, 16+packetLen)
+
+	// record header
+	binary.LittleEndian.PutUint32(buf[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(packetLen))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(packetLen))
+
+	// IPv4 header
+	ip := buf[16:36]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(packetLen))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64
+	ip[9] = 6 // TCP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+	binary.BigEndian.PutUint16(ip[10:], ipv4Checksum(ip))
+
+	// TCP header, the checksum is not computed
+	tcp := buf[36:56]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], cc.seq[direction])
+	binary.BigEndian.PutUint32(tcp[8:], cc.seq[1-direction])
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff)
+
+	copy(buf[56:], segment)
+	return buf
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
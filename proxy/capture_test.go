@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureFilter(t *testing.T) {
+	a := assert.New(t)
+
+	filter := &CaptureFilter{}
+	a.True(filter.matches("10.0.0.5", "kafka-0:9092", 0))
+	a.False(filter.matches("10.0.0.5", "kafka-0:9092", apiKeySaslHandshake))
+	a.False(filter.matches("10.0.0.5", "kafka-0:9092", apiKeySaslAuthenticate))
+
+	filter = &CaptureFilter{ApiKeys: []int16{0, 1}, Clients: []string{"10.0.0.5"}, Brokers: []string{"kafka-0:9092"}}
+	a.True(filter.matches("10.0.0.5", "kafka-0:9092", 1))
+	a.False(filter.matches("10.0.0.5", "kafka-0:9092", 3))
+	a.False(filter.matches("10.0.0.6", "kafka-0:9092", 1))
+	a.False(filter.matches("10.0.0.5", "kafka-1:9092", 1))
+}
+
+func readCaptureFiles(a *assert.Assertions, dir string) [][]byte {
+	infos, err := ioutil.ReadDir(dir)
+	a.Nil(err)
+	files := make([][]byte, 0)
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		a.Nil(err)
+		files = append(files, data)
+	}
+	return files
+}
+
+// readCapturePackets returns the IPv4 packets of the pcap file
+func readCapturePackets(a *assert.Assertions, data []byte) [][]byte {
+	a.Equal(uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data[0:]))
+	a.Equal(uint32(pcapLinkTypeRaw), binary.LittleEndian.Uint32(data[20:]))
+	packets := make([][]byte, 0)
+	for data = data[24:]; len(data) != 0; {
+		length := int(binary.LittleEndian.Uint32(data[8:]))
+		packets = append(packets, data[16:16+length])
+		data = data[16+length:]
+	}
+	return packets
+}
+
+func TestFrameCapture(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	capture, err := NewFrameCapture(dir)
+	a.Nil(err)
+	cc := capture.connection("10.0.0.5:51000", "kafka-0:9092")
+	a.False(cc.enabled(3))
+
+	capture.Enable(CaptureFilter{ApiKeys: []int16{3}})
+	a.True(cc.enabled(3))
+	a.False(cc.enabled(0))
+
+	request := []byte{0, 0, 0, 4, 0, 3, 0, 1}
+	response := []byte{0, 0, 0, 6, 0, 0, 0, 7, 1, 2}
+	cc.write(captureRequest, request[:4], request[4:])
+	cc.write(captureResponse, response)
+	cc.write(captureResponse, make([]byte, maxCaptureSegment+10))
+	cc.close()
+	cc.write(captureRequest, request)
+
+	files := readCaptureFiles(a, dir)
+	a.Len(files, 1)
+	packets := readCapturePackets(a, files[0])
+	a.Len(packets, 4)
+
+	ip, tcp := packets[0][:20], packets[0][20:40]
+	a.Equal(uint16(0), ipv4Checksum(ip))
+	a.Equal(net.IPv4(10, 0, 0, 5).To4(), net.IP(ip[12:16]))
+	a.Equal(net.IPv4(127, 0, 0, 2).To4(), net.IP(ip[16:20]))
+	a.Equal(uint16(51000), binary.BigEndian.Uint16(tcp[0:]))
+	a.Equal(uint16(9092), binary.BigEndian.Uint16(tcp[2:]))
+	a.Equal(request, packets[0][40:])
+
+	ip, tcp = packets[1][:20], packets[1][20:40]
+	a.Equal(net.IPv4(127, 0, 0, 2).To4(), net.IP(ip[12:16]))
+	a.Equal(uint16(9092), binary.BigEndian.Uint16(tcp[0:]))
+	a.Equal(uint32(0), binary.BigEndian.Uint32(tcp[4:]))
+	a.Equal(uint32(len(request)), binary.BigEndian.Uint32(tcp[8:]))
+	a.Equal(response, packets[1][40:])
+
+	// the large frames are split into segments
+	a.Len(packets[2], 65535)
+	a.Equal(uint32(len(response)+maxCaptureSegment), binary.BigEndian.Uint32(packets[3][24:]))
+	a.Len(packets[3][40:], 10)
+
+	// the files are closed when the capture is disabled, a new file is created when enabled again
+	cc = capture.connection("10.0.0.6:51000", "kafka-1:9092")
+	cc.write(captureRequest, request)
+	a.Len(capture.files, 1)
+	capture.Disable()
+	a.Len(capture.files, 0)
+	a.False(cc.enabled(3))
+	cc.write(captureRequest, request)
+	capture.Enable(CaptureFilter{})
+	cc.write(captureRequest, request)
+	a.Len(readCaptureFiles(a, dir), 3)
+
+	_, err = NewFrameCapture(filepath.Join(dir, "missing"))
+	a.NotNil(err)
+}
+
+func TestFrameCaptureHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	capture, err := NewFrameCapture(dir)
+	a.Nil(err)
+	server := httptest.NewServer(capture)
+	defer server.Close()
+
+	do := func(method string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		a.Nil(err)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	status, body := do(http.MethodGet, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+
+	status, body = do(http.MethodPost, `{"api_keys":[0,1],"brokers":["kafka-0:9092"]}`)
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":true,"filter":{"api_keys":[0,1],"clients":null,"brokers":["kafka-0:9092"]}}`, body)
+	a.Equal([]int16{0, 1}, capture.Filter().ApiKeys)
+
+	status, _ = do(http.MethodPut, `{"api_keys":"all"}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPatch, "")
+	a.Equal(http.StatusMethodNotAllowed, status)
+
+	status, body = do(http.MethodDelete, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+	a.Nil(capture.Filter())
+}
+
+func TestDefaultHandlersFrameCapture(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	capture, err := NewFrameCapture(dir)
+	a.Nil(err)
+	capture.Enable(CaptureFilter{})
+	cc := capture.connection("10.0.0.5:51000", "kafka-0:9092")
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		capture:                    cc,
+	}
+	go func() {
+		client.Write(testMetadataRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err = defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(testMetadataRequest, <-received)
+	<-openRequestsChannel
+
+	// response of an api without the response modifier
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 18, ApiVersion: 0}
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		buf:                 make([]byte, 16),
+		capture:             cc,
+	}
+	response := []byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}
+	go func() {
+		brokerSide.Write(response)
+		brokerSide.Close()
+	}()
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	proxyClientSide.Close()
+	a.Equal(response, <-received)
+	cc.close()
+
+	files := readCaptureFiles(a, dir)
+	a.Len(files, 1)
+	packets := readCapturePackets(a, files[0])
+	a.Len(packets, 2)
+	a.Equal(testMetadataRequest, packets[0][40:])
+	a.Equal(response, packets[1][40:])
+}
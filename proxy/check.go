@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// kinds of the checked addresses
+const (
+	CheckListener = "listener"
+	CheckBroker   = "broker"
+)
+
+// CheckResult is the result of the check of a listener or a broker address
+type CheckResult struct {
+	Kind    string
+	Address string
+	// upstream cluster of the broker
+	Cluster string
+	// failed stage of the broker connection setup: tcp_dial, tls_handshake, gateway_auth, sasl or cluster_id
+	Stage    string
+	Duration time.Duration
+	Err      error
+}
+
+// Check validates the connectivity of the configuration without serving the clients, e.g. in CI and pre-deploy checks.
+// The listener addresses are bound and closed, every configured broker of the upstream clusters and the shadow cluster is dialed
+// and authenticated (TLS, gateway and SASL) like the broker connections of the clients. The brokers are dialed with the global
+// gateway client settings. The server must not be started.
+func (s *Server) Check() ([]CheckResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.client != nil {
+		return nil, errors.New("server was already started")
+	}
+	netAddressMappingFunc := func(brokerHost string, brokerPort int32) (string, int32, error) {
+		return "", 0, errors.New("net address mapping is not available in the check")
+	}
+	client, err := NewClient(NewConnSet(), s.cfg, netAddressMappingFunc, s.opts.passwordAuthenticator, s.opts.tokenProvider, s.opts.tokenInfo, s.opts.requestAuthorizer, s.opts.frameFilter, s.opts.keyManagementService)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	results := make([]CheckResult, 0)
+	for _, listenerAddress := range checkListenerAddresses(s.cfg) {
+		results = append(results, checkListener(listenerAddress))
+	}
+	defaultBrokers := make([]string, 0)
+	for _, v := range append(append(append([]config.ListenerConfig{}, s.cfg.Proxy.BootstrapServers...), s.cfg.Proxy.ServerMapping.BootstrapServers...), s.cfg.Proxy.ExternalServers...) {
+		defaultBrokers = appendAddress(defaultBrokers, v.BrokerAddress)
+	}
+	for _, brokerAddress := range defaultBrokers {
+		results = append(results, checkBroker(brokerAddress, config.DefaultClusterName, client.DialAndAuth))
+	}
+	if routing := client.processorConfig.ClusterRouting; routing != nil {
+		for i, cluster := range routing.clusters[1:] {
+			upstream := client.upstreams[i+1]
+			for _, brokerAddress := range cluster.bootstrapServers {
+				results = append(results, checkBroker(brokerAddress, cluster.name, func(brokerAddress string) (net.Conn, error) {
+					return client.dialUpstream(upstream, brokerAddress, upstream.saslAuth, client.authClient)
+				}))
+			}
+		}
+	}
+	if s.cfg.Kafka.Shadow.Cluster != "" {
+		// the shadow upstream is the last upstream
+		upstream := client.upstreams[len(client.upstreams)-1]
+		pair := strings.SplitN(s.cfg.Kafka.Shadow.Cluster, "=", 2)
+		for _, brokerAddress := range strings.Split(pair[1], ",") {
+			results = append(results, checkBroker(brokerAddress, pair[0], func(brokerAddress string) (net.Conn, error) {
+				return client.dialUpstream(upstream, brokerAddress, upstream.saslAuth, client.authClient)
+			}))
+		}
+	}
+	return results, nil
+}
+
+// checkListenerAddresses returns the addresses of the listeners started by the server, the dynamic listeners are not known in advance
+func checkListenerAddresses(c *config.Config) []string {
+	addresses := make([]string, 0)
+	for _, v := range append(append([]config.ListenerConfig{}, c.Proxy.BootstrapServers...), c.Proxy.ServerMapping.BootstrapServers...) {
+		addresses = appendAddress(addresses, v.ListenerAddress)
+	}
+	if !c.Http.Disable {
+		addresses = appendAddress(addresses, c.Http.ListenAddress)
+	}
+	if c.Tunnel.Server.ListenAddress != "" {
+		addresses = appendAddress(addresses, c.Tunnel.Server.ListenAddress)
+	}
+	return addresses
+}
+
+func appendAddress(addresses []string, address string) []string {
+	for _, a := range addresses {
+		if a == address {
+			return addresses
+		}
+	}
+	return append(addresses, address)
+}
+
+func checkListener(listenerAddress string) CheckResult {
+	result := CheckResult{Kind: CheckListener, Address: listenerAddress}
+	start := time.Now()
+	listener, err := net.Listen("tcp", listenerAddress)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	listener.Close()
+	return result
+}
+
+func checkBroker(brokerAddress string, cluster string, dial func(brokerAddress string) (net.Conn, error)) CheckResult {
+	result := CheckResult{Kind: CheckBroker, Address: brokerAddress, Cluster: cluster}
+	start := time.Now()
+	conn, err := dial(brokerAddress)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Stage = dialStage(err)
+		result.Err = err
+		return result
+	}
+	conn.Close()
+	return result
+}
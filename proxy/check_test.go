@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestServerCheck(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer broker.Close()
+	go func() {
+		for {
+			conn, err := broker.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closedBroker, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	closedBroker.Close()
+
+	c := config.NewConfig()
+	c.Http.Disable = true
+	c.Proxy.BootstrapServers = []config.ListenerConfig{
+		{BrokerAddress: broker.Addr().String(), ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "127.0.0.1:32400"},
+		// the port of the broker is in use
+		{BrokerAddress: closedBroker.Addr().String(), ListenerAddress: broker.Addr().String(), AdvertisedAddress: "127.0.0.1:32401"},
+	}
+	s, err := New(c, WithRegisterer(prometheus.NewRegistry()))
+	a.Nil(err)
+
+	results, err := s.Check()
+	a.Nil(err)
+	a.Len(results, 4)
+	a.Equal(CheckListener, results[0].Kind)
+	a.Equal("127.0.0.1:0", results[0].Address)
+	a.Nil(results[0].Err)
+	a.Equal(CheckListener, results[1].Kind)
+	a.NotNil(results[1].Err)
+
+	a.Equal(broker.Addr().String(), results[2].Address)
+	a.Equal(config.DefaultClusterName, results[2].Cluster)
+	a.Nil(results[2].Err)
+	a.Equal(closedBroker.Addr().String(), results[3].Address)
+	a.Equal(dialStageTCP, results[3].Stage)
+	a.NotNil(results[3].Err)
+
+	c.Proxy.BootstrapServers = c.Proxy.BootstrapServers[:1]
+	s, err = New(c, WithRegisterer(prometheus.NewRegistry()))
+	a.Nil(err)
+	a.Nil(s.Start(context.Background()))
+	defer s.Close()
+	_, err = s.Check()
+	a.EqualError(err, "server was already started")
+}
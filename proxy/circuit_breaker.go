@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitOpenError is returned for the connections to a broker whose circuit is open
+type circuitOpenError struct {
+	brokerAddress string
+	until         time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of broker %s is open until %s", e.brokerAddress, e.until.Format(time.RFC3339))
+}
+
+type brokerCircuit struct {
+	failures  int
+	openUntil time.Time
+	// a dial is trying whether the broker is reachable again (half-open)
+	probing bool
+}
+
+// circuitBreaker fails the connections to a broker fast after its dials failed failureThreshold times in a row.
+// The circuit is open for the backoff, then a single dial decides whether the circuit is closed or opened again.
+// Only the failures of the TCP dial and the TLS handshake are counted, the broker is reachable when the authentication fails.
+type circuitBreaker struct {
+	failureThreshold int
+	backoff          time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*brokerCircuit // by broker address
+}
+
+func newCircuitBreaker(failureThreshold int, backoff time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		backoff:          backoff,
+		circuits:         make(map[string]*brokerCircuit),
+	}
+}
+
+// allow returns the error when the connection to the broker must fail fast, otherwise the connection is reported by done
+func (b *circuitBreaker) allow(brokerAddress string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit, ok := b.circuits[brokerAddress]
+	if !ok || circuit.failures < b.failureThreshold {
+		return nil
+	}
+	if circuit.probing || time.Now().Before(circuit.openUntil) {
+		proxyUpstreamCircuitRejectionsTotal.WithLabelValues(brokerAddress).Inc()
+		return &circuitOpenError{brokerAddress: brokerAddress, until: circuit.openUntil}
+	}
+	circuit.probing = true
+	return nil
+}
+
+// done records the result of the connection to the broker
+func (b *circuitBreaker) done(brokerAddress string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit, ok := b.circuits[brokerAddress]
+	if err == nil || !brokerUnreachable(err) {
+		if ok {
+			if circuit.failures >= b.failureThreshold {
+				logger.Infof("Circuit of broker %s is closed", brokerAddress)
+				proxyUpstreamCircuitOpen.WithLabelValues(brokerAddress).Set(0)
+			}
+			delete(b.circuits, brokerAddress)
+		}
+		return
+	}
+	if !ok {
+		circuit = &brokerCircuit{}
+		b.circuits[brokerAddress] = circuit
+	}
+	circuit.failures++
+	circuit.probing = false
+	if circuit.failures >= b.failureThreshold {
+		circuit.openUntil = time.Now().Add(b.backoff)
+		logger.Warnf("Circuit of broker %s is open for %v after %d failed connections: %v", brokerAddress, b.backoff, circuit.failures, err)
+		proxyUpstreamCircuitOpen.WithLabelValues(brokerAddress).Set(1)
+	}
+}
+
+// brokerUnreachable returns true for the failures which show that the broker is not reachable
+func brokerUnreachable(err error) bool {
+	switch dialStage(err) {
+	case dialStageTCP, dialStageTLS:
+		return true
+	}
+	return false
+}
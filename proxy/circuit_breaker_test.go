@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	broker := "circuit-0:9092"
+	b := newCircuitBreaker(2, 50*time.Millisecond)
+	dialErr := errors.New("connection refused")
+	rejections := testCounterValue(a, proxyUpstreamCircuitRejectionsTotal.WithLabelValues(broker))
+
+	a.Nil(b.allow(broker))
+	b.done(broker, dialErr)
+	a.Nil(b.allow(broker))
+	// the broker is reachable when the authentication fails
+	b.done(broker, &dialStageError{stage: dialStageSASL, err: errors.New("invalid credentials")})
+	a.Nil(b.allow(broker))
+	b.done(broker, dialErr)
+	a.Nil(b.allow(broker))
+	b.done(broker, &dialStageError{stage: dialStageTLS, err: errors.New("handshake timeout")})
+
+	err := b.allow(broker)
+	a.IsType(&circuitOpenError{}, err)
+	a.Equal(rejections+1, testCounterValue(a, proxyUpstreamCircuitRejectionsTotal.WithLabelValues(broker)))
+	a.Nil(b.allow("circuit-1:9092"))
+
+	// half-open: a single dial after the backoff
+	time.Sleep(60 * time.Millisecond)
+	a.Nil(b.allow(broker))
+	a.NotNil(b.allow(broker))
+	b.done(broker, dialErr)
+	a.NotNil(b.allow(broker))
+
+	time.Sleep(60 * time.Millisecond)
+	a.Nil(b.allow(broker))
+	b.done(broker, nil)
+	a.Nil(b.allow(broker))
+	a.Nil(b.allow(broker))
+	a.Empty(b.circuits)
+}
+
+func TestDialUpstreamCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	brokerAddress := listener.Addr().String()
+	listener.Close()
+
+	client := &Client{circuitBreaker: newCircuitBreaker(2, time.Minute)}
+	u := &upstream{dialer: directDialer{dialTimeout: time.Second}}
+	for i := 0; i < 2; i++ {
+		_, err = client.dialUpstream(u, brokerAddress, nil, &AuthClient{})
+		a.NotNil(err)
+		a.Equal(dialStageTCP, dialStage(err))
+	}
+	_, err = client.dialUpstream(u, brokerAddress, nil, &AuthClient{})
+	a.IsType(&circuitOpenError{}, err)
+	a.Equal(float64(2), testCounterValue(a, proxyUpstreamConnectErrorsTotal.WithLabelValues(brokerAddress, dialStageTCP)))
+}
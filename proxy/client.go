@@ -2,18 +2,23 @@ package proxy
 
 import (
 	"crypto/tls"
+	"github.com/cenkalti/backoff"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/kms"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Conn represents a connection from a client to a specific instance.
 type Conn struct {
-	BrokerAddress   string
+	BrokerAddress string
+	// configured address of the listener which accepted the connection, the auth settings of the listener are applied
+	ListenerAddress string
 	LocalConnection net.Conn
 }
 
@@ -28,17 +33,49 @@ type Client struct {
 	// Config of Proxy request-response processor (instance p)
 	processorConfig ProcessorConfig
 
-	dialer         Dialer
+	// the first upstream is the default cluster
+	upstreams      []*upstream
 	tcpConnOptions TCPConnOptions
 
 	stopRun  chan struct{}
 	stopOnce sync.Once
 
-	saslPlainAuth *SASLPlainAuth
-	authClient    *AuthClient
+	authClient *AuthClient
+	// auth of the listeners with auth settings
+	listenerAuths map[string]*listenerAuth
+	// listeners whose Metadata and FindCoordinator responses are not rewritten
+	transparentListeners map[string]bool
+
+	// fails the connections to the unreachable brokers fast, nil when disabled
+	circuitBreaker *circuitBreaker
+	// rejects the connections to the brokers taken down for maintenance, nil when disabled
+	brokerDrains *BrokerDrains
+	// rejects all new connections during planned work, nil when disabled
+	maintenance *Maintenance
+	// authenticated connections to the configured brokers, nil when disabled
+	brokerPool *brokerPool
+	// moves the clients of the brokers whose address changed, nil when disabled
+	brokerMigrations *brokerMigrations
+	// partition leaders of the Metadata responses, nil when disabled
+	partitionLeaders *PartitionLeaders
+	// produces the audit events to the audit topic, nil when disabled
+	auditTopic       *AuditTopic
+	removeAuditTopic func()
+}
+
+// upstream connects to the brokers of an upstream cluster
+type upstream struct {
+	config   *config.Config
+	dialer   Dialer
+	saslAuth saslAuthenticator
+	// obtains the delegation token used by saslAuth with the credentials of the principal
+	tokenProvider *DelegationTokenProvider
+	// incremented when the watched SASL credentials change, nil when the credentials are not watched
+	generation *credentialsGeneration
+	done       chan bool
 }
 
-func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.NetAddressMappingFunc, passwordAuthenticator apis.PasswordAuthenticator, tokenProvider apis.TokenProvider, tokenInfo apis.TokenInfo) (*Client, error) {
+func newUpstream(c *config.Config) (*upstream, error) {
 	tlsConfig, err := newTLSClientConfig(c)
 	if err != nil {
 		return nil, err
@@ -47,101 +84,443 @@ func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.Ne
 	if err != nil {
 		return nil, err
 	}
+	saslAuth, err := newSASLAuthenticator(c)
+	if err != nil {
+		return nil, err
+	}
+	u := &upstream{
+		config:   c,
+		dialer:   dialer,
+		saslAuth: saslAuth,
+	}
+	if c.CredentialsWatch.Enable {
+		if err = u.watchCredentials(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func newSASLAuthenticator(c *config.Config) (saslAuthenticator, error) {
+	// the credentials are fetched from Vault or the cloud secret managers at startup, the connections are authenticated with the current credentials
+	var credentialsProvider saslCredentialsProvider
+	if c.Kafka.SASL.Enable && c.Kafka.SASL.VaultPath != "" {
+		credentials, err := newVaultCredentials(c, c.Kafka.SASL.VaultPath)
+		if err != nil {
+			return nil, err
+		}
+		credentialsProvider = credentials
+	} else if c.Kafka.SASL.Enable && (kms.IsSecretReference(c.Kafka.SASL.Username) || kms.IsSecretReference(c.Kafka.SASL.Password.Value())) {
+		credentials, err := newSecretCredentials(c, c.Kafka.SASL.Username, c.Kafka.SASL.Password.Value())
+		if err != nil {
+			return nil, err
+		}
+		credentialsProvider = credentials
+	} else if c.Kafka.SASL.Enable && c.CredentialsWatch.Enable && c.Kafka.SASL.JaasConfigFile != "" {
+		credentials, err := newJaasFileCredentials(c.Kafka.SASL.JaasConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		credentialsProvider = credentials
+	}
+	if c.Kafka.SASL.Mechanism == SASLSCRAMSHA256 || c.Kafka.SASL.Mechanism == SASLSCRAMSHA512 {
+		return &SASLSCRAMAuth{
+			mechanism:           c.Kafka.SASL.Mechanism,
+			username:            c.Kafka.SASL.Username,
+			password:            c.Kafka.SASL.Password.Value(),
+			tokenAuth:           c.Kafka.SASL.TokenAuth,
+			credentialsProvider: credentialsProvider,
+		}, nil
+	}
+	return &SASLPlainAuth{
+		username:            c.Kafka.SASL.Username,
+		password:            c.Kafka.SASL.Password.Value(),
+		credentialsProvider: credentialsProvider,
+	}, nil
+}
+
+// enableDelegationToken authenticates the connections of the upstream with a delegation token,
+// the token is obtained and renewed with the credentials of the principal
+func (c *Client) enableDelegationToken(u *upstream, bootstrapServers []string) {
+	credentialsAuth := u.saslAuth
+	dial := func(brokerAddress string) (net.Conn, error) {
+		return c.dialUpstream(u, brokerAddress, credentialsAuth, c.authClient)
+	}
+	delegationToken := u.config.Kafka.SASL.DelegationToken
+	u.tokenProvider = NewDelegationTokenProvider(dial, bootstrapServers, u.config.Kafka.ClientID, delegationToken.MaxLifetime, delegationToken.RenewInterval, u.config.Kafka.ReadTimeout)
+	u.saslAuth = &SASLSCRAMAuth{
+		mechanism:     delegationToken.Mechanism,
+		tokenProvider: u.tokenProvider,
+	}
+	go withRecover(u.tokenProvider.Run)
+}
+
+func NewClient(conns *ConnSet, c *config.Config, netAddressMappingFunc config.NetAddressMappingFunc, passwordAuthenticator apis.PasswordAuthenticator, tokenProvider apis.TokenProvider, tokenInfo apis.TokenInfo, requestAuthorizer apis.RequestAuthorizer, frameFilter apis.FrameFilter, keyManagementService apis.KeyManagementService) (*Client, error) {
+	defaultConfig, err := c.ClusterConfig(config.DefaultClusterName)
+	if err != nil {
+		return nil, err
+	}
+	defaultUpstream, err := newUpstream(defaultConfig)
+	if err != nil {
+		return nil, err
+	}
 	tcpConnOptions := TCPConnOptions{
-		KeepAlive:       c.Kafka.KeepAlive,
-		WriteBufferSize: c.Kafka.ConnectionWriteBufferSize,
-		ReadBufferSize:  c.Kafka.ConnectionReadBufferSize,
+		KeepAlive:         c.Kafka.KeepAlive,
+		KeepAliveInterval: c.Kafka.KeepAliveInterval,
+		KeepAliveCount:    c.Kafka.KeepAliveCount,
+		NoDelay:           c.Kafka.NoDelay,
+		WriteBufferSize:   c.Kafka.ConnectionWriteBufferSize,
+		ReadBufferSize:    c.Kafka.ConnectionReadBufferSize,
 	}
 
 	forbiddenApiKeys := make(map[int16]struct{})
 	if len(c.Kafka.ForbiddenApiKeys) != 0 {
-		logrus.Warnf("Kafka operations for Api Keys %v will be forbidden.", c.Kafka.ForbiddenApiKeys)
+		logger.Warnf("Kafka operations for Api Keys %v will be forbidden.", c.Kafka.ForbiddenApiKeys)
 		for _, apiKey := range c.Kafka.ForbiddenApiKeys {
 			forbiddenApiKeys[int16(apiKey)] = struct{}{}
 		}
 	}
-	if c.Auth.Local.Enable && passwordAuthenticator == nil {
-		return nil, errors.New("Auth.Local.Enable is enabled but passwordAuthenticator is nil")
+	defaultAuth, err := newListenerAuth(c, passwordAuthenticator, tokenProvider, tokenInfo, requestAuthorizer)
+	if err != nil {
+		return nil, err
 	}
-
-	if c.Auth.Gateway.Client.Enable && tokenProvider == nil {
-		return nil, errors.New("Auth.Gateway.Client.Enable is enabled but tokenProvider is nil")
+	listenerAuths := make(map[string]*listenerAuth)
+	for _, listenerAddress := range c.AuthListeners() {
+		listenerConfig, err := c.ListenerAuthConfig(listenerAddress)
+		if err != nil {
+			return nil, err
+		}
+		if listenerAuths[listenerAddress], err = newListenerAuth(listenerConfig, passwordAuthenticator, tokenProvider, tokenInfo, requestAuthorizer); err != nil {
+			return nil, errors.Wrapf(err, "listener %s", listenerAddress)
+		}
+		logger.Infof("Listener %s uses its own auth settings", listenerAddress)
+	}
+	transparentListeners := make(map[string]bool)
+	for _, listenerAddress := range c.Proxy.TransparentListeners {
+		transparentListeners[listenerAddress] = true
+		logger.Infof("Listener %s is transparent, its responses are not rewritten", listenerAddress)
+	}
+	frameFilters := &FrameFilters{}
+	if c.Proxy.Filter.Enable {
+		if frameFilter == nil {
+			return nil, errors.New("Proxy.Filter.Enable is enabled but frameFilter is nil")
+		}
+		frameFilters.filters = append(frameFilters.filters, frameFilter)
+	}
+	if c.Auth.Local.Enable && c.Auth.Local.SessionLifetime > 0 {
+		// clients must use SaslAuthenticate v1 which returns the session lifetime, the flexible versions are not supported
+		frameFilters.filters = append(frameFilters.filters, &apiVersionsLimit{maxVersions: localSaslMaxVersions})
+	}
+	recordHeaders, err := NewRecordHeaders(c.Proxy.RecordHeaders, c.Proxy.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	if recordHeaders.enabled() {
+		// clients must not use Produce versions whose record sets cannot be modified
+		frameFilters.filters = append(frameFilters.filters, &apiVersionsLimit{maxVersions: protocol.MaxRecordSetsVersions})
 	}
-	if c.Auth.Gateway.Server.Enable && tokenInfo == nil {
-		return nil, errors.New("Auth.Gateway.Server.Enable is enabled but tokenInfo is nil")
+	var requestPolicies *RequestPolicies
+	if len(c.Policy.Roles) != 0 {
+		if requestPolicies, err = NewRequestPolicies(c.Policy.Roles, c.Policy.Topics, c.Policy.Principals, c.Policy.DefaultRole, c.Policy.ClientCertPrincipal); err != nil {
+			return nil, err
+		}
+	}
+	var topicPrefixes *TopicPrefixes
+	if c.Proxy.TopicPrefix.Default != "" || len(c.Proxy.TopicPrefix.Principals) != 0 {
+		if topicPrefixes, err = NewTopicPrefixes(c.Proxy.TopicPrefix.Default, c.Proxy.TopicPrefix.Principals, c.Proxy.TopicPrefix.Groups); err != nil {
+			return nil, err
+		}
+		// clients must not use versions whose topic names cannot be prefixed
+		frameFilters.filters = append(frameFilters.filters, &apiVersionsLimit{maxVersions: topicPrefixes.maxVersions()})
+	}
+	if limit := c.Auth.Local.ConnectionLimit; limit.PerPrincipal != 0 || len(limit.Principals) != 0 || limit.PerTenant != 0 {
+		connectionLimits, err := NewConnectionLimits(limit.PerPrincipal, limit.Principals, limit.PerTenant, topicPrefixes)
+		if err != nil {
+			return nil, err
+		}
+		// the connections are counted across the listeners
+		defaultAuth.localSasl.connectionLimits = connectionLimits
+		for _, auth := range listenerAuths {
+			auth.localSasl.connectionLimits = connectionLimits
+		}
+	}
+	var readOnly *ReadOnly
+	if c.Proxy.ReadOnly.Enable {
+		readOnly = NewReadOnly(c.Proxy.ReadOnly.AllowOffsetCommit)
+		// clients must not use versions of the rejected APIs whose error responses cannot be encoded
+		frameFilters.filters = append(frameFilters.filters, &apiVersionsLimit{maxVersions: readOnly.maxVersions()})
+		logger.Warnf("Read-only mode is enabled, the requests changing the cluster will be rejected")
+	}
+	var clientTelemetry *ClientTelemetry
+	if c.Proxy.ClientTelemetry.Terminate {
+		clientTelemetry = NewClientTelemetry(c.Proxy.ClientTelemetry.PushInterval, c.Proxy.ClientTelemetry.MaxBytes, c.Proxy.ClientTelemetry.Metrics)
+		// the clients send the telemetry requests only when they are announced, the proxy answers them also without the brokers support
+		frameFilters.filters = append(frameFilters.filters, &apiVersionsAdd{maxVersions: protocol.ClientTelemetryVersions})
+		logger.Infof("Client telemetry is answered by the proxy, the clients push their metrics every %v", c.Proxy.ClientTelemetry.PushInterval)
+	}
+	if c.Http.MetricsTopics.Enable {
+		// the record sets are counted as sent by the clients
+		frameFilters.filters = append(frameFilters.filters, NewTopicMetrics(c.Http.MetricsTopics.Topics))
+	}
+	var partitionLeaders *PartitionLeaders
+	if c.Http.PartitionLeaders.Enable {
+		// the responses are observed with the advertised broker addresses
+		partitionLeaders = NewPartitionLeaders()
+		frameFilters.filters = append(frameFilters.filters, partitionLeaders)
+	}
+	if c.SchemaRegistry.Enable {
+		schemaValidation, err := NewSchemaValidation(SchemaRegistryOptions{
+			Url:          c.SchemaRegistry.Url,
+			Username:     c.SchemaRegistry.Username,
+			Password:     c.SchemaRegistry.Password.Value(),
+			Topics:       c.SchemaRegistry.Topics,
+			ValidateKeys: c.SchemaRegistry.ValidateKeys,
+			CacheTTL:     c.SchemaRegistry.CacheTTL,
+			Timeout:      c.SchemaRegistry.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// records are validated before encryption
+		frameFilters.filters = append(frameFilters.filters, schemaValidation)
+	}
+	if c.Encryption.Enable {
+		if keyManagementService == nil {
+			return nil, errors.New("Encryption.Enable is enabled but keyManagementService is nil")
+		}
+		recordEncryption, err := NewRecordEncryption(keyManagementService, c.Encryption.TopicKeys, c.Encryption.DataKeyTTL, c.Encryption.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		// the filters see the plaintext of the requests and the responses
+		frameFilters.filters = append(frameFilters.filters, recordEncryption)
+	}
+	if c.Proxy.CompressionTranscoding.Produce != "" || c.Proxy.CompressionTranscoding.Fetch != "" {
+		compressionTranscoding, err := NewCompressionTranscoding(c.Proxy.CompressionTranscoding.Produce, c.Proxy.CompressionTranscoding.Fetch)
+		if err != nil {
+			return nil, err
+		}
+		// the requests are compressed after and the responses are decompressed before the other filters
+		frameFilters.filters = append(frameFilters.filters, compressionTranscoding)
 	}
 
-	return &Client{conns: conns, config: c, dialer: dialer, tcpConnOptions: tcpConnOptions, stopRun: make(chan struct{}, 1),
-		saslPlainAuth: &SASLPlainAuth{
-			clientID:     c.Kafka.ClientID,
-			writeTimeout: c.Kafka.WriteTimeout,
-			readTimeout:  c.Kafka.ReadTimeout,
-			username:     c.Kafka.SASL.Username,
-			password:     c.Kafka.SASL.Password,
-		},
-		authClient: &AuthClient{
-			enabled:       c.Auth.Gateway.Client.Enable,
-			magic:         c.Auth.Gateway.Client.Magic,
-			method:        c.Auth.Gateway.Client.Method,
-			timeout:       c.Auth.Gateway.Client.Timeout,
-			tokenProvider: tokenProvider,
-		},
+	advertisedHostFunc, err := newAdvertisedHostFunc(c.Proxy.AdvertisedHostTemplate)
+	if err != nil {
+		return nil, err
+	}
+	tuning := NewProcessorTuning(TuningSettings{
+		MaxOpenRequests:    c.Kafka.MaxOpenRequests,
+		RequestBufferSize:  c.Proxy.RequestBufferSize,
+		ResponseBufferSize: c.Proxy.ResponseBufferSize,
+	})
+	client := &Client{conns: conns, config: c, upstreams: []*upstream{defaultUpstream}, tcpConnOptions: tcpConnOptions, stopRun: make(chan struct{}, 1),
+		authClient:           defaultAuth.authClient,
+		listenerAuths:        listenerAuths,
+		transparentListeners: transparentListeners,
+		partitionLeaders:     partitionLeaders,
 		processorConfig: ProcessorConfig{
-			MaxOpenRequests:       c.Kafka.MaxOpenRequests,
 			NetAddressMappingFunc: netAddressMappingFunc,
-			RequestBufferSize:     c.Proxy.RequestBufferSize,
-			ResponseBufferSize:    c.Proxy.ResponseBufferSize,
+			AdvertisedHostFunc:    advertisedHostFunc,
+			Tuning:                tuning,
 			ReadTimeout:           c.Kafka.ReadTimeout,
 			WriteTimeout:          c.Kafka.WriteTimeout,
-			LocalSasl: &LocalSasl{
-				enabled:            c.Auth.Local.Enable,
-				timeout:            c.Auth.Local.Timeout,
-				localAuthenticator: passwordAuthenticator},
-			AuthServer: &AuthServer{
-				enabled:   c.Auth.Gateway.Server.Enable,
-				magic:     c.Auth.Gateway.Server.Magic,
-				method:    c.Auth.Gateway.Server.Method,
-				timeout:   c.Auth.Gateway.Server.Timeout,
-				tokenInfo: tokenInfo,
-			},
-			ForbiddenApiKeys: forbiddenApiKeys,
-		}}, nil
+			RequestTimeout:        c.Kafka.RequestTimeout,
+			MaxFetchResponseSize:  c.Kafka.MaxFetchResponseSize,
+			LocalSasl:             defaultAuth.localSasl,
+			AuthServer:            defaultAuth.authServer,
+			RequestAuthz:          defaultAuth.requestAuthz,
+			RequestPolicies:       requestPolicies,
+			RecordHeaders:         recordHeaders,
+			FrameFilters:          frameFilters,
+			TopicPrefixes:         topicPrefixes,
+			ForbiddenApiKeys:      forbiddenApiKeys,
+			ResponseErrorMetrics:  c.Http.MetricsResponseErrors,
+			RequestLogSampleRate:  c.Log.RequestSampleRate,
+			ReadOnly:              readOnly,
+			ClientTelemetry:       clientTelemetry,
+		}}
+	if c.Debug.Capture.Dir != "" {
+		if client.processorConfig.FrameCapture, err = NewFrameCapture(c.Debug.Capture.Dir); err != nil {
+			return nil, err
+		}
+	}
+	if c.Debug.Faults.Enable {
+		client.processorConfig.FaultInjection = NewFaultInjection()
+	}
+	if c.Proxy.SlowConsumer.Threshold > 0 {
+		client.processorConfig.SlowConsumers = NewSlowConsumers(c.Proxy.SlowConsumer.Threshold, c.Proxy.SlowConsumer.Policy)
+	}
+	if c.Proxy.ClientQuota.ProduceByteRate > 0 || c.Proxy.ClientQuota.FetchByteRate > 0 || c.Proxy.ClientQuota.RequestRate > 0 {
+		client.processorConfig.ClientQuotas = NewClientQuotas(c.Proxy.ClientQuota.ProduceByteRate, c.Proxy.ClientQuota.FetchByteRate, c.Proxy.ClientQuota.RequestRate, c.Proxy.ClientQuota.Window, c.Proxy.ClientQuota.MaxThrottle)
+	}
+	if c.Http.ClientInventory.Enable {
+		client.processorConfig.ClientInventory = NewClientInventory()
+	}
+	if len(c.Proxy.OldClients.MinApiVersions) != 0 {
+		if client.processorConfig.OldClients, err = NewOldClients(c.Proxy.OldClients.MinApiVersions, c.Proxy.OldClients.Policy); err != nil {
+			return nil, err
+		}
+	}
+	if c.Proxy.MemoryBudget.MaxBytes > 0 {
+		client.processorConfig.MemoryBudget = NewMemoryBudget(c.Proxy.MemoryBudget.MaxBytes, c.Proxy.MemoryBudget.RejectConnections)
+	}
+	if c.Proxy.ResponseCache.Enable {
+		client.processorConfig.ResponseCache = NewResponseCache(c.Proxy.ResponseCache.TTL)
+	}
+	if c.Kafka.CircuitBreaker.Enable {
+		client.circuitBreaker = newCircuitBreaker(c.Kafka.CircuitBreaker.FailureThreshold, c.Kafka.CircuitBreaker.Backoff)
+	}
+	if c.Http.Drain.Enable {
+		client.brokerDrains = NewBrokerDrains(conns)
+	}
+	if c.Http.Maintenance.Enable {
+		client.maintenance = NewMaintenance(conns, c.Http.Maintenance.Timeout)
+	}
+	if c.Proxy.BrokerMigration.Enable {
+		client.brokerMigrations = newBrokerMigrations(conns, c.Proxy.BrokerMigration.IdleTimeout, c.Proxy.BrokerMigration.GracePeriod)
+		client.processorConfig.BrokerMigrations = client.brokerMigrations
+	}
+	// the processors set the principal, the client id and the bytes of the listed connections
+	client.processorConfig.Conns = conns
+	if c.Http.KillSwitch.Enable || len(c.Proxy.KillSwitch.ClientIDs) != 0 || len(c.Proxy.KillSwitch.Principals) != 0 {
+		if client.processorConfig.KillSwitch, err = NewKillSwitch(conns, KillSwitchPatterns{ClientIDs: c.Proxy.KillSwitch.ClientIDs, Principals: c.Proxy.KillSwitch.Principals}); err != nil {
+			return nil, err
+		}
+	}
+	bootstrapServers := make([]string, 0, len(c.Proxy.BootstrapServers)+len(c.Proxy.ServerMapping.BootstrapServers))
+	for _, v := range append(append([]config.ListenerConfig{}, c.Proxy.BootstrapServers...), c.Proxy.ServerMapping.BootstrapServers...) {
+		bootstrapServers = append(bootstrapServers, v.BrokerAddress)
+	}
+	if defaultConfig.Kafka.SASL.DelegationToken.Enable {
+		client.enableDelegationToken(defaultUpstream, bootstrapServers)
+	}
+	if c.Kafka.ConnectionPool.Size > 0 {
+		client.brokerPool = newBrokerPool(bootstrapServers, c.Kafka.ConnectionPool.Size, c.Kafka.ConnectionPool.PingInterval, c.Kafka.ReadTimeout, c.Kafka.ClientID, func(brokerAddress string) (net.Conn, error) {
+			return client.dialAndAuth(brokerAddress, client.authClient)
+		})
+	}
+	if len(c.Kafka.Clusters.Servers) != 0 {
+		// the requests to other clusters are authenticated as the forwarded connections
+		clusterRouting, err := NewClusterRouting(bootstrapServers, c.Kafka.Clusters.Servers, c.Kafka.Clusters.TopicRoutes, c.Kafka.Clusters.GroupRoutes, client.dialCluster, c.Kafka.ReadTimeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range clusterRouting.clusters[1:] {
+			clusterConfig, err := c.ClusterConfig(cluster.name)
+			if err != nil {
+				return nil, err
+			}
+			clusterUpstream, err := newUpstream(clusterConfig)
+			if err != nil {
+				return nil, err
+			}
+			if clusterConfig.Kafka.SASL.DelegationToken.Enable {
+				client.enableDelegationToken(clusterUpstream, cluster.bootstrapServers)
+			}
+			client.upstreams = append(client.upstreams, clusterUpstream)
+		}
+		client.processorConfig.ClusterRouting = clusterRouting
+	}
+	if c.Kafka.Shadow.Cluster != "" {
+		// the shadow upstream follows the upstreams of the clusters, its brokers are not routed to
+		name, shadowBootstrapServers, dial, err := client.clusterDial(c, c.Kafka.Shadow.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		produceShadow, err := NewProduceShadow(name, shadowBootstrapServers, c.Kafka.Shadow.Topics, c.Kafka.Shadow.QueueSize, dial, c.Kafka.ReadTimeout, c.Kafka.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		client.processorConfig.ProduceShadow = produceShadow
+		go withRecover(produceShadow.Run)
+	}
+	if c.Kafka.Canary.Cluster != "" {
+		// the canary upstream follows the shadow upstream, its brokers are not routed to
+		name, canaryBootstrapServers, dial, err := client.clusterDial(c, c.Kafka.Canary.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		clusterCanary, err := NewClusterCanary(name, canaryBootstrapServers, c.Kafka.Canary.SampleRate, c.Kafka.Canary.QueueSize, dial, c.Kafka.ReadTimeout, c.Kafka.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		client.processorConfig.ClusterCanary = clusterCanary
+		go withRecover(clusterCanary.Run)
+	}
+	if c.Events.Audit.Topic != "" {
+		// the audit events are produced to the default cluster as the proxy
+		dial := func(brokerAddress string) (net.Conn, error) {
+			return client.dialUpstream(defaultUpstream, brokerAddress, defaultUpstream.saslAuth, client.authClient)
+		}
+		audit := c.Events.Audit
+		auditTopic, err := NewAuditTopic(audit.Topic, bootstrapServers, audit.BatchSize, audit.FlushInterval, audit.QueueSize, dial, c.Kafka.ReadTimeout, c.Kafka.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		client.auditTopic = auditTopic
+		client.removeAuditTopic = AddEventHandler(auditTopic)
+		go withRecover(auditTopic.Run)
+	}
+	if c.Events.RequestMirror.SampleRate > 0 {
+		// the samples are produced to the default cluster as the proxy
+		dial := func(brokerAddress string) (net.Conn, error) {
+			return client.dialUpstream(defaultUpstream, brokerAddress, defaultUpstream.saslAuth, client.authClient)
+		}
+		requestMirror, err := NewRequestMirror(c, bootstrapServers, dial)
+		if err != nil {
+			return nil, err
+		}
+		client.processorConfig.RequestMirror = requestMirror
+		go withRecover(requestMirror.Run)
+	}
+	return client, nil
 }
 
 func newDialer(c *config.Config, tlsConfig *tls.Config) (Dialer, error) {
 	directDialer := directDialer{
-		dialTimeout: c.Kafka.DialTimeout,
-		keepAlive:   c.Kafka.KeepAlive,
+		dialTimeout:   c.Kafka.DialTimeout,
+		keepAlive:     c.Kafka.KeepAlive,
+		fallbackDelay: c.Kafka.DialFallbackDelay,
+		source:        sourceAddress(c.Kafka.DialSourceAddress),
+	}
+	if c.Kafka.DNS.TTL > 0 || c.Kafka.DNS.LookupOnDial {
+		directDialer.resolver = newDNSResolver(c.Kafka.DNS.TTL, c.Kafka.DNS.MaxStale, c.Kafka.DNS.LookupOnDial)
 	}
 
 	var rawDialer Dialer
 	if c.ForwardProxy.Url != "" {
-		switch c.ForwardProxy.Scheme {
-		case "socks5":
-			logrus.Infof("Kafka clients will connect through the SOCKS5 proxy %s", c.ForwardProxy.Address)
-			rawDialer = &socks5Dialer{
-				directDialer: directDialer,
-				proxyNetwork: "tcp",
-				proxyAddr:    c.ForwardProxy.Address,
-				username:     c.ForwardProxy.Username,
-				password:     c.ForwardProxy.Password,
+		forwardDialers := make([]forwardProxyDialer, 0, len(c.ForwardProxy.Proxies))
+		for _, forwardProxy := range c.ForwardProxy.Proxies {
+			forwardDialer, err := newForwardProxyDialer(c, directDialer, forwardProxy)
+			if err != nil {
+				return nil, err
 			}
-		case "http":
-			logrus.Infof("Kafka clients will connect through the HTTP proxy %s using CONNECT", c.ForwardProxy.Address)
-
-			rawDialer = &httpProxy{
-				forwardDialer: directDialer,
-				network:       "tcp",
-				hostPort:      c.ForwardProxy.Address,
-				username:      c.ForwardProxy.Username,
-				password:      c.ForwardProxy.Password,
+			healthCheckDialer, err := newForwardProxyHealthCheckDialer(c, directDialer, forwardProxy)
+			if err != nil {
+				return nil, err
 			}
+			forwardDialers = append(forwardDialers, forwardProxyDialer{address: forwardProxy.Address, dialer: forwardDialer, healthCheckDialer: healthCheckDialer})
+		}
+		switch len(forwardDialers) {
+		case 0:
+			return nil, errors.New("ForwardProxy.Url is set but no forward proxy was configured")
+		case 1:
+			rawDialer = forwardDialers[0].dialer
 		default:
-			return nil, errors.New("Only http or socks5 proxy is supported")
+			logger.Infof("Kafka clients will fail over between %d forward proxies", len(forwardDialers))
+			rawDialer = newFailoverDialer(forwardDialers, c.ForwardProxy.HealthCheckInterval)
 		}
 	} else {
 		rawDialer = directDialer
 	}
+	if c.Tunnel.Client.Address != "" {
+		tunnelDialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, rawDialer)
+		if err != nil {
+			closeDialer(rawDialer)
+			return nil, err
+		}
+		rawDialer = tunnelDialer
+	}
 	if c.Kafka.TLS.Enable {
 		if tlsConfig == nil {
 			return nil, errors.New("tlsConfig must not be nil")
@@ -156,9 +535,159 @@ func newDialer(c *config.Config, tlsConfig *tls.Config) (Dialer, error) {
 	return rawDialer, nil
 }
 
+func newForwardProxyDialer(c *config.Config, directDialer directDialer, forwardProxy config.ForwardProxyConfig) (Dialer, error) {
+	switch forwardProxy.Scheme {
+	case "socks5":
+		logger.Infof("Kafka clients will connect through the SOCKS5 proxy %s", forwardProxy.Address)
+		return &socks5Dialer{
+			directDialer: directDialer,
+			proxyNetwork: "tcp",
+			proxyAddr:    forwardProxy.Address,
+			username:     forwardProxy.Username,
+			password:     forwardProxy.Password.Value(),
+			localResolve: c.ForwardProxy.SOCKS5.Resolve == "local",
+		}, nil
+	case "http":
+		logger.Infof("Kafka clients will connect through the HTTP proxy %s using CONNECT", forwardProxy.Address)
+
+		kerberos, err := newForwardProxyKerberos(c, forwardProxy)
+		if err != nil {
+			return nil, err
+		}
+		return &httpProxy{
+			forwardDialer: directDialer,
+			network:       "tcp",
+			hostPort:      forwardProxy.Address,
+			username:      forwardProxy.Username,
+			password:      forwardProxy.Password.Value(),
+			auth:          c.ForwardProxy.HTTP.Auth,
+			kerberos:      kerberos,
+		}, nil
+	case "https":
+		logger.Infof("Kafka clients will connect through the HTTPS proxy %s using CONNECT", forwardProxy.Address)
+
+		forwardProxyTLSConfig, err := newForwardProxyTLSConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		kerberos, err := newForwardProxyKerberos(c, forwardProxy)
+		if err != nil {
+			return nil, err
+		}
+		return &httpProxy{
+			forwardDialer: tlsDialer{
+				timeout:   c.Kafka.DialTimeout,
+				rawDialer: directDialer,
+				config:    forwardProxyTLSConfig,
+			},
+			network:  "tcp",
+			hostPort: forwardProxy.Address,
+			username: forwardProxy.Username,
+			password: forwardProxy.Password.Value(),
+			auth:     c.ForwardProxy.HTTP.Auth,
+			kerberos: kerberos,
+		}, nil
+	case "ssh":
+		logger.Infof("Kafka clients will connect through the SSH tunnel %s", forwardProxy.Address)
+
+		return newSSHDialer(directDialer, "tcp", forwardProxy.Address, sshDialerOptions{
+			username:              forwardProxy.Username,
+			password:              forwardProxy.Password.Value(),
+			privateKeyFile:        c.ForwardProxy.SSH.PrivateKeyFile,
+			privateKeyPassword:    c.ForwardProxy.SSH.PrivateKeyPassword.Value(),
+			agentEnable:           c.ForwardProxy.SSH.AgentEnable,
+			knownHostsFile:        c.ForwardProxy.SSH.KnownHostsFile,
+			insecureIgnoreHostKey: c.ForwardProxy.SSH.InsecureIgnoreHostKey,
+			keepAliveInterval:     c.ForwardProxy.SSH.KeepAliveInterval,
+		})
+	default:
+		return nil, errors.New("Only http, https, socks5 or ssh proxy is supported")
+	}
+}
+
+func newForwardProxyHealthCheckDialer(c *config.Config, directDialer directDialer, forwardProxy config.ForwardProxyConfig) (Dialer, error) {
+	directDialer.dialTimeout = c.ForwardProxy.HealthCheckTimeout
+	if forwardProxy.Scheme != "https" {
+		return directDialer, nil
+	}
+	forwardProxyTLSConfig, err := newForwardProxyTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return tlsDialer{
+		timeout:   c.ForwardProxy.HealthCheckTimeout,
+		rawDialer: directDialer,
+		config:    forwardProxyTLSConfig,
+	}, nil
+}
+
 // Run causes the client to start waiting for new connections to connSrc and
 // proxy them to the destination instance. It blocks until connSrc is closed.
+// FrameCapture returns the frame capture, nil when it is not configured
+func (c *Client) FrameCapture() *FrameCapture {
+	return c.processorConfig.FrameCapture
+}
+
+// BrokerDrains returns the broker drains, nil when they are not enabled
+func (c *Client) BrokerDrains() *BrokerDrains {
+	return c.brokerDrains
+}
+
+// Maintenance returns the maintenance mode, nil when it is not enabled
+func (c *Client) Maintenance() *Maintenance {
+	return c.maintenance
+}
+
+// Connections returns the connection listing, nil when it is not enabled
+func (c *Client) Connections() *ConnSet {
+	if !c.config.Http.Connections.Enable {
+		return nil
+	}
+	return c.conns
+}
+
+// PartitionLeaders returns the partition leaders, nil when their endpoint is not enabled
+func (c *Client) PartitionLeaders() *PartitionLeaders {
+	return c.partitionLeaders
+}
+
+// ClientInventory returns the client inventory, nil when its endpoint is not enabled
+func (c *Client) ClientInventory() *ClientInventory {
+	return c.processorConfig.ClientInventory
+}
+
+// KillSwitch returns the kill switch, nil when its endpoint is not enabled
+func (c *Client) KillSwitch() *KillSwitch {
+	if !c.config.Http.KillSwitch.Enable {
+		return nil
+	}
+	return c.processorConfig.KillSwitch
+}
+
+// ConnTracing returns the tracing of the client connections, nil when its endpoint is not enabled
+func (c *Client) ConnTracing() *ConnTracing {
+	if !c.config.Http.Tracing.Enable {
+		return nil
+	}
+	return NewConnTracing(c.conns)
+}
+
+// ProcessorTuning returns the tuning of the processors, nil when the tuning endpoint is not enabled
+func (c *Client) ProcessorTuning() *ProcessorTuning {
+	if !c.config.Http.Tuning.Enable {
+		return nil
+	}
+	return c.processorConfig.Tuning
+}
+
+// FaultInjection returns the fault injection, nil when it is not enabled
+func (c *Client) FaultInjection() *FaultInjection {
+	return c.processorConfig.FaultInjection
+}
+
 func (c *Client) Run(connSrc <-chan Conn) error {
+	c.brokerPool.start()
+	c.brokerMigrations.start()
 STOP:
 	for {
 		select {
@@ -169,46 +698,200 @@ STOP:
 		}
 	}
 
-	logrus.Info("Closing connections")
+	logger.Infof("Closing connections")
 
 	if err := c.conns.Close(); err != nil {
-		logrus.Infof("closing client had error: %v", err)
+		logger.Infof("closing client had error: %v", err)
 	}
 
-	logrus.Info("Proxy is stopped")
+	logger.Infof("Proxy is stopped")
 	return nil
 }
 
+// clusterDial adds the upstream of the cluster given as name=host:port,host:port whose settings are given by Kafka.Clusters.Settings
+// and returns the dial function to its brokers
+func (c *Client) clusterDial(conf *config.Config, cluster string) (string, []string, func(brokerAddress string) (net.Conn, error), error) {
+	pair := strings.SplitN(cluster, "=", 2)
+	bootstrapServers := strings.Split(pair[1], ",")
+	clusterConfig, err := conf.ClusterConfig(pair[0])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	clusterUpstream, err := newUpstream(clusterConfig)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if clusterConfig.Kafka.SASL.DelegationToken.Enable {
+		c.enableDelegationToken(clusterUpstream, bootstrapServers)
+	}
+	c.upstreams = append(c.upstreams, clusterUpstream)
+	dial := func(brokerAddress string) (net.Conn, error) {
+		return c.dialUpstream(clusterUpstream, brokerAddress, clusterUpstream.saslAuth, c.authClient)
+	}
+	return pair[0], bootstrapServers, dial, nil
+}
+
 func (c *Client) Close() {
 	c.stopOnce.Do(func() {
 		close(c.stopRun)
+		c.brokerPool.close()
+		c.brokerMigrations.close()
+		if c.processorConfig.ProduceShadow != nil {
+			c.processorConfig.ProduceShadow.Close()
+		}
+		if c.processorConfig.ClusterCanary != nil {
+			c.processorConfig.ClusterCanary.Close()
+		}
+		if c.auditTopic != nil {
+			c.removeAuditTopic()
+			c.auditTopic.Close()
+		}
+		if c.processorConfig.RequestMirror != nil {
+			c.processorConfig.RequestMirror.Close()
+		}
+		for _, upstream := range c.upstreams {
+			if upstream.tokenProvider != nil {
+				upstream.tokenProvider.Close()
+			}
+			closeDialer(upstream.dialer)
+			if upstream.done != nil {
+				close(upstream.done)
+			}
+		}
 	})
 }
 
 func (c *Client) handleConn(conn Conn) {
-	proxyConnectionsTotal.WithLabelValues(conn.BrokerAddress).Inc()
+	metricLabels := newMetricLabelValues(conn.BrokerAddress, conn.LocalConnection)
+	proxyConnectionsTotal.with(&metricLabels).Inc()
 
-	server, err := c.DialAndAuth(conn.BrokerAddress)
+	if c.maintenance.active() {
+		c.maintenance.reject(conn.LocalConnection)
+		return
+	}
+	if c.processorConfig.MemoryBudget.rejects() {
+		logger.Infof("rejected connection from %s to %s as the memory budget is exhausted", conn.LocalConnection.RemoteAddr(), conn.BrokerAddress)
+		proxyMemoryBudgetRejectedConnectionsTotal.Inc()
+		conn.LocalConnection.Close()
+		return
+	}
+	if c.brokerDrains.draining(conn.BrokerAddress) {
+		logger.Infof("rejected connection from %s to the drained broker %s", conn.LocalConnection.RemoteAddr(), conn.BrokerAddress)
+		proxyDrainRejectedConnectionsTotal.WithLabelValues(conn.BrokerAddress).Inc()
+		conn.LocalConnection.Close()
+		return
+	}
+	if brokerAddress := c.brokerMigrations.brokerAddress(conn.BrokerAddress); brokerAddress != conn.BrokerAddress {
+		logger.Debugf("connection from %s to the old broker address %s is connected to %s", conn.LocalConnection.RemoteAddr(), conn.BrokerAddress, brokerAddress)
+		conn.BrokerAddress = brokerAddress
+	}
+	processorConfig, authClient := c.listenerAuth(conn.ListenerAddress)
+	server, err := c.connectBroker(conn.BrokerAddress, authClient)
 	if err != nil {
-		logrus.Infof("couldn't connect to %s: %v", conn.BrokerAddress, err)
+		logger.Infof("couldn't connect to %s: %v", conn.BrokerAddress, err)
+		emitEvent(Event{Type: EventBrokerUnreachable, Broker: conn.BrokerAddress, Client: conn.LocalConnection.RemoteAddr().String(), Listener: conn.LocalConnection.LocalAddr().String(), Message: err.Error()})
 		conn.LocalConnection.Close()
 		return
 	}
-	if tcpConn, ok := server.(*net.TCPConn); ok {
+	upstreamConnections := proxyUpstreamConnections.WithLabelValues(conn.BrokerAddress)
+	upstreamConnections.Inc()
+	defer upstreamConnections.Dec()
+
+	rawConn := server
+	if conn, ok := rawConn.(*saslConn); ok {
+		rawConn = conn.Conn
+	}
+	if conn, ok := rawConn.(*meteredConn); ok {
+		rawConn = conn.Conn
+	}
+	if tcpConn, ok := rawConn.(*net.TCPConn); ok {
 		if err := c.tcpConnOptions.setTCPConnOptions(tcpConn); err != nil {
-			logrus.Infof("WARNING: Error while setting TCP options for kafka connection %s on %v: %v", conn.BrokerAddress, server.LocalAddr(), err)
+			logger.Infof("WARNING: Error while setting TCP options for kafka connection %s on %v: %v", conn.BrokerAddress, server.LocalAddr(), err)
 		}
 	}
 	c.conns.Add(conn.BrokerAddress, conn.LocalConnection)
+	event := Event{Type: EventConnectionOpened, Broker: conn.BrokerAddress, Client: conn.LocalConnection.RemoteAddr().String(), Listener: conn.LocalConnection.LocalAddr().String()}
+	emitEvent(event)
 	localDesc := "local connection on " + conn.LocalConnection.LocalAddr().String() + " from " + conn.LocalConnection.RemoteAddr().String() + " (" + conn.BrokerAddress + ")"
-	copyThenClose(c.processorConfig, server, conn.LocalConnection, conn.BrokerAddress, conn.BrokerAddress, localDesc)
+	copyThenClose(processorConfig, server, conn.LocalConnection, conn.BrokerAddress, conn.BrokerAddress, localDesc)
 	if err := c.conns.Remove(conn.BrokerAddress, conn.LocalConnection); err != nil {
-		logrus.Info(err)
+		logger.Infof("%v", err)
 	}
+	event.Type = EventConnectionClosed
+	emitEvent(event)
 }
 
+// DialAndAuth connects to the broker, the failed connections are retried with exponential backoff and jitter
 func (c *Client) DialAndAuth(brokerAddress string) (net.Conn, error) {
-	conn, err := c.dialer.Dial("tcp", brokerAddress)
+	return c.dialAndAuth(brokerAddress, c.authClient)
+}
+
+// connectBroker takes an authenticated connection of the pool or dials the broker, the connections of the listeners with own auth are dialed
+func (c *Client) connectBroker(brokerAddress string, authClient *AuthClient) (net.Conn, error) {
+	if authClient == c.authClient {
+		if conn := c.brokerPool.take(brokerAddress); conn != nil {
+			return conn, nil
+		}
+	}
+	return c.dialAndAuth(brokerAddress, authClient)
+}
+
+func (c *Client) dialAndAuth(brokerAddress string, authClient *AuthClient) (net.Conn, error) {
+	upstream := c.upstreams[c.processorConfig.ClusterRouting.cluster(brokerAddress)]
+	dialRetry := c.config.Kafka.DialRetry
+	if dialRetry.Retries <= 0 {
+		return c.dialUpstream(upstream, brokerAddress, upstream.saslAuth, authClient)
+	}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = dialRetry.InitialBackoff
+	b.MaxInterval = dialRetry.MaxBackoff
+	b.Multiplier = 2
+	b.MaxElapsedTime = 0
+
+	var conn net.Conn
+	err := backoff.RetryNotify(func() error {
+		var err error
+		if conn, err = c.dialUpstream(upstream, brokerAddress, upstream.saslAuth, authClient); err != nil {
+			if _, ok := err.(*circuitOpenError); ok {
+				// the broker is not dialed until the circuit is half-open
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	}, backoff.WithMaxTries(b, uint64(dialRetry.Retries)), func(err error, next time.Duration) {
+		proxyUpstreamDialRetriesTotal.WithLabelValues(brokerAddress).Inc()
+		logger.Infof("couldn't connect to %s, retrying in %v: %v", brokerAddress, next, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialCluster connects to the broker with the settings of the upstream cluster
+func (c *Client) dialCluster(cluster int, brokerAddress string) (net.Conn, error) {
+	upstream := c.upstreams[cluster]
+	return c.dialUpstream(upstream, brokerAddress, upstream.saslAuth, c.authClient)
+}
+
+func (c *Client) dialUpstream(upstream *upstream, brokerAddress string, saslAuth saslAuthenticator, authClient *AuthClient) (net.Conn, error) {
+	if err := c.circuitBreaker.allow(brokerAddress); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := c.dialAndAuthUpstream(upstream, brokerAddress, saslAuth, authClient)
+	c.circuitBreaker.done(brokerAddress, err)
+	proxyUpstreamConnectDuration.WithLabelValues(brokerAddress).Observe(time.Since(start).Seconds())
+	if err != nil {
+		proxyUpstreamConnectErrorsTotal.WithLabelValues(brokerAddress, dialStage(err)).Inc()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) dialAndAuthUpstream(upstream *upstream, brokerAddress string, saslAuth saslAuthenticator, authClient *AuthClient) (net.Conn, error) {
+	conn, err := upstream.dialer.Dial("tcp", brokerAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -216,34 +899,67 @@ func (c *Client) DialAndAuth(brokerAddress string) (net.Conn, error) {
 		conn.Close()
 		return nil, err
 	}
-	err = c.auth(conn)
-	if err != nil {
+	if conn, err = c.auth(newMeteredConn(conn, brokerAddress), upstream, saslAuth, authClient); err != nil {
 		return nil, err
 	}
+	if expectedClusterID := upstream.config.Kafka.ExpectedClusterID; expectedClusterID != "" {
+		if err = verifyClusterID(conn, expectedClusterID, upstream.config.Kafka.ClientID, upstream.config.Kafka.ReadTimeout); err != nil {
+			conn.Close()
+			return nil, &dialStageError{stage: dialStageClusterID, err: errors.Wrapf(err, "cluster id verification of %s failed", brokerAddress)}
+		}
+	}
 	return conn, nil
 }
 
-func (c *Client) auth(conn net.Conn) error {
-	if c.config.Auth.Gateway.Client.Enable {
-		if err := c.authClient.sendAndReceiveGatewayAuth(conn); err != nil {
+// verifyClusterID checks that the broker belongs to the expected cluster, the broker of another cluster is a misconfigured mapping
+func verifyClusterID(conn net.Conn, expectedClusterID string, clientID string, timeout time.Duration) error {
+	response, err := roundTripRequest(conn, protocol.EncodeClusterIDRequest(0, clientID), timeout)
+	if err != nil {
+		return err
+	}
+	clusterID, err := protocol.DecodeClusterIDResponse(response)
+	if err != nil {
+		return err
+	}
+	if clusterID != expectedClusterID {
+		return errors.Errorf("broker belongs to cluster %s, expected cluster %s", clusterID, expectedClusterID)
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// auth authenticates the connection, the connection with an expiring SASL session is returned as *saslConn
+func (c *Client) auth(conn net.Conn, upstream *upstream, saslAuth saslAuthenticator, authClient *AuthClient) (net.Conn, error) {
+	if authClient.enabled {
+		if err := authClient.sendAndReceiveGatewayAuth(conn); err != nil {
 			conn.Close()
-			return err
+			return nil, &dialStageError{stage: dialStageGatewayAuth, err: err}
 		}
 		if err := conn.SetDeadline(time.Time{}); err != nil {
 			conn.Close()
-			return err
+			return nil, err
 		}
 	}
-	if c.config.Kafka.SASL.Enable {
-		err := c.saslPlainAuth.sendAndReceiveSASLPlainAuth(conn)
+	if upstream.config.Kafka.SASL.Enable {
+		kafka := upstream.config.Kafka
+		var transport saslTransport = &rawSASLTransport{conn: conn, clientID: kafka.ClientID, writeTimeout: kafka.WriteTimeout, readTimeout: kafka.ReadTimeout}
+		if kafka.SASL.Reauthentication {
+			transport = &saslAuthenticateTransport{clientID: kafka.ClientID, roundTrip: func(request *protocol.Request) ([]byte, error) {
+				return sendAndReceive(conn, request, kafka.WriteTimeout, kafka.ReadTimeout)
+			}}
+		}
+		generation := upstream.generation.get()
+		err := saslAuth.authenticate(transport)
 		if err != nil {
 			conn.Close()
-			return err
+			return nil, &dialStageError{stage: dialStageSASL, err: err}
 		}
 		if err := conn.SetDeadline(time.Time{}); err != nil {
 			conn.Close()
-			return err
+			return nil, err
+		}
+		if t, ok := transport.(*saslAuthenticateTransport); ok && (t.sessionLifetime > 0 || upstream.generation != nil) {
+			return &saslConn{Conn: conn, session: newUpstreamSASLSession(saslAuth, kafka.ClientID, t.sessionLifetime, upstream.generation, generation)}, nil
 		}
 	}
-	return nil
+	return conn, nil
 }
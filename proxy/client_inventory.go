@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxClientInventoryEntries limits the remembered clients, the connections of the clients which do not fit are only counted by the metric
+const maxClientInventoryEntries = 10000
+
+// ClientInventoryEntry is a client fingerprinted by the first request of its connections
+type ClientInventoryEntry struct {
+	ClientID  string `json:"client_id"`
+	Principal string `json:"principal,omitempty"`
+	// named by the ApiVersions requests v3+, empty for the older clients
+	SoftwareName    string    `json:"software_name,omitempty"`
+	SoftwareVersion string    `json:"software_version,omitempty"`
+	FirstRequest    string    `json:"first_request"` // api key name and version e.g. ApiVersions v3
+	Connections     int64     `json:"connections"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+type clientInventoryKey struct {
+	clientID        string
+	principal       string
+	softwareName    string
+	softwareVersion string
+	firstRequest    string
+}
+
+// ClientInventory aggregates the client ids, the principals authenticated by local SASL and the client software of the connections,
+// so the clients with old libraries are found before the api version policy is tightened. The inventory is listed by the HTTP
+// client inventory endpoint, the connections are counted by the client software.
+type ClientInventory struct {
+	mu      sync.Mutex
+	entries map[clientInventoryKey]*ClientInventoryEntry
+}
+
+// NewClientInventory creates the empty client inventory
+func NewClientInventory() *ClientInventory {
+	return &ClientInventory{entries: make(map[clientInventoryKey]*ClientInventoryEntry)}
+}
+
+// observe fingerprints the connection by its first request (without the size), the request which cannot be decoded is ignored
+func (i *ClientInventory) observe(principal string, request []byte) {
+	if i == nil {
+		return
+	}
+	software, err := protocol.DecodeClientSoftware(request)
+	if err != nil {
+		logger.Debugf("Client software of the first request cannot be decoded: %v", err)
+		return
+	}
+	softwareName, softwareVersion := software.Name, software.Version
+	if softwareName == "" {
+		softwareName, softwareVersion = "unknown", "unknown"
+	}
+	proxyClientSoftwareConnectionsTotal.WithLabelValues(softwareName, softwareVersion).Inc()
+
+	key := clientInventoryKey{
+		clientID:        software.ClientID,
+		principal:       principal,
+		softwareName:    software.Name,
+		softwareVersion: software.Version,
+		firstRequest:    fmt.Sprintf("%s v%d", protocol.ApiKeyName(int16(binary.BigEndian.Uint16(request))), int16(binary.BigEndian.Uint16(request[2:]))),
+	}
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entry, ok := i.entries[key]
+	if !ok {
+		if len(i.entries) >= maxClientInventoryEntries {
+			return
+		}
+		entry = &ClientInventoryEntry{ClientID: key.clientID, Principal: key.principal, SoftwareName: key.softwareName,
+			SoftwareVersion: key.softwareVersion, FirstRequest: key.firstRequest, FirstSeen: now}
+		i.entries[key] = entry
+	}
+	entry.Connections++
+	entry.LastSeen = now
+}
+
+// Entries returns the clients of the software name or all clients when the name is empty, ordered by the client id
+func (i *ClientInventory) Entries(softwareName string) []ClientInventoryEntry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entries := make([]ClientInventoryEntry, 0, len(i.entries))
+	for _, entry := range i.entries {
+		if softwareName == "" || softwareName == entry.SoftwareName {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].ClientID != entries[b].ClientID {
+			return entries[a].ClientID < entries[b].ClientID
+		}
+		if entries[a].SoftwareName != entries[b].SoftwareName {
+			return entries[a].SoftwareName < entries[b].SoftwareName
+		}
+		if entries[a].SoftwareVersion != entries[b].SoftwareVersion {
+			return entries[a].SoftwareVersion < entries[b].SoftwareVersion
+		}
+		if entries[a].Principal != entries[b].Principal {
+			return entries[a].Principal < entries[b].Principal
+		}
+		return entries[a].FirstRequest < entries[b].FirstRequest
+	})
+	return entries
+}
+
+// Reset forgets the clients e.g. after the clients were upgraded
+func (i *ClientInventory) Reset() {
+	i.mu.Lock()
+	i.entries = make(map[clientInventoryKey]*ClientInventoryEntry)
+	i.mu.Unlock()
+}
+
+// ServeHTTP returns on GET the clients selected by the optional query parameter software_name e.g. ?software_name=apache-kafka-java
+// and forgets the clients on DELETE
+func (i *ClientInventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		i.Reset()
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(i.Entries(r.URL.Query().Get("software_name")))
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testApiVersionsRequestV3 returns the ApiVersions v3 request (without the size) naming the client software
+func testApiVersionsRequestV3(clientID string, softwareName string, softwareVersion string) []byte {
+	request := []byte{0x00, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(len(clientID))}
+	request = append(request, clientID...)
+	// request header tagged fields
+	request = append(request, 0x00)
+	request = append(request, byte(len(softwareName)+1))
+	request = append(request, softwareName...)
+	request = append(request, byte(len(softwareVersion)+1))
+	request = append(request, softwareVersion...)
+	return append(request, 0x00)
+}
+
+func TestClientInventory(t *testing.T) {
+	a := assert.New(t)
+
+	i := NewClientInventory()
+	before := testCounterValue(a, proxyClientSoftwareConnectionsTotal.WithLabelValues("apache-kafka-java", "3.7.0"))
+	i.observe("", testApiVersionsRequestV3("orders", "apache-kafka-java", "3.7.0"))
+	i.observe("", testApiVersionsRequestV3("orders", "apache-kafka-java", "3.7.0"))
+	i.observe("alice", protocol.EncodeApiVersionsRequest(1, "legacy"))
+	// the requests which cannot be decoded are ignored
+	i.observe("", []byte{0x00, 0x12})
+	a.Equal(before+2, testCounterValue(a, proxyClientSoftwareConnectionsTotal.WithLabelValues("apache-kafka-java", "3.7.0")))
+
+	entries := i.Entries("")
+	a.Len(entries, 2)
+	a.Equal("legacy", entries[0].ClientID)
+	a.Equal("alice", entries[0].Principal)
+	a.Equal("", entries[0].SoftwareName)
+	a.Equal("ApiVersions v0", entries[0].FirstRequest)
+	a.Equal(int64(1), entries[0].Connections)
+	a.Equal("orders", entries[1].ClientID)
+	a.Equal("3.7.0", entries[1].SoftwareVersion)
+	a.Equal("ApiVersions v3", entries[1].FirstRequest)
+	a.Equal(int64(2), entries[1].Connections)
+
+	rec := httptest.NewRecorder()
+	i.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/client-inventory?software_name=apache-kafka-java", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	var body []ClientInventoryEntry
+	a.Nil(json.Unmarshal(rec.Body.Bytes(), &body))
+	a.Len(body, 1)
+	a.Equal("orders", body[0].ClientID)
+
+	rec = httptest.NewRecorder()
+	i.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/client-inventory", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+	rec = httptest.NewRecorder()
+	i.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/client-inventory", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	a.Empty(i.Entries(""))
+
+	// disabled
+	var disabled *ClientInventory
+	disabled.observe("", testApiVersionsRequestV3("orders", "apache-kafka-java", "3.7.0"))
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	clientQuotaProduce = "produce"
+	clientQuotaFetch   = "fetch"
+	clientQuotaRequest = "request"
+)
+
+// ClientQuotas emulates the client quotas of the brokers. The produced and fetched bytes and the requests are measured per client id,
+// the responses of the clients exceeding a quota get the throttle time the broker would return, so the clients back off themselves.
+// The requests and responses are neither delayed nor dropped.
+type ClientQuotas struct {
+	produceByteRate float64 // bytes per second, 0 - unlimited
+	fetchByteRate   float64 // bytes per second, 0 - unlimited
+	requestRate     float64 // requests per second, 0 - unlimited
+	window          time.Duration
+	maxThrottle     time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*clientQuotaUsage
+}
+
+func NewClientQuotas(produceByteRate int64, fetchByteRate int64, requestRate float64, window time.Duration, maxThrottle time.Duration) *ClientQuotas {
+	return &ClientQuotas{
+		produceByteRate: float64(produceByteRate),
+		fetchByteRate:   float64(fetchByteRate),
+		requestRate:     requestRate,
+		window:          window,
+		maxThrottle:     maxThrottle,
+		clients:         make(map[string]*clientQuotaUsage),
+	}
+}
+
+// clientQuotaUsage is the usage of the client id in the last window
+type clientQuotaUsage struct {
+	produce  quotaWindow
+	fetch    quotaWindow
+	requests quotaWindow
+	lastSeen time.Time
+}
+
+// quotaWindow counts the usage of the current and of the previous window, the usage of the last window is the current usage
+// and the part of the previous usage which is still in the last window
+type quotaWindow struct {
+	start    time.Time
+	current  float64
+	previous float64
+}
+
+// add adds the usage and returns the usage of the last window
+func (w *quotaWindow) add(now time.Time, window time.Duration, n float64) float64 {
+	elapsed := now.Sub(w.start)
+	if elapsed >= 2*window {
+		w.start, w.current, w.previous, elapsed = now, 0, 0, 0
+	} else if elapsed >= window {
+		w.start, w.current, w.previous, elapsed = w.start.Add(window), 0, w.current, elapsed-window
+	}
+	w.current += n
+	return w.previous*float64(window-elapsed)/float64(window) + w.current
+}
+
+// recordRequest counts the request of the client id of the connection and the bytes of the Produce request
+func (q *ClientQuotas) recordRequest(conn *connMetadata, apiKey int16, size int) {
+	if q == nil {
+		return
+	}
+	clientID := conn.currentClientID()
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := q.usage(clientID, now)
+	usage.requests.add(now, q.window, 1)
+	if apiKey == apiKeyProduce {
+		usage.produce.add(now, q.window, float64(size))
+	}
+}
+
+// throttle counts the bytes of the Fetch response of the client id of the connection and returns the throttle time of the response
+// and the exceeded quota, 0 when the client is within its quotas
+func (q *ClientQuotas) throttle(conn *connMetadata, apiKey int16, size int) (time.Duration, string) {
+	if q == nil {
+		return 0, ""
+	}
+	clientID := conn.currentClientID()
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := q.usage(clientID, now)
+	throttle, quota := q.throttleTime(usage.requests.add(now, q.window, 0), q.requestRate), clientQuotaRequest
+	switch apiKey {
+	case apiKeyProduce:
+		if produceThrottle := q.throttleTime(usage.produce.add(now, q.window, 0), q.produceByteRate); produceThrottle > throttle {
+			throttle, quota = produceThrottle, clientQuotaProduce
+		}
+	case apiKeyFetch:
+		if fetchThrottle := q.throttleTime(usage.fetch.add(now, q.window, float64(size)), q.fetchByteRate); fetchThrottle > throttle {
+			throttle, quota = fetchThrottle, clientQuotaFetch
+		}
+	}
+	return throttle, quota
+}
+
+// throttleTime returns the time the client must wait until its rate drops to the quota, as the broker computes it:
+// (rate - quota) / quota * window
+func (q *ClientQuotas) throttleTime(usage float64, quota float64) time.Duration {
+	if quota <= 0 {
+		return 0
+	}
+	throttle := time.Duration(usage/quota*float64(time.Second)) - q.window
+	if throttle <= 0 {
+		return 0
+	}
+	if q.maxThrottle > 0 && throttle > q.maxThrottle {
+		return q.maxThrottle
+	}
+	return throttle
+}
+
+// usage returns the usage of the client id, the usage of the client ids not seen for two windows is removed when a new client id is added
+func (q *ClientQuotas) usage(clientID string, now time.Time) *clientQuotaUsage {
+	usage, ok := q.clients[clientID]
+	if !ok {
+		for id, u := range q.clients {
+			if now.Sub(u.lastSeen) >= 2*q.window {
+				delete(q.clients, id)
+			}
+		}
+		usage = &clientQuotaUsage{}
+		q.clients[clientID] = usage
+	}
+	usage.lastSeen = now
+	return usage
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClientQuotas(t *testing.T) {
+	a := assert.New(t)
+
+	q := NewClientQuotas(1000, 0, 0, time.Minute, 0)
+	producer := newConnMetadata("kafka-1:9092", nil)
+	producer.setClientID("producer")
+	other := newConnMetadata("kafka-1:9092", nil)
+	other.setClientID("other")
+
+	q.recordRequest(producer, apiKeyProduce, 500)
+	throttle, _ := q.throttle(producer, apiKeyProduce, 10)
+	a.Equal(time.Duration(0), throttle)
+
+	// 90000 bytes in the window of 60s are 1500 bytes per second: (1500 - 1000) / 1000 * 60s
+	q.recordRequest(producer, apiKeyProduce, 89500)
+	throttle, quota := q.throttle(producer, apiKeyProduce, 10)
+	a.Equal(30*time.Second, throttle)
+	a.Equal(clientQuotaProduce, quota)
+
+	// the other api keys and client ids are not throttled by the produce quota
+	throttle, _ = q.throttle(producer, apiKeyFetch, 100000)
+	a.Equal(time.Duration(0), throttle)
+	throttle, _ = q.throttle(other, apiKeyProduce, 10)
+	a.Equal(time.Duration(0), throttle)
+
+	q.maxThrottle = 5 * time.Second
+	throttle, _ = q.throttle(producer, apiKeyProduce, 10)
+	a.Equal(5*time.Second, throttle)
+
+	// the request rate throttles all responses
+	q = NewClientQuotas(0, 0, 2, time.Second, 0)
+	for i := 0; i < 5; i++ {
+		q.recordRequest(producer, apiKeyMetadata, 50)
+	}
+	throttle, quota = q.throttle(producer, apiKeyMetadata, 10)
+	a.Equal(1500*time.Millisecond, throttle)
+	a.Equal(clientQuotaRequest, quota)
+
+	// disabled
+	var disabled *ClientQuotas
+	disabled.recordRequest(producer, apiKeyProduce, 100000)
+	throttle, _ = disabled.throttle(producer, apiKeyProduce, 10)
+	a.Equal(time.Duration(0), throttle)
+}
+
+func TestQuotaWindow(t *testing.T) {
+	a := assert.New(t)
+
+	start := time.Now()
+	w := &quotaWindow{}
+	a.Equal(float64(100), w.add(start, time.Second, 100))
+	// half of the previous window is in the last window
+	a.Equal(float64(60), w.add(start.Add(1500*time.Millisecond), time.Second, 10))
+	// the usage older than two windows is dropped
+	a.Equal(float64(0), w.add(start.Add(4*time.Second), time.Second, 0))
+}
+
+func TestThrottleResponse(t *testing.T) {
+	a := assert.New(t)
+
+	ctx := &ResponsesLoopContext{brokerAddress: "kafka-1:9092"}
+	before := testCounterValue(a, proxyClientQuotaThrottledResponsesTotal.WithLabelValues(clientQuotaFetch))
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07}
+	a.Equal([]byte{0x00, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x00, 0x07}, ctx.throttleResponse(apiKeyFetch, 1, response, time.Second, clientQuotaFetch))
+	a.Equal(before+1, testCounterValue(a, proxyClientQuotaThrottledResponsesTotal.WithLabelValues(clientQuotaFetch)))
+
+	// the response which cannot be modified is returned
+	a.Equal([]byte{0x00}, ctx.throttleResponse(apiKeyFetch, 1, []byte{0x00}, time.Second, clientQuotaFetch))
+	a.Equal(before+1, testCounterValue(a, proxyClientQuotaThrottledResponsesTotal.WithLabelValues(clientQuotaFetch)))
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyGetTelemetrySubscriptions = int16(71)
+	apiKeyPushTelemetry             = int16(72)
+
+	compressionNone = int8(0)
+)
+
+// ClientTelemetry answers the client telemetry requests (KIP-714) in the proxy instead of forwarding them to the brokers. The clients
+// are subscribed to the metrics with the requested name prefixes, the values of the pushed gauges and sums are exported as the proxy
+// metric proxy_client_telemetry, so they reach the Prometheus endpoint and the statsd and OTLP exporters.
+// The requests are announced by ApiVersions also when the brokers have no client telemetry receiver.
+type ClientTelemetry struct {
+	subscriptionID    int32
+	pushIntervalMs    int32
+	telemetryMaxBytes int32
+	requestedMetrics  []string
+}
+
+// NewClientTelemetry creates the client telemetry, all metrics are requested when the metric name prefixes are empty
+func NewClientTelemetry(pushInterval time.Duration, maxBytes int, metrics []string) *ClientTelemetry {
+	requestedMetrics := metrics
+	if len(requestedMetrics) == 0 {
+		// the empty prefix matches all metrics
+		requestedMetrics = []string{""}
+	}
+	// the subscription changes with the configuration
+	subscriptionID := int32(crc32.ChecksumIEEE([]byte(pushInterval.String() + "\x00" + strings.Join(requestedMetrics, "\x00"))))
+	return &ClientTelemetry{
+		subscriptionID:    subscriptionID,
+		pushIntervalMs:    int32(pushInterval / time.Millisecond),
+		telemetryMaxBytes: int32(maxBytes),
+		requestedMetrics:  requestedMetrics,
+	}
+}
+
+// terminates checks whether the requests with the api key are answered by the proxy
+func (c *ClientTelemetry) terminates(apiKey int16) bool {
+	return c != nil && (apiKey == apiKeyGetTelemetrySubscriptions || apiKey == apiKeyPushTelemetry)
+}
+
+// respond returns the response to the client telemetry request (without the size field)
+func (c *ClientTelemetry) respond(clientAddress string, request []byte) ([]byte, error) {
+	apiKey := int16(binary.BigEndian.Uint16(request))
+	if apiKey == apiKeyGetTelemetrySubscriptions {
+		clientInstanceID, err := protocol.DecodeGetTelemetrySubscriptionsRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		if isZeroUuid(clientInstanceID) {
+			if clientInstanceID, err = newUuid(); err != nil {
+				return nil, err
+			}
+		}
+		return protocol.EncodeGetTelemetrySubscriptionsResponse(protocol.TelemetrySubscription{
+			ClientInstanceID:  clientInstanceID,
+			SubscriptionID:    c.subscriptionID,
+			PushIntervalMs:    c.pushIntervalMs,
+			TelemetryMaxBytes: c.telemetryMaxBytes,
+			RequestedMetrics:  c.requestedMetrics,
+		})
+	}
+	push, err := protocol.DecodePushTelemetryRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.EncodePushTelemetryResponse(int16(c.push(clientAddress, push)))
+}
+
+// push exports the pushed metrics and returns the error code of the response
+func (c *ClientTelemetry) push(clientAddress string, push *protocol.TelemetryPush) protocol.KError {
+	switch {
+	case push.SubscriptionID != c.subscriptionID:
+		proxyClientTelemetryPushesTotal.WithLabelValues("unknown_subscription").Inc()
+		return protocol.ErrUnknownSubscriptionID
+	case push.CompressionType != compressionNone:
+		proxyClientTelemetryPushesTotal.WithLabelValues("unsupported_compression").Inc()
+		return protocol.ErrUnsupportedCompressionType
+	case len(push.Metrics) > int(c.telemetryMaxBytes):
+		proxyClientTelemetryPushesTotal.WithLabelValues("too_large").Inc()
+		return protocol.ErrTelemetryTooLarge
+	}
+	metrics, err := decodeClientMetrics(push.Metrics)
+	if err != nil {
+		logger.Warnf("Client telemetry pushed by %s (client id %s) cannot be decoded: %v", clientAddress, push.ClientID, err)
+		proxyClientTelemetryPushesTotal.WithLabelValues("invalid").Inc()
+		return protocol.ErrNoError
+	}
+	for name, value := range metrics {
+		proxyClientTelemetry.WithLabelValues(push.ClientID, name).Set(value)
+	}
+	proxyClientTelemetryPushesTotal.WithLabelValues("accepted").Inc()
+	return protocol.ErrNoError
+}
+
+func isZeroUuid(uuid []byte) bool {
+	for _, b := range uuid {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// newUuid returns the random (version 4) uuid
+func newUuid() ([]byte, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return nil, err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return uuid, nil
+}
+
+// decodeClientMetrics returns the values of the gauges and sums of the OTLP MetricsData pushed by the client,
+// the values of the data points of a metric are summed across their attributes. The histograms and summaries are skipped.
+func decodeClientMetrics(data []byte) (map[string]float64, error) {
+	result := make(map[string]float64)
+	// MetricsData => resource_metrics(1) => scope_metrics(2) => metrics(2)
+	err := walkProtobuf(data, 1, func(resourceMetrics []byte) error {
+		return walkProtobuf(resourceMetrics, 2, func(scopeMetrics []byte) error {
+			return walkProtobuf(scopeMetrics, 2, func(metric []byte) error {
+				return decodeClientMetric(metric, result)
+			})
+		})
+	})
+	return result, err
+}
+
+// decodeClientMetric decodes the Metric with the name(1) and the data points(1) of the gauge(5) or sum(7)
+func decodeClientMetric(metric []byte, result map[string]float64) error {
+	var name string
+	var points [][]byte
+	err := walkProtobufFields(metric, func(field int, wireType int, value []byte) error {
+		if wireType != 2 {
+			return nil
+		}
+		switch field {
+		case 1:
+			name = string(value)
+		case 5, 7:
+			return walkProtobuf(value, 1, func(point []byte) error {
+				points = append(points, point)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil || name == "" || points == nil {
+		return err
+	}
+	var sum float64
+	for _, point := range points {
+		// NumberDataPoint => as_double(4) or as_int(6), both fixed64
+		err = walkProtobufFields(point, func(field int, wireType int, value []byte) error {
+			if wireType != 1 {
+				return nil
+			}
+			switch field {
+			case 4:
+				sum += math.Float64frombits(binary.LittleEndian.Uint64(value))
+			case 6:
+				sum += float64(int64(binary.LittleEndian.Uint64(value)))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	result[name] += sum
+	return nil
+}
+
+// walkProtobuf calls fn with the embedded messages of the field
+func walkProtobuf(message []byte, field int, fn func(value []byte) error) error {
+	return walkProtobufFields(message, func(f int, wireType int, value []byte) error {
+		if f == field && wireType == 2 {
+			return fn(value)
+		}
+		return nil
+	})
+}
+
+// walkProtobufFields calls fn with the fields of the protobuf message, the value is the varint, the fixed bytes or the length delimited bytes
+func walkProtobufFields(message []byte, fn func(field int, wireType int, value []byte) error) error {
+	for offset := 0; offset < len(message); {
+		key, size := binary.Uvarint(message[offset:])
+		if size <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		offset += size
+		field, wireType := int(key>>3), int(key&7)
+		var length int
+		switch wireType {
+		case 0:
+			if _, size = binary.Uvarint(message[offset:]); size <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			length = size
+		case 1:
+			length = 8
+		case 2:
+			n, size := binary.Uvarint(message[offset:])
+			if size <= 0 || n > uint64(len(message)-offset-size) {
+				return errors.New("invalid protobuf length")
+			}
+			offset += size
+			length = int(n)
+		case 5:
+			length = 4
+		default:
+			return errors.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if offset+length > len(message) {
+			return errors.New("protobuf message is too short")
+		}
+		if err := fn(field, wireType, message[offset:offset+length]); err != nil {
+			return err
+		}
+		offset += length
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/binary"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func testTelemetryRequest(apiKey int16, clientID string, body []byte) []byte {
+	request := []byte{byte(apiKey >> 8), byte(apiKey), 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, byte(len(clientID))}
+	request = append(request, clientID...)
+	// request header tagged fields
+	request = append(request, 0x00)
+	return append(request, body...)
+}
+
+func testPushTelemetryRequest(subscriptionID int32, compressionType byte, metrics []byte) []byte {
+	body := make([]byte, 16, 64)
+	body[15] = 0x01
+	body = append(body, 0x00, 0x00, 0x00, 0x00, 0x00, compressionType)
+	binary.BigEndian.PutUint32(body[16:], uint32(subscriptionID))
+	var length [binary.MaxVarintLen32]byte
+	body = append(body, length[:binary.PutUvarint(length[:], uint64(len(metrics)+1))]...)
+	body = append(body, metrics...)
+	body = append(body, 0x00)
+	return testTelemetryRequest(apiKeyPushTelemetry, "app-1", body)
+}
+
+func testClientMetrics() []byte {
+	var gauge, sum otlpMessage
+	for _, value := range []float64{2.5, 1.5} {
+		var point otlpMessage
+		point.double(4, value)
+		gauge.message(1, point)
+	}
+	var point otlpMessage
+	point.fixed64(6, 7)
+	sum.message(1, point)
+	sum.varint(2, otlpCumulative)
+
+	var queueTime, connections otlpMessage
+	queueTime.string(1, "org.apache.kafka.producer.record.queue.time.avg")
+	queueTime.message(5, gauge)
+	connections.string(1, "org.apache.kafka.producer.connection.creation.total")
+	connections.message(7, sum)
+
+	var scopeMetrics, resourceMetrics, metricsData otlpMessage
+	scopeMetrics.message(2, queueTime)
+	scopeMetrics.message(2, connections)
+	resourceMetrics.message(2, scopeMetrics)
+	metricsData.message(1, resourceMetrics)
+	return metricsData
+}
+
+func testClientTelemetryValue(metric string) float64 {
+	m := &dto.Metric{}
+	proxyClientTelemetry.WithLabelValues("app-1", metric).Write(m)
+	return m.GetGauge().GetValue()
+}
+
+func TestClientTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewClientTelemetry(30*time.Second, 1024, []string{"org.apache.kafka.producer."})
+	a.True(c.terminates(apiKeyGetTelemetrySubscriptions))
+	a.True(c.terminates(apiKeyPushTelemetry))
+	a.False(c.terminates(apiKeyApiVersions))
+	a.False((*ClientTelemetry)(nil).terminates(apiKeyPushTelemetry))
+
+	// the zero client instance id gets a new id
+	response, err := c.respond("127.0.0.1:5000", testTelemetryRequest(apiKeyGetTelemetrySubscriptions, "app-1", make([]byte, 17)))
+	a.Nil(err)
+	a.Len(response, 66)
+	a.Equal([]byte{0x00, 0x00}, response[5:7])
+	a.NotEqual(make([]byte, 16), response[7:23])
+	a.Equal(byte(0x40), response[13]&0xf0)
+	a.Equal(c.subscriptionID, int32(binary.BigEndian.Uint32(response[23:])))
+	// no compression, push interval, max bytes, cumulative temporality and the requested metrics
+	a.Equal([]byte{0x01, 0x00, 0x00, 0x75, 0x30, 0x00, 0x00, 0x04, 0x00, 0x00, 0x02, 0x1b}, response[27:39])
+	a.Equal("org.apache.kafka.producer.", string(response[39:65]))
+
+	// the metrics are exported
+	response, err = c.respond("127.0.0.1:5000", testPushTelemetryRequest(c.subscriptionID, 0, testClientMetrics()))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, response)
+	a.Equal(4.0, testClientTelemetryValue("org.apache.kafka.producer.record.queue.time.avg"))
+	a.Equal(7.0, testClientTelemetryValue("org.apache.kafka.producer.connection.creation.total"))
+
+	// the outdated subscription, the compressed and the too large metrics are rejected
+	response, err = c.respond("127.0.0.1:5000", testPushTelemetryRequest(c.subscriptionID+1, 0, testClientMetrics()))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x75}, response[5:7])
+	response, err = c.respond("127.0.0.1:5000", testPushTelemetryRequest(c.subscriptionID, 1, testClientMetrics()))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x4c}, response[5:7])
+	response, err = c.respond("127.0.0.1:5000", testPushTelemetryRequest(c.subscriptionID, 0, make([]byte, 1025)))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x76}, response[5:7])
+
+	// the invalid metrics are dropped
+	response, err = c.respond("127.0.0.1:5000", testPushTelemetryRequest(c.subscriptionID, 0, []byte{0x0a, 0x05}))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00}, response[5:7])
+
+	// all metrics are requested without prefixes
+	response, err = NewClientTelemetry(time.Minute, 1024, nil).respond("127.0.0.1:5000", testTelemetryRequest(apiKeyGetTelemetrySubscriptions, "app-1", make([]byte, 17)))
+	a.Nil(err)
+	a.Equal([]byte{0x02, 0x01, 0x00}, response[37:])
+}
+
+func TestDecodeClientMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	metrics, err := decodeClientMetrics(testClientMetrics())
+	a.Nil(err)
+	a.Equal(map[string]float64{
+		"org.apache.kafka.producer.record.queue.time.avg":     4,
+		"org.apache.kafka.producer.connection.creation.total": 7,
+	}, metrics)
+
+	var histogram, metric, scopeMetrics, resourceMetrics, metricsData otlpMessage
+	histogram.double(5, math.Pi)
+	metric.string(1, "org.apache.kafka.producer.latency")
+	metric.message(9, histogram)
+	scopeMetrics.message(2, metric)
+	resourceMetrics.message(2, scopeMetrics)
+	metricsData.message(1, resourceMetrics)
+	metrics, err = decodeClientMetrics(metricsData)
+	a.Nil(err)
+	a.Empty(metrics)
+
+	_, err = decodeClientMetrics([]byte{0x0a, 0x05, 0x00})
+	a.NotNil(err)
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DialFunc connects to the broker of the upstream cluster
+type DialFunc func(cluster int, brokerAddress string) (net.Conn, error)
+
+// ClusterRouting routes the topics and the consumer groups to the upstream clusters, the clients see one cluster.
+// The Metadata responses of all clusters are merged and the node ids of every cluster are shifted by protocol.ClusterNodeIDs,
+// so the brokers of different clusters do not collide. The FindCoordinator requests are answered by the cluster of the group.
+type ClusterRouting struct {
+	// the first cluster is the default cluster of the bootstrap servers
+	clusters    []upstreamCluster
+	topicRoutes []clusterRoute
+	groupRoutes []clusterRoute
+
+	dial    DialFunc
+	timeout time.Duration
+
+	// clusters of the brokers learned from the metadata
+	brokerClusters map[string]int
+	lock           sync.RWMutex
+}
+
+type upstreamCluster struct {
+	name             string
+	bootstrapServers []string
+}
+
+type clusterRoute struct {
+	pattern *regexp.Regexp
+	cluster int
+}
+
+// NewClusterRouting creates the routing to the default cluster and to the clusters given as name=host:port,host:port.
+// The routes are given as name=regexp, the first route matching the whole topic name or group id is used.
+func NewClusterRouting(bootstrapServers []string, clusters []string, topicRoutes []string, groupRoutes []string, dial DialFunc, timeout time.Duration) (*ClusterRouting, error) {
+	r := &ClusterRouting{
+		clusters:       []upstreamCluster{{name: config.DefaultClusterName, bootstrapServers: bootstrapServers}},
+		dial:           dial,
+		timeout:        timeout,
+		brokerClusters: make(map[string]int),
+	}
+	for _, value := range clusters {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("cluster '%s' must be name=host:port,host:port", value)
+		}
+		if r.clusterIndex(pair[0]) != -1 {
+			return nil, fmt.Errorf("cluster %s is configured twice", pair[0])
+		}
+		cluster := upstreamCluster{name: pair[0], bootstrapServers: strings.Split(pair[1], ",")}
+		for _, address := range cluster.bootstrapServers {
+			r.brokerClusters[address] = len(r.clusters)
+		}
+		r.clusters = append(r.clusters, cluster)
+	}
+	var err error
+	if r.topicRoutes, err = r.parseRoutes(topicRoutes); err != nil {
+		return nil, err
+	}
+	if r.groupRoutes, err = r.parseRoutes(groupRoutes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ClusterRouting) parseRoutes(routes []string) ([]clusterRoute, error) {
+	result := make([]clusterRoute, 0, len(routes))
+	for _, value := range routes {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return nil, fmt.Errorf("cluster route '%s' must be name=regexp", value)
+		}
+		cluster := r.clusterIndex(pair[0])
+		if cluster == -1 {
+			return nil, fmt.Errorf("cluster route '%s' refers to unknown cluster %s", value, pair[0])
+		}
+		pattern, err := regexp.Compile("^(?:" + pair[1] + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "cluster route '%s'", value)
+		}
+		result = append(result, clusterRoute{pattern: pattern, cluster: cluster})
+	}
+	return result, nil
+}
+
+func (r *ClusterRouting) clusterIndex(name string) int {
+	for i, cluster := range r.clusters {
+		if cluster.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// routes checks whether the requests with the api key are routed
+func (r *ClusterRouting) routes(apiKey int16) bool {
+	return r != nil && (apiKey == apiKeyMetadata || apiKey == apiKeyFindCoordinator)
+}
+
+// cluster returns the cluster of the broker, unknown brokers belong to the default cluster
+func (r *ClusterRouting) cluster(brokerAddress string) int {
+	if r == nil {
+		return 0
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.brokerClusters[brokerAddress]
+}
+
+func (r *ClusterRouting) topicCluster(topic string) int {
+	return route(r.topicRoutes, topic)
+}
+
+func (r *ClusterRouting) groupCluster(group string) int {
+	return route(r.groupRoutes, group)
+}
+
+func route(routes []clusterRoute, name string) int {
+	for _, route := range routes {
+		if route.pattern.MatchString(name) {
+			return route.cluster
+		}
+	}
+	return 0
+}
+
+func (r *ClusterRouting) topicSelector(cluster int) func(topic string) bool {
+	return func(topic string) bool {
+		return r.topicCluster(topic) == cluster
+	}
+}
+
+func nodeIDOffset(cluster int) int32 {
+	return int32(cluster) * protocol.ClusterNodeIDs
+}
+
+// routeRequest removes from the request (without the size field) sent to the broker of the cluster the topics of other clusters
+func (r *ClusterRouting) routeRequest(cluster int, request []byte) ([]byte, error) {
+	if int16(binary.BigEndian.Uint16(request)) != apiKeyMetadata {
+		return request, nil
+	}
+	result, _, err := protocol.FilterMetadataTopics(request, r.topicSelector(cluster))
+	return result, err
+}
+
+// routeResponse merges the response of the broker of the cluster (without the size and the correlation id) with the responses of other clusters
+// to the request
+func (r *ClusterRouting) routeResponse(cluster int, request []byte, apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	switch apiKey {
+	case apiKeyMetadata:
+		return r.mergeMetadata(cluster, request, apiVersion, response)
+	case apiKeyFindCoordinator:
+		key, err := protocol.FindCoordinatorKey(request)
+		if err != nil {
+			return nil, err
+		}
+		groupCluster := r.groupCluster(key)
+		if groupCluster != cluster {
+			if response, err = r.roundTrip(groupCluster, request); err != nil {
+				return nil, err
+			}
+		}
+		return protocol.ShiftCoordinatorNodeID(apiVersion, response, nodeIDOffset(groupCluster))
+	default:
+		return response, nil
+	}
+}
+
+func (r *ClusterRouting) mergeMetadata(cluster int, request []byte, apiVersion int16, response []byte) ([]byte, error) {
+	indexes := []int{cluster}
+	responses := []protocol.ClusterMetadata{{Response: response, NodeIDOffset: nodeIDOffset(cluster), Topic: r.topicSelector(cluster)}}
+	for other := range r.clusters {
+		if other == cluster {
+			continue
+		}
+		clusterRequest, selected, err := protocol.FilterMetadataTopics(request, r.topicSelector(other))
+		if err != nil {
+			return nil, err
+		}
+		if !selected {
+			continue
+		}
+		clusterResponse, err := r.roundTrip(other, clusterRequest)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, other)
+		responses = append(responses, protocol.ClusterMetadata{Response: clusterResponse, NodeIDOffset: nodeIDOffset(other), Topic: r.topicSelector(other)})
+	}
+	merged, brokers, err := protocol.MergeMetadataResponses(apiVersion, responses)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, addresses := range brokers {
+		for _, address := range addresses {
+			r.brokerClusters[address] = indexes[i]
+		}
+	}
+	return merged, nil
+}
+
+// roundTrip sends the request (without the size field) to a bootstrap server of the cluster and returns the response without the correlation id
+func (r *ClusterRouting) roundTrip(cluster int, request []byte) (response []byte, err error) {
+	for _, brokerAddress := range r.clusters[cluster].bootstrapServers {
+		if response, err = r.send(cluster, brokerAddress, request); err == nil {
+			return response, nil
+		}
+		logger.Infof("request to %s of cluster %s failed: %v", brokerAddress, r.clusters[cluster].name, err)
+	}
+	return nil, errors.Wrapf(err, "cluster %s is not available", r.clusters[cluster].name)
+}
+
+func (r *ClusterRouting) send(cluster int, brokerAddress string, request []byte) ([]byte, error) {
+	conn, err := r.dial(cluster, brokerAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return roundTripRequest(conn, request, r.timeout)
+}
+
+// roundTripRequest writes the request (without the size field) to the connection and returns the response without the correlation id
+func roundTripRequest(conn net.Conn, request []byte, timeout time.Duration) ([]byte, error) {
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	if err = writeRequest(conn, request); err != nil {
+		return nil, err
+	}
+	responseHeaderBuf := make([]byte, 8) // Size => int32, CorrelationId => int32
+	if _, err = io.ReadFull(conn, responseHeaderBuf); err != nil {
+		return nil, err
+	}
+	var responseHeader protocol.ResponseHeader
+	if err = protocol.Decode(responseHeaderBuf, &responseHeader); err != nil {
+		return nil, err
+	}
+	if responseHeader.CorrelationID != int32(binary.BigEndian.Uint32(request[4:])) {
+		return nil, fmt.Errorf("unexpected correlation id %d", responseHeader.CorrelationID)
+	}
+	if responseHeader.Length > protocol.MaxResponseSize {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", responseHeader.Length)}
+	}
+	response := make([]byte, int(responseHeader.Length-4))
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// writeRequest writes the size field and the request to the connection
+func writeRequest(conn net.Conn, request []byte) error {
+	sizeBuf := make([]byte, 4, 4+len(request))
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(request)))
+	_, err := conn.Write(append(sizeBuf, request...))
+	return err
+}
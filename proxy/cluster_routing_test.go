@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testClusterDial answers every request with the response of the broker address and records the requests
+func testClusterDial(responses map[string][]byte, requests map[string][]byte) DialFunc {
+	return func(_ int, brokerAddress string) (net.Conn, error) {
+		response, ok := responses[brokerAddress]
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			sizeBuf := make([]byte, 4)
+			if _, err := io.ReadFull(server, sizeBuf); err != nil {
+				return
+			}
+			request := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+			if _, err := io.ReadFull(server, request); err != nil {
+				return
+			}
+			requests[brokerAddress] = request
+			header := make([]byte, 8)
+			binary.BigEndian.PutUint32(header, uint32(len(response)+4))
+			copy(header[4:], request[4:8])
+			server.Write(append(header, response...))
+		}()
+		return client, nil
+	}
+}
+
+func testMetadataResponseV0(nodeID int32, host string, topic string) []byte {
+	buf := []byte{0x00, 0x00, 0x00, 0x01}
+	buf = append(buf, byte(nodeID>>24), byte(nodeID>>16), byte(nodeID>>8), byte(nodeID), 0x00, byte(len(host)))
+	buf = append(buf, host...)
+	buf = append(buf, 0x00, 0x00, 0x23, 0x84, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, byte(len(topic)))
+	buf = append(buf, topic...)
+	// partition_metadata
+	return append(buf, 0x00, 0x00, 0x00, 0x00)
+}
+
+func TestNewClusterRouting(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewClusterRouting([]string{"old:9092"}, []string{"new"}, nil, nil, nil, time.Second)
+	a.EqualError(err, "cluster 'new' must be name=host:port,host:port")
+	_, err = NewClusterRouting([]string{"old:9092"}, []string{"default=new:9092"}, nil, nil, nil, time.Second)
+	a.EqualError(err, "cluster default is configured twice")
+	_, err = NewClusterRouting([]string{"old:9092"}, []string{"new=new:9092"}, []string{"other=t.*"}, nil, nil, time.Second)
+	a.EqualError(err, "cluster route 'other=t.*' refers to unknown cluster other")
+
+	r, err := NewClusterRouting([]string{"old:9092"}, []string{"new=new-0:9092,new-1:9092"}, []string{"default=new\\.legacy", "new=new\\..*"}, []string{"new=migrated-.*"}, nil, time.Second)
+	a.Nil(err)
+	a.Equal(0, r.topicCluster("old.t1"))
+	a.Equal(0, r.topicCluster("new.legacy"))
+	a.Equal(1, r.topicCluster("new.t1"))
+	a.Equal(0, r.topicCluster("x.new.t1"))
+	a.Equal(1, r.groupCluster("migrated-g1"))
+	a.Equal(0, r.groupCluster("g1"))
+	a.Equal(0, r.cluster("old:9092"))
+	a.Equal(1, r.cluster("new-1:9092"))
+	a.True(r.routes(apiKeyMetadata))
+	a.False(r.routes(0))
+
+	var disabled *ClusterRouting
+	a.False(disabled.routes(apiKeyMetadata))
+	a.Equal(0, disabled.cluster("old:9092"))
+}
+
+func TestClusterRoutingMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	responses := map[string][]byte{"new-1:9092": testMetadataResponseV0(0, "new-b0", "new.t1")}
+	requests := make(map[string][]byte)
+	r, err := NewClusterRouting([]string{"old:9092"}, []string{"new=new-0:9092,new-1:9092"}, []string{"new=new\\..*"}, nil, testClusterDial(responses, requests), time.Second)
+	a.Nil(err)
+
+	// Metadata v0 for topics old.t1 and new.t1
+	request := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x02, 0x00, 0x06, 'o', 'l', 'd', '.', 't', '1', 0x00, 0x06, 'n', 'e', 'w', '.', 't', '1'}
+	oldRequest, err := r.routeRequest(0, request)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 'o', 'l', 'd', '.', 't', '1'}, oldRequest)
+
+	response, err := r.routeResponse(0, request, apiKeyMetadata, 0, testMetadataResponseV0(0, "old-b0", "old.t1"))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 'n', 'e', 'w', '.', 't', '1'}, requests["new-1:9092"])
+
+	expected := []byte{0x00, 0x00, 0x00, 0x02}
+	expected = append(expected, testMetadataResponseV0(0, "old-b0", "old.t1")[4:20]...)
+	expected = append(expected, testMetadataResponseV0(protocol.ClusterNodeIDs, "new-b0", "new.t1")[4:20]...)
+	expected = append(expected, 0x00, 0x00, 0x00, 0x02)
+	expected = append(expected, testMetadataResponseV0(0, "old-b0", "old.t1")[24:]...)
+	expected = append(expected, testMetadataResponseV0(0, "new-b0", "new.t1")[24:]...)
+	a.Equal(expected, response)
+
+	// the brokers of the new cluster are known
+	a.Equal(1, r.cluster("new-b0:9092"))
+	a.Equal(0, r.cluster("old-b0:9092"))
+}
+
+func TestClusterRoutingFindCoordinator(t *testing.T) {
+	a := assert.New(t)
+
+	coordinator := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'h', 0x00, 0x00, 0x23, 0x84}
+	responses := map[string][]byte{"new-0:9092": coordinator}
+	requests := make(map[string][]byte)
+	r, err := NewClusterRouting([]string{"old:9092"}, []string{"new=new-0:9092"}, nil, []string{"new=migrated-.*"}, testClusterDial(responses, requests), time.Second)
+	a.Nil(err)
+
+	// FindCoordinator v0 of the group routed to the new cluster
+	request := []byte{0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0x00, 0x0b, 'm', 'i', 'g', 'r', 'a', 't', 'e', 'd', '-', 'g', '1'}
+	response, err := r.routeResponse(0, request, apiKeyFindCoordinator, 0, []byte{0x00, 0x0f, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff})
+	a.Nil(err)
+	a.Equal(request, requests["new-0:9092"])
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x0f, 0x42, 0x41, 0x00, 0x01, 'h', 0x00, 0x00, 0x23, 0x84}, response)
+
+	// the group of the default cluster requested from the broker of the new cluster
+	request = []byte{0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0x00, 0x02, 'g', '1'}
+	_, err = r.routeResponse(1, request, apiKeyFindCoordinator, 0, coordinator)
+	a.EqualError(err, "cluster default is not available: unexpected EOF")
+}
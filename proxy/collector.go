@@ -1,29 +1,48 @@
 package proxy
 
 import (
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"net"
+	"os"
+	"sort"
+	"strconv"
 )
 
+const (
+	metricLabelBroker     = "broker"
+	metricLabelListener   = "listener"
+	metricLabelClientIP   = "client_ip"
+	metricLabelPrincipal  = "principal"
+	metricLabelApiKey     = "api_key"
+	metricLabelApiVersion = "api_version"
+	metricLabelInstance   = "proxy_instance"
+)
+
+var defaultMetricLabels = []string{metricLabelBroker, metricLabelApiKey, metricLabelApiVersion}
+
 var (
-	proxyConnectionsTotal = prometheus.NewCounterVec(
+	proxyConnectionsTotal = newLabeledCounterVec(
 		prometheus.CounterOpts{Name: "proxy_connections_total",
 			Help: "Total number of created connections"},
-		[]string{"broker"})
+		metricLabelBroker, metricLabelListener, metricLabelClientIP)
 
-	proxyRequestsTotal = prometheus.NewCounterVec(
+	proxyRequestsTotal = newLabeledCounterVec(
 		prometheus.CounterOpts{Name: "proxy_requests_total",
 			Help: "Total number of requests sent"},
-		[]string{"broker", "api_key", "api_version"})
+		metricLabelBroker, metricLabelListener, metricLabelClientIP, metricLabelPrincipal, metricLabelApiKey, metricLabelApiVersion)
 
-	proxyRequestsBytes = prometheus.NewCounterVec(
+	proxyRequestsBytes = newLabeledCounterVec(
 		prometheus.CounterOpts{Name: "proxy_requests_bytes",
 			Help: "Size of outgoing requests"},
-		[]string{"broker"})
+		metricLabelBroker, metricLabelListener, metricLabelClientIP, metricLabelPrincipal)
 
-	proxyResponsesBytes = prometheus.NewCounterVec(
+	// the principal is not known by the responses loop
+	proxyResponsesBytes = newLabeledCounterVec(
 		prometheus.CounterOpts{Name: "proxy_responses_bytes",
 			Help: "Size of incoming responses"},
-		[]string{"broker"})
+		metricLabelBroker, metricLabelListener, metricLabelClientIP)
 
 	proxyOpenedConnections = prometheus.NewDesc(
 		"proxy_opened_connections",
@@ -35,14 +54,458 @@ var (
 		prometheus.CounterOpts{Name: "proxy_local_auth_total",
 			Help: "Total number of local auth requests sent"},
 		[]string{"success", "status"})
+
+	proxyForwardProxyActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_forward_proxy_active",
+			Help: "Forward proxy used for new connections (1 - active, 0 - standby)"},
+		[]string{"proxy"})
+
+	proxyDNSResolutionFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_dns_resolution_failures_total",
+			Help: "Total number of failed broker host name resolutions"},
+		[]string{"host"})
+
+	proxyAddressLookupFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_address_lookup_failures_total",
+			Help: "Total number of failed broker address lookups"})
+
+	proxyRequestAuthzTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_request_authz_total",
+			Help: "Total number of request authorizations"},
+		[]string{"api_key", "allowed"})
+
+	proxyRequestPolicyTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_request_policy_total",
+			Help: "Total number of requests checked by the role policies"},
+		[]string{"role", "api_key", "allowed"})
+
+	proxyResponseErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_response_errors_total",
+			Help: "Total number of error codes in the broker responses"},
+		[]string{"broker", "api_key", "error_code"})
+
+	// api: produce or fetch
+	proxyTopicBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_topic_bytes_total",
+			Help: "Total number of record set bytes of the topic"},
+		[]string{"topic", "api"})
+
+	proxyTopicRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_topic_records_total",
+			Help: "Total number of records of the topic"},
+		[]string{"topic", "api"})
+
+	// listener: the broker address advertised to the clients
+	proxyPartitionLeaders = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_partition_leaders",
+			Help: "Number of the partitions led by the broker in the Metadata responses returned to the clients"},
+		[]string{"broker_id", "listener"})
+
+	proxyPartitionLeaderChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_partition_leader_changes_total",
+			Help: "Total number of the partition leader changes seen in the Metadata responses by the new leader"},
+		[]string{"broker_id"})
+
+	// broker: the old address of the broker whose address changed
+	proxyStaleBrokerConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_stale_broker_connections",
+			Help: "Number of the open connections to the old address of the broker whose address changed, they are closed when idle"},
+		[]string{"broker"})
+
+	proxyUpstreamConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_upstream_connections",
+			Help: "Number of open connections to the broker"},
+		[]string{"broker"})
+
+	// stage: tcp_dial, tls_handshake, gateway_auth, sasl or cluster_id
+	proxyUpstreamConnectErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_connect_errors_total",
+			Help: "Total number of failed connections to the broker by the failed stage"},
+		[]string{"broker", "stage"})
+
+	proxyUpstreamConnectDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "proxy_upstream_connect_duration_seconds",
+			Help: "Duration of the dial and authentication of the broker connections"},
+		[]string{"broker"})
+
+	proxyUpstreamDialRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_dial_retries_total",
+			Help: "Total number of retried connections to the broker"},
+		[]string{"broker"})
+
+	proxyUpstreamCircuitOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_upstream_circuit_open",
+			Help: "Circuit of the broker (1 - open, 0 - closed)"},
+		[]string{"broker"})
+
+	proxyUpstreamCircuitRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_circuit_rejections_total",
+			Help: "Total number of connections to the broker failed fast by the open circuit"},
+		[]string{"broker"})
+
+	proxyUpstreamSentBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_sent_bytes_total",
+			Help: "Total number of bytes sent to the broker"},
+		[]string{"broker"})
+
+	proxyUpstreamReceivedBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_upstream_received_bytes_total",
+			Help: "Total number of bytes received from the broker"},
+		[]string{"broker"})
+
+	// side: listener or broker, reason: revoked, crl_unavailable or ocsp_unavailable
+	proxyTLSRevocationCheckFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_tls_revocation_check_failures_total",
+			Help: "Total number of the peer certificates which are revoked or their revocation status is unknown"},
+		[]string{"side", "reason"})
+
+	proxyPluginUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_plugin_up",
+			Help: "Plugin subprocess is running (1 - running, 0 - restarting)"},
+		[]string{"plugin"})
+
+	// reason: exited, ping_failed or unresponsive
+	proxyPluginCrashesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_plugin_crashes_total",
+			Help: "Total number of the plugin subprocesses which failed the health check"},
+		[]string{"plugin", "reason"})
+
+	proxyPluginRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_plugin_restarts_total",
+			Help: "Total number of the plugin restarts"},
+		[]string{"plugin", "success"})
+
+	proxyPrincipalConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_principal_connections",
+			Help: "Number of the open client connections of the principal authenticated by local SASL"},
+		[]string{"principal"})
+
+	proxyTenantConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_tenant_connections",
+			Help: "Number of the open client connections of the tenant (topic prefix)"},
+		[]string{"tenant"})
+
+	// limit: principal or tenant
+	proxyConnectionLimitRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_connection_limit_rejections_total",
+			Help: "Total number of the client connections rejected by the connection limits"},
+		[]string{"limit"})
+	proxyExpiredSessionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_expired_sessions_total",
+			Help: "Total number of the client connections closed because their SASL session or gateway token expired"},
+		[]string{"auth"})
+	proxyBrokerDraining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_broker_draining",
+			Help: "Whether the new connections to the broker are rejected because it is drained"},
+		[]string{"broker"})
+	proxyDrainRejectedConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_drain_rejected_connections_total",
+			Help: "Total number of the client connections to the drained brokers rejected"},
+		[]string{"broker"})
+	proxyMaintenance = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "proxy_maintenance",
+			Help: "Whether the proxy is in maintenance mode and rejects the new connections"})
+	proxyMaintenanceRejectedConnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_maintenance_rejected_connections_total",
+			Help: "Total number of the client connections rejected in maintenance mode"})
+	proxyResponseCacheTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_response_cache_total",
+			Help: "Total number of the cacheable requests answered from the response cache (hit) or by the broker (miss)"},
+		[]string{"broker", "api_key", "result"})
+	proxyInjectedFaultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_injected_faults_total",
+			Help: "Total number of the faults injected into the requests"},
+		[]string{"fault", "api_key"})
+	proxyShadowProduceRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_shadow_produce_requests_total",
+			Help: "Total number of the Produce requests sent to the brokers of the shadow cluster"},
+		[]string{"broker"})
+	proxyShadowProduceDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_shadow_produce_dropped_total",
+			Help: "Total number of the Produce requests or their parts not shadowed or failed in the shadow cluster"},
+		[]string{"reason"})
+	proxyCanaryComparisonsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_canary_comparisons_total",
+			Help: "Total number of the responses compared with the canary cluster"},
+		[]string{"api_key"})
+	proxyCanaryDifferencesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_canary_differences_total",
+			Help: "Total number of the differences between the responses of the primary and the canary cluster"},
+		[]string{"api_key", "kind"})
+	proxyCanarySkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_canary_skipped_total",
+			Help: "Total number of the sampled responses not compared with the canary cluster"},
+		[]string{"reason"})
+	proxyReadOnlyRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_read_only_rejected_total",
+			Help: "Total number of the requests rejected in the read-only mode"},
+		[]string{"api_key"})
+	proxyEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_events_total",
+			Help: "Total number of the emitted events"},
+		[]string{"type"})
+	proxyEventWebhookDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_event_webhook_dropped_total",
+			Help: "Total number of the events which were not posted to the webhook"},
+		[]string{"reason"})
+	proxyAuditEventsProducedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_audit_events_produced_total",
+			Help: "Total number of the audit events produced to the audit topic"})
+	proxyAuditEventsDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_audit_events_dropped_total",
+			Help: "Total number of the audit events which were not produced to the audit topic"},
+		[]string{"reason"})
+	proxyRequestMirrorSamplesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_request_mirror_samples_total",
+			Help: "Total number of the request samples written to the request mirror"})
+	proxyRequestMirrorDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_request_mirror_dropped_total",
+			Help: "Total number of the request samples dropped as the queue was full or the batch could not be written"},
+		[]string{"reason"})
+	proxyClientTelemetryPushesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_telemetry_pushes_total",
+			Help: "Total number of the client telemetry pushes answered by the proxy"},
+		[]string{"result"})
+	proxyClientTelemetry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_client_telemetry",
+			Help: "Last value of the client metric pushed by the clients with the client id"},
+		[]string{"client_id", "metric"})
+	proxyRequestTimeoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_request_timeouts_total",
+			Help: "Total number of the requests answered with REQUEST_TIMED_OUT by the proxy as the broker response did not arrive in time"},
+		[]string{"broker", "api_key"})
+	proxySlowConsumersTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_slow_consumers_total",
+			Help: "Total number of the Fetch responses whose write to the client blocked longer than the slow consumer threshold"},
+		[]string{"broker", "policy"})
+	proxyClientQuotaThrottledResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_quota_throttled_responses_total",
+			Help: "Total number of the responses whose throttle time was raised by the proxy as the client id exceeded the produce, fetch or request quota"},
+		[]string{"quota"})
+	proxyMemoryBudgetBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_memory_budget_bytes",
+			Help: "Limit of the memory budget and the bytes of the buffered requests and responses"},
+		[]string{"state"})
+	proxyMemoryBudgetWaitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_memory_budget_waits_total",
+			Help: "Total number of the requests and responses which waited for the memory budget"})
+	proxyMemoryBudgetRejectedConnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "proxy_memory_budget_rejected_connections_total",
+			Help: "Total number of the connections closed as the memory budget was exhausted"})
+	proxyBrokerPoolIdleConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_broker_pool_idle_connections",
+			Help: "Number of the authenticated broker connections waiting in the pool for the clients"},
+		[]string{"broker"})
+	proxyBrokerPoolTakesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_broker_pool_takes_total",
+			Help: "Total number of the client connections which got a pooled broker connection (hit) or dialed the broker (miss)"},
+		[]string{"broker", "result"})
+	proxyKillSwitchConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_kill_switch_connections_total",
+			Help: "Total number of the connections closed by the kill switch as their client id or principal matched a pattern"},
+		[]string{"match"})
+	proxyClientSoftwareConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_client_software_connections_total",
+			Help: "Total number of the client connections by the client software named by their ApiVersions request v3+, unknown for the older clients"},
+		[]string{"software_name", "software_version"})
+	proxyOldClientRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_old_client_requests_total",
+			Help: "Total number of the requests with the api versions lower than the configured min versions, rejected or only logged by the policy"},
+		[]string{"api_key", "api_version", "policy"})
+	proxyCompressionTranscodedBatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_compression_transcoded_batches_total",
+			Help: "Total number of the record batches of the Produce requests and the Fetch responses compressed with another codec"},
+		[]string{"api", "from", "to"})
+	proxyOversizedResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_oversized_fetch_responses_total",
+			Help: "Total number of the Fetch responses larger than the max Fetch response size discarded by the proxy"},
+		[]string{"broker"})
+	proxyTunnelSessions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_tunnel_sessions",
+			Help: "Number of the open TLS and QUIC connections between the tunnel client and server"},
+		[]string{"side"})
+	proxyTunnelStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "proxy_tunnel_streams",
+			Help: "Number of the broker connections multiplexed over the tunnel connections"},
+		[]string{"side"})
+	proxyTunnelCompressionBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "proxy_tunnel_compression_bytes_total",
+			Help: "Total number of the bytes written to the compressed tunnel streams before and after the compression"},
+		[]string{"side", "stage"})
 )
 
 func init() {
-	prometheus.MustRegister(proxyConnectionsTotal)
-	prometheus.MustRegister(proxyRequestsTotal)
-	prometheus.MustRegister(proxyRequestsBytes)
-	prometheus.MustRegister(proxyResponsesBytes)
 	prometheus.MustRegister(proxyLocalAuthTotal)
+	prometheus.MustRegister(proxyForwardProxyActive)
+	prometheus.MustRegister(proxyDNSResolutionFailuresTotal)
+	prometheus.MustRegister(proxyAddressLookupFailuresTotal)
+	prometheus.MustRegister(proxyRequestAuthzTotal)
+	prometheus.MustRegister(proxyRequestPolicyTotal)
+	prometheus.MustRegister(proxyResponseErrorsTotal)
+	prometheus.MustRegister(proxyTopicBytesTotal)
+	prometheus.MustRegister(proxyTopicRecordsTotal)
+	prometheus.MustRegister(proxyPartitionLeaders)
+	prometheus.MustRegister(proxyPartitionLeaderChangesTotal)
+	prometheus.MustRegister(proxyStaleBrokerConnections)
+	prometheus.MustRegister(proxyUpstreamConnections)
+	prometheus.MustRegister(proxyUpstreamConnectErrorsTotal)
+	prometheus.MustRegister(proxyUpstreamConnectDuration)
+	prometheus.MustRegister(proxyUpstreamDialRetriesTotal)
+	prometheus.MustRegister(proxyUpstreamCircuitOpen)
+	prometheus.MustRegister(proxyUpstreamCircuitRejectionsTotal)
+	prometheus.MustRegister(proxyUpstreamSentBytesTotal)
+	prometheus.MustRegister(proxyUpstreamReceivedBytesTotal)
+	prometheus.MustRegister(proxyTLSRevocationCheckFailuresTotal)
+	prometheus.MustRegister(proxyPluginUp)
+	prometheus.MustRegister(proxyPluginCrashesTotal)
+	prometheus.MustRegister(proxyPluginRestartsTotal)
+	prometheus.MustRegister(proxyPrincipalConnections)
+	prometheus.MustRegister(proxyTenantConnections)
+	prometheus.MustRegister(proxyConnectionLimitRejectionsTotal)
+	prometheus.MustRegister(proxyExpiredSessionsTotal)
+	prometheus.MustRegister(proxyBrokerDraining)
+	prometheus.MustRegister(proxyDrainRejectedConnectionsTotal)
+	prometheus.MustRegister(proxyMaintenance)
+	prometheus.MustRegister(proxyMaintenanceRejectedConnectionsTotal)
+	prometheus.MustRegister(proxyResponseCacheTotal)
+	prometheus.MustRegister(proxyInjectedFaultsTotal)
+	prometheus.MustRegister(proxyShadowProduceRequestsTotal)
+	prometheus.MustRegister(proxyShadowProduceDroppedTotal)
+	prometheus.MustRegister(proxyCanaryComparisonsTotal)
+	prometheus.MustRegister(proxyCanaryDifferencesTotal)
+	prometheus.MustRegister(proxyCanarySkippedTotal)
+	prometheus.MustRegister(proxyReadOnlyRejectedTotal)
+	prometheus.MustRegister(proxyEventsTotal)
+	prometheus.MustRegister(proxyEventWebhookDroppedTotal)
+	prometheus.MustRegister(proxyAuditEventsProducedTotal)
+	prometheus.MustRegister(proxyAuditEventsDroppedTotal)
+	prometheus.MustRegister(proxyRequestMirrorSamplesTotal)
+	prometheus.MustRegister(proxyRequestMirrorDroppedTotal)
+	prometheus.MustRegister(proxyClientTelemetryPushesTotal)
+	prometheus.MustRegister(proxyClientTelemetry)
+	prometheus.MustRegister(proxyRequestTimeoutsTotal)
+	prometheus.MustRegister(proxySlowConsumersTotal)
+	prometheus.MustRegister(proxyClientQuotaThrottledResponsesTotal)
+	prometheus.MustRegister(proxyMemoryBudgetBytes)
+	prometheus.MustRegister(proxyMemoryBudgetWaitsTotal)
+	prometheus.MustRegister(proxyMemoryBudgetRejectedConnectionsTotal)
+	prometheus.MustRegister(proxyBrokerPoolIdleConnections)
+	prometheus.MustRegister(proxyBrokerPoolTakesTotal)
+	prometheus.MustRegister(proxyKillSwitchConnectionsTotal)
+	prometheus.MustRegister(proxyClientSoftwareConnectionsTotal)
+	prometheus.MustRegister(proxyOldClientRequestsTotal)
+	prometheus.MustRegister(proxyCompressionTranscodedBatchesTotal)
+	prometheus.MustRegister(proxyOversizedResponsesTotal)
+	prometheus.MustRegister(proxyTunnelSessions)
+	prometheus.MustRegister(proxyTunnelStreams)
+	prometheus.MustRegister(proxyTunnelCompressionBytesTotal)
+}
+
+// labeledCounterVec is the counter with the configurable labels, the labels which are not supported by the counter are ignored
+type labeledCounterVec struct {
+	opts      prometheus.CounterOpts
+	supported []string
+	labels    []string
+	vec       *prometheus.CounterVec
+}
+
+func newLabeledCounterVec(opts prometheus.CounterOpts, supported ...string) *labeledCounterVec {
+	c := &labeledCounterVec{opts: opts, supported: supported}
+	c.setLabels(defaultMetricLabels)
+	return c
+}
+
+func (c *labeledCounterVec) setLabels(labels []string) {
+	c.labels = make([]string, 0)
+	for _, label := range c.supported {
+		for _, l := range labels {
+			if l == label {
+				c.labels = append(c.labels, label)
+				break
+			}
+		}
+	}
+	c.vec = prometheus.NewCounterVec(c.opts, c.labels)
+}
+
+func (c *labeledCounterVec) with(values *metricLabelValues) prometheus.Counter {
+	labelValues := make([]string, len(c.labels))
+	for i, label := range c.labels {
+		switch label {
+		case metricLabelBroker:
+			labelValues[i] = values.broker
+		case metricLabelListener:
+			labelValues[i] = values.listener
+		case metricLabelClientIP:
+			labelValues[i] = values.clientIP
+		case metricLabelPrincipal:
+			labelValues[i] = values.principal
+		case metricLabelApiKey:
+			labelValues[i] = strconv.Itoa(int(values.apiKey))
+		case metricLabelApiVersion:
+			labelValues[i] = strconv.Itoa(int(values.apiVersion))
+		}
+	}
+	return c.vec.WithLabelValues(labelValues...)
+}
+
+// metricLabelValues are the label values of the connection and request metrics
+type metricLabelValues struct {
+	broker     string
+	listener   string
+	clientIP   string
+	principal  string
+	apiKey     int16
+	apiVersion int16
+}
+
+func newMetricLabelValues(brokerAddress string, local net.Conn) metricLabelValues {
+	values := metricLabelValues{broker: brokerAddress}
+	if local != nil {
+		values.listener = local.LocalAddr().String()
+		values.clientIP = local.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(values.clientIP); err == nil {
+			values.clientIP = host
+		}
+	}
+	return values
+}
+
+// SetMetricsLabels sets the labels of the connection and request metrics and registers them.
+// The label names of a metric cannot be changed after the registration, it must be called once before the connections are handled.
+func SetMetricsLabels(labels []string) {
+	for _, c := range []*labeledCounterVec{proxyConnectionsTotal, proxyRequestsTotal, proxyRequestsBytes, proxyResponsesBytes} {
+		c.setLabels(labels)
+		prometheus.MustRegister(c.vec)
+	}
+}
+
+// NewInstanceGatherer returns the gatherer adding the proxy_instance label with the instance id to the metrics of the gatherer,
+// so the metrics of the processes sharing the listener ports can be told apart
+func NewInstanceGatherer(gatherer prometheus.Gatherer, instanceID string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				name, value := metricLabelInstance, instanceID
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+				sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+			}
+		}
+		return families, err
+	})
+}
+
+// MetricsInstanceID returns the instance id of the metrics, the hostname and the process id when the instance id is empty
+func MetricsInstanceID(instanceID string) string {
+	if instanceID != "" {
+		return instanceID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
 
 type proxyCollector struct {
@@ -64,3 +527,34 @@ func (p *proxyCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(proxyOpenedConnections, prometheus.GaugeValue, float64(count), broker)
 	}
 }
+
+// meteredConn counts the bytes sent to and received from the broker
+type meteredConn struct {
+	net.Conn
+	sent     prometheus.Counter
+	received prometheus.Counter
+}
+
+func newMeteredConn(conn net.Conn, brokerAddress string) *meteredConn {
+	return &meteredConn{
+		Conn:     conn,
+		sent:     proxyUpstreamSentBytesTotal.WithLabelValues(brokerAddress),
+		received: proxyUpstreamReceivedBytesTotal.WithLabelValues(brokerAddress),
+	}
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.received.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.sent.Add(float64(n))
+	}
+	return n, err
+}
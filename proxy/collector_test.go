@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+func testCounterValue(a *assert.Assertions, counter prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	a.Nil(counter.Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func testGaugeValue(a *assert.Assertions, gauge prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	a.Nil(gauge.Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func TestMeteredConn(t *testing.T) {
+	a := assert.New(t)
+
+	client, broker := net.Pipe()
+	defer broker.Close()
+	conn := newMeteredConn(client, "metered-broker:9092")
+	defer conn.Close()
+
+	sent := testCounterValue(a, proxyUpstreamSentBytesTotal.WithLabelValues("metered-broker:9092"))
+	received := testCounterValue(a, proxyUpstreamReceivedBytesTotal.WithLabelValues("metered-broker:9092"))
+	other := testCounterValue(a, proxyUpstreamSentBytesTotal.WithLabelValues("other-broker:9092"))
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(broker, buf)
+		broker.Write([]byte("response"))
+	}()
+	n, err := conn.Write([]byte("hello"))
+	a.Nil(err)
+	a.Equal(5, n)
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+
+	a.Equal(sent+5, testCounterValue(a, proxyUpstreamSentBytesTotal.WithLabelValues("metered-broker:9092")))
+	a.Equal(received+8, testCounterValue(a, proxyUpstreamReceivedBytesTotal.WithLabelValues("metered-broker:9092")))
+	a.Equal(other, testCounterValue(a, proxyUpstreamSentBytesTotal.WithLabelValues("other-broker:9092")))
+}
+
+func TestLabeledCounterVec(t *testing.T) {
+	a := assert.New(t)
+
+	vec := newLabeledCounterVec(prometheus.CounterOpts{Name: "test_labeled_counter_total", Help: "Test counter"}, metricLabelBroker, metricLabelClientIP, metricLabelApiKey)
+	// principal is not supported by the counter
+	vec.setLabels([]string{metricLabelApiKey, metricLabelPrincipal, metricLabelBroker})
+	a.Equal([]string{metricLabelBroker, metricLabelApiKey}, vec.labels)
+
+	client, proxy := net.Pipe()
+	defer client.Close()
+	defer proxy.Close()
+	values := newMetricLabelValues("kafka-0:9092", proxy)
+	a.Equal(metricLabelValues{broker: "kafka-0:9092", listener: "pipe", clientIP: "pipe"}, values)
+	values.principal, values.apiKey, values.apiVersion = "alice", 3, 5
+	vec.with(&values).Add(2)
+	values.principal, values.apiVersion = "bob", 6
+	vec.with(&values).Inc()
+	a.Equal(float64(3), testCounterValue(a, vec.vec.WithLabelValues("kafka-0:9092", "3")))
+
+	vec.setLabels([]string{})
+	a.Empty(vec.labels)
+	vec.with(&values).Inc()
+	a.Equal(float64(1), testCounterValue(a, vec.vec.WithLabelValues()))
+}
+
+func TestInstanceGatherer(t *testing.T) {
+	a := assert.New(t)
+
+	registry := prometheus.NewRegistry()
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_instance_counter_total", Help: "Test counter"}, []string{"broker", "topic"})
+	registry.MustRegister(vec)
+	vec.WithLabelValues("kafka-0:9092", "t1").Inc()
+
+	families, err := NewInstanceGatherer(registry, "proxy-1").Gather()
+	a.Nil(err)
+	a.Len(families, 1)
+	labels := families[0].Metric[0].Label
+	a.Len(labels, 3)
+	a.Equal("broker", labels[0].GetName())
+	a.Equal("proxy_instance", labels[1].GetName())
+	a.Equal("proxy-1", labels[1].GetValue())
+	a.Equal("topic", labels[2].GetName())
+
+	a.Equal("proxy-1", MetricsInstanceID("proxy-1"))
+	a.Regexp(`-[0-9]+$`, MetricsInstanceID(""))
+}
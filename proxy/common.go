@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"io"
 	"net"
 	"sync"
@@ -101,6 +100,46 @@ func myCopyN(dst io.Writer, src io.Reader, size int64, buf []byte) (readErr bool
 	return
 }
 
+// spliceN copies size bytes between the TCP connections of the client and the broker with ReadFrom, which splices them
+// in the kernel on Linux without copying them to user space. The connections wrapped e.g. by TLS are not spliced, false is returned.
+func spliceN(dst io.Writer, src io.Reader, size int64) (spliced bool, readErr bool, err error) {
+	dstConn, dstMetered := unwrapTCPConn(dst)
+	srcConn, srcMetered := unwrapTCPConn(src)
+	if dstConn == nil || srcConn == nil {
+		return false, false, nil
+	}
+	written, err := dstConn.ReadFrom(io.LimitReader(srcConn, size))
+	// the bytes are not counted by the metered broker connection
+	if dstMetered != nil {
+		dstMetered.sent.Add(float64(written))
+	}
+	if srcMetered != nil {
+		srcMetered.received.Add(float64(written))
+	}
+	if err != nil {
+		// ReadFrom does not report whether the read or the write failed
+		return true, false, err
+	}
+	if written < size {
+		// src stopped early; must have been EOF.
+		return true, true, io.EOF
+	}
+	return true, false, nil
+}
+
+// unwrapTCPConn returns the TCP connection of the client or the broker connection and the metered broker connection
+func unwrapTCPConn(conn interface{}) (*net.TCPConn, *meteredConn) {
+	if c, ok := conn.(*saslConn); ok {
+		conn = c.Conn
+	}
+	metered, ok := conn.(*meteredConn)
+	if ok {
+		conn = metered.Conn
+	}
+	tcpConn, _ := conn.(*net.TCPConn)
+	return tcpConn, metered
+}
+
 func copyError(readDesc, writeDesc string, readErr bool, err error) {
 	var desc string
 	if readErr {
@@ -108,12 +147,24 @@ func copyError(readDesc, writeDesc string, readErr bool, err error) {
 	} else {
 		desc = "Writing data to " + writeDesc
 	}
-	logrus.Infof("%v had error: %s", desc, err.Error())
+	logger.Infof("%v had error: %s", desc, err.Error())
 }
 
 func copyThenClose(cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, brokerAddress string, remoteDesc, localDesc string) {
 
-	processor := newProcessor(cfg, brokerAddress)
+	var clientAddress string
+	var localConn net.Conn
+	if conn, ok := local.(net.Conn); ok {
+		clientAddress = conn.RemoteAddr().String()
+		localConn = conn
+	}
+	processor := newProcessor(cfg, brokerAddress, clientAddress)
+	defer processor.capture.close()
+	processor.metricLabels = newMetricLabelValues(brokerAddress, localConn)
+	processor.connMetadata = cfg.Conns.metadata(localConn)
+	if conn, ok := remote.(*saslConn); ok {
+		processor.upstreamSession = conn.session
+	}
 
 	firstErr := make(chan error, 1)
 
@@ -122,7 +173,7 @@ func copyThenClose(cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, b
 		select {
 		case firstErr <- err:
 			if readErr && err == io.EOF {
-				logrus.Infof("Client closed %v", localDesc)
+				logger.Infof("Client closed %v", localDesc)
 			} else {
 				copyError(localDesc, remoteDesc, readErr, err)
 			}
@@ -136,7 +187,7 @@ func copyThenClose(cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, b
 	select {
 	case firstErr <- err:
 		if readErr && err == io.EOF {
-			logrus.Infof("Server %v closed connection", remoteDesc)
+			logger.Infof("Server %v closed connection", remoteDesc)
 		} else {
 			copyError(remoteDesc, localDesc, readErr, err)
 		}
@@ -150,13 +201,15 @@ func copyThenClose(cfg ProcessorConfig, remote, local DeadlineReadWriteCloser, b
 
 // NewConnSet initializes a new ConnSet and returns it.
 func NewConnSet() *ConnSet {
-	return &ConnSet{m: make(map[string][]net.Conn)}
+	return &ConnSet{m: make(map[string][]net.Conn), info: make(map[net.Conn]*connMetadata)}
 }
 
 // A ConnSet tracks net.Conns associated with a provided ID.
 type ConnSet struct {
 	sync.RWMutex
 	m map[string][]net.Conn
+	// metadata of the connections for the connection listing
+	info map[net.Conn]*connMetadata
 }
 
 // String returns a debug string for the ConnSet.
@@ -180,6 +233,7 @@ func (c *ConnSet) String() string {
 func (c *ConnSet) Add(id string, conn net.Conn) {
 	c.Lock()
 	c.m[id] = append(c.m[id], conn)
+	c.info[conn] = newConnMetadata(id, conn)
 	c.Unlock()
 }
 
@@ -248,6 +302,7 @@ func (c *ConnSet) Remove(id string, conn net.Conn) error {
 	} else {
 		c.m[id] = append(conns[:pos], conns[pos+1:]...)
 	}
+	delete(c.info, conn)
 
 	return nil
 }
@@ -276,7 +331,7 @@ func (c *ConnSet) Close() error {
 func withRecover(fn func()) {
 	defer func() {
 		if err := recover(); err != nil {
-			logrus.Errorf("Recovered from %v", err)
+			logger.Errorf("Recovered from %v", err)
 		}
 	}()
 	fn()
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"testing"
 )
 
@@ -84,6 +86,52 @@ func TestMyCopyN(t *testing.T) {
 
 }
 
+func TestSpliceN(t *testing.T) {
+	a := assert.New(t)
+
+	// the buffers are not spliced
+	spliced, _, _ := spliceN(new(bytes.Buffer), new(bytes.Buffer), 1)
+	a.False(spliced)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	pair := func() (net.Conn, net.Conn) {
+		dialed, err := net.Dial("tcp", l.Addr().String())
+		a.Nil(err)
+		accepted, err := l.Accept()
+		a.Nil(err)
+		return dialed, accepted
+	}
+	writer, src := pair()
+	dst, reader := pair()
+	defer dst.Close()
+	defer reader.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(reader)
+		received <- buf
+	}()
+
+	text := randomString(70000)
+	go func() {
+		writer.Write([]byte(text))
+		writer.Close()
+	}()
+	spliced, readErr, err := spliceN(dst, newMeteredConn(src, "kafka-0:9092"), 65536)
+	a.True(spliced)
+	a.False(readErr)
+	a.Nil(err)
+	// src stopped early
+	spliced, readErr, err = spliceN(dst, src, 8192)
+	a.True(spliced)
+	a.True(readErr)
+	a.Equal(io.EOF, err)
+	src.Close()
+	dst.Close()
+	a.Equal(text, string(<-received))
+}
+
 func randomString(n int) string {
 	var letter = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+)
+
+// compressionCodecs are the codecs the record batches are transcoded to
+var compressionCodecs = map[string]int{
+	"none": protocol.CompressionNone,
+	"gzip": protocol.CompressionGZIP,
+}
+
+// CompressionTranscoding compresses the record batches of the Produce requests with the codec of the brokers and the record
+// batches of the Fetch responses with the codec of the consumers, e.g. for the brokers or the consumers not supporting
+// the codec of the producers during a migration. Only the batches compressed with the supported codecs are decoded,
+// the other batches, the control batches and the older message formats are passed unchanged.
+type CompressionTranscoding struct {
+	// -1 when not transcoded
+	produce int
+	fetch   int
+}
+
+// NewCompressionTranscoding creates the transcoding to the codecs none or gzip, the empty codec is not transcoded
+func NewCompressionTranscoding(produce string, fetch string) (*CompressionTranscoding, error) {
+	t := &CompressionTranscoding{}
+	var err error
+	if t.produce, err = compressionCodec(produce); err != nil {
+		return nil, err
+	}
+	if t.fetch, err = compressionCodec(fetch); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func compressionCodec(name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	codec, ok := compressionCodecs[name]
+	if !ok {
+		return 0, fmt.Errorf("compression %s is not supported, supported are none and gzip", name)
+	}
+	return codec, nil
+}
+
+// FilterRequest implements apis.FrameFilter
+func (t *CompressionTranscoding) FilterRequest(request []byte) ([]byte, error) {
+	if t.produce == -1 || int16(binary.BigEndian.Uint16(request)) != apiKeyProduce {
+		return request, nil
+	}
+	return protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		return t.transcode("produce", t.produce, topic, recordSet)
+	})
+}
+
+// FilterResponse implements apis.FrameFilter
+func (t *CompressionTranscoding) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	switch apiKey {
+	case apiKeyFetch:
+		if t.fetch != -1 {
+			return protocol.ModifyFetchRecordSets(apiVersion, response, func(topic string, recordSet []byte) ([]byte, error) {
+				return t.transcode("fetch", t.fetch, topic, recordSet)
+			})
+		}
+	case apiKeyApiVersions:
+		// clients must not use Produce and Fetch versions whose record sets cannot be modified
+		if err := protocol.LimitApiVersions(apiVersion, response, protocol.MaxRecordSetsVersions); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// transcode compresses the decoded batches of the record set with the codec, the record set is returned unchanged when no batch is transcoded
+func (t *CompressionTranscoding) transcode(api string, codec int, topic string, recordSet []byte) ([]byte, error) {
+	batches, rest, err := protocol.DecodeRecordBatches(recordSet)
+	if err != nil {
+		return nil, err
+	}
+	transcoded := false
+	for _, batch := range batches {
+		if batch.Records == nil {
+			if batch.Magic() == 2 && !batch.Control() && batch.Compression() != codec {
+				logger.Debugf("%s records of topic %s with compression %s cannot be transcoded", api, topic, protocol.CompressionName(batch.Compression()))
+			}
+			continue
+		}
+		if batch.Compression() == codec {
+			// the batch is encoded unchanged
+			batch.Records = nil
+			continue
+		}
+		proxyCompressionTranscodedBatchesTotal.WithLabelValues(api, protocol.CompressionName(batch.Compression()), protocol.CompressionName(codec)).Inc()
+		if err = batch.SetCompression(codec); err != nil {
+			return nil, err
+		}
+		transcoded = true
+	}
+	if !transcoded {
+		return recordSet, nil
+	}
+	return protocol.EncodeRecordBatches(batches, rest), nil
+}
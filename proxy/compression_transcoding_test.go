@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testRecordSetCompression(t *testing.T, recordSet []byte) int {
+	batches, _, err := protocol.DecodeRecordBatches(recordSet)
+	assert.Nil(t, err)
+	assert.Len(t, batches, 1)
+	return batches[0].Compression()
+}
+
+func TestCompressionTranscoding(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewCompressionTranscoding("zstd", "")
+	a.EqualError(err, "compression zstd is not supported, supported are none and gzip")
+
+	transcoding, err := NewCompressionTranscoding("gzip", "none")
+	a.Nil(err)
+
+	// the produced batch is compressed with gzip
+	request, err := transcoding.FilterRequest(testProduceRequest("orders", testRecordSet("v1")))
+	a.Nil(err)
+	var produced []byte
+	_, err = protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		produced = recordSet
+		return recordSet, nil
+	})
+	a.Nil(err)
+	a.Equal(protocol.CompressionGZIP, testRecordSetCompression(t, produced))
+	a.Equal([]string{"v1"}, testRecordValues(t, produced))
+
+	// the request with the batch already compressed with gzip is not changed
+	gzipRequest := testProduceRequest("orders", produced)
+	request, err = transcoding.FilterRequest(gzipRequest)
+	a.Nil(err)
+	a.Equal(gzipRequest, request)
+
+	// the fetched batch is decompressed
+	response, err := transcoding.FilterResponse(apiKeyFetch, 5, testFetchResponse("orders", produced))
+	a.Nil(err)
+	var fetched []byte
+	_, err = protocol.ModifyFetchRecordSets(5, response, func(topic string, recordSet []byte) ([]byte, error) {
+		fetched = recordSet
+		return recordSet, nil
+	})
+	a.Nil(err)
+	a.Equal(protocol.CompressionNone, testRecordSetCompression(t, fetched))
+	a.Equal([]string{"v1"}, testRecordValues(t, fetched))
+
+	// the Fetch responses are not transcoded
+	transcoding, err = NewCompressionTranscoding("none", "")
+	a.Nil(err)
+	fetchResponse := testFetchResponse("orders", produced)
+	response, err = transcoding.FilterResponse(apiKeyFetch, 5, fetchResponse)
+	a.Nil(err)
+	a.Equal(fetchResponse, response)
+}
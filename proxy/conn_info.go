@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connMetadata is the metadata of a client connection, the principal, the client id and the bytes are set by the processor
+type connMetadata struct {
+	broker   string
+	listener string
+	client   string
+	start    time.Time
+
+	mu        sync.Mutex
+	principal string
+	clientID  string
+	// the client id is taken from the first request passed to the broker
+	identified int32
+
+	bytesIn  int64 // requests
+	bytesOut int64 // responses
+	// unix nanoseconds of the last request or response
+	lastActive int64
+
+	// unix nanoseconds until the requests of the connection are traced, 0 when not traced
+	traceUntil int64
+}
+
+func newConnMetadata(broker string, conn net.Conn) *connMetadata {
+	m := &connMetadata{broker: broker, start: time.Now()}
+	m.lastActive = m.start.UnixNano()
+	if conn != nil {
+		if addr := conn.LocalAddr(); addr != nil {
+			m.listener = addr.String()
+		}
+		if addr := conn.RemoteAddr(); addr != nil {
+			m.client = addr.String()
+		}
+	}
+	return m
+}
+
+func (m *connMetadata) setPrincipal(principal string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.principal = principal
+	m.mu.Unlock()
+}
+
+// identifying returns true until the client id is known
+func (m *connMetadata) identifying() bool {
+	return m != nil && atomic.LoadInt32(&m.identified) == 0
+}
+
+func (m *connMetadata) setClientID(clientID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.clientID = clientID
+	m.mu.Unlock()
+	atomic.StoreInt32(&m.identified, 1)
+}
+
+// currentClientID returns the client id of the connection, empty until it is known
+func (m *connMetadata) currentClientID() string {
+	if m == nil {
+		return ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clientID
+}
+
+func (m *connMetadata) addBytesIn(n int64) {
+	if m != nil {
+		atomic.AddInt64(&m.bytesIn, n)
+		atomic.StoreInt64(&m.lastActive, time.Now().UnixNano())
+	}
+}
+
+func (m *connMetadata) addBytesOut(n int64) {
+	if m != nil {
+		atomic.AddInt64(&m.bytesOut, n)
+		atomic.StoreInt64(&m.lastActive, time.Now().UnixNano())
+	}
+}
+
+// idle returns the time since the last request or response of the connection
+func (m *connMetadata) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&m.lastActive)))
+}
+
+func (m *connMetadata) info(now time.Time) ConnInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return ConnInfo{
+		Broker:    m.broker,
+		Listener:  m.listener,
+		Client:    m.client,
+		Principal: m.principal,
+		ClientID:  m.clientID,
+		Start:     m.start,
+		Age:       now.Sub(m.start).Truncate(time.Second).String(),
+		BytesIn:   atomic.LoadInt64(&m.bytesIn),
+		BytesOut:  atomic.LoadInt64(&m.bytesOut),
+	}
+}
+
+// ConnInfo describes a client connection of the connection listing
+type ConnInfo struct {
+	Broker    string    `json:"broker"`
+	Listener  string    `json:"listener"`
+	Client    string    `json:"client"`
+	Principal string    `json:"principal,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Start     time.Time `json:"start"`
+	Age       string    `json:"age"`
+	BytesIn   int64     `json:"bytes_in"`
+	BytesOut  int64     `json:"bytes_out"`
+}
+
+// ConnFilter selects the connections by their attributes, the empty attributes match all connections
+type ConnFilter struct {
+	Broker    string
+	Listener  string
+	Client    string // the address or only the host of the client
+	Principal string
+	ClientID  string
+	OlderThan time.Duration
+}
+
+func (f ConnFilter) matches(info ConnInfo, now time.Time) bool {
+	if f.Broker != "" && f.Broker != info.Broker {
+		return false
+	}
+	if f.Listener != "" && f.Listener != info.Listener {
+		return false
+	}
+	if f.Client != "" && f.Client != info.Client {
+		if host, _, err := net.SplitHostPort(info.Client); err != nil || host != f.Client {
+			return false
+		}
+	}
+	if f.Principal != "" && f.Principal != info.Principal {
+		return false
+	}
+	if f.ClientID != "" && f.ClientID != info.ClientID {
+		return false
+	}
+	return f.OlderThan <= 0 || now.Sub(info.Start) > f.OlderThan
+}
+
+// metadata returns the metadata of the connection, nil when the connection is not in the set
+func (c *ConnSet) metadata(conn net.Conn) *connMetadata {
+	if c == nil || conn == nil {
+		return nil
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.info[conn]
+}
+
+// Search returns the connections matching the filter ordered by their start
+func (c *ConnSet) Search(filter ConnFilter) []ConnInfo {
+	now := time.Now()
+	result := make([]ConnInfo, 0)
+
+	c.RLock()
+	for _, m := range c.info {
+		if info := m.info(now); filter.matches(info, now) {
+			result = append(result, info)
+		}
+	}
+	c.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result
+}
+
+// ServeHTTP returns on GET the connections selected by the query parameters broker, listener, client, principal, client_id and
+// older_than e.g. ?principal=alice&broker=kafka-0:9092&older_than=1h
+func (c *ConnSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseConnFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Search(filter))
+}
+
+// parseConnFilter returns the filter of the query parameters broker, listener, client, principal, client_id and older_than
+func parseConnFilter(query url.Values) (ConnFilter, error) {
+	filter := ConnFilter{
+		Broker:    query.Get("broker"),
+		Listener:  query.Get("listener"),
+		Client:    query.Get("client"),
+		Principal: query.Get("principal"),
+		ClientID:  query.Get("client_id"),
+	}
+	if value := query.Get("older_than"); value != "" {
+		olderThan, err := time.ParseDuration(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid older_than: %v", err)
+		}
+		filter.OlderThan = olderThan
+	}
+	return filter, nil
+}
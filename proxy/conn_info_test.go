@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnSetSearch(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	accept := func() net.Conn {
+		client, err := net.Dial("tcp", l.Addr().String())
+		a.Nil(err)
+		defer client.Close()
+		conn, err := l.Accept()
+		a.Nil(err)
+		return conn
+	}
+	alice, bob := accept(), accept()
+	defer alice.Close()
+	defer bob.Close()
+
+	conns := NewConnSet()
+	conns.Add("kafka-0:9092", alice)
+	conns.Add("kafka-1:9092", bob)
+	a.Nil(conns.metadata(nil))
+
+	metadata := conns.metadata(alice)
+	a.True(metadata.identifying())
+	metadata.setPrincipal("alice")
+	metadata.setClientID("consumer-1")
+	metadata.addBytesIn(100)
+	metadata.addBytesOut(1000)
+	a.False(metadata.identifying())
+	// the connection of alice is older
+	metadata.start = metadata.start.Add(-2 * time.Hour)
+
+	a.Len(conns.Search(ConnFilter{}), 2)
+	found := conns.Search(ConnFilter{Principal: "alice", Broker: "kafka-0:9092", OlderThan: time.Hour})
+	a.Len(found, 1)
+	a.Equal("consumer-1", found[0].ClientID)
+	a.Equal(int64(100), found[0].BytesIn)
+	a.Equal(int64(1000), found[0].BytesOut)
+	a.Equal(l.Addr().String(), found[0].Listener)
+	a.Equal("2h0m0s", found[0].Age)
+
+	a.Empty(conns.Search(ConnFilter{Principal: "alice", Broker: "kafka-1:9092"}))
+	a.Empty(conns.Search(ConnFilter{Broker: "kafka-1:9092", OlderThan: time.Hour}))
+	a.Len(conns.Search(ConnFilter{Client: "127.0.0.1"}), 2)
+	a.Len(conns.Search(ConnFilter{Client: bob.RemoteAddr().String()}), 1)
+
+	server := httptest.NewServer(conns)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "?client_id=consumer-1&older_than=1h")
+	a.Nil(err)
+	var listed []ConnInfo
+	a.Nil(json.NewDecoder(resp.Body).Decode(&listed))
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Len(listed, 1)
+	a.Equal("alice", listed[0].Principal)
+
+	resp, err = http.Get(server.URL + "?older_than=1x")
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Post(server.URL, "application/json", nil)
+	a.Nil(err)
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	a.Nil(conns.Remove("kafka-0:9092", alice))
+	a.Nil(conns.metadata(alice))
+	a.Len(conns.Search(ConnFilter{}), 1)
+}
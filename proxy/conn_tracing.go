@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const defaultTraceDuration = 10 * time.Minute
+
+// TracedConn is a client connection whose requests are traced
+type TracedConn struct {
+	ConnInfo
+	TracedUntil time.Time `json:"traced_until"`
+}
+
+// ConnTracing logs the request and response pairs of the selected client connections with the correlation id, the api key and version,
+// the sizes and the time to the response, so a single client can be traced without the debug logging of all connections.
+// The connections are selected at runtime by the HTTP tracing endpoint, the tracing stops after its duration.
+type ConnTracing struct {
+	conns *ConnSet
+}
+
+// NewConnTracing creates the tracing of the connections of the connection set
+func NewConnTracing(conns *ConnSet) *ConnTracing {
+	return &ConnTracing{conns: conns}
+}
+
+// Start traces the connections matching the filter for the duration, the number of the traced connections is returned
+func (t *ConnTracing) Start(filter ConnFilter, duration time.Duration) int {
+	until := time.Now().Add(duration).UnixNano()
+	n := t.update(filter, func(m *connMetadata) bool {
+		atomic.StoreInt64(&m.traceUntil, until)
+		return true
+	})
+	logger.Infof("Started tracing of %d connections for %v", n, duration)
+	return n
+}
+
+// Stop stops the tracing of the connections matching the filter, the number of the connections which were traced is returned
+func (t *ConnTracing) Stop(filter ConnFilter) int {
+	n := t.update(filter, func(m *connMetadata) bool {
+		return atomic.SwapInt64(&m.traceUntil, 0) != 0
+	})
+	logger.Infof("Stopped tracing of %d connections", n)
+	return n
+}
+
+func (t *ConnTracing) update(filter ConnFilter, fn func(m *connMetadata) bool) int {
+	now := time.Now()
+	n := 0
+	t.conns.RLock()
+	defer t.conns.RUnlock()
+	for _, m := range t.conns.info {
+		if filter.matches(m.info(now), now) && fn(m) {
+			n++
+		}
+	}
+	return n
+}
+
+// Traced returns the traced connections ordered by their start
+func (t *ConnTracing) Traced() []TracedConn {
+	now := time.Now()
+	result := make([]TracedConn, 0)
+	t.conns.RLock()
+	for _, m := range t.conns.info {
+		if m.tracing() {
+			result = append(result, TracedConn{ConnInfo: m.info(now), TracedUntil: time.Unix(0, atomic.LoadInt64(&m.traceUntil))})
+		}
+	}
+	t.conns.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result
+}
+
+// tracing checks whether the requests of the connection are traced
+func (m *connMetadata) tracing() bool {
+	if m == nil {
+		return false
+	}
+	until := atomic.LoadInt64(&m.traceUntil)
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// trace logs the request and its response read from the broker
+func (m *connMetadata) trace(requestKeyVersion *protocol.RequestKeyVersion, responseHeader *protocol.ResponseHeader) {
+	m.mu.Lock()
+	principal, clientID := m.principal, m.clientID
+	m.mu.Unlock()
+	logger.Infof("Trace of client %s (principal '%s', client id '%s') to %s: correlation id %d, %s version %d, request %d bytes, response %d bytes, %v",
+		m.client, principal, clientID, m.broker, responseHeader.CorrelationID, protocol.ApiKeyName(requestKeyVersion.ApiKey), requestKeyVersion.ApiVersion,
+		requestKeyVersion.Length+4, responseHeader.Length+4, time.Since(requestKeyVersion.TraceStart))
+}
+
+// ServeHTTP returns the traced connections on GET, starts the tracing of the connections selected by the query parameters of
+// the connection listing e.g. ?client_id=orders-app&duration=5m on POST or PUT and stops it on DELETE. The tracing of a POST
+// without the duration stops after 10m.
+func (t *ConnTracing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Traced())
+		return
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter, err := parseConnFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var n int
+	if r.Method == http.MethodDelete {
+		n = t.Stop(filter)
+	} else {
+		duration := defaultTraceDuration
+		if value := query.Get("duration"); value != "" {
+			if duration, err = time.ParseDuration(value); err != nil || duration <= 0 {
+				http.Error(w, fmt.Sprintf("invalid duration '%s', it must be a positive duration", value), http.StatusBadRequest)
+				return
+			}
+		}
+		n = t.Start(filter, duration)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"connections": n})
+}
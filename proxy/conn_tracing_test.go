@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnTracing(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	accept := func() net.Conn {
+		client, err := net.Dial("tcp", l.Addr().String())
+		a.Nil(err)
+		defer client.Close()
+		conn, err := l.Accept()
+		a.Nil(err)
+		return conn
+	}
+	alice, bob := accept(), accept()
+	defer alice.Close()
+	defer bob.Close()
+
+	conns := NewConnSet()
+	conns.Add("kafka-0:9092", alice)
+	conns.Add("kafka-0:9092", bob)
+	conns.metadata(alice).setClientID("orders-app")
+	conns.metadata(bob).setClientID("billing-app")
+
+	tracing := NewConnTracing(conns)
+	a.Empty(tracing.Traced())
+	a.Equal(1, tracing.Start(ConnFilter{ClientID: "orders-app"}, time.Minute))
+	a.True(conns.metadata(alice).tracing())
+	a.False(conns.metadata(bob).tracing())
+	traced := tracing.Traced()
+	a.Len(traced, 1)
+	a.Equal("orders-app", traced[0].ClientID)
+
+	// the tracing stops after its duration
+	a.Equal(1, tracing.Start(ConnFilter{ClientID: "billing-app"}, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	a.False(conns.metadata(bob).tracing())
+	a.Len(tracing.Traced(), 1)
+
+	server := httptest.NewServer(tracing)
+	defer server.Close()
+	request := func(method string, query string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+query, nil)
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		return resp
+	}
+	resp := request(http.MethodPost, "?client=127.0.0.1&duration=1h")
+	var updated map[string]int
+	a.Nil(json.NewDecoder(resp.Body).Decode(&updated))
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(2, updated["connections"])
+
+	resp = request(http.MethodGet, "")
+	var listed []TracedConn
+	a.Nil(json.NewDecoder(resp.Body).Decode(&listed))
+	resp.Body.Close()
+	a.Len(listed, 2)
+	a.True(listed[0].TracedUntil.After(time.Now().Add(59 * time.Minute)))
+
+	resp = request(http.MethodDelete, "?client_id=orders-app")
+	a.Nil(json.NewDecoder(resp.Body).Decode(&updated))
+	resp.Body.Close()
+	a.Equal(1, updated["connections"])
+	a.False(conns.metadata(alice).tracing())
+	a.True(conns.metadata(bob).tracing())
+
+	resp = request(http.MethodPost, "?duration=-1m")
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = request(http.MethodPatch, "")
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	a.Equal("GET, POST, PUT, DELETE", resp.Header.Get("Allow"))
+}
+
+func TestDefaultHandlersConnTracing(t *testing.T) {
+	a := assert.New(t)
+
+	metadata := newConnMetadata("kafka-0:9092", nil)
+	atomic.StoreInt64(&metadata.traceUntil, time.Now().Add(time.Minute).UnixNano())
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	nextRequestHandlerChannel := make(chan RequestHandler, 1)
+	nextResponseHandlerChannel := make(chan ResponseHandler, 1)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  nextRequestHandlerChannel,
+		nextResponseHandlerChannel: nextResponseHandlerChannel,
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		connMetadata:               metadata,
+	}
+	handle := func() {
+		client, local := net.Pipe()
+		remote, broker := net.Pipe()
+		go func() {
+			client.Write(testFetchRequest)
+			client.Close()
+		}()
+		go ioutil.ReadAll(broker)
+		_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+		a.Nil(err)
+		remote.Close()
+	}
+	handle()
+	requestKeyVersion := <-openRequestsChannel
+	a.False(requestKeyVersion.TraceStart.IsZero())
+
+	// the connection is not traced after the tracing stops
+	atomic.StoreInt64(&metadata.traceUntil, 0)
+	<-nextRequestHandlerChannel
+	<-nextResponseHandlerChannel
+	handle()
+	a.True((<-openRequestsChannel).TraceStart.IsZero())
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	connectionLimitPrincipal = "principal"
+	connectionLimitTenant    = "tenant"
+)
+
+// ConnectionLimits limit the concurrent connections of the principals authenticated by local SASL
+// and of their tenants, the principals with the same topic prefix. The connection over the limit fails the authentication.
+type ConnectionLimits struct {
+	perPrincipal  int // 0 - unlimited
+	principals    map[string]int
+	perTenant     int // 0 - unlimited
+	topicPrefixes *TopicPrefixes
+
+	lock                 sync.Mutex
+	principalConnections map[string]int
+	tenantConnections    map[string]int
+}
+
+// NewConnectionLimits creates the limits of the principals, the limits of the principals given as principal=limit replace the default limit.
+// The tenant of a principal is its topic prefix, the principals without a prefix have no tenant limit.
+func NewConnectionLimits(perPrincipal int, principals []string, perTenant int, topicPrefixes *TopicPrefixes) (*ConnectionLimits, error) {
+	l := &ConnectionLimits{
+		perPrincipal:         perPrincipal,
+		principals:           make(map[string]int),
+		perTenant:            perTenant,
+		topicPrefixes:        topicPrefixes,
+		principalConnections: make(map[string]int),
+		tenantConnections:    make(map[string]int),
+	}
+	for _, value := range principals {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("connection limit '%s' must be principal=limit", value)
+		}
+		limit, err := strconv.Atoi(pair[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("connection limit '%s' must be a number greater or equal 0", value)
+		}
+		l.principals[pair[0]] = limit
+	}
+	return l, nil
+}
+
+func (l *ConnectionLimits) principalLimit(principal string) int {
+	if limit, ok := l.principals[principal]; ok {
+		return limit
+	}
+	return l.perPrincipal
+}
+
+func (l *ConnectionLimits) tenant(principal string) string {
+	if !l.topicPrefixes.enabled() {
+		return ""
+	}
+	return l.topicPrefixes.prefix(principal)
+}
+
+// acquire counts the connection of the principal, an error is returned when the principal or its tenant has reached the limit
+func (l *ConnectionLimits) acquire(principal string) error {
+	if l == nil {
+		return nil
+	}
+	tenant := l.tenant(principal)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if limit := l.principalLimit(principal); limit > 0 && l.principalConnections[principal] >= limit {
+		proxyConnectionLimitRejectionsTotal.WithLabelValues(connectionLimitPrincipal).Inc()
+		return fmt.Errorf("connection limit %d of principal %s is reached", limit, principal)
+	}
+	if tenant != "" && l.perTenant > 0 && l.tenantConnections[tenant] >= l.perTenant {
+		proxyConnectionLimitRejectionsTotal.WithLabelValues(connectionLimitTenant).Inc()
+		return fmt.Errorf("connection limit %d of tenant %s is reached", l.perTenant, tenant)
+	}
+	l.principalConnections[principal]++
+	proxyPrincipalConnections.WithLabelValues(principal).Inc()
+	if tenant != "" {
+		l.tenantConnections[tenant]++
+		proxyTenantConnections.WithLabelValues(tenant).Inc()
+	}
+	return nil
+}
+
+// release counts the closed connection of the principal
+func (l *ConnectionLimits) release(principal string) {
+	if l == nil {
+		return
+	}
+	tenant := l.tenant(principal)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.principalConnections[principal]--; l.principalConnections[principal] <= 0 {
+		delete(l.principalConnections, principal)
+	}
+	proxyPrincipalConnections.WithLabelValues(principal).Dec()
+	if tenant != "" {
+		if l.tenantConnections[tenant]--; l.tenantConnections[tenant] <= 0 {
+			delete(l.tenantConnections, tenant)
+		}
+		proxyTenantConnections.WithLabelValues(tenant).Dec()
+	}
+}
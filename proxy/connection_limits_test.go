@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConnectionLimitsPrincipal(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewConnectionLimits(1, []string{"alice"}, 0, nil)
+	a.EqualError(err, "connection limit 'alice' must be principal=limit")
+	_, err = NewConnectionLimits(1, []string{"alice=-1"}, 0, nil)
+	a.EqualError(err, "connection limit 'alice=-1' must be a number greater or equal 0")
+
+	l, err := NewConnectionLimits(1, []string{"limit-alice=2", "limit-admin=0"}, 0, nil)
+	a.Nil(err)
+
+	a.Nil(l.acquire("limit-bob"))
+	a.EqualError(l.acquire("limit-bob"), "connection limit 1 of principal limit-bob is reached")
+	l.release("limit-bob")
+	a.Nil(l.acquire("limit-bob"))
+
+	a.Nil(l.acquire("limit-alice"))
+	a.Nil(l.acquire("limit-alice"))
+	a.EqualError(l.acquire("limit-alice"), "connection limit 2 of principal limit-alice is reached")
+
+	// unlimited
+	for i := 0; i < 3; i++ {
+		a.Nil(l.acquire("limit-admin"))
+	}
+
+	m := &dto.Metric{}
+	a.Nil(proxyPrincipalConnections.WithLabelValues("limit-alice").Write(m))
+	a.Equal(float64(2), m.GetGauge().GetValue())
+	l.release("limit-alice")
+	l.release("limit-alice")
+	a.Nil(proxyPrincipalConnections.WithLabelValues("limit-alice").Write(m))
+	a.Equal(float64(0), m.GetGauge().GetValue())
+	a.Empty(l.principalConnections["limit-alice"])
+
+	// no limits
+	var nilLimits *ConnectionLimits
+	a.Nil(nilLimits.acquire("limit-bob"))
+	nilLimits.release("limit-bob")
+}
+
+func TestConnectionLimitsTenant(t *testing.T) {
+	a := assert.New(t)
+
+	topicPrefixes, err := NewTopicPrefixes("", []string{"tenant-alice=tenant-a.", "tenant-bob=tenant-a.", "tenant-carol=tenant-c."}, false)
+	a.Nil(err)
+	l, err := NewConnectionLimits(0, nil, 2, topicPrefixes)
+	a.Nil(err)
+
+	connections := testGaugeValue(a, proxyTenantConnections.WithLabelValues("tenant-a."))
+	a.Nil(l.acquire("tenant-alice"))
+	a.Nil(l.acquire("tenant-bob"))
+	a.EqualError(l.acquire("tenant-alice"), "connection limit 2 of tenant tenant-a. is reached")
+	a.Nil(l.acquire("tenant-carol"))
+	// principals without a topic prefix have no tenant
+	for i := 0; i < 3; i++ {
+		a.Nil(l.acquire("tenant-dave"))
+	}
+
+	a.Equal(connections+2, testGaugeValue(a, proxyTenantConnections.WithLabelValues("tenant-a.")))
+
+	l.release("tenant-bob")
+	a.Nil(l.acquire("tenant-alice"))
+}
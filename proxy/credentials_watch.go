@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+)
+
+// credentialsGeneration is incremented when the watched credentials of the upstream change,
+// the connections to the brokers authenticated with an older generation are re-authenticated before the next request
+type credentialsGeneration struct {
+	value uint64
+}
+
+// get returns 0 for the nil generation of the credentials which are not watched
+func (g *credentialsGeneration) get() uint64 {
+	if g == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&g.value)
+}
+
+func (g *credentialsGeneration) inc() {
+	atomic.AddUint64(&g.value, 1)
+}
+
+// watchCredentials builds the TLS config of the dialer again when the client certificate, key or CA files change and reads
+// the SASL credentials of the JAAS config file again when it changes. The connections are re-authenticated with the changed credentials.
+func (u *upstream) watchCredentials(tlsConfig *tls.Config) error {
+	u.generation = &credentialsGeneration{}
+	u.done = make(chan bool)
+
+	if dialer, ok := u.dialer.(tlsDialer); ok {
+		dialer.reloader = newTLSConfigReloader("broker", tlsConfig, func() (*tls.Config, error) {
+			return newTLSClientConfig(u.config)
+		})
+		u.dialer = dialer
+		opts := u.config.Kafka.TLS
+		if err := watchCredentialFiles([]string{opts.ClientCertFile, opts.ClientKeyFile, opts.CAChainCertFile}, u.done, func() { dialer.reloader.reload() }); err != nil {
+			return err
+		}
+	}
+	var credentialsProvider saslCredentialsProvider
+	switch auth := u.saslAuth.(type) {
+	case *SASLPlainAuth:
+		credentialsProvider = auth.credentialsProvider
+	case *SASLSCRAMAuth:
+		credentialsProvider = auth.credentialsProvider
+	}
+	if jaasCredentials, ok := credentialsProvider.(*jaasFileCredentials); ok {
+		return watchCredentialFiles([]string{jaasCredentials.file}, u.done, func() {
+			if jaasCredentials.reload() == nil {
+				logger.Infof("reloaded SASL credentials from JAAS config file %s, the broker connections are re-authenticated", jaasCredentials.file)
+				emitEvent(Event{Type: EventConfigReloaded, Message: "JAAS config file " + jaasCredentials.file})
+				u.generation.inc()
+			}
+		})
+	}
+	return nil
+}
+
+// tlsConfigReloader holds the TLS config which is built again when its certificate, key or CA files change.
+// The previous config is kept when the changed files are invalid e.g. the certificate is replaced before the key.
+type tlsConfigReloader struct {
+	side string
+	load func() (*tls.Config, error)
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+func newTLSConfigReloader(side string, config *tls.Config, load func() (*tls.Config, error)) *tlsConfigReloader {
+	return &tlsConfigReloader{side: side, config: config, load: load}
+}
+
+func (r *tlsConfigReloader) get() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+func (r *tlsConfigReloader) reload() error {
+	config, err := r.load()
+	if err != nil {
+		logger.Errorf("error while reloading %s TLS config, the previous config is used: %v", r.side, err)
+		return err
+	}
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+	logger.Infof("reloaded %s TLS config", r.side)
+	emitEvent(Event{Type: EventConfigReloaded, Message: r.side + " TLS config"})
+	return nil
+}
+
+// getConfigForClient implements tls.Config.GetConfigForClient of the listener
+func (r *tlsConfigReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.get(), nil
+}
+
+// jaasFileCredentials are the SASL username and password of the JAAS config file, they are read again when the file changes
+type jaasFileCredentials struct {
+	file string
+
+	mu       sync.RWMutex
+	username string
+	password string
+}
+
+func newJaasFileCredentials(file string) (*jaasFileCredentials, error) {
+	c := &jaasFileCredentials{file: file}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *jaasFileCredentials) reload() error {
+	credentials, err := config.NewJaasCredentialFromFile(c.file)
+	if err != nil {
+		logger.Errorf("error while reloading JAAS config file %s, the previous credentials are used: %v", c.file, err)
+		return err
+	}
+	c.mu.Lock()
+	c.username, c.password = credentials.Username, credentials.Password
+	c.mu.Unlock()
+	return nil
+}
+
+// credentials implements saslCredentialsProvider
+func (c *jaasFileCredentials) credentials() (string, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.username, c.password, nil
+}
+
+// watchCredentialFiles calls the action when one of the files changes, the Kubernetes Secret and ConfigMap volumes are replaced by a symlink swap
+func watchCredentialFiles(files []string, done <-chan bool, action func()) error {
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if err := util.WatchForUpdates(file, done, action); err != nil {
+			return errors.Wrapf(err, "cannot watch credentials file %s", file)
+		}
+	}
+	return nil
+}
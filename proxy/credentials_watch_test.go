@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTLSConfigReloader(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	rotated := NewCertsBundle()
+	defer rotated.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+
+	load := func() (*tls.Config, error) {
+		return newTLSListenerConfig(c, nil)
+	}
+	tlsConfig, err := load()
+	a.Nil(err)
+	reloader := newTLSConfigReloader("listener", tlsConfig, load)
+
+	// the certificate is replaced before the key
+	certPEM, err := ioutil.ReadFile(rotated.ServerCert.Name())
+	a.Nil(err)
+	a.Nil(ioutil.WriteFile(c.Proxy.TLS.ListenerCertFile, certPEM, 0600))
+	a.NotNil(reloader.reload())
+	a.Equal(tlsConfig, reloader.get())
+
+	keyPEM, err := ioutil.ReadFile(rotated.ServerKey.Name())
+	a.Nil(err)
+	a.Nil(ioutil.WriteFile(c.Proxy.TLS.ListenerKeyFile, keyPEM, 0600))
+	a.Nil(reloader.reload())
+	a.NotEqual(tlsConfig, reloader.get())
+
+	serverConfig, err := reloader.getConfigForClient(nil)
+	a.Nil(err)
+	a.Equal(reloader.get(), serverConfig)
+}
+
+func TestJaasFileCredentialsReload(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "jaas")
+	a.Nil(err)
+	defer os.Remove(file.Name())
+	a.Nil(ioutil.WriteFile(file.Name(), []byte(`org.apache.kafka.common.security.plain.PlainLoginModule required username="alice" password="alice-secret";`), 0600))
+
+	credentials, err := newJaasFileCredentials(file.Name())
+	a.Nil(err)
+	username, password, err := credentials.credentials()
+	a.Nil(err)
+	a.Equal("alice", username)
+	a.Equal("alice-secret", password)
+
+	// the previous credentials are kept when the file is invalid
+	a.Nil(ioutil.WriteFile(file.Name(), []byte(`org.apache.kafka.common.security.plain.PlainLoginModule required;`), 0600))
+	a.NotNil(credentials.reload())
+	username, password, _ = credentials.credentials()
+	a.Equal("alice", username)
+	a.Equal("alice-secret", password)
+
+	a.Nil(ioutil.WriteFile(file.Name(), []byte(`org.apache.kafka.common.security.plain.PlainLoginModule required username="alice" password="rotated-secret";`), 0600))
+	a.Nil(credentials.reload())
+	username, password, _ = credentials.credentials()
+	a.Equal("alice", username)
+	a.Equal("rotated-secret", password)
+}
+
+func TestUpstreamSASLSessionCredentialsGeneration(t *testing.T) {
+	a := assert.New(t)
+
+	var notWatched *credentialsGeneration
+	a.Equal(uint64(0), notWatched.get())
+	a.False(newUpstreamSASLSession(&SASLPlainAuth{}, "proxy", 0, nil, 0).expiring())
+
+	generation := &credentialsGeneration{}
+	session := newUpstreamSASLSession(&SASLPlainAuth{}, "proxy", 0, generation, generation.get())
+	a.False(session.expiring())
+
+	// the credentials have changed
+	generation.inc()
+	a.True(session.expiring())
+	session.authenticatedGeneration = generation.get()
+	a.False(session.expiring())
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DelegationTokenProvider obtains a delegation token with the credentials of the principal and renews it periodically,
+// the connections to the brokers are authenticated with the token instead of the credentials.
+// A token that cannot be renewed is dropped and a new token is created for the next connection.
+type DelegationTokenProvider struct {
+	// dial connects to the broker authenticated with the credentials of the principal
+	dial             func(brokerAddress string) (net.Conn, error)
+	bootstrapServers []string
+	clientID         string
+	timeout          time.Duration
+
+	maxLifetime   time.Duration // 0 is the broker default
+	renewInterval time.Duration
+
+	tokenID string
+	hmac    []byte
+	lock    sync.Mutex
+
+	stopRenew chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewDelegationTokenProvider creates the provider, Run must be called to renew the tokens
+func NewDelegationTokenProvider(dial func(brokerAddress string) (net.Conn, error), bootstrapServers []string, clientID string, maxLifetime time.Duration, renewInterval time.Duration, timeout time.Duration) *DelegationTokenProvider {
+	return &DelegationTokenProvider{
+		dial:             dial,
+		bootstrapServers: bootstrapServers,
+		clientID:         clientID,
+		timeout:          timeout,
+		maxLifetime:      maxLifetime,
+		renewInterval:    renewInterval,
+		stopRenew:        make(chan struct{}),
+	}
+}
+
+// credentials returns the token id and the password of the token, the token is created when there is none
+func (p *DelegationTokenProvider) credentials() (string, string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.tokenID == "" {
+		if err := p.createToken(); err != nil {
+			return "", "", errors.Wrap(err, "delegation token cannot be created")
+		}
+	}
+	return p.tokenID, base64.StdEncoding.EncodeToString(p.hmac), nil
+}
+
+func (p *DelegationTokenProvider) createToken() error {
+	request := &protocol.CreateDelegationTokenRequestV1{MaxLifetimeMs: -1}
+	if p.maxLifetime > 0 {
+		request.MaxLifetimeMs = int64(p.maxLifetime / time.Millisecond)
+	}
+	payload, err := p.roundTrip(request)
+	if err != nil {
+		return err
+	}
+	response := &protocol.CreateDelegationTokenResponseV1{}
+	if err = protocol.Decode(payload, response); err != nil {
+		return err
+	}
+	if response.Err != protocol.ErrNoError {
+		return response.Err
+	}
+	p.tokenID = response.TokenID
+	p.hmac = response.HMAC
+	logger.Infof("Delegation token %s of %s:%s was created, it expires at %v", p.tokenID, response.PrincipalType, response.PrincipalName, timestampMs(response.ExpiryTimestampMs))
+	return nil
+}
+
+func (p *DelegationTokenProvider) renewToken() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.tokenID == "" {
+		return
+	}
+	err := func() error {
+		payload, err := p.roundTrip(&protocol.RenewDelegationTokenRequestV1{HMAC: p.hmac, RenewPeriodMs: -1})
+		if err != nil {
+			return err
+		}
+		response := &protocol.RenewDelegationTokenResponseV1{}
+		if err = protocol.Decode(payload, response); err != nil {
+			return err
+		}
+		if response.Err != protocol.ErrNoError {
+			return response.Err
+		}
+		logger.Debugf("Delegation token %s was renewed, it expires at %v", p.tokenID, timestampMs(response.ExpiryTimestampMs))
+		return nil
+	}()
+	if err != nil {
+		// e.g. the max lifetime of the token is reached
+		logger.Warnf("Delegation token %s cannot be renewed, a new token will be created: %v", p.tokenID, err)
+		p.tokenID = ""
+		p.hmac = nil
+	}
+}
+
+// roundTrip sends the request to a bootstrap server and returns the response payload without the header
+func (p *DelegationTokenProvider) roundTrip(body protocol.ProtocolBody) (payload []byte, err error) {
+	for _, brokerAddress := range p.bootstrapServers {
+		if payload, err = p.send(brokerAddress, body); err == nil {
+			return payload, nil
+		}
+		logger.Infof("delegation token request to %s failed: %v", brokerAddress, err)
+	}
+	return nil, err
+}
+
+func (p *DelegationTokenProvider) send(brokerAddress string, body protocol.ProtocolBody) ([]byte, error) {
+	conn, err := p.dial(brokerAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return sendAndReceive(conn, &protocol.Request{ClientID: p.clientID, Body: body}, p.timeout, p.timeout)
+}
+
+// Run renews the token until Close is called
+func (p *DelegationTokenProvider) Run() {
+	ticker := time.NewTicker(p.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.renewToken()
+		case <-p.stopRenew:
+			return
+		}
+	}
+}
+
+func (p *DelegationTokenProvider) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stopRenew)
+	})
+}
+
+func timestampMs(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testDelegationTokenDial answers the CreateDelegationToken and RenewDelegationToken requests with the responses and records the api keys
+func testDelegationTokenDial(responses map[int16][]byte, apiKeys chan<- int16) func(string) (net.Conn, error) {
+	return func(brokerAddress string) (net.Conn, error) {
+		if brokerAddress != "kafka-0:9092" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			sizeBuf := make([]byte, 4)
+			if _, err := io.ReadFull(server, sizeBuf); err != nil {
+				return
+			}
+			request := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+			if _, err := io.ReadFull(server, request); err != nil {
+				return
+			}
+			apiKey := int16(binary.BigEndian.Uint16(request))
+			apiKeys <- apiKey
+			response := responses[apiKey]
+			header := make([]byte, 8)
+			binary.BigEndian.PutUint32(header, uint32(len(response)+4))
+			copy(header[4:], request[4:8])
+			server.Write(append(header, response...))
+		}()
+		return client, nil
+	}
+}
+
+func TestDelegationTokenProvider(t *testing.T) {
+	a := assert.New(t)
+
+	created, _ := protocol.Encode(&protocol.CreateDelegationTokenResponseV1{Err: protocol.ErrNoError, PrincipalType: "User", PrincipalName: "alice", TokenID: "token-1", HMAC: []byte("hmac")})
+	renewed, _ := protocol.Encode(&protocol.RenewDelegationTokenResponseV1{Err: protocol.ErrNoError})
+	responses := map[int16][]byte{38: created, 39: renewed}
+	apiKeys := make(chan int16, 10)
+	p := NewDelegationTokenProvider(testDelegationTokenDial(responses, apiKeys), []string{"kafka-1:9092", "kafka-0:9092"}, "test", time.Hour, time.Hour, time.Second)
+
+	username, password, err := p.credentials()
+	a.Nil(err)
+	a.Equal("token-1", username)
+	a.Equal("aG1hYw==", password)
+	a.Equal(int16(38), <-apiKeys)
+
+	// the token is created once
+	_, _, err = p.credentials()
+	a.Nil(err)
+	p.renewToken()
+	a.Equal(int16(39), <-apiKeys)
+	a.Equal("token-1", p.tokenID)
+
+	// the expired token is dropped and a new token is created
+	responses[39], _ = protocol.Encode(&protocol.RenewDelegationTokenResponseV1{Err: protocol.ErrDelegationTokenExpired})
+	responses[38], _ = protocol.Encode(&protocol.CreateDelegationTokenResponseV1{Err: protocol.ErrNoError, TokenID: "token-2", HMAC: []byte("hmac")})
+	p.renewToken()
+	a.Equal(int16(39), <-apiKeys)
+	a.Equal("", p.tokenID)
+	username, _, err = p.credentials()
+	a.Nil(err)
+	a.Equal("token-2", username)
+	a.Equal(int16(38), <-apiKeys)
+
+	// the creation of the token is not allowed
+	p.tokenID = ""
+	responses[38], _ = protocol.Encode(&protocol.CreateDelegationTokenResponseV1{Err: protocol.ErrDelegationTokenRequestNotAllowed})
+	_, _, err = p.credentials()
+	a.EqualError(err, "delegation token cannot be created: "+protocol.ErrDelegationTokenRequestNotAllowed.Error())
+	a.Len(apiKeys, 1)
+}
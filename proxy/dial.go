@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"golang.org/x/net/proxy"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,17 +20,60 @@ type Dialer interface {
 	Dial(network, addr string) (c net.Conn, err error)
 }
 
+// stages of the broker connection setup
+const (
+	dialStageTCP         = "tcp_dial"
+	dialStageTLS         = "tls_handshake"
+	dialStageGatewayAuth = "gateway_auth"
+	dialStageSASL        = "sasl"
+	dialStageClusterID   = "cluster_id"
+)
+
+// dialStageError is the failure of a connection setup stage
+type dialStageError struct {
+	stage string
+	err   error
+}
+
+func (e *dialStageError) Error() string {
+	return e.err.Error()
+}
+
+// dialStage returns the stage of the failed connection setup, the errors without a stage are failures of the TCP dial
+func dialStage(err error) string {
+	if e, ok := errors.Cause(err).(*dialStageError); ok {
+		return e.stage
+	}
+	return dialStageTCP
+}
+
 type directDialer struct {
-	dialTimeout time.Duration
-	keepAlive   time.Duration
+	dialTimeout   time.Duration
+	keepAlive     time.Duration
+	fallbackDelay time.Duration
+	resolver      *dnsResolver
+	source        sourceAddress
 }
 
 func (d directDialer) Dial(network, addr string) (net.Conn, error) {
 	dialer := net.Dialer{
-		Timeout:   d.dialTimeout,
-		KeepAlive: d.keepAlive,
+		Timeout:       d.dialTimeout,
+		KeepAlive:     d.keepAlive,
+		FallbackDelay: d.fallbackDelay,
+	}
+	localAddr, err := d.source.localAddr()
+	if err != nil {
+		return nil, err
+	}
+	if localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+	var conn net.Conn
+	if d.resolver != nil {
+		conn, err = d.resolver.dial(dialer, network, addr)
+	} else {
+		conn, err = dialer.Dial(network, addr)
 	}
-	conn, err := dialer.Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -40,10 +85,94 @@ func (d directDialer) Dial(network, addr string) (net.Conn, error) {
 	return conn, err
 }
 
+// sourceAddress is the local IP or the name of the network interface the connections are dialed from, the system chooses it when empty
+type sourceAddress string
+
+// localAddr returns the local address of the dialer. The interface addresses are looked up on every dial, its first IPv4 address
+// is preferred over the IPv6 ones and the link-local addresses are not used.
+func (s sourceAddress) localAddr() (*net.TCPAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(string(s)); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	iface, err := net.InterfaceByName(string(s))
+	if err != nil {
+		return nil, errors.Wrapf(err, "source interface %s", s)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "addresses of source interface %s", s)
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("source interface %s has no IP address", s)
+	}
+	return &net.TCPAddr{IP: ipv6}, nil
+}
+
+// closeDialer stops background tasks of the dialer
+func closeDialer(dialer Dialer) {
+	switch d := dialer.(type) {
+	case tlsDialer:
+		closeDialer(d.rawDialer)
+	case *failoverDialer:
+		d.Close()
+	case *tunnelDialer:
+		d.Close()
+	}
+}
+
+// forwardProxyError is returned when the forward proxy itself cannot be reached (TCP or TLS connect failure).
+// Rejections of the upstream address by a reachable proxy are returned as they are.
+type forwardProxyError struct {
+	err error
+}
+
+func (e forwardProxyError) Error() string {
+	return e.err.Error()
+}
+
+func isForwardProxyError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	_, ok := err.(forwardProxyError)
+	return ok
+}
+
+// forwardProxyConnectDialer marks errors of connecting to the forward proxy
+type forwardProxyConnectDialer struct {
+	dialer Dialer
+}
+
+func (d forwardProxyConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, forwardProxyError{err: err}
+	}
+	return conn, nil
+}
+
 type socks5Dialer struct {
 	directDialer            directDialer
 	proxyNetwork, proxyAddr string
 	username, password      string
+	// the host names are resolved by the proxy and the IPs are sent to the SOCKS5 server, which resolves them otherwise
+	localResolve bool
 }
 
 func (d socks5Dialer) Dial(network, addr string) (net.Conn, error) {
@@ -57,10 +186,13 @@ func (d socks5Dialer) Dial(network, addr string) (net.Conn, error) {
 			Password: d.password,
 		}
 	}
-	socks5Dialer, err := proxy.SOCKS5(d.proxyNetwork, d.proxyAddr, auth, d.directDialer)
+	socks5Dialer, err := proxy.SOCKS5(d.proxyNetwork, d.proxyAddr, auth, forwardProxyConnectDialer{dialer: d.directDialer})
 	if err != nil {
 		return nil, err
 	}
+	if d.localResolve {
+		return d.dialResolved(socks5Dialer, network, addr)
+	}
 	conn, err := socks5Dialer.Dial(network, addr)
 	if err != nil {
 		return nil, err
@@ -68,10 +200,44 @@ func (d socks5Dialer) Dial(network, addr string) (net.Conn, error) {
 	return conn, nil
 }
 
+// dialResolved connects to the resolved addresses of the host in order until one of them succeeds, the host name is not sent
+// to the SOCKS5 server. The failures to connect to the SOCKS5 server itself are not retried.
+func (d socks5Dialer) dialResolved(socks5Dialer proxy.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return socks5Dialer.Dial(network, addr)
+	}
+	var ips []string
+	if d.directDialer.resolver != nil {
+		ips, err = d.directDialer.resolver.resolve(host)
+	} else {
+		ips, err = net.LookupHost(host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for host %s", host)
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = socks5Dialer.Dial(network, net.JoinHostPort(ip, port))
+		if err == nil || isForwardProxyError(err) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
 type tlsDialer struct {
 	timeout   time.Duration
 	rawDialer Dialer
 	config    *tls.Config
+	// when set, the config of the reloader is used instead of config
+	reloader *tlsConfigReloader
 }
 
 // see tls.DialWithDialer
@@ -100,13 +266,15 @@ func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
 		return nil, err
 	}
 
-	colonPos := strings.LastIndex(addr, ":")
-	if colonPos == -1 {
-		colonPos = len(addr)
+	hostname, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		hostname = addr
 	}
-	hostname := addr[:colonPos]
 
 	config := d.config
+	if d.reloader != nil {
+		config = d.reloader.get()
+	}
 
 	// If no ServerName is set, infer the ServerName
 	// from the hostname we're connecting to.
@@ -131,7 +299,7 @@ func (d tlsDialer) Dial(network, addr string) (net.Conn, error) {
 
 	if err != nil {
 		rawConn.Close()
-		return nil, err
+		return nil, &dialStageError{stage: dialStageTLS, err: err}
 	}
 
 	return conn, nil
@@ -142,9 +310,58 @@ type httpProxy struct {
 	network            string
 	hostPort           string
 	username, password string
+	// authentication scheme of the credentials: basic, ntlm or negotiate
+	auth string
+	// the Kerberos service tickets of the negotiate authentication
+	kerberos *forwardProxyKerberos
 }
 
 func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
+	c, err := s.forwardDialer.Dial(s.network, s.hostPort)
+	if err != nil {
+		return nil, forwardProxyError{err: err}
+	}
+	br := bufio.NewReader(c)
+	var resp *http.Response
+	if s.auth == "negotiate" && s.kerberos != nil {
+		var rejected bool
+		if resp, rejected, err = s.connectNegotiate(c, br, addr); rejected && s.username != "" && s.password != "" {
+			logger.Infof("Kerberos authentication to the forward proxy %s failed, falling back to NTLM: %v", s.hostPort, err)
+			if resp != nil {
+				// NTLM is tried on a new proxy connection after the rejected CONNECT request
+				resp.Body.Close()
+				c.Close()
+				if c, err = s.forwardDialer.Dial(s.network, s.hostPort); err != nil {
+					return nil, forwardProxyError{err: err}
+				}
+				br = bufio.NewReader(c)
+			}
+			resp, err = s.connectNTLM(c, br, addr)
+		}
+	} else if s.username != "" && s.password != "" && s.auth == "ntlm" {
+		resp, err = s.connectNTLM(c, br, addr)
+	} else {
+		var authorization string
+		if s.username != "" && s.password != "" {
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(s.username+":"+s.password))
+		}
+		resp, err = s.connect(c, br, addr, authorization)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		c.Close()
+		return nil, fmt.Errorf("connect server using proxy error, statuscode [%d]", resp.StatusCode)
+	}
+
+	return c, nil
+}
+
+// connect sends the CONNECT request with the optional Proxy-Authorization header and reads the response
+func (s *httpProxy) connect(c net.Conn, br *bufio.Reader, addr string, authorization string) (*http.Response, error) {
 	reqURL, err := url.Parse("http://" + addr)
 	if err != nil {
 		return nil, err
@@ -154,31 +371,69 @@ func (s *httpProxy) Dial(network, addr string) (net.Conn, error) {
 		return nil, err
 	}
 	req.Close = false
-	if s.username != "" && s.password != "" {
-		basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(s.username+":"+s.password))
-		req.Header.Set("Proxy-Authorization", basic)
+	if authorization != "" {
+		req.Header.Set("Proxy-Authorization", authorization)
 	}
+	if err = req.Write(c); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(br, req)
+}
 
-	c, err := s.forwardDialer.Dial(s.network, s.hostPort)
+// connectNegotiate authenticates the CONNECT request with the SPNEGO token of the Kerberos service ticket of the proxy in the Negotiate
+// scheme. It returns rejected when the ticket cannot be obtained or the proxy does not accept it.
+func (s *httpProxy) connectNegotiate(c net.Conn, br *bufio.Reader, addr string) (resp *http.Response, rejected bool, err error) {
+	token, err := s.kerberos.token()
+	if err != nil {
+		return nil, true, fmt.Errorf("kerberos service ticket of %s: %v", s.kerberos.spn, err)
+	}
+	if resp, err = s.connect(c, br, addr, "Negotiate "+token); err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return resp, true, fmt.Errorf("proxy rejected the Kerberos service ticket of %s", s.kerberos.spn)
+	}
+	return resp, false, nil
+}
+
+// connectNTLM authenticates the CONNECT request with the NTLM handshake. The challenge and the authentication must use the same
+// proxy connection, which is then tunneled.
+func (s *httpProxy) connectNTLM(c net.Conn, br *bufio.Reader, addr string) (*http.Response, error) {
+	resp, err := s.connect(c, br, addr, "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
 	if err != nil {
 		return nil, err
 	}
-	err = req.Write(c)
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		return resp, nil
+	}
+	// the body is read to the end so the next response can be read from the connection
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		c.Close()
 		return nil, err
 	}
-
-	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if resp.Close {
+		return nil, fmt.Errorf("proxy closed the connection after the NTLM challenge")
+	}
+	var challengeMessage []byte
+	for _, value := range resp.Header["Proxy-Authenticate"] {
+		if token := strings.TrimPrefix(value, "NTLM "); token != value {
+			if challengeMessage, err = base64.StdEncoding.DecodeString(strings.TrimSpace(token)); err != nil {
+				return nil, fmt.Errorf("invalid NTLM challenge: %v", err)
+			}
+			break
+		}
+	}
+	if challengeMessage == nil {
+		return nil, fmt.Errorf("proxy did not send the NTLM challenge, statuscode [%d]", resp.StatusCode)
+	}
+	challenge, err := parseNTLMChallenge(challengeMessage)
 	if err != nil {
-		c.Close()
 		return nil, err
 	}
-	resp.Body.Close()
-	if resp.StatusCode != 200 {
-		c.Close()
-		return nil, fmt.Errorf("connect server using proxy error, statuscode [%d]", resp.StatusCode)
+	authenticateMessage, err := ntlmAuthenticateMessage(s.username, s.password, challenge)
+	if err != nil {
+		return nil, err
 	}
-
-	return c, nil
+	return s.connect(c, br, addr, "NTLM "+base64.StdEncoding.EncodeToString(authenticateMessage))
 }
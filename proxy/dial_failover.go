@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type forwardProxyDialer struct {
+	address string
+	dialer  Dialer
+	// healthCheckDialer connects to the forward proxy address, for https proxies the TLS handshake is completed
+	healthCheckDialer Dialer
+}
+
+// failoverDialer dials through the first healthy forward proxy. Proxies are ordered by priority:
+// when a preferred proxy becomes healthy again, new connections fail back to it.
+type failoverDialer struct {
+	forwardDialers []forwardProxyDialer
+
+	healthy []bool
+	active  int
+	lock    sync.RWMutex
+
+	stopHealthCheck chan struct{}
+	stopOnce        sync.Once
+}
+
+func newFailoverDialer(forwardDialers []forwardProxyDialer, healthCheckInterval time.Duration) *failoverDialer {
+	healthy := make([]bool, len(forwardDialers))
+	for i := range healthy {
+		healthy[i] = true
+	}
+	d := &failoverDialer{
+		forwardDialers:  forwardDialers,
+		healthy:         healthy,
+		active:          -1,
+		stopHealthCheck: make(chan struct{}, 1),
+	}
+	d.lock.Lock()
+	d.updateActive()
+	d.lock.Unlock()
+
+	go withRecover(func() { d.healthCheckLoop(d.stopHealthCheck, healthCheckInterval) })
+	return d
+}
+
+// Close stops the health checks
+func (d *failoverDialer) Close() {
+	d.stopOnce.Do(func() {
+		close(d.stopHealthCheck)
+	})
+}
+
+func (d *failoverDialer) Dial(network, addr string) (net.Conn, error) {
+	var lastErr error
+	for _, i := range d.candidates() {
+		forwardDialer := d.forwardDialers[i]
+		conn, err := forwardDialer.dialer.Dial(network, addr)
+		if err == nil {
+			d.setHealthy(i, true)
+			return conn, nil
+		}
+		if !isForwardProxyError(err) {
+			// the proxy is reachable but could not connect to addr e.g. the broker is down
+			return nil, err
+		}
+		logger.Warnf("Dial to %s through forward proxy %s failed: %v", addr, forwardDialer.address, err)
+		d.setHealthy(i, false)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no forward proxy is configured")
+	}
+	return nil, lastErr
+}
+
+// candidates returns healthy proxies in priority order followed by the unhealthy ones as the last resort
+func (d *failoverDialer) candidates() []int {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	result := make([]int, 0, len(d.forwardDialers))
+	for i, healthy := range d.healthy {
+		if healthy {
+			result = append(result, i)
+		}
+	}
+	for i, healthy := range d.healthy {
+		if !healthy {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func (d *failoverDialer) setHealthy(i int, healthy bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.healthy[i] == healthy {
+		return
+	}
+	d.healthy[i] = healthy
+	if healthy {
+		logger.Infof("Forward proxy %s is healthy", d.forwardDialers[i].address)
+	} else {
+		logger.Warnf("Forward proxy %s is unhealthy", d.forwardDialers[i].address)
+	}
+	d.updateActive()
+}
+
+// updateActive must be called with the lock held
+func (d *failoverDialer) updateActive() {
+	active := -1
+	for i, healthy := range d.healthy {
+		if healthy {
+			active = i
+			break
+		}
+	}
+	if active != d.active {
+		if active == -1 {
+			logger.Warnf("All forward proxies are unhealthy")
+		} else {
+			logger.Infof("Active forward proxy is %s", d.forwardDialers[active].address)
+		}
+		d.active = active
+	}
+	for i, forwardDialer := range d.forwardDialers {
+		value := 0.0
+		if i == active {
+			value = 1.0
+		}
+		proxyForwardProxyActive.WithLabelValues(forwardDialer.address).Set(value)
+	}
+}
+
+func (d *failoverDialer) activeAddress() string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if d.active == -1 {
+		return ""
+	}
+	return d.forwardDialers[d.active].address
+}
+
+func (d *failoverDialer) healthCheckLoop(stopChannel chan struct{}, interval time.Duration) {
+	logger.Infof("Checking forward proxies health every: %v", interval)
+	healthCheckTicker := time.NewTicker(interval)
+	defer healthCheckTicker.Stop()
+	for {
+		select {
+		case <-healthCheckTicker.C:
+			d.healthCheckTick()
+		case <-stopChannel:
+			return
+		}
+	}
+}
+
+func (d *failoverDialer) healthCheckTick() {
+	for i, forwardDialer := range d.forwardDialers {
+		conn, err := forwardDialer.healthCheckDialer.Dial("tcp", forwardDialer.address)
+		if err != nil {
+			logger.Debugf("Health check of forward proxy %s failed: %v", forwardDialer.address, err)
+			d.setHealthy(i, false)
+			continue
+		}
+		conn.Close()
+		d.setHealthy(i, true)
+	}
+}
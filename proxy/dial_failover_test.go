@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type testDialer struct {
+	err   error
+	dials int
+}
+
+func (d *testDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials++
+	if d.err != nil {
+		return nil, d.err
+	}
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, nil
+}
+
+func TestFailoverDialer(t *testing.T) {
+	a := assert.New(t)
+
+	primary := &testDialer{err: forwardProxyError{err: errors.New("primary is down")}}
+	secondary := &testDialer{}
+
+	d := newFailoverDialer([]forwardProxyDialer{
+		{address: "primary:3128", dialer: primary, healthCheckDialer: primary},
+		{address: "secondary:3128", dialer: secondary, healthCheckDialer: secondary},
+	}, time.Hour)
+	defer d.Close()
+	a.Equal("primary:3128", d.activeAddress())
+
+	// fail over
+	conn, err := d.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	conn.Close()
+	a.Equal(1, primary.dials)
+	a.Equal(1, secondary.dials)
+	a.Equal("secondary:3128", d.activeAddress())
+
+	// unhealthy primary is not tried first
+	conn, err = d.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	conn.Close()
+	a.Equal(1, primary.dials)
+	a.Equal(2, secondary.dials)
+
+	// fail back after primary recovers
+	primary.err = nil
+	d.setHealthy(0, true)
+	a.Equal("primary:3128", d.activeAddress())
+	conn, err = d.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	conn.Close()
+	a.Equal(2, primary.dials)
+	a.Equal(2, secondary.dials)
+
+	// upstream rejection by a reachable proxy does not fail over
+	primary.err = errors.New("connect server using proxy error, statuscode [502]")
+	_, err = d.Dial("tcp", "kafka-0:9092")
+	a.EqualError(err, "connect server using proxy error, statuscode [502]")
+	a.Equal(3, primary.dials)
+	a.Equal(2, secondary.dials)
+	a.Equal("primary:3128", d.activeAddress())
+
+	// all down
+	primary.err = forwardProxyError{err: errors.New("primary is down")}
+	secondary.err = &net.OpError{Op: "socks connect", Net: "tcp", Err: forwardProxyError{err: errors.New("secondary is down")}}
+	_, err = d.Dial("tcp", "kafka-0:9092")
+	a.EqualError(err, "socks connect tcp: secondary is down")
+	a.Equal("", d.activeAddress())
+
+	// health check
+	primary.err = nil
+	d.healthCheckTick()
+	a.Equal("primary:3128", d.activeAddress())
+}
+
+func TestFailoverDialerClose(t *testing.T) {
+	a := assert.New(t)
+
+	d := newFailoverDialer([]forwardProxyDialer{{address: "primary:3128", dialer: &testDialer{}, healthCheckDialer: &testDialer{}}}, time.Hour)
+	closeDialer(tlsDialer{rawDialer: d})
+	closeDialer(d)
+
+	select {
+	case <-d.stopHealthCheck:
+	default:
+		a.Fail("health check is not stopped")
+	}
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// sshDialer opens connections to the brokers as direct-tcpip channels of a single SSH connection to a bastion host.
+// The SSH connection is established lazily and re-established after it is lost.
+type sshDialer struct {
+	directDialer directDialer
+	network      string
+	hostPort     string
+	config       *ssh.ClientConfig
+	// keepAliveInterval is the period of keepalive@openssh.com requests. If zero, keep-alives are disabled.
+	keepAliveInterval time.Duration
+
+	client *ssh.Client
+	lock   sync.Mutex
+}
+
+type sshDialerOptions struct {
+	username              string
+	password              string
+	privateKeyFile        string
+	privateKeyPassword    string
+	agentEnable           bool
+	knownHostsFile        string
+	insecureIgnoreHostKey bool
+	keepAliveInterval     time.Duration
+}
+
+func newSSHDialer(directDialer directDialer, network, hostPort string, opts sshDialerOptions) (*sshDialer, error) {
+	auth := make([]ssh.AuthMethod, 0)
+	if opts.privateKeyFile != "" {
+		signer, err := readSSHPrivateKey(opts.privateKeyFile, opts.privateKeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if opts.agentEnable {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("ssh agent is enabled but SSH_AUTH_SOCK is not set")
+		}
+		auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to connect to ssh agent")
+			}
+			defer conn.Close()
+			return agent.NewClient(conn).Signers()
+		}))
+	}
+	if opts.password != "" {
+		auth = append(auth, ssh.Password(opts.password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("ssh private key, agent or password is required")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if opts.insecureIgnoreHostKey {
+		logger.Warnf("Host key of the ssh server %s will not be verified", hostPort)
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		if opts.knownHostsFile == "" {
+			return nil, errors.New("ssh known hosts file must not be empty")
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(opts.knownHostsFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read ssh known hosts file %s", opts.knownHostsFile)
+		}
+	}
+
+	return &sshDialer{
+		directDialer: directDialer,
+		network:      network,
+		hostPort:     hostPort,
+		config: &ssh.ClientConfig{
+			User:            opts.username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         directDialer.dialTimeout,
+		},
+		keepAliveInterval: opts.keepAliveInterval,
+	}, nil
+}
+
+func readSSHPrivateKey(filename string, password string) (ssh.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(password))
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+func (d *sshDialer) Dial(network, addr string) (net.Conn, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, forwardProxyError{err: err}
+	}
+	channel, err := d.openChannel(client, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// ssh channels do not support deadlines which are required by the processor
+	local, remote := net.Pipe()
+	go withRecover(func() {
+		io.Copy(channel, remote)
+		channel.Close()
+	})
+	go withRecover(func() {
+		io.Copy(remote, channel)
+		remote.Close()
+	})
+	return local, nil
+}
+
+type sshDialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// openChannel opens the direct-tcpip channel within the dial timeout. A half-dead ssh connection
+// never confirms the channel, it is closed so that the next dial reconnects.
+func (d *sshDialer) openChannel(client *ssh.Client, network, addr string) (net.Conn, error) {
+	if d.directDialer.dialTimeout <= 0 {
+		return client.Dial(network, addr)
+	}
+	result := make(chan sshDialResult, 1)
+	go withRecover(func() {
+		conn, err := client.Dial(network, addr)
+		result <- sshDialResult{conn: conn, err: err}
+	})
+
+	timer := time.NewTimer(d.directDialer.dialTimeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-timer.C:
+		d.dropClient(client)
+		go withRecover(func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		})
+		return nil, forwardProxyError{err: errors.Errorf("opening ssh channel to %s through %s timed out after %v", addr, d.hostPort, d.directDialer.dialTimeout)}
+	}
+}
+
+// dropClient closes the ssh connection, a new one is established by the next dial
+func (d *sshDialer) dropClient(client *ssh.Client) {
+	d.lock.Lock()
+	if d.client == client {
+		d.client = nil
+	}
+	d.lock.Unlock()
+	client.Close()
+}
+
+func (d *sshDialer) keepAliveLoop(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(d.keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.sendKeepAlive(client); err != nil {
+				logger.Warnf("Keep-alive of ssh server %s failed: %v", d.hostPort, err)
+				d.dropClient(client)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendKeepAlive expects a reply within the keep-alive interval. A failure reply means the server is alive.
+func (d *sshDialer) sendKeepAlive(client *ssh.Client) error {
+	result := make(chan error, 1)
+	go withRecover(func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	})
+	timer := time.NewTimer(d.keepAliveInterval)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return errors.Errorf("no reply after %v", d.keepAliveInterval)
+	}
+}
+
+func (d *sshDialer) getClient() (*ssh.Client, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+	conn, err := d.directDialer.Dial(d.network, d.hostPort)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, d.hostPort, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "ssh handshake with %s failed", d.hostPort)
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		sshConn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	logger.Infof("Connected to ssh server %s as %s", d.hostPort, d.config.User)
+
+	done := make(chan struct{})
+	go withRecover(func() {
+		err := client.Wait()
+		close(done)
+		logger.Infof("Connection to ssh server %s closed: %v", d.hostPort, err)
+		d.lock.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.lock.Unlock()
+	})
+	if d.keepAliveInterval > 0 {
+		go withRecover(func() { d.keepAliveLoop(client, done) })
+	}
+	d.client = client
+	return client, nil
+}
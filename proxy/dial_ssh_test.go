@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSSHDialer(t *testing.T) {
+	a := assert.New(t)
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	a.Nil(err)
+
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	clientKeyFile, err := ioutil.TempFile("", "ssh-client-key")
+	a.Nil(err)
+	defer os.Remove(clientKeyFile.Name())
+	a.Nil(pem.Encode(clientKeyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)}))
+	clientKeyFile.Close()
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	a.Nil(err)
+
+	// echo server as the broker
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	serverAddr, stop := startTestSSHServer(a, hostSigner, clientPublicKey)
+	defer stop()
+
+	knownHostsFile, err := ioutil.TempFile("", "known_hosts")
+	a.Nil(err)
+	defer os.Remove(knownHostsFile.Name())
+	fmt.Fprintln(knownHostsFile, knownhosts.Line([]string{knownhosts.Normalize(serverAddr)}, hostSigner.PublicKey()))
+	knownHostsFile.Close()
+
+	dialer, err := newSSHDialer(directDialer{dialTimeout: 3 * time.Second}, "tcp", serverAddr, sshDialerOptions{
+		username:       "kafka",
+		privateKeyFile: clientKeyFile.Name(),
+		knownHostsFile: knownHostsFile.Name(),
+	})
+	a.Nil(err)
+
+	conn, err := dialer.Dial("tcp", target.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+
+	a.Nil(conn.SetDeadline(time.Now().Add(3 * time.Second)))
+	_, err = conn.Write([]byte("ping"))
+	a.Nil(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal("ping", string(buf))
+
+	// wrong host key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	a.Nil(err)
+	a.Nil(ioutil.WriteFile(knownHostsFile.Name(), []byte(knownhosts.Line([]string{knownhosts.Normalize(serverAddr)}, otherSigner.PublicKey())+"\n"), 0600))
+
+	dialer, err = newSSHDialer(directDialer{dialTimeout: 3 * time.Second}, "tcp", serverAddr, sshDialerOptions{
+		username:       "kafka",
+		privateKeyFile: clientKeyFile.Name(),
+		knownHostsFile: knownHostsFile.Name(),
+	})
+	a.Nil(err)
+	_, err = dialer.Dial("tcp", target.Addr().String())
+	a.NotNil(err)
+}
+
+func TestSSHDialerUnresponsiveServer(t *testing.T) {
+	a := assert.New(t)
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.Nil(err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	a.Nil(err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+
+	handshakes := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// neither channels nor global requests are answered
+				_, _, _, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				handshakes <- struct{}{}
+			}()
+		}
+	}()
+
+	dialer, err := newSSHDialer(directDialer{dialTimeout: 200 * time.Millisecond}, "tcp", ln.Addr().String(), sshDialerOptions{
+		username:              "kafka",
+		password:              "secret",
+		insecureIgnoreHostKey: true,
+	})
+	a.Nil(err)
+
+	_, err = dialer.Dial("tcp", "kafka-0:9092")
+	a.NotNil(err)
+	a.True(isForwardProxyError(err))
+	dialer.lock.Lock()
+	a.Nil(dialer.client)
+	dialer.lock.Unlock()
+
+	// keep-alive without reply drops the connection
+	dialer.keepAliveInterval = 100 * time.Millisecond
+	client, err := dialer.getClient()
+	a.Nil(err)
+	a.NotNil(client.Wait())
+
+	dialer.lock.Lock()
+	a.Nil(dialer.client)
+	dialer.lock.Unlock()
+	a.Len(handshakes, 2)
+}
+
+func startTestSSHServer(a *assert.Assertions, hostSigner ssh.Signer, authorizedKey ssh.PublicKey) (string, func()) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %q", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, config)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+			newChannel.Reject(ssh.Prohibited, err.Error())
+			continue
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			io.Copy(channel, target)
+			channel.Close()
+		}()
+		go func() {
+			io.Copy(target, channel)
+			target.Close()
+		}()
+	}
+}
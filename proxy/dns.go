@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsResolver caches resolved addresses of broker host names for the fixed ttl, record TTLs are not exposed by the resolver.
+// Expired entries are resolved again on the next dial, so brokers moving behind DNS (e.g. Kubernetes headless services)
+// are followed. When a lookup fails, the last known addresses are used for at most maxStale after expiry.
+type dnsResolver struct {
+	ttl          time.Duration
+	maxStale     time.Duration
+	lookupOnDial bool
+	lookupHost   func(host string) ([]string, error)
+
+	cache map[string]dnsEntry
+	lock  sync.Mutex
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSResolver(ttl time.Duration, maxStale time.Duration, lookupOnDial bool) *dnsResolver {
+	return &dnsResolver{
+		ttl:          ttl,
+		maxStale:     maxStale,
+		lookupOnDial: lookupOnDial,
+		lookupHost:   net.LookupHost,
+		cache:        make(map[string]dnsEntry),
+	}
+}
+
+func (r *dnsResolver) resolve(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	now := time.Now()
+	r.lock.Lock()
+	entry, cached := r.cache[host]
+	r.lock.Unlock()
+
+	if cached && !r.lookupOnDial && now.Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := r.lookupHost(host)
+	if err == nil && len(addrs) == 0 {
+		err = errors.Errorf("no addresses found for %s", host)
+	}
+	if err != nil {
+		proxyDNSResolutionFailuresTotal.WithLabelValues(host).Inc()
+		if cached && now.Before(entry.expires.Add(r.maxStale)) {
+			logger.Warnf("Resolution of %s failed, using last known addresses %v: %v", host, entry.addrs, err)
+			return entry.addrs, nil
+		}
+		r.lock.Lock()
+		r.evictStale(now)
+		r.lock.Unlock()
+		return nil, err
+	}
+	if cached && !equalAddrs(entry.addrs, addrs) {
+		logger.Infof("Address of %s changed from %v to %v", host, entry.addrs, addrs)
+	}
+	r.lock.Lock()
+	r.evictStale(now)
+	r.cache[host] = dnsEntry{addrs: addrs, expires: now.Add(r.ttl)}
+	r.lock.Unlock()
+	return addrs, nil
+}
+
+// evictStale removes entries which cannot be used anymore, it must be called with the lock held
+func (r *dnsResolver) evictStale(now time.Time) {
+	for host, entry := range r.cache {
+		if !now.Before(entry.expires.Add(r.maxStale)) {
+			delete(r.cache, host)
+		}
+	}
+}
+
+// dial connects to the resolved addresses. When both IPv4 and IPv6 addresses are published, the address family
+// of the first address is tried first and the other family is raced after dialer.FallbackDelay (happy-eyeballs, RFC 6555).
+func (r *dnsResolver) dial(dialer net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	if dialer.Timeout > 0 {
+		dialer.Deadline = time.Now().Add(dialer.Timeout)
+	}
+	primaries, fallbacks := partitionAddrs(addrs)
+	if len(fallbacks) == 0 || dialer.FallbackDelay < 0 {
+		return dialSerial(dialer, network, addrs, port)
+	}
+	return dialParallel(dialer, network, primaries, fallbacks, port)
+}
+
+// dialSerial connects to the addresses in order until one of them succeeds. All attempts share the dialer deadline.
+func dialSerial(dialer net.Dialer, network string, addrs []string, port string) (net.Conn, error) {
+	var err error
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = dialer.Dial(network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func dialParallel(dialer net.Dialer, network string, primaries, fallbacks []string, port string) (net.Conn, error) {
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	startRacer := func(addrs []string, primary bool) {
+		go withRecover(func() {
+			conn, err := dialSerial(dialer, network, addrs, port)
+			select {
+			case results <- dialResult{conn: conn, err: err, primary: primary}:
+			case <-returned:
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		})
+	}
+	startRacer(primaries, true)
+
+	fallbackDelay := dialer.FallbackDelay
+	if fallbackDelay == 0 {
+		fallbackDelay = 300 * time.Millisecond
+	}
+	fallbackTimer := time.NewTimer(fallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr error
+	primaryDone, fallbackDone := false, false
+	for {
+		select {
+		case <-fallbackTimer.C:
+			startRacer(fallbacks, false)
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+				primaryDone = true
+				// start the fallback immediately
+				if fallbackTimer.Stop() {
+					fallbackTimer.Reset(0)
+				}
+			} else {
+				fallbackDone = true
+			}
+			if primaryDone && fallbackDone {
+				return nil, primaryErr
+			}
+		}
+	}
+}
+
+// partitionAddrs divides addresses into those of the same family as the first address and the rest.
+func partitionAddrs(addrs []string) (primaries []string, fallbacks []string) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	primaryIPv4 := isIPv4(addrs[0])
+	for _, addr := range addrs {
+		if isIPv4(addr) == primaryIPv4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+func isIPv4(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() != nil
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
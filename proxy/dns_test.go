@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSResolverCache(t *testing.T) {
+	a := assert.New(t)
+
+	lookups := 0
+	addrs := []string{"10.0.0.1"}
+	var lookupErr error
+	resolver := newDNSResolver(time.Hour, time.Hour, false)
+	resolver.lookupHost = func(host string) ([]string, error) {
+		lookups++
+		return addrs, lookupErr
+	}
+
+	result, err := resolver.resolve("broker-0")
+	a.Nil(err)
+	a.Equal([]string{"10.0.0.1"}, result)
+
+	// cached until ttl expires
+	addrs = []string{"10.0.0.2"}
+	result, err = resolver.resolve("broker-0")
+	a.Nil(err)
+	a.Equal([]string{"10.0.0.1"}, result)
+	a.Equal(1, lookups)
+
+	// expired entry is resolved again
+	resolver.ttl = 0
+	resolver.cache["broker-0"] = dnsEntry{addrs: []string{"10.0.0.1"}}
+	result, err = resolver.resolve("broker-0")
+	a.Nil(err)
+	a.Equal([]string{"10.0.0.2"}, result)
+	a.Equal(2, lookups)
+
+	// last known addresses are used when the lookup fails
+	lookupErr = errors.New("no such host")
+	result, err = resolver.resolve("broker-0")
+	a.Nil(err)
+	a.Equal([]string{"10.0.0.2"}, result)
+
+	// stale addresses are not used after max stale
+	resolver.maxStale = 0
+	_, err = resolver.resolve("broker-0")
+	a.NotNil(err)
+	a.Empty(resolver.cache)
+	resolver.maxStale = time.Hour
+
+	_, err = resolver.resolve("broker-1")
+	a.NotNil(err)
+
+	// ip addresses are not resolved
+	result, err = resolver.resolve("127.0.0.1")
+	a.Nil(err)
+	a.Equal([]string{"127.0.0.1"}, result)
+	a.Equal(5, lookups)
+}
+
+func TestDNSResolverLookupOnDial(t *testing.T) {
+	a := assert.New(t)
+
+	lookups := 0
+	resolver := newDNSResolver(time.Hour, time.Hour, true)
+	resolver.lookupHost = func(host string) ([]string, error) {
+		lookups++
+		return []string{"10.0.0.1"}, nil
+	}
+	resolver.resolve("broker-0")
+	resolver.resolve("broker-0")
+	a.Equal(2, lookups)
+}
+
+func TestDirectDialerWithResolver(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	resolver := newDNSResolver(time.Hour, time.Hour, false)
+	resolver.lookupHost = func(host string) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	}
+	dialer := directDialer{dialTimeout: 3 * time.Second, resolver: resolver}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("broker-0", port))
+	a.Nil(err)
+	conn.Close()
+}
+
+func TestPartitionAddrs(t *testing.T) {
+	a := assert.New(t)
+
+	primaries, fallbacks := partitionAddrs([]string{"2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2"})
+	a.Equal([]string{"2001:db8::1", "2001:db8::2"}, primaries)
+	a.Equal([]string{"10.0.0.1", "10.0.0.2"}, fallbacks)
+
+	primaries, fallbacks = partitionAddrs([]string{"10.0.0.1", "10.0.0.2"})
+	a.Equal([]string{"10.0.0.1", "10.0.0.2"}, primaries)
+	a.Empty(fallbacks)
+}
+
+func TestDirectDialerDualStackFallback(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// nothing listens on the IPv6 address, the IPv4 fallback must be used
+	resolver := newDNSResolver(time.Hour, time.Hour, false)
+	resolver.lookupHost = func(host string) ([]string, error) {
+		return []string{"::1", "127.0.0.1"}, nil
+	}
+	dialer := directDialer{dialTimeout: 3 * time.Second, fallbackDelay: time.Second, resolver: resolver}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("broker-0", port))
+	a.Nil(err)
+	a.Equal(ln.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+}
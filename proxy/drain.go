@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BrokerDrain is the draining broker, the new client connections to its listener are rejected
+type BrokerDrain struct {
+	Broker string `json:"broker"` // broker address host:port
+	// the existing connections are closed after the grace period e.g. 30s, they are kept when empty
+	GracePeriod string    `json:"grace_period,omitempty"`
+	Since       time.Time `json:"since"`
+	Connections int       `json:"connections"`
+
+	closeTimer *time.Timer
+}
+
+// BrokerDrains are the brokers taken down for maintenance. They are drained at runtime by the HTTP drain endpoint.
+type BrokerDrains struct {
+	conns *ConnSet
+
+	mu     sync.Mutex
+	drains map[string]*BrokerDrain
+}
+
+// NewBrokerDrains creates the drains closing the connections of the connection set
+func NewBrokerDrains(conns *ConnSet) *BrokerDrains {
+	return &BrokerDrains{conns: conns, drains: make(map[string]*BrokerDrain)}
+}
+
+// Drain rejects the new connections to the broker, the existing connections are closed after the grace period when it is greater or equal 0
+func (d *BrokerDrains) Drain(brokerAddress string, gracePeriod time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	drain, ok := d.drains[brokerAddress]
+	if !ok {
+		drain = &BrokerDrain{Broker: brokerAddress, Since: time.Now()}
+		d.drains[brokerAddress] = drain
+		proxyBrokerDraining.WithLabelValues(brokerAddress).Set(1)
+	}
+	if drain.closeTimer != nil {
+		drain.closeTimer.Stop()
+		drain.closeTimer = nil
+	}
+	drain.GracePeriod = ""
+	if gracePeriod >= 0 {
+		drain.GracePeriod = gracePeriod.String()
+		drain.closeTimer = time.AfterFunc(gracePeriod, func() { d.closeConns(brokerAddress) })
+	}
+	logger.Infof("Draining broker %s, grace period of the connections '%s'", brokerAddress, drain.GracePeriod)
+}
+
+// Resume accepts the new connections to the broker again, false is returned when the broker was not drained
+func (d *BrokerDrains) Resume(brokerAddress string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	drain, ok := d.drains[brokerAddress]
+	if !ok {
+		return false
+	}
+	if drain.closeTimer != nil {
+		drain.closeTimer.Stop()
+	}
+	delete(d.drains, brokerAddress)
+	proxyBrokerDraining.WithLabelValues(brokerAddress).Set(0)
+	logger.Infof("Resumed broker %s", brokerAddress)
+	return true
+}
+
+// Drains returns the draining brokers with the number of their connections
+func (d *BrokerDrains) Drains() []BrokerDrain {
+	counts := d.conns.Count()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	drains := make([]BrokerDrain, 0, len(d.drains))
+	for _, drain := range d.drains {
+		value := *drain
+		value.closeTimer = nil
+		value.Connections = counts[drain.Broker]
+		drains = append(drains, value)
+	}
+	sort.Slice(drains, func(i, j int) bool { return drains[i].Broker < drains[j].Broker })
+	return drains
+}
+
+// draining returns true when the new connections to the broker are rejected
+func (d *BrokerDrains) draining(brokerAddress string) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.drains[brokerAddress]
+	return ok
+}
+
+func (d *BrokerDrains) closeConns(brokerAddress string) {
+	if !d.draining(brokerAddress) {
+		return
+	}
+	conns := d.conns.Conns(brokerAddress)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	logger.Infof("Closed %d connections of the drained broker %s", len(conns), brokerAddress)
+}
+
+// ServeHTTP returns the draining brokers on GET, drains the broker given as JSON {"broker":"host:port","grace_period":"30s"}
+// on POST or PUT and resumes the broker given by the broker query parameter on DELETE
+func (d *BrokerDrains) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		drain := BrokerDrain{}
+		if err := json.NewDecoder(r.Body).Decode(&drain); err != nil {
+			http.Error(w, fmt.Sprintf("invalid broker drain: %v", err), http.StatusBadRequest)
+			return
+		}
+		if drain.Broker == "" {
+			http.Error(w, "invalid broker drain: broker is required", http.StatusBadRequest)
+			return
+		}
+		gracePeriod := time.Duration(-1)
+		if drain.GracePeriod != "" {
+			var err error
+			if gracePeriod, err = time.ParseDuration(drain.GracePeriod); err != nil || gracePeriod < 0 {
+				http.Error(w, fmt.Sprintf("invalid broker drain: grace period '%s' must be a non-negative duration", drain.GracePeriod), http.StatusBadRequest)
+				return
+			}
+		}
+		d.Drain(drain.Broker, gracePeriod)
+	case http.MethodDelete:
+		broker := r.URL.Query().Get("broker")
+		if !d.Resume(broker) {
+			http.Error(w, fmt.Sprintf("broker '%s' is not drained", broker), http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Drains())
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrokerDrains(t *testing.T) {
+	a := assert.New(t)
+
+	conns := NewConnSet()
+	drains := NewBrokerDrains(conns)
+	client, server := net.Pipe()
+	defer client.Close()
+	conns.Add("kafka-0:9092", server)
+
+	a.False(drains.draining("kafka-0:9092"))
+	drains.Drain("kafka-0:9092", -1)
+	a.True(drains.draining("kafka-0:9092"))
+	a.False(drains.draining("kafka-1:9092"))
+
+	// the existing connections are kept without the grace period
+	drains.closeConns("kafka-0:9092")
+	a.Len(drains.Drains(), 1)
+	a.Equal(1, drains.Drains()[0].Connections)
+	a.Equal("", drains.Drains()[0].GracePeriod)
+
+	drains.Drain("kafka-0:9092", 10*time.Millisecond)
+	a.Equal("10ms", drains.Drains()[0].GracePeriod)
+	buf := make([]byte, 1)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(buf)
+	a.NotNil(err)
+	a.NotContains(err.Error(), "timeout")
+
+	a.True(drains.Resume("kafka-0:9092"))
+	a.False(drains.Resume("kafka-0:9092"))
+	a.False(drains.draining("kafka-0:9092"))
+	a.Empty(drains.Drains())
+
+	// new connections are rejected
+	drains.Drain("kafka-0:9092", -1)
+	c := &Client{brokerDrains: drains}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c.handleConn(Conn{BrokerAddress: "kafka-0:9092", LocalConnection: local})
+	_, err = remote.Read(buf)
+	a.NotNil(err)
+	a.True(drains.Resume("kafka-0:9092"))
+
+	var nilDrains *BrokerDrains
+	a.False(nilDrains.draining("kafka-0:9092"))
+}
+
+func TestBrokerDrainsHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	drains := NewBrokerDrains(NewConnSet())
+	server := httptest.NewServer(drains)
+	defer server.Close()
+
+	do := func(method string, query string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+query, strings.NewReader(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		a.Nil(err)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	status, body := do(http.MethodGet, "", "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`[]`, body)
+
+	status, body = do(http.MethodPost, "", `{"broker":"kafka-0:9092","grace_period":"1h"}`)
+	a.Equal(http.StatusOK, status)
+	a.Contains(body, `"broker":"kafka-0:9092","grace_period":"1h0m0s"`)
+	a.Contains(body, `"connections":0`)
+	a.True(drains.draining("kafka-0:9092"))
+
+	status, _ = do(http.MethodPost, "", `{"grace_period":"1h"}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPut, "", `{"broker":"kafka-1:9092","grace_period":"soon"}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPatch, "", "")
+	a.Equal(http.StatusMethodNotAllowed, status)
+
+	status, _ = do(http.MethodDelete, "?broker=kafka-1:9092", "")
+	a.Equal(http.StatusNotFound, status)
+	status, body = do(http.MethodDelete, "?broker=kafka-0:9092", "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`[]`, body)
+	a.False(drains.draining("kafka-0:9092"))
+}
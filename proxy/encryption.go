@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyProduce     = 0
+	apiKeyFetch       = 1
+	apiKeyApiVersions = 18
+
+	maxDecryptionKeys = 1024
+)
+
+// envelopeMagic starts the encrypted record values: Magic, KeyIdLength => int16, KeyId, EncryptedDataKeyLength => int16, EncryptedDataKey, Nonce, Ciphertext
+var envelopeMagic = []byte{0x00, 'k', 'p', 'e', 0x01}
+
+type topicKey struct {
+	topic  string
+	prefix bool
+	keyID  string
+}
+
+type dataKey struct {
+	aead       cipher.AEAD
+	ciphertext []byte
+	expires    time.Time
+}
+
+// RecordEncryption encrypts the record values of Produce requests and decrypts them in Fetch responses with the data keys
+// of the topics. The data keys are generated and decrypted by the key management service.
+type RecordEncryption struct {
+	kms        apis.KeyManagementService
+	topicKeys  []topicKey
+	dataKeyTTL time.Duration
+	timeout    time.Duration
+
+	mu             sync.Mutex
+	encryptionKeys map[string]*dataKey // by key id
+	decryptionKeys map[string]*dataKey // by key id and encrypted data key
+}
+
+// NewRecordEncryption creates the encryption of the topics given as topic=key-id, the topic ending with * is a prefix.
+func NewRecordEncryption(kms apis.KeyManagementService, topicKeys []string, dataKeyTTL time.Duration, timeout time.Duration) (*RecordEncryption, error) {
+	if kms == nil {
+		return nil, errors.New("key management service is nil")
+	}
+	e := &RecordEncryption{
+		kms:            kms,
+		dataKeyTTL:     dataKeyTTL,
+		timeout:        timeout,
+		encryptionKeys: make(map[string]*dataKey),
+		decryptionKeys: make(map[string]*dataKey),
+	}
+	for _, value := range topicKeys {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("topic key '%s' must be topic=key-id", value)
+		}
+		if len(pair[1]) > math.MaxInt16 {
+			return nil, fmt.Errorf("key id of topic %s is too long", pair[0])
+		}
+		key := topicKey{topic: pair[0], keyID: pair[1]}
+		if strings.HasSuffix(key.topic, "*") {
+			key.topic, key.prefix = strings.TrimSuffix(key.topic, "*"), true
+		}
+		e.topicKeys = append(e.topicKeys, key)
+	}
+	return e, nil
+}
+
+// keyID returns the key of the topic, an exact topic match takes precedence over the longest prefix
+func (e *RecordEncryption) keyID(topic string) (string, bool) {
+	var result *topicKey
+	for i, key := range e.topicKeys {
+		if !key.prefix && key.topic == topic {
+			return key.keyID, true
+		}
+		if key.prefix && strings.HasPrefix(topic, key.topic) && (result == nil || len(key.topic) > len(result.topic)) {
+			result = &e.topicKeys[i]
+		}
+	}
+	if result == nil {
+		return "", false
+	}
+	return result.keyID, true
+}
+
+// FilterRequest implements apis.FrameFilter
+func (e *RecordEncryption) FilterRequest(request []byte) ([]byte, error) {
+	if int16(binary.BigEndian.Uint16(request)) != apiKeyProduce {
+		return request, nil
+	}
+	return protocol.ModifyProduceRecordSets(request, e.encryptRecordSet)
+}
+
+// FilterResponse implements apis.FrameFilter
+func (e *RecordEncryption) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	switch apiKey {
+	case apiKeyFetch:
+		return protocol.ModifyFetchRecordSets(apiVersion, response, e.decryptRecordSet)
+	case apiKeyApiVersions:
+		// clients must not use Produce and Fetch versions whose record sets cannot be modified
+		if err := protocol.LimitApiVersions(apiVersion, response, protocol.MaxRecordSetsVersions); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (e *RecordEncryption) encryptRecordSet(topic string, recordSet []byte) ([]byte, error) {
+	keyID, ok := e.keyID(topic)
+	if !ok {
+		return recordSet, nil
+	}
+	batches, rest, err := protocol.DecodeRecordBatches(recordSet)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("record set of topic %s contains a partial batch", topic)
+	}
+	key, err := e.encryptionKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, batch := range batches {
+		if batch.Records == nil {
+			return nil, fmt.Errorf("records of topic %s cannot be encrypted: magic %d, compression %d", topic, batch.Magic(), batch.Compression())
+		}
+		for _, record := range batch.Records {
+			if record.Value == nil {
+				// tombstones are not encrypted
+				continue
+			}
+			if record.Value, err = key.seal(keyID, record.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return protocol.EncodeRecordBatches(batches, rest), nil
+}
+
+func (e *RecordEncryption) decryptRecordSet(topic string, recordSet []byte) ([]byte, error) {
+	if _, ok := e.keyID(topic); !ok {
+		return recordSet, nil
+	}
+	batches, rest, err := protocol.DecodeRecordBatches(recordSet)
+	if err != nil {
+		return nil, err
+	}
+	for _, batch := range batches {
+		if batch.Records == nil {
+			if batch.Magic() == 2 && !batch.Control() {
+				return nil, fmt.Errorf("records of topic %s cannot be decrypted: compression %d", topic, batch.Compression())
+			}
+			// control batches and older message formats are not modified
+			continue
+		}
+		decrypted := false
+		for _, record := range batch.Records {
+			if !bytes.HasPrefix(record.Value, envelopeMagic) {
+				// not encrypted e.g. produced before the encryption was enabled
+				continue
+			}
+			if record.Value, err = e.open(record.Value); err != nil {
+				return nil, errors.Wrapf(err, "record of topic %s cannot be decrypted", topic)
+			}
+			decrypted = true
+		}
+		if !decrypted {
+			// the batch is encoded unchanged
+			batch.Records = nil
+		}
+	}
+	return protocol.EncodeRecordBatches(batches, rest), nil
+}
+
+// encryptionKey returns the current data key of the key id, a new data key is generated when the data key expires
+func (e *RecordEncryption) encryptionKey(keyID string) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.encryptionKeys[keyID]; ok && time.Now().Before(key.expires) {
+		return key, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	plaintext, ciphertext, err := e.kms.GenerateDataKey(ctx, keyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "data key of %s cannot be generated", keyID)
+	}
+	if len(ciphertext) > math.MaxInt16 {
+		return nil, fmt.Errorf("encrypted data key of %s is too long", keyID)
+	}
+	key, err := newDataKey(plaintext, ciphertext, time.Now().Add(e.dataKeyTTL))
+	if err != nil {
+		return nil, err
+	}
+	e.encryptionKeys[keyID] = key
+	return key, nil
+}
+
+// decryptionKey returns the data key decrypted by the key management service, the data keys are cached until expiry
+func (e *RecordEncryption) decryptionKey(keyID string, ciphertext []byte) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	cacheKey := keyID + "\x00" + string(ciphertext)
+	if key, ok := e.decryptionKeys[cacheKey]; ok && now.Before(key.expires) {
+		return key, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	plaintext, err := e.kms.DecryptDataKey(ctx, keyID, ciphertext)
+	if err != nil {
+		return nil, errors.Wrapf(err, "data key of %s cannot be decrypted", keyID)
+	}
+	key, err := newDataKey(plaintext, ciphertext, now.Add(e.dataKeyTTL))
+	if err != nil {
+		return nil, err
+	}
+	if len(e.decryptionKeys) >= maxDecryptionKeys {
+		for k, v := range e.decryptionKeys {
+			if !now.Before(v.expires) {
+				delete(e.decryptionKeys, k)
+			}
+		}
+		if len(e.decryptionKeys) >= maxDecryptionKeys {
+			e.decryptionKeys = make(map[string]*dataKey)
+		}
+	}
+	e.decryptionKeys[cacheKey] = key
+	return key, nil
+}
+
+func (e *RecordEncryption) open(value []byte) ([]byte, error) {
+	offset := len(envelopeMagic)
+	keyID, offset, err := readEnvelopeField(value, offset)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, offset, err := readEnvelopeField(value, offset)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.decryptionKey(string(keyID), ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(value) < offset+nonceSize {
+		return nil, errors.New("envelope is too short")
+	}
+	return key.aead.Open(nil, value[offset:offset+nonceSize], value[offset+nonceSize:], value[:offset])
+}
+
+func newDataKey(plaintext []byte, ciphertext []byte, expires time.Time) (*dataKey, error) {
+	block, err := aes.NewCipher(plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{aead: aead, ciphertext: ciphertext, expires: expires}, nil
+}
+
+// seal returns the envelope of the value, the envelope header is authenticated
+func (k *dataKey) seal(keyID string, value []byte) ([]byte, error) {
+	nonceSize := k.aead.NonceSize()
+	headerSize := len(envelopeMagic) + 2 + len(keyID) + 2 + len(k.ciphertext)
+	envelope := make([]byte, 0, headerSize+nonceSize+len(value)+k.aead.Overhead())
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, byte(len(keyID)>>8), byte(len(keyID)))
+	envelope = append(envelope, keyID...)
+	envelope = append(envelope, byte(len(k.ciphertext)>>8), byte(len(k.ciphertext)))
+	envelope = append(envelope, k.ciphertext...)
+
+	nonce := envelope[headerSize : headerSize+nonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(envelope[:headerSize+nonceSize], nonce, value, envelope[:headerSize]), nil
+}
+
+func readEnvelopeField(envelope []byte, offset int) ([]byte, int, error) {
+	if len(envelope) < offset+2 {
+		return nil, 0, errors.New("envelope is too short")
+	}
+	length := int(binary.BigEndian.Uint16(envelope[offset:]))
+	offset += 2
+	if len(envelope) < offset+length {
+		return nil, 0, errors.New("envelope is too short")
+	}
+	return envelope[offset : offset+length], offset + length, nil
+}
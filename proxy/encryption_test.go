@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testKMS struct {
+	generated int
+	decrypted int
+}
+
+func (k *testKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	k.generated++
+	plaintext := bytes.Repeat([]byte{byte(k.generated)}, 32)
+	return plaintext, append([]byte(keyID+":"), plaintext...), nil
+}
+
+func (k *testKMS) DecryptDataKey(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	k.decrypted++
+	if !bytes.HasPrefix(ciphertext, []byte(keyID+":")) {
+		return nil, errors.New("invalid key")
+	}
+	return ciphertext[len(keyID)+1:], nil
+}
+
+// record batch with one record with null key and the value
+func testRecordSet(value string) []byte {
+	record := []byte{0x00, 0x00, 0x00, 0x01, byte(len(value) * 2)}
+	record = append(record, value...)
+	record = append(record, 0x00)
+	batch := make([]byte, 61)
+	binary.BigEndian.PutUint32(batch[8:], uint32(49+1+len(record)))
+	batch[16] = 2
+	binary.BigEndian.PutUint32(batch[57:], 1)
+	batch = append(batch, byte(len(record)*2))
+	return append(batch, record...)
+}
+
+// Produce v3 request with the record set of the topic
+func testProduceRequest(topic string, recordSet []byte) []byte {
+	request := []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x09, 0x00, 0x01, 'c', 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x00, 0x01}
+	request = append(request, 0x00, byte(len(topic)))
+	request = append(request, topic...)
+	request = append(request, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	request = append(request, 0x00, 0x00, byte(len(recordSet)>>8), byte(len(recordSet)))
+	return append(request, recordSet...)
+}
+
+// Fetch v5 response with the record set of the topic
+func testFetchResponse(topic string, recordSet []byte) []byte {
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	response = append(response, 0x00, byte(len(topic)))
+	response = append(response, topic...)
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, make([]byte, 24)...)
+	response = append(response, 0xff, 0xff, 0xff, 0xff)
+	response = append(response, 0x00, 0x00, byte(len(recordSet)>>8), byte(len(recordSet)))
+	return append(response, recordSet...)
+}
+
+func testRecordValues(t *testing.T, recordSet []byte) []string {
+	batches, _, err := protocol.DecodeRecordBatches(recordSet)
+	assert.Nil(t, err)
+	values := make([]string, 0)
+	for _, batch := range batches {
+		for _, record := range batch.Records {
+			values = append(values, string(record.Value))
+		}
+	}
+	return values
+}
+
+func TestRecordEncryptionKeyID(t *testing.T) {
+	a := assert.New(t)
+
+	e, err := NewRecordEncryption(&testKMS{}, []string{"orders=k1", "pay*=k2", "payments-*=k3", "payments=k4"}, time.Hour, time.Second)
+	a.Nil(err)
+	for topic, expected := range map[string]string{"orders": "k1", "pay": "k2", "payouts": "k2", "payments-eu": "k3", "payments": "k4", "orders-eu": ""} {
+		keyID, ok := e.keyID(topic)
+		a.Equal(expected != "", ok, topic)
+		a.Equal(expected, keyID, topic)
+	}
+
+	_, err = NewRecordEncryption(&testKMS{}, []string{"orders"}, time.Hour, time.Second)
+	a.NotNil(err)
+}
+
+func TestRecordEncryptionRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	kms := &testKMS{}
+	e, err := NewRecordEncryption(kms, []string{"orders=k1"}, time.Hour, time.Second)
+	a.Nil(err)
+
+	var encrypted []byte
+	for _, value := range []string{"v1", "v2"} {
+		request, err := e.FilterRequest(testProduceRequest("orders", testRecordSet(value)))
+		a.Nil(err)
+		_, err = protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+			encrypted = recordSet
+			return recordSet, nil
+		})
+		a.Nil(err)
+		values := testRecordValues(t, encrypted)
+		a.Len(values, 1)
+		a.True(bytes.HasPrefix([]byte(values[0]), envelopeMagic))
+		a.NotContains(values[0], value)
+
+		response, err := e.FilterResponse(apiKeyFetch, 5, testFetchResponse("orders", encrypted))
+		a.Nil(err)
+		_, err = protocol.ModifyFetchRecordSets(5, response, func(topic string, recordSet []byte) ([]byte, error) {
+			a.Equal([]string{value}, testRecordValues(t, recordSet))
+			return recordSet, nil
+		})
+		a.Nil(err)
+	}
+	a.Equal(1, kms.generated)
+	a.Equal(1, kms.decrypted)
+
+	// other topics and plaintext values are not modified
+	request := testProduceRequest("other", testRecordSet("v1"))
+	result, err := e.FilterRequest(request)
+	a.Nil(err)
+	a.Equal(request, result)
+	response := testFetchResponse("orders", testRecordSet("v1"))
+	result, err = e.FilterResponse(apiKeyFetch, 5, response)
+	a.Nil(err)
+	a.Equal(response, result)
+
+	// tampered envelope
+	encrypted[len(encrypted)-2] ^= 0xff
+	_, err = e.FilterResponse(apiKeyFetch, 5, testFetchResponse("orders", encrypted))
+	a.NotNil(err)
+}
+
+func TestRecordEncryptionDataKeyTTL(t *testing.T) {
+	a := assert.New(t)
+
+	kms := &testKMS{}
+	e, err := NewRecordEncryption(kms, []string{"orders=k1"}, time.Millisecond, time.Second)
+	a.Nil(err)
+	_, err = e.FilterRequest(testProduceRequest("orders", testRecordSet("v1")))
+	a.Nil(err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = e.FilterRequest(testProduceRequest("orders", testRecordSet("v1")))
+	a.Nil(err)
+	a.Equal(2, kms.generated)
+}
+
+func TestRecordEncryptionApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	e, err := NewRecordEncryption(&testKMS{}, []string{"orders=k1"}, time.Hour, time.Second)
+	a.Nil(err)
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09}
+	result, err := e.FilterResponse(apiKeyApiVersions, 0, response)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08}, result)
+}
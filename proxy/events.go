@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the type of the proxy event
+type EventType string
+
+const (
+	EventConnectionOpened  EventType = "connection_opened"
+	EventConnectionClosed  EventType = "connection_closed"
+	EventAuthSuccess       EventType = "auth_success"
+	EventAuthFailure       EventType = "auth_failure"
+	EventRequestDenied     EventType = "request_denied"
+	EventBrokerUnreachable EventType = "broker_unreachable"
+	EventConfigReloaded    EventType = "config_reloaded"
+)
+
+// Event is the structured event of the proxy e.g. the opened client connection or the failed client authentication
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	Broker    string    `json:"broker,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Listener  string    `json:"listener,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// EventHandler receives the events of the proxy. It is called by the goroutine handling the connection, so it must not block.
+type EventHandler interface {
+	HandleEvent(event Event)
+}
+
+// EventHandlerFunc is the function used as EventHandler
+type EventHandlerFunc func(event Event)
+
+func (f EventHandlerFunc) HandleEvent(event Event) {
+	f(event)
+}
+
+// eventHandlers receive the events emitted by all servers of the process
+var eventHandlers = &eventBus{}
+
+type eventBus struct {
+	lock     sync.RWMutex
+	handlers []*eventHandlerEntry
+}
+
+// eventHandlerEntry identifies the added handler, the handlers themselves may not be comparable
+type eventHandlerEntry struct {
+	handler EventHandler
+}
+
+// AddEventHandler adds the handler of the proxy events, the returned function removes it
+func AddEventHandler(handler EventHandler) (remove func()) {
+	entry := &eventHandlerEntry{handler: handler}
+	eventHandlers.lock.Lock()
+	eventHandlers.handlers = append(eventHandlers.handlers, entry)
+	eventHandlers.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			eventHandlers.lock.Lock()
+			defer eventHandlers.lock.Unlock()
+			for i, e := range eventHandlers.handlers {
+				if e == entry {
+					eventHandlers.handlers = append(eventHandlers.handlers[:i:i], eventHandlers.handlers[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// emitEvent passes the event to the handlers
+func emitEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	proxyEventsTotal.WithLabelValues(string(event.Type)).Inc()
+
+	eventHandlers.lock.RLock()
+	handlers := eventHandlers.handlers
+	eventHandlers.lock.RUnlock()
+	for _, entry := range handlers {
+		entry.handler.HandleEvent(event)
+	}
+}
+
+// EventWebhook posts the events in batches as JSON arrays to the webhook URL. A batch is sent when it is full or
+// after the flush interval, the failed posts are retried with exponential backoff. The events are dropped when the queue
+// is full or the retries are exhausted, so a slow or unavailable webhook never blocks the proxy.
+type EventWebhook struct {
+	url           string
+	headers       http.Header
+	httpClient    *http.Client
+	batchSize     int
+	flushInterval time.Duration
+	retries       int
+	retryBackoff  time.Duration
+
+	queue    chan Event
+	stopRun  chan struct{}
+	stopOnce sync.Once
+}
+
+func NewEventWebhook(conf *config.Config) (*EventWebhook, error) {
+	opts := conf.Events.Webhook
+	if opts.Url == "" {
+		return nil, errors.New("events webhook URL must not be empty")
+	}
+	headers := http.Header{}
+	for _, header := range opts.Headers {
+		kv := strings.SplitN(header, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("events webhook header %s must be key=value", header)
+		}
+		headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	logger.Infof("Events are posted to webhook %s", opts.Url)
+	return &EventWebhook{
+		url:           opts.Url,
+		headers:       headers,
+		httpClient:    &http.Client{Timeout: opts.Timeout},
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		retries:       opts.Retries,
+		retryBackoff:  opts.RetryBackoff,
+		queue:         make(chan Event, opts.QueueSize),
+		stopRun:       make(chan struct{}),
+	}, nil
+}
+
+// HandleEvent queues the event, the event is dropped when the queue is full
+func (w *EventWebhook) HandleEvent(event Event) {
+	select {
+	case w.queue <- event:
+	default:
+		proxyEventWebhookDroppedTotal.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Run sends the queued events until the webhook is closed, the remaining events are sent once on close
+func (w *EventWebhook) Run() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	batch := make([]Event, 0, w.batchSize)
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) >= w.batchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		case <-w.stopRun:
+			for {
+				select {
+				case event := <-w.queue:
+					batch = append(batch, event)
+					if len(batch) >= w.batchSize {
+						batch = w.flush(batch)
+					}
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *EventWebhook) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stopRun)
+	})
+}
+
+// flush sends the batch and returns the emptied batch
+func (w *EventWebhook) flush(batch []Event) []Event {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := w.send(batch); err != nil {
+		logger.Warnf("Posting %d events to webhook failed, the events are dropped: %v", len(batch), err)
+		proxyEventWebhookDroppedTotal.WithLabelValues("send_failed").Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+// send posts the batch, the failed posts are retried until the retries are exhausted or the webhook is closed
+func (w *EventWebhook) send(batch []Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	backoff := w.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil || attempt >= w.retries {
+			return err
+		}
+		logger.Debugf("Posting events to webhook failed, retrying in %v: %v", backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-w.stopRun:
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (w *EventWebhook) post(body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range w.headers {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := w.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+	return nil
+}
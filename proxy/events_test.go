@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventHandlers(t *testing.T) {
+	a := assert.New(t)
+
+	events := make(chan Event, 2)
+	remove := AddEventHandler(EventHandlerFunc(func(event Event) { events <- event }))
+	before := testCounterValue(a, proxyEventsTotal.WithLabelValues(string(EventConfigReloaded)))
+
+	emitEvent(Event{Type: EventConfigReloaded, Message: "server mapping file servers.yaml"})
+	event := <-events
+	a.Equal(EventConfigReloaded, event.Type)
+	a.Equal("server mapping file servers.yaml", event.Message)
+	a.False(event.Time.IsZero())
+	a.Equal(before+1, testCounterValue(a, proxyEventsTotal.WithLabelValues(string(EventConfigReloaded))))
+
+	// the removed handler does not receive the events, removing it again does nothing
+	remove()
+	remove()
+	emitEvent(Event{Type: EventConfigReloaded})
+	a.Len(events, 0)
+}
+
+func TestEventWebhook(t *testing.T) {
+	a := assert.New(t)
+
+	batches := make(chan []Event, 10)
+	var failures int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("Bearer secret", r.Header.Get("Authorization"))
+		a.Equal("application/json", r.Header.Get("Content-Type"))
+		// the first request fails and is retried
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []Event
+		a.Nil(json.NewDecoder(r.Body).Decode(&batch))
+		batches <- batch
+	}))
+	defer server.Close()
+
+	conf := config.NewConfig()
+	conf.Events.Webhook.Url = server.URL
+	conf.Events.Webhook.Headers = []string{"Authorization = Bearer secret"}
+	conf.Events.Webhook.BatchSize = 2
+	conf.Events.Webhook.FlushInterval = time.Hour
+	conf.Events.Webhook.RetryBackoff = 10 * time.Millisecond
+	webhook, err := NewEventWebhook(conf)
+	a.Nil(err)
+	done := make(chan struct{})
+	go func() {
+		webhook.Run()
+		close(done)
+	}()
+
+	// the full batch is sent at once
+	webhook.HandleEvent(Event{Type: EventConnectionOpened, Broker: "kafka-0:9092", Client: "10.0.0.1:50000"})
+	webhook.HandleEvent(Event{Type: EventConnectionClosed, Broker: "kafka-0:9092", Client: "10.0.0.1:50000"})
+	batch := <-batches
+	a.Len(batch, 2)
+	a.Equal(EventConnectionOpened, batch[0].Type)
+	a.Equal("kafka-0:9092", batch[0].Broker)
+	a.Equal(EventConnectionClosed, batch[1].Type)
+
+	// the remaining events are sent on close
+	webhook.HandleEvent(Event{Type: EventAuthFailure, Message: "user alice authentication failed"})
+	webhook.Close()
+	<-done
+	batch = <-batches
+	a.Len(batch, 1)
+	a.Equal("user alice authentication failed", batch[0].Message)
+}
+
+func TestEventWebhookDropped(t *testing.T) {
+	a := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	conf := config.NewConfig()
+	conf.Events.Webhook.Url = server.URL
+	conf.Events.Webhook.Retries = 2
+	conf.Events.Webhook.RetryBackoff = time.Millisecond
+	conf.Events.Webhook.QueueSize = 1
+	webhook, err := NewEventWebhook(conf)
+	a.Nil(err)
+
+	// the queue is full
+	queueFull := testCounterValue(a, proxyEventWebhookDroppedTotal.WithLabelValues("queue_full"))
+	webhook.HandleEvent(Event{Type: EventBrokerUnreachable})
+	webhook.HandleEvent(Event{Type: EventBrokerUnreachable})
+	a.Equal(queueFull+1, testCounterValue(a, proxyEventWebhookDroppedTotal.WithLabelValues("queue_full")))
+
+	// the batch is dropped after the retries
+	sendFailed := testCounterValue(a, proxyEventWebhookDroppedTotal.WithLabelValues("send_failed"))
+	a.Len(webhook.flush([]Event{<-webhook.queue}), 0)
+	a.Equal(int32(3), atomic.LoadInt32(&requests))
+	a.Equal(sendFailed+1, testCounterValue(a, proxyEventWebhookDroppedTotal.WithLabelValues("send_failed")))
+}
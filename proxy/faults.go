@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults are injected into the requests of the selected connections, an empty list matches all
+type Faults struct {
+	ApiKeys []int16  `json:"api_keys"`
+	Clients []string `json:"clients"` // client IP addresses
+	Brokers []string `json:"brokers"` // broker addresses host:port
+
+	// added before the request is sent to the broker e.g. 200ms
+	Latency string `json:"latency,omitempty"`
+	// the broker response is not sent to the client
+	DropProbability float64 `json:"drop_probability,omitempty"`
+	// the broker response is replaced by the response failing with the error code
+	ErrorCode        int16   `json:"error_code,omitempty"`
+	ErrorProbability float64 `json:"error_probability,omitempty"`
+	// the client connection is closed before the request is sent to the broker
+	DisconnectProbability float64 `json:"disconnect_probability,omitempty"`
+
+	latency time.Duration
+}
+
+func (f *Faults) validate() error {
+	if f.Latency != "" {
+		latency, err := time.ParseDuration(f.Latency)
+		if err != nil || latency < 0 {
+			return fmt.Errorf("latency '%s' must be a non-negative duration", f.Latency)
+		}
+		f.latency = latency
+	}
+	for _, probability := range []float64{f.DropProbability, f.ErrorProbability, f.DisconnectProbability} {
+		if probability < 0 || probability > 1 {
+			return fmt.Errorf("probability %v must be between 0 and 1", probability)
+		}
+	}
+	if (f.ErrorCode != 0) != (f.ErrorProbability > 0) {
+		return errors.New("error code and error probability must be set together")
+	}
+	return nil
+}
+
+func (f *Faults) matches(clientIP string, brokerAddress string, apiKey int16) bool {
+	if len(f.ApiKeys) != 0 {
+		found := false
+		for _, v := range f.ApiKeys {
+			found = found || v == apiKey
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchesCaptureValue(f.Clients, clientIP) && matchesCaptureValue(f.Brokers, brokerAddress)
+}
+
+// injectedFault are the faults drawn for a request
+type injectedFault struct {
+	latency    time.Duration
+	drop       bool
+	errorCode  int16
+	disconnect bool
+}
+
+// FaultInjection adds latency, drops responses, synthesizes error responses and closes client connections, so the retries
+// of the clients can be tested without touching the brokers. The faults are enabled and disabled at runtime by the HTTP faults endpoint.
+type FaultInjection struct {
+	active int32 // fast path of the disabled fault injection
+
+	mu     sync.Mutex
+	faults *Faults // nil when disabled
+	random *rand.Rand
+}
+
+func NewFaultInjection() *FaultInjection {
+	return &FaultInjection{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Enable starts injecting the faults, the previous faults are replaced
+func (fi *FaultInjection) Enable(faults Faults) error {
+	if err := faults.validate(); err != nil {
+		return err
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fi.faults = &faults
+	atomic.StoreInt32(&fi.active, 1)
+	logger.Infof("Fault injection enabled: api keys %v, clients %v, brokers %v, latency '%s', drop probability %v, error code %d with probability %v, disconnect probability %v",
+		faults.ApiKeys, faults.Clients, faults.Brokers, faults.Latency, faults.DropProbability, faults.ErrorCode, faults.ErrorProbability, faults.DisconnectProbability)
+	return nil
+}
+
+func (fi *FaultInjection) Disable() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.faults != nil {
+		logger.Infof("Fault injection disabled")
+	}
+	fi.faults = nil
+	atomic.StoreInt32(&fi.active, 0)
+}
+
+// Faults returns the enabled faults, nil when disabled
+func (fi *FaultInjection) Faults() *Faults {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.faults
+}
+
+// inject draws the faults of the request with the api key, no faults are returned when the fault injection is not configured or disabled
+func (fi *FaultInjection) inject(clientAddress string, brokerAddress string, apiKey int16) (fault injectedFault) {
+	if fi == nil || atomic.LoadInt32(&fi.active) == 0 {
+		return fault
+	}
+	clientIP := clientAddress
+	if host, _, err := net.SplitHostPort(clientAddress); err == nil {
+		clientIP = host
+	}
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	faults := fi.faults
+	if faults == nil || !faults.matches(clientIP, brokerAddress, apiKey) {
+		return fault
+	}
+	fault.latency = faults.latency
+	fault.disconnect = fi.random.Float64() < faults.DisconnectProbability
+	fault.drop = fi.random.Float64() < faults.DropProbability
+	if fi.random.Float64() < faults.ErrorProbability {
+		fault.errorCode = faults.ErrorCode
+	}
+
+	label := strconv.Itoa(int(apiKey))
+	if fault.latency > 0 {
+		proxyInjectedFaultsTotal.WithLabelValues("latency", label).Inc()
+	}
+	if fault.disconnect {
+		proxyInjectedFaultsTotal.WithLabelValues("disconnect", label).Inc()
+	}
+	if fault.drop {
+		proxyInjectedFaultsTotal.WithLabelValues("drop", label).Inc()
+	}
+	if fault.errorCode != 0 {
+		proxyInjectedFaultsTotal.WithLabelValues("error", label).Inc()
+	}
+	return fault
+}
+
+// ServeHTTP returns the enabled faults on GET, enables the faults in the body on POST or PUT and disables them on DELETE
+func (fi *FaultInjection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		faults := Faults{}
+		if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+			http.Error(w, fmt.Sprintf("invalid faults: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := fi.Enable(faults); err != nil {
+			http.Error(w, fmt.Sprintf("invalid faults: %v", err), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		fi.Disable()
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	faults := fi.Faults()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Enabled bool    `json:"enabled"`
+		Faults  *Faults `json:"faults,omitempty"`
+	}{Enabled: faults != nil, Faults: faults})
+}
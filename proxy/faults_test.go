@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectionInject(t *testing.T) {
+	a := assert.New(t)
+
+	var nilFaults *FaultInjection
+	a.Equal(injectedFault{}, nilFaults.inject("10.0.0.5:51000", "kafka-0:9092", apiKeyMetadata))
+
+	faults := NewFaultInjection()
+	a.Equal(injectedFault{}, faults.inject("10.0.0.5:51000", "kafka-0:9092", apiKeyMetadata))
+
+	a.EqualError(faults.Enable(Faults{Latency: "soon"}), "latency 'soon' must be a non-negative duration")
+	a.EqualError(faults.Enable(Faults{DropProbability: 1.5}), "probability 1.5 must be between 0 and 1")
+	a.EqualError(faults.Enable(Faults{ErrorCode: 7}), "error code and error probability must be set together")
+	a.Nil(faults.Faults())
+
+	a.Nil(faults.Enable(Faults{ApiKeys: []int16{apiKeyMetadata}, Clients: []string{"10.0.0.5"}, Latency: "10ms", DropProbability: 1, ErrorCode: 7, ErrorProbability: 1}))
+	a.Equal(injectedFault{latency: 10 * time.Millisecond, drop: true, errorCode: 7}, faults.inject("10.0.0.5:51000", "kafka-0:9092", apiKeyMetadata))
+	a.Equal(injectedFault{}, faults.inject("10.0.0.5:51000", "kafka-0:9092", apiKeyApiApiVersions))
+	a.Equal(injectedFault{}, faults.inject("10.0.0.6:51000", "kafka-0:9092", apiKeyMetadata))
+
+	a.Nil(faults.Enable(Faults{Brokers: []string{"kafka-1:9092"}, DisconnectProbability: 1}))
+	a.Equal(injectedFault{disconnect: true}, faults.inject("10.0.0.5:51000", "kafka-1:9092", apiKeyMetadata))
+	a.Equal(injectedFault{}, faults.inject("10.0.0.5:51000", "kafka-0:9092", apiKeyMetadata))
+
+	faults.Disable()
+	a.Nil(faults.Faults())
+	a.Equal(injectedFault{}, faults.inject("10.0.0.5:51000", "kafka-1:9092", apiKeyMetadata))
+}
+
+func TestDefaultHandlersFaultInjection(t *testing.T) {
+	a := assert.New(t)
+
+	faults := NewFaultInjection()
+	a.Nil(faults.Enable(Faults{DropProbability: 1, ErrorCode: int16(protocol.ErrNotLeaderForPartition), ErrorProbability: 1}))
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		faults:                     faults,
+	}
+	// the substitute request is sent to the broker, the error response is sent to the client
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(testMetadataRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(apiKeyApiApiVersions, int16((<-received)[5]))
+	requestKeyVersion := <-openRequestsChannel
+	expected, err := protocol.EncodeErrorResponse(testMetadataRequest[4:], int16(protocol.ErrNotLeaderForPartition))
+	a.Nil(err)
+	a.Equal(expected, requestKeyVersion.LocalResponse)
+	a.True(requestKeyVersion.DropResponse)
+
+	// the dropped response is not sent to the client
+	openRequestsChannel <- requestKeyVersion
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		buf:                 make([]byte, 16),
+	}
+	go func() {
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		brokerSide.Close()
+	}()
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	proxyClientSide.Close()
+	a.Empty(<-received)
+
+	// the connection is closed
+	a.Nil(faults.Enable(Faults{DisconnectProbability: 1}))
+	client2, local2 := net.Pipe()
+	defer client2.Close()
+	go func() {
+		client2.Write(testMetadataRequest)
+	}()
+	_, err = defaultRequestHandler.handleRequest(remote, local2, requestsCtx)
+	a.EqualError(err, "fault injection closed the connection of client ")
+	a.Len(openRequestsChannel, 0)
+}
+
+func TestFaultInjectionHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	faults := NewFaultInjection()
+	server := httptest.NewServer(faults)
+	defer server.Close()
+
+	do := func(method string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		a.Nil(err)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	status, body := do(http.MethodGet, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+
+	status, body = do(http.MethodPost, `{"api_keys":[3],"latency":"200ms","error_code":7,"error_probability":0.5}`)
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":true,"faults":{"api_keys":[3],"clients":null,"brokers":null,"latency":"200ms","error_code":7,"error_probability":0.5}}`, body)
+	a.Equal(200*time.Millisecond, faults.Faults().latency)
+
+	status, _ = do(http.MethodPut, `{"disconnect_probability":2}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPut, `{"latency":1}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPatch, "")
+	a.Equal(http.StatusMethodNotAllowed, status)
+
+	status, body = do(http.MethodDelete, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+	a.Nil(faults.Faults())
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+)
+
+// FrameFilters observes or mutates Kafka frames passing through the processor.
+type FrameFilters struct {
+	filters []apis.FrameFilter
+}
+
+func (f *FrameFilters) enabled() bool {
+	return f != nil && len(f.filters) != 0
+}
+
+// filterRequest applies the filters to the request without the size field
+func (f *FrameFilters) filterRequest(request []byte) ([]byte, error) {
+	if len(request) < 8 {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("request of length %d is too short to filter", len(request))}
+	}
+	header := make([]byte, 8) // ApiKey => int16, ApiVersion => int16, CorrelationId => int32
+	copy(header, request)
+
+	var err error
+	for _, filter := range f.filters {
+		if request, err = filter.FilterRequest(request); err != nil {
+			return nil, err
+		}
+		if len(request) < 8 || !bytes.Equal(header, request[:8]) {
+			return nil, fmt.Errorf("frame filter %T must not change api key, api version or correlation id", filter)
+		}
+	}
+	return request, nil
+}
+
+// filterResponse applies the filters in reverse order to the response without the size and correlation id fields
+func (f *FrameFilters) filterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	var err error
+	for i := len(f.filters) - 1; i >= 0; i-- {
+		if response, err = f.filters[i].FilterResponse(apiKey, apiVersion, response); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// apiVersionsLimit lowers the max versions of the ApiVersions response to the versions the proxy can modify
+type apiVersionsLimit struct {
+	maxVersions map[int16]int16
+}
+
+// FilterRequest implements apis.FrameFilter
+func (l *apiVersionsLimit) FilterRequest(request []byte) ([]byte, error) {
+	return request, nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (l *apiVersionsLimit) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if apiKey == apiKeyApiVersions {
+		if err := protocol.LimitApiVersions(apiVersion, response, l.maxVersions); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// apiVersionsAdd adds the api keys answered by the proxy to the ApiVersions response
+type apiVersionsAdd struct {
+	maxVersions map[int16]int16
+}
+
+// FilterRequest implements apis.FrameFilter
+func (a *apiVersionsAdd) FilterRequest(request []byte) ([]byte, error) {
+	return request, nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (a *apiVersionsAdd) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if apiKey == apiKeyApiVersions {
+		return protocol.AddApiVersions(apiVersion, response, a.maxVersions)
+	}
+	return response, nil
+}
+
+// rejectRequest returns the error response to the request rejected by a filter. The error is returned when it is not a rejection
+// or the error response cannot be encoded.
+func rejectRequest(request []byte, err error) (allowed bool, errorResponse []byte, _ error) {
+	rejection, ok := err.(*apis.FrameRejection)
+	if !ok {
+		return false, nil, err
+	}
+	errorResponse, err = protocol.EncodeErrorResponse(request, rejection.ErrorCode)
+	if err != nil {
+		return false, nil, errors.Wrap(err, rejection.Error())
+	}
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return false, nil, err
+	}
+	logger.Infof("Request with api key %d version %d for topics %v from client %s was rejected with error code %d: %s", info.ApiKey, info.ApiVersion, info.Topics, info.ClientID, rejection.ErrorCode, rejection.Reason)
+	return false, errorResponse, nil
+}
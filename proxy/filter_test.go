@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type testFrameFilter struct {
+	request  func([]byte) ([]byte, error)
+	response func([]byte) ([]byte, error)
+}
+
+func (f *testFrameFilter) FilterRequest(request []byte) ([]byte, error) {
+	return f.request(request)
+}
+
+func (f *testFrameFilter) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	return f.response(response)
+}
+
+// replaces client id "c" of the Metadata request with "cc"
+var testRenameClientFilter = &testFrameFilter{
+	request: func(request []byte) ([]byte, error) {
+		return bytes.Replace(request, []byte{0x00, 0x01, 'c'}, []byte{0x00, 0x02, 'c', 'c'}, 1), nil
+	},
+	response: func(response []byte) ([]byte, error) {
+		return append(response, 0xff), nil
+	},
+}
+
+func TestFrameFiltersFilterRequest(t *testing.T) {
+	a := assert.New(t)
+
+	frameFilters := &FrameFilters{filters: []apis.FrameFilter{testRenameClientFilter, testRenameClientFilter}}
+	a.True(frameFilters.enabled())
+
+	request, err := frameFilters.filterRequest(testMetadataRequest[4:])
+	a.Nil(err)
+	info, err := protocol.DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal("cc", info.ClientID)
+	a.Equal([]string{"t1"}, info.Topics)
+
+	// header change is rejected
+	frameFilters = &FrameFilters{filters: []apis.FrameFilter{&testFrameFilter{request: func(request []byte) ([]byte, error) {
+		return append([]byte{0x00, 0x00}, request[2:]...), nil
+	}}}}
+	_, err = frameFilters.filterRequest(testMetadataRequest[4:])
+	a.NotNil(err)
+
+	frameFilters = &FrameFilters{filters: []apis.FrameFilter{&testFrameFilter{request: func(request []byte) ([]byte, error) {
+		return nil, errors.New("rejected by filter")
+	}}}}
+	_, err = frameFilters.filterRequest(testMetadataRequest[4:])
+	a.EqualError(err, "rejected by filter")
+
+	var disabled *FrameFilters
+	a.False(disabled.enabled())
+}
+
+func TestDefaultHandlersFrameFilters(t *testing.T) {
+	a := assert.New(t)
+
+	frameFilters := &FrameFilters{filters: []apis.FrameFilter{testRenameClientFilter}}
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		frameFilters:               frameFilters,
+	}
+	go func() {
+		client.Write(testMetadataRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+
+	expected := []byte{0x00, 0x00, 0x00, 0x15}
+	expected = append(expected, bytes.Replace(testMetadataRequest[4:], []byte{0x00, 0x01, 'c'}, []byte{0x00, 0x02, 'c', 'c'}, 1)...)
+	a.Equal(expected, <-received)
+	a.Equal(protocol.RequestKeyVersion{ApiKey: 3, ApiVersion: 4, Length: 0x14}, <-openRequestsChannel)
+
+	// response of an api without the response modifier
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 18, ApiVersion: 0}
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		buf:                 make([]byte, 16),
+		frameFilters:        frameFilters,
+	}
+	go func() {
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+		brokerSide.Close()
+	}()
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	proxyClientSide.Close()
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0xff}, <-received)
+}
+
+func TestDefaultRequestHandlerFrameRejection(t *testing.T) {
+	a := assert.New(t)
+
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+
+	rejectingFilter := &testFrameFilter{request: func(request []byte) ([]byte, error) {
+		return nil, &apis.FrameRejection{ErrorCode: 3, Reason: "unknown topic"}
+	}}
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	ctx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		frameFilters:               &FrameFilters{filters: []apis.FrameFilter{rejectingFilter}},
+	}
+	go func() {
+		client.Write(testMetadataRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+
+	_, err := defaultRequestHandler.handleRequest(remote, local, ctx)
+	remote.Close()
+	a.Nil(err)
+	// ApiVersions v0 request with the correlation id of the rejected request
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff}, <-received)
+	a.Equal(testMetadataErrorResponse, (<-openRequestsChannel).LocalResponse)
+
+	// other errors close the connection
+	_, _, err = rejectRequest(testMetadataRequest[4:], errors.New("filter failed"))
+	a.EqualError(err, "filter failed")
+}
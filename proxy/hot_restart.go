@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// addresses of the listeners handed over to the new process, the listener files follow the readiness pipe
+	hotRestartListenersEnv = "KAFKA_PROXY_HOT_RESTART_LISTENERS"
+	// the first extra file of the new process is the readiness pipe
+	hotRestartReadyFd = 3
+	// the new process not ready within the timeout is killed, the old process keeps its listeners
+	hotRestartReadyTimeout = 30 * time.Second
+)
+
+// HotRestart hands the listening sockets over to a new process of the same binary, so the proxy can be upgraded without
+// refusing the connections. The new process inherits the sockets and reports its readiness, then the old process closes
+// its proxy listeners and drains its connections. The connections arriving in between wait in the accept queues of the sockets.
+type HotRestart struct {
+	lock      sync.Mutex
+	inherited map[string]*net.TCPListener
+	listeners []hotRestartListener
+	ready     *os.File
+	restarted bool
+}
+
+type hotRestartListener struct {
+	address  string
+	listener *net.TCPListener
+	// kept open by the old process until it exits e.g. the HTTP listener
+	shared bool
+}
+
+// NewHotRestart creates the hot restart, the listeners handed over by the parent process are inherited
+func NewHotRestart() (*HotRestart, error) {
+	if HotRestartSignal == nil {
+		return nil, errors.New("hot restart is not supported on this platform")
+	}
+	addresses, ok := os.LookupEnv(hotRestartListenersEnv)
+	if !ok {
+		return newHotRestart(nil, nil, nil)
+	}
+	// the listeners are not inherited by the processes started later
+	os.Unsetenv(hotRestartListenersEnv)
+
+	var inherited []string
+	if addresses != "" {
+		inherited = strings.Split(addresses, ",")
+	}
+	files := make([]*os.File, 0, len(inherited))
+	for i, address := range inherited {
+		files = append(files, os.NewFile(uintptr(hotRestartReadyFd+1+i), address))
+	}
+	return newHotRestart(os.NewFile(hotRestartReadyFd, "hot-restart-ready"), files, inherited)
+}
+
+func newHotRestart(ready *os.File, files []*os.File, addresses []string) (*HotRestart, error) {
+	h := &HotRestart{inherited: make(map[string]*net.TCPListener), ready: ready}
+	for i, file := range files {
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "inherited listener %s", addresses[i])
+		}
+		tcpListener, ok := l.(*net.TCPListener)
+		if !ok {
+			l.Close()
+			return nil, errors.Errorf("inherited listener %s is not a TCP listener", addresses[i])
+		}
+		h.inherited[addresses[i]] = tcpListener
+	}
+	if len(files) != 0 {
+		logger.Infof("Inherited %d listeners from the parent process", len(files))
+	}
+	return h, nil
+}
+
+// Listen returns the listener inherited for the address or announces on the address. The listener is closed
+// when the listeners are handed over to the new process, the shared listener is kept open until the process exits.
+func (h *HotRestart) Listen(address string, reusePort bool, shared bool) (net.Listener, error) {
+	if h == nil {
+		return Listen(address, reusePort)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.restarted {
+		return nil, errors.Errorf("listener %s is not started, the listeners were handed over to the new process", address)
+	}
+	l, ok := h.inherited[address]
+	if ok {
+		delete(h.inherited, address)
+		logger.Infof("Listener %s inherited from the parent process", address)
+	} else {
+		nl, err := Listen(address, reusePort)
+		if err != nil {
+			return nil, err
+		}
+		if l, ok = nl.(*net.TCPListener); !ok {
+			nl.Close()
+			return nil, errors.Errorf("listener %s is not a TCP listener", address)
+		}
+	}
+	h.listeners = append(h.listeners, hotRestartListener{address: address, listener: l, shared: shared})
+	return l, nil
+}
+
+// Ready reports the readiness of the new process to the parent process
+func (h *HotRestart) Ready() {
+	if h == nil || h.ready == nil {
+		return
+	}
+	if _, err := h.ready.Write([]byte{1}); err != nil {
+		logger.Warnf("Readiness was not reported to the parent process: %v", err)
+	}
+	h.ready.Close()
+	h.ready = nil
+}
+
+// Restart starts the new process with the same arguments and hands the listeners over to it. The listeners which are not
+// shared are closed when the new process is ready, they are kept when the new process fails to start.
+func (h *HotRestart) Restart() (*os.Process, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.restarted {
+		return nil, errors.New("listeners were already handed over to the new process")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	addresses, files, err := h.handover()
+	if err != nil {
+		return nil, err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), hotRestartListenersEnv+"="+strings.Join(addresses, ","))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append([]*os.File{readyWriter}, files...)
+	err = cmd.Start()
+	// the reader gets EOF when the new process exits
+	closeFiles(cmd.ExtraFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "new process was not started")
+	}
+	if err = waitReady(readyReader, hotRestartReadyTimeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errors.Wrapf(err, "new process %d is not ready", cmd.Process.Pid)
+	}
+	go cmd.Wait()
+
+	h.restarted = true
+	h.closeListeners()
+	return cmd.Process, nil
+}
+
+// handover returns the addresses and the files of the listeners passed to the new process, the listeners
+// with the dynamic ports are not passed as the new process listens on other ports
+func (h *HotRestart) handover() ([]string, []*os.File, error) {
+	var addresses []string
+	var files []*os.File
+	add := func(address string, l *net.TCPListener) error {
+		if _, port, err := net.SplitHostPort(address); err == nil && port == "0" {
+			return nil
+		}
+		file, err := l.File()
+		if err != nil {
+			return errors.Wrapf(err, "listener %s", address)
+		}
+		addresses = append(addresses, address)
+		files = append(files, file)
+		return nil
+	}
+	for _, l := range h.listeners {
+		if err := add(l.address, l.listener); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	for address, l := range h.inherited {
+		if err := add(address, l); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	return addresses, files, nil
+}
+
+// closeListeners closes the listeners which are not shared, the accepted connections are not affected
+func (h *HotRestart) closeListeners() {
+	for _, l := range h.listeners {
+		if !l.shared {
+			l.listener.Close()
+		}
+	}
+	for address, l := range h.inherited {
+		l.Close()
+		delete(h.inherited, address)
+	}
+}
+
+func waitReady(ready *os.File, timeout time.Duration) error {
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1)
+	_, err := ready.Read(buf)
+	return err
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package proxy
+
+import (
+	"os"
+)
+
+// HotRestartSignal is nil as the listeners cannot be handed over to a new process
+var HotRestartSignal os.Signal
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHotRestart(t *testing.T) {
+	a := assert.New(t)
+
+	// the listener of the parent process
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	address := parent.Addr().String()
+	file, err := parent.(*net.TCPListener).File()
+	a.Nil(err)
+	parent.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	a.Nil(err)
+	defer readyReader.Close()
+	h, err := newHotRestart(readyWriter, []*os.File{file}, []string{address})
+	a.Nil(err)
+
+	// the inherited listener accepts the connections to the address
+	inherited, err := h.Listen(address, false, false)
+	a.Nil(err)
+	a.Equal(address, inherited.Addr().String())
+	conn, err := net.Dial("tcp", address)
+	a.Nil(err)
+	conn.Close()
+	accepted, err := inherited.Accept()
+	a.Nil(err)
+	accepted.Close()
+
+	h.Ready()
+	a.Nil(waitReady(readyReader, time.Second))
+
+	dynamic, err := h.Listen("127.0.0.1:0", false, false)
+	a.Nil(err)
+	shared, err := h.Listen("127.0.0.1:0", false, true)
+	a.Nil(err)
+	defer shared.Close()
+
+	// the listeners with the dynamic ports are not handed over
+	addresses, files, err := h.handover()
+	a.Nil(err)
+	a.Equal([]string{address}, addresses)
+	a.Len(files, 1)
+	closeFiles(files)
+
+	h.restarted = true
+	h.closeListeners()
+	_, err = inherited.Accept()
+	a.NotNil(err)
+	_, err = dynamic.Accept()
+	a.NotNil(err)
+	conn, err = net.Dial("tcp", shared.Addr().String())
+	a.Nil(err)
+	conn.Close()
+	_, err = h.Listen(address, false, false)
+	a.EqualError(err, "listener "+address+" is not started, the listeners were handed over to the new process")
+
+	var disabled *HotRestart
+	l, err := disabled.Listen("127.0.0.1:0", false, false)
+	a.Nil(err)
+	l.Close()
+	disabled.Ready()
+}
@@ -0,0 +1,12 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// HotRestartSignal triggers the hand over of the listeners to a new process
+var HotRestartSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+var keySignerHashes = map[crypto.Hash]string{
+	crypto.SHA256: "SHA-256",
+	crypto.SHA384: "SHA-384",
+	crypto.SHA512: "SHA-512",
+}
+
+// keySignerKey is the private key of the listener certificate held by the key signer, the TLS handshake signatures are delegated to the signer
+type keySignerKey struct {
+	public  crypto.PublicKey
+	keyType string
+	signer  apis.KeySigner
+	timeout time.Duration
+}
+
+// newKeySignerCertificate returns the certificate chain of the PEM file with the private key of the key signer
+func newKeySignerCertificate(certFile string, signer apis.KeySigner, timeout time.Duration) (tls.Certificate, error) {
+	certPEMBlock, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{}
+	for {
+		var block *pem.Block
+		block, certPEMBlock = pem.Decode(certPEMBlock)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("Failed to find certificate PEM data in the listener cert file")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key := &keySignerKey{public: leaf.PublicKey, signer: signer, timeout: timeout}
+	switch leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.keyType = "RSA"
+	case *ecdsa.PublicKey:
+		key.keyType = "ECDSA"
+	default:
+		return tls.Certificate{}, fmt.Errorf("unsupported public key type %T of the key signer certificate", leaf.PublicKey)
+	}
+	cert.PrivateKey = key
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// Public implements crypto.Signer
+func (k *keySignerKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign implements crypto.Signer, the PSS salt length is the hash length as used by TLS
+func (k *keySignerKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := keySignerHashes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v of the key signer", opts.HashFunc())
+	}
+	_, pss := opts.(*rsa.PSSOptions)
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	signature, err := k.signer.Sign(ctx, apis.SignRequest{KeyType: k.keyType, Hash: hash, PSS: pss, Digest: digest})
+	if err != nil {
+		logger.Errorf("Key signer failed: %v", err)
+		return nil, err
+	}
+	return signature, nil
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type testKeySigner struct {
+	key      *rsa.PrivateKey
+	requests []apis.SignRequest
+	err      error
+}
+
+func (s *testKeySigner) Sign(_ context.Context, request apis.SignRequest) ([]byte, error) {
+	s.requests = append(s.requests, request)
+	if s.err != nil {
+		return nil, s.err
+	}
+	hash := map[string]crypto.Hash{"SHA-256": crypto.SHA256, "SHA-384": crypto.SHA384, "SHA-512": crypto.SHA512}[request.Hash]
+	if request.PSS {
+		return rsa.SignPSS(rand.Reader, s.key, hash, request.Digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	}
+	return rsa.SignPKCS1v15(rand.Reader, s.key, hash, request.Digest)
+}
+
+func testKeySignerHandshake(serverConfig *tls.Config, maxVersion uint16) error {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	server := tls.Server(c1, serverConfig)
+	go func() {
+		// the failed server handshake closes the client handshake
+		if server.Handshake() != nil {
+			c1.Close()
+		}
+	}()
+	client := tls.Client(c2, &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion})
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	return client.Handshake()
+}
+
+func TestTLSListenerKeySigner(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	keyPEMBlock, err := ioutil.ReadFile(bundle.ServerKey.Name())
+	a.Nil(err)
+	certPEMBlock, err := ioutil.ReadFile(bundle.ServerCert.Name())
+	a.Nil(err)
+	cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+	a.Nil(err)
+	signer := &testKeySigner{key: cert.PrivateKey.(*rsa.PrivateKey)}
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeySigner.Timeout = time.Second
+	serverConfig, err := newTLSListenerConfig(c, signer)
+	a.Nil(err)
+
+	a.Nil(testKeySignerHandshake(serverConfig, tls.VersionTLS13))
+	a.Nil(testKeySignerHandshake(serverConfig, tls.VersionTLS12))
+	a.Len(signer.requests, 2)
+	a.Equal("RSA", signer.requests[0].KeyType)
+	a.True(signer.requests[0].PSS)
+	a.Equal("SHA-256", signer.requests[0].Hash)
+
+	signer.err = errors.New("HSM unavailable")
+	a.NotNil(testKeySignerHandshake(serverConfig, tls.VersionTLS13))
+
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerKey.Name()
+	_, err = newTLSListenerConfig(c, signer)
+	a.EqualError(err, "Failed to find certificate PEM data in the listener cert file")
+}
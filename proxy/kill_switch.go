@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// KillSwitchPatterns are the regexps matching the whole client id or principal of the killed connections
+type KillSwitchPatterns struct {
+	ClientIDs  []string `json:"client_ids"`
+	Principals []string `json:"principals"`
+}
+
+// KillSwitch cuts off the clients whose client id or principal authenticated by local SASL matches a pattern. The new connections
+// are closed when the client id of their first request or the principal is known, the existing connections are closed when
+// a pattern is added. The patterns are configured and changed at runtime by the HTTP kill switch endpoint.
+type KillSwitch struct {
+	conns *ConnSet
+
+	mu         sync.RWMutex
+	clientIDs  map[string]*regexp.Regexp
+	principals map[string]*regexp.Regexp
+}
+
+// NewKillSwitch creates the kill switch closing the connections of the connection set
+func NewKillSwitch(conns *ConnSet, patterns KillSwitchPatterns) (*KillSwitch, error) {
+	k := &KillSwitch{conns: conns, clientIDs: make(map[string]*regexp.Regexp), principals: make(map[string]*regexp.Regexp)}
+	if err := k.add(patterns); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KillSwitch) add(patterns KillSwitchPatterns) error {
+	clientIDs, err := compileKillSwitchPatterns(patterns.ClientIDs)
+	if err != nil {
+		return err
+	}
+	principals, err := compileKillSwitchPatterns(patterns.Principals)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for pattern, re := range clientIDs {
+		k.clientIDs[pattern] = re
+	}
+	for pattern, re := range principals {
+		k.principals[pattern] = re
+	}
+	return nil
+}
+
+func compileKillSwitchPatterns(patterns []string) (map[string]*regexp.Regexp, error) {
+	result := make(map[string]*regexp.Regexp)
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("kill switch pattern '%s' is invalid: %v", pattern, err)
+		}
+		result[pattern] = re
+	}
+	return result, nil
+}
+
+// Add adds the patterns and closes the existing connections matching them, the number of the closed connections is returned
+func (k *KillSwitch) Add(patterns KillSwitchPatterns) (int, error) {
+	if err := k.add(patterns); err != nil {
+		return 0, err
+	}
+	logger.Infof("Kill switch added the client id patterns %v and the principal patterns %v", patterns.ClientIDs, patterns.Principals)
+	return k.terminate(), nil
+}
+
+// Remove removes the patterns, the new connections of the matching clients are accepted again
+func (k *KillSwitch) Remove(patterns KillSwitchPatterns) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, pattern := range patterns.ClientIDs {
+		delete(k.clientIDs, pattern)
+	}
+	for _, pattern := range patterns.Principals {
+		delete(k.principals, pattern)
+	}
+	logger.Infof("Kill switch removed the client id patterns %v and the principal patterns %v", patterns.ClientIDs, patterns.Principals)
+}
+
+// Patterns returns the sorted patterns of the kill switch
+func (k *KillSwitch) Patterns() KillSwitchPatterns {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	patterns := KillSwitchPatterns{ClientIDs: make([]string, 0, len(k.clientIDs)), Principals: make([]string, 0, len(k.principals))}
+	for pattern := range k.clientIDs {
+		patterns.ClientIDs = append(patterns.ClientIDs, pattern)
+	}
+	for pattern := range k.principals {
+		patterns.Principals = append(patterns.Principals, pattern)
+	}
+	sort.Strings(patterns.ClientIDs)
+	sort.Strings(patterns.Principals)
+	return patterns
+}
+
+// kills returns the attribute matched by a pattern, client_id or principal, and an empty string when the connection is not killed.
+// The unknown (empty) client id and principal are not matched.
+func (k *KillSwitch) kills(principal string, clientID string) string {
+	if k == nil {
+		return ""
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if clientID != "" {
+		for _, re := range k.clientIDs {
+			if re.MatchString(clientID) {
+				return "client_id"
+			}
+		}
+	}
+	if principal != "" {
+		for _, re := range k.principals {
+			if re.MatchString(principal) {
+				return "principal"
+			}
+		}
+	}
+	return ""
+}
+
+// killed counts the connection closed by the kill switch
+func (k *KillSwitch) killed(match string, clientAddress string, principal string, clientID string) {
+	proxyKillSwitchConnectionsTotal.WithLabelValues(match).Inc()
+	logger.Infof("Kill switch closed the connection of client %s, principal '%s', client id '%s'", clientAddress, principal, clientID)
+}
+
+// terminate closes the existing connections matching the patterns
+func (k *KillSwitch) terminate() int {
+	type killedConn struct {
+		conn                               net.Conn
+		match, client, principal, clientID string
+	}
+	var conns []killedConn
+	k.conns.RLock()
+	for conn, m := range k.conns.info {
+		m.mu.Lock()
+		principal, clientID := m.principal, m.clientID
+		m.mu.Unlock()
+		if match := k.kills(principal, clientID); match != "" {
+			conns = append(conns, killedConn{conn: conn, match: match, client: m.client, principal: principal, clientID: clientID})
+		}
+	}
+	k.conns.RUnlock()
+
+	for _, c := range conns {
+		c.conn.Close()
+		k.killed(c.match, c.client, c.principal, c.clientID)
+	}
+	return len(conns)
+}
+
+// ServeHTTP returns the patterns on GET, adds the patterns of the JSON {"client_ids":["batch-.*"],"principals":["alice"]} and closes
+// the matching connections on POST or PUT, and removes the patterns of the JSON on DELETE
+func (k *KillSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		patterns := KillSwitchPatterns{}
+		if err := json.NewDecoder(r.Body).Decode(&patterns); err != nil {
+			http.Error(w, fmt.Sprintf("invalid kill switch patterns: %v", err), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			k.Remove(patterns)
+			break
+		}
+		if _, err := k.Add(patterns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k.Patterns())
+}
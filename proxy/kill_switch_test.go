@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKillSwitch(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *KillSwitch
+	a.Equal("", disabled.kills("alice", "consumer-1"))
+
+	_, err := NewKillSwitch(NewConnSet(), KillSwitchPatterns{ClientIDs: []string{"batch-("}})
+	a.EqualError(err, "kill switch pattern 'batch-(' is invalid: error parsing regexp: missing closing ): `^(?:batch-()$`")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	accept := func() (net.Conn, net.Conn) {
+		client, err := net.Dial("tcp", l.Addr().String())
+		a.Nil(err)
+		conn, err := l.Accept()
+		a.Nil(err)
+		return client, conn
+	}
+	batchClient, batch := accept()
+	defer batchClient.Close()
+	onlineClient, online := accept()
+	defer onlineClient.Close()
+	defer online.Close()
+
+	conns := NewConnSet()
+	conns.Add("kafka-0:9092", batch)
+	conns.Add("kafka-0:9092", online)
+	conns.metadata(batch).setClientID("batch-7")
+	conns.metadata(online).setPrincipal("bob")
+	conns.metadata(online).setClientID("online")
+
+	killSwitch, err := NewKillSwitch(conns, KillSwitchPatterns{Principals: []string{"alice"}})
+	a.Nil(err)
+	a.Equal("principal", killSwitch.kills("alice", "consumer-1"))
+	// the whole principal must match
+	a.Equal("", killSwitch.kills("alice-2", ""))
+
+	// the existing connection of the added client id pattern is closed
+	closed, err := killSwitch.Add(KillSwitchPatterns{ClientIDs: []string{"batch-.*"}})
+	a.Nil(err)
+	a.Equal(1, closed)
+	a.Equal("client_id", killSwitch.kills("bob", "batch-8"))
+	batchClient.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = batchClient.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.False(isTimeout(err))
+
+	server := httptest.NewServer(killSwitch)
+	defer server.Close()
+	do := func(method string, body string) (*http.Response, KillSwitchPatterns) {
+		req, err := http.NewRequest(method, server.URL, bytes.NewBufferString(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		patterns := KillSwitchPatterns{}
+		if resp.StatusCode == http.StatusOK {
+			a.Nil(json.NewDecoder(resp.Body).Decode(&patterns))
+		}
+		return resp, patterns
+	}
+	resp, patterns := do(http.MethodGet, "")
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(KillSwitchPatterns{ClientIDs: []string{"batch-.*"}, Principals: []string{"alice"}}, patterns)
+
+	resp, patterns = do(http.MethodPost, `{"principals":["b.b"]}`)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal([]string{"alice", "b.b"}, patterns.Principals)
+	onlineClient.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = onlineClient.Read(make([]byte, 1))
+	a.NotNil(err)
+	a.False(isTimeout(err))
+
+	resp, patterns = do(http.MethodDelete, `{"client_ids":["batch-.*"],"principals":["alice"]}`)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(KillSwitchPatterns{ClientIDs: []string{}, Principals: []string{"b.b"}}, patterns)
+	a.Equal("", killSwitch.kills("alice", "batch-8"))
+
+	resp, _ = do(http.MethodPut, `{"client_ids":["("]}`)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(http.MethodPost, `[]`)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(http.MethodPatch, "")
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
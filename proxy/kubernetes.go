@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesRequestTimeout    = 10 * time.Second
+	kubernetesPollInterval      = 5 * time.Second
+	maxKubernetesResponseSize   = 1 << 20
+
+	kubernetesServiceTypeLB       = "LoadBalancer"
+	kubernetesServiceTypeNodePort = "NodePort"
+)
+
+// kubernetesService is the part of the service object read by the proxy
+type kubernetesService struct {
+	Spec struct {
+		Type  string `json:"type"`
+		Ports []struct {
+			Port       int32       `json:"port"`
+			TargetPort interface{} `json:"targetPort"` // number or name of the container port
+			NodePort   int32       `json:"nodePort"`
+		} `json:"ports"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP       string `json:"ip"`
+				Hostname string `json:"hostname"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type kubernetesPod struct {
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+type kubernetesNode struct {
+	Status struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+// kubernetesAddress is the external address of the service: the load balancer ingress or the node address,
+// and the external ports by the listener (target) ports
+type kubernetesAddress struct {
+	host  string
+	ports map[int32]int32
+}
+
+// kubernetesClient reads the objects of the API server with the service account of the pod
+type kubernetesClient struct {
+	url       string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// newKubernetesClient creates the in-cluster client, the namespace of the pod is used when the namespace is empty
+func newKubernetesClient(namespace string) (*kubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("proxy is not running in Kubernetes, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(kubernetesServiceAccountDir, "token"))
+	if err != nil {
+		return nil, errors.Wrap(err, "service account token")
+	}
+	caCert, err := ioutil.ReadFile(filepath.Join(kubernetesServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, errors.Wrap(err, "service account CA certificate")
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("service account CA certificate cannot be parsed")
+	}
+	if namespace == "" {
+		data, err := ioutil.ReadFile(filepath.Join(kubernetesServiceAccountDir, "namespace"))
+		if err != nil {
+			return nil, errors.Wrap(err, "namespace of the pod")
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	return &kubernetesClient{
+		url:       "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: namespace,
+		client: &http.Client{
+			Timeout:   kubernetesRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}},
+		},
+	}, nil
+}
+
+func (c *kubernetesClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKubernetesResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s status %d: %s", path, resp.StatusCode, string(data))
+	}
+	return json.Unmarshal(data, v)
+}
+
+// serviceAddress returns the external address of the LoadBalancer or NodePort service, nil while the load balancer is provisioned
+func (c *kubernetesClient) serviceAddress(name string) (*kubernetesAddress, error) {
+	service := &kubernetesService{}
+	if err := c.get(fmt.Sprintf("/api/v1/namespaces/%s/services/%s", c.namespace, name), service); err != nil {
+		return nil, err
+	}
+	address := &kubernetesAddress{ports: make(map[int32]int32)}
+	for _, port := range service.Spec.Ports {
+		// the named target ports are not resolved, the listener port is the service port
+		targetPort := port.Port
+		if number, ok := port.TargetPort.(float64); ok {
+			targetPort = int32(number)
+		}
+		if service.Spec.Type == kubernetesServiceTypeNodePort {
+			address.ports[targetPort] = port.NodePort
+		} else {
+			address.ports[targetPort] = port.Port
+		}
+	}
+	switch service.Spec.Type {
+	case kubernetesServiceTypeLB:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.Hostname != "" {
+				address.host = ingress.Hostname
+				return address, nil
+			}
+			if ingress.IP != "" {
+				address.host = ingress.IP
+				return address, nil
+			}
+		}
+		return nil, nil
+	case kubernetesServiceTypeNodePort:
+		host, err := c.nodeAddress()
+		if err != nil {
+			return nil, err
+		}
+		address.host = host
+		return address, nil
+	default:
+		return nil, fmt.Errorf("service %s/%s of type %s has no external address, supported are %s and %s", c.namespace, name, service.Spec.Type, kubernetesServiceTypeLB, kubernetesServiceTypeNodePort)
+	}
+}
+
+// nodeAddress returns the external or, without it, the internal IP of the node of the pod
+func (c *kubernetesClient) nodeAddress() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	pod := &kubernetesPod{}
+	if err = c.get(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", c.namespace, hostname), pod); err != nil {
+		return "", err
+	}
+	node := &kubernetesNode{}
+	if err = c.get("/api/v1/nodes/"+pod.Spec.NodeName, node); err != nil {
+		return "", err
+	}
+	for _, addressType := range []string{"ExternalIP", "InternalIP"} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("node %s has no IP address", pod.Spec.NodeName)
+}
+
+// waitServiceAddress polls the service until its external address is known or the timeout expires
+func (c *kubernetesClient) waitServiceAddress(name string, timeout time.Duration) (*kubernetesAddress, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		address, err := c.serviceAddress(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "external address of the Kubernetes service %s/%s", c.namespace, name)
+		}
+		if address != nil {
+			return address, nil
+		}
+		if time.Now().Add(kubernetesPollInterval).After(deadline) {
+			return nil, fmt.Errorf("load balancer of the Kubernetes service %s/%s was not provisioned within %v", c.namespace, name, timeout)
+		}
+		logger.Infof("Waiting for the load balancer of the Kubernetes service %s/%s", c.namespace, name)
+		time.Sleep(kubernetesPollInterval)
+	}
+}
+
+// advertise maps the listener addresses to the external address of the service
+func (a *kubernetesAddress) advertise(service string, fn config.NetAddressMappingFunc) config.NetAddressMappingFunc {
+	return func(brokerHost string, brokerPort int32) (string, int32, error) {
+		_, port, err := fn(brokerHost, brokerPort)
+		if err != nil {
+			return "", 0, err
+		}
+		externalPort, ok := a.ports[port]
+		if !ok {
+			return "", 0, fmt.Errorf("port %d of broker %s:%d is not exposed by the Kubernetes service %s", port, brokerHost, brokerPort, service)
+		}
+		return a.host, externalPort, nil
+	}
+}
+
+// kubernetesNetAddressMapping returns the net address mapping advertising the external address of the Kubernetes service of the proxy
+func kubernetesNetAddressMapping(cfg *config.Config, fn config.NetAddressMappingFunc) (config.NetAddressMappingFunc, error) {
+	client, err := newKubernetesClient(cfg.Kubernetes.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	address, err := client.waitServiceAddress(cfg.Kubernetes.Service, cfg.Kubernetes.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Advertising the external address %s of the Kubernetes service %s/%s, ports %v", address.host, client.namespace, cfg.Kubernetes.Service, address.ports)
+	return address.advertise(cfg.Kubernetes.Service, fn), nil
+}
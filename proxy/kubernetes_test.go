@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestKubernetesServiceAddress(t *testing.T) {
+	a := assert.New(t)
+
+	hostname, err := os.Hostname()
+	a.Nil(err)
+	objects := map[string]string{
+		"/api/v1/namespaces/kafka/services/pending": `{"spec":{"type":"LoadBalancer","ports":[{"port":9092,"targetPort":32400}]},"status":{"loadBalancer":{}}}`,
+		"/api/v1/namespaces/kafka/services/lb": `{"spec":{"type":"LoadBalancer","ports":[{"port":9092,"targetPort":32400},{"port":9093,"targetPort":"kafka-1"}]},
+			"status":{"loadBalancer":{"ingress":[{"hostname":"lb.example.com"}]}}}`,
+		"/api/v1/namespaces/kafka/services/node-port":  `{"spec":{"type":"NodePort","ports":[{"port":9092,"targetPort":32400,"nodePort":30400}]}}`,
+		"/api/v1/namespaces/kafka/services/cluster-ip": `{"spec":{"type":"ClusterIP","ports":[{"port":9092}]}}`,
+		"/api/v1/namespaces/kafka/pods/" + hostname:    `{"spec":{"nodeName":"node-1"}}`,
+		"/api/v1/nodes/node-1":                         `{"status":{"addresses":[{"type":"InternalIP","address":"10.0.0.1"},{"type":"ExternalIP","address":"203.0.113.1"}]}}`,
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		object, ok := objects[r.URL.Path]
+		if !ok || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(object))
+	}))
+	defer server.Close()
+	client := &kubernetesClient{url: server.URL, token: "token", namespace: "kafka", client: server.Client()}
+
+	address, err := client.serviceAddress("pending")
+	a.Nil(err)
+	a.Nil(address)
+
+	address, err = client.serviceAddress("lb")
+	a.Nil(err)
+	a.Equal(&kubernetesAddress{host: "lb.example.com", ports: map[int32]int32{32400: 9092, 9093: 9093}}, address)
+	fn := address.advertise("lb", func(brokerHost string, brokerPort int32) (string, int32, error) {
+		return "127.0.0.1", brokerPort + 23308, nil
+	})
+	host, port, err := fn("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("lb.example.com", host)
+	a.Equal(int32(9092), port)
+	_, _, err = fn("kafka-1", 9093)
+	a.EqualError(err, "port 32401 of broker kafka-1:9093 is not exposed by the Kubernetes service lb")
+
+	address, err = client.serviceAddress("node-port")
+	a.Nil(err)
+	a.Equal(&kubernetesAddress{host: "203.0.113.1", ports: map[int32]int32{32400: 30400}}, address)
+
+	_, err = client.serviceAddress("cluster-ip")
+	a.EqualError(err, "service kafka/cluster-ip of type ClusterIP has no external address, supported are LoadBalancer and NodePort")
+	_, err = client.serviceAddress("unknown")
+	a.NotNil(err)
+}
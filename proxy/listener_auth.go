@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+)
+
+// listenerAuth authenticates the connections accepted by a listener and their broker connections
+type listenerAuth struct {
+	authClient   *AuthClient
+	localSasl    *LocalSasl
+	authServer   *AuthServer
+	requestAuthz *RequestAuthz
+}
+
+func newListenerAuth(c *config.Config, passwordAuthenticator apis.PasswordAuthenticator, tokenProvider apis.TokenProvider, tokenInfo apis.TokenInfo, requestAuthorizer apis.RequestAuthorizer) (*listenerAuth, error) {
+	if c.Auth.Local.Enable && passwordAuthenticator == nil {
+		return nil, errors.New("Auth.Local.Enable is enabled but passwordAuthenticator is nil")
+	}
+	if c.Auth.Gateway.Client.Enable && tokenProvider == nil {
+		return nil, errors.New("Auth.Gateway.Client.Enable is enabled but tokenProvider is nil")
+	}
+	var tokenCache *tokenCache
+	if c.Auth.Gateway.Client.Enable && c.Auth.Gateway.Client.TokenCache.Enable {
+		tokenCache = newTokenCache(tokenProvider, c.Auth.Gateway.Client.Timeout, c.Auth.Gateway.Client.TokenCache.TTL, c.Auth.Gateway.Client.TokenCache.RefreshBefore)
+	}
+	if c.Auth.Gateway.Server.Enable && tokenInfo == nil {
+		return nil, errors.New("Auth.Gateway.Server.Enable is enabled but tokenInfo is nil")
+	}
+	if c.Auth.Authz.Enable && requestAuthorizer == nil {
+		return nil, errors.New("Auth.Authz.Enable is enabled but requestAuthorizer is nil")
+	}
+	return &listenerAuth{
+		authClient: &AuthClient{
+			enabled:       c.Auth.Gateway.Client.Enable,
+			magic:         c.Auth.Gateway.Client.Magic,
+			method:        c.Auth.Gateway.Client.Method,
+			timeout:       c.Auth.Gateway.Client.Timeout,
+			tokenProvider: tokenProvider,
+			tokenCache:    tokenCache,
+		},
+		localSasl: &LocalSasl{
+			enabled:            c.Auth.Local.Enable,
+			timeout:            c.Auth.Local.Timeout,
+			sessionLifetime:    c.Auth.Local.SessionLifetime,
+			localAuthenticator: passwordAuthenticator},
+		authServer: &AuthServer{
+			enabled:   c.Auth.Gateway.Server.Enable,
+			magic:     c.Auth.Gateway.Server.Magic,
+			method:    c.Auth.Gateway.Server.Method,
+			timeout:   c.Auth.Gateway.Server.Timeout,
+			tokenInfo: tokenInfo,
+
+			expiryEnable:      c.Auth.Gateway.Server.ExpiryEnable,
+			expiryGracePeriod: c.Auth.Gateway.Server.ExpiryGracePeriod,
+		},
+		requestAuthz: &RequestAuthz{
+			enabled:           c.Auth.Authz.Enable,
+			timeout:           c.Auth.Authz.Timeout,
+			requestAuthorizer: requestAuthorizer,
+		},
+	}, nil
+}
+
+// listenerAuth returns the processor config and the gateway client of the connections accepted by the listener,
+// the listeners without auth settings use the global auth configuration. The responses of the transparent listeners are not rewritten.
+func (c *Client) listenerAuth(listenerAddress string) (ProcessorConfig, *AuthClient) {
+	processorConfig, authClient := c.processorConfig, c.authClient
+	if auth, ok := c.listenerAuths[listenerAddress]; ok {
+		processorConfig.LocalSasl = auth.localSasl
+		processorConfig.AuthServer = auth.authServer
+		processorConfig.RequestAuthz = auth.requestAuthz
+		authClient = auth.authClient
+	}
+	processorConfig.Transparent = c.transparentListeners[listenerAddress]
+	return processorConfig, authClient
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+)
+
+// Logger is the logger of the proxy. The logrus loggers and entries and the zap sugared loggers implement it,
+// the slog loggers are adapted by NewSlogLogger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// logger is used by the proxy, it logs to the logrus standard logger until SetLogger is called
+var logger = newSwappableLogger(logrus.StandardLogger())
+
+// SetLogger replaces the logger of the proxy, nil restores the logrus standard logger
+func SetLogger(l Logger) {
+	if l == nil {
+		l = logrus.StandardLogger()
+	}
+	logger.set(l)
+}
+
+// swappableLogger delegates to the logger which can be replaced while the proxy is running
+type swappableLogger struct {
+	value atomic.Value
+}
+
+// loggerHolder keeps the concrete type stored in the atomic value the same
+type loggerHolder struct {
+	Logger
+}
+
+func newSwappableLogger(l Logger) *swappableLogger {
+	s := &swappableLogger{}
+	s.set(l)
+	return s
+}
+
+func (s *swappableLogger) set(l Logger) {
+	s.value.Store(loggerHolder{l})
+}
+
+func (s *swappableLogger) get() Logger {
+	return s.value.Load().(loggerHolder).Logger
+}
+
+func (s *swappableLogger) Debugf(format string, args ...interface{}) {
+	s.get().Debugf(format, args...)
+}
+
+func (s *swappableLogger) Infof(format string, args ...interface{}) {
+	s.get().Infof(format, args...)
+}
+
+func (s *swappableLogger) Warnf(format string, args ...interface{}) {
+	s.get().Warnf(format, args...)
+}
+
+func (s *swappableLogger) Errorf(format string, args ...interface{}) {
+	s.get().Errorf(format, args...)
+}
@@ -0,0 +1,43 @@
+//go:build go1.21
+// +build go1.21
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// NewSlogLogger adapts the slog logger to the logger of the proxy, the messages are formatted by fmt.Sprintf
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{logger: l}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, format, args)
+}
+
+func (l slogLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args)
+}
+
+func (l slogLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args)
+}
+
+func (l slogLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args)
+}
+
+func (l slogLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	l.logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
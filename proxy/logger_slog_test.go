@@ -0,0 +1,22 @@
+//go:build go1.21
+// +build go1.21
+
+package proxy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	a := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	l.Debugf("request %d", 1)
+	l.Warnf("broker %s is draining", "kafka-0:9092")
+	a.NotContains(buf.String(), "request 1")
+	a.Contains(buf.String(), `level=WARN msg="broker kafka-0:9092 is draining"`)
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "debug "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "info "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warn "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "error "+fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	a := assert.New(t)
+
+	l := &testLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	logger.Debugf("request %d", 1)
+	logger.Infof("connection from %s", "10.0.0.1")
+	logger.Warnf("broker %s is draining", "kafka-0:9092")
+	logger.Errorf("failed: %v", "timeout")
+	a.Equal([]string{"debug request 1", "info connection from 10.0.0.1", "warn broker kafka-0:9092 is draining", "error failed: timeout"}, l.messages)
+
+	SetLogger(nil)
+	a.Equal(logrus.StandardLogger(), logger.get())
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaintenanceStatus is the state of the maintenance mode
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	// the existing connections are closed after the grace period e.g. 5m, they are kept when empty
+	GracePeriod string `json:"grace_period,omitempty"`
+}
+
+// Maintenance rejects the new client connections during planned work. The first request of a new connection is answered
+// with the BROKER_NOT_AVAILABLE error and the connection is closed, the existing connections drain.
+// The maintenance mode is entered and left at runtime by the HTTP maintenance endpoint.
+type Maintenance struct {
+	conns   *ConnSet
+	timeout time.Duration
+
+	mu         sync.Mutex
+	status     MaintenanceStatus
+	closeTimer *time.Timer
+}
+
+// NewMaintenance creates the maintenance mode closing the connections of the connection set, the timeout is the time to wait
+// for the first request of a rejected connection
+func NewMaintenance(conns *ConnSet, timeout time.Duration) *Maintenance {
+	return &Maintenance{conns: conns, timeout: timeout}
+}
+
+// Enter rejects the new connections, the existing connections are closed after the grace period when it is greater or equal 0
+func (m *Maintenance) Enter(gracePeriod time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.status.Enabled {
+		now := time.Now()
+		m.status = MaintenanceStatus{Enabled: true, Since: &now}
+		proxyMaintenance.Set(1)
+	}
+	if m.closeTimer != nil {
+		m.closeTimer.Stop()
+		m.closeTimer = nil
+	}
+	m.status.GracePeriod = ""
+	if gracePeriod >= 0 {
+		m.status.GracePeriod = gracePeriod.String()
+		m.closeTimer = time.AfterFunc(gracePeriod, m.closeConns)
+	}
+	logger.Infof("Entered maintenance mode, grace period of the connections '%s'", m.status.GracePeriod)
+}
+
+// Leave accepts the new connections again
+func (m *Maintenance) Leave() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closeTimer != nil {
+		m.closeTimer.Stop()
+		m.closeTimer = nil
+	}
+	if m.status.Enabled {
+		logger.Infof("Left maintenance mode")
+	}
+	m.status = MaintenanceStatus{}
+	proxyMaintenance.Set(0)
+}
+
+// Status returns the state of the maintenance mode
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// active returns true when the new connections are rejected
+func (m *Maintenance) active() bool {
+	if m == nil {
+		return false
+	}
+	return m.Status().Enabled
+}
+
+func (m *Maintenance) closeConns() {
+	if !m.active() {
+		return
+	}
+	conns := m.conns.Conns(m.conns.IDs()...)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	logger.Infof("Closed %d connections in maintenance mode", len(conns))
+}
+
+// reject answers the first request of the new connection with an error response and closes the connection
+func (m *Maintenance) reject(conn net.Conn) {
+	defer conn.Close()
+	proxyMaintenanceRejectedConnectionsTotal.Inc()
+
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		return
+	}
+	keyVersionBuf := make([]byte, 8) // Size => int32 + ApiKey => int16 + ApiVersion => int16
+	if _, err := io.ReadFull(conn, keyVersionBuf); err != nil {
+		return
+	}
+	requestKeyVersion := &protocol.RequestKeyVersion{}
+	if err := protocol.Decode(keyVersionBuf, requestKeyVersion); err != nil || requestKeyVersion.Length < 8 {
+		return
+	}
+	request, err := readRequest(conn, keyVersionBuf, requestKeyVersion, m.timeout)
+	if err != nil {
+		return
+	}
+	if response := encodeErrorResponse(request, int16(protocol.ErrBrokerNotAvailable)); response != nil {
+		correlationID := int32(binary.BigEndian.Uint32(request[4:]))
+		if err = writeResponse(conn, correlationID, response); err != nil {
+			logger.Debugf("maintenance response to %s failed: %v", conn.RemoteAddr(), err)
+		}
+	}
+	logger.Infof("rejected connection from %s in maintenance mode", conn.RemoteAddr())
+}
+
+// encodeErrorResponse returns the response body to the request (without the size) failing with the error code, also for the ApiVersions
+// and SaslHandshake requests. Nil is returned when the response cannot be encoded or the client does not expect a response.
+func encodeErrorResponse(request []byte, errorCode int16) []byte {
+	apiKey := int16(binary.BigEndian.Uint16(request))
+	apiVersion := int16(binary.BigEndian.Uint16(request[2:]))
+
+	switch apiKey {
+	case apiKeyApiApiVersions:
+		if apiVersion >= 3 {
+			// error_code, empty compact api_keys array, throttle_time_ms, no tagged fields
+			response := make([]byte, 8)
+			binary.BigEndian.PutUint16(response, uint16(errorCode))
+			response[2] = 1
+			return response
+		}
+		// error_code, empty api_versions array, throttle_time_ms (v1+)
+		response := make([]byte, 6, 10)
+		binary.BigEndian.PutUint16(response, uint16(errorCode))
+		if apiVersion >= 1 {
+			response = response[:10]
+		}
+		return response
+	case apiKeySaslHandshake:
+		// error_code, empty enabled_mechanisms array
+		response := make([]byte, 6)
+		binary.BigEndian.PutUint16(response, uint16(errorCode))
+		return response
+	}
+	response, err := protocol.EncodeErrorResponse(request, errorCode)
+	if err != nil {
+		return nil
+	}
+	return response
+}
+
+// ServeHTTP returns the state of the maintenance mode on GET, enters the maintenance mode with the optional JSON {"grace_period":"5m"}
+// on POST or PUT and leaves it on DELETE
+func (m *Maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		status := MaintenanceStatus{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+				http.Error(w, fmt.Sprintf("invalid maintenance mode: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		gracePeriod := time.Duration(-1)
+		if status.GracePeriod != "" {
+			var err error
+			if gracePeriod, err = time.ParseDuration(status.GracePeriod); err != nil || gracePeriod < 0 {
+				http.Error(w, fmt.Sprintf("invalid maintenance mode: grace period '%s' must be a non-negative duration", status.GracePeriod), http.StatusBadRequest)
+				return
+			}
+		}
+		m.Enter(gracePeriod)
+	case http.MethodDelete:
+		m.Leave()
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
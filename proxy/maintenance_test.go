@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeErrorResponse(t *testing.T) {
+	a := assert.New(t)
+	errorCode := int16(protocol.ErrBrokerNotAvailable)
+
+	// ApiVersions v0 and v1 request: api key, api version, correlation id, client id
+	a.Equal([]byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x00}, encodeErrorResponse([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff}, errorCode))
+	a.Equal([]byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, encodeErrorResponse([]byte{0x00, 0x12, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff}, errorCode))
+	// ApiVersions v3 with the compact api keys array
+	a.Equal([]byte{0x00, 0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}, encodeErrorResponse([]byte{0x00, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00}, errorCode))
+	// SaslHandshake
+	a.Equal([]byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x00}, encodeErrorResponse([]byte{0x00, 0x11, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x05, 'P', 'L', 'A', 'I', 'N'}, errorCode))
+
+	response := encodeErrorResponse(testMetadataRequest[4:], errorCode)
+	a.NotNil(response)
+	expected, err := protocol.EncodeErrorResponse(testMetadataRequest[4:], errorCode)
+	a.Nil(err)
+	a.Equal(expected, response)
+}
+
+func TestMaintenanceReject(t *testing.T) {
+	a := assert.New(t)
+
+	conns := NewConnSet()
+	maintenance := NewMaintenance(conns, time.Second)
+	a.False(maintenance.active())
+
+	existingClient, existing := net.Pipe()
+	defer existingClient.Close()
+	conns.Add("kafka-0:9092", existing)
+
+	maintenance.Enter(-1)
+	a.True(maintenance.active())
+	a.Equal("", maintenance.Status().GracePeriod)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &Client{maintenance: maintenance}
+	go c.handleConn(Conn{BrokerAddress: "kafka-0:9092", LocalConnection: server})
+
+	// ApiVersions v1 with correlation id 7
+	_, err := client.Write([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x12, 0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0xff, 0xff})
+	a.Nil(err)
+	response := make([]byte, 18)
+	_, err = io.ReadFull(client, response)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x0e, 0x00, 0x00, 0x00, 0x07, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, response)
+	_, err = client.Read(response)
+	a.Equal(io.EOF, err)
+
+	// the existing connections are closed after the grace period
+	maintenance.Enter(10 * time.Millisecond)
+	a.Equal("10ms", maintenance.Status().GracePeriod)
+	existingClient.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = existingClient.Read(response)
+	a.Equal(io.EOF, err)
+
+	maintenance.Leave()
+	a.False(maintenance.active())
+	a.Equal(MaintenanceStatus{}, maintenance.Status())
+
+	var nilMaintenance *Maintenance
+	a.False(nilMaintenance.active())
+}
+
+func TestMaintenanceHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	maintenance := NewMaintenance(NewConnSet(), time.Second)
+	server := httptest.NewServer(maintenance)
+	defer server.Close()
+
+	do := func(method string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		a.Nil(err)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	status, body := do(http.MethodGet, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+
+	status, body = do(http.MethodPost, "")
+	a.Equal(http.StatusOK, status)
+	a.Contains(body, `"enabled":true`)
+	a.NotContains(body, `grace_period`)
+	a.True(maintenance.active())
+
+	status, body = do(http.MethodPut, `{"grace_period":"1h"}`)
+	a.Equal(http.StatusOK, status)
+	a.Contains(body, `"grace_period":"1h0m0s"`)
+
+	status, _ = do(http.MethodPost, `{"grace_period":"-1s"}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPatch, "")
+	a.Equal(http.StatusMethodNotAllowed, status)
+
+	status, body = do(http.MethodDelete, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"enabled":false}`, body)
+	a.False(maintenance.active())
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryBudget limits the bytes of the requests and responses buffered by all connections at once. A connection waits for the
+// budget before it buffers the body of a message, so the sockets are not read while the budget is exhausted and the clients and
+// brokers are slowed down instead of the proxy being killed for running out of memory. The streamed messages are not charged.
+type MemoryBudget struct {
+	// the bytes in use, first in the struct for the 64-bit alignment of the atomic operations
+	used int64
+	// the number of the readers waiting for the budget
+	waiters int32
+
+	maxBytes int64
+	// the new connections are closed while the budget is exhausted
+	rejectConnections bool
+
+	mu sync.Mutex
+	// broadcast when the bytes are released while a reader is waiting
+	released *sync.Cond
+
+	usedBytes prometheus.Gauge
+}
+
+func NewMemoryBudget(maxBytes int64, rejectConnections bool) *MemoryBudget {
+	proxyMemoryBudgetBytes.WithLabelValues("limit").Set(float64(maxBytes))
+	usedBytes := proxyMemoryBudgetBytes.WithLabelValues("used")
+	usedBytes.Set(0)
+	b := &MemoryBudget{maxBytes: maxBytes, rejectConnections: rejectConnections, usedBytes: usedBytes}
+	b.released = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until the message of n bytes fits into the budget. The message larger than the budget is transferred alone.
+func (b *MemoryBudget) acquire(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	if !b.tryAcquire(n) {
+		proxyMemoryBudgetWaitsTotal.Inc()
+		b.mu.Lock()
+		// the waiter is counted before the bytes are checked again, release broadcasts after the bytes are returned
+		atomic.AddInt32(&b.waiters, 1)
+		for !b.tryAcquire(n) {
+			b.released.Wait()
+		}
+		atomic.AddInt32(&b.waiters, -1)
+		b.mu.Unlock()
+	}
+	b.usedBytes.Add(float64(n))
+}
+
+func (b *MemoryBudget) tryAcquire(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used != 0 && used+n > b.maxBytes {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+// release returns the bytes of the transferred message to the budget
+func (b *MemoryBudget) release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&b.used, -n)
+	b.usedBytes.Sub(float64(n))
+	if atomic.LoadInt32(&b.waiters) != 0 {
+		b.mu.Lock()
+		b.released.Broadcast()
+		b.mu.Unlock()
+	}
+}
+
+// rejects returns true when the new connections are closed as the budget is exhausted
+func (b *MemoryBudget) rejects() bool {
+	if b == nil || !b.rejectConnections {
+		return false
+	}
+	return atomic.LoadInt64(&b.used) >= b.maxBytes
+}
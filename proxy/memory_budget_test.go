@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	a := assert.New(t)
+
+	var unlimited *MemoryBudget
+	unlimited.acquire(1 << 30)
+	unlimited.release(1 << 30)
+	a.False(unlimited.rejects())
+
+	budget := NewMemoryBudget(100, true)
+	budget.acquire(60)
+	a.False(budget.rejects())
+
+	// the message over the budget waits until the bytes are released
+	waits := testCounterValue(a, proxyMemoryBudgetWaitsTotal)
+	acquired := make(chan struct{})
+	go func() {
+		budget.acquire(50)
+		close(acquired)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-acquired:
+		a.Fail("message over the budget was not blocked")
+	default:
+	}
+	budget.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		a.Fail("message was not read after the bytes were released")
+	}
+	a.Equal(waits+1, testCounterValue(a, proxyMemoryBudgetWaitsTotal))
+	a.Equal(float64(50), testGaugeValue(a, proxyMemoryBudgetBytes.WithLabelValues("used")))
+
+	budget.acquire(50)
+	a.True(budget.rejects())
+	budget.release(50)
+	budget.release(50)
+
+	// the message larger than the budget is transferred alone
+	budget.acquire(500)
+	a.True(budget.rejects())
+	budget.release(500)
+	a.False(budget.rejects())
+	a.Equal(float64(0), testGaugeValue(a, proxyMemoryBudgetBytes.WithLabelValues("used")))
+
+	a.False(NewMemoryBudget(100, false).rejects())
+}
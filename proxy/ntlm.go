@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM message flags, see MS-NLMP 2.2.2.5
+const (
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmNegotiateOEM                     = 0x00000002
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiateKeyExchange             = 0x40000000
+	ntlmNegotiate56                      = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+const (
+	ntlmAvEOL       = 0
+	ntlmAvTimestamp = 7
+	// 100ns intervals between 1601-01-01 and 1970-01-01
+	ntlmFiletimeEpoch = 116444736000000000
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmChallenge is the CHALLENGE_MESSAGE of the server
+type ntlmChallenge struct {
+	flags           uint32
+	serverChallenge []byte
+	targetInfo      []byte
+}
+
+// ntlmNegotiateMessage returns the NEGOTIATE_MESSAGE without the domain and the workstation
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	return msg
+}
+
+// parseNTLMChallenge parses the CHALLENGE_MESSAGE
+func parseNTLMChallenge(msg []byte) (*ntlmChallenge, error) {
+	if len(msg) < 48 || !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	challenge := &ntlmChallenge{
+		flags:           binary.LittleEndian.Uint32(msg[20:]),
+		serverChallenge: msg[24:32],
+	}
+	length, offset := int(binary.LittleEndian.Uint16(msg[40:])), int(binary.LittleEndian.Uint32(msg[44:]))
+	if offset > len(msg) || length > len(msg)-offset {
+		return nil, errors.New("invalid NTLM challenge message target info")
+	}
+	challenge.targetInfo = msg[offset : offset+length]
+	return challenge, nil
+}
+
+// ntlmAuthenticateMessage returns the AUTHENTICATE_MESSAGE with the NTLMv2 response to the challenge,
+// the domain can be given in the username as DOMAIN\user
+func ntlmAuthenticateMessage(username, password string, challenge *ntlmChallenge) ([]byte, error) {
+	var domain string
+	if i := strings.Index(username, `\`); i >= 0 {
+		domain, username = username[:i], username[i+1:]
+	}
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp, serverTimestamp := ntlmTimestamp(challenge.targetInfo)
+	if !serverTimestamp {
+		timestamp = uint64(time.Now().UnixNano()/100) + ntlmFiletimeEpoch
+	}
+	responseKey := ntlmOWFv2(username, password, domain)
+	ntResponse := ntlmV2Response(responseKey, challenge.serverChallenge, clientChallenge, timestamp, challenge.targetInfo)
+	// the LMv2 response is not sent when the server gave the timestamp
+	lmResponse := make([]byte, 24)
+	if !serverTimestamp {
+		lmResponse = append(hmacMD5(responseKey, challenge.serverChallenge, clientChallenge), clientChallenge...)
+	}
+	// no session key is exchanged, the connection is neither signed nor sealed
+	flags := challenge.flags &^ ntlmNegotiateKeyExchange
+
+	fields := [][]byte{lmResponse, ntResponse, encodeUTF16LE(domain), encodeUTF16LE(username), nil, nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	for i, field := range fields {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(len(msg)))
+		msg = append(msg, field...)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	return msg, nil
+}
+
+// ntlmTimestamp returns the MsvAvTimestamp of the target info
+func ntlmTimestamp(targetInfo []byte) (uint64, bool) {
+	for len(targetInfo) >= 4 {
+		id, length := binary.LittleEndian.Uint16(targetInfo), int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == ntlmAvEOL || length > len(targetInfo)-4 {
+			break
+		}
+		if id == ntlmAvTimestamp && length == 8 {
+			return binary.LittleEndian.Uint64(targetInfo[4:]), true
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+	return 0, false
+}
+
+// ntlmOWFv2 is the NTOWFv2 response key of MS-NLMP 3.3.2
+func ntlmOWFv2(username, password, domain string) []byte {
+	hash := md4Sum(encodeUTF16LE(password))
+	return hmacMD5(hash[:], encodeUTF16LE(strings.ToUpper(username)+domain))
+}
+
+// ntlmV2Response is the NtChallengeResponse of MS-NLMP 3.3.2: NTProofStr followed by the client blob
+func ntlmV2Response(responseKey, serverChallenge, clientChallenge []byte, timestamp uint64, targetInfo []byte) []byte {
+	blob := make([]byte, 28, 28+len(targetInfo)+4)
+	blob[0], blob[1] = 1, 1
+	binary.LittleEndian.PutUint64(blob[8:], timestamp)
+	copy(blob[16:], clientChallenge)
+	blob = append(blob, targetInfo...)
+	blob = append(blob, 0, 0, 0, 0)
+	return append(hmacMD5(responseKey, serverChallenge, blob), blob...)
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func encodeUTF16LE(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+// md4Sum is the MD4 digest of RFC 1320 used by the NT hash of the password
+func md4Sum(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	msg := append(append(make([]byte, 0, len(data)+72), data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = append(msg, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(msg[len(msg)-8:], uint64(len(data))*8)
+
+	var x [16]uint32
+	for block := msg; len(block) > 0; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for _, i := range []int{0, 4, 8, 12} {
+			a = bits.RotateLeft32(a+((b&c)|(^b&d))+x[i], 3)
+			d = bits.RotateLeft32(d+((a&b)|(^a&c))+x[i+1], 7)
+			c = bits.RotateLeft32(c+((d&a)|(^d&b))+x[i+2], 11)
+			b = bits.RotateLeft32(b+((c&d)|(^c&a))+x[i+3], 19)
+		}
+		for _, i := range []int{0, 1, 2, 3} {
+			a = bits.RotateLeft32(a+((b&c)|(b&d)|(c&d))+x[i]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+((a&b)|(a&c)|(b&c))+x[i+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+((d&a)|(d&b)|(a&b))+x[i+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+((c&d)|(c&a)|(d&a))+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []int{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+(b^c^d)+x[i]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+(a^b^c)+x[i+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+(d^a^b)+x[i+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+(c^d^a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
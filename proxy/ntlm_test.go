@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMD4Sum(t *testing.T) {
+	a := assert.New(t)
+
+	for input, expected := range map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		sum := md4Sum([]byte(input))
+		a.Equal(expected, hex.EncodeToString(sum[:]))
+	}
+}
+
+// test vectors of MS-NLMP 4.2.4
+var testNTLMTargetInfo, _ = hex.DecodeString("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+
+func TestNTLMv2Response(t *testing.T) {
+	a := assert.New(t)
+
+	responseKey := ntlmOWFv2("User", "Password", "Domain")
+	a.Equal("0c868a403bfd7a93a3001ef22ef02e3f", hex.EncodeToString(responseKey))
+
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge, _ := hex.DecodeString("aaaaaaaaaaaaaaaa")
+	response := ntlmV2Response(responseKey, serverChallenge, clientChallenge, 0, testNTLMTargetInfo)
+	a.Equal("68cd0ab851e51c96aabc927bebef6a1c", hex.EncodeToString(response[:16]))
+}
+
+func testNTLMChallengeMessage(serverChallenge []byte, targetInfo []byte) []byte {
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[16:], 48)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateFlags|ntlmNegotiateKeyExchange)
+	copy(msg[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	return append(msg, targetInfo...)
+}
+
+// testNTLMField returns the payload of the field of the AUTHENTICATE_MESSAGE
+func testNTLMField(msg []byte, i int) []byte {
+	length, offset := binary.LittleEndian.Uint16(msg[12+8*i:]), binary.LittleEndian.Uint32(msg[16+8*i:])
+	return msg[offset : offset+uint32(length)]
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := parseNTLMChallenge([]byte("NTLMSSP\x00"))
+	a.EqualError(err, "invalid NTLM challenge message")
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	challengeMessage := testNTLMChallengeMessage(serverChallenge, testNTLMTargetInfo)
+	_, err = parseNTLMChallenge(challengeMessage[:60])
+	a.EqualError(err, "invalid NTLM challenge message target info")
+
+	challenge, err := parseNTLMChallenge(challengeMessage)
+	a.Nil(err)
+	a.Equal(serverChallenge, challenge.serverChallenge)
+	a.Equal(testNTLMTargetInfo, challenge.targetInfo)
+
+	msg, err := ntlmAuthenticateMessage(`Domain\User`, "Password", challenge)
+	a.Nil(err)
+	a.Equal(ntlmSignature, msg[:8])
+	a.Equal(uint32(3), binary.LittleEndian.Uint32(msg[8:]))
+	a.Equal(uint32(ntlmNegotiateFlags), binary.LittleEndian.Uint32(msg[60:]))
+	a.Equal(encodeUTF16LE("Domain"), testNTLMField(msg, 2))
+	a.Equal(encodeUTF16LE("User"), testNTLMField(msg, 3))
+	a.Len(testNTLMField(msg, 0), 24)
+
+	// the NTProofStr is verified with the blob of the client
+	ntResponse := testNTLMField(msg, 1)
+	a.Equal(hmacMD5(ntlmOWFv2("User", "Password", "Domain"), serverChallenge, ntResponse[16:]), ntResponse[:16])
+}
+
+// testNTLMProxy serves the NTLM handshake of alice from the CORP domain on the proxy connection and echoes the tunneled client
+func testNTLMProxy(conn net.Conn, serverChallenge []byte) bool {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Header.Get("Proxy-Authorization") != "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()) {
+		return false
+	}
+	conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"proxy\"\r\nProxy-Authenticate: NTLM " +
+		base64.StdEncoding.EncodeToString(testNTLMChallengeMessage(serverChallenge, testNTLMTargetInfo)) + "\r\nContent-Length: 6\r\n\r\ndenied"))
+	req, err = http.ReadRequest(br)
+	if err != nil || req.Host != "kafka-0:9092" {
+		return false
+	}
+	msg, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Header.Get("Proxy-Authorization"), "NTLM "))
+	if err != nil || len(msg) < 64 {
+		return false
+	}
+	ntResponse := testNTLMField(msg, 1)
+	if string(hmacMD5(ntlmOWFv2("alice", "secret", "CORP"), serverChallenge, ntResponse[16:])) != string(ntResponse[:16]) {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+		return false
+	}
+	testEchoTunnel(conn, br)
+	return true
+}
+
+// testEchoTunnel establishes the tunnel and echoes the ping of the client
+func testEchoTunnel(conn net.Conn, br *bufio.Reader) {
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err == nil {
+		conn.Write(buf)
+	}
+}
+
+// testPingTunnel sends the ping through the tunnel
+func testPingTunnel(a *assert.Assertions, conn net.Conn) {
+	_, err := conn.Write([]byte("ping"))
+	a.Nil(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal("ping", string(buf))
+	conn.Close()
+}
+
+func TestHttpProxyNTLM(t *testing.T) {
+	a := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	authorized := make(chan bool, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		authorized <- testNTLMProxy(conn, serverChallenge)
+	}()
+
+	dialer := &httpProxy{
+		forwardDialer: directDialer{dialTimeout: 3 * time.Second},
+		network:       "tcp",
+		hostPort:      ln.Addr().String(),
+		username:      `CORP\alice`,
+		password:      "secret",
+		auth:          "ntlm",
+	}
+	conn, err := dialer.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	// the proxy authorizes the client after the tunnel is closed
+	testPingTunnel(a, conn)
+	a.True(<-authorized)
+}
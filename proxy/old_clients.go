@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLoggedOldClients limits the remembered client ids, api keys and versions whose first request is logged
+const maxLoggedOldClients = 10000
+
+type oldClientRequest struct {
+	clientID   string
+	apiKey     int16
+	apiVersion int16
+}
+
+// OldClients refuses the requests with the api versions lower than the configured minimum versions e.g. the Produce requests
+// before version 3 with the message format older than 0.11. The refused requests are answered with the UNSUPPORTED_VERSION error,
+// the connections of the requests without a response are closed. With the log policy the requests are only logged and counted,
+// so the clients to be upgraded are found before a broker upgrade.
+type OldClients struct {
+	minVersions map[int16]int16
+	reject      bool
+
+	mu     sync.Mutex
+	logged map[oldClientRequest]struct{}
+}
+
+// NewOldClients creates the refusal of the old api versions given as apiKey=minVersion e.g. 0=3, the policy is reject or log
+func NewOldClients(minVersions []string, policy string) (*OldClients, error) {
+	o := &OldClients{minVersions: make(map[int16]int16), reject: policy == "reject", logged: make(map[oldClientRequest]struct{})}
+	for _, value := range minVersions {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("min api version '%s' must be apiKey=version", value)
+		}
+		apiKey, err := strconv.ParseInt(pair[0], 10, 16)
+		if err != nil || int16(apiKey) < minRequestApiKey || int16(apiKey) > maxRequestApiKey {
+			return nil, fmt.Errorf("min api version '%s' has invalid api key %s", value, pair[0])
+		}
+		apiVersion, err := strconv.ParseInt(pair[1], 10, 16)
+		if err != nil || apiVersion < 0 {
+			return nil, fmt.Errorf("min api version '%s' has invalid version %s", value, pair[1])
+		}
+		o.minVersions[int16(apiKey)] = int16(apiVersion)
+	}
+	return o, nil
+}
+
+// deprecated checks whether the api version of the request is lower than the minimum version
+func (o *OldClients) deprecated(apiKey int16, apiVersion int16) bool {
+	if o == nil {
+		return false
+	}
+	minVersion, ok := o.minVersions[apiKey]
+	return ok && apiVersion < minVersion
+}
+
+// refuses counts and logs the request (without the size) with the deprecated api version, true is returned when it is rejected
+func (o *OldClients) refuses(clientAddress string, request []byte) bool {
+	info, err := protocol.DecodeRequestHeader(request)
+	if err != nil {
+		return o.reject
+	}
+	policy := "log"
+	if o.reject {
+		policy = "reject"
+	}
+	proxyOldClientRequestsTotal.WithLabelValues(strconv.Itoa(int(info.ApiKey)), strconv.Itoa(int(info.ApiVersion)), policy).Inc()
+
+	key := oldClientRequest{clientID: info.ClientID, apiKey: info.ApiKey, apiVersion: info.ApiVersion}
+	o.mu.Lock()
+	_, logged := o.logged[key]
+	if !logged && len(o.logged) < maxLoggedOldClients {
+		o.logged[key] = struct{}{}
+	}
+	o.mu.Unlock()
+	if !logged {
+		action := "should be upgraded"
+		if o.reject {
+			action = "was refused"
+		}
+		logger.Warnf("Client %s with client id '%s' sent %s version %d lower than the min version %d and %s", clientAddress, info.ClientID,
+			protocol.ApiKeyName(info.ApiKey), info.ApiVersion, o.minVersions[info.ApiKey], action)
+	}
+	return o.reject
+}
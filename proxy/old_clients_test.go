@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOldClients(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *OldClients
+	a.False(disabled.deprecated(apiKeyProduce, 0))
+
+	_, err := NewOldClients([]string{"0"}, "reject")
+	a.EqualError(err, "min api version '0' must be apiKey=version")
+	_, err = NewOldClients([]string{"produce=3"}, "reject")
+	a.EqualError(err, "min api version 'produce=3' has invalid api key produce")
+	_, err = NewOldClients([]string{"0=-1"}, "reject")
+	a.EqualError(err, "min api version '0=-1' has invalid version -1")
+
+	o, err := NewOldClients([]string{"0=3", "18=1"}, "reject")
+	a.Nil(err)
+	a.True(o.deprecated(apiKeyProduce, 2))
+	a.False(o.deprecated(apiKeyProduce, 3))
+	a.True(o.deprecated(apiKeyApiApiVersions, 0))
+	a.False(o.deprecated(apiKeyFetch, 0))
+
+	// ApiVersions v0 with the client id legacy
+	request := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x06, 'l', 'e', 'g', 'a', 'c', 'y'}
+	a.True(o.refuses("10.0.0.5:51000", request))
+	a.True(o.refuses("10.0.0.5:51001", request))
+	a.Len(o.logged, 1)
+
+	o, err = NewOldClients([]string{"18=1"}, "log")
+	a.Nil(err)
+	a.False(o.refuses("10.0.0.5:51000", request))
+}
+
+func TestDefaultRequestHandlerOldClients(t *testing.T) {
+	a := assert.New(t)
+
+	oldClients, err := NewOldClients([]string{"18=1"}, "reject")
+	a.Nil(err)
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	nextRequestHandlerChannel := make(chan RequestHandler, 1)
+	nextResponseHandlerChannel := make(chan ResponseHandler, 1)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  nextRequestHandlerChannel,
+		nextResponseHandlerChannel: nextResponseHandlerChannel,
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		oldClients:                 oldClients,
+	}
+	withSize := func(request []byte) []byte {
+		return append([]byte{0x00, 0x00, 0x00, byte(len(request))}, request...)
+	}
+	handle := func(request []byte) []byte {
+		client, local := net.Pipe()
+		remote, broker := net.Pipe()
+		go func() {
+			client.Write(withSize(request))
+			client.Close()
+		}()
+		received := make(chan []byte, 1)
+		go func() {
+			buf, _ := ioutil.ReadAll(broker)
+			received <- buf
+		}()
+		_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+		a.Nil(err)
+		remote.Close()
+		<-nextRequestHandlerChannel
+		<-nextResponseHandlerChannel
+		return <-received
+	}
+
+	// ApiVersions v0 is answered with UNSUPPORTED_VERSION
+	handle([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x06, 'l', 'e', 'g', 'a', 'c', 'y'})
+	requestKeyVersion := <-openRequestsChannel
+	a.Equal([]byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x00}, requestKeyVersion.LocalResponse)
+
+	// ApiVersions v1 is sent to the broker
+	request := []byte{0x00, 0x12, 0x00, 0x01, 0x00, 0x00, 0x00, 0x08, 0x00, 0x03, 'n', 'e', 'w'}
+	a.Equal(withSize(request), handle(request))
+	requestKeyVersion = <-openRequestsChannel
+	a.Nil(requestKeyVersion.LocalResponse)
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpExportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	otlpScopeName    = "github.com/grepplabs/kafka-proxy"
+
+	// AggregationTemporality
+	otlpCumulative = 2
+)
+
+// OTLPExporter pushes the metrics of the gatherer to an OpenTelemetry collector over OTLP/gRPC.
+// The counters are exported as cumulative monotonic sums, the gauges as gauges, the histograms and the summaries as cumulative histograms and summaries.
+type OTLPExporter struct {
+	gatherer    prometheus.Gatherer
+	conn        *grpc.ClientConn
+	headers     metadata.MD
+	serviceName string
+	interval    time.Duration
+	timeout     time.Duration
+	startTime   time.Time
+
+	stopPush chan struct{}
+	stopOnce sync.Once
+}
+
+func NewOTLPExporter(gatherer prometheus.Gatherer, conf *config.Config) (*OTLPExporter, error) {
+	opts := conf.Otlp
+
+	dialOption := grpc.WithInsecure()
+	if !opts.Insecure {
+		tlsConfig, err := newClientTLSConfig(false, "", "", "", opts.CAChainCertFile)
+		if err != nil {
+			return nil, err
+		}
+		dialOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	conn, err := grpc.Dial(opts.Endpoint, dialOption)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to OTLP endpoint %s", opts.Endpoint)
+	}
+	headers := metadata.MD{}
+	for _, header := range opts.Headers {
+		kv := strings.SplitN(header, "=", 2)
+		headers = metadata.Join(headers, metadata.Pairs(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])))
+	}
+	logger.Infof("Metrics are pushed to OTLP endpoint %s every %v", opts.Endpoint, opts.Interval)
+	return &OTLPExporter{
+		gatherer:    gatherer,
+		conn:        conn,
+		headers:     headers,
+		serviceName: opts.ServiceName,
+		interval:    opts.Interval,
+		timeout:     opts.Timeout,
+		startTime:   time.Now(),
+		stopPush:    make(chan struct{}),
+	}, nil
+}
+
+// Run pushes the metrics until the exporter is closed, the metrics are pushed for the last time on close
+func (e *OTLPExporter) Run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	defer e.conn.Close()
+	for {
+		select {
+		case <-ticker.C:
+			e.logPush()
+		case <-e.stopPush:
+			e.logPush()
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) Close() {
+	e.stopOnce.Do(func() {
+		close(e.stopPush)
+	})
+}
+
+func (e *OTLPExporter) logPush() {
+	if err := e.push(); err != nil {
+		logger.Warnf("Pushing metrics to OTLP endpoint failed: %v", err)
+	}
+}
+
+func (e *OTLPExporter) push() error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// the gathered metrics are pushed despite of the error
+		logger.Warnf("Gathering of the metrics had error: %v", err)
+	}
+	request := encodeOTLPMetrics(families, e.serviceName, e.startTime, time.Now())
+
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), e.headers), e.timeout)
+	defer cancel()
+	var response []byte
+	return e.conn.Invoke(ctx, otlpExportMethod, request, &response, grpc.CallCustomCodec(rawCodec{}))
+}
+
+// rawCodec passes the protobuf messages encoded by the exporter
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case []byte:
+		return m, nil
+	case *[]byte:
+		return *m, nil
+	}
+	return nil, errors.Errorf("unexpected message type %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*[]byte)
+	if !ok {
+		return errors.Errorf("unexpected message type %T", v)
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "proto"
+}
+
+// encodeOTLPMetrics encodes the ExportMetricsServiceRequest of opentelemetry-proto
+func encodeOTLPMetrics(families []*dto.MetricFamily, serviceName string, startTime time.Time, now time.Time) []byte {
+	start, timestamp := uint64(startTime.UnixNano()), uint64(now.UnixNano())
+
+	var scopeMetrics otlpMessage
+	var scope otlpMessage
+	scope.string(1, otlpScopeName)
+	scope.string(2, config.Version)
+	scopeMetrics.message(1, scope)
+
+	for _, family := range families {
+		var metric otlpMessage
+		metric.string(1, family.GetName())
+		metric.string(2, family.GetHelp())
+
+		var data otlpMessage
+		for _, m := range family.GetMetric() {
+			var point otlpMessage
+			point.fixed64(2, start)
+			point.fixed64(3, timestamp)
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				point.double(4, m.GetCounter().GetValue())
+				point.attributes(7, m.GetLabel())
+			case dto.MetricType_GAUGE:
+				point.double(4, m.GetGauge().GetValue())
+				point.attributes(7, m.GetLabel())
+			case dto.MetricType_UNTYPED:
+				point.double(4, m.GetUntyped().GetValue())
+				point.attributes(7, m.GetLabel())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				point.fixed64(4, h.GetSampleCount())
+				point.double(5, h.GetSampleSum())
+				// the prometheus buckets are cumulative, the last OTLP bucket counts the values above the last bound
+				var counts, bounds otlpMessage
+				var cumulative uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+					counts.rawFixed64(b.GetCumulativeCount() - cumulative)
+					bounds.rawFixed64(math.Float64bits(b.GetUpperBound()))
+					cumulative = b.GetCumulativeCount()
+				}
+				counts.rawFixed64(h.GetSampleCount() - cumulative)
+				point.message(6, counts)
+				if len(bounds) != 0 {
+					point.message(7, bounds)
+				}
+				point.attributes(9, m.GetLabel())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				point.fixed64(4, s.GetSampleCount())
+				point.double(5, s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					var quantile otlpMessage
+					quantile.double(1, q.GetQuantile())
+					quantile.double(2, q.GetValue())
+					point.message(6, quantile)
+				}
+				point.attributes(7, m.GetLabel())
+			}
+			data.message(1, point)
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			data.varint(2, otlpCumulative)
+			data.varint(3, 1) // is_monotonic
+			metric.message(7, data)
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric.message(5, data)
+		case dto.MetricType_HISTOGRAM:
+			data.varint(2, otlpCumulative)
+			metric.message(9, data)
+		case dto.MetricType_SUMMARY:
+			metric.message(11, data)
+		default:
+			continue
+		}
+		scopeMetrics.message(2, metric)
+	}
+
+	var resource otlpMessage
+	var serviceNameAttribute otlpMessage
+	serviceNameAttribute.keyValue("service.name", serviceName)
+	resource.message(1, serviceNameAttribute)
+
+	var resourceMetrics otlpMessage
+	resourceMetrics.message(1, resource)
+	resourceMetrics.message(2, scopeMetrics)
+
+	var request otlpMessage
+	request.message(1, resourceMetrics)
+	return request
+}
+
+// otlpMessage is the protobuf encoding of a message
+type otlpMessage []byte
+
+func (m *otlpMessage) tag(field int, wireType uint64) {
+	m.rawVarint(uint64(field)<<3 | wireType)
+}
+
+func (m *otlpMessage) rawVarint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	*m = append(*m, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+func (m *otlpMessage) rawFixed64(v uint64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	*m = append(*m, buf...)
+}
+
+func (m *otlpMessage) varint(field int, v uint64) {
+	m.tag(field, 0)
+	m.rawVarint(v)
+}
+
+func (m *otlpMessage) fixed64(field int, v uint64) {
+	m.tag(field, 1)
+	m.rawFixed64(v)
+}
+
+func (m *otlpMessage) double(field int, v float64) {
+	m.fixed64(field, math.Float64bits(v))
+}
+
+func (m *otlpMessage) bytes(field int, b []byte) {
+	m.tag(field, 2)
+	m.rawVarint(uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+func (m *otlpMessage) string(field int, s string) {
+	m.bytes(field, []byte(s))
+}
+
+func (m *otlpMessage) message(field int, message otlpMessage) {
+	m.bytes(field, message)
+}
+
+// keyValue encodes the fields of the KeyValue with the string value
+func (m *otlpMessage) keyValue(key string, value string) {
+	var anyValue otlpMessage
+	anyValue.string(1, value)
+	m.string(1, key)
+	m.message(2, anyValue)
+}
+
+func (m *otlpMessage) attributes(field int, labels []*dto.LabelPair) {
+	for _, label := range labels {
+		var attribute otlpMessage
+		attribute.keyValue(label.GetName(), label.GetValue())
+		m.message(field, attribute)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+type testProtoField struct {
+	value uint64 // varint and fixed64
+	bytes []byte // length-delimited
+}
+
+// testDecodeProto returns the fields of the message by field number
+func testDecodeProto(a *assert.Assertions, buf []byte) map[int][]testProtoField {
+	fields := make(map[int][]testProtoField)
+	for len(buf) != 0 {
+		tag, n := binary.Uvarint(buf)
+		a.True(n > 0)
+		buf = buf[n:]
+		var field testProtoField
+		switch tag & 7 {
+		case 0:
+			field.value, n = binary.Uvarint(buf)
+			buf = buf[n:]
+		case 1:
+			field.value = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+		case 2:
+			size, n := binary.Uvarint(buf)
+			field.bytes = buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+		default:
+			a.Fail("unexpected wire type")
+			return fields
+		}
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], field)
+	}
+	return fields
+}
+
+func testProtoAttributes(a *assert.Assertions, fields []testProtoField) map[string]string {
+	attributes := make(map[string]string)
+	for _, field := range fields {
+		kv := testDecodeProto(a, field.bytes)
+		attributes[string(kv[1][0].bytes)] = string(testDecodeProto(a, kv[2][0].bytes)[1][0].bytes)
+	}
+	return attributes
+}
+
+type testOTLPRequest struct {
+	method  string
+	headers metadata.MD
+	body    []byte
+}
+
+func testOTLPServer(a *assert.Assertions) (string, chan testOTLPRequest, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	requests := make(chan testOTLPRequest, 1)
+	server := grpc.NewServer(grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		headers, _ := metadata.FromIncomingContext(stream.Context())
+		var body []byte
+		if err := stream.RecvMsg(&body); err != nil {
+			return err
+		}
+		requests <- testOTLPRequest{method: method, headers: headers, body: body}
+		return stream.SendMsg(&[]byte{})
+	}))
+	go server.Serve(listener)
+	return listener.Addr().String(), requests, server.Stop
+}
+
+func TestOTLPExporter(t *testing.T) {
+	a := assert.New(t)
+
+	address, requests, stop := testOTLPServer(a)
+	defer stop()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"broker"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency", Buckets: []float64{0.1, 1}})
+	a.Nil(registry.Register(counter))
+	a.Nil(registry.Register(histogram))
+	counter.WithLabelValues("kafka-0:9092").Add(5)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(0.7)
+	histogram.Observe(5)
+
+	conf := config.NewConfig()
+	conf.Otlp.Endpoint = address
+	conf.Otlp.Insecure = true
+	conf.Otlp.Headers = []string{"api-key = secret"}
+	exporter, err := NewOTLPExporter(registry, conf)
+	a.Nil(err)
+	defer exporter.conn.Close()
+	a.Nil(exporter.push())
+
+	request := <-requests
+	a.Equal(otlpExportMethod, request.method)
+	a.Equal([]string{"secret"}, request.headers["api-key"])
+
+	resourceMetrics := testDecodeProto(a, testDecodeProto(a, request.body)[1][0].bytes)
+	resource := testDecodeProto(a, resourceMetrics[1][0].bytes)
+	a.Equal(map[string]string{"service.name": "kafka-proxy"}, testProtoAttributes(a, resource[1]))
+
+	scopeMetrics := testDecodeProto(a, resourceMetrics[2][0].bytes)
+	a.Equal(otlpScopeName, string(testDecodeProto(a, scopeMetrics[1][0].bytes)[1][0].bytes))
+	metrics := make(map[string]map[int][]testProtoField)
+	for _, field := range scopeMetrics[2] {
+		metric := testDecodeProto(a, field.bytes)
+		metrics[string(metric[1][0].bytes)] = metric
+	}
+	a.Len(metrics, 2)
+
+	sum := testDecodeProto(a, metrics["requests_total"][7][0].bytes)
+	a.Equal(uint64(otlpCumulative), sum[2][0].value)
+	a.Equal(uint64(1), sum[3][0].value)
+	point := testDecodeProto(a, sum[1][0].bytes)
+	a.Equal(float64(5), math.Float64frombits(point[4][0].value))
+	a.Equal(map[string]string{"broker": "kafka-0:9092"}, testProtoAttributes(a, point[7]))
+	a.True(point[2][0].value <= point[3][0].value)
+
+	h := testDecodeProto(a, metrics["latency_seconds"][9][0].bytes)
+	a.Equal(uint64(otlpCumulative), h[2][0].value)
+	point = testDecodeProto(a, h[1][0].bytes)
+	a.Equal(uint64(4), point[4][0].value)
+	a.Equal(6.25, math.Float64frombits(point[5][0].value))
+	counts := point[6][0].bytes
+	a.Equal([]uint64{1, 2, 1}, []uint64{binary.LittleEndian.Uint64(counts), binary.LittleEndian.Uint64(counts[8:]), binary.LittleEndian.Uint64(counts[16:])})
+	bounds := point[7][0].bytes
+	a.Equal([]float64{0.1, 1}, []float64{math.Float64frombits(binary.LittleEndian.Uint64(bounds)), math.Float64frombits(binary.LittleEndian.Uint64(bounds[8:]))})
+}
+
+func TestOTLPExporterUnavailable(t *testing.T) {
+	a := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	conf := config.NewConfig()
+	conf.Otlp.Endpoint = address
+	conf.Otlp.Insecure = true
+	conf.Otlp.Timeout = 500 * time.Millisecond
+	exporter, err := NewOTLPExporter(prometheus.NewRegistry(), conf)
+	a.Nil(err)
+	defer exporter.conn.Close()
+	a.NotNil(exporter.push())
+}
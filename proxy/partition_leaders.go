@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PartitionLeader is the leader of the topic partition and the listener the clients are routed to
+type PartitionLeader struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Leader    int32  `json:"leader"` // -1 when the partition has no leader
+	Listener  string `json:"listener,omitempty"`
+}
+
+// BrokerLeadership is the number of the partitions led by the broker
+type BrokerLeadership struct {
+	Broker     int32  `json:"broker"`
+	Listener   string `json:"listener"`
+	Partitions int    `json:"partitions"`
+}
+
+// PartitionLeadersStatus is the response of the HTTP partition leaders endpoint
+type PartitionLeadersStatus struct {
+	Brokers    []BrokerLeadership `json:"brokers"`
+	Partitions []PartitionLeader  `json:"partitions"`
+}
+
+// PartitionLeaders tracks the partition leaders of the Metadata responses returned to the clients. The listeners are the broker
+// addresses advertised to the clients, so the operators see through which listeners the clients reach the leaders.
+// The frames are not modified, the responses which cannot be decoded are ignored.
+type PartitionLeaders struct {
+	mu        sync.RWMutex
+	listeners map[int32]string           // advertised address by broker node id
+	topics    map[string]map[int32]int32 // leader node id by partition by topic
+}
+
+// NewPartitionLeaders creates the empty partition leaders
+func NewPartitionLeaders() *PartitionLeaders {
+	return &PartitionLeaders{listeners: make(map[int32]string), topics: make(map[string]map[int32]int32)}
+}
+
+// FilterRequest implements apis.FrameFilter
+func (l *PartitionLeaders) FilterRequest(request []byte) ([]byte, error) {
+	return request, nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (l *PartitionLeaders) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if apiKey != apiKeyMetadata {
+		return response, nil
+	}
+	leaders, err := protocol.DecodeMetadataLeaders(apiVersion, response)
+	if err != nil {
+		logger.Debugf("Partition leaders of the Metadata response version %d cannot be decoded: %v", apiVersion, err)
+		return response, nil
+	}
+	l.update(leaders)
+	return response, nil
+}
+
+// update replaces the brokers and the leaders of the topics in the response, the topics with an error are removed
+func (l *PartitionLeaders) update(leaders *protocol.MetadataLeaders) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the response lists all live brokers
+	if len(leaders.Brokers) != 0 {
+		l.listeners = make(map[int32]string, len(leaders.Brokers))
+		for _, broker := range leaders.Brokers {
+			l.listeners[broker.NodeID] = net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		}
+	}
+	for _, topic := range leaders.Topics {
+		if topic.ErrorCode != 0 {
+			delete(l.topics, topic.Name)
+			continue
+		}
+		if previous, ok := l.topics[topic.Name]; ok {
+			for partition, leader := range topic.Leaders {
+				if previousLeader, ok := previous[partition]; ok && previousLeader != leader {
+					proxyPartitionLeaderChangesTotal.WithLabelValues(strconv.Itoa(int(leader))).Inc()
+				}
+			}
+		}
+		l.topics[topic.Name] = topic.Leaders
+	}
+	l.updateMetrics()
+}
+
+func (l *PartitionLeaders) updateMetrics() {
+	proxyPartitionLeaders.Reset()
+	for _, broker := range l.brokers() {
+		proxyPartitionLeaders.WithLabelValues(strconv.Itoa(int(broker.Broker)), broker.Listener).Set(float64(broker.Partitions))
+	}
+}
+
+// brokers returns the leadership of the known brokers and of the leaders missing in the broker list
+func (l *PartitionLeaders) brokers() []BrokerLeadership {
+	counts := make(map[int32]int)
+	for id := range l.listeners {
+		counts[id] = 0
+	}
+	for _, partitions := range l.topics {
+		for _, leader := range partitions {
+			if leader >= 0 {
+				counts[leader]++
+			}
+		}
+	}
+	brokers := make([]BrokerLeadership, 0, len(counts))
+	for id, count := range counts {
+		brokers = append(brokers, BrokerLeadership{Broker: id, Listener: l.listeners[id], Partitions: count})
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].Broker < brokers[j].Broker })
+	return brokers
+}
+
+// Status returns the brokers and the leaders of the partitions of the topics matching the pattern, the pattern ending with * is a prefix.
+// When the listener is not empty, only the partitions led through the listener are returned.
+func (l *PartitionLeaders) Status(topicPattern string, listener string) PartitionLeadersStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	status := PartitionLeadersStatus{Brokers: l.brokers(), Partitions: make([]PartitionLeader, 0)}
+	for topic, partitions := range l.topics {
+		if topicPattern != "" && topicPattern != topic && !(strings.HasSuffix(topicPattern, "*") && strings.HasPrefix(topic, strings.TrimSuffix(topicPattern, "*"))) {
+			continue
+		}
+		for partition, leader := range partitions {
+			partitionLeader := PartitionLeader{Topic: topic, Partition: partition, Leader: leader, Listener: l.listeners[leader]}
+			if listener != "" && listener != partitionLeader.Listener {
+				continue
+			}
+			status.Partitions = append(status.Partitions, partitionLeader)
+		}
+	}
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		if status.Partitions[i].Topic != status.Partitions[j].Topic {
+			return status.Partitions[i].Topic < status.Partitions[j].Topic
+		}
+		return status.Partitions[i].Partition < status.Partitions[j].Partition
+	})
+	return status
+}
+
+// ServeHTTP returns on GET the partition leaders selected by the optional query parameters topic and listener
+// e.g. ?topic=orders-*&listener=kafka-proxy:32401
+func (l *PartitionLeaders) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	if listener := query.Get("listener"); listener != "" {
+		if _, _, err := net.SplitHostPort(listener); err != nil {
+			http.Error(w, fmt.Sprintf("invalid listener: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.Status(query.Get("topic"), query.Get("listener")))
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPartitionLeaders(t *testing.T) {
+	a := assert.New(t)
+
+	l := NewPartitionLeaders()
+	// the Metadata v0 response of the cluster routing tests has the topic without partitions
+	response := testMetadataResponseV0(0, "kafka-proxy", "orders")
+	result, err := l.FilterResponse(apiKeyMetadata, 0, response)
+	a.Nil(err)
+	a.Equal(response, result)
+	a.Equal([]BrokerLeadership{{Broker: 0, Listener: "kafka-proxy:9092"}}, l.Status("", "").Brokers)
+
+	// the frames which cannot be decoded are forwarded
+	result, err = l.FilterResponse(apiKeyMetadata, 0, []byte{0x00, 0x01})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x01}, result)
+
+	brokers := []protocol.MetadataBroker{{NodeID: 0, Host: "proxy-0", Port: 32400}, {NodeID: 1, Host: "proxy-1", Port: 32401}}
+	l.update(&protocol.MetadataLeaders{Brokers: brokers, Topics: []protocol.MetadataTopic{
+		{Name: "orders", Leaders: map[int32]int32{0: 0, 1: 1, 2: 1}},
+		{Name: "payments", Leaders: map[int32]int32{0: -1}},
+	}})
+	status := l.Status("", "")
+	a.Equal([]BrokerLeadership{{0, "proxy-0:32400", 1}, {1, "proxy-1:32401", 2}}, status.Brokers)
+	a.Equal([]PartitionLeader{
+		{"orders", 0, 0, "proxy-0:32400"},
+		{"orders", 1, 1, "proxy-1:32401"},
+		{"orders", 2, 1, "proxy-1:32401"},
+		{"payments", 0, -1, ""},
+	}, status.Partitions)
+	a.Equal(float64(2), testGaugeValue(a, proxyPartitionLeaders.WithLabelValues("1", "proxy-1:32401")))
+
+	// the leader moves, the topic with an error is removed
+	changes := testCounterValue(a, proxyPartitionLeaderChangesTotal.WithLabelValues("0"))
+	l.update(&protocol.MetadataLeaders{Brokers: brokers, Topics: []protocol.MetadataTopic{
+		{Name: "orders", Leaders: map[int32]int32{0: 0, 1: 0, 2: 1}},
+		{Name: "payments", ErrorCode: 3},
+	}})
+	a.Equal(changes+1, testCounterValue(a, proxyPartitionLeaderChangesTotal.WithLabelValues("0")))
+	a.Equal([]PartitionLeader{{"orders", 0, 0, "proxy-0:32400"}, {"orders", 1, 0, "proxy-0:32400"}}, l.Status("ord*", "proxy-0:32400").Partitions)
+	a.Empty(l.Status("payments", "").Partitions)
+	a.Equal(float64(2), testGaugeValue(a, proxyPartitionLeaders.WithLabelValues("0", "proxy-0:32400")))
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partition-leaders?topic=orders&listener=proxy-1:32401", nil))
+	a.Equal(http.StatusOK, rec.Code)
+	var body PartitionLeadersStatus
+	a.Nil(json.Unmarshal(rec.Body.Bytes(), &body))
+	a.Equal([]PartitionLeader{{"orders", 2, 1, "proxy-1:32401"}}, body.Partitions)
+
+	rec = httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partition-leaders?listener=proxy-1", nil))
+	a.Equal(http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/partition-leaders", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
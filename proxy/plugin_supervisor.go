@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"github.com/cenkalti/backoff"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/hashicorp/go-plugin"
+	"sync"
+	"time"
+)
+
+const (
+	pluginExited       = "exited"
+	pluginPingFailed   = "ping_failed"
+	pluginUnresponsive = "unresponsive"
+)
+
+// PluginClient is the client of the plugin subprocess, it is implemented by plugin.Client
+type PluginClient interface {
+	Client() (plugin.ClientProtocol, error)
+	Exited() bool
+	Kill()
+}
+
+// PluginSupervisor starts the plugin subprocess and checks it periodically. The plugin which exited or does not respond to the ping
+// is restarted with exponential backoff, the calls fail fast until the plugin is running again.
+type PluginSupervisor struct {
+	name      string
+	dispense  string
+	newClient func() PluginClient
+
+	healthCheckInterval time.Duration
+	backoff             *backoff.ExponentialBackOff
+
+	mu       sync.RWMutex
+	client   PluginClient
+	protocol plugin.ClientProtocol
+	raw      interface{}
+
+	checkRequest chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewPluginSupervisor starts the plugin and dispenses the plugin implementation, the plugin is supervised when Plugin.HealthCheckInterval is set
+func NewPluginSupervisor(name string, dispense string, newClient func() PluginClient, conf *config.Config) (*PluginSupervisor, error) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = conf.Plugin.RestartInitialBackoff
+	b.MaxInterval = conf.Plugin.RestartMaxBackoff
+	b.Multiplier = 2
+	b.MaxElapsedTime = 0
+
+	s := &PluginSupervisor{
+		name:                name,
+		dispense:            dispense,
+		newClient:           newClient,
+		healthCheckInterval: conf.Plugin.HealthCheckInterval,
+		backoff:             b,
+		checkRequest:        make(chan struct{}, 1),
+		done:                make(chan struct{}),
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	if s.healthCheckInterval > 0 {
+		go s.run()
+	}
+	return s, nil
+}
+
+// Get returns the plugin implementation of the running subprocess
+func (s *PluginSupervisor) Get() (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.raw == nil {
+		return nil, fmt.Errorf("plugin %s is not running", s.name)
+	}
+	return s.raw, nil
+}
+
+// Close stops the supervision and kills the plugin subprocess
+func (s *PluginSupervisor) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.stop()
+	})
+}
+
+func (s *PluginSupervisor) start() error {
+	client := s.newClient()
+	protocol, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return err
+	}
+	raw, err := protocol.Dispense(s.dispense)
+	if err != nil {
+		client.Kill()
+		return err
+	}
+	s.mu.Lock()
+	s.client, s.protocol, s.raw = client, protocol, raw
+	s.mu.Unlock()
+	proxyPluginUp.WithLabelValues(s.name).Set(1)
+	return nil
+}
+
+func (s *PluginSupervisor) stop() {
+	s.mu.Lock()
+	client := s.client
+	s.client, s.protocol, s.raw = nil, nil, nil
+	s.mu.Unlock()
+	if client != nil {
+		client.Kill()
+	}
+	proxyPluginUp.WithLabelValues(s.name).Set(0)
+}
+
+// failed requests the health check after the failed plugin call
+func (s *PluginSupervisor) failed() {
+	select {
+	case s.checkRequest <- struct{}{}:
+	default:
+	}
+}
+
+func (s *PluginSupervisor) run() {
+	ticker := time.NewTicker(s.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.checkRequest:
+		}
+		reason := s.check()
+		if reason == "" {
+			// the backoff grows while the restarted plugin crashes before the next health check
+			s.backoff.Reset()
+			continue
+		}
+		proxyPluginCrashesTotal.WithLabelValues(s.name, reason).Inc()
+		logger.Errorf("plugin %s failed the health check (%s), restarting", s.name, reason)
+		s.stop()
+		s.restart()
+	}
+}
+
+// check returns the reason of the failed health check or the empty string for the healthy plugin
+func (s *PluginSupervisor) check() string {
+	s.mu.RLock()
+	client, protocol := s.client, s.protocol
+	s.mu.RUnlock()
+	if client == nil {
+		return pluginExited
+	}
+	if client.Exited() {
+		return pluginExited
+	}
+	ping := make(chan error, 1)
+	go func() {
+		ping <- protocol.Ping()
+	}()
+	select {
+	case err := <-ping:
+		if err != nil {
+			return pluginPingFailed
+		}
+		return ""
+	case <-time.After(s.healthCheckInterval):
+		return pluginUnresponsive
+	case <-s.done:
+		return ""
+	}
+}
+
+// restart starts the plugin again, the failed starts are retried with backoff until the supervisor is closed
+func (s *PluginSupervisor) restart() {
+	for {
+		next := s.backoff.NextBackOff()
+		select {
+		case <-s.done:
+			return
+		case <-time.After(next):
+		}
+		if err := s.start(); err != nil {
+			proxyPluginRestartsTotal.WithLabelValues(s.name, "false").Inc()
+			logger.Errorf("restart of plugin %s failed: %v", s.name, err)
+			continue
+		}
+		select {
+		case <-s.done:
+			// closed while starting
+			s.stop()
+			return
+		default:
+		}
+		proxyPluginRestartsTotal.WithLabelValues(s.name, "true").Inc()
+		logger.Infof("plugin %s restarted after %v", s.name, next)
+		return
+	}
+}
+
+// PasswordAuthenticator returns the password authenticator which calls the running plugin
+func (s *PluginSupervisor) PasswordAuthenticator() apis.PasswordAuthenticator {
+	return supervisedPasswordAuthenticator{supervisor: s}
+}
+
+// TokenProvider returns the token provider which calls the running plugin
+func (s *PluginSupervisor) TokenProvider() apis.TokenProvider {
+	return supervisedTokenProvider{supervisor: s}
+}
+
+// TokenInfo returns the token info which calls the running plugin
+func (s *PluginSupervisor) TokenInfo() apis.TokenInfo {
+	return supervisedTokenInfo{supervisor: s}
+}
+
+// RequestAuthorizer returns the request authorizer which calls the running plugin
+func (s *PluginSupervisor) RequestAuthorizer() apis.RequestAuthorizer {
+	return supervisedRequestAuthorizer{supervisor: s}
+}
+
+// KeySigner returns the key signer which calls the running plugin
+func (s *PluginSupervisor) KeySigner() apis.KeySigner {
+	return supervisedKeySigner{supervisor: s}
+}
+
+type supervisedPasswordAuthenticator struct {
+	supervisor *PluginSupervisor
+}
+
+func (p supervisedPasswordAuthenticator) Authenticate(username, password string) (bool, int32, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return false, 0, err
+	}
+	ok, status, err := raw.(apis.PasswordAuthenticator).Authenticate(username, password)
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return ok, status, err
+}
+
+// AuthenticateWithContext implements apis.ContextPasswordAuthenticator, the context is dropped for the plugins without its support
+func (p supervisedPasswordAuthenticator) AuthenticateWithContext(authContext apis.AuthContext, username, password string) (bool, int32, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return false, 0, err
+	}
+	var (
+		ok     bool
+		status int32
+	)
+	if authenticator, isContext := raw.(apis.ContextPasswordAuthenticator); isContext {
+		ok, status, err = authenticator.AuthenticateWithContext(authContext, username, password)
+	} else {
+		ok, status, err = raw.(apis.PasswordAuthenticator).Authenticate(username, password)
+	}
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return ok, status, err
+}
+
+type supervisedTokenProvider struct {
+	supervisor *PluginSupervisor
+}
+
+func (p supervisedTokenProvider) GetToken(ctx context.Context, request apis.TokenRequest) (apis.TokenResponse, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return apis.TokenResponse{}, err
+	}
+	response, err := raw.(apis.TokenProvider).GetToken(ctx, request)
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return response, err
+}
+
+type supervisedTokenInfo struct {
+	supervisor *PluginSupervisor
+}
+
+func (p supervisedTokenInfo) VerifyToken(ctx context.Context, request apis.VerifyRequest) (apis.VerifyResponse, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return apis.VerifyResponse{}, err
+	}
+	response, err := raw.(apis.TokenInfo).VerifyToken(ctx, request)
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return response, err
+}
+
+type supervisedRequestAuthorizer struct {
+	supervisor *PluginSupervisor
+}
+
+func (p supervisedRequestAuthorizer) Authorize(ctx context.Context, request apis.AuthorizeRequest) (apis.AuthorizeResponse, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return apis.AuthorizeResponse{}, err
+	}
+	response, err := raw.(apis.RequestAuthorizer).Authorize(ctx, request)
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return response, err
+}
+
+type supervisedKeySigner struct {
+	supervisor *PluginSupervisor
+}
+
+func (p supervisedKeySigner) Sign(ctx context.Context, request apis.SignRequest) ([]byte, error) {
+	raw, err := p.supervisor.Get()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := raw.(apis.KeySigner).Sign(ctx, request)
+	if err != nil {
+		p.supervisor.failed()
+	}
+	return signature, err
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/hashicorp/go-plugin"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPluginClient struct {
+	mu     sync.Mutex
+	exited bool
+	ping   error
+}
+
+func (c *testPluginClient) Client() (plugin.ClientProtocol, error) {
+	return &testPluginProtocol{client: c}, nil
+}
+
+func (c *testPluginClient) Exited() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exited
+}
+
+func (c *testPluginClient) Kill() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exited = true
+}
+
+type testPluginProtocol struct {
+	client *testPluginClient
+}
+
+func (p *testPluginProtocol) Close() error {
+	return nil
+}
+
+func (p *testPluginProtocol) Dispense(string) (interface{}, error) {
+	return testPasswordAuthenticator{"alice": "secret"}, nil
+}
+
+func (p *testPluginProtocol) Ping() error {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	return p.client.ping
+}
+
+func testPluginConfig() *config.Config {
+	c := new(config.Config)
+	c.Plugin.HealthCheckInterval = 10 * time.Millisecond
+	c.Plugin.RestartInitialBackoff = 10 * time.Millisecond
+	c.Plugin.RestartMaxBackoff = 50 * time.Millisecond
+	return c
+}
+
+func TestPluginSupervisorRestart(t *testing.T) {
+	a := assert.New(t)
+
+	clients := make(chan *testPluginClient, 10)
+	supervisor, err := NewPluginSupervisor("local-auth", "passwordAuthenticator", func() PluginClient {
+		client := &testPluginClient{}
+		clients <- client
+		return client
+	}, testPluginConfig())
+	a.Nil(err)
+	defer supervisor.Close()
+
+	authenticator := supervisor.PasswordAuthenticator()
+	ok, _, err := authenticator.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+
+	// the plugin process dies
+	first := <-clients
+	first.mu.Lock()
+	first.exited = true
+	first.mu.Unlock()
+
+	second := testWaitPluginRestart(a, supervisor, clients)
+	ok, _, err = authenticator.Authenticate("alice", "secret")
+	a.Nil(err)
+	a.True(ok)
+
+	// the plugin does not answer the ping, it is killed and restarted
+	second.mu.Lock()
+	second.ping = errors.New("connection is shut down")
+	second.mu.Unlock()
+	testWaitPluginRestart(a, supervisor, clients)
+	a.True(second.Exited())
+}
+
+// testWaitPluginRestart returns the client of the restarted plugin when it is running
+func testWaitPluginRestart(a *assert.Assertions, supervisor *PluginSupervisor, clients chan *testPluginClient) *testPluginClient {
+	var client *testPluginClient
+	select {
+	case client = <-clients:
+	case <-time.After(5 * time.Second):
+		a.FailNow("plugin was not restarted")
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := supervisor.Get(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return client
+}
+
+func TestPluginSupervisorClosed(t *testing.T) {
+	a := assert.New(t)
+
+	supervisor, err := NewPluginSupervisor("local-auth", "passwordAuthenticator", func() PluginClient {
+		return &testPluginClient{}
+	}, testPluginConfig())
+	a.Nil(err)
+	supervisor.Close()
+	supervisor.Close()
+
+	_, _, err = supervisor.PasswordAuthenticator().Authenticate("alice", "secret")
+	a.EqualError(err, "plugin local-auth is not running")
+}
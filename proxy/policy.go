@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const policyAllApiKeys = "*"
+
+// api keys of the roles given by an alias
+var policyApiKeyGroups = map[string][]int16{
+	// consumer groups
+	"groups": {8, 9, 10, 11, 12, 13, 14, 15, 16}, // OffsetCommit, OffsetFetch, FindCoordinator, JoinGroup, Heartbeat, LeaveGroup, SyncGroup, DescribeGroups, ListGroups
+	// transactional producers
+	"transactions": {22, 24, 25, 26, 28}, // InitProducerId, AddPartitionsToTxn, AddOffsetsToTxn, EndTxn, TxnOffsetCommit
+}
+
+// requests of the connection setup allowed to every principal
+var policyConnectionApiKeys = map[int16]struct{}{
+	apiKeySaslHandshake:    {},
+	apiKeyApiApiVersions:   {},
+	apiKeySaslAuthenticate: {},
+}
+
+// RequestPolicies allow the principals the api keys and the topics of their roles, other requests are denied
+// with an authorization error response.
+type RequestPolicies struct {
+	roles       map[string]*policyRole
+	principals  map[string]string
+	defaultRole string
+	// the common name of the verified client certificate is the principal of the connections without local SASL
+	clientCertPrincipal bool
+}
+
+type policyRole struct {
+	name    string
+	apiKeys map[int16]struct{} // nil - all api keys
+	topics  []*regexp.Regexp   // empty - all topics
+}
+
+// NewRequestPolicies creates the roles given as role=api-key,api-key and their topics given as role=regexp.
+// The principals given as principal=role without a role have the default role.
+func NewRequestPolicies(roles []string, topics []string, principals []string, defaultRole string, clientCertPrincipal bool) (*RequestPolicies, error) {
+	p := &RequestPolicies{roles: make(map[string]*policyRole), principals: make(map[string]string), defaultRole: defaultRole, clientCertPrincipal: clientCertPrincipal}
+	for _, value := range roles {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("policy role '%s' must be role=api-key,api-key", value)
+		}
+		role := &policyRole{name: pair[0], apiKeys: make(map[int16]struct{})}
+		for _, name := range strings.Split(pair[1], ",") {
+			name = strings.TrimSpace(name)
+			if name == policyAllApiKeys {
+				role.apiKeys = nil
+				break
+			}
+			if group, ok := policyApiKeyGroups[name]; ok {
+				for _, apiKey := range group {
+					role.apiKeys[apiKey] = struct{}{}
+				}
+				continue
+			}
+			apiKey, ok := protocol.ApiKeyByName(name)
+			if !ok {
+				return nil, fmt.Errorf("policy role '%s' has unknown api key %s", value, name)
+			}
+			role.apiKeys[apiKey] = struct{}{}
+		}
+		p.roles[role.name] = role
+	}
+	for _, value := range topics {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("policy topics '%s' must be role=regexp", value)
+		}
+		role, ok := p.roles[pair[0]]
+		if !ok {
+			return nil, fmt.Errorf("policy topics '%s' refer to unknown role %s", value, pair[0])
+		}
+		pattern, err := regexp.Compile("^(?:" + pair[1] + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "policy topics '%s'", value)
+		}
+		role.topics = append(role.topics, pattern)
+	}
+	for _, value := range principals {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("policy principal '%s' must be principal=role", value)
+		}
+		if _, ok := p.roles[pair[1]]; !ok {
+			return nil, fmt.Errorf("policy principal '%s' refers to unknown role %s", value, pair[1])
+		}
+		p.principals[pair[0]] = pair[1]
+	}
+	if _, ok := p.roles[defaultRole]; defaultRole != "" && !ok {
+		return nil, fmt.Errorf("policy default role %s is unknown", defaultRole)
+	}
+	return p, nil
+}
+
+func (p *RequestPolicies) enabled() bool {
+	return p != nil
+}
+
+// principal returns the principal authenticated by local SASL or the common name of the verified client certificate
+func (p *RequestPolicies) principal(saslPrincipal string, conn interface{}) string {
+	if saslPrincipal != "" || !p.clientCertPrincipal {
+		return saslPrincipal
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		state := c.ConnectionState()
+		if len(state.VerifiedChains) != 0 && len(state.VerifiedChains[0]) != 0 {
+			return state.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// role returns the role of the principal, nil when the principal has no role
+func (p *RequestPolicies) role(principal string) *policyRole {
+	name, ok := p.principals[principal]
+	if !ok || principal == "" {
+		name = p.defaultRole
+	}
+	return p.roles[name]
+}
+
+// authorize checks whether the role of the principal allows the request (without the size field). For a denied request
+// the error response to be returned to the client is provided, it is nil when the client does not expect a response (Produce with acks 0).
+func (p *RequestPolicies) authorize(principal string, clientAddress string, request []byte) (allowed bool, errorResponse []byte, err error) {
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return false, nil, err
+	}
+	if _, ok := policyConnectionApiKeys[info.ApiKey]; ok {
+		return true, nil, nil
+	}
+	role := p.role(principal)
+	roleName := ""
+	if role != nil {
+		roleName = role.name
+	}
+	apiKey := strconv.Itoa(int(info.ApiKey))
+	if role.allows(info) {
+		proxyRequestPolicyTotal.WithLabelValues(roleName, apiKey, "true").Inc()
+		return true, nil, nil
+	}
+	proxyRequestPolicyTotal.WithLabelValues(roleName, apiKey, "false").Inc()
+
+	errorCode := int16(protocol.ErrClusterAuthorizationFailed)
+	if len(info.Topics) != 0 {
+		errorCode = int16(protocol.ErrTopicAuthorizationFailed)
+	}
+	errorResponse, err = protocol.EncodeErrorResponse(request, errorCode)
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "request %s version %d of principal '%s' from %s was denied by role '%s'", protocol.ApiKeyName(info.ApiKey), info.ApiVersion, principal, clientAddress, roleName)
+	}
+	logger.Infof("Request %s version %d for topics %v of principal '%s' from %s was denied by role '%s'", protocol.ApiKeyName(info.ApiKey), info.ApiVersion, info.Topics, principal, clientAddress, roleName)
+	return false, errorResponse, nil
+}
+
+// allows checks the api key and the topics of the request. The requests referring to all topics are allowed
+// to the roles with topics only for Metadata, the response lists the topic names.
+func (r *policyRole) allows(info *protocol.RequestInfo) bool {
+	if r == nil {
+		return false
+	}
+	if r.apiKeys != nil {
+		if _, ok := r.apiKeys[info.ApiKey]; !ok {
+			return false
+		}
+	}
+	if len(r.topics) == 0 {
+		return true
+	}
+	if info.TopicsUnknown && info.ApiKey != apiKeyMetadata {
+		return false
+	}
+	for _, topic := range info.Topics {
+		if !r.allowsTopic(topic) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *policyRole) allowsTopic(topic string) bool {
+	for _, pattern := range r.topics {
+		if pattern.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Metadata v4 request for all topics
+var testMetadataAllTopicsRequest = []byte{
+	0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x01, 'c',
+	0xff, 0xff, 0xff, 0xff,
+	0x01,
+}
+
+// FindCoordinator v0 request for group g1
+var testFindCoordinatorRequest = []byte{
+	0x00, 0x0a, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x01, 'c',
+	0x00, 0x02, 'g', '1',
+}
+
+func TestRequestPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	policies, err := NewRequestPolicies([]string{"read-only=Fetch,metadata,ListOffsets,groups", "orders=*", "admin=*"}, []string{"orders=orders\\..*", "orders=payments"}, []string{"alice=read-only", "bob=orders", "carol=admin"}, "", false)
+	a.Nil(err)
+	a.Len(policies.roles["read-only"].apiKeys, 12)
+	a.Nil(policies.roles["orders"].apiKeys)
+
+	// the api key of the role
+	allowed, errorResponse, err := policies.authorize("alice", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.True(allowed)
+	a.Nil(errorResponse)
+	allowed, _, err = policies.authorize("alice", "10.0.0.1:5000", testFindCoordinatorRequest)
+	a.Nil(err)
+	a.True(allowed)
+
+	// the topics of the role
+	allowed, errorResponse, err = policies.authorize("bob", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.False(allowed)
+	// TOPIC_AUTHORIZATION_FAILED
+	a.Equal([]byte{0x00, 0x1d}, errorResponse[18:20])
+	allowed, _, err = policies.authorize("bob", "10.0.0.1:5000", testMetadataAllTopicsRequest)
+	a.Nil(err)
+	a.True(allowed)
+	a.True(policies.roles["orders"].allowsTopic("orders.eu"))
+	a.True(policies.roles["orders"].allowsTopic("payments"))
+	a.False(policies.roles["orders"].allowsTopic("payments-dlq"))
+
+	allowed, _, err = policies.authorize("carol", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.True(allowed)
+
+	// the principals without a role and the unauthenticated clients are denied without the default role
+	allowed, _, err = policies.authorize("dave", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.False(allowed)
+	allowed, _, err = policies.authorize("", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.False(allowed)
+	// the requests of the connection setup are allowed
+	allowed, _, err = policies.authorize("", "10.0.0.1:5000", []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.Nil(err)
+	a.True(allowed)
+	// the error response to the denied FindCoordinator request cannot be encoded
+	_, _, err = policies.authorize("dave", "10.0.0.1:5000", testFindCoordinatorRequest)
+	a.NotNil(err)
+
+	policies.defaultRole = "read-only"
+	allowed, _, err = policies.authorize("dave", "10.0.0.1:5000", testMetadataRequest[4:])
+	a.Nil(err)
+	a.True(allowed)
+
+	_, err = NewRequestPolicies([]string{"read-only=Fetch,Fetcher"}, nil, nil, "", false)
+	a.EqualError(err, "policy role 'read-only=Fetch,Fetcher' has unknown api key Fetcher")
+	_, err = NewRequestPolicies([]string{"read-only=Fetch"}, nil, []string{"alice=admin"}, "", false)
+	a.EqualError(err, "policy principal 'alice=admin' refers to unknown role admin")
+}
+
+func TestRequestPoliciesClientCertPrincipal(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.CAChainCertFile = bundle.CACert.Name()
+	c.Kafka.TLS.CAChainCertFile = bundle.ServerCert.Name()
+	c.Kafka.TLS.ClientCertFile = bundle.ClientCert.Name()
+	c.Kafka.TLS.ClientKeyFile = bundle.ClientKey.Name()
+
+	c1, c2, stop, err := makeTLSPipe(c)
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	defer stop()
+	pingPong(t, c1, c2)
+
+	policies, err := NewRequestPolicies([]string{"admin=*"}, nil, []string{"localhost=admin"}, "", true)
+	a.Nil(err)
+	a.Equal("localhost", policies.principal("", c2))
+	// the principal of local SASL is preferred
+	a.Equal("alice", policies.principal("alice", c2))
+
+	policies.clientCertPrincipal = false
+	a.Equal("", policies.principal("", c2))
+}
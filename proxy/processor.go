@@ -3,6 +3,7 @@ package proxy
 import (
 	"errors"
 	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"time"
 )
@@ -16,11 +17,15 @@ const (
 	defaultReadTimeout        = 30 * time.Second
 	minOpenRequests           = 16
 
-	apiKeySaslHandshake  = int16(17)
-	apiKeyApiApiVersions = int16(18)
+	apiKeyListOffsets      = int16(2)
+	apiKeyMetadata         = int16(3)
+	apiKeyFindCoordinator  = int16(10)
+	apiKeySaslHandshake    = int16(17)
+	apiKeyApiApiVersions   = int16(18)
+	apiKeySaslAuthenticate = int16(36)
 
 	minRequestApiKey = int16(0)   // 0 - Produce
-	maxRequestApiKey = int16(100) // so far 42 is the last (reserve some for the feature)
+	maxRequestApiKey = int16(100) // so far 82 is the last (reserve some for the feature)
 )
 
 var (
@@ -31,15 +36,45 @@ var (
 )
 
 type ProcessorConfig struct {
-	MaxOpenRequests       int
 	NetAddressMappingFunc config.NetAddressMappingFunc
-	RequestBufferSize     int
-	ResponseBufferSize    int
+	AdvertisedHostFunc    protocol.AdvertisedHostFunc
+	Tuning                *ProcessorTuning
 	WriteTimeout          time.Duration
 	ReadTimeout           time.Duration
+	RequestTimeout        time.Duration
 	LocalSasl             *LocalSasl
 	AuthServer            *AuthServer
+	RequestAuthz          *RequestAuthz
+	RequestPolicies       *RequestPolicies
+	RecordHeaders         *RecordHeaders
+	FrameFilters          *FrameFilters
+	TopicPrefixes         *TopicPrefixes
+	ClusterRouting        *ClusterRouting
 	ForbiddenApiKeys      map[int16]struct{}
+	ResponseErrorMetrics  bool
+	FrameCapture          *FrameCapture
+	RequestLogSampleRate  float64
+	RequestMirror         *RequestMirror
+	ResponseCache         *ResponseCache
+	FaultInjection        *FaultInjection
+	ProduceShadow         *ProduceShadow
+	ClusterCanary         *ClusterCanary
+	ReadOnly              *ReadOnly
+	ClientTelemetry       *ClientTelemetry
+	SlowConsumers         *SlowConsumers
+	MemoryBudget          *MemoryBudget
+	Conns                 *ConnSet
+	KillSwitch            *KillSwitch
+	OldClients            *OldClients
+	MaxFetchResponseSize  int
+	// the responses are relayed without rewriting the broker addresses
+	Transparent bool
+	// observes the broker addresses of the Metadata responses, nil when the brokers are not migrated
+	BrokerMigrations *brokerMigrations
+	// nil when the client quotas are not emulated
+	ClientQuotas *ClientQuotas
+	// nil when the clients are not fingerprinted
+	ClientInventory *ClientInventory
 }
 
 type processor struct {
@@ -48,29 +83,90 @@ type processor struct {
 	nextResponseHandlerChannel chan ResponseHandler
 
 	netAddressMappingFunc config.NetAddressMappingFunc
+	advertisedHostFunc    protocol.AdvertisedHostFunc
 	requestBufferSize     int
 	responseBufferSize    int
+	tuning                *ProcessorTuning
 	writeTimeout          time.Duration
 	readTimeout           time.Duration
-
-	localSasl  *LocalSasl
-	authServer *AuthServer
+	// the requests which are not answered in time get the REQUEST_TIMED_OUT response, 0 when the requests do not time out
+	requestTimeout time.Duration
+	// the larger Fetch responses are discarded and the client gets the MESSAGE_TOO_LARGE response, 0 when the size is not limited
+	maxFetchResponseSize int
+	// the Metadata and FindCoordinator responses are not rewritten, the clients reach the advertised broker addresses directly
+	transparent bool
+
+	localSasl       *LocalSasl
+	authServer      *AuthServer
+	requestAuthz    *RequestAuthz
+	requestPolicies *RequestPolicies
+	recordHeaders   *RecordHeaders
+	frameFilters    *FrameFilters
+	topicPrefixes   *TopicPrefixes
+
+	clusterRouting *ClusterRouting
+	// upstream cluster of the broker
+	cluster int
+
+	// SASL session of the connection to the broker, nil if it does not expire
+	upstreamSession *upstreamSASLSession
 
 	forbiddenApiKeys map[int16]struct{}
 	// metrics
-	brokerAddress string
+	brokerAddress        string
+	metricLabels         metricLabelValues
+	responseErrorMetrics bool
+	// authorization
+	clientAddress string
+	// nil when the frame capture is not configured
+	capture *connectionCapture
+	// fraction of the requests whose headers are logged
+	requestLogSampleRate float64
+	// nil when the requests are not mirrored
+	requestMirror *RequestMirror
+
+	// nil when the responses are not cached
+	responseCache    *ResponseCache
+	pendingResponses *pendingResponses
+	// nil when the fault injection is not configured
+	faults *FaultInjection
+	// nil when the Produce requests are not shadowed
+	produceShadow *ProduceShadow
+	// nil when the responses are not compared with the canary cluster
+	clusterCanary *ClusterCanary
+	// nil when the read-only mode is disabled
+	readOnly *ReadOnly
+	// nil when the client telemetry is forwarded to the brokers
+	clientTelemetry *ClientTelemetry
+	// nil when the slow consumers are not detected
+	slowConsumer *slowConsumer
+	// nil when the bytes in flight are not limited
+	memoryBudget *MemoryBudget
+	// nil when the connection is not listed
+	connMetadata *connMetadata
+	// nil when no client is killed
+	killSwitch *KillSwitch
+	// nil when the old api versions are accepted
+	oldClients *OldClients
+	// nil when the brokers are not migrated
+	brokerMigrations *brokerMigrations
+	// nil when the client quotas are not emulated
+	clientQuotas *ClientQuotas
+	// nil when the clients are not fingerprinted
+	clientInventory *ClientInventory
 }
 
-func newProcessor(cfg ProcessorConfig, brokerAddress string) *processor {
-	maxOpenRequests := cfg.MaxOpenRequests
+func newProcessor(cfg ProcessorConfig, brokerAddress string, clientAddress string) *processor {
+	settings := cfg.Tuning.Settings()
+	maxOpenRequests := settings.MaxOpenRequests
 	if maxOpenRequests < minOpenRequests {
 		maxOpenRequests = minOpenRequests
 	}
-	requestBufferSize := cfg.RequestBufferSize
+	requestBufferSize := settings.RequestBufferSize
 	if requestBufferSize <= 0 {
 		requestBufferSize = defaultRequestBufferSize
 	}
-	responseBufferSize := cfg.ResponseBufferSize
+	responseBufferSize := settings.ResponseBufferSize
 	if responseBufferSize <= 0 {
 		responseBufferSize = defaultResponseBufferSize
 	}
@@ -94,23 +190,57 @@ func newProcessor(cfg ProcessorConfig, brokerAddress string) *processor {
 		nextRequestHandlerChannel:  nextRequestHandlerChannel,
 		nextResponseHandlerChannel: nextResponseHandlerChannel,
 		netAddressMappingFunc:      cfg.NetAddressMappingFunc,
+		advertisedHostFunc:         cfg.AdvertisedHostFunc,
 		requestBufferSize:          requestBufferSize,
 		responseBufferSize:         responseBufferSize,
+		tuning:                     cfg.Tuning,
 		readTimeout:                readTimeout,
 		writeTimeout:               writeTimeout,
+		requestTimeout:             cfg.RequestTimeout,
+		maxFetchResponseSize:       cfg.MaxFetchResponseSize,
+		transparent:                cfg.Transparent,
 		brokerAddress:              brokerAddress,
 		localSasl:                  cfg.LocalSasl,
 		authServer:                 cfg.AuthServer,
+		requestAuthz:               cfg.RequestAuthz,
+		requestPolicies:            cfg.RequestPolicies,
+		recordHeaders:              cfg.RecordHeaders,
+		frameFilters:               cfg.FrameFilters,
+		topicPrefixes:              cfg.TopicPrefixes,
+		clusterRouting:             cfg.ClusterRouting,
+		cluster:                    cfg.ClusterRouting.cluster(brokerAddress),
 		forbiddenApiKeys:           cfg.ForbiddenApiKeys,
+		responseErrorMetrics:       cfg.ResponseErrorMetrics,
+		clientAddress:              clientAddress,
+		capture:                    cfg.FrameCapture.connection(clientAddress, brokerAddress),
+		requestLogSampleRate:       cfg.RequestLogSampleRate,
+		requestMirror:              cfg.RequestMirror,
+		responseCache:              cfg.ResponseCache,
+		pendingResponses:           &pendingResponses{},
+		faults:                     cfg.FaultInjection,
+		produceShadow:              cfg.ProduceShadow,
+		clusterCanary:              cfg.ClusterCanary,
+		readOnly:                   cfg.ReadOnly,
+		clientTelemetry:            cfg.ClientTelemetry,
+		slowConsumer:               cfg.SlowConsumers.connection(clientAddress, brokerAddress),
+		memoryBudget:               cfg.MemoryBudget,
+		killSwitch:                 cfg.KillSwitch,
+		oldClients:                 cfg.OldClients,
+		brokerMigrations:           cfg.BrokerMigrations,
+		clientQuotas:               cfg.ClientQuotas,
+		clientInventory:            cfg.ClientInventory,
 	}
 }
 
 func (p *processor) RequestsLoop(dst DeadlineWriter, src DeadlineReaderWriter) (readErr bool, err error) {
 
+	var gatewayExpiry time.Time
 	if p.authServer.enabled {
-		if err = p.authServer.receiveAndSendGatewayAuth(src); err != nil {
+		if gatewayExpiry, err = p.authServer.receiveAndSendGatewayAuth(src); err != nil {
+			emitEvent(Event{Type: EventAuthFailure, Broker: p.brokerAddress, Client: p.clientAddress, Message: "gateway auth: " + err.Error()})
 			return true, err
 		}
+		emitEvent(Event{Type: EventAuthSuccess, Broker: p.brokerAddress, Client: p.clientAddress, Message: "gateway auth"})
 	}
 	src.SetDeadline(time.Time{})
 
@@ -119,14 +249,50 @@ func (p *processor) RequestsLoop(dst DeadlineWriter, src DeadlineReaderWriter) (
 		nextRequestHandlerChannel:  p.nextRequestHandlerChannel,
 		nextResponseHandlerChannel: p.nextResponseHandlerChannel,
 		timeout:                    p.writeTimeout,
+		requestTimeout:             p.requestTimeout,
+		maxFetchResponseSize:       p.maxFetchResponseSize,
+		transparent:                p.transparent,
 		brokerAddress:              p.brokerAddress,
+		metricLabels:               p.metricLabels,
 		forbiddenApiKeys:           p.forbiddenApiKeys,
 		buf:                        make([]byte, p.requestBufferSize),
+		tuning:                     p.tuning,
 		localSasl:                  p.localSasl,
 		localSaslDone:              false, // sequential processing - mutex is required
+		gatewayExpiry:              gatewayExpiry,
+		requestAuthz:               p.requestAuthz,
+		requestPolicies:            p.requestPolicies,
+		clientAddress:              p.clientAddress,
+		recordHeaders:              p.recordHeaders,
+		frameFilters:               p.frameFilters,
+		topicPrefixes:              p.topicPrefixes,
+		clusterRouting:             p.clusterRouting,
+		cluster:                    p.cluster,
+		upstreamSession:            p.upstreamSession,
+		capture:                    p.capture,
+		requestLogSampleRate:       p.requestLogSampleRate,
+		requestMirror:              p.requestMirror,
+		responseCache:              p.responseCache,
+		pendingResponses:           p.pendingResponses,
+		faults:                     p.faults,
+		produceShadow:              p.produceShadow,
+		clusterCanary:              p.clusterCanary,
+		readOnly:                   p.readOnly,
+		clientTelemetry:            p.clientTelemetry,
+		slowConsumer:               p.slowConsumer,
+		memoryBudget:               p.memoryBudget,
+		connMetadata:               p.connMetadata,
+		killSwitch:                 p.killSwitch,
+		oldClients:                 p.oldClients,
+		clientQuotas:               p.clientQuotas,
+		clientInventory:            p.clientInventory,
 	}
 
-	return ctx.requestsLoop(dst, src)
+	readErr, err = ctx.requestsLoop(dst, src)
+	if ctx.principal != "" {
+		ctx.localSasl.connectionLimits.release(ctx.principal)
+	}
+	return readErr, err
 }
 
 type RequestsLoopContext struct {
@@ -135,12 +301,60 @@ type RequestsLoopContext struct {
 	nextResponseHandlerChannel chan<- ResponseHandler
 
 	timeout          time.Duration
+	requestTimeout   time.Duration
 	brokerAddress    string
+	metricLabels     metricLabelValues
 	forbiddenApiKeys map[int16]struct{}
 	buf              []byte // bufSize
-
-	localSasl     *LocalSasl
-	localSaslDone bool
+	tuning           *ProcessorTuning
+
+	localSasl       *LocalSasl
+	localSaslDone   bool
+	localSaslReauth bool      // SaslHandshake of the re-authentication was answered
+	localSaslExpiry time.Time // zero if the session does not expire
+	gatewayExpiry   time.Time // expiry of the gateway token with the grace period, zero if the connection is not closed
+
+	// re-authenticates the connection to the broker
+	upstreamSession *upstreamSASLSession
+
+	requestAuthz    *RequestAuthz
+	requestPolicies *RequestPolicies
+	clientAddress   string
+	principal       string // user authenticated by local SASL
+	// the client connection of the local SASL authentication, it is passed to the re-authentications
+	authContext apis.AuthContext
+
+	recordHeaders *RecordHeaders
+	frameFilters  *FrameFilters
+	topicPrefixes *TopicPrefixes
+
+	clusterRouting *ClusterRouting
+	cluster        int
+
+	capture              *connectionCapture
+	requestLogSampleRate float64
+	requestMirror        *RequestMirror
+
+	responseCache    *ResponseCache
+	pendingResponses *pendingResponses
+	faults           *FaultInjection
+	produceShadow    *ProduceShadow
+	clusterCanary    *ClusterCanary
+	readOnly         *ReadOnly
+	clientTelemetry  *ClientTelemetry
+	slowConsumer     *slowConsumer
+	memoryBudget     *MemoryBudget
+	connMetadata     *connMetadata
+	killSwitch       *KillSwitch
+	oldClients       *OldClients
+	clientQuotas     *ClientQuotas
+	clientInventory  *ClientInventory
+	// 0 when the Fetch responses are not limited
+	maxFetchResponseSize int
+	// the requests are relayed without copying them to user space when possible
+	transparent bool
+	// the bytes of the buffered request charged to the memory budget
+	bufferedBytes int64
 }
 
 // used by local authentication
@@ -161,6 +375,11 @@ func (ctx *RequestsLoopContext) putNextHandlers(nextRequestHandler RequestHandle
 	default:
 		return errors.New("next request handler channel is full")
 	}
+	return ctx.putNextResponseHandler(nextResponseHandler)
+}
+
+// used by the requests of the proxy
+func (ctx *RequestsLoopContext) putNextResponseHandler(nextResponseHandler ResponseHandler) error {
 
 	select {
 	case ctx.nextResponseHandlerChannel <- nextResponseHandler:
@@ -207,9 +426,29 @@ func (p *processor) ResponsesLoop(dst DeadlineWriter, src DeadlineReader) (readE
 		openRequestsChannel:        p.openRequestsChannel,
 		nextResponseHandlerChannel: p.nextResponseHandlerChannel,
 		netAddressMappingFunc:      p.netAddressMappingFunc,
+		advertisedHostFunc:         p.advertisedHostFunc,
 		timeout:                    p.readTimeout,
+		requestTimeout:             p.requestTimeout,
+		maxFetchResponseSize:       p.maxFetchResponseSize,
+		transparent:                p.transparent,
 		brokerAddress:              p.brokerAddress,
+		metricLabels:               p.metricLabels,
+		responseErrorMetrics:       p.responseErrorMetrics,
 		buf:                        make([]byte, p.responseBufferSize),
+		tuning:                     p.tuning,
+		frameFilters:               p.frameFilters,
+		topicPrefixes:              p.topicPrefixes,
+		clusterRouting:             p.clusterRouting,
+		cluster:                    p.cluster,
+		capture:                    p.capture,
+		responseCache:              p.responseCache,
+		pendingResponses:           p.pendingResponses,
+		slowConsumer:               p.slowConsumer,
+		memoryBudget:               p.memoryBudget,
+		connMetadata:               p.connMetadata,
+		brokerMigrations:           p.brokerMigrations,
+		clientQuotas:               p.clientQuotas,
+		clusterCanary:              p.clusterCanary,
 	}
 	return ctx.responsesLoop(dst, src)
 }
@@ -218,9 +457,33 @@ type ResponsesLoopContext struct {
 	openRequestsChannel        <-chan protocol.RequestKeyVersion
 	nextResponseHandlerChannel <-chan ResponseHandler
 	netAddressMappingFunc      config.NetAddressMappingFunc
+	advertisedHostFunc         protocol.AdvertisedHostFunc
 	timeout                    time.Duration
 	brokerAddress              string
+	metricLabels               metricLabelValues
+	responseErrorMetrics       bool
 	buf                        []byte // bufSize
+	tuning                     *ProcessorTuning
+	frameFilters               *FrameFilters
+	topicPrefixes              *TopicPrefixes
+	clusterRouting             *ClusterRouting
+	cluster                    int
+	capture                    *connectionCapture
+	responseCache              *ResponseCache
+	pendingResponses           *pendingResponses
+	slowConsumer               *slowConsumer
+	memoryBudget               *MemoryBudget
+	connMetadata               *connMetadata
+	requestTimeout             time.Duration
+	maxFetchResponseSize       int
+	transparent                bool
+	brokerMigrations           *brokerMigrations
+	clientQuotas               *ClientQuotas
+	clusterCanary              *ClusterCanary
+	// the request received before its response when the requests time out
+	awaitedRequest *protocol.RequestKeyVersion
+	// correlation ids of the timed out requests whose broker responses are discarded
+	timedOutRequests []int32
 }
 
 type ResponseHandler interface {
@@ -1,10 +1,13 @@
 package proxy
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/proxy/protocol"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"strconv"
 	"time"
 )
@@ -18,13 +21,18 @@ type DefaultResponseHandler struct {
 func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src DeadlineReaderWriter, ctx *RequestsLoopContext) (readErr bool, err error) {
 	// logrus.Println("Await Kafka request")
 
-	// waiting for first bytes or EOF - reset deadlines
-	src.SetReadDeadline(time.Time{})
+	// waiting for first bytes or EOF - reset deadlines, the idle connection is closed when the gateway token expires
+	src.SetReadDeadline(ctx.gatewayExpiry)
 	dst.SetWriteDeadline(time.Time{})
 
 	keyVersionBuf := make([]byte, 8) // Size => int32 + ApiKey => int16 + ApiVersion => int16
 
-	if _, err = io.ReadFull(src, keyVersionBuf); err != nil {
+	_, err = io.ReadFull(src, keyVersionBuf)
+	if !ctx.gatewayExpiry.IsZero() && time.Now().After(ctx.gatewayExpiry) {
+		proxyExpiredSessionsTotal.WithLabelValues("gateway").Inc()
+		return true, fmt.Errorf("gateway token of client %s expired", ctx.clientAddress)
+	}
+	if err != nil {
 		return true, err
 	}
 
@@ -34,38 +42,82 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 	}
 	//logrus.Printf("Kafka request length %v, key %v, version %v", requestKeyVersion.Length, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
 
+	// the size covers at least the api key and the api version
+	if requestKeyVersion.Length < 4 {
+		return true, fmt.Errorf("request of length %d is invalid", requestKeyVersion.Length)
+	}
 	if requestKeyVersion.ApiKey < minRequestApiKey || requestKeyVersion.ApiKey > maxRequestApiKey {
 		return true, fmt.Errorf("api key %d is invalid", requestKeyVersion.ApiKey)
 	}
+	// the bytes of the buffered request are returned to the memory budget after the request is sent to the broker
+	defer ctx.releaseRequest()
 
-	proxyRequestsTotal.WithLabelValues(ctx.brokerAddress, strconv.Itoa(int(requestKeyVersion.ApiKey)), strconv.Itoa(int(requestKeyVersion.ApiVersion))).Inc()
-	proxyRequestsBytes.WithLabelValues(ctx.brokerAddress).Add(float64(requestKeyVersion.Length + 4))
+	metricLabels := ctx.metricLabels
+	metricLabels.principal = ctx.principal
+	metricLabels.apiKey, metricLabels.apiVersion = requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion
+	proxyRequestsTotal.with(&metricLabels).Inc()
+	proxyRequestsBytes.with(&metricLabels).Add(float64(requestKeyVersion.Length + 4))
+	ctx.connMetadata.addBytesIn(int64(requestKeyVersion.Length + 4))
+	if ctx.connMetadata.tracing() {
+		requestKeyVersion.TraceStart = time.Now()
+	}
 
 	if _, ok := ctx.forbiddenApiKeys[requestKeyVersion.ApiKey]; ok {
-		return true, fmt.Errorf("api key %d is forbidden", requestKeyVersion.ApiKey)
+		err = fmt.Errorf("api key %d is forbidden", requestKeyVersion.ApiKey)
+		emitEvent(Event{Type: EventRequestDenied, Broker: ctx.brokerAddress, Client: ctx.clientAddress, Principal: ctx.principal, Message: err.Error()})
+		return true, err
+	}
+	readOnly := ctx.readOnly.blocks(requestKeyVersion.ApiKey)
+	if readOnly && !ctx.readOnly.rejectable(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion) {
+		return true, fmt.Errorf("api key %d version %d is not allowed in the read-only mode", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
 	}
 
+	var requestBuf []byte
 	if ctx.localSasl.enabled {
 		if ctx.localSaslDone {
-			if requestKeyVersion.ApiKey == apiKeySaslHandshake {
-				return false, errors.New("SASL Auth was already done")
+			reauth := requestKeyVersion.ApiKey == apiKeySaslHandshake || requestKeyVersion.ApiKey == apiKeySaslAuthenticate
+			if ctx.localSasl.sessionLifetime <= 0 {
+				if requestKeyVersion.ApiKey == apiKeySaslHandshake {
+					return false, errors.New("SASL Auth was already done")
+				}
+			} else if reauth {
+				if requestBuf, err = ctx.readRequest(src, keyVersionBuf, requestKeyVersion); err != nil {
+					return true, err
+				}
+				var reauthResponse []byte
+				if reauthResponse, err = ctx.reauthenticate(requestBuf); err != nil {
+					return false, err
+				}
+				// the broker response to the substitute request is discarded, the local response is sent in the order of the requests
+				keyVersionBuf, requestBuf = substituteRequest(requestBuf)
+				requestKeyVersion.LocalResponse = reauthResponse
+			} else if !ctx.localSaslExpiry.IsZero() && time.Now().After(ctx.localSaslExpiry) {
+				proxyExpiredSessionsTotal.WithLabelValues("local").Inc()
+				return false, fmt.Errorf("SASL session of user %s expired", ctx.principal)
 			}
 		} else {
 			switch requestKeyVersion.ApiKey {
 			case apiKeySaslHandshake:
 				switch requestKeyVersion.ApiVersion {
 				case 0:
-					if err = ctx.localSasl.receiveAndSendSASLPlainAuthV0(src, keyVersionBuf); err != nil {
+					if ctx.principal, err = ctx.localSasl.receiveAndSendSASLPlainAuthV0(src, keyVersionBuf); err != nil {
 						return true, err
 					}
 				case 1:
-					if err = ctx.localSasl.receiveAndSendSASLPlainAuthV1(src, keyVersionBuf); err != nil {
+					if ctx.principal, err = ctx.localSasl.receiveAndSendSASLPlainAuthV1(src, keyVersionBuf); err != nil {
 						return true, err
 					}
 				default:
 					return true, fmt.Errorf("only saslHandshake version 0 and 1 are supported, got version %d", requestKeyVersion.ApiVersion)
 				}
 				ctx.localSaslDone = true
+				ctx.connMetadata.setPrincipal(ctx.principal)
+				if match := ctx.killSwitch.kills(ctx.principal, ""); match != "" {
+					ctx.killSwitch.killed(match, ctx.clientAddress, ctx.principal, "")
+					return true, fmt.Errorf("kill switch closed the connection of principal %s", ctx.principal)
+				}
+				ctx.localSaslExpiry = ctx.localSasl.sessionExpiry()
+				ctx.authContext = newAuthContext(src)
 				src.SetDeadline(time.Time{})
 
 				// defaultRequestHandler was consumed but due to local handling enqueued defaultResponseHandler will not be.
@@ -78,9 +130,209 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 		}
 	}
 
-	// send inFlightRequest to channel before myCopyN to prevent race condition in proxyResponses
-	if err = sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion); err != nil {
-		return true, err
+	if requestKeyVersion.LocalResponse == nil && ctx.clientTelemetry.terminates(requestKeyVersion.ApiKey) {
+		if requestBuf, err = ctx.readRequest(src, keyVersionBuf, requestKeyVersion); err != nil {
+			return true, err
+		}
+		var telemetryResponse []byte
+		if telemetryResponse, err = ctx.clientTelemetry.respond(ctx.clientAddress, requestBuf); err != nil {
+			return true, err
+		}
+		// the broker response to the substitute request is discarded, the local response is sent in the order of the requests
+		keyVersionBuf, requestBuf = substituteRequest(requestBuf)
+		requestKeyVersion.LocalResponse = telemetryResponse
+	}
+
+	var fault injectedFault
+	if requestKeyVersion.LocalResponse == nil {
+		fault = ctx.faults.inject(ctx.clientAddress, ctx.brokerAddress, requestKeyVersion.ApiKey)
+		if fault.disconnect {
+			return true, fmt.Errorf("fault injection closed the connection of client %s", ctx.clientAddress)
+		}
+		if fault.latency > 0 {
+			time.Sleep(fault.latency)
+		}
+		requestKeyVersion.DropResponse = fault.drop
+		ctx.slowConsumer.throttleFetch(requestKeyVersion.ApiKey)
+	}
+
+	// policies, authorization, record headers, filters, topic prefixes, cluster routing, capture, request logging and mirroring, response cache, injected errors, produce shadowing, the canary comparison, the read-only mode, the request timeouts (except Produce, its topics are decoded while it is streamed), the client id of the connection listing, the min api versions and the max Fetch response size require the whole request, it is read before anything is sent to the broker
+	capture := ctx.capture.enabled(requestKeyVersion.ApiKey)
+	sampled := ctx.requestLogSampleRate > 0 && rand.Float64() < ctx.requestLogSampleRate
+	mirrored := ctx.requestMirror.samples()
+	cacheable := ctx.responseCache.cacheable(requestKeyVersion.ApiKey) && !requestKeyVersion.DropResponse
+	shadowed := ctx.produceShadow.shadows(requestKeyVersion.ApiKey)
+	compared := ctx.clusterCanary.compares(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion) && !requestKeyVersion.DropResponse
+	timed := ctx.timesOut(requestKeyVersion)
+	identifying := ctx.connMetadata.identifying()
+	deprecated := ctx.oldClients.deprecated(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
+	limited := ctx.maxFetchResponseSize > 0 && requestKeyVersion.ApiKey == apiKeyFetch && !requestKeyVersion.DropResponse
+	if requestKeyVersion.LocalResponse == nil && (ctx.requestAuthz.enabled || ctx.requestPolicies.enabled() || ctx.recordHeaders.enabled() || ctx.frameFilters.enabled() || ctx.topicPrefixes.enabled() || ctx.clusterRouting.routes(requestKeyVersion.ApiKey) || capture || sampled || mirrored || cacheable || fault.errorCode != 0 || shadowed || compared || readOnly || (timed && requestKeyVersion.ApiKey != apiKeyProduce) || identifying || deprecated || limited) {
+		if requestBuf, err = ctx.readRequest(src, keyVersionBuf, requestKeyVersion); err != nil {
+			return true, err
+		}
+		if identifying {
+			// the connection listing shows the client id of the first request
+			var clientID string
+			if info, err := protocol.DecodeRequestHeader(requestBuf); err == nil {
+				clientID = info.ClientID
+			}
+			ctx.connMetadata.setClientID(clientID)
+			ctx.clientInventory.observe(ctx.principal, requestBuf)
+			if match := ctx.killSwitch.kills(ctx.principal, clientID); match != "" {
+				ctx.killSwitch.killed(match, ctx.clientAddress, ctx.principal, clientID)
+				return true, fmt.Errorf("kill switch closed the connection of client id %s", clientID)
+			}
+		}
+		if timed {
+			// the timeout response refers to the request of the client e.g. the topics without the prefix
+			ctx.setRequestDeadline(requestKeyVersion, requestBuf)
+		}
+		if limited {
+			// the error response refers to the request of the client
+			ctx.setOversizedResponse(requestKeyVersion, requestBuf)
+		}
+		if capture {
+			ctx.capture.write(captureRequest, keyVersionBuf[:4], requestBuf)
+		}
+		if sampled {
+			ctx.logRequest(requestBuf)
+		}
+		if mirrored {
+			ctx.mirrorRequest(requestBuf)
+		}
+		allowed := true
+		var errorResponse []byte
+		if fault.errorCode != 0 {
+			if errorResponse = encodeErrorResponse(requestBuf, fault.errorCode); errorResponse != nil {
+				allowed = false
+			}
+		}
+		// the requests denied by the min api versions, the read-only mode, the policies and the authorizer are audited
+		var deniedBy string
+		if allowed && deprecated && ctx.oldClients.refuses(ctx.clientAddress, requestBuf) {
+			if errorResponse = encodeErrorResponse(requestBuf, int16(protocol.ErrUnsupportedVersion)); errorResponse == nil {
+				return true, fmt.Errorf("api key %d version %d of client %s is refused as too old", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.clientAddress)
+			}
+			allowed, deniedBy = false, "min api version"
+		}
+		if allowed && readOnly {
+			allowed, deniedBy = false, "read-only mode"
+			if errorResponse, err = ctx.readOnly.reject(ctx.clientAddress, requestBuf); err != nil {
+				return true, err
+			}
+		}
+		if allowed && ctx.requestPolicies.enabled() {
+			if allowed, errorResponse, err = ctx.requestPolicies.authorize(ctx.requestPolicies.principal(ctx.principal, src), ctx.clientAddress, requestBuf); err != nil {
+				return true, err
+			}
+			if !allowed {
+				deniedBy = "policy"
+			}
+		}
+		if allowed && ctx.requestAuthz.enabled {
+			if allowed, errorResponse, err = ctx.requestAuthz.authorize(ctx.principal, ctx.clientAddress, requestBuf); err != nil {
+				return true, err
+			}
+			if !allowed {
+				deniedBy = "authorizer"
+			}
+		}
+		if deniedBy != "" {
+			emitEvent(Event{Type: EventRequestDenied, Broker: ctx.brokerAddress, Client: ctx.clientAddress, Principal: ctx.principal,
+				Message: fmt.Sprintf("api key %d version %d denied by %s", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, deniedBy)})
+		}
+		if allowed && ctx.recordHeaders.enabled() {
+			var injectedBuf []byte
+			if injectedBuf, err = ctx.recordHeaders.inject(ctx.principal, ctx.clientAddress, requestBuf); err != nil {
+				if allowed, errorResponse, err = rejectRequest(requestBuf, err); err != nil {
+					return true, err
+				}
+			} else {
+				requestBuf = injectedBuf
+			}
+		}
+		if allowed && ctx.frameFilters.enabled() {
+			var filteredBuf []byte
+			if filteredBuf, err = ctx.frameFilters.filterRequest(requestBuf); err != nil {
+				if allowed, errorResponse, err = rejectRequest(requestBuf, err); err != nil {
+					return true, err
+				}
+			} else {
+				requestBuf = filteredBuf
+			}
+		}
+		if allowed && ctx.topicPrefixes.enabled() {
+			prefix := ctx.topicPrefixes.prefix(ctx.principal)
+			var prefixedBuf []byte
+			if prefixedBuf, err = ctx.topicPrefixes.addPrefix(prefix, requestBuf); err != nil {
+				if allowed, errorResponse, err = rejectRequest(requestBuf, err); err != nil {
+					return true, err
+				}
+			} else {
+				requestBuf = prefixedBuf
+				requestKeyVersion.TopicPrefix = prefix
+			}
+		}
+		if allowed && ctx.clusterRouting.routes(requestKeyVersion.ApiKey) {
+			var routedBuf []byte
+			if routedBuf, err = ctx.clusterRouting.routeRequest(ctx.cluster, requestBuf); err != nil {
+				if allowed, errorResponse, err = rejectRequest(requestBuf, err); err != nil {
+					return true, err
+				}
+			} else {
+				requestKeyVersion.ClusterRequest = requestBuf
+				requestBuf = routedBuf
+			}
+		}
+		if allowed {
+			// size field of the modified request
+			binary.BigEndian.PutUint32(keyVersionBuf, uint32(len(requestBuf)))
+			if shadowed {
+				// the shadow cluster receives the request sent to the broker
+				ctx.produceShadow.shadow(requestBuf)
+			}
+			if compared {
+				// the canary cluster receives the request sent to the broker
+				requestKeyVersion.CanaryRequest = requestBuf
+			}
+			// the responses with the topic prefix of the principal or merged from the other clusters are not shared
+			if cacheable && requestKeyVersion.TopicPrefix == "" && requestKeyVersion.ClusterRequest == nil {
+				var served bool
+				if served, err = ctx.serveCachedResponse(src, requestKeyVersion, requestBuf); served || err != nil {
+					return false, err
+				}
+			}
+		} else {
+			if errorResponse == nil {
+				// defaultRequestHandler was consumed but as the client does not expect a response defaultResponseHandler will not be.
+				return false, ctx.putNextRequestHandler(defaultRequestHandler)
+			}
+			keyVersionBuf, requestBuf = substituteRequest(requestBuf)
+			requestKeyVersion.LocalResponse = errorResponse
+			// the local response does not time out
+			requestKeyVersion.Deadline = time.Time{}
+		}
+	}
+
+	// the client id of the connection is known after the first request was read
+	ctx.clientQuotas.recordRequest(ctx.connMetadata, requestKeyVersion.ApiKey, int(requestKeyVersion.Length+4))
+
+	if ctx.upstreamSession != nil && ctx.upstreamSession.expiring() {
+		// the request waits until the connection to the broker is re-authenticated
+		if err = ctx.upstreamSession.reauthenticate(ctx.upstreamRoundTrip(dst)); err != nil {
+			return false, fmt.Errorf("SASL re-authentication to %s failed: %v", ctx.brokerAddress, err)
+		}
+	}
+
+	// the streamed Produce request gets its deadline and REQUEST_TIMED_OUT response after it is written to the broker
+	streamed := timed && requestBuf == nil && requestKeyVersion.ApiKey == apiKeyProduce
+	if !streamed {
+		// send inFlightRequest to channel before myCopyN to prevent race condition in proxyResponses
+		ctx.pendingResponses.add()
+		if err = sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion); err != nil {
+			return true, err
+		}
 	}
 
 	requestDeadline := time.Now().Add(ctx.timeout)
@@ -97,9 +349,33 @@ func (handler *DefaultRequestHandler) handleRequest(dst DeadlineWriter, src Dead
 	if _, err = dst.Write(keyVersionBuf); err != nil {
 		return false, err
 	}
-	// 4 bytes were written as keyVersionBuf (ApiKey, ApiVersion)
-	if readErr, err = myCopyN(dst, src, int64(requestKeyVersion.Length-4), ctx.buf); err != nil {
-		return readErr, err
+	if requestBuf != nil {
+		if _, err = dst.Write(requestBuf[4:]); err != nil {
+			return false, err
+		}
+	} else if streamed {
+		if readErr, err = ctx.streamTimedRequest(dst, src, requestKeyVersion); err != nil {
+			return readErr, err
+		}
+		// the responses loop waits for the request when the broker response header arrives first
+		ctx.pendingResponses.add()
+		if err = sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion); err != nil {
+			return true, err
+		}
+	} else {
+		// 4 bytes were written as keyVersionBuf (ApiKey, ApiVersion)
+		var spliced bool
+		if ctx.transparent {
+			if spliced, readErr, err = spliceN(dst, src, int64(requestKeyVersion.Length-4)); err != nil {
+				return readErr, err
+			}
+		}
+		if !spliced {
+			ctx.buf = ctx.tuning.requestBuffer(ctx.buf)
+			if readErr, err = myCopyN(dst, src, int64(requestKeyVersion.Length-4), ctx.buf); err != nil {
+				return readErr, err
+			}
+		}
 	}
 	if requestKeyVersion.ApiKey == apiKeySaslHandshake {
 		if requestKeyVersion.ApiVersion == 0 {
@@ -117,7 +393,13 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 	dst.SetWriteDeadline(time.Time{})
 
 	responseHeaderBuf := make([]byte, 8) // Size => int32, CorrelationId => int32
-	if _, err = io.ReadFull(src, responseHeaderBuf); err != nil {
+	var requestKeyVersion *protocol.RequestKeyVersion
+	if ctx.requestTimeout > 0 {
+		// nil request when the REQUEST_TIMED_OUT response was written
+		if requestKeyVersion, readErr, err = ctx.awaitResponseHeader(dst, src, responseHeaderBuf); err != nil || requestKeyVersion == nil {
+			return readErr, err
+		}
+	} else if _, err = io.ReadFull(src, responseHeaderBuf); err != nil {
 		return true, err
 	}
 
@@ -125,13 +407,31 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 	if err = protocol.Decode(responseHeaderBuf, &responseHeader); err != nil {
 		return true, err
 	}
+	// the size covers at least the correlation id
+	if responseHeader.Length < 4 {
+		return true, fmt.Errorf("response of length %d is invalid", responseHeader.Length)
+	}
 
-	// Read the inFlightRequests channel after header is read. Otherwise the channel would block and socket EOF from remote would not be received.
-	requestKeyVersion, err := receiveRequestKeyVersion(ctx.openRequestsChannel, openRequestReceiveTimeout)
-	if err != nil {
-		return true, err
+	if requestKeyVersion == nil {
+		// Read the inFlightRequests channel after header is read. Otherwise the channel would block and socket EOF from remote would not be received.
+		if requestKeyVersion, err = receiveRequestKeyVersion(ctx.openRequestsChannel, openRequestReceiveTimeout); err != nil {
+			return true, err
+		}
+	}
+	if !requestKeyVersion.TraceStart.IsZero() {
+		// the request and response pair is logged after the response is written to the client
+		defer ctx.connMetadata.trace(requestKeyVersion, &responseHeader)
+	}
+	if ctx.oversized(requestKeyVersion, &responseHeader) {
+		// the response is discarded before it is buffered
+		return ctx.rejectOversizedResponse(dst, src, requestKeyVersion, &responseHeader)
+	}
+	if requestKeyVersion.ProxyResponse == nil {
+		// the cached responses are written by the requests loop after the response is written to the client
+		defer ctx.pendingResponses.done()
 	}
-	proxyResponsesBytes.WithLabelValues(ctx.brokerAddress).Add(float64(responseHeader.Length + 4))
+	proxyResponsesBytes.with(&ctx.metricLabels).Add(float64(responseHeader.Length + 4))
+	ctx.connMetadata.addBytesOut(int64(responseHeader.Length + 4))
 	//logrus.Printf("Kafka response lenght %v for key %v, version %v", responseHeader.Length, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
 
 	responseDeadline := time.Now().Add(ctx.timeout)
@@ -143,32 +443,109 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 	if err != nil {
 		return true, err
 	}
-
-	responseModifier, err := protocol.GetResponseModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.netAddressMappingFunc)
-	if err != nil {
-		return true, err
+	if ctx.slowConsumer.detects(requestKeyVersion.ApiKey) {
+		// the writes of the Fetch response to the client are timed
+		dst = ctx.slowConsumer.writer(dst, responseDeadline)
 	}
-	if responseModifier != nil {
+
+	if requestKeyVersion.ProxyResponse != nil {
 		if int32(responseHeader.Length) > protocol.MaxResponseSize {
 			return true, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", responseHeader.Length)}
 		}
+		// the body is buffered when the response fits into the memory budget
+		ctx.memoryBudget.acquire(int64(responseHeader.Length))
+		defer ctx.memoryBudget.release(int64(responseHeader.Length))
 		resp := make([]byte, int(responseHeader.Length-4))
 		if _, err = io.ReadFull(src, resp); err != nil {
 			return true, err
 		}
-		newResponseBuf, err := responseModifier.Apply(resp)
-		if err != nil {
+		requestKeyVersion.ProxyResponse <- resp
+		return false, nil
+	}
+	if requestKeyVersion.DropResponse {
+		// the injected fault - the client does not receive the response
+		if _, err = io.CopyN(ioutil.Discard, src, int64(responseHeader.Length-4)); err != nil {
 			return true, err
 		}
-		// add 4 bytes (CorrelationId) to the length
-		newHeaderBuf, err := protocol.Encode(&protocol.ResponseHeader{Length: int32(len(newResponseBuf) + 4), CorrelationID: responseHeader.CorrelationID})
-		if err != nil {
+		return false, nil
+	}
+	if requestKeyVersion.LocalResponse != nil {
+		// the broker response to the substitute request is discarded
+		if _, err = io.CopyN(ioutil.Discard, src, int64(responseHeader.Length-4)); err != nil {
 			return true, err
 		}
-		if _, err := dst.Write(newHeaderBuf); err != nil {
-			return false, err
+		if ctx.capture.enabled(requestKeyVersion.ApiKey) {
+			ctx.captureResponse(responseHeader.CorrelationID, requestKeyVersion.LocalResponse)
+		}
+		return false, writeResponse(dst, responseHeader.CorrelationID, requestKeyVersion.LocalResponse)
+	}
+
+	var responseModifier protocol.ResponseModifier
+	if !ctx.transparent {
+		if responseModifier, err = protocol.GetResponseModifier(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.netAddressMappingFunc, ctx.advertisedHostFunc); err != nil {
+			return true, err
 		}
-		if _, err := dst.Write(newResponseBuf); err != nil {
+	}
+	responseErrors := ctx.responseErrorMetrics && protocol.ResponseErrorsSupported(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
+	capture := ctx.capture.enabled(requestKeyVersion.ApiKey)
+	migrations := ctx.brokerMigrations != nil && requestKeyVersion.ApiKey == apiKeyMetadata
+	throttle, quota := ctx.clientQuotas.throttle(ctx.connMetadata, requestKeyVersion.ApiKey, int(responseHeader.Length+4))
+	throttled := throttle > 0 && protocol.ThrottleTimeSupported(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion)
+	if responseModifier != nil || requestKeyVersion.TopicPrefix != "" || requestKeyVersion.ClusterRequest != nil || ctx.frameFilters.enabled() || responseErrors || capture || requestKeyVersion.CacheKey != "" || requestKeyVersion.CanaryRequest != nil || migrations || throttled {
+		if int32(responseHeader.Length) > protocol.MaxResponseSize {
+			return true, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", responseHeader.Length)}
+		}
+		// the body is buffered when the response fits into the memory budget
+		ctx.memoryBudget.acquire(int64(responseHeader.Length))
+		defer ctx.memoryBudget.release(int64(responseHeader.Length))
+		resp := make([]byte, int(responseHeader.Length-4))
+		if _, err = io.ReadFull(src, resp); err != nil {
+			return true, err
+		}
+		if responseErrors {
+			ctx.countResponseErrors(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, resp)
+		}
+		if requestKeyVersion.CanaryRequest != nil {
+			// the broker response is compared before it is modified
+			ctx.clusterCanary.compare(requestKeyVersion.CanaryRequest, resp)
+		}
+		newResponseBuf := resp
+		if requestKeyVersion.ClusterRequest != nil {
+			// the brokers of other clusters are mapped by the response modifier
+			if newResponseBuf, err = ctx.clusterRouting.routeResponse(ctx.cluster, requestKeyVersion.ClusterRequest, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, newResponseBuf); err != nil {
+				return true, err
+			}
+		}
+		if migrations {
+			// the broker addresses are observed before they are mapped
+			ctx.brokerMigrations.observeMetadata(requestKeyVersion.ApiVersion, newResponseBuf)
+		}
+		if responseModifier != nil {
+			if newResponseBuf, err = responseModifier.Apply(newResponseBuf); err != nil {
+				return true, err
+			}
+		}
+		if requestKeyVersion.TopicPrefix != "" {
+			if newResponseBuf, err = ctx.topicPrefixes.removePrefix(requestKeyVersion.TopicPrefix, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, newResponseBuf); err != nil {
+				return true, err
+			}
+		}
+		if ctx.frameFilters.enabled() {
+			if newResponseBuf, err = ctx.frameFilters.filterResponse(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, newResponseBuf); err != nil {
+				return true, err
+			}
+		}
+		if requestKeyVersion.CacheKey != "" {
+			ctx.responseCache.put(requestKeyVersion.CacheKey, requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, newResponseBuf)
+		}
+		if throttled {
+			// the cached response is shared, the throttle time is set on its copy
+			newResponseBuf = ctx.throttleResponse(requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, newResponseBuf, throttle, quota)
+		}
+		if capture {
+			ctx.captureResponse(responseHeader.CorrelationID, newResponseBuf)
+		}
+		if err = writeResponse(dst, responseHeader.CorrelationID, newResponseBuf); err != nil {
 			return false, err
 		}
 	} else {
@@ -177,13 +554,169 @@ func (handler *DefaultResponseHandler) handleResponse(dst DeadlineWriter, src De
 			return false, err
 		}
 		// 4 bytes were written as responseHeaderBuf (CorrelationId)
-		if readErr, err = myCopyN(dst, src, int64(responseHeader.Length-4), ctx.buf); err != nil {
-			return readErr, err
+		var spliced bool
+		if ctx.transparent {
+			if spliced, readErr, err = spliceN(dst, src, int64(responseHeader.Length-4)); err != nil {
+				return readErr, err
+			}
+		}
+		if !spliced {
+			ctx.buf = ctx.tuning.responseBuffer(ctx.buf)
+			if readErr, err = myCopyN(dst, src, int64(responseHeader.Length-4), ctx.buf); err != nil {
+				return readErr, err
+			}
 		}
 	}
 	return false, nil // continue nextResponse
 }
 
+// logRequest logs the decoded header of the request sample
+func (ctx *RequestsLoopContext) logRequest(request []byte) {
+	info, err := protocol.DecodeRequestHeader(request)
+	if err != nil {
+		logger.Debugf("Header of the request sample from %s to %s cannot be decoded: %v", ctx.clientAddress, ctx.brokerAddress, err)
+		return
+	}
+	logger.Infof("Request sample from %s to %s: api key %d, api version %d, correlation id %d, client id '%s', size %d",
+		ctx.clientAddress, ctx.brokerAddress, info.ApiKey, info.ApiVersion, info.CorrelationID, info.ClientID, len(request)+4)
+}
+
+// mirrorRequest queues the decoded metadata of the request sample to the request mirror, the topics of the request which cannot
+// be decoded are not mirrored
+func (ctx *RequestsLoopContext) mirrorRequest(request []byte) {
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		if info, err = protocol.DecodeRequestHeader(request); err != nil {
+			logger.Debugf("Header of the request sample from %s to %s cannot be decoded: %v", ctx.clientAddress, ctx.brokerAddress, err)
+			return
+		}
+	}
+	ctx.requestMirror.mirror(RequestSample{
+		Time:          time.Now(),
+		Broker:        ctx.brokerAddress,
+		Client:        ctx.clientAddress,
+		Principal:     ctx.principal,
+		ClientID:      info.ClientID,
+		ApiKey:        info.ApiKey,
+		ApiName:       protocol.ApiKeyName(info.ApiKey),
+		ApiVersion:    info.ApiVersion,
+		CorrelationID: info.CorrelationID,
+		Size:          len(request) + 4,
+		Topics:        info.Topics,
+	})
+}
+
+// serveCachedResponse writes the cached response to the client instead of sending the request to the broker. When the response is not cached
+// or other responses of the connection are pending, the request is sent to the broker and its response is cached.
+func (ctx *RequestsLoopContext) serveCachedResponse(client DeadlineWriter, requestKeyVersion *protocol.RequestKeyVersion, request []byte) (served bool, err error) {
+	key, err := responseCacheKey(ctx.brokerAddress, request)
+	if err != nil {
+		logger.Debugf("Response cache key of the request from %s cannot be computed: %v", ctx.clientAddress, err)
+		return false, nil
+	}
+	apiKey := strconv.Itoa(int(requestKeyVersion.ApiKey))
+	response := ctx.responseCache.get(key)
+	if response == nil || !ctx.pendingResponses.none() {
+		proxyResponseCacheTotal.WithLabelValues(ctx.brokerAddress, apiKey, "miss").Inc()
+		requestKeyVersion.CacheKey = key
+		return false, nil
+	}
+	proxyResponseCacheTotal.WithLabelValues(ctx.brokerAddress, apiKey, "hit").Inc()
+
+	correlationID := int32(binary.BigEndian.Uint32(request[4:]))
+	if ctx.capture.enabled(requestKeyVersion.ApiKey) {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header, uint32(len(response)+4))
+		binary.BigEndian.PutUint32(header[4:], uint32(correlationID))
+		ctx.capture.write(captureResponse, header, response)
+	}
+	if err = client.SetWriteDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return true, err
+	}
+	if err = writeResponse(client, correlationID, response); err != nil {
+		return true, err
+	}
+	// defaultRequestHandler was consumed but as the response was written defaultResponseHandler will not be.
+	return true, ctx.putNextRequestHandler(defaultRequestHandler)
+}
+
+// countResponseErrors counts the error codes of the broker response, the response which cannot be decoded is not counted
+func (ctx *ResponsesLoopContext) countResponseErrors(apiKey int16, apiVersion int16, response []byte) {
+	errorCodes, err := protocol.ResponseErrorCodes(apiKey, apiVersion, response)
+	if err != nil {
+		logger.Debugf("Error codes of the response with api key %d version %d from %s cannot be decoded: %v", apiKey, apiVersion, ctx.brokerAddress, err)
+		return
+	}
+	for _, errorCode := range errorCodes {
+		proxyResponseErrorsTotal.WithLabelValues(ctx.brokerAddress, strconv.Itoa(int(apiKey)), strconv.Itoa(int(errorCode))).Inc()
+	}
+}
+
+// throttleResponse raises the throttle time of the response of the client exceeding the quota, the response which cannot be modified is returned
+func (ctx *ResponsesLoopContext) throttleResponse(apiKey int16, apiVersion int16, response []byte, throttle time.Duration, quota string) []byte {
+	throttled, err := protocol.SetThrottleTime(apiKey, apiVersion, response, int32(throttle/time.Millisecond))
+	if err != nil {
+		logger.Debugf("Throttle time of the response with api key %d version %d from %s cannot be set: %v", apiKey, apiVersion, ctx.brokerAddress, err)
+		return response
+	}
+	proxyClientQuotaThrottledResponsesTotal.WithLabelValues(quota).Inc()
+	return throttled
+}
+
+// captureResponse captures the response frame as it is written to the client
+func (ctx *ResponsesLoopContext) captureResponse(correlationID int32, response []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(response)+4))
+	binary.BigEndian.PutUint32(header[4:], uint32(correlationID))
+	ctx.capture.write(captureResponse, header, response)
+}
+
+// readRequest reads the whole request when it fits into the memory budget, the bytes are returned to the budget by releaseRequest
+func (ctx *RequestsLoopContext) readRequest(src DeadlineReader, keyVersionBuf []byte, requestKeyVersion *protocol.RequestKeyVersion) ([]byte, error) {
+	if int32(requestKeyVersion.Length) > protocol.MaxRequestSize {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("request of length %d too large", requestKeyVersion.Length)}
+	}
+	ctx.memoryBudget.acquire(int64(requestKeyVersion.Length))
+	ctx.bufferedBytes += int64(requestKeyVersion.Length)
+	return readRequest(src, keyVersionBuf, requestKeyVersion, ctx.timeout)
+}
+
+// releaseRequest returns the bytes of the buffered request to the memory budget
+func (ctx *RequestsLoopContext) releaseRequest() {
+	ctx.memoryBudget.release(ctx.bufferedBytes)
+	ctx.bufferedBytes = 0
+}
+
+// readRequest reads the whole request whose size, ApiKey and ApiVersion were read as keyVersionBuf. The request does not contain the size.
+func readRequest(src DeadlineReader, keyVersionBuf []byte, requestKeyVersion *protocol.RequestKeyVersion, timeout time.Duration) ([]byte, error) {
+	if int32(requestKeyVersion.Length) > protocol.MaxRequestSize {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("request of length %d too large", requestKeyVersion.Length)}
+	}
+	if err := src.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	requestBuf := make([]byte, int(requestKeyVersion.Length))
+	copy(requestBuf, keyVersionBuf[4:])
+	if _, err := io.ReadFull(src, requestBuf[4:]); err != nil {
+		return nil, err
+	}
+	return requestBuf, nil
+}
+
+// writeResponse writes the response header and the response body to the client
+func writeResponse(dst DeadlineWriter, correlationID int32, response []byte) error {
+	// add 4 bytes (CorrelationId) to the length
+	headerBuf, err := protocol.Encode(&protocol.ResponseHeader{Length: int32(len(response) + 4), CorrelationID: correlationID})
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(headerBuf); err != nil {
+		return err
+	}
+	_, err = dst.Write(response)
+	return err
+}
+
 func sendRequestKeyVersion(openRequestsChannel chan<- protocol.RequestKeyVersion, timeout time.Duration, request *protocol.RequestKeyVersion) error {
 	select {
 	case openRequestsChannel <- *request:
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDefaultRequestHandlerRequestLog(t *testing.T) {
+	a := assert.New(t)
+
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	defer logrus.SetOutput(os.Stderr)
+
+	for _, sampleRate := range []float64{0, 1} {
+		client, local := net.Pipe()
+		remote, broker := net.Pipe()
+		ctx := &RequestsLoopContext{
+			openRequestsChannel:        make(chan protocol.RequestKeyVersion, 1),
+			nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+			nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+			timeout:                    time.Second,
+			buf:                        make([]byte, 16),
+			localSasl:                  &LocalSasl{},
+			requestAuthz:               &RequestAuthz{},
+			clientAddress:              "10.0.0.5:51000",
+			brokerAddress:              "kafka-0:9092",
+			requestLogSampleRate:       sampleRate,
+		}
+		go func() {
+			client.Write(testMetadataRequest)
+			client.Close()
+		}()
+		received := make(chan []byte, 1)
+		go func() {
+			buf, _ := ioutil.ReadAll(broker)
+			received <- buf
+		}()
+		_, err := defaultRequestHandler.handleRequest(remote, local, ctx)
+		a.Nil(err)
+		remote.Close()
+		a.Equal(testMetadataRequest, <-received)
+	}
+	a.Equal(1, bytes.Count(logs.Bytes(), []byte("Request sample")))
+	a.Contains(logs.String(), "Request sample from 10.0.0.5:51000 to kafka-0:9092: api key 3, api version 4, correlation id 1, client id 'c', size 24")
+}
+
+func TestDefaultResponseHandlerTransparent(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	pair := func() (net.Conn, net.Conn) {
+		dialed, err := net.Dial("tcp", l.Addr().String())
+		a.Nil(err)
+		accepted, err := l.Accept()
+		a.Nil(err)
+		return dialed, accepted
+	}
+	brokerSide, proxySide := pair()
+	defer brokerSide.Close()
+	defer proxySide.Close()
+	proxyClientSide, clientSide := pair()
+	defer proxyClientSide.Close()
+	defer clientSide.Close()
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	ctx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		netAddressMappingFunc: func(brokerHost string, brokerPort int32) (string, int32, error) {
+			return "", 0, errors.New("broker is not mapped")
+		},
+		timeout:     time.Second,
+		buf:         make([]byte, 16),
+		transparent: true,
+	}
+	// the Metadata response is relayed without decoding it
+	response := append([]byte{0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x07}, []byte("metadata")...)
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 3, ApiVersion: 1}
+	go brokerSide.Write(response)
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, ctx)
+	a.Nil(err)
+	received := make([]byte, len(response))
+	_, err = io.ReadFull(clientSide, received)
+	a.Nil(err)
+	a.Equal(response, received)
+
+	// the response of other listeners is rewritten
+	ctx.transparent = false
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 3, ApiVersion: 1}
+	go brokerSide.Write(response)
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, ctx)
+	a.NotNil(err)
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// apiKeyNames are the names of the request types of the Kafka protocol
+var apiKeyNames = []string{
+	"Produce",
+	"Fetch",
+	"ListOffsets",
+	"Metadata",
+	"LeaderAndIsr",
+	"StopReplica",
+	"UpdateMetadata",
+	"ControlledShutdown",
+	"OffsetCommit",
+	"OffsetFetch",
+	"FindCoordinator",
+	"JoinGroup",
+	"Heartbeat",
+	"LeaveGroup",
+	"SyncGroup",
+	"DescribeGroups",
+	"ListGroups",
+	"SaslHandshake",
+	"ApiVersions",
+	"CreateTopics",
+	"DeleteTopics",
+	"DeleteRecords",
+	"InitProducerId",
+	"OffsetForLeaderEpoch",
+	"AddPartitionsToTxn",
+	"AddOffsetsToTxn",
+	"EndTxn",
+	"WriteTxnMarkers",
+	"TxnOffsetCommit",
+	"DescribeAcls",
+	"CreateAcls",
+	"DeleteAcls",
+	"DescribeConfigs",
+	"AlterConfigs",
+	"AlterReplicaLogDirs",
+	"DescribeLogDirs",
+	"SaslAuthenticate",
+	"CreatePartitions",
+	"CreateDelegationToken",
+	"RenewDelegationToken",
+	"ExpireDelegationToken",
+	"DescribeDelegationToken",
+	"DeleteGroups",
+	"ElectLeaders",
+	"IncrementalAlterConfigs",
+	"AlterPartitionReassignments",
+	"ListPartitionReassignments",
+	"OffsetDelete",
+	"DescribeClientQuotas",
+	"AlterClientQuotas",
+	"DescribeUserScramCredentials",
+	"AlterUserScramCredentials",
+	"Vote",
+	"BeginQuorumEpoch",
+	"EndQuorumEpoch",
+	"DescribeQuorum",
+	"AlterPartition",
+	"UpdateFeatures",
+	"Envelope",
+	"FetchSnapshot",
+	"DescribeCluster",
+	"DescribeProducers",
+	"BrokerRegistration",
+	"BrokerHeartbeat",
+	"UnregisterBroker",
+	"DescribeTransactions",
+	"ListTransactions",
+	"AllocateProducerIds",
+	"ConsumerGroupHeartbeat",
+	"ConsumerGroupDescribe",
+	"ControllerRegistration",
+	"GetTelemetrySubscriptions",
+	"PushTelemetry",
+	"AssignReplicasToDirs",
+	"ListClientMetricsResources",
+	"DescribeTopicPartitions",
+	"ShareGroupHeartbeat",
+	"ShareGroupDescribe",
+	"ShareFetch",
+	"ShareAcknowledge",
+	"AddRaftVoter",
+	"RemoveRaftVoter",
+	"UpdateRaftVoter",
+}
+
+// ApiKeyByName returns the api key of the request type given by its case-insensitive name (e.g. Fetch) or by its number
+func ApiKeyByName(name string) (int16, bool) {
+	for apiKey, apiKeyName := range apiKeyNames {
+		if strings.EqualFold(apiKeyName, name) {
+			return int16(apiKey), true
+		}
+	}
+	apiKey, err := strconv.ParseInt(name, 10, 16)
+	if err != nil || apiKey < 0 {
+		return 0, false
+	}
+	return int16(apiKey), true
+}
+
+// ApiKeyName returns the name of the api key, the number for the unknown api keys
+func ApiKeyName(apiKey int16) string {
+	if apiKey >= 0 && int(apiKey) < len(apiKeyNames) {
+		return apiKeyNames[apiKey]
+	}
+	return strconv.Itoa(int(apiKey))
+}
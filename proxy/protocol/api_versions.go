@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// the ApiVersions requests v3+ have the request header v2 and name the client software
+const minClientSoftwareApiVersionsVersion = 3
+
+var apiVersionsRequestV3 = NewSchema("api_versions_request_v3",
+	&field{name: "client_software_name", ty: typeCompactStr},
+	&field{name: "client_software_version", ty: typeCompactStr},
+	&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+)
+
+// ClientSoftware is the client id of the request and the client software named by the ApiVersions request v3+
+type ClientSoftware struct {
+	ClientID string
+	// empty when the request is not an ApiVersions request v3+
+	Name    string
+	Version string
+}
+
+// EncodeApiVersionsRequest returns the ApiVersions v0 request (without the size), the cheapest request answered by every broker
+func EncodeApiVersionsRequest(correlationID int32, clientID string) []byte {
+	request := make([]byte, 0, 2+2+4+2+len(clientID))
+	request = appendInt16(request, apiKeyApiVersions)
+	request = appendInt16(request, 0)
+	request = appendInt32(request, correlationID)
+	return appendString(request, clientID)
+}
+
+// DecodeApiVersionsError returns the error of the ApiVersions response (without the size and the correlation id)
+func DecodeApiVersionsError(response []byte) error {
+	if len(response) < 2 {
+		return errors.New("ApiVersions response is too short")
+	}
+	if errorCode := KError(int16(binary.BigEndian.Uint16(response))); errorCode != ErrNoError {
+		return errorCode
+	}
+	return nil
+}
+
+// DecodeClientSoftware returns the client id of the request (without the size) and the client software of the ApiVersions request v3+
+func DecodeClientSoftware(request []byte) (*ClientSoftware, error) {
+	info, headerLength, err := decodeRequestHeader(request)
+	if err != nil {
+		return nil, err
+	}
+	software := &ClientSoftware{ClientID: info.ClientID}
+	if info.ApiKey != apiKeyApiVersions || info.ApiVersion < minClientSoftwareApiVersionsVersion {
+		return software, nil
+	}
+	if headerLength >= len(request) {
+		return nil, PacketDecodingError{Info: "request header tagged fields are missing"}
+	}
+	if headerLength, err = skipTaggedFields(request, headerLength); err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(request[headerLength:], apiVersionsRequestV3)
+	if err != nil {
+		return nil, fmt.Errorf("ApiVersions request version %d cannot be decoded: %v", info.ApiVersion, err)
+	}
+	software.Name = body.Get("client_software_name").(string)
+	software.Version = body.Get("client_software_version").(string)
+	return software, nil
+}
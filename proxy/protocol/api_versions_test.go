@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEncodeApiVersionsRequest(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x02, 'i', 'd'}, EncodeApiVersionsRequest(7, "id"))
+
+	a.Nil(DecodeApiVersionsError([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}))
+	a.Equal(ErrUnsupportedVersion, DecodeApiVersionsError([]byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x00}))
+	a.EqualError(DecodeApiVersionsError([]byte{0x00}), "ApiVersions response is too short")
+}
+
+func TestDecodeClientSoftware(t *testing.T) {
+	a := assert.New(t)
+
+	software, err := DecodeClientSoftware(EncodeApiVersionsRequest(7, "legacy"))
+	a.Nil(err)
+	a.Equal(&ClientSoftware{ClientID: "legacy"}, software)
+
+	request := appendInt16(nil, apiKeyApiVersions)
+	request = appendInt16(request, 3)
+	request = appendInt32(request, 7)
+	request = appendString(request, "orders")
+	request = append(request, 0x00) // request header tagged fields
+	request = appendCompactString(request, "apache-kafka-java")
+	request = appendCompactString(request, "3.7.0")
+	request = append(request, 0x00)
+	software, err = DecodeClientSoftware(request)
+	a.Nil(err)
+	a.Equal(&ClientSoftware{ClientID: "orders", Name: "apache-kafka-java", Version: "3.7.0"}, software)
+
+	_, err = DecodeClientSoftware(request[:14])
+	a.NotNil(err)
+	_, err = DecodeClientSoftware(request[:18])
+	a.NotNil(err)
+}
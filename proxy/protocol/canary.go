@@ -0,0 +1,131 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// CanaryMetadataVersion is the version of the Metadata requests to the canary cluster, the first version
+	// which does not create the requested topics
+	CanaryMetadataVersion = 4
+	// maxCanaryListOffsetsVersion is the last ListOffsets version compared with the canary cluster
+	maxCanaryListOffsetsVersion = 5
+)
+
+// ListOffsetsPartition is the partition of the ListOffsets response
+type ListOffsetsPartition struct {
+	ErrorCode int16
+	// the first offset of v0, -1 when v0 returned no offsets
+	Offset int64
+}
+
+// CanaryVersionSupported checks whether the request can be compared with the canary cluster: the Metadata requests up to v8
+// and the ListOffsets requests up to v5 are decoded
+func CanaryVersionSupported(apiKey int16, apiVersion int16) bool {
+	switch apiKey {
+	case apiKeyMetadata:
+		return apiVersion >= 0 && int(apiVersion) < len(requestSchemaVersions[apiKeyMetadata])
+	case apiKeyListOffsets:
+		return apiVersion >= 0 && apiVersion <= maxCanaryListOffsetsVersion
+	}
+	return false
+}
+
+// EncodeCanaryMetadataRequest returns the Metadata request (without the size) for the topics or for all topics when the topics are nil.
+// The request does not create the topics, its response is decoded by DecodeMetadataLeaders with CanaryMetadataVersion.
+func EncodeCanaryMetadataRequest(correlationID int32, clientID string, topics []string) []byte {
+	length := 2 + 2 + 4 + 2 + len(clientID) + 4 + 1
+	for _, topic := range topics {
+		length += 2 + len(topic)
+	}
+	request := make([]byte, 0, length)
+	request = appendInt16(request, apiKeyMetadata)
+	request = appendInt16(request, CanaryMetadataVersion)
+	request = appendInt32(request, correlationID)
+	request = appendString(request, clientID)
+	if topics == nil {
+		request = appendInt32(request, -1)
+	} else {
+		request = appendInt32(request, int32(len(topics)))
+		for _, topic := range topics {
+			request = appendString(request, topic)
+		}
+	}
+	// allow_auto_topic_creation
+	return append(request, 0)
+}
+
+// PartitionLeaders returns the addresses of the partition leaders, the partitions without a leader are skipped
+func (m *MetadataLeaders) PartitionLeaders() *PartitionLeaders {
+	leaders := &PartitionLeaders{Brokers: make(map[int32]string), Leaders: make(map[string]map[int32]int32)}
+	for _, broker := range m.Brokers {
+		leaders.Brokers[broker.NodeID] = net.JoinHostPort(broker.Host, fmt.Sprint(broker.Port))
+	}
+	for _, topic := range m.Topics {
+		if topic.ErrorCode != 0 {
+			continue
+		}
+		partitions := make(map[int32]int32, len(topic.Leaders))
+		for partition, leader := range topic.Leaders {
+			if leader >= 0 {
+				partitions[partition] = leader
+			}
+		}
+		leaders.Leaders[topic.Name] = partitions
+	}
+	return leaders
+}
+
+// SplitListOffsetsRequest splits the ListOffsets request (without the size) up to v5 by the leaders of its partitions. It returns
+// the requests (without the size) with the header of the request by the leader address and the topics of the partitions without a known leader.
+func SplitListOffsetsRequest(request []byte, leader func(topic string, partition int32) (string, bool)) (map[string][]byte, []string, error) {
+	info, headerLength, err := decodeRequestHeader(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.ApiKey != apiKeyListOffsets || !CanaryVersionSupported(info.ApiKey, info.ApiVersion) {
+		return nil, nil, fmt.Errorf("list offsets request up to v%d expected, got api key %d version %d", maxCanaryListOffsetsVersion, info.ApiKey, info.ApiVersion)
+	}
+	schema := requestSchemaVersions[apiKeyListOffsets][info.ApiVersion]
+	body, err := DecodeSchema(request[headerLength:], schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := func(string) bool { return true }
+	return splitRequest(request[:headerLength], body, schema, topicsKeyName, "partitions", all, leader)
+}
+
+// DecodeListOffsetsResponse returns the partitions by the topic of the ListOffsets response (without the size and the correlation id) up to v5
+func DecodeListOffsetsResponse(apiVersion int16, response []byte) (map[string]map[int32]ListOffsetsPartition, error) {
+	if apiVersion > maxCanaryListOffsetsVersion {
+		return nil, fmt.Errorf("Unsupported response schema version %d for key %d ", apiVersion, apiKeyListOffsets)
+	}
+	schema, err := getResponseSchema(apiKeyListOffsets, apiVersion, topicPrefixResponseSchemaVersions[apiKeyListOffsets])
+	if err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]map[int32]ListOffsetsPartition)
+	for _, t := range body.Get("responses").([]interface{}) {
+		topic := t.(*Struct)
+		name := topic.Get(topicKeyName).(string)
+		if topics[name] == nil {
+			topics[name] = make(map[int32]ListOffsetsPartition)
+		}
+		for _, p := range topic.Get("partition_responses").([]interface{}) {
+			s := p.(*Struct)
+			partition := ListOffsetsPartition{ErrorCode: s.Get(errorCodeKeyName).(int16), Offset: -1}
+			if offset, ok := s.Get("offset").(int64); ok {
+				partition.Offset = offset
+			} else if offsets, ok := s.Get("offsets").([]interface{}); ok && len(offsets) != 0 {
+				partition.Offset = offsets[0].(int64)
+			}
+			topics[name][s.Get("partition").(int32)] = partition
+		}
+	}
+	return topics, nil
+}
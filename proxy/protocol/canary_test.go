@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func appendTestInt64(buf []byte, v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return append(buf, b...)
+}
+
+// testListOffsetsRequestV1 encodes the ListOffsets v1 request (without the size) for the latest offsets of the partitions
+func testListOffsetsRequestV1(partitions []testProducePartition) []byte {
+	buf := []byte{0x00, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x09}
+	buf = appendTestString(buf, "c")
+	buf = appendTestInt32(buf, -1)
+	buf = appendTestInt32(buf, int32(len(partitions)))
+	for _, p := range partitions {
+		buf = appendTestString(buf, p.topic)
+		buf = appendTestInt32(buf, 1)
+		buf = appendTestInt32(buf, p.partition)
+		buf = appendTestInt64(buf, -1)
+	}
+	return buf
+}
+
+func TestCanaryMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(CanaryVersionSupported(apiKeyMetadata, 8))
+	a.False(CanaryVersionSupported(apiKeyMetadata, 9))
+	a.True(CanaryVersionSupported(apiKeyListOffsets, 5))
+	a.False(CanaryVersionSupported(apiKeyListOffsets, 6))
+	a.False(CanaryVersionSupported(apiKeyFetch, 4))
+
+	request := EncodeCanaryMetadataRequest(7, "kafka-proxy", []string{"t1", "t2"})
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(int16(apiKeyMetadata), info.ApiKey)
+	a.Equal(int16(CanaryMetadataVersion), info.ApiVersion)
+	a.Equal([]string{"t1", "t2"}, info.Topics)
+	a.False(info.TopicsUnknown)
+	// the topics are not created
+	a.Equal(byte(0), request[len(request)-1])
+
+	info, err = DecodeRequestInfo(EncodeCanaryMetadataRequest(8, "kafka-proxy", nil))
+	a.Nil(err)
+	a.True(info.TopicsUnknown)
+
+	// v4 adds the throttle time and the cluster id to v1
+	brokers := []testBroker{{nodeID: 1, host: "canary-1"}, {nodeID: 2, host: "canary-2"}}
+	responseV1 := testMetadataResponseV1(brokers, 1, []testTopic{{name: "t1", leader: 1}, {name: "t2", leader: -1}})
+	brokersLength := 4 + 2*(4+2+len("canary-1")+4+2)
+	response := append([]byte{0x00, 0x00, 0x00, 0x00}, responseV1[:brokersLength]...)
+	response = append(response, 0xff, 0xff)
+	response = append(response, responseV1[brokersLength:]...)
+	metadata, err := DecodeMetadataLeaders(CanaryMetadataVersion, response)
+	a.Nil(err)
+	leaders := metadata.PartitionLeaders()
+	address, ok := leaders.Leader("t1", 0)
+	a.True(ok)
+	a.Equal("canary-1:9092", address)
+	_, ok = leaders.Leader("t2", 0)
+	a.False(ok)
+}
+
+func TestSplitListOffsetsRequest(t *testing.T) {
+	a := assert.New(t)
+
+	leaders := map[testProducePartition]string{
+		{topic: "t1", partition: 0}: "canary-1:9092",
+		{topic: "t1", partition: 1}: "canary-2:9092",
+		{topic: "t2", partition: 0}: "canary-1:9092",
+	}
+	leader := func(topic string, partition int32) (string, bool) {
+		address, ok := leaders[testProducePartition{topic: topic, partition: partition}]
+		return address, ok
+	}
+
+	requests, unknown, err := SplitListOffsetsRequest(testListOffsetsRequestV1([]testProducePartition{{"t1", 0}, {"t1", 1}, {"t2", 0}, {"t3", 0}}), leader)
+	a.Nil(err)
+	a.Equal([]string{"t3"}, unknown)
+	a.Len(requests, 2)
+	a.Equal(testListOffsetsRequestV1([]testProducePartition{{"t1", 0}, {"t2", 0}}), requests["canary-1:9092"])
+	a.Equal(testListOffsetsRequestV1([]testProducePartition{{"t1", 1}}), requests["canary-2:9092"])
+
+	_, _, err = SplitListOffsetsRequest(EncodeCanaryMetadataRequest(7, "kafka-proxy", nil), leader)
+	a.EqualError(err, "list offsets request up to v5 expected, got api key 3 version 4")
+}
+
+func TestDecodeListOffsetsResponse(t *testing.T) {
+	a := assert.New(t)
+
+	response := appendTestInt32(nil, 1)
+	response = appendTestString(response, "t1")
+	response = appendTestInt32(response, 2)
+	// partition 0: no error, timestamp, offset
+	response = appendTestInt32(response, 0)
+	response = append(response, 0x00, 0x00)
+	response = appendTestInt64(response, -1)
+	response = appendTestInt64(response, 42)
+	// partition 1: NOT_LEADER_OR_FOLLOWER
+	response = appendTestInt32(response, 1)
+	response = append(response, 0x00, 0x06)
+	response = appendTestInt64(response, -1)
+	response = appendTestInt64(response, -1)
+	partitions, err := DecodeListOffsetsResponse(1, response)
+	a.Nil(err)
+	a.Equal(map[string]map[int32]ListOffsetsPartition{"t1": {0: {ErrorCode: 0, Offset: 42}, 1: {ErrorCode: 6, Offset: -1}}}, partitions)
+
+	// v0 returns the list of the offsets
+	response = appendTestInt32(nil, 1)
+	response = appendTestString(response, "t1")
+	response = appendTestInt32(response, 1)
+	response = appendTestInt32(response, 0)
+	response = append(response, 0x00, 0x00)
+	response = appendTestInt32(response, 2)
+	response = appendTestInt64(response, 42)
+	response = appendTestInt64(response, 0)
+	partitions, err = DecodeListOffsetsResponse(0, response)
+	a.Nil(err)
+	a.Equal(map[string]map[int32]ListOffsetsPartition{"t1": {0: {ErrorCode: 0, Offset: 42}}}, partitions)
+
+	_, err = DecodeListOffsetsResponse(6, response)
+	a.NotNil(err)
+}
@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+const (
+	apiKeyGetTelemetrySubscriptions = 71
+	apiKeyPushTelemetry             = 72
+)
+
+// ClientTelemetryVersions are the versions of the client telemetry requests (KIP-714) which can be answered by the proxy
+var ClientTelemetryVersions = map[int16]int16{
+	apiKeyGetTelemetrySubscriptions: 0,
+	apiKeyPushTelemetry:             0,
+}
+
+var (
+	getTelemetrySubscriptionsRequestV0 = NewSchema("get_telemetry_subscriptions_request_v0",
+		&field{name: "client_instance_id", ty: typeUuid},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	getTelemetrySubscriptionsResponseV0 = NewSchema("get_telemetry_subscriptions_response_v0",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "client_instance_id", ty: typeUuid},
+		&field{name: "subscription_id", ty: typeInt32},
+		&compactArray{name: "accepted_compression_types", ty: typeInt8},
+		&field{name: "push_interval_ms", ty: typeInt32},
+		&field{name: "telemetry_max_bytes", ty: typeInt32},
+		&field{name: "delta_temporality", ty: typeBool},
+		&compactArray{name: "requested_metrics", ty: typeCompactStr},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	pushTelemetryRequestV0 = NewSchema("push_telemetry_request_v0",
+		&field{name: "client_instance_id", ty: typeUuid},
+		&field{name: "subscription_id", ty: typeInt32},
+		&field{name: "terminating", ty: typeBool},
+		&field{name: "compression_type", ty: typeInt8},
+		&field{name: "metrics", ty: typeCompactBytes},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	pushTelemetryResponseV0 = NewSchema("push_telemetry_response_v0",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+)
+
+// TelemetrySubscription is the client metrics subscription of the GetTelemetrySubscriptions response
+type TelemetrySubscription struct {
+	ClientInstanceID  []byte
+	SubscriptionID    int32
+	PushIntervalMs    int32
+	TelemetryMaxBytes int32
+	// the metric name prefixes, the empty prefix requests all metrics
+	RequestedMetrics []string
+}
+
+// TelemetryPush is the PushTelemetry request, the metrics are the serialized OTLP MetricsData
+type TelemetryPush struct {
+	ClientID         string
+	ClientInstanceID []byte
+	SubscriptionID   int32
+	Terminating      bool
+	CompressionType  int8
+	Metrics          []byte
+}
+
+// decodeFlexibleRequest decodes the body of the request with the request header v2 (without the size field)
+func decodeFlexibleRequest(request []byte, apiKey int16, schema Schema) (*RequestInfo, *Struct, error) {
+	info, headerLength, err := decodeRequestHeader(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.ApiKey != apiKey || info.ApiVersion != 0 {
+		return nil, nil, fmt.Errorf("api key %d version 0 expected, got api key %d version %d", apiKey, info.ApiKey, info.ApiVersion)
+	}
+	if headerLength >= len(request) {
+		return nil, nil, PacketDecodingError{Info: "request header tagged fields are missing"}
+	}
+	if headerLength, err = skipTaggedFields(request, headerLength); err != nil {
+		return nil, nil, err
+	}
+	body, err := DecodeSchema(request[headerLength:], schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, body, nil
+}
+
+// DecodeGetTelemetrySubscriptionsRequest returns the client instance id of the GetTelemetrySubscriptions request (without the size field),
+// the zero id asks for a new client instance id
+func DecodeGetTelemetrySubscriptionsRequest(request []byte) ([]byte, error) {
+	_, body, err := decodeFlexibleRequest(request, apiKeyGetTelemetrySubscriptions, getTelemetrySubscriptionsRequestV0)
+	if err != nil {
+		return nil, err
+	}
+	return body.Get("client_instance_id").([]byte), nil
+}
+
+// EncodeGetTelemetrySubscriptionsResponse encodes the response (without the size and the correlation id), the metrics are pushed uncompressed
+// with the cumulative temporality
+func EncodeGetTelemetrySubscriptionsResponse(subscription TelemetrySubscription) ([]byte, error) {
+	requestedMetrics := make([]interface{}, 0, len(subscription.RequestedMetrics))
+	for _, prefix := range subscription.RequestedMetrics {
+		requestedMetrics = append(requestedMetrics, prefix)
+	}
+	return EncodeSchema(&Struct{schema: getTelemetrySubscriptionsResponseV0.(*schema), values: []interface{}{
+		[]TaggedField{},
+		int32(0),
+		int16(ErrNoError),
+		subscription.ClientInstanceID,
+		subscription.SubscriptionID,
+		[]interface{}{},
+		subscription.PushIntervalMs,
+		subscription.TelemetryMaxBytes,
+		false,
+		requestedMetrics,
+		[]TaggedField{},
+	}}, getTelemetrySubscriptionsResponseV0)
+}
+
+// DecodePushTelemetryRequest decodes the PushTelemetry request (without the size field)
+func DecodePushTelemetryRequest(request []byte) (*TelemetryPush, error) {
+	info, body, err := decodeFlexibleRequest(request, apiKeyPushTelemetry, pushTelemetryRequestV0)
+	if err != nil {
+		return nil, err
+	}
+	return &TelemetryPush{
+		ClientID:         info.ClientID,
+		ClientInstanceID: body.Get("client_instance_id").([]byte),
+		SubscriptionID:   body.Get("subscription_id").(int32),
+		Terminating:      body.Get("terminating").(bool),
+		CompressionType:  body.Get("compression_type").(int8),
+		Metrics:          body.Get("metrics").([]byte),
+	}, nil
+}
+
+// EncodePushTelemetryResponse encodes the response (without the size and the correlation id)
+func EncodePushTelemetryResponse(errorCode int16) ([]byte, error) {
+	return EncodeSchema(&Struct{schema: pushTelemetryResponseV0.(*schema), values: []interface{}{
+		[]TaggedField{},
+		int32(0),
+		errorCode,
+		[]TaggedField{},
+	}}, pushTelemetryResponseV0)
+}
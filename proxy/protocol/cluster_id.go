@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"errors"
+)
+
+// clusterIDMetadataVersion is the version of the Metadata requests of the cluster id verification,
+// the oldest version supported by the brokers since Kafka 4.0
+const clusterIDMetadataVersion = 4
+
+// EncodeClusterIDRequest returns the Metadata request (without the size) for the cluster id, no topics are requested
+func EncodeClusterIDRequest(correlationID int32, clientID string) []byte {
+	request := make([]byte, 0, 2+2+4+2+len(clientID)+4+1)
+	request = appendInt16(request, apiKeyMetadata)
+	request = appendInt16(request, clusterIDMetadataVersion)
+	request = appendInt32(request, correlationID)
+	request = appendString(request, clientID)
+	// the empty topics array, allow_auto_topic_creation false
+	request = appendInt32(request, 0)
+	return append(request, 0)
+}
+
+// DecodeClusterIDResponse returns the cluster id of the response (without the size and the correlation id)
+// to the request of EncodeClusterIDRequest
+func DecodeClusterIDResponse(response []byte) (string, error) {
+	body, err := DecodeSchema(response, metadataResponseSchemaVersions[clusterIDMetadataVersion])
+	if err != nil {
+		return "", err
+	}
+	clusterID, ok := body.Get("cluster_id").(*string)
+	if !ok || clusterID == nil {
+		return "", errors.New("broker returned no cluster id")
+	}
+	return *clusterID, nil
+}
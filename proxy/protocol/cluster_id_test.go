@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testClusterIDResponse(clusterID *string) []byte {
+	// throttle_time_ms, no brokers
+	buf := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if clusterID == nil {
+		buf = append(buf, 0xff, 0xff)
+	} else {
+		buf = appendTestString(buf, *clusterID)
+	}
+	// controller_id, no topics
+	buf = appendTestInt32(buf, 1)
+	return appendTestInt32(buf, 0)
+}
+
+func TestClusterID(t *testing.T) {
+	a := assert.New(t)
+
+	request := EncodeClusterIDRequest(3, "kafka-proxy")
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(int16(apiKeyMetadata), info.ApiKey)
+	a.Equal(int16(4), info.ApiVersion)
+	a.Equal(int32(3), info.CorrelationID)
+	a.Equal("kafka-proxy", info.ClientID)
+	a.Empty(info.Topics)
+	// no topics are created
+	a.Equal(byte(0), request[len(request)-1])
+
+	clusterID := "MkU3OEVBNTcwNTJENDM2Qg"
+	id, err := DecodeClusterIDResponse(testClusterIDResponse(&clusterID))
+	a.Nil(err)
+	a.Equal(clusterID, id)
+
+	_, err = DecodeClusterIDResponse(testClusterIDResponse(nil))
+	a.EqualError(err, "broker returned no cluster id")
+	_, err = DecodeClusterIDResponse([]byte{0x00, 0x00})
+	a.NotNil(err)
+}
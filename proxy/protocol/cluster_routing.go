@@ -0,0 +1,221 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	// ClusterNodeIDs is the number of node ids reserved for every upstream cluster, the node ids of the brokers must be lower
+	ClusterNodeIDs = 1000000
+
+	nodeIDKeyName        = "node_id"
+	controllerIDKeyName  = "controller_id"
+	topicMetadataKeyName = "topic_metadata"
+)
+
+// node id fields of the partition metadata
+var partitionNodeIDKeyNames = []string{"leader", "replicas", "isr", "offline_replicas"}
+
+// ClusterMetadata is the Metadata response (without the size and the correlation id) of one of the upstream clusters
+type ClusterMetadata struct {
+	Response     []byte
+	NodeIDOffset int32
+	// Topic selects the topics of the response which belong to the cluster
+	Topic func(topic string) bool
+}
+
+// FilterMetadataTopics keeps the selected topics in the Metadata request (without the size field). Requests for all topics are returned unchanged.
+// The result is false when the request lists topics and none of them was selected.
+func FilterMetadataTopics(request []byte, selected func(topic string) bool) ([]byte, bool, error) {
+	if len(request) < 4 {
+		return nil, false, PacketDecodingError{Info: "request is too short"}
+	}
+	apiKey, apiVersion := int16(binary.BigEndian.Uint16(request)), int16(binary.BigEndian.Uint16(request[2:]))
+	schemas := requestSchemaVersions[apiKeyMetadata]
+	if apiKey != apiKeyMetadata || apiVersion < 0 || int(apiVersion) >= len(schemas) {
+		return nil, false, fmt.Errorf("metadata request expected, got api key %d version %d", apiKey, apiVersion)
+	}
+	helper := realDecoder{raw: request}
+	if _, err := requestHeaderSchema.decode(&helper); err != nil {
+		return nil, false, err
+	}
+	schema := schemas[apiVersion]
+	body, err := DecodeSchema(request[helper.off:], schema)
+	if err != nil {
+		return nil, false, err
+	}
+	topics, ok := body.Get(topicsKeyName).([]interface{})
+	if !ok {
+		return nil, false, errors.New("metadata request topics not found")
+	}
+	if topics == nil || (apiVersion == 0 && len(topics) == 0) {
+		return request, true, nil
+	}
+	kept := make([]interface{}, 0, len(topics))
+	for _, topic := range topics {
+		if selected(topic.(string)) {
+			kept = append(kept, topic)
+		}
+	}
+	if len(kept) == len(topics) {
+		return request, true, nil
+	}
+	if err = body.Replace(topicsKeyName, kept); err != nil {
+		return nil, false, err
+	}
+	newBody, err := EncodeSchema(body, schema)
+	if err != nil {
+		return nil, false, err
+	}
+	result := make([]byte, 0, helper.off+len(newBody))
+	result = append(result, request[:helper.off]...)
+	return append(result, newBody...), len(kept) != 0, nil
+}
+
+// MergeMetadataResponses merges the Metadata responses of the upstream clusters. The result contains the brokers and the selected topics
+// of all clusters with the node ids shifted by the offsets of the clusters, the other fields are taken from the first response.
+// The addresses (host:port) of the brokers of every cluster are returned as well.
+func MergeMetadataResponses(apiVersion int16, clusters []ClusterMetadata) ([]byte, [][]string, error) {
+	schema, err := getResponseSchema(apiKeyMetadata, apiVersion, metadataResponseSchemaVersions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(clusters) == 0 {
+		return nil, nil, errors.New("no metadata responses to merge")
+	}
+	var merged *Struct
+	brokers := make([]interface{}, 0)
+	topics := make([]interface{}, 0)
+	addresses := make([][]string, 0, len(clusters))
+	for i, cluster := range clusters {
+		decoded, err := DecodeSchema(cluster.Response, schema)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 {
+			merged = decoded
+			if controllerID, ok := decoded.Get(controllerIDKeyName).(int32); ok {
+				if controllerID, err = shiftNodeID(controllerID, cluster.NodeIDOffset); err != nil {
+					return nil, nil, err
+				}
+				if err = decoded.Replace(controllerIDKeyName, controllerID); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		clusterAddresses := make([]string, 0)
+		for _, elem := range decoded.Get(brokersKeyName).([]interface{}) {
+			broker := elem.(*Struct)
+			if err = shiftNodeIDs(broker, []string{nodeIDKeyName}, cluster.NodeIDOffset); err != nil {
+				return nil, nil, err
+			}
+			clusterAddresses = append(clusterAddresses, net.JoinHostPort(broker.Get(hostKeyName).(string), fmt.Sprint(broker.Get(portKeyName).(int32))))
+			brokers = append(brokers, broker)
+		}
+		addresses = append(addresses, clusterAddresses)
+		for _, elem := range decoded.Get(topicMetadataKeyName).([]interface{}) {
+			topic := elem.(*Struct)
+			if !cluster.Topic(topic.Get(topicKeyName).(string)) {
+				continue
+			}
+			for _, partition := range topic.Get("partition_metadata").([]interface{}) {
+				if err = shiftNodeIDs(partition.(*Struct), partitionNodeIDKeyNames, cluster.NodeIDOffset); err != nil {
+					return nil, nil, err
+				}
+			}
+			topics = append(topics, topic)
+		}
+	}
+	if err = merged.Replace(brokersKeyName, brokers); err != nil {
+		return nil, nil, err
+	}
+	if err = merged.Replace(topicMetadataKeyName, topics); err != nil {
+		return nil, nil, err
+	}
+	result, err := EncodeSchema(merged, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, addresses, nil
+}
+
+// ShiftCoordinatorNodeID shifts the node ids of the coordinators in the FindCoordinator response by the offset of the cluster
+func ShiftCoordinatorNodeID(apiVersion int16, response []byte, offset int32) ([]byte, error) {
+	schema, err := getResponseSchema(apiKeyFindCoordinator, apiVersion, findCoordinatorResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	coordinators, err := findCoordinators(decoded)
+	if err != nil {
+		return nil, err
+	}
+	for _, coordinator := range coordinators {
+		if err = shiftNodeIDs(coordinator, []string{nodeIDKeyName}, offset); err != nil {
+			return nil, err
+		}
+	}
+	return EncodeSchema(decoded, schema)
+}
+
+// FindCoordinatorKey returns the group id or the transactional id of the FindCoordinator request (without the size field)
+func FindCoordinatorKey(request []byte) (string, error) {
+	helper := realDecoder{raw: request}
+	v, err := requestHeaderSchema.decode(&helper)
+	if err != nil {
+		return "", err
+	}
+	header := v.(*Struct)
+	apiKey, apiVersion := header.Get("api_key").(int16), header.Get("api_version").(int16)
+	schemas := groupRequestSchemaVersions[apiKeyFindCoordinator]
+	if apiKey != apiKeyFindCoordinator || apiVersion < 0 || int(apiVersion) >= len(schemas) {
+		return "", fmt.Errorf("find coordinator request expected, got api key %d version %d", apiKey, apiVersion)
+	}
+	body, err := DecodeSchema(request[helper.off:], schemas[apiVersion])
+	if err != nil {
+		return "", err
+	}
+	return body.Get(findCoordinatorKeyName).(string), nil
+}
+
+// shiftNodeIDs shifts the node ids of the int32 fields and of the int32 arrays of the struct
+func shiftNodeIDs(s *Struct, names []string, offset int32) error {
+	for _, name := range names {
+		switch value := s.Get(name).(type) {
+		case int32:
+			nodeID, err := shiftNodeID(value, offset)
+			if err != nil {
+				return err
+			}
+			if err = s.Replace(name, nodeID); err != nil {
+				return err
+			}
+		case []interface{}:
+			for i, elem := range value {
+				nodeID, err := shiftNodeID(elem.(int32), offset)
+				if err != nil {
+					return err
+				}
+				value[i] = nodeID
+			}
+		}
+	}
+	return nil
+}
+
+// shiftNodeID shifts the node id, negative node ids (no node) are not changed
+func shiftNodeID(nodeID int32, offset int32) (int32, error) {
+	if nodeID < 0 {
+		return nodeID, nil
+	}
+	if nodeID >= ClusterNodeIDs {
+		return 0, fmt.Errorf("node id %d is too large, the node ids of the upstream clusters must be lower than %d", nodeID, ClusterNodeIDs)
+	}
+	return nodeID + offset, nil
+}
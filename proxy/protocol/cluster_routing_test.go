@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type testBroker struct {
+	nodeID int32
+	host   string
+}
+
+type testTopic struct {
+	name   string
+	leader int32
+}
+
+func appendTestString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+func appendTestInt32(buf []byte, v int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	return append(buf, b...)
+}
+
+// testMetadataResponseV1 encodes the Metadata v1 response with one partition per topic
+func testMetadataResponseV1(brokers []testBroker, controllerID int32, topics []testTopic) []byte {
+	buf := appendTestInt32(nil, int32(len(brokers)))
+	for _, broker := range brokers {
+		buf = appendTestInt32(buf, broker.nodeID)
+		buf = appendTestString(buf, broker.host)
+		buf = appendTestInt32(buf, 9092)
+		// rack
+		buf = append(buf, 0xff, 0xff)
+	}
+	buf = appendTestInt32(buf, controllerID)
+	buf = appendTestInt32(buf, int32(len(topics)))
+	for _, topic := range topics {
+		// error_code
+		buf = append(buf, 0x00, 0x00)
+		buf = appendTestString(buf, topic.name)
+		// is_internal, partition_metadata
+		buf = append(buf, 0x00, 0x00, 0x00, 0x00, 0x01)
+		// error_code, partition
+		buf = append(buf, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+		buf = appendTestInt32(buf, topic.leader)
+		// replicas, isr
+		for i := 0; i < 2; i++ {
+			buf = appendTestInt32(buf, 1)
+			buf = appendTestInt32(buf, topic.leader)
+		}
+	}
+	return buf
+}
+
+func testMetadataRequestV1(topics ...string) []byte {
+	request := []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c'}
+	if topics == nil {
+		return append(request, 0xff, 0xff, 0xff, 0xff)
+	}
+	request = appendTestInt32(request, int32(len(topics)))
+	for _, topic := range topics {
+		request = appendTestString(request, topic)
+	}
+	return request
+}
+
+func TestFilterMetadataTopics(t *testing.T) {
+	a := assert.New(t)
+
+	selected := func(topic string) bool { return strings.HasPrefix(topic, "new.") }
+
+	result, ok, err := FilterMetadataTopics(testMetadataRequestV1("old.t1", "new.t1", "new.t2"), selected)
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(testMetadataRequestV1("new.t1", "new.t2"), result)
+
+	result, ok, err = FilterMetadataTopics(testMetadataRequestV1("old.t1"), selected)
+	a.Nil(err)
+	a.False(ok)
+	a.Equal(testMetadataRequestV1([]string{}...), result)
+
+	// all topics
+	request := testMetadataRequestV1()
+	result, ok, err = FilterMetadataTopics(request, selected)
+	a.Nil(err)
+	a.True(ok)
+	a.Equal(request, result)
+
+	_, _, err = FilterMetadataTopics([]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c'}, selected)
+	a.EqualError(err, "metadata request expected, got api key 0 version 3")
+}
+
+func TestMergeMetadataResponses(t *testing.T) {
+	a := assert.New(t)
+
+	old := testMetadataResponseV1([]testBroker{{0, "old-0"}, {1, "old-1"}}, 1, []testTopic{{"old.t1", 0}, {"new.t1", 1}})
+	migrated := testMetadataResponseV1([]testBroker{{0, "new-0"}}, 0, []testTopic{{"new.t1", 0}, {"old.t1", -1}})
+
+	merged, addresses, err := MergeMetadataResponses(1, []ClusterMetadata{
+		{Response: old, NodeIDOffset: 0, Topic: func(topic string) bool { return strings.HasPrefix(topic, "old.") }},
+		{Response: migrated, NodeIDOffset: ClusterNodeIDs, Topic: func(topic string) bool { return strings.HasPrefix(topic, "new.") }},
+	})
+	a.Nil(err)
+	a.Equal(testMetadataResponseV1([]testBroker{{0, "old-0"}, {1, "old-1"}, {ClusterNodeIDs, "new-0"}}, 1, []testTopic{{"old.t1", 0}, {"new.t1", ClusterNodeIDs}}), merged)
+	a.Equal([][]string{{"old-0:9092", "old-1:9092"}, {"new-0:9092"}}, addresses)
+
+	// the controller of the first cluster is shifted as well
+	merged, _, err = MergeMetadataResponses(1, []ClusterMetadata{{Response: migrated, NodeIDOffset: 2 * ClusterNodeIDs, Topic: func(string) bool { return true }}})
+	a.Nil(err)
+	a.Equal(testMetadataResponseV1([]testBroker{{2 * ClusterNodeIDs, "new-0"}}, 2*ClusterNodeIDs, []testTopic{{"new.t1", 2 * ClusterNodeIDs}, {"old.t1", -1}}), merged)
+
+	_, _, err = MergeMetadataResponses(1, []ClusterMetadata{{Response: testMetadataResponseV1([]testBroker{{ClusterNodeIDs, "old-0"}}, -1, nil), Topic: func(string) bool { return true }}})
+	a.EqualError(err, "node id 1000000 is too large, the node ids of the upstream clusters must be lower than 1000000")
+}
+
+func TestShiftCoordinatorNodeID(t *testing.T) {
+	a := assert.New(t)
+
+	response := func(nodeID int32) []byte {
+		// error_code
+		buf := []byte{0x00, 0x00}
+		buf = appendTestInt32(buf, nodeID)
+		buf = appendTestString(buf, "h")
+		return appendTestInt32(buf, 9092)
+	}
+	result, err := ShiftCoordinatorNodeID(0, response(2), ClusterNodeIDs)
+	a.Nil(err)
+	a.Equal(response(ClusterNodeIDs+2), result)
+
+	result, err = ShiftCoordinatorNodeID(0, response(-1), ClusterNodeIDs)
+	a.Nil(err)
+	a.Equal(response(-1), result)
+}
+
+func TestFindCoordinatorKey(t *testing.T) {
+	a := assert.New(t)
+
+	// FindCoordinator v1
+	request := []byte{0x00, 0x0a, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0x00, 0x02, 'g', '1', 0x00}
+	key, err := FindCoordinatorKey(request)
+	a.Nil(err)
+	a.Equal("g1", key)
+
+	_, err = FindCoordinatorKey(testMetadataRequestV1())
+	a.EqualError(err, "find coordinator request expected, got api key 3 version 1")
+}
@@ -0,0 +1,160 @@
+package protocol
+
+// CreateDelegationTokenRequestV1 creates a delegation token of the authenticated principal without renewers
+type CreateDelegationTokenRequestV1 struct {
+	MaxLifetimeMs int64 // -1 is the broker default
+}
+
+func (r *CreateDelegationTokenRequestV1) encode(pe packetEncoder) error {
+	// renewers
+	if err := pe.putArrayLength(0); err != nil {
+		return err
+	}
+	pe.putInt64(r.MaxLifetimeMs)
+	return nil
+}
+
+func (r *CreateDelegationTokenRequestV1) decode(pd packetDecoder) (err error) {
+	n, err := pd.getArrayLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err = pd.getString(); err != nil {
+			return err
+		}
+		if _, err = pd.getString(); err != nil {
+			return err
+		}
+	}
+	r.MaxLifetimeMs, err = pd.getInt64()
+	return err
+}
+
+func (r *CreateDelegationTokenRequestV1) key() int16 {
+	return 38
+}
+
+func (r *CreateDelegationTokenRequestV1) version() int16 {
+	return 1
+}
+
+type CreateDelegationTokenResponseV1 struct {
+	Err               KError
+	PrincipalType     string
+	PrincipalName     string
+	IssueTimestampMs  int64
+	ExpiryTimestampMs int64
+	MaxTimestampMs    int64
+	TokenID           string
+	HMAC              []byte
+	ThrottleTimeMs    int32
+}
+
+func (r *CreateDelegationTokenResponseV1) encode(pe packetEncoder) error {
+	pe.putInt16(int16(r.Err))
+	if err := pe.putString(r.PrincipalType); err != nil {
+		return err
+	}
+	if err := pe.putString(r.PrincipalName); err != nil {
+		return err
+	}
+	pe.putInt64(r.IssueTimestampMs)
+	pe.putInt64(r.ExpiryTimestampMs)
+	pe.putInt64(r.MaxTimestampMs)
+	if err := pe.putString(r.TokenID); err != nil {
+		return err
+	}
+	if err := pe.putBytes(r.HMAC); err != nil {
+		return err
+	}
+	pe.putInt32(r.ThrottleTimeMs)
+	return nil
+}
+
+func (r *CreateDelegationTokenResponseV1) decode(pd packetDecoder) (err error) {
+	kerr, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	r.Err = KError(kerr)
+	if r.PrincipalType, err = pd.getString(); err != nil {
+		return err
+	}
+	if r.PrincipalName, err = pd.getString(); err != nil {
+		return err
+	}
+	if r.IssueTimestampMs, err = pd.getInt64(); err != nil {
+		return err
+	}
+	if r.ExpiryTimestampMs, err = pd.getInt64(); err != nil {
+		return err
+	}
+	if r.MaxTimestampMs, err = pd.getInt64(); err != nil {
+		return err
+	}
+	if r.TokenID, err = pd.getString(); err != nil {
+		return err
+	}
+	if r.HMAC, err = pd.getBytes(); err != nil {
+		return err
+	}
+	r.ThrottleTimeMs, err = pd.getInt32()
+	return err
+}
+
+// RenewDelegationTokenRequestV1 extends the expiry time of the delegation token
+type RenewDelegationTokenRequestV1 struct {
+	HMAC          []byte
+	RenewPeriodMs int64 // -1 is the broker default
+}
+
+func (r *RenewDelegationTokenRequestV1) encode(pe packetEncoder) error {
+	if err := pe.putBytes(r.HMAC); err != nil {
+		return err
+	}
+	pe.putInt64(r.RenewPeriodMs)
+	return nil
+}
+
+func (r *RenewDelegationTokenRequestV1) decode(pd packetDecoder) (err error) {
+	if r.HMAC, err = pd.getBytes(); err != nil {
+		return err
+	}
+	r.RenewPeriodMs, err = pd.getInt64()
+	return err
+}
+
+func (r *RenewDelegationTokenRequestV1) key() int16 {
+	return 39
+}
+
+func (r *RenewDelegationTokenRequestV1) version() int16 {
+	return 1
+}
+
+type RenewDelegationTokenResponseV1 struct {
+	Err               KError
+	ExpiryTimestampMs int64
+	ThrottleTimeMs    int32
+}
+
+func (r *RenewDelegationTokenResponseV1) encode(pe packetEncoder) error {
+	pe.putInt16(int16(r.Err))
+	pe.putInt64(r.ExpiryTimestampMs)
+	pe.putInt32(r.ThrottleTimeMs)
+	return nil
+}
+
+func (r *RenewDelegationTokenResponseV1) decode(pd packetDecoder) (err error) {
+	kerr, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	r.Err = KError(kerr)
+	if r.ExpiryTimestampMs, err = pd.getInt64(); err != nil {
+		return err
+	}
+	r.ThrottleTimeMs, err = pd.getInt32()
+	return err
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDelegationTokenRequests(t *testing.T) {
+	a := assert.New(t)
+
+	buf, err := Encode(&Request{ClientID: "c", Body: &CreateDelegationTokenRequestV1{MaxLifetimeMs: 3600000}})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x26, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x36, 0xee, 0x80}, buf)
+	create := &Request{Body: &CreateDelegationTokenRequestV1{}}
+	a.Nil(Decode(buf, create))
+	a.Equal(int64(3600000), create.Body.(*CreateDelegationTokenRequestV1).MaxLifetimeMs)
+
+	buf, err = Encode(&Request{ClientID: "c", Body: &RenewDelegationTokenRequestV1{HMAC: []byte{0x01}, RenewPeriodMs: -1}})
+	a.Nil(err)
+	renew := &Request{Body: &RenewDelegationTokenRequestV1{}}
+	a.Nil(Decode(buf, renew))
+	a.Equal(&RenewDelegationTokenRequestV1{HMAC: []byte{0x01}, RenewPeriodMs: -1}, renew.Body)
+}
+
+func TestDelegationTokenResponses(t *testing.T) {
+	a := assert.New(t)
+
+	created := &CreateDelegationTokenResponseV1{Err: ErrNoError, PrincipalType: "User", PrincipalName: "alice", IssueTimestampMs: 1, ExpiryTimestampMs: 2, MaxTimestampMs: 3, TokenID: "token", HMAC: []byte("hmac"), ThrottleTimeMs: 4}
+	buf, err := Encode(created)
+	a.Nil(err)
+	decodedCreated := &CreateDelegationTokenResponseV1{}
+	a.Nil(Decode(buf, decodedCreated))
+	a.Equal(created, decodedCreated)
+
+	renewed := &RenewDelegationTokenResponseV1{Err: ErrDelegationTokenExpired, ExpiryTimestampMs: 2}
+	buf, err = Encode(renewed)
+	a.Nil(err)
+	decodedRenewed := &RenewDelegationTokenResponseV1{}
+	a.Nil(Decode(buf, decodedRenewed))
+	a.Equal(renewed, decodedRenewed)
+}
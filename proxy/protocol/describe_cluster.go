@@ -0,0 +1,167 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+)
+
+const (
+	apiKeyDescribeCluster = 60
+
+	// DescribeCluster has only flexible versions, v1 adds the endpoint type (brokers or controllers), v2 the fenced brokers
+	maxDescribeClusterVersion = 2
+)
+
+// describeClusterModifier maps the addresses of the brokers or the KRaft controllers of the DescribeCluster response.
+// The flexible response is not supported by the schemas, so it is walked field by field and only the hosts and ports are encoded again.
+type describeClusterModifier struct {
+	apiVersion            int16
+	netAddressMappingFunc config.NetAddressMappingFunc
+	advertisedHostFunc    AdvertisedHostFunc
+}
+
+func newDescribeClusterModifier(apiVersion int16, netAddressMappingFunc config.NetAddressMappingFunc, advertisedHostFunc AdvertisedHostFunc) (ResponseModifier, error) {
+	if apiVersion < 0 || apiVersion > maxDescribeClusterVersion {
+		return nil, fmt.Errorf("Unsupported response schema version %d for key %d ", apiVersion, apiKeyDescribeCluster)
+	}
+	return &describeClusterModifier{apiVersion: apiVersion, netAddressMappingFunc: netAddressMappingFunc, advertisedHostFunc: advertisedHostFunc}, nil
+}
+
+func (m *describeClusterModifier) Apply(resp []byte) ([]byte, error) {
+	if m.netAddressMappingFunc == nil {
+		return nil, fmt.Errorf("net address mapper must not be nil")
+	}
+	// DescribeCluster Response (Version: 2) => throttle_time_ms error_code error_message endpoint_type cluster_id controller_id [brokers] cluster_authorized_operations TAG_BUFFER
+	//   throttle_time_ms => INT32
+	//   error_code => INT16
+	//   error_message => COMPACT_NULLABLE_STRING
+	//   endpoint_type => INT8 (v1+)
+	//   cluster_id => COMPACT_STRING
+	//   controller_id => INT32
+	//   brokers => broker_id host port rack is_fenced TAG_BUFFER
+	//     broker_id => INT32
+	//     host => COMPACT_STRING
+	//     port => INT32
+	//     rack => COMPACT_NULLABLE_STRING
+	//     is_fenced => BOOLEAN (v2+)
+	//   cluster_authorized_operations => INT32
+	//
+	// The response header v1 starts with the tagged fields.
+	offset, err := skipFlexibleTaggedFields(resp, 0)
+	if err != nil {
+		return nil, err
+	}
+	// throttle_time_ms, error_code
+	if offset, err = skipFlexibleBytes(resp, offset, 6); err != nil {
+		return nil, err
+	}
+	if _, offset, err = readCompactString(resp, offset); err != nil {
+		return nil, err
+	}
+	if m.apiVersion >= 1 {
+		if offset, err = skipFlexibleBytes(resp, offset, 1); err != nil {
+			return nil, err
+		}
+	}
+	if _, offset, err = readCompactString(resp, offset); err != nil {
+		return nil, err
+	}
+	if offset, err = skipFlexibleBytes(resp, offset, 4); err != nil {
+		return nil, err
+	}
+	length, size := binary.Uvarint(resp[offset:])
+	if size <= 0 || length > uint64(len(resp)) {
+		return nil, PacketDecodingError{Info: "invalid brokers array length"}
+	}
+	offset += size
+
+	result := make([]byte, offset, len(resp)+64)
+	copy(result, resp[:offset])
+	for i := 1; i < int(length); i++ {
+		// broker_id
+		if offset+4 > len(resp) {
+			return nil, PacketDecodingError{Info: "describe cluster response is too short"}
+		}
+		brokerID := int32(binary.BigEndian.Uint32(resp[offset:]))
+		result = append(result, resp[offset:offset+4]...)
+		offset += 4
+
+		var host string
+		if host, offset, err = readCompactString(resp, offset); err != nil {
+			return nil, err
+		}
+		if offset+4 > len(resp) {
+			return nil, PacketDecodingError{Info: "describe cluster response is too short"}
+		}
+		port := int32(binary.BigEndian.Uint32(resp[offset:]))
+		offset += 4
+		newHost, newPort := host, port
+		if host != "" || port > 0 {
+			if newHost, newPort, err = m.netAddressMappingFunc(host, port); err != nil {
+				return nil, err
+			}
+			if m.advertisedHostFunc != nil {
+				if newHost, err = m.advertisedHostFunc(brokerID); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result = appendCompactString(result, newHost)
+		result = append(result, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(result[len(result)-4:], uint32(newPort))
+
+		// rack, is_fenced and the tagged fields are copied
+		start := offset
+		if _, offset, err = readCompactString(resp, offset); err != nil {
+			return nil, err
+		}
+		if m.apiVersion >= 2 {
+			if offset, err = skipFlexibleBytes(resp, offset, 1); err != nil {
+				return nil, err
+			}
+		}
+		if offset, err = skipFlexibleTaggedFields(resp, offset); err != nil {
+			return nil, err
+		}
+		result = append(result, resp[start:offset]...)
+	}
+	return append(result, resp[offset:]...), nil
+}
+
+// readCompactString reads the compact (nullable) string, the null string is returned as empty
+func readCompactString(buf []byte, offset int) (string, int, error) {
+	if offset >= len(buf) {
+		return "", 0, PacketDecodingError{Info: "compact string is missing"}
+	}
+	length, size := binary.Uvarint(buf[offset:])
+	if size <= 0 || length > uint64(len(buf)-offset-size)+1 {
+		return "", 0, PacketDecodingError{Info: "invalid compact string length"}
+	}
+	offset += size
+	if length == 0 {
+		return "", offset, nil
+	}
+	end := offset + int(length) - 1
+	return string(buf[offset:end]), end, nil
+}
+
+func appendCompactString(buf []byte, value string) []byte {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(value))+1)
+	return append(append(buf, length[:n]...), value...)
+}
+
+func skipFlexibleBytes(buf []byte, offset int, n int) (int, error) {
+	if offset+n > len(buf) {
+		return 0, PacketDecodingError{Info: "response is too short"}
+	}
+	return offset + n, nil
+}
+
+func skipFlexibleTaggedFields(buf []byte, offset int) (int, error) {
+	if offset >= len(buf) {
+		return 0, PacketDecodingError{Info: "tagged fields are missing"}
+	}
+	return skipTaggedFields(buf, offset)
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDescribeClusterResponseV0(t *testing.T) {
+	a := assert.New(t)
+
+	bytes := []byte{
+		0x00,                   // header tagged fields
+		0x00, 0x00, 0x00, 0x00, // throttle_time_ms
+		0x00, 0x00, // error_code
+		0x00,                // error_message null
+		0x04, 'c', 'i', 'd', // cluster_id
+		0x00, 0x00, 0x00, 0x01, // controller_id
+		0x03, // 2 brokers
+		0x00, 0x00, 0x00, 0x01,
+		0x0a, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't',
+		0x00, 0x00, 0x00, 0x33, // 51
+		0x00, // rack null
+		0x00, // tagged fields
+		0x00, 0x00, 0x00, 0x02,
+		0x0b, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm',
+		0x00, 0x00, 0x01, 0x11, // 273
+		0x03, 'r', '1', // rack
+		0x00,
+		0x80, 0x00, 0x00, 0x00, // cluster_authorized_operations
+		0x00,
+	}
+	modifier, err := GetResponseModifier(apiKeyDescribeCluster, 0, testResponseModifier, nil)
+	a.Nil(err)
+	resp, err := modifier.Apply(bytes)
+	a.Nil(err)
+	a.Equal([]byte{
+		0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00,
+		0x00,
+		0x04, 'c', 'i', 'd',
+		0x00, 0x00, 0x00, 0x01,
+		0x03,
+		0x00, 0x00, 0x00, 0x01,
+		0x08, 'm', 'y', 'h', 'o', 's', 't', '1',
+		0x00, 0x00, 0x84, 0xd1, // 34001
+		0x00,
+		0x00,
+		0x00, 0x00, 0x00, 0x02,
+		0x08, 'm', 'y', 'h', 'o', 's', 't', '2',
+		0x00, 0x00, 0x84, 0xd2, // 34002
+		0x03, 'r', '1',
+		0x00,
+		0x80, 0x00, 0x00, 0x00,
+		0x00,
+	}, resp)
+
+	_, err = modifier.Apply(bytes[:30])
+	a.NotNil(err)
+}
+
+func TestDescribeClusterResponseV2(t *testing.T) {
+	a := assert.New(t)
+
+	// the KRaft controllers are described
+	bytes := []byte{
+		0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00,
+		0x00,
+		0x02, // endpoint_type controllers
+		0x04, 'c', 'i', 'd',
+		0xff, 0xff, 0xff, 0xff,
+		0x02,
+		0x00, 0x00, 0x0b, 0xb9,
+		0x0a, 'k', 'a', 'f', 'k', 'a', '.', 'o', 'r', 'g',
+		0x00, 0x00, 0xd1, 0x01, // 53505
+		0x00,
+		0x00,                   // is_fenced
+		0x01, 0x00, 0x01, 0x07, // tagged field 0 with 1 byte
+		0x00, 0x00, 0x00, 0x00,
+		0x00,
+	}
+	// the controller port is not mapped
+	modifier, err := GetResponseModifier(apiKeyDescribeCluster, 2, testResponseModifier, nil)
+	a.Nil(err)
+	_, err = modifier.Apply(bytes)
+	a.EqualError(err, "unexpected data")
+
+	bytes[34], bytes[35] = 0xd0, 0xff // 53503
+	resp, err := modifier.Apply(bytes)
+	a.Nil(err)
+	a.Equal([]byte{
+		0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00,
+		0x00,
+		0x02,
+		0x04, 'c', 'i', 'd',
+		0xff, 0xff, 0xff, 0xff,
+		0x02,
+		0x00, 0x00, 0x0b, 0xb9,
+		0x08, 'm', 'y', 'h', 'o', 's', 't', '3',
+		0x00, 0x00, 0x84, 0xd3, // 34003
+		0x00,
+		0x00,
+		0x01, 0x00, 0x01, 0x07,
+		0x00, 0x00, 0x00, 0x00,
+		0x00,
+	}, resp)
+
+	_, err = GetResponseModifier(apiKeyDescribeCluster, 3, testResponseModifier, nil)
+	a.NotNil(err)
+}
@@ -0,0 +1,377 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+const noOffset int64 = -1
+
+type topicPartitions struct {
+	topic      string
+	partitions []int32
+}
+
+// errorResponse is the response to a request failing every referenced topic (or the whole request) with the error code.
+type errorResponse struct {
+	info      *RequestInfo
+	body      *Struct
+	errorCode int16
+}
+
+// EncodeErrorResponse returns the response body (without the size and the correlation id) to the request
+// (without the size) failing every topic or resource of the request with the error code. A nil response is returned
+// for a request the client does not expect a response to (Produce with acks 0). An error is returned when the response
+// to the request API or version cannot be encoded.
+func EncodeErrorResponse(request []byte, errorCode int16) ([]byte, error) {
+	info, body, err := decodeRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, fmt.Errorf("error response for api key %d version %d is not supported", info.ApiKey, info.ApiVersion)
+	}
+	if info.ApiKey == apiKeyProduce && body.Get("acks").(int16) == 0 {
+		return nil, nil
+	}
+	return Encode(&errorResponse{info: info, body: body, errorCode: errorCode})
+}
+
+// MaxErrorResponseVersion returns the highest version of the requests with the api key whose error responses are encoded by EncodeErrorResponse
+func MaxErrorResponseVersion(apiKey int16) (int16, bool) {
+	schemas, ok := requestSchemaVersions[apiKey]
+	if !ok {
+		return 0, false
+	}
+	return int16(len(schemas) - 1), true
+}
+
+func (r *errorResponse) encode(pe packetEncoder) error {
+	version := r.info.ApiVersion
+	switch r.info.ApiKey {
+	case apiKeyProduce:
+		return r.encodeProduce(pe, version)
+	case apiKeyFetch:
+		return r.encodeFetch(pe, version)
+	case apiKeyListOffsets:
+		return r.encodeListOffsets(pe, version)
+	case apiKeyMetadata:
+		return r.encodeMetadata(pe, version)
+	case apiKeyOffsetCommit:
+		if version >= 3 {
+			pe.putInt32(0) // throttle_time_ms
+		}
+		return r.encodePartitionErrors(pe, r.topicPartitions(topicsKeyName, "partitions"))
+	case apiKeyOffsetFetch:
+		return r.encodeOffsetFetch(pe, version)
+	case apiKeyCreateTopics:
+		if version >= 2 {
+			pe.putInt32(0) // throttle_time_ms
+		}
+		return r.encodeTopicErrors(pe, r.topicNames("create_topic_requests"), version >= 1)
+	case apiKeyDeleteTopics:
+		if version >= 1 {
+			pe.putInt32(0) // throttle_time_ms
+		}
+		return r.encodeTopicErrors(pe, r.topicNames(topicsKeyName), false)
+	case apiKeyDeleteRecords:
+		pe.putInt32(0) // throttle_time_ms
+		return r.encodePartitions(pe, r.topicPartitions(topicsKeyName, "partitions"), func(pe packetEncoder) {
+			pe.putInt64(noOffset) // low_watermark
+			pe.putInt16(r.errorCode)
+		})
+	case apiKeyOffsetForLeaderEpoch:
+		return r.encodeOffsetForLeaderEpoch(pe, version)
+	case apiKeyAddPartitionsToTxn, apiKeyTxnOffsetCommit:
+		pe.putInt32(0) // throttle_time_ms
+		return r.encodePartitionErrors(pe, r.topicPartitions(topicsKeyName, "partitions"))
+	case apiKeyDescribeConfigs, apiKeyAlterConfigs, apiKeyIncrementalAlterConfigs:
+		return r.encodeConfigs(pe)
+	case apiKeyCreatePartitions:
+		pe.putInt32(0) // throttle_time_ms
+		return r.encodeTopicErrors(pe, r.topicNames(topicPartitionsKeyName), true)
+	case apiKeyElectLeaders:
+		return r.encodeElectLeaders(pe, version)
+	}
+	return PacketEncodingError{fmt.Sprintf("error response for api key %d is not supported", r.info.ApiKey)}
+}
+
+func (r *errorResponse) encodeProduce(pe packetEncoder, version int16) error {
+	return r.encodeProduceTopics(pe, version, r.topicPartitions("topic_data", "data"))
+}
+
+func (r *errorResponse) encodeProduceTopics(pe packetEncoder, version int16, topics []topicPartitions) error {
+	err := r.encodePartitions(pe, topics, func(pe packetEncoder) {
+		pe.putInt16(r.errorCode)
+		pe.putInt64(noOffset) // base_offset
+		if version >= 2 {
+			pe.putInt64(noOffset) // log_append_time
+		}
+		if version >= 5 {
+			pe.putInt64(noOffset) // log_start_offset
+		}
+		if version >= 8 {
+			pe.putArrayLength(0)      // record_errors
+			pe.putNullableString(nil) // error_message
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if version >= 1 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	return nil
+}
+
+func (r *errorResponse) encodeFetch(pe packetEncoder, version int16) error {
+	if version >= 1 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	if version >= 7 {
+		pe.putInt16(0) // error_code
+		pe.putInt32(0) // session_id
+	}
+	return r.encodePartitions(pe, r.topicPartitions(topicsKeyName, "partitions"), func(pe packetEncoder) {
+		pe.putInt16(r.errorCode)
+		pe.putInt64(noOffset) // high_watermark
+		if version >= 4 {
+			pe.putInt64(noOffset) // last_stable_offset
+		}
+		if version >= 5 {
+			pe.putInt64(noOffset) // log_start_offset
+		}
+		if version >= 4 {
+			pe.putArrayLength(-1) // aborted_transactions
+		}
+		if version >= 11 {
+			pe.putInt32(-1) // preferred_read_replica
+		}
+		pe.putBytes([]byte{}) // record_set
+	})
+}
+
+func (r *errorResponse) encodeListOffsets(pe packetEncoder, version int16) error {
+	if version >= 2 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	return r.encodePartitions(pe, r.topicPartitions(topicsKeyName, "partitions"), func(pe packetEncoder) {
+		pe.putInt16(r.errorCode)
+		if version == 0 {
+			pe.putArrayLength(0) // offsets
+			return
+		}
+		pe.putInt64(noOffset) // timestamp
+		pe.putInt64(noOffset) // offset
+		if version >= 4 {
+			pe.putInt32(-1) // leader_epoch
+		}
+	})
+}
+
+func (r *errorResponse) encodeMetadata(pe packetEncoder, version int16) error {
+	if version >= 3 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	pe.putArrayLength(0) // brokers
+	if version >= 2 {
+		pe.putNullableString(nil) // cluster_id
+	}
+	if version >= 1 {
+		pe.putInt32(-1) // controller_id
+	}
+	// the request for all topics is answered with no topics
+	var topics []string
+	if !requestsAllTopics(r.info, r.body) {
+		topics = r.topicNames(topicsKeyName)
+	}
+	if err := pe.putArrayLength(len(topics)); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		pe.putInt16(r.errorCode)
+		if err := pe.putString(topic); err != nil {
+			return err
+		}
+		if version >= 1 {
+			pe.putBool(false) // is_internal
+		}
+		pe.putArrayLength(0) // partitions
+		if version >= 8 {
+			pe.putInt32(-2147483648) // topic_authorized_operations
+		}
+	}
+	if version >= 8 {
+		pe.putInt32(-2147483648) // cluster_authorized_operations
+	}
+	return nil
+}
+
+func (r *errorResponse) encodeOffsetFetch(pe packetEncoder, version int16) error {
+	if version >= 3 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	err := r.encodePartitions(pe, r.topicPartitions(topicsKeyName, "partitions"), func(pe packetEncoder) {
+		pe.putInt64(noOffset) // committed_offset
+		if version >= 5 {
+			pe.putInt32(-1) // committed_leader_epoch
+		}
+		pe.putString("") // metadata
+		pe.putInt16(r.errorCode)
+	})
+	if err != nil {
+		return err
+	}
+	if version >= 2 {
+		// the request for all topics fails as a whole
+		if requestsAllTopics(r.info, r.body) {
+			pe.putInt16(r.errorCode)
+		} else {
+			pe.putInt16(0)
+		}
+	}
+	return nil
+}
+
+func (r *errorResponse) encodeOffsetForLeaderEpoch(pe packetEncoder, version int16) error {
+	if version >= 2 {
+		pe.putInt32(0) // throttle_time_ms
+	}
+	topics := r.topicPartitions(topicsKeyName, "partitions")
+	if err := pe.putArrayLength(len(topics)); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if err := pe.putString(topic.topic); err != nil {
+			return err
+		}
+		if err := pe.putArrayLength(len(topic.partitions)); err != nil {
+			return err
+		}
+		for _, partition := range topic.partitions {
+			pe.putInt16(r.errorCode)
+			pe.putInt32(partition)
+			if version >= 1 {
+				pe.putInt32(-1) // leader_epoch
+			}
+			pe.putInt64(noOffset) // end_offset
+		}
+	}
+	return nil
+}
+
+func (r *errorResponse) encodeConfigs(pe packetEncoder) error {
+	pe.putInt32(0) // throttle_time_ms
+	resources, _ := r.body.Get("resources").([]interface{})
+	if err := pe.putArrayLength(len(resources)); err != nil {
+		return err
+	}
+	for _, elem := range resources {
+		resource := elem.(*Struct)
+		pe.putInt16(r.errorCode)
+		pe.putNullableString(nil) // error_message
+		pe.putInt8(resource.Get("resource_type").(int8))
+		if err := pe.putString(resource.Get("resource_name").(string)); err != nil {
+			return err
+		}
+		if r.info.ApiKey == apiKeyDescribeConfigs {
+			pe.putArrayLength(0) // config_entries
+		}
+	}
+	return nil
+}
+
+func (r *errorResponse) encodeElectLeaders(pe packetEncoder, version int16) error {
+	pe.putInt32(0) // throttle_time_ms
+	if version >= 1 {
+		// the request for all partitions fails as a whole
+		if requestsAllTopics(r.info, r.body) {
+			pe.putInt16(r.errorCode)
+		} else {
+			pe.putInt16(0)
+		}
+	}
+	return r.encodePartitions(pe, r.topicPartitions(topicPartitionsKeyName, "partition_id"), func(pe packetEncoder) {
+		pe.putInt16(r.errorCode)
+		pe.putNullableString(nil) // error_message
+	})
+}
+
+// encodeTopicErrors writes the array of topic name, error code and optionally the error message
+func (r *errorResponse) encodeTopicErrors(pe packetEncoder, topics []string, errorMessage bool) error {
+	if err := pe.putArrayLength(len(topics)); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if err := pe.putString(topic); err != nil {
+			return err
+		}
+		pe.putInt16(r.errorCode)
+		if errorMessage {
+			pe.putNullableString(nil)
+		}
+	}
+	return nil
+}
+
+// encodePartitionErrors writes the array of topic name and partition errors (partition, error code)
+func (r *errorResponse) encodePartitionErrors(pe packetEncoder, topics []topicPartitions) error {
+	return r.encodePartitions(pe, topics, func(pe packetEncoder) {
+		pe.putInt16(r.errorCode)
+	})
+}
+
+// encodePartitions writes the array of topic name and partitions, fields following the partition are written by partitionFields
+func (r *errorResponse) encodePartitions(pe packetEncoder, topics []topicPartitions, partitionFields func(pe packetEncoder)) error {
+	if err := pe.putArrayLength(len(topics)); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if err := pe.putString(topic.topic); err != nil {
+			return err
+		}
+		if err := pe.putArrayLength(len(topic.partitions)); err != nil {
+			return err
+		}
+		for _, partition := range topic.partitions {
+			pe.putInt32(partition)
+			partitionFields(pe)
+		}
+	}
+	return nil
+}
+
+// topicNames returns the topics of the request array with topic structs or topic names
+func (r *errorResponse) topicNames(arrayName string) []string {
+	elems, _ := r.body.Get(arrayName).([]interface{})
+	topics := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		switch e := elem.(type) {
+		case *Struct:
+			topics = append(topics, e.Get(topicKeyName).(string))
+		case string:
+			topics = append(topics, e)
+		}
+	}
+	return topics
+}
+
+// topicPartitions returns the topics with partitions of the request array, the partitions are partition ids or structs with the partition field
+func (r *errorResponse) topicPartitions(arrayName string, partitionsName string) []topicPartitions {
+	elems, _ := r.body.Get(arrayName).([]interface{})
+	topics := make([]topicPartitions, 0, len(elems))
+	for _, elem := range elems {
+		topic := elem.(*Struct)
+		partitionElems, _ := topic.Get(partitionsName).([]interface{})
+		partitions := make([]int32, 0, len(partitionElems))
+		for _, partitionElem := range partitionElems {
+			switch p := partitionElem.(type) {
+			case *Struct:
+				partitions = append(partitions, p.Get("partition").(int32))
+			case int32:
+				partitions = append(partitions, p)
+			}
+		}
+		topics = append(topics, topicPartitions{topic: topic.Get(topicKeyName).(string), partitions: partitions})
+	}
+	return topics
+}
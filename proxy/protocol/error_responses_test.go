@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEncodeErrorResponseProduceV3(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x07,
+		0x00, 0x02, 'c', '1',
+		// transactional_id null
+		0xff, 0xff,
+		// acks, timeout
+		0xff, 0xff, 0x00, 0x00, 0x75, 0x30,
+		// topic_data
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02, 0xff, 0xff, 0xff, 0xff,
+	}
+	response, err := EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Equal([]byte{
+		// responses
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		// partition, error_code, base_offset, log_append_time
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x1d,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		// throttle_time_ms
+		0x00, 0x00, 0x00, 0x00,
+	}, response)
+
+	// acks 0
+	request[14], request[15] = 0x00, 0x00
+	response, err = EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Nil(response)
+}
+
+func TestEncodeErrorResponseMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	for version := int16(0); version < int16(len(requestSchemaVersions[apiKeyMetadata])); version++ {
+		request := []byte{
+			0x00, 0x03, 0x00, byte(version),
+			0x00, 0x00, 0x00, 0x01,
+			0xff, 0xff,
+			0x00, 0x00, 0x00, 0x02,
+			0x00, 0x02, 't', '1',
+			0x00, 0x02, 't', '2',
+		}
+		if version >= 4 {
+			// allow_auto_topic_creation
+			request = append(request, 0x01)
+		}
+		if version >= 8 {
+			// include_cluster_authorized_operations, include_topic_authorized_operations
+			request = append(request, 0x00, 0x00)
+		}
+		response, err := EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+		a.Nil(err)
+
+		s, err := DecodeSchema(response, metadataResponseSchemaVersions[version])
+		a.Nil(err, "version %d", version)
+		a.Len(s.Get(brokersKeyName), 0)
+		topics := s.Get("topic_metadata").([]interface{})
+		a.Len(topics, 2)
+		for i, topic := range []string{"t1", "t2"} {
+			a.Equal(topic, topics[i].(*Struct).Get("topic"))
+			a.Equal(int16(ErrTopicAuthorizationFailed), topics[i].(*Struct).Get("error_code"))
+		}
+	}
+
+	// v1 with null topics - no topics are returned
+	response, err := EncodeErrorResponse([]byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, int16(ErrClusterAuthorizationFailed))
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00}, response)
+}
+
+func TestEncodeErrorResponseFetchV7(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x01, 0x00, 0x07,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00,
+		// replica_id, max_wait_time, min_bytes, max_bytes, isolation_level, session_id, session_epoch
+		0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x10, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// topics
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x10, 0x00,
+		// forgotten_topics_data
+		0x00, 0x00, 0x00, 0x00,
+	}
+	response, err := EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Equal([]byte{
+		// throttle_time_ms, error_code, session_id
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		// responses
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		// partition, error_code, high_watermark, last_stable_offset, log_start_offset
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x1d,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		// aborted_transactions null, record_set
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+	}, response)
+}
+
+func TestEncodeErrorResponseDescribeConfigsV0(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x20, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0xff, 0xff,
+		0x00, 0x00, 0x00, 0x01,
+		0x02, 0x00, 0x02, 't', '1', 0xff, 0xff, 0xff, 0xff,
+	}
+	response, err := EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Equal([]byte{
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		// error_code, error_message, resource_type, resource_name, config_entries
+		0x00, 0x1d,
+		0xff, 0xff,
+		0x02, 0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x00,
+	}, response)
+}
+
+func TestEncodeErrorResponseUnsupported(t *testing.T) {
+	a := assert.New(t)
+
+	// ApiVersions v0
+	_, err := EncodeErrorResponse([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff}, int16(ErrClusterAuthorizationFailed))
+	a.NotNil(err)
+
+	// flexible DeleteTopics v4
+	_, err = EncodeErrorResponse([]byte{0x00, 0x14, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x02, 0x03, 't', '1', 0x00}, int16(ErrTopicAuthorizationFailed))
+	a.NotNil(err)
+}
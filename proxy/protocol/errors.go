@@ -116,6 +116,16 @@ const (
 	ErrSASLAuthenticationFailed           KError = 58
 	ErrUnknownProducerID                  KError = 59
 	ErrReassignmentInProgress             KError = 60
+	ErrDelegationTokenAuthDisabled        KError = 61
+	ErrDelegationTokenNotFound            KError = 62
+	ErrDelegationTokenOwnerMismatch       KError = 63
+	ErrDelegationTokenRequestNotAllowed   KError = 64
+	ErrDelegationTokenAuthorization       KError = 65
+	ErrDelegationTokenExpired             KError = 66
+	ErrUnsupportedCompressionType         KError = 76
+	ErrInvalidRecord                      KError = 87
+	ErrUnknownSubscriptionID              KError = 117
+	ErrTelemetryTooLarge                  KError = 118
 )
 
 func (err KError) Error() string {
@@ -246,6 +256,26 @@ func (err KError) Error() string {
 		return "kafka server: The broker could not locate the producer metadata associated with the Producer ID."
 	case ErrReassignmentInProgress:
 		return "kafka server: A partition reassignment is in progress."
+	case ErrDelegationTokenAuthDisabled:
+		return "kafka server: Delegation Token feature is not enabled."
+	case ErrDelegationTokenNotFound:
+		return "kafka server: Delegation Token is not found on server."
+	case ErrDelegationTokenOwnerMismatch:
+		return "kafka server: Specified Principal is not valid Owner/Renewer."
+	case ErrDelegationTokenRequestNotAllowed:
+		return "kafka server: Delegation Token requests are not allowed on PLAINTEXT/1-way SSL channels and on delegation token authenticated channels."
+	case ErrDelegationTokenAuthorization:
+		return "kafka server: Delegation Token authorization failed."
+	case ErrDelegationTokenExpired:
+		return "kafka server: Delegation Token is expired."
+	case ErrUnsupportedCompressionType:
+		return "kafka server: The requesting client does not support the compression type of given partition."
+	case ErrInvalidRecord:
+		return "kafka server: This record has failed the validation on broker and hence will be rejected."
+	case ErrUnknownSubscriptionID:
+		return "kafka server: Client sent a push telemetry request with an invalid or outdated subscription ID."
+	case ErrTelemetryTooLarge:
+		return "kafka server: Client sent a push telemetry request larger than the maximum size the broker will accept."
 	}
 
 	return fmt.Sprintf("Unknown error, how did this happen? Error code = %d", err)
@@ -0,0 +1,330 @@
+package protocol
+
+const (
+	apiKeyJoinGroup       = 11
+	apiKeyHeartbeat       = 12
+	apiKeyLeaveGroup      = 13
+	apiKeySyncGroup       = 14
+	apiKeyDescribeGroups  = 15
+	apiKeyListGroups      = 16
+	apiKeyInitProducerId  = 22
+	apiKeyAddOffsetsToTxn = 25
+	apiKeyEndTxn          = 26
+	apiKeyDeleteGroups    = 42
+
+	groupIdKeyName         = "group_id"
+	groupsKeyName          = "groups"
+	transactionalIdKeyName = "transactional_id"
+	// FindCoordinator key is the group id or the transactional id
+	findCoordinatorKeyName = "key"
+)
+
+var (
+	// groupRequestSchemaVersions are the requests which reference group ids or transactional ids but no topics
+	groupRequestSchemaVersions = map[int16][]Schema{
+		apiKeyFindCoordinator: createFindCoordinatorRequestSchemaVersions(),
+		apiKeyJoinGroup:       createJoinGroupRequestSchemaVersions(),
+		apiKeyHeartbeat:       createHeartbeatRequestSchemaVersions(),
+		apiKeyLeaveGroup:      createLeaveGroupRequestSchemaVersions(),
+		apiKeySyncGroup:       createSyncGroupRequestSchemaVersions(),
+		apiKeyDescribeGroups:  createDescribeGroupsRequestSchemaVersions(),
+		apiKeyListGroups:      createListGroupsRequestSchemaVersions(),
+		apiKeyInitProducerId:  createInitProducerIdRequestSchemaVersions(),
+		apiKeyAddOffsetsToTxn: createAddOffsetsToTxnRequestSchemaVersions(),
+		apiKeyEndTxn:          createEndTxnRequestSchemaVersions(),
+		apiKeyDeleteGroups:    createDeleteGroupsRequestSchemaVersions(),
+	}
+
+	// groupResponseSchemaVersions are the responses with group ids to the requests of groupRequestSchemaVersions
+	groupResponseSchemaVersions = map[int16][]Schema{
+		apiKeyDescribeGroups: createDescribeGroupsResponseSchemaVersions(),
+		apiKeyListGroups:     createListGroupsResponseSchemaVersions(),
+		apiKeyDeleteGroups:   createDeleteGroupsResponseSchemaVersions(),
+	}
+
+	// MaxGroupPrefixVersions are the highest versions of the requests of groupRequestSchemaVersions and their responses
+	// which AddTopicPrefix and RemoveTopicPrefix support when group ids and transactional ids are prefixed.
+	MaxGroupPrefixVersions = createMaxGroupPrefixVersions()
+)
+
+func createMaxGroupPrefixVersions() map[int16]int16 {
+	result := make(map[int16]int16)
+	for apiKey, requestSchemas := range groupRequestSchemaVersions {
+		maxVersion := len(requestSchemas)
+		if responseSchemas, ok := groupResponseSchemaVersions[apiKey]; ok && len(responseSchemas) < maxVersion {
+			maxVersion = len(responseSchemas)
+		}
+		result[apiKey] = int16(maxVersion - 1)
+	}
+	return result
+}
+
+func createFindCoordinatorRequestSchemaVersions() []Schema {
+	findCoordinatorRequestV0 := NewSchema("find_coordinator_request_v0",
+		&field{name: findCoordinatorKeyName, ty: typeStr},
+	)
+
+	findCoordinatorRequestV1 := NewSchema("find_coordinator_request_v1",
+		&field{name: findCoordinatorKeyName, ty: typeStr},
+		&field{name: "key_type", ty: typeInt8},
+	)
+
+	return []Schema{findCoordinatorRequestV0, findCoordinatorRequestV1, findCoordinatorRequestV1}
+}
+
+func createJoinGroupRequestSchemaVersions() []Schema {
+	protocolV0 := NewSchema("join_group_protocol_v0",
+		&field{name: "name", ty: typeStr},
+		&field{name: "metadata", ty: typeBytes},
+	)
+
+	joinGroupRequestV0 := NewSchema("join_group_request_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "session_timeout_ms", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "protocols", ty: protocolV0},
+	)
+
+	joinGroupRequestV1 := NewSchema("join_group_request_v1",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "session_timeout_ms", ty: typeInt32},
+		&field{name: "rebalance_timeout_ms", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "protocols", ty: protocolV0},
+	)
+
+	joinGroupRequestV5 := NewSchema("join_group_request_v5",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "session_timeout_ms", ty: typeInt32},
+		&field{name: "rebalance_timeout_ms", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&array{name: "protocols", ty: protocolV0},
+	)
+
+	return []Schema{joinGroupRequestV0, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV1, joinGroupRequestV5}
+}
+
+func createHeartbeatRequestSchemaVersions() []Schema {
+	heartbeatRequestV0 := NewSchema("heartbeat_request_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+	)
+
+	heartbeatRequestV3 := NewSchema("heartbeat_request_v3",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+	)
+
+	return []Schema{heartbeatRequestV0, heartbeatRequestV0, heartbeatRequestV0, heartbeatRequestV3}
+}
+
+func createLeaveGroupRequestSchemaVersions() []Schema {
+	leaveGroupRequestV0 := NewSchema("leave_group_request_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "member_id", ty: typeStr},
+	)
+
+	memberV3 := NewSchema("leave_group_member_v3",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+	)
+
+	leaveGroupRequestV3 := NewSchema("leave_group_request_v3",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&array{name: "members", ty: memberV3},
+	)
+
+	return []Schema{leaveGroupRequestV0, leaveGroupRequestV0, leaveGroupRequestV0, leaveGroupRequestV3}
+}
+
+func createSyncGroupRequestSchemaVersions() []Schema {
+	assignmentV0 := NewSchema("sync_group_assignment_v0",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "assignment", ty: typeBytes},
+	)
+
+	syncGroupRequestV0 := NewSchema("sync_group_request_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "assignments", ty: assignmentV0},
+	)
+
+	syncGroupRequestV3 := NewSchema("sync_group_request_v3",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&array{name: "assignments", ty: assignmentV0},
+	)
+
+	return []Schema{syncGroupRequestV0, syncGroupRequestV0, syncGroupRequestV0, syncGroupRequestV3}
+}
+
+func createDescribeGroupsRequestSchemaVersions() []Schema {
+	describeGroupsRequestV0 := NewSchema("describe_groups_request_v0",
+		&array{name: groupsKeyName, ty: typeStr},
+	)
+
+	describeGroupsRequestV3 := NewSchema("describe_groups_request_v3",
+		&array{name: groupsKeyName, ty: typeStr},
+		&field{name: "include_authorized_operations", ty: typeBool},
+	)
+
+	return []Schema{describeGroupsRequestV0, describeGroupsRequestV0, describeGroupsRequestV0, describeGroupsRequestV3, describeGroupsRequestV3}
+}
+
+func createListGroupsRequestSchemaVersions() []Schema {
+	listGroupsRequestV0 := NewSchema("list_groups_request_v0")
+
+	return []Schema{listGroupsRequestV0, listGroupsRequestV0, listGroupsRequestV0}
+}
+
+func createInitProducerIdRequestSchemaVersions() []Schema {
+	initProducerIdRequestV0 := NewSchema("init_producer_id_request_v0",
+		&field{name: transactionalIdKeyName, ty: typeNullableStr},
+		&field{name: "transaction_timeout_ms", ty: typeInt32},
+	)
+
+	return []Schema{initProducerIdRequestV0, initProducerIdRequestV0}
+}
+
+func createAddOffsetsToTxnRequestSchemaVersions() []Schema {
+	addOffsetsToTxnRequestV0 := NewSchema("add_offsets_to_txn_request_v0",
+		&field{name: transactionalIdKeyName, ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&field{name: groupIdKeyName, ty: typeStr},
+	)
+
+	return []Schema{addOffsetsToTxnRequestV0, addOffsetsToTxnRequestV0, addOffsetsToTxnRequestV0}
+}
+
+func createEndTxnRequestSchemaVersions() []Schema {
+	endTxnRequestV0 := NewSchema("end_txn_request_v0",
+		&field{name: transactionalIdKeyName, ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&field{name: "committed", ty: typeBool},
+	)
+
+	return []Schema{endTxnRequestV0, endTxnRequestV0, endTxnRequestV0}
+}
+
+func createDeleteGroupsRequestSchemaVersions() []Schema {
+	deleteGroupsRequestV0 := NewSchema("delete_groups_request_v0",
+		&array{name: groupsKeyName, ty: typeStr},
+	)
+
+	return []Schema{deleteGroupsRequestV0, deleteGroupsRequestV0}
+}
+
+func createDescribeGroupsResponseSchemaVersions() []Schema {
+	memberV0 := NewSchema("describe_groups_member_v0",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "client_id", ty: typeStr},
+		&field{name: "client_host", ty: typeStr},
+		&field{name: "member_metadata", ty: typeBytes},
+		&field{name: "member_assignment", ty: typeBytes},
+	)
+
+	memberV4 := NewSchema("describe_groups_member_v4",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: "client_id", ty: typeStr},
+		&field{name: "client_host", ty: typeStr},
+		&field{name: "member_metadata", ty: typeBytes},
+		&field{name: "member_assignment", ty: typeBytes},
+	)
+
+	groupV0 := NewSchema("describe_groups_group_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+	)
+
+	groupV3 := NewSchema("describe_groups_group_v3",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+		&field{name: "authorized_operations", ty: typeInt32},
+	)
+
+	groupV4 := NewSchema("describe_groups_group_v4",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "group_state", ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+		&field{name: "protocol_data", ty: typeStr},
+		&array{name: "members", ty: memberV4},
+		&field{name: "authorized_operations", ty: typeInt32},
+	)
+
+	describeGroupsResponseV0 := NewSchema("describe_groups_response_v0",
+		&array{name: groupsKeyName, ty: groupV0},
+	)
+
+	describeGroupsResponseV1 := NewSchema("describe_groups_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: groupsKeyName, ty: groupV0},
+	)
+
+	describeGroupsResponseV3 := NewSchema("describe_groups_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: groupsKeyName, ty: groupV3},
+	)
+
+	describeGroupsResponseV4 := NewSchema("describe_groups_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: groupsKeyName, ty: groupV4},
+	)
+
+	return []Schema{describeGroupsResponseV0, describeGroupsResponseV1, describeGroupsResponseV1, describeGroupsResponseV3, describeGroupsResponseV4}
+}
+
+func createListGroupsResponseSchemaVersions() []Schema {
+	groupV0 := NewSchema("list_groups_group_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "protocol_type", ty: typeStr},
+	)
+
+	listGroupsResponseV0 := NewSchema("list_groups_response_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: groupsKeyName, ty: groupV0},
+	)
+
+	listGroupsResponseV1 := NewSchema("list_groups_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: groupsKeyName, ty: groupV0},
+	)
+
+	return []Schema{listGroupsResponseV0, listGroupsResponseV1, listGroupsResponseV1}
+}
+
+func createDeleteGroupsResponseSchemaVersions() []Schema {
+	resultV0 := NewSchema("delete_groups_result_v0",
+		&field{name: groupIdKeyName, ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	deleteGroupsResponseV0 := NewSchema("delete_groups_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "results", ty: resultV0},
+	)
+
+	return []Schema{deleteGroupsResponseV0, deleteGroupsResponseV0}
+}
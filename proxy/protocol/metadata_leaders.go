@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"errors"
+)
+
+// MetadataBroker is the broker of the Metadata response
+type MetadataBroker struct {
+	NodeID int32
+	Host   string
+	Port   int32
+}
+
+// MetadataTopic is the topic of the Metadata response with the leaders of its partitions
+type MetadataTopic struct {
+	Name      string
+	ErrorCode int16
+	// leader node id by partition, -1 when the partition has no leader
+	Leaders map[int32]int32
+}
+
+// MetadataLeaders are the brokers and the partition leaders of the Metadata response
+type MetadataLeaders struct {
+	Brokers []MetadataBroker
+	Topics  []MetadataTopic
+}
+
+// DecodeMetadataLeaders returns the brokers and the partition leaders of the Metadata response (without the size and the correlation id).
+// The topics requested by their ids without the names (v12+) are skipped.
+func DecodeMetadataLeaders(apiVersion int16, response []byte) (*MetadataLeaders, error) {
+	schema, err := getResponseSchema(apiKeyMetadata, apiVersion, metadataResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	brokers, ok := decoded.Get(brokersKeyName).([]interface{})
+	if !ok {
+		return nil, errors.New("brokers list not found")
+	}
+	topics, ok := decoded.Get(topicMetadataKeyName).([]interface{})
+	if !ok {
+		return nil, errors.New("topic metadata not found")
+	}
+	leaders := &MetadataLeaders{
+		Brokers: make([]MetadataBroker, 0, len(brokers)),
+		Topics:  make([]MetadataTopic, 0, len(topics)),
+	}
+	for _, elem := range brokers {
+		broker := elem.(*Struct)
+		leaders.Brokers = append(leaders.Brokers, MetadataBroker{
+			NodeID: broker.Get(nodeIDKeyName).(int32),
+			Host:   broker.Get(hostKeyName).(string),
+			Port:   broker.Get(portKeyName).(int32),
+		})
+	}
+	for _, elem := range topics {
+		topic := elem.(*Struct)
+		var name string
+		switch value := topic.Get(topicKeyName).(type) {
+		case string:
+			name = value
+		case *string:
+			if value == nil {
+				continue
+			}
+			name = *value
+		default:
+			continue
+		}
+		partitions, _ := topic.Get("partition_metadata").([]interface{})
+		metadataTopic := MetadataTopic{
+			Name:      name,
+			ErrorCode: topic.Get(errorCodeKeyName).(int16),
+			Leaders:   make(map[int32]int32, len(partitions)),
+		}
+		for _, partition := range partitions {
+			s := partition.(*Struct)
+			metadataTopic.Leaders[s.Get("partition").(int32)] = s.Get("leader").(int32)
+		}
+		leaders.Topics = append(leaders.Topics, metadataTopic)
+	}
+	return leaders, nil
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeMetadataLeaders(t *testing.T) {
+	a := assert.New(t)
+
+	response := testMetadataResponseV1([]testBroker{{0, "kafka-0"}, {1, "kafka-1"}}, 1, []testTopic{{"orders", 1}, {"payments", -1}})
+	leaders, err := DecodeMetadataLeaders(1, response)
+	a.Nil(err)
+	a.Equal([]MetadataBroker{{0, "kafka-0", 9092}, {1, "kafka-1", 9092}}, leaders.Brokers)
+	a.Equal([]MetadataTopic{
+		{Name: "orders", Leaders: map[int32]int32{0: 1}},
+		{Name: "payments", Leaders: map[int32]int32{0: -1}},
+	}, leaders.Topics)
+
+	// all versions
+	for version := int16(0); version < int16(len(metadataResponseSchemaVersions)); version++ {
+		leaders, err = DecodeMetadataLeaders(version, testMetadataResponse(version, [][2]interface{}{{"localhost", int32(51)}}))
+		a.Nil(err, "version %d", version)
+		a.Equal([]MetadataBroker{{0, "localhost", 51}}, leaders.Brokers, "version %d", version)
+		a.Equal([]MetadataTopic{{Name: "foo", Leaders: map[int32]int32{0: 1}}}, leaders.Topics, "version %d", version)
+	}
+
+	// the topic requested by its id without the name is skipped
+	nullTopic := testMetadataResponse(12, [][2]interface{}{{"localhost", int32(51)}})
+	offset := bytes.Index(nullTopic, []byte{0x04, 'f', 'o', 'o'})
+	nullTopic = append(append(append([]byte{}, nullTopic[:offset]...), 0x00), nullTopic[offset+4:]...)
+	leaders, err = DecodeMetadataLeaders(12, nullTopic)
+	a.Nil(err)
+	a.Empty(leaders.Topics)
+
+	_, err = DecodeMetadataLeaders(int16(len(metadataResponseSchemaVersions)), response)
+	a.NotNil(err)
+	_, err = DecodeMetadataLeaders(1, response[:10])
+	a.NotNil(err)
+}
@@ -10,12 +10,18 @@ type packetEncoder interface {
 	putInt32(in int32)
 	putInt64(in int64)
 	putVarint(in int64)
+	putUVarint(in uint64)
 	putArrayLength(in int) error
+	putCompactArrayLength(in int) error
 	putBool(in bool)
 
 	putBytes(in []byte) error
+	putRawBytes(in []byte) error
+	putCompactBytes(in []byte) error
 	putString(in string) error
 	putNullableString(in *string) error
+	putCompactString(in string) error
+	putCompactNullableString(in *string) error
 	putStringArray(in []string) error
 	putInt32Array(in []int32) error
 	putInt64Array(in []int64) error
@@ -33,6 +33,11 @@ func (pe *prepEncoder) putVarint(in int64) {
 	pe.length += binary.PutVarint(buf[:], in)
 }
 
+func (pe *prepEncoder) putUVarint(in uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	pe.length += binary.PutUvarint(buf[:], in)
+}
+
 func (pe *prepEncoder) putArrayLength(in int) error {
 	if in > math.MaxInt32 {
 		return PacketEncodingError{fmt.Sprintf("array too long (%d)", in)}
@@ -41,6 +46,15 @@ func (pe *prepEncoder) putArrayLength(in int) error {
 	return nil
 }
 
+func (pe *prepEncoder) putCompactArrayLength(in int) error {
+	if in > math.MaxInt32 {
+		return PacketEncodingError{fmt.Sprintf("array too long (%d)", in)}
+	}
+	// the null array is encoded as 0
+	pe.putUVarint(uint64(in + 1))
+	return nil
+}
+
 func (pe *prepEncoder) putBool(in bool) {
 	pe.length++
 }
@@ -54,6 +68,15 @@ func (pe *prepEncoder) putBytes(in []byte) error {
 	return pe.putRawBytes(in)
 }
 
+func (pe *prepEncoder) putCompactBytes(in []byte) error {
+	if in == nil {
+		pe.putUVarint(0)
+		return nil
+	}
+	pe.putUVarint(uint64(len(in) + 1))
+	return pe.putRawBytes(in)
+}
+
 func (pe *prepEncoder) putRawBytes(in []byte) error {
 	if len(in) > math.MaxInt32 {
 		return PacketEncodingError{fmt.Sprintf("byteslice too long (%d)", len(in))}
@@ -79,6 +102,23 @@ func (pe *prepEncoder) putString(in string) error {
 	return nil
 }
 
+func (pe *prepEncoder) putCompactNullableString(in *string) error {
+	if in == nil {
+		pe.putUVarint(0)
+		return nil
+	}
+	return pe.putCompactString(*in)
+}
+
+func (pe *prepEncoder) putCompactString(in string) error {
+	if len(in) > math.MaxInt16 {
+		return PacketEncodingError{fmt.Sprintf("string too long (%d)", len(in))}
+	}
+	pe.putUVarint(uint64(len(in) + 1))
+	pe.length += len(in)
+	return nil
+}
+
 func (pe *prepEncoder) putStringArray(in []string) error {
 	err := pe.putArrayLength(len(in))
 	if err != nil {
@@ -0,0 +1,30 @@
+package protocol
+
+import "time"
+
+// produceRecordsVersion is the version of the Produce requests of EncodeProduceRequest, the first version with the record batches
+const produceRecordsVersion = 3
+
+// EncodeProduceRequest returns the Produce request (without the size) writing the record set to the topic partition
+func EncodeProduceRequest(correlationID int32, clientID string, acks int16, timeout time.Duration, topic string, partition int32, recordSet []byte) []byte {
+	request := make([]byte, 0, 2+2+4+2+len(clientID)+2+2+4+4+2+len(topic)+4+4+4+len(recordSet))
+	request = appendInt16(request, apiKeyProduce)
+	request = appendInt16(request, produceRecordsVersion)
+	request = appendInt32(request, correlationID)
+	request = appendString(request, clientID)
+	// null transactional id
+	request = appendInt16(request, -1)
+	request = appendInt16(request, acks)
+	request = appendInt32(request, int32(timeout/time.Millisecond))
+	request = appendInt32(request, 1)
+	request = appendString(request, topic)
+	request = appendInt32(request, 1)
+	request = appendInt32(request, partition)
+	request = appendInt32(request, int32(len(recordSet)))
+	return append(request, recordSet...)
+}
+
+// ProduceResponseErrorCodes returns the error codes of the response (without the size and the correlation id) to the request of EncodeProduceRequest
+func ProduceResponseErrorCodes(response []byte) ([]KError, error) {
+	return ResponseErrorCodes(apiKeyProduce, produceRecordsVersion, response)
+}
@@ -0,0 +1,173 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	// shadowMetadataVersion is the version of the Metadata requests of the produce shadow
+	shadowMetadataVersion = 1
+
+	topicDataKeyName = "topic_data"
+)
+
+// ErrTransactionalProduceRequest is returned by SplitProduceRequest for the requests with a transactional id
+var ErrTransactionalProduceRequest = errors.New("transactional produce request is not split")
+
+// PartitionLeaders are the leaders of the topic partitions decoded from a Metadata response
+type PartitionLeaders struct {
+	Brokers map[int32]string           // node id to host:port
+	Leaders map[string]map[int32]int32 // topic to partition to leader node id
+}
+
+// Leader returns the address of the leader of the topic partition
+func (l *PartitionLeaders) Leader(topic string, partition int32) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	nodeID, ok := l.Leaders[topic][partition]
+	if !ok {
+		return "", false
+	}
+	address, ok := l.Brokers[nodeID]
+	return address, ok
+}
+
+// EncodeShadowMetadataRequest returns the Metadata request (without the size) for the topics
+func EncodeShadowMetadataRequest(correlationID int32, clientID string, topics []string) []byte {
+	length := 2 + 2 + 4 + 2 + len(clientID) + 4
+	for _, topic := range topics {
+		length += 2 + len(topic)
+	}
+	request := make([]byte, 0, length)
+	request = appendInt16(request, apiKeyMetadata)
+	request = appendInt16(request, shadowMetadataVersion)
+	request = appendInt32(request, correlationID)
+	request = appendString(request, clientID)
+	request = appendInt32(request, int32(len(topics)))
+	for _, topic := range topics {
+		request = appendString(request, topic)
+	}
+	return request
+}
+
+func appendInt16(buf []byte, value int16) []byte {
+	return append(buf, byte(uint16(value)>>8), byte(value))
+}
+
+func appendInt32(buf []byte, value int32) []byte {
+	return append(buf, byte(uint32(value)>>24), byte(uint32(value)>>16), byte(uint32(value)>>8), byte(value))
+}
+
+func appendString(buf []byte, value string) []byte {
+	return append(appendInt16(buf, int16(len(value))), value...)
+}
+
+// DecodeShadowMetadataResponse decodes the leaders of the partitions from the response (without the size and the correlation id)
+// to the Metadata request of EncodeShadowMetadataRequest. The partitions with an error are skipped.
+func DecodeShadowMetadataResponse(response []byte) (*PartitionLeaders, error) {
+	body, err := DecodeSchema(response, metadataResponseSchemaVersions[shadowMetadataVersion])
+	if err != nil {
+		return nil, err
+	}
+	leaders := &PartitionLeaders{Brokers: make(map[int32]string), Leaders: make(map[string]map[int32]int32)}
+	for _, elem := range body.Get(brokersKeyName).([]interface{}) {
+		broker := elem.(*Struct)
+		leaders.Brokers[broker.Get(nodeIDKeyName).(int32)] = net.JoinHostPort(broker.Get(hostKeyName).(string), fmt.Sprint(broker.Get(portKeyName).(int32)))
+	}
+	for _, elem := range body.Get(topicMetadataKeyName).([]interface{}) {
+		topic := elem.(*Struct)
+		if topic.Get(errorCodeKeyName).(int16) != 0 {
+			continue
+		}
+		partitions := make(map[int32]int32)
+		for _, p := range topic.Get("partition_metadata").([]interface{}) {
+			partition := p.(*Struct)
+			if partition.Get(errorCodeKeyName).(int16) != 0 {
+				continue
+			}
+			partitions[partition.Get("partition").(int32)] = partition.Get("leader").(int32)
+		}
+		leaders.Leaders[topic.Get(topicKeyName).(string)] = partitions
+	}
+	return leaders, nil
+}
+
+// ProduceSplit is the Produce request split by the leaders of its partitions
+type ProduceSplit struct {
+	// requests (without the size) with the header of the Produce request and the partitions of one leader by the leader address
+	Requests map[string][]byte
+	Acks     int16
+	// topics of the partitions without a known leader, the partitions are not a part of the requests
+	Unknown []string
+}
+
+// SplitProduceRequest splits the selected topics of the Produce request (without the size) by the leaders of their partitions.
+// The transactional requests are not split.
+func SplitProduceRequest(request []byte, selected func(topic string) bool, leader func(topic string, partition int32) (string, bool)) (*ProduceSplit, error) {
+	info, headerLength, err := decodeRequestHeader(request)
+	if err != nil {
+		return nil, err
+	}
+	schemas := requestSchemaVersions[apiKeyProduce]
+	if info.ApiKey != apiKeyProduce || info.ApiVersion < 0 || int(info.ApiVersion) >= len(schemas) {
+		return nil, fmt.Errorf("produce request expected, got api key %d version %d", info.ApiKey, info.ApiVersion)
+	}
+	schema := schemas[info.ApiVersion]
+	body, err := DecodeSchema(request[headerLength:], schema)
+	if err != nil {
+		return nil, err
+	}
+	if transactionalID, ok := body.Get("transactional_id").(*string); ok && transactionalID != nil {
+		return nil, ErrTransactionalProduceRequest
+	}
+	requests, unknown, err := splitRequest(request[:headerLength], body, schema, topicDataKeyName, "data", selected, leader)
+	if err != nil {
+		return nil, err
+	}
+	return &ProduceSplit{Requests: requests, Acks: body.Get("acks").(int16), Unknown: unknown}, nil
+}
+
+// splitRequest splits the partitions of the selected topics of the request body by the leaders of the partitions. The topics are the array
+// of the structs with the topic name and the array of the partitions. It returns the requests (without the size) with the header and
+// the partitions of one leader by the leader address and the topics of the partitions without a known leader.
+func splitRequest(header []byte, body *Struct, schema Schema, topicsKey string, partitionsKey string, selected func(topic string) bool, leader func(topic string, partition int32) (string, bool)) (map[string][]byte, []string, error) {
+	var unknown []string
+	// topics by the leader address
+	topicsByLeader := make(map[string][]interface{})
+	for _, t := range body.Get(topicsKey).([]interface{}) {
+		topic := t.(*Struct)
+		name := topic.Get(topicKeyName).(string)
+		if !selected(name) {
+			continue
+		}
+		partitions := make(map[string][]interface{})
+		for _, p := range topic.Get(partitionsKey).([]interface{}) {
+			address, ok := leader(name, p.(*Struct).Get("partition").(int32))
+			if !ok {
+				unknown = appendTopic(unknown, name)
+				continue
+			}
+			partitions[address] = append(partitions[address], p)
+		}
+		for address, data := range partitions {
+			topicsByLeader[address] = append(topicsByLeader[address], &Struct{schema: topic.schema, values: []interface{}{name, data}})
+		}
+	}
+	requests := make(map[string][]byte)
+	for address, topics := range topicsByLeader {
+		if err := body.Replace(topicsKey, topics); err != nil {
+			return nil, nil, err
+		}
+		newBody, err := EncodeSchema(body, schema)
+		if err != nil {
+			return nil, nil, err
+		}
+		result := make([]byte, 0, len(header)+len(newBody))
+		result = append(result, header...)
+		requests[address] = append(result, newBody...)
+	}
+	return requests, unknown, nil
+}
@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testProducePartition struct {
+	topic     string
+	partition int32
+}
+
+// testProduceRequestV3 encodes the Produce v3 request (without the size) with a record set of one byte per partition
+func testProduceRequestV3(transactionalID string, partitions []testProducePartition) []byte {
+	buf := []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x05}
+	buf = appendTestString(buf, "c")
+	if transactionalID == "" {
+		buf = append(buf, 0xff, 0xff)
+	} else {
+		buf = appendTestString(buf, transactionalID)
+	}
+	// acks, timeout
+	buf = append(buf, 0xff, 0xff)
+	buf = appendTestInt32(buf, 1000)
+	buf = appendTestInt32(buf, int32(len(partitions)))
+	for _, p := range partitions {
+		buf = appendTestString(buf, p.topic)
+		buf = appendTestInt32(buf, 1)
+		buf = appendTestInt32(buf, p.partition)
+		buf = appendTestInt32(buf, 1)
+		buf = append(buf, byte(p.partition))
+	}
+	return buf
+}
+
+func TestShadowMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	request := EncodeShadowMetadataRequest(7, "kafka-proxy", []string{"t1"})
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(int16(apiKeyMetadata), info.ApiKey)
+	a.Equal(int16(1), info.ApiVersion)
+	a.Equal(int32(7), info.CorrelationID)
+	a.Equal("kafka-proxy", info.ClientID)
+	a.Equal([]string{"t1"}, info.Topics)
+
+	response := testMetadataResponseV1([]testBroker{{nodeID: 1, host: "shadow-1"}, {nodeID: 2, host: "shadow-2"}}, 1,
+		[]testTopic{{name: "t1", leader: 1}, {name: "t2", leader: 2}, {name: "t3", leader: 3}})
+	leaders, err := DecodeShadowMetadataResponse(response)
+	a.Nil(err)
+
+	address, ok := leaders.Leader("t1", 0)
+	a.True(ok)
+	a.Equal("shadow-1:9092", address)
+	address, ok = leaders.Leader("t2", 0)
+	a.True(ok)
+	a.Equal("shadow-2:9092", address)
+	_, ok = leaders.Leader("t1", 1)
+	a.False(ok)
+	_, ok = leaders.Leader("t3", 0)
+	a.False(ok)
+	var nilLeaders *PartitionLeaders
+	_, ok = nilLeaders.Leader("t1", 0)
+	a.False(ok)
+}
+
+func TestSplitProduceRequest(t *testing.T) {
+	a := assert.New(t)
+
+	leaders := map[testProducePartition]string{
+		{topic: "t1", partition: 0}: "shadow-1:9092",
+		{topic: "t1", partition: 1}: "shadow-2:9092",
+		{topic: "t2", partition: 0}: "shadow-1:9092",
+	}
+	leader := func(topic string, partition int32) (string, bool) {
+		address, ok := leaders[testProducePartition{topic: topic, partition: partition}]
+		return address, ok
+	}
+	selected := func(topic string) bool { return topic != "private" }
+
+	request := testProduceRequestV3("", []testProducePartition{{"t1", 0}, {"t1", 1}, {"t2", 0}, {"t3", 0}, {"private", 0}})
+	split, err := SplitProduceRequest(request, selected, leader)
+	a.Nil(err)
+	a.Equal(int16(-1), split.Acks)
+	a.Equal([]string{"t3"}, split.Unknown)
+	a.Len(split.Requests, 2)
+	a.Equal(testProduceRequestV3("", []testProducePartition{{"t1", 0}, {"t2", 0}}), split.Requests["shadow-1:9092"])
+	a.Equal(testProduceRequestV3("", []testProducePartition{{"t1", 1}}), split.Requests["shadow-2:9092"])
+
+	split, err = SplitProduceRequest(testProduceRequestV3("", []testProducePartition{{"private", 0}}), selected, leader)
+	a.Nil(err)
+	a.Empty(split.Requests)
+
+	_, err = SplitProduceRequest(testProduceRequestV3("tx", []testProducePartition{{"t1", 0}}), selected, leader)
+	a.EqualError(err, "transactional produce request is not split")
+	_, err = SplitProduceRequest(EncodeShadowMetadataRequest(7, "kafka-proxy", nil), selected, leader)
+	a.EqualError(err, "produce request expected, got api key 3 version 1")
+}
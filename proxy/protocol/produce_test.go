@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEncodeProduceRequest(t *testing.T) {
+	a := assert.New(t)
+
+	records := []*Record{{Value: []byte("v1"), Headers: []RecordHeader{}}, {TimestampDelta: 5, Key: []byte("k2"), Value: []byte("v2"), Headers: []RecordHeader{{Key: []byte("type"), Value: []byte("auth_failure")}}}}
+	recordSet := EncodeRecordBatch(1700000000000, records)
+	request := EncodeProduceRequest(7, "kafka-proxy", -1, 10*time.Second, "audit", 2, recordSet)
+
+	info, _, err := decodeRequestHeader(request)
+	a.Nil(err)
+	a.Equal(int16(apiKeyProduce), info.ApiKey)
+	a.Equal(int16(produceRecordsVersion), info.ApiVersion)
+
+	split, err := SplitProduceRequest(request, func(string) bool { return true }, func(topic string, partition int32) (string, bool) {
+		a.Equal("audit", topic)
+		a.Equal(int32(2), partition)
+		return "kafka-0:9092", true
+	})
+	a.Nil(err)
+	a.Equal(int16(-1), split.Acks)
+	a.Equal(request, split.Requests["kafka-0:9092"])
+
+	var decoded []*RecordBatch
+	_, err = ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		decoded, _, err = DecodeRecordBatches(recordSet)
+		return recordSet, err
+	})
+	a.Nil(err)
+	a.Len(decoded, 1)
+	a.Equal(records, decoded[0].Records)
+	a.Equal(int64(1), decoded[0].Records[1].OffsetDelta)
+	a.Equal(recordSet, decoded[0].raw)
+}
+
+func TestProduceResponseErrorCodes(t *testing.T) {
+	a := assert.New(t)
+
+	response := []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x05, 'a', 'u', 'd', 'i', 't', 0x00, 0x00, 0x00, 0x01}
+	response = append(response, 0x00, 0x00, 0x00, 0x02, 0x00, 0x06)             // partition, error code
+	response = append(response, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00) // base offset
+	response = append(response, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff) // log append time
+	response = append(response, 0x00, 0x00, 0x00, 0x00)                         // throttle time
+
+	errorCodes, err := ProduceResponseErrorCodes(response)
+	a.Nil(err)
+	a.Equal([]KError{ErrNotLeaderForPartition}, errorCodes)
+}
@@ -72,6 +72,20 @@ func (rd *realDecoder) getVarint() (int64, error) {
 	return tmp, nil
 }
 
+func (rd *realDecoder) getUVarint() (uint64, error) {
+	tmp, n := binary.Uvarint(rd.raw[rd.off:])
+	if n == 0 {
+		rd.off = len(rd.raw)
+		return 0, ErrInsufficientData
+	}
+	if n < 0 {
+		rd.off -= n
+		return 0, errVarintOverflow
+	}
+	rd.off += n
+	return tmp, nil
+}
+
 func (rd *realDecoder) getArrayLength() (int, error) {
 	if rd.remaining() < 4 {
 		rd.off = len(rd.raw)
@@ -88,6 +102,24 @@ func (rd *realDecoder) getArrayLength() (int, error) {
 	return tmp, nil
 }
 
+// getCompactArrayLength returns -1 for the null array, the compact length is the number of elements plus one
+func (rd *realDecoder) getCompactArrayLength() (int, error) {
+	n, err := rd.getUVarint()
+	if err != nil {
+		return -1, err
+	}
+	if n == 0 {
+		return -1, nil
+	}
+	if n-1 > uint64(rd.remaining()) {
+		rd.off = len(rd.raw)
+		return -1, ErrInsufficientData
+	} else if n-1 > 2*math.MaxUint16 {
+		return -1, errInvalidArrayLength
+	}
+	return int(n - 1), nil
+}
+
 func (rd *realDecoder) getBool() (bool, error) {
 	b, err := rd.getInt8()
 	if err != nil || b == 0 {
@@ -126,6 +158,14 @@ func (rd *realDecoder) getRawBytes(length int) ([]byte, error) {
 	return rd.raw[start:rd.off], nil
 }
 
+func (rd *realDecoder) getCompactBytes() ([]byte, error) {
+	n, err := rd.getCompactStringLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	return rd.getRawBytes(n)
+}
+
 func (rd *realDecoder) getStringLength() (int, error) {
 	length, err := rd.getInt16()
 	if err != nil {
@@ -167,6 +207,44 @@ func (rd *realDecoder) getNullableString() (*string, error) {
 	return &tmpStr, err
 }
 
+// getCompactStringLength returns -1 for the null string or bytes
+func (rd *realDecoder) getCompactStringLength() (int, error) {
+	length, err := rd.getUVarint()
+	if err != nil {
+		return 0, err
+	}
+	if length == 0 {
+		return -1, nil
+	}
+	if length-1 > uint64(rd.remaining()) {
+		rd.off = len(rd.raw)
+		return 0, ErrInsufficientData
+	}
+	return int(length - 1), nil
+}
+
+func (rd *realDecoder) getCompactString() (string, error) {
+	n, err := rd.getCompactStringLength()
+	if err != nil || n == -1 {
+		return "", err
+	}
+
+	tmpStr := string(rd.raw[rd.off : rd.off+n])
+	rd.off += n
+	return tmpStr, nil
+}
+
+func (rd *realDecoder) getCompactNullableString() (*string, error) {
+	n, err := rd.getCompactStringLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+
+	tmpStr := string(rd.raw[rd.off : rd.off+n])
+	rd.off += n
+	return &tmpStr, nil
+}
+
 func (rd *realDecoder) getInt32Array() ([]int32, error) {
 	if rd.remaining() < 4 {
 		rd.off = len(rd.raw)
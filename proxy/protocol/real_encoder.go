@@ -35,11 +35,20 @@ func (re *realEncoder) putVarint(in int64) {
 	re.off += binary.PutVarint(re.raw[re.off:], in)
 }
 
+func (re *realEncoder) putUVarint(in uint64) {
+	re.off += binary.PutUvarint(re.raw[re.off:], in)
+}
+
 func (re *realEncoder) putArrayLength(in int) error {
 	re.putInt32(int32(in))
 	return nil
 }
 
+func (re *realEncoder) putCompactArrayLength(in int) error {
+	re.putUVarint(uint64(in + 1))
+	return nil
+}
+
 func (re *realEncoder) putBool(in bool) {
 	if in {
 		re.putInt8(1)
@@ -65,6 +74,15 @@ func (re *realEncoder) putBytes(in []byte) error {
 	return re.putRawBytes(in)
 }
 
+func (re *realEncoder) putCompactBytes(in []byte) error {
+	if in == nil {
+		re.putUVarint(0)
+		return nil
+	}
+	re.putUVarint(uint64(len(in) + 1))
+	return re.putRawBytes(in)
+}
+
 func (re *realEncoder) putString(in string) error {
 	re.putInt16(int16(len(in)))
 	copy(re.raw[re.off:], in)
@@ -80,6 +98,21 @@ func (re *realEncoder) putNullableString(in *string) error {
 	return re.putString(*in)
 }
 
+func (re *realEncoder) putCompactString(in string) error {
+	re.putUVarint(uint64(len(in) + 1))
+	copy(re.raw[re.off:], in)
+	re.off += len(in)
+	return nil
+}
+
+func (re *realEncoder) putCompactNullableString(in *string) error {
+	if in == nil {
+		re.putUVarint(0)
+		return nil
+	}
+	return re.putCompactString(*in)
+}
+
 func (re *realEncoder) putStringArray(in []string) error {
 	err := re.putArrayLength(len(in))
 	if err != nil {
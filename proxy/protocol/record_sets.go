@@ -0,0 +1,355 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	apiKeyApiVersions = 18
+
+	recordSetKeyName = "record_set"
+
+	errorCodeUnsupportedVersion = 35
+)
+
+var (
+	fetchResponseSchemaVersions = createFetchResponseSchemaVersions()
+)
+
+// ModifyRecordSetFunc returns the record set which replaces the record set of the topic.
+type ModifyRecordSetFunc func(topic string, recordSet []byte) ([]byte, error)
+
+func createFetchResponseSchemaVersions() []Schema {
+	partitionResponseV0 := NewSchema("fetch_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: recordSetKeyName, ty: typeBytes},
+	)
+
+	abortedTransactionV4 := NewSchema("fetch_response_aborted_transaction_v4",
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "first_offset", ty: typeInt64},
+	)
+
+	partitionResponseV4 := NewSchema("fetch_response_partition_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&array{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: recordSetKeyName, ty: typeBytes},
+	)
+
+	partitionResponseV5 := NewSchema("fetch_response_partition_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&array{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: recordSetKeyName, ty: typeBytes},
+	)
+
+	partitionResponseV11 := NewSchema("fetch_response_partition_v11",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "high_watermark", ty: typeInt64},
+		&field{name: "last_stable_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&array{name: "aborted_transactions", ty: abortedTransactionV4},
+		&field{name: "preferred_read_replica", ty: typeInt32},
+		&field{name: recordSetKeyName, ty: typeBytes},
+	)
+
+	topicResponse := func(name string, partitionResponse Schema) Schema {
+		return NewSchema(name,
+			&field{name: topicKeyName, ty: typeStr},
+			&array{name: "partition_responses", ty: partitionResponse},
+		)
+	}
+	topicResponseV0 := topicResponse("fetch_response_topic_v0", partitionResponseV0)
+	topicResponseV4 := topicResponse("fetch_response_topic_v4", partitionResponseV4)
+	topicResponseV5 := topicResponse("fetch_response_topic_v5", partitionResponseV5)
+	topicResponseV11 := topicResponse("fetch_response_topic_v11", partitionResponseV11)
+
+	fetchResponseV0 := NewSchema("fetch_response_v0",
+		&array{name: "responses", ty: topicResponseV0},
+	)
+
+	fetchResponseV1 := NewSchema("fetch_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: topicResponseV0},
+	)
+
+	fetchResponseV4 := NewSchema("fetch_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: topicResponseV4},
+	)
+
+	fetchResponseV5 := NewSchema("fetch_response_v5",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: topicResponseV5},
+	)
+
+	fetchResponseV7 := NewSchema("fetch_response_v7",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "session_id", ty: typeInt32},
+		&array{name: "responses", ty: topicResponseV5},
+	)
+
+	fetchResponseV11 := NewSchema("fetch_response_v11",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "session_id", ty: typeInt32},
+		&array{name: "responses", ty: topicResponseV11},
+	)
+
+	return []Schema{fetchResponseV0, fetchResponseV1, fetchResponseV1, fetchResponseV1, fetchResponseV4, fetchResponseV5, fetchResponseV5,
+		fetchResponseV7, fetchResponseV7, fetchResponseV7, fetchResponseV7, fetchResponseV11}
+}
+
+// MaxRecordSetsVersions are the highest Produce and Fetch versions which ModifyProduceRecordSets and ModifyFetchRecordSets support.
+var MaxRecordSetsVersions = map[int16]int16{
+	apiKeyProduce: 8,
+	apiKeyFetch:   11,
+}
+
+// ModifyProduceRecordSets applies fn to every record set of the Produce request.
+// The request starts with the header (ApiKey, ApiVersion, CorrelationId, ClientId) and does not contain the size.
+func ModifyProduceRecordSets(request []byte, fn ModifyRecordSetFunc) ([]byte, error) {
+	helper := realDecoder{raw: request}
+	v, err := requestHeaderSchema.decode(&helper)
+	if err != nil {
+		return nil, err
+	}
+	header := v.(*Struct)
+	apiKey, apiVersion := header.Get("api_key").(int16), header.Get("api_version").(int16)
+	if apiKey != apiKeyProduce {
+		return nil, fmt.Errorf("request key %d is not a Produce request", apiKey)
+	}
+	schema, err := getRecordSetsSchema(apiKey, apiVersion, requestSchemaVersions[apiKey])
+	if err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(request[helper.off:], schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = modifyRecordSets(body, "topic_data", "data", fn); err != nil {
+		return nil, err
+	}
+	newBody, err := EncodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, helper.off+len(newBody))
+	result = append(result, request[:helper.off]...)
+	return append(result, newBody...), nil
+}
+
+// ModifyFetchRecordSets applies fn to every record set of the Fetch response. The response does not contain the size and CorrelationId.
+func ModifyFetchRecordSets(apiVersion int16, response []byte, fn ModifyRecordSetFunc) ([]byte, error) {
+	schema, err := getRecordSetsSchema(apiKeyFetch, apiVersion, fetchResponseSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = modifyRecordSets(body, "responses", "partition_responses", fn); err != nil {
+		return nil, err
+	}
+	return EncodeSchema(body, schema)
+}
+
+func getRecordSetsSchema(apiKey int16, apiVersion int16, schemas []Schema) (Schema, error) {
+	if maxVersion := MaxRecordSetsVersions[apiKey]; apiVersion < 0 || apiVersion > maxVersion || int(apiVersion) >= len(schemas) {
+		return nil, fmt.Errorf("unsupported record sets version %d for key %d", apiVersion, apiKey)
+	}
+	return schemas[apiVersion], nil
+}
+
+func modifyRecordSets(body *Struct, topicsName string, partitionsName string, fn ModifyRecordSetFunc) error {
+	topics, ok := body.Get(topicsName).([]interface{})
+	if !ok {
+		return SchemaDecodingError{fmt.Sprintf("%s field not found", topicsName)}
+	}
+	for _, topicElem := range topics {
+		topic := topicElem.(*Struct)
+		name, ok := topic.Get(topicKeyName).(string)
+		if !ok {
+			return SchemaDecodingError{"topic field not found"}
+		}
+		partitions, ok := topic.Get(partitionsName).([]interface{})
+		if !ok {
+			return SchemaDecodingError{fmt.Sprintf("%s field not found", partitionsName)}
+		}
+		for _, partitionElem := range partitions {
+			partition := partitionElem.(*Struct)
+			recordSet, ok := partition.Get(recordSetKeyName).([]byte)
+			if !ok {
+				return SchemaDecodingError{"record_set field not found"}
+			}
+			if recordSet == nil {
+				continue
+			}
+			newRecordSet, err := fn(name, recordSet)
+			if err != nil {
+				return err
+			}
+			if newRecordSet == nil {
+				newRecordSet = []byte{}
+			}
+			if err = partition.Replace(recordSetKeyName, newRecordSet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LimitApiVersions lowers in place the max versions of the ApiVersions response to the given versions.
+// The response does not contain the size and CorrelationId.
+func LimitApiVersions(apiVersion int16, response []byte, maxVersions map[int16]int16) error {
+	if len(response) < 2 {
+		return PacketDecodingError{Info: "api versions response is too short"}
+	}
+	errorCode := int16(binary.BigEndian.Uint16(response))
+	offset := 2
+	var count int
+	var tagged bool
+	// the broker answers with the v0 response when the request version is not supported
+	if apiVersion >= 3 && errorCode != errorCodeUnsupportedVersion {
+		// flexible version: compact array and tagged fields
+		n, size := binary.Uvarint(response[offset:])
+		if size <= 0 || n == 0 {
+			return PacketDecodingError{Info: "invalid api versions array length"}
+		}
+		count = int(n - 1)
+		offset += size
+		tagged = true
+	} else {
+		if len(response) < offset+4 {
+			return PacketDecodingError{Info: "api versions response is too short"}
+		}
+		count = int(int32(binary.BigEndian.Uint32(response[offset:])))
+		offset += 4
+	}
+	for i := 0; i < count; i++ {
+		// ApiKey => int16, MinVersion => int16, MaxVersion => int16
+		if len(response) < offset+6 {
+			return PacketDecodingError{Info: "api versions response is too short"}
+		}
+		apiKey := int16(binary.BigEndian.Uint16(response[offset:]))
+		if maxVersion, ok := maxVersions[apiKey]; ok && int16(binary.BigEndian.Uint16(response[offset+4:])) > maxVersion {
+			binary.BigEndian.PutUint16(response[offset+4:], uint16(maxVersion))
+		}
+		offset += 6
+		if tagged {
+			var err error
+			if offset, err = skipTaggedFields(response, offset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddApiVersions adds the api keys missing in the ApiVersions response (without the size and CorrelationId) with the version 0 as the min version
+// and the given max versions, so the clients send the requests answered by the proxy.
+func AddApiVersions(apiVersion int16, response []byte, maxVersions map[int16]int16) ([]byte, error) {
+	if len(response) < 2 {
+		return nil, PacketDecodingError{Info: "api versions response is too short"}
+	}
+	errorCode := int16(binary.BigEndian.Uint16(response))
+	if errorCode != 0 {
+		return response, nil
+	}
+	offset := 2
+	var count int
+	flexible := apiVersion >= 3
+	if flexible {
+		n, size := binary.Uvarint(response[offset:])
+		if size <= 0 || n == 0 {
+			return nil, PacketDecodingError{Info: "invalid api versions array length"}
+		}
+		count = int(n - 1)
+		offset += size
+	} else {
+		if len(response) < offset+4 {
+			return nil, PacketDecodingError{Info: "api versions response is too short"}
+		}
+		count = int(int32(binary.BigEndian.Uint32(response[offset:])))
+		offset += 4
+	}
+	entriesOffset := offset
+	present := make(map[int16]bool, count)
+	for i := 0; i < count; i++ {
+		if len(response) < offset+6 {
+			return nil, PacketDecodingError{Info: "api versions response is too short"}
+		}
+		present[int16(binary.BigEndian.Uint16(response[offset:]))] = true
+		offset += 6
+		if flexible {
+			var err error
+			if offset, err = skipTaggedFields(response, offset); err != nil {
+				return nil, err
+			}
+		}
+	}
+	added := make([]int16, 0, len(maxVersions))
+	for apiKey := range maxVersions {
+		if !present[apiKey] {
+			added = append(added, apiKey)
+		}
+	}
+	if len(added) == 0 {
+		return response, nil
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+
+	result := make([]byte, 0, len(response)+binary.MaxVarintLen32+7*len(added))
+	result = append(result, response[:2]...)
+	if flexible {
+		var length [binary.MaxVarintLen32]byte
+		result = append(result, length[:binary.PutUvarint(length[:], uint64(count+len(added)+1))]...)
+	} else {
+		result = append(result, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(result[2:], uint32(count+len(added)))
+	}
+	result = append(result, response[entriesOffset:offset]...)
+	for _, apiKey := range added {
+		maxVersion := maxVersions[apiKey]
+		result = append(result, byte(apiKey>>8), byte(apiKey), 0, 0, byte(maxVersion>>8), byte(maxVersion))
+		if flexible {
+			result = append(result, 0)
+		}
+	}
+	return append(result, response[offset:]...), nil
+}
+
+func skipTaggedFields(buf []byte, offset int) (int, error) {
+	n, size := binary.Uvarint(buf[offset:])
+	if size <= 0 {
+		return 0, PacketDecodingError{Info: "invalid tagged fields count"}
+	}
+	offset += size
+	for i := uint64(0); i < n; i++ {
+		// tag
+		if _, size = binary.Uvarint(buf[offset:]); size <= 0 {
+			return 0, PacketDecodingError{Info: "invalid tag"}
+		}
+		offset += size
+		length, size := binary.Uvarint(buf[offset:])
+		if size <= 0 || length > uint64(len(buf)-offset-size) {
+			return 0, PacketDecodingError{Info: "invalid tagged field length"}
+		}
+		offset += size + int(length)
+	}
+	return offset, nil
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestModifyProduceRecordSets(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(0, &Record{Value: []byte("v1")})
+	request := []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x09, 0x00, 0x01, 'c'}
+	request = append(request, 0xff, 0xff) // transactional_id
+	request = append(request, 0xff, 0xff) // acks
+	request = append(request, 0x00, 0x00, 0x03, 0xe8)
+	request = append(request, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1', 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	request = append(request, 0x00, 0x00, 0x00, byte(len(recordSet)))
+	request = append(request, recordSet...)
+
+	var topics []string
+	result, err := ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		topics = append(topics, topic)
+		return append(recordSet, 0x01, 0x02), nil
+	})
+	a.Nil(err)
+	a.Equal([]string{"t1"}, topics)
+	a.Equal(len(request)+2, len(result))
+	a.Equal(request[:len(request)-len(recordSet)-4], result[:len(request)-len(recordSet)-4])
+	a.True(bytes.HasSuffix(result, append(recordSet, 0x01, 0x02)))
+
+	// flexible version is not supported
+	request[3] = 9
+	_, err = ModifyProduceRecordSets(request, nil)
+	a.NotNil(err)
+}
+
+func TestModifyFetchRecordSets(t *testing.T) {
+	a := assert.New(t)
+
+	recordSet := testRecordBatch(0, &Record{Value: []byte("v1")})
+	response := []byte{0x00, 0x00, 0x00, 0x00} // throttle_time_ms
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1', 0x00, 0x00, 0x00, 0x02)
+	// partition 0 with records and no aborted transactions
+	response = append(response, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, bytes.Repeat([]byte{0x00}, 24)...)
+	response = append(response, 0xff, 0xff, 0xff, 0xff)
+	response = append(response, 0x00, 0x00, 0x00, byte(len(recordSet)))
+	response = append(response, recordSet...)
+	// partition 1 with error and null records
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01)
+	response = append(response, bytes.Repeat([]byte{0x00}, 24)...)
+	response = append(response, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, 0xff, 0xff, 0xff, 0xff)
+
+	calls := 0
+	result, err := ModifyFetchRecordSets(5, response, func(topic string, recordSet []byte) ([]byte, error) {
+		calls++
+		a.Equal("t1", topic)
+		return recordSet[:len(recordSet)-1], nil
+	})
+	a.Nil(err)
+	a.Equal(1, calls)
+	a.Equal(len(response)-1, len(result))
+
+	_, err = ModifyFetchRecordSets(12, response, nil)
+	a.NotNil(err)
+	_, err = ModifyFetchRecordSets(4, response, nil)
+	a.NotNil(err)
+}
+
+func TestLimitApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	// v1: Produce 0-9, Fetch 0-12, Metadata 0-9
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x0c,
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x00}
+	a.Nil(LimitApiVersions(1, response, MaxRecordSetsVersions))
+	a.Equal([]byte{0x00, 0x08}, response[10:12])
+	a.Equal([]byte{0x00, 0x0b}, response[16:18])
+	a.Equal([]byte{0x00, 0x09}, response[22:24])
+
+	// v3: compact array with tagged fields
+	response = []byte{0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00,
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x01, 0x00, 0x01, 0xaa,
+		0x00, 0x00, 0x00, 0x00, 0x00}
+	a.Nil(LimitApiVersions(3, response, MaxRecordSetsVersions))
+	a.Equal([]byte{0x00, 0x08}, response[7:9])
+	a.Equal([]byte{0x00, 0x0b}, response[14:16])
+
+	// unsupported version error is answered with v0
+	response = []byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0c}
+	a.Nil(LimitApiVersions(3, response, MaxRecordSetsVersions))
+	a.Equal([]byte{0x00, 0x0b}, response[10:12])
+
+	a.NotNil(LimitApiVersions(1, response[:8], MaxRecordSetsVersions))
+}
+
+func TestAddApiVersions(t *testing.T) {
+	a := assert.New(t)
+
+	// v1: Produce 0-9, PushTelemetry 0-0 and throttle_time_ms
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x48, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}
+	result, err := AddApiVersions(1, response, ClientTelemetryVersions)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x48, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x47, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}, result)
+
+	// v3: compact array with tagged fields, the throttle_time_ms and the tagged fields of the response follow
+	response = []byte{0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x01, 0x00, 0x01, 0xaa,
+		0x00, 0x00, 0x00, 0x00, 0x00}
+	result, err = AddApiVersions(3, response, ClientTelemetryVersions)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x04,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x01, 0x00, 0x01, 0xaa,
+		0x00, 0x47, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x48, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00}, result)
+
+	// all api keys are announced
+	result, err = AddApiVersions(3, result, ClientTelemetryVersions)
+	a.Nil(err)
+	a.Len(result, 32)
+
+	// unsupported version error is answered with v0
+	response = []byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0c}
+	result, err = AddApiVersions(3, response, ClientTelemetryVersions)
+	a.Nil(err)
+	a.Equal(response, result)
+
+	_, err = AddApiVersions(1, response[2:8], ClientTelemetryVersions)
+	a.NotNil(err)
+}
@@ -0,0 +1,340 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	CompressionNone   = 0
+	CompressionGZIP   = 1
+	CompressionSnappy = 2
+	CompressionLZ4    = 3
+	CompressionZSTD   = 4
+
+	recordBatchMagic = 2
+	// BaseOffset => int64, BatchLength => int32, PartitionLeaderEpoch => int32, Magic => int8, CRC => uint32, Attributes => int16,
+	// LastOffsetDelta => int32, FirstTimestamp => int64, MaxTimestamp => int64, ProducerId => int64, ProducerEpoch => int16,
+	// BaseSequence => int32, RecordsCount => int32
+	recordBatchHeaderLength     = 61
+	recordBatchLengthOffset     = 8
+	recordBatchMagicOffset      = 16
+	recordBatchCRCOffset        = 17
+	recordBatchAttributesOffset = 21
+	recordBatchCountOffset      = 57
+
+	recordBatchCompressionMask = 0x07
+	recordBatchControlFlag     = 0x20
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var compressionNames = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+
+// CompressionName returns the name of the compression codec of the record batch attributes
+func CompressionName(compression int) string {
+	if compression >= 0 && compression < len(compressionNames) {
+		return compressionNames[compression]
+	}
+	return fmt.Sprintf("%d", compression)
+}
+
+type RecordHeader struct {
+	Key   []byte
+	Value []byte
+}
+
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int64
+	Key            []byte
+	Value          []byte
+	Headers        []RecordHeader
+}
+
+// RecordBatch is a batch of the message format v2. The records are decoded only for not control batches
+// which are not compressed or compressed with gzip, otherwise Records is nil and the batch is encoded unchanged.
+type RecordBatch struct {
+	raw     []byte
+	Records []*Record
+	// compression of the encoded records
+	compression int
+}
+
+func (b *RecordBatch) Magic() int8 {
+	return int8(b.raw[recordBatchMagicOffset])
+}
+
+func (b *RecordBatch) Attributes() int16 {
+	if b.Magic() != recordBatchMagic {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b.raw[recordBatchAttributesOffset:]))
+}
+
+func (b *RecordBatch) Compression() int {
+	return int(b.Attributes() & recordBatchCompressionMask)
+}
+
+func (b *RecordBatch) Control() bool {
+	return b.Attributes()&recordBatchControlFlag != 0
+}
+
+// SetCompression sets the compression of the encoded records, only CompressionNone and CompressionGZIP are supported.
+// The decoded batches are encoded uncompressed by default.
+func (b *RecordBatch) SetCompression(compression int) error {
+	if compression != CompressionNone && compression != CompressionGZIP {
+		return fmt.Errorf("compression %s is not supported", CompressionName(compression))
+	}
+	b.compression = compression
+	return nil
+}
+
+// DecodeRecordBatches decodes the batches of a record set. A partial batch at the end of the record set (fetch responses
+// are truncated by max bytes) is returned as rest.
+func DecodeRecordBatches(recordSet []byte) (batches []*RecordBatch, rest []byte, err error) {
+	batches = make([]*RecordBatch, 0)
+	for len(recordSet) != 0 {
+		if len(recordSet) < recordBatchMagicOffset+1 {
+			return batches, recordSet, nil
+		}
+		batchLength := int(int32(binary.BigEndian.Uint32(recordSet[recordBatchLengthOffset:])))
+		if batchLength < 0 {
+			return nil, nil, PacketDecodingError{Info: fmt.Sprintf("invalid batch length %d", batchLength)}
+		}
+		size := recordBatchLengthOffset + 4 + batchLength
+		if size > len(recordSet) {
+			return batches, recordSet, nil
+		}
+		batch := &RecordBatch{raw: recordSet[:size]}
+		if batch.Magic() == recordBatchMagic {
+			if size < recordBatchHeaderLength {
+				return nil, nil, PacketDecodingError{Info: fmt.Sprintf("batch of length %d is too short", size)}
+			}
+			if !batch.Control() && (batch.Compression() == CompressionNone || batch.Compression() == CompressionGZIP) {
+				if batch.Records, err = batch.decodeRecords(); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		batches = append(batches, batch)
+		recordSet = recordSet[size:]
+	}
+	return batches, nil, nil
+}
+
+// CountRecords returns the number of records of the complete batches of the record set. The messages of the message format v0 and v1
+// are counted as records, a compressed message set is counted as one record.
+func CountRecords(recordSet []byte) (int, error) {
+	count := 0
+	for len(recordSet) >= recordBatchMagicOffset+1 {
+		batchLength := int(int32(binary.BigEndian.Uint32(recordSet[recordBatchLengthOffset:])))
+		if batchLength < 0 {
+			return 0, PacketDecodingError{Info: fmt.Sprintf("invalid batch length %d", batchLength)}
+		}
+		size := recordBatchLengthOffset + 4 + batchLength
+		if size > len(recordSet) {
+			break
+		}
+		if recordSet[recordBatchMagicOffset] == recordBatchMagic {
+			if size < recordBatchHeaderLength {
+				return 0, PacketDecodingError{Info: fmt.Sprintf("batch of length %d is too short", size)}
+			}
+			count += int(int32(binary.BigEndian.Uint32(recordSet[recordBatchCountOffset:])))
+		} else {
+			count++
+		}
+		recordSet = recordSet[size:]
+	}
+	return count, nil
+}
+
+// EncodeRecordBatches encodes the batches followed by rest. The decoded batches are written with their compression set by SetCompression.
+func EncodeRecordBatches(batches []*RecordBatch, rest []byte) []byte {
+	var buf bytes.Buffer
+	for _, batch := range batches {
+		if batch.Records == nil {
+			buf.Write(batch.raw)
+			continue
+		}
+		buf.Write(batch.encode())
+	}
+	buf.Write(rest)
+	return buf.Bytes()
+}
+
+func (b *RecordBatch) decodeRecords() ([]*Record, error) {
+	count := int(int32(binary.BigEndian.Uint32(b.raw[recordBatchCountOffset:])))
+	if count < 0 {
+		return nil, PacketDecodingError{Info: fmt.Sprintf("invalid records count %d", count)}
+	}
+	raw := b.raw[recordBatchHeaderLength:]
+	if b.Compression() == CompressionGZIP {
+		reader, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = ioutil.ReadAll(io.LimitReader(reader, int64(MaxResponseSize))); err != nil {
+			return nil, err
+		}
+	}
+	// We could allocate the capacity at once, but in case of malformed payload we could allocate too much memory.
+	records := make([]*Record, 0)
+	pd := &realDecoder{raw: raw}
+	for i := 0; i < count; i++ {
+		record, err := decodeRecord(pd)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if pd.remaining() != 0 {
+		return nil, PacketDecodingError{Info: "invalid length of records"}
+	}
+	return records, nil
+}
+
+func (b *RecordBatch) encode() []byte {
+	var records bytes.Buffer
+	for _, record := range b.Records {
+		record.encode(&records)
+	}
+	payload := records.Bytes()
+	if b.compression == CompressionGZIP {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		// writes to the buffer do not fail
+		writer.Write(payload)
+		writer.Close()
+		payload = compressed.Bytes()
+	}
+	raw := make([]byte, recordBatchHeaderLength, recordBatchHeaderLength+len(payload))
+	copy(raw, b.raw[:recordBatchHeaderLength])
+	raw = append(raw, payload...)
+
+	binary.BigEndian.PutUint32(raw[recordBatchLengthOffset:], uint32(len(raw)-recordBatchLengthOffset-4))
+	binary.BigEndian.PutUint16(raw[recordBatchAttributesOffset:], uint16(b.Attributes()&^recordBatchCompressionMask|int16(b.compression)))
+	binary.BigEndian.PutUint32(raw[recordBatchCountOffset:], uint32(len(b.Records)))
+	binary.BigEndian.PutUint32(raw[recordBatchCRCOffset:], crc32.Checksum(raw[recordBatchAttributesOffset:], crc32cTable))
+	return raw
+}
+
+func decodeRecord(pd *realDecoder) (*Record, error) {
+	length, err := pd.getVarint()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || int(length) > pd.remaining() {
+		return nil, PacketDecodingError{Info: fmt.Sprintf("invalid record length %d", length)}
+	}
+	end := pd.off + int(length)
+
+	record := &Record{}
+	if record.Attributes, err = pd.getInt8(); err != nil {
+		return nil, err
+	}
+	if record.TimestampDelta, err = pd.getVarint(); err != nil {
+		return nil, err
+	}
+	if record.OffsetDelta, err = pd.getVarint(); err != nil {
+		return nil, err
+	}
+	if record.Key, err = getVarintBytes(pd); err != nil {
+		return nil, err
+	}
+	if record.Value, err = getVarintBytes(pd); err != nil {
+		return nil, err
+	}
+	headersCount, err := pd.getVarint()
+	if err != nil {
+		return nil, err
+	}
+	if headersCount < 0 {
+		return nil, PacketDecodingError{Info: fmt.Sprintf("invalid headers count %d", headersCount)}
+	}
+	record.Headers = make([]RecordHeader, 0)
+	for i := int64(0); i < headersCount; i++ {
+		var header RecordHeader
+		if header.Key, err = getVarintBytes(pd); err != nil {
+			return nil, err
+		}
+		if header.Value, err = getVarintBytes(pd); err != nil {
+			return nil, err
+		}
+		record.Headers = append(record.Headers, header)
+	}
+	if pd.off != end {
+		return nil, PacketDecodingError{Info: "invalid record length"}
+	}
+	return record, nil
+}
+
+func (r *Record) encode(buf *bytes.Buffer) {
+	var body bytes.Buffer
+	body.WriteByte(byte(r.Attributes))
+	putVarint(&body, r.TimestampDelta)
+	putVarint(&body, r.OffsetDelta)
+	putVarintBytes(&body, r.Key)
+	putVarintBytes(&body, r.Value)
+	putVarint(&body, int64(len(r.Headers)))
+	for _, header := range r.Headers {
+		putVarintBytes(&body, header.Key)
+		putVarintBytes(&body, header.Value)
+	}
+	putVarint(buf, int64(body.Len()))
+	buf.Write(body.Bytes())
+}
+
+func getVarintBytes(pd *realDecoder) ([]byte, error) {
+	length, err := pd.getVarint()
+	if err != nil {
+		return nil, err
+	}
+	if length == -1 {
+		return nil, nil
+	}
+	return pd.getRawBytes(int(length))
+}
+
+func putVarint(buf *bytes.Buffer, in int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], in)])
+}
+
+func putVarintBytes(buf *bytes.Buffer, in []byte) {
+	if in == nil {
+		putVarint(buf, -1)
+		return
+	}
+	putVarint(buf, int64(len(in)))
+	buf.Write(in)
+}
+
+// EncodeRecordBatch encodes the records as an uncompressed batch of a producer without idempotence. The offset deltas
+// of the records are set, their timestamp deltas are the milliseconds since the first timestamp.
+func EncodeRecordBatch(firstTimestamp int64, records []*Record) []byte {
+	maxTimestamp := firstTimestamp
+	for i, record := range records {
+		record.OffsetDelta = int64(i)
+		if firstTimestamp+record.TimestampDelta > maxTimestamp {
+			maxTimestamp = firstTimestamp + record.TimestampDelta
+		}
+	}
+	// PartitionLeaderEpoch, ProducerId, ProducerEpoch and BaseSequence are -1, the length, the CRC and the count are set by encode
+	header := make([]byte, recordBatchHeaderLength)
+	binary.BigEndian.PutUint32(header[12:], 0xffffffff)
+	header[recordBatchMagicOffset] = recordBatchMagic
+	binary.BigEndian.PutUint32(header[23:], uint32(len(records)-1))
+	binary.BigEndian.PutUint64(header[27:], uint64(firstTimestamp))
+	binary.BigEndian.PutUint64(header[35:], uint64(maxTimestamp))
+	for i := 43; i < recordBatchCountOffset; i++ {
+		header[i] = 0xff
+	}
+	return (&RecordBatch{raw: header, Records: records}).encode()
+}
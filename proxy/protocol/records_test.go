@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"testing"
+)
+
+func testRecordBatchHeader(baseOffset int64, attributes int16) []byte {
+	header := make([]byte, recordBatchHeaderLength)
+	binary.BigEndian.PutUint64(header, uint64(baseOffset))
+	header[recordBatchMagicOffset] = recordBatchMagic
+	binary.BigEndian.PutUint16(header[recordBatchAttributesOffset:], uint16(attributes))
+	return header
+}
+
+func testRecordBatch(baseOffset int64, records ...*Record) []byte {
+	batch := &RecordBatch{raw: testRecordBatchHeader(baseOffset, 0), Records: records}
+	return batch.encode()
+}
+
+func TestRecordBatchesRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	records := []*Record{
+		{OffsetDelta: 0, Key: []byte("k1"), Value: []byte("v1"), Headers: []RecordHeader{{Key: []byte("h"), Value: nil}}},
+		{OffsetDelta: 1, TimestampDelta: 300, Key: nil, Value: nil, Headers: []RecordHeader{}},
+	}
+	recordSet := append(testRecordBatch(10, records...), testRecordBatch(12, records[0])...)
+	// partial batch
+	recordSet = append(recordSet, testRecordBatch(13, records[0])[:20]...)
+
+	batches, rest, err := DecodeRecordBatches(recordSet)
+	a.Nil(err)
+	a.Len(batches, 2)
+	a.Len(rest, 20)
+	a.Equal(records, batches[0].Records)
+	a.Equal(int8(2), batches[0].Magic())
+	a.False(batches[0].Control())
+	a.Equal(recordSet, EncodeRecordBatches(batches, rest))
+
+	raw := batches[0].encode()
+	a.Equal(crc32.Checksum(raw[recordBatchAttributesOffset:], crc32cTable), binary.BigEndian.Uint32(raw[recordBatchCRCOffset:]))
+	a.Equal(uint32(len(raw)-12), binary.BigEndian.Uint32(raw[recordBatchLengthOffset:]))
+
+	batches[0].Records[0].Value = []byte("value")
+	batches, rest, err = DecodeRecordBatches(EncodeRecordBatches(batches, nil))
+	a.Nil(err)
+	a.Nil(rest)
+	a.Equal([]byte("value"), batches[0].Records[0].Value)
+}
+
+func TestRecordBatchesCompression(t *testing.T) {
+	a := assert.New(t)
+
+	var records bytes.Buffer
+	(&Record{Value: []byte("v1")}).encode(&records)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(records.Bytes())
+	writer.Close()
+
+	gzipBatch := append(testRecordBatchHeader(0, CompressionGZIP), compressed.Bytes()...)
+	binary.BigEndian.PutUint32(gzipBatch[recordBatchLengthOffset:], uint32(len(gzipBatch)-12))
+	binary.BigEndian.PutUint32(gzipBatch[recordBatchCountOffset:], 1)
+	// lz4 is not supported
+	lz4Batch := append(testRecordBatchHeader(1, 3), 0x01, 0x02)
+	binary.BigEndian.PutUint32(lz4Batch[recordBatchLengthOffset:], uint32(len(lz4Batch)-12))
+
+	batches, rest, err := DecodeRecordBatches(append(gzipBatch, lz4Batch...))
+	a.Nil(err)
+	a.Nil(rest)
+	a.Len(batches, 2)
+	a.Equal(CompressionGZIP, batches[0].Compression())
+	a.Equal([]byte("v1"), batches[0].Records[0].Value)
+	a.Equal(3, batches[1].Compression())
+	a.Nil(batches[1].Records)
+
+	// decoded batches are written uncompressed
+	batches, _, err = DecodeRecordBatches(EncodeRecordBatches(batches, rest))
+	a.Nil(err)
+	a.Equal(CompressionNone, batches[0].Compression())
+	a.Equal([]byte("v1"), batches[0].Records[0].Value)
+	a.Equal(lz4Batch, batches[1].raw)
+
+	// the records are compressed again with gzip
+	a.Nil(batches[0].SetCompression(CompressionGZIP))
+	a.EqualError(batches[1].SetCompression(CompressionZSTD), "compression zstd is not supported")
+	raw := batches[0].encode()
+	a.Equal(crc32.Checksum(raw[recordBatchAttributesOffset:], crc32cTable), binary.BigEndian.Uint32(raw[recordBatchCRCOffset:]))
+	batches, _, err = DecodeRecordBatches(EncodeRecordBatches(batches, nil))
+	a.Nil(err)
+	a.Equal(CompressionGZIP, batches[0].Compression())
+	a.Equal([]byte("v1"), batches[0].Records[0].Value)
+	a.Equal("lz4", CompressionName(batches[1].Compression()))
+	a.Equal("7", CompressionName(7))
+}
+
+func TestDecodeRecordBatchesInvalid(t *testing.T) {
+	a := assert.New(t)
+
+	batch := testRecordBatch(0, &Record{Value: []byte("v1")})
+	binary.BigEndian.PutUint32(batch[recordBatchCountOffset:], 2)
+	_, _, err := DecodeRecordBatches(batch)
+	a.NotNil(err)
+
+	batch = testRecordBatch(0, &Record{Value: []byte("v1")})
+	binary.BigEndian.PutUint32(batch[recordBatchLengthOffset:], 0xffffffff)
+	_, _, err = DecodeRecordBatches(batch)
+	a.NotNil(err)
+}
+
+func TestCountRecords(t *testing.T) {
+	a := assert.New(t)
+
+	record := &Record{Value: []byte("v1")}
+	// lz4 batch with 3 records
+	lz4Batch := append(testRecordBatchHeader(3, 3), 0x01, 0x02)
+	binary.BigEndian.PutUint32(lz4Batch[recordBatchLengthOffset:], uint32(len(lz4Batch)-12))
+	binary.BigEndian.PutUint32(lz4Batch[recordBatchCountOffset:], 3)
+	// message format v1
+	message := make([]byte, 26)
+	binary.BigEndian.PutUint32(message[recordBatchLengthOffset:], 14)
+	message[recordBatchMagicOffset] = 1
+
+	recordSet := append(testRecordBatch(0, record, record), lz4Batch...)
+	recordSet = append(recordSet, message...)
+	// partial batch
+	recordSet = append(recordSet, testRecordBatch(6, record)[:20]...)
+	count, err := CountRecords(recordSet)
+	a.Nil(err)
+	a.Equal(6, count)
+
+	count, err = CountRecords(nil)
+	a.Nil(err)
+	a.Equal(0, count)
+
+	binary.BigEndian.PutUint32(lz4Batch[recordBatchLengthOffset:], 0xffffffff)
+	_, err = CountRecords(lz4Batch)
+	a.NotNil(err)
+}
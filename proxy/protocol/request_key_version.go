@@ -1,11 +1,41 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type RequestKeyVersion struct {
 	Length     int32
 	ApiKey     int16
 	ApiVersion int16
+
+	// LocalResponse is sent to the client instead of the broker response. It is not a part of the request.
+	LocalResponse []byte
+	// TopicPrefix is removed from the topic names of the broker response. It is not a part of the request.
+	TopicPrefix string
+	// ClusterRequest is sent to the other upstream clusters whose responses are merged with the broker response. It is not a part of the request.
+	ClusterRequest []byte
+	// ProxyResponse receives the broker response to the request sent by the proxy, the response is not sent to the client. It is not a part of the request.
+	ProxyResponse chan<- []byte
+	// CacheKey is the key of the response cache the client response is put under. It is not a part of the request.
+	CacheKey string
+	// CanaryRequest is the request sent to the broker whose response is compared with the canary cluster. It is not a part of the request.
+	CanaryRequest []byte
+	// DropResponse discards the broker response, it is not sent to the client. It is not a part of the request.
+	DropResponse bool
+	// Deadline is when the TimeoutResponse is sent to the client if the broker response has not arrived, zero when the request does not time out.
+	// The broker response arriving later is discarded. It is not a part of the request.
+	Deadline        time.Time
+	TimeoutResponse []byte
+	// CorrelationID of the TimeoutResponse. It is not a part of the request.
+	CorrelationID int32
+	// OversizedResponse is sent to the client instead of the broker response larger than the max response size, the broker response is discarded.
+	// It is not a part of the request.
+	OversizedResponse []byte
+	// TraceStart is the time the request of a traced connection was read, zero when the connection is not traced.
+	// It is not a part of the request.
+	TraceStart time.Time
 }
 
 func (r *RequestKeyVersion) decode(pd packetDecoder) (err error) {
@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// the fields with the time the broker may hold the request before it responds
+var requestWaitTimeFields = map[int16]string{
+	apiKeyProduce:          "timeout",
+	apiKeyFetch:            "max_wait_time",
+	apiKeyCreateTopics:     "timeout",
+	apiKeyDeleteTopics:     "timeout",
+	apiKeyDeleteRecords:    "timeout",
+	apiKeyCreatePartitions: "timeout",
+	apiKeyElectLeaders:     "timeout",
+}
+
+// EncodeTimeoutResponse returns the REQUEST_TIMED_OUT response body to the request (without the size) and how long the broker
+// may hold the request e.g. the max wait time of Fetch or the timeout of Produce. A nil response is returned for the request
+// the REQUEST_TIMED_OUT response cannot be encoded to (unknown API or version) or the client does not expect a response to (Produce with acks 0).
+func EncodeTimeoutResponse(request []byte) ([]byte, time.Duration, error) {
+	info, body, err := decodeRequest(request)
+	if err != nil || body == nil {
+		return nil, 0, err
+	}
+	if info.ApiKey == apiKeyProduce && body.Get("acks").(int16) == 0 {
+		return nil, 0, nil
+	}
+	var waitTime time.Duration
+	if name, ok := requestWaitTimeFields[info.ApiKey]; ok {
+		if v, ok := body.Get(name).(int32); ok && v > 0 {
+			waitTime = time.Duration(v) * time.Millisecond
+		}
+	}
+	response, err := Encode(&errorResponse{info: info, body: body, errorCode: int16(ErrRequestTimedOut)})
+	if err != nil {
+		return nil, 0, err
+	}
+	return response, waitTime, nil
+}
+
+// EncodeProduceTimeoutResponse reads the Produce request up to v8 following its api key and api version from the reader and returns
+// its correlation id, the REQUEST_TIMED_OUT response and the timeout of the request. Only the topics and the partitions are kept, the
+// record sets are skipped in chunks, so the request written to the broker while it is read is not buffered. A nil response is returned
+// for the request with acks 0.
+func EncodeProduceTimeoutResponse(r io.Reader, apiVersion int16) (correlationID int32, response []byte, waitTime time.Duration, err error) {
+	if apiVersion < 0 || int(apiVersion) >= len(requestSchemaVersions[apiKeyProduce]) {
+		return 0, nil, 0, fmt.Errorf("produce request up to v%d expected, got version %d", len(requestSchemaVersions[apiKeyProduce])-1, apiVersion)
+	}
+	d := &streamDecoder{r: r}
+	if correlationID, err = d.getInt32(); err != nil {
+		return 0, nil, 0, err
+	}
+	// client_id
+	if err = d.skipNullableString(); err != nil {
+		return 0, nil, 0, err
+	}
+	if apiVersion >= 3 {
+		// transactional_id
+		if err = d.skipNullableString(); err != nil {
+			return 0, nil, 0, err
+		}
+	}
+	acks, err := d.getInt16()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	timeout, err := d.getInt32()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	topicCount, err := d.getInt32()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	var topics []topicPartitions
+	for i := int32(0); i < topicCount; i++ {
+		var topic topicPartitions
+		if topic.topic, err = d.getString(); err != nil {
+			return 0, nil, 0, err
+		}
+		var partitionCount, partition int32
+		if partitionCount, err = d.getInt32(); err != nil {
+			return 0, nil, 0, err
+		}
+		for j := int32(0); j < partitionCount; j++ {
+			if partition, err = d.getInt32(); err != nil {
+				return 0, nil, 0, err
+			}
+			topic.partitions = append(topic.partitions, partition)
+			// record_set
+			if err = d.skipBytes(); err != nil {
+				return 0, nil, 0, err
+			}
+		}
+		topics = append(topics, topic)
+	}
+	if acks == 0 {
+		return correlationID, nil, 0, nil
+	}
+	if timeout > 0 {
+		waitTime = time.Duration(timeout) * time.Millisecond
+	}
+	if response, err = Encode(&produceTimeoutResponse{version: apiVersion, topics: topics}); err != nil {
+		return 0, nil, 0, err
+	}
+	return correlationID, response, waitTime, nil
+}
+
+type produceTimeoutResponse struct {
+	version int16
+	topics  []topicPartitions
+}
+
+func (r *produceTimeoutResponse) encode(pe packetEncoder) error {
+	return (&errorResponse{errorCode: int16(ErrRequestTimedOut)}).encodeProduceTopics(pe, r.version, r.topics)
+}
+
+// streamDecoder decodes the fields of the request read from the reader one by one
+type streamDecoder struct {
+	r   io.Reader
+	buf [4]byte
+}
+
+func (d *streamDecoder) getInt16() (int16, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:2]); err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(d.buf[:2])), nil
+}
+
+func (d *streamDecoder) getInt32() (int32, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:4]); err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(d.buf[:4])), nil
+}
+
+func (d *streamDecoder) getString() (string, error) {
+	length, err := d.getInt16()
+	if err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", PacketDecodingError{fmt.Sprintf("invalid string length %d", length)}
+	}
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(d.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (d *streamDecoder) skipNullableString() error {
+	length, err := d.getInt16()
+	if err != nil || length <= 0 {
+		return err
+	}
+	return d.skip(int64(length))
+}
+
+func (d *streamDecoder) skipBytes() error {
+	length, err := d.getInt32()
+	if err != nil || length <= 0 {
+		return err
+	}
+	return d.skip(int64(length))
+}
+
+func (d *streamDecoder) skip(n int64) error {
+	if _, err := io.CopyN(ioutil.Discard, d.r, n); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
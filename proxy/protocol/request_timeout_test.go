@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEncodeTimeoutResponse(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x07,
+		0x00, 0x02, 'c', '1',
+		// transactional_id null
+		0xff, 0xff,
+		// acks, timeout
+		0xff, 0xff, 0x00, 0x00, 0x75, 0x30,
+		// topic_data
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02, 0xff, 0xff, 0xff, 0xff,
+	}
+	response, waitTime, err := EncodeTimeoutResponse(request)
+	a.Nil(err)
+	a.Equal(30*time.Second, waitTime)
+	errorCodes, err := ResponseErrorCodes(apiKeyProduce, 3, response)
+	a.Nil(err)
+	a.Equal([]KError{ErrRequestTimedOut}, errorCodes)
+
+	// acks 0
+	request[14], request[15] = 0x00, 0x00
+	response, _, err = EncodeTimeoutResponse(request)
+	a.Nil(err)
+	a.Nil(response)
+
+	// ApiVersions
+	response, _, err = EncodeTimeoutResponse([]byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.Nil(err)
+	a.Nil(response)
+
+	// Metadata v1
+	response, waitTime, err = EncodeTimeoutResponse([]byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 't', '1'})
+	a.Nil(err)
+	a.NotNil(response)
+	a.Equal(time.Duration(0), waitTime)
+}
+
+func TestEncodeProduceTimeoutResponse(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x07,
+		0x00, 0x02, 'c', '1',
+		// transactional_id
+		0x00, 0x01, 'x',
+		// acks, timeout
+		0xff, 0xff, 0x00, 0x00, 0x75, 0x30,
+		// topic_data
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x01, 0x02, 0x03,
+		0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x02, 't', '2',
+		0x00, 0x00, 0x00, 0x00,
+	}
+	expected, expectedWaitTime, err := EncodeTimeoutResponse(request)
+	a.Nil(err)
+	r := bytes.NewReader(request[4:])
+	correlationID, response, waitTime, err := EncodeProduceTimeoutResponse(r, 3)
+	a.Nil(err)
+	a.Equal(int32(7), correlationID)
+	a.Equal(expected, response)
+	a.Equal(expectedWaitTime, waitTime)
+	a.Equal(0, r.Len())
+
+	// acks 0
+	request[15], request[16] = 0x00, 0x00
+	_, response, _, err = EncodeProduceTimeoutResponse(bytes.NewReader(request[4:]), 3)
+	a.Nil(err)
+	a.Nil(response)
+
+	// the record set is cut
+	_, _, _, err = EncodeProduceTimeoutResponse(bytes.NewReader(request[4:40]), 3)
+	a.NotNil(err)
+	_, _, _, err = EncodeProduceTimeoutResponse(bytes.NewReader(request[4:]), 9)
+	a.EqualError(err, "produce request up to v8 expected, got version 9")
+}
@@ -0,0 +1,789 @@
+package protocol
+
+const (
+	apiKeyProduce                 = 0
+	apiKeyFetch                   = 1
+	apiKeyListOffsets             = 2
+	apiKeyOffsetCommit            = 8
+	apiKeyOffsetFetch             = 9
+	apiKeyCreateTopics            = 19
+	apiKeyDeleteTopics            = 20
+	apiKeyDeleteRecords           = 21
+	apiKeyOffsetForLeaderEpoch    = 23
+	apiKeyAddPartitionsToTxn      = 24
+	apiKeyTxnOffsetCommit         = 28
+	apiKeyDescribeConfigs         = 32
+	apiKeyAlterConfigs            = 33
+	apiKeyCreatePartitions        = 37
+	apiKeyElectLeaders            = 43
+	apiKeyIncrementalAlterConfigs = 44
+
+	topicKeyName           = "topic"
+	topicsKeyName          = "topics"
+	topicPartitionsKeyName = "topic_partitions"
+
+	resourceTypeTopic = 2
+)
+
+var (
+	requestHeaderSchema = NewSchema("request_header_v1",
+		&field{name: "api_key", ty: typeInt16},
+		&field{name: "api_version", ty: typeInt16},
+		&field{name: "correlation_id", ty: typeInt32},
+		&field{name: "client_id", ty: typeNullableStr},
+	)
+
+	requestSchemaVersions = map[int16][]Schema{
+		apiKeyProduce:       createProduceRequestSchemaVersions(),
+		apiKeyFetch:         createFetchRequestSchemaVersions(),
+		apiKeyListOffsets:   createListOffsetsRequestSchemaVersions(),
+		apiKeyMetadata:      createMetadataRequestSchemaVersions(),
+		apiKeyOffsetCommit:  createOffsetCommitRequestSchemaVersions(),
+		apiKeyOffsetFetch:   createOffsetFetchRequestSchemaVersions(),
+		apiKeyCreateTopics:  createCreateTopicsRequestSchemaVersions(),
+		apiKeyDeleteTopics:  createDeleteTopicsRequestSchemaVersions(),
+		apiKeyDeleteRecords: createDeleteRecordsRequestSchemaVersions(),
+
+		apiKeyOffsetForLeaderEpoch:    createOffsetForLeaderEpochRequestSchemaVersions(),
+		apiKeyAddPartitionsToTxn:      createAddPartitionsToTxnRequestSchemaVersions(),
+		apiKeyTxnOffsetCommit:         createTxnOffsetCommitRequestSchemaVersions(),
+		apiKeyDescribeConfigs:         createDescribeConfigsRequestSchemaVersions(),
+		apiKeyAlterConfigs:            createAlterConfigsRequestSchemaVersions(),
+		apiKeyCreatePartitions:        createCreatePartitionsRequestSchemaVersions(),
+		apiKeyElectLeaders:            createElectLeadersRequestSchemaVersions(),
+		apiKeyIncrementalAlterConfigs: createIncrementalAlterConfigsRequestSchemaVersions(),
+	}
+
+	// requests which never reference topics
+	requestsWithoutTopics = map[int16]struct{}{
+		10: {}, // FindCoordinator
+		11: {}, // JoinGroup
+		12: {}, // Heartbeat
+		13: {}, // LeaveGroup
+		14: {}, // SyncGroup
+		15: {}, // DescribeGroups
+		16: {}, // ListGroups
+		17: {}, // SaslHandshake
+		18: {}, // ApiVersions
+		22: {}, // InitProducerId
+		25: {}, // AddOffsetsToTxn
+		26: {}, // EndTxn
+		36: {}, // SaslAuthenticate
+		38: {}, // CreateDelegationToken
+		39: {}, // RenewDelegationToken
+		40: {}, // ExpireDelegationToken
+		41: {}, // DescribeDelegationToken
+		42: {}, // DeleteGroups
+	}
+)
+
+func createProduceRequestSchemaVersions() []Schema {
+	partitionDataV0 := NewSchema("partition_data_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "record_set", ty: typeBytes},
+	)
+
+	topicDataV0 := NewSchema("topic_data_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "data", ty: partitionDataV0},
+	)
+
+	produceRequestV0 := NewSchema("produce_request_v0",
+		&field{name: "acks", ty: typeInt16},
+		&field{name: "timeout", ty: typeInt32},
+		&array{name: "topic_data", ty: topicDataV0},
+	)
+
+	produceRequestV3 := NewSchema("produce_request_v3",
+		&field{name: "transactional_id", ty: typeNullableStr},
+		&field{name: "acks", ty: typeInt16},
+		&field{name: "timeout", ty: typeInt32},
+		&array{name: "topic_data", ty: topicDataV0},
+	)
+
+	return []Schema{produceRequestV0, produceRequestV0, produceRequestV0, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3, produceRequestV3}
+}
+
+func createFetchRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("fetch_request_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	partitionV5 := NewSchema("fetch_request_partition_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	partitionV9 := NewSchema("fetch_request_partition_v9",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "current_leader_epoch", ty: typeInt32},
+		&field{name: "fetch_offset", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&field{name: "max_bytes", ty: typeInt32},
+	)
+
+	topicV0 := NewSchema("fetch_request_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV5 := NewSchema("fetch_request_topic_v5",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV5},
+	)
+
+	topicV9 := NewSchema("fetch_request_topic_v9",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV9},
+	)
+
+	forgottenTopicV7 := NewSchema("forgotten_topic_data_v7",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	fetchRequestV0 := NewSchema("fetch_request_v0",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	fetchRequestV3 := NewSchema("fetch_request_v3",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	fetchRequestV4 := NewSchema("fetch_request_v4",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	fetchRequestV5 := NewSchema("fetch_request_v5",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: topicsKeyName, ty: topicV5},
+	)
+
+	fetchRequestV7 := NewSchema("fetch_request_v7",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV5},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+	)
+
+	fetchRequestV9 := NewSchema("fetch_request_v9",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV9},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+	)
+
+	fetchRequestV11 := NewSchema("fetch_request_v11",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "max_wait_time", ty: typeInt32},
+		&field{name: "min_bytes", ty: typeInt32},
+		&field{name: "max_bytes", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&field{name: "session_id", ty: typeInt32},
+		&field{name: "session_epoch", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV9},
+		&array{name: "forgotten_topics_data", ty: forgottenTopicV7},
+		&field{name: "rack_id", ty: typeStr},
+	)
+
+	return []Schema{fetchRequestV0, fetchRequestV0, fetchRequestV0, fetchRequestV3, fetchRequestV4, fetchRequestV5, fetchRequestV5, fetchRequestV7, fetchRequestV7, fetchRequestV9, fetchRequestV9, fetchRequestV11}
+}
+
+func createListOffsetsRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("list_offsets_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "max_num_offsets", ty: typeInt32},
+	)
+
+	partitionV1 := NewSchema("list_offsets_partition_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+	)
+
+	partitionV4 := NewSchema("list_offsets_partition_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "current_leader_epoch", ty: typeInt32},
+		&field{name: "timestamp", ty: typeInt64},
+	)
+
+	topicV0 := NewSchema("list_offsets_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV1 := NewSchema("list_offsets_topic_v1",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV1},
+	)
+
+	topicV4 := NewSchema("list_offsets_topic_v4",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV4},
+	)
+
+	listOffsetsRequestV0 := NewSchema("list_offsets_request_v0",
+		&field{name: "replica_id", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	listOffsetsRequestV1 := NewSchema("list_offsets_request_v1",
+		&field{name: "replica_id", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV1},
+	)
+
+	listOffsetsRequestV2 := NewSchema("list_offsets_request_v2",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: topicsKeyName, ty: topicV1},
+	)
+
+	listOffsetsRequestV4 := NewSchema("list_offsets_request_v4",
+		&field{name: "replica_id", ty: typeInt32},
+		&field{name: "isolation_level", ty: typeInt8},
+		&array{name: topicsKeyName, ty: topicV4},
+	)
+
+	return []Schema{listOffsetsRequestV0, listOffsetsRequestV1, listOffsetsRequestV2, listOffsetsRequestV2, listOffsetsRequestV4, listOffsetsRequestV4}
+}
+
+func createMetadataRequestSchemaVersions() []Schema {
+	metadataRequestV0 := NewSchema("metadata_request_v0",
+		&array{name: topicsKeyName, ty: typeStr},
+	)
+
+	metadataRequestV4 := NewSchema("metadata_request_v4",
+		&array{name: topicsKeyName, ty: typeStr},
+		&field{name: "allow_auto_topic_creation", ty: typeBool},
+	)
+
+	metadataRequestV8 := NewSchema("metadata_request_v8",
+		&array{name: topicsKeyName, ty: typeStr},
+		&field{name: "allow_auto_topic_creation", ty: typeBool},
+		&field{name: "include_cluster_authorized_operations", ty: typeBool},
+		&field{name: "include_topic_authorized_operations", ty: typeBool},
+	)
+
+	return []Schema{metadataRequestV0, metadataRequestV0, metadataRequestV0, metadataRequestV0, metadataRequestV4, metadataRequestV4, metadataRequestV4, metadataRequestV4, metadataRequestV8}
+}
+
+func createOffsetCommitRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_commit_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	partitionV1 := NewSchema("offset_commit_partition_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	partitionV6 := NewSchema("offset_commit_partition_v6",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "leader_epoch", ty: typeInt32},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	topicV0 := NewSchema("offset_commit_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV1 := NewSchema("offset_commit_topic_v1",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV1},
+	)
+
+	topicV6 := NewSchema("offset_commit_topic_v6",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV6},
+	)
+
+	offsetCommitRequestV0 := NewSchema("offset_commit_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetCommitRequestV1 := NewSchema("offset_commit_request_v1",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: topicsKeyName, ty: topicV1},
+	)
+
+	offsetCommitRequestV2 := NewSchema("offset_commit_request_v2",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "retention_time", ty: typeInt64},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetCommitRequestV5 := NewSchema("offset_commit_request_v5",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetCommitRequestV6 := NewSchema("offset_commit_request_v6",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: topicsKeyName, ty: topicV6},
+	)
+
+	offsetCommitRequestV7 := NewSchema("offset_commit_request_v7",
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&array{name: topicsKeyName, ty: topicV6},
+	)
+
+	return []Schema{offsetCommitRequestV0, offsetCommitRequestV1, offsetCommitRequestV2, offsetCommitRequestV2, offsetCommitRequestV2, offsetCommitRequestV5, offsetCommitRequestV6, offsetCommitRequestV7}
+}
+
+func createOffsetFetchRequestSchemaVersions() []Schema {
+	topicV0 := NewSchema("offset_fetch_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	offsetFetchRequestV0 := NewSchema("offset_fetch_request_v0",
+		&field{name: "group_id", ty: typeStr},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	return []Schema{offsetFetchRequestV0, offsetFetchRequestV0, offsetFetchRequestV0, offsetFetchRequestV0, offsetFetchRequestV0, offsetFetchRequestV0}
+}
+
+func createCreateTopicsRequestSchemaVersions() []Schema {
+	replicaAssignmentV0 := NewSchema("replica_assignment_v0",
+		&field{name: "partition", ty: typeInt32},
+		&array{name: "replicas", ty: typeInt32},
+	)
+
+	configEntryV0 := NewSchema("config_entry_v0",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+	)
+
+	createTopicV0 := NewSchema("create_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "num_partitions", ty: typeInt32},
+		&field{name: "replication_factor", ty: typeInt16},
+		&array{name: "replica_assignment", ty: replicaAssignmentV0},
+		&array{name: "config_entries", ty: configEntryV0},
+	)
+
+	createTopicsRequestV0 := NewSchema("create_topics_request_v0",
+		&array{name: "create_topic_requests", ty: createTopicV0},
+		&field{name: "timeout", ty: typeInt32},
+	)
+
+	createTopicsRequestV1 := NewSchema("create_topics_request_v1",
+		&array{name: "create_topic_requests", ty: createTopicV0},
+		&field{name: "timeout", ty: typeInt32},
+		&field{name: "validate_only", ty: typeBool},
+	)
+
+	return []Schema{createTopicsRequestV0, createTopicsRequestV1, createTopicsRequestV1, createTopicsRequestV1, createTopicsRequestV1}
+}
+
+func createDeleteTopicsRequestSchemaVersions() []Schema {
+	deleteTopicsRequestV0 := NewSchema("delete_topics_request_v0",
+		&array{name: topicsKeyName, ty: typeStr},
+		&field{name: "timeout", ty: typeInt32},
+	)
+
+	return []Schema{deleteTopicsRequestV0, deleteTopicsRequestV0, deleteTopicsRequestV0, deleteTopicsRequestV0}
+}
+
+func createDeleteRecordsRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("delete_records_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+	)
+
+	topicV0 := NewSchema("delete_records_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	deleteRecordsRequestV0 := NewSchema("delete_records_request_v0",
+		&array{name: topicsKeyName, ty: topicV0},
+		&field{name: "timeout", ty: typeInt32},
+	)
+
+	return []Schema{deleteRecordsRequestV0, deleteRecordsRequestV0}
+}
+
+func createOffsetForLeaderEpochRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_for_leader_epoch_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "leader_epoch", ty: typeInt32},
+	)
+
+	partitionV2 := NewSchema("offset_for_leader_epoch_partition_v2",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "current_leader_epoch", ty: typeInt32},
+		&field{name: "leader_epoch", ty: typeInt32},
+	)
+
+	topicV0 := NewSchema("offset_for_leader_epoch_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV2 := NewSchema("offset_for_leader_epoch_topic_v2",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV2},
+	)
+
+	offsetForLeaderEpochRequestV0 := NewSchema("offset_for_leader_epoch_request_v0",
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetForLeaderEpochRequestV2 := NewSchema("offset_for_leader_epoch_request_v2",
+		&array{name: topicsKeyName, ty: topicV2},
+	)
+
+	offsetForLeaderEpochRequestV3 := NewSchema("offset_for_leader_epoch_request_v3",
+		&field{name: "replica_id", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV2},
+	)
+
+	return []Schema{offsetForLeaderEpochRequestV0, offsetForLeaderEpochRequestV0, offsetForLeaderEpochRequestV2, offsetForLeaderEpochRequestV3}
+}
+
+func createAddPartitionsToTxnRequestSchemaVersions() []Schema {
+	topicV0 := NewSchema("add_partitions_to_txn_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: typeInt32},
+	)
+
+	addPartitionsToTxnRequestV0 := NewSchema("add_partitions_to_txn_request_v0",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	return []Schema{addPartitionsToTxnRequestV0, addPartitionsToTxnRequestV0, addPartitionsToTxnRequestV0}
+}
+
+func createTxnOffsetCommitRequestSchemaVersions() []Schema {
+	partitionV0 := NewSchema("txn_offset_commit_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	partitionV2 := NewSchema("txn_offset_commit_partition_v2",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "leader_epoch", ty: typeInt32},
+		&field{name: "metadata", ty: typeNullableStr},
+	)
+
+	topicV0 := NewSchema("txn_offset_commit_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV2 := NewSchema("txn_offset_commit_topic_v2",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV2},
+	)
+
+	txnOffsetCommitRequestV0 := NewSchema("txn_offset_commit_request_v0",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	txnOffsetCommitRequestV2 := NewSchema("txn_offset_commit_request_v2",
+		&field{name: "transactional_id", ty: typeStr},
+		&field{name: "group_id", ty: typeStr},
+		&field{name: "producer_id", ty: typeInt64},
+		&field{name: "producer_epoch", ty: typeInt16},
+		&array{name: topicsKeyName, ty: topicV2},
+	)
+
+	return []Schema{txnOffsetCommitRequestV0, txnOffsetCommitRequestV0, txnOffsetCommitRequestV2}
+}
+
+func createDescribeConfigsRequestSchemaVersions() []Schema {
+	resourceV0 := NewSchema("describe_configs_resource_v0",
+		&field{name: "resource_type", ty: typeInt8},
+		&field{name: "resource_name", ty: typeStr},
+		&array{name: "config_names", ty: typeStr},
+	)
+
+	describeConfigsRequestV0 := NewSchema("describe_configs_request_v0",
+		&array{name: "resources", ty: resourceV0},
+	)
+
+	describeConfigsRequestV1 := NewSchema("describe_configs_request_v1",
+		&array{name: "resources", ty: resourceV0},
+		&field{name: "include_synonyms", ty: typeBool},
+	)
+
+	describeConfigsRequestV3 := NewSchema("describe_configs_request_v3",
+		&array{name: "resources", ty: resourceV0},
+		&field{name: "include_synonyms", ty: typeBool},
+		&field{name: "include_documentation", ty: typeBool},
+	)
+
+	return []Schema{describeConfigsRequestV0, describeConfigsRequestV1, describeConfigsRequestV1, describeConfigsRequestV3}
+}
+
+func createAlterConfigsRequestSchemaVersions() []Schema {
+	configEntryV0 := NewSchema("alter_configs_entry_v0",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+	)
+
+	resourceV0 := NewSchema("alter_configs_resource_v0",
+		&field{name: "resource_type", ty: typeInt8},
+		&field{name: "resource_name", ty: typeStr},
+		&array{name: "config_entries", ty: configEntryV0},
+	)
+
+	alterConfigsRequestV0 := NewSchema("alter_configs_request_v0",
+		&array{name: "resources", ty: resourceV0},
+		&field{name: "validate_only", ty: typeBool},
+	)
+
+	return []Schema{alterConfigsRequestV0, alterConfigsRequestV0}
+}
+
+func createIncrementalAlterConfigsRequestSchemaVersions() []Schema {
+	configV0 := NewSchema("incremental_alter_configs_config_v0",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_operation", ty: typeInt8},
+		&field{name: "config_value", ty: typeNullableStr},
+	)
+
+	resourceV0 := NewSchema("incremental_alter_configs_resource_v0",
+		&field{name: "resource_type", ty: typeInt8},
+		&field{name: "resource_name", ty: typeStr},
+		&array{name: "configs", ty: configV0},
+	)
+
+	incrementalAlterConfigsRequestV0 := NewSchema("incremental_alter_configs_request_v0",
+		&array{name: "resources", ty: resourceV0},
+		&field{name: "validate_only", ty: typeBool},
+	)
+
+	return []Schema{incrementalAlterConfigsRequestV0}
+}
+
+func createCreatePartitionsRequestSchemaVersions() []Schema {
+	newPartitionsV0 := NewSchema("create_partitions_new_partitions_v0",
+		&field{name: "count", ty: typeInt32},
+		&array{name: "assignment", ty: &array{name: "broker_ids", ty: typeInt32}},
+	)
+
+	topicPartitionV0 := NewSchema("create_partitions_topic_partition_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "new_partitions", ty: newPartitionsV0},
+	)
+
+	createPartitionsRequestV0 := NewSchema("create_partitions_request_v0",
+		&array{name: topicPartitionsKeyName, ty: topicPartitionV0},
+		&field{name: "timeout", ty: typeInt32},
+		&field{name: "validate_only", ty: typeBool},
+	)
+
+	return []Schema{createPartitionsRequestV0, createPartitionsRequestV0}
+}
+
+func createElectLeadersRequestSchemaVersions() []Schema {
+	topicPartitionsV0 := NewSchema("elect_leaders_topic_partitions_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partition_id", ty: typeInt32},
+	)
+
+	electLeadersRequestV0 := NewSchema("elect_leaders_request_v0",
+		&array{name: topicPartitionsKeyName, ty: topicPartitionsV0},
+		&field{name: "timeout", ty: typeInt32},
+	)
+
+	electLeadersRequestV1 := NewSchema("elect_leaders_request_v1",
+		&field{name: "election_type", ty: typeInt8},
+		&array{name: topicPartitionsKeyName, ty: topicPartitionsV0},
+		&field{name: "timeout", ty: typeInt32},
+	)
+
+	return []Schema{electLeadersRequestV0, electLeadersRequestV1}
+}
+
+// RequestInfo describes a request sent by a client.
+type RequestInfo struct {
+	ApiKey        int16
+	ApiVersion    int16
+	CorrelationID int32
+	ClientID      string
+	Topics        []string
+	// TopicsUnknown is set when the topics could not be decoded (unsupported API or version) or the request refers to all topics
+	TopicsUnknown bool
+}
+
+// DecodeRequestInfo decodes the request header and the names of the topics referenced by the request.
+// The request starts with the header (ApiKey, ApiVersion, CorrelationId, ClientId) and does not contain the size.
+// Requests which are known not to reference topics have an empty list. For the requests without a decoder
+// (unknown API, newer or flexible version) only the header is decoded and TopicsUnknown is set.
+func DecodeRequestInfo(request []byte) (*RequestInfo, error) {
+	info, body, err := decodeRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		if _, ok := requestsWithoutTopics[info.ApiKey]; !ok {
+			info.TopicsUnknown = true
+		}
+		return info, nil
+	}
+	info.Topics = appendTopics(make([]string, 0), body)
+	info.TopicsUnknown = requestsAllTopics(info, body)
+	return info, nil
+}
+
+// decodeRequest decodes the request header and the request body. The body is nil when the request has no decoder.
+// DecodeRequestHeader decodes the request header (ApiKey, ApiVersion, CorrelationId, ClientId) of the request without the size.
+// The topics are not decoded.
+func DecodeRequestHeader(request []byte) (*RequestInfo, error) {
+	info, _, err := decodeRequestHeader(request)
+	return info, err
+}
+
+// decodeRequestHeader returns the header and its length
+func decodeRequestHeader(request []byte) (*RequestInfo, int, error) {
+	helper := realDecoder{raw: request}
+	v, err := requestHeaderSchema.decode(&helper)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := v.(*Struct)
+	info := &RequestInfo{
+		ApiKey:        header.Get("api_key").(int16),
+		ApiVersion:    header.Get("api_version").(int16),
+		CorrelationID: header.Get("correlation_id").(int32),
+	}
+	if clientID := header.Get("client_id").(*string); clientID != nil {
+		info.ClientID = *clientID
+	}
+	return info, helper.off, nil
+}
+
+func decodeRequest(request []byte) (*RequestInfo, *Struct, error) {
+	info, headerLength, err := decodeRequestHeader(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	schemas, ok := requestSchemaVersions[info.ApiKey]
+	if !ok || info.ApiVersion < 0 || int(info.ApiVersion) >= len(schemas) {
+		return info, nil, nil
+	}
+	body, err := DecodeSchema(request[headerLength:], schemas[info.ApiVersion])
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, body, nil
+}
+
+// requestsAllTopics checks whether the request refers to all topics: null topics array (Metadata v1+, OffsetFetch v2+, ElectLeaders)
+// or empty topics array in Metadata v0.
+func requestsAllTopics(info *RequestInfo, body *Struct) bool {
+	for _, name := range []string{topicsKeyName, topicPartitionsKeyName} {
+		if topics, ok := body.Get(name).([]interface{}); ok {
+			if topics == nil {
+				return true
+			}
+			if info.ApiKey == apiKeyMetadata && info.ApiVersion == 0 && len(topics) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appendTopics collects topic names from the fields named topic, from the string arrays named topics
+// and from the names of topic resources.
+func appendTopics(topics []string, s *Struct) []string {
+	if resourceType, ok := s.Get("resource_type").(int8); ok && resourceType == resourceTypeTopic {
+		if name, ok := s.Get("resource_name").(string); ok {
+			topics = appendTopic(topics, name)
+		}
+	}
+	for _, f := range s.schema.fields {
+		name := f.def.GetName()
+		switch value := s.Get(name).(type) {
+		case string:
+			if name == topicKeyName {
+				topics = appendTopic(topics, value)
+			}
+		case *Struct:
+			topics = appendTopics(topics, value)
+		case []interface{}:
+			for _, elem := range value {
+				switch e := elem.(type) {
+				case *Struct:
+					topics = appendTopics(topics, e)
+				case string:
+					if name == topicsKeyName {
+						topics = appendTopic(topics, e)
+					}
+				}
+			}
+		}
+	}
+	return topics
+}
+
+func appendTopic(topics []string, topic string) []string {
+	for _, t := range topics {
+		if t == topic {
+			return topics
+		}
+	}
+	return append(topics, topic)
+}
@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeRequestInfoProduceV3(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		// api_key, api_version
+		0x00, 0x00, 0x00, 0x03,
+		// correlation_id
+		0x00, 0x00, 0x00, 0x07,
+		// client_id "c1"
+		0x00, 0x02, 'c', '1',
+		// transactional_id null
+		0xff, 0xff,
+		// acks, timeout
+		0xff, 0xff, 0x00, 0x00, 0x75, 0x30,
+		// topic_data
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xca, 0xfe,
+		0x00, 0x02, 't', '2',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(&RequestInfo{ApiKey: 0, ApiVersion: 3, CorrelationID: 7, ClientID: "c1", Topics: []string{"t1", "t2"}}, info)
+}
+
+func TestDecodeRequestInfoMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	// v1 with null topics - all topics
+	request := []byte{
+		0x00, 0x03, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff,
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal("", info.ClientID)
+	a.Empty(info.Topics)
+	a.True(info.TopicsUnknown)
+
+	// v0 with empty topics - all topics
+	info, err = DecodeRequestInfo([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00})
+	a.Nil(err)
+	a.True(info.TopicsUnknown)
+
+	// v1 with empty topics - no topics
+	info, err = DecodeRequestInfo([]byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00})
+	a.Nil(err)
+	a.Empty(info.Topics)
+	a.False(info.TopicsUnknown)
+
+	// v4 with duplicated topic
+	request = []byte{
+		0x00, 0x03, 0x00, 0x04,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x02, 't', '1',
+		0x00, 0x02, 't', '1',
+		0x01,
+	}
+	info, err = DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal([]string{"t1"}, info.Topics)
+}
+
+func TestDecodeRequestInfoFetchV7(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x01, 0x00, 0x07,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00,
+		// replica_id, max_wait_time, min_bytes, max_bytes, isolation_level, session_id, session_epoch
+		0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x10, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// topics
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x10, 0x00,
+		// forgotten_topics_data
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '2',
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal([]string{"t1", "t2"}, info.Topics)
+}
+
+func TestDecodeRequestInfoWithoutTopics(t *testing.T) {
+	a := assert.New(t)
+
+	// ApiVersions v0
+	request := []byte{
+		0x00, 0x12, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x01, 'c',
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal(&RequestInfo{ApiKey: 18, ApiVersion: 0, CorrelationID: 1, ClientID: "c"}, info)
+}
+
+func TestDecodeRequestInfoTopicsUnknown(t *testing.T) {
+	a := assert.New(t)
+
+	// flexible DeleteTopics v4 - only the header is decoded
+	info, err := DecodeRequestInfo([]byte{0x00, 0x14, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0x00, 0x02, 0x03, 't', '1', 0x00})
+	a.Nil(err)
+	a.Equal(&RequestInfo{ApiKey: 20, ApiVersion: 4, CorrelationID: 1, ClientID: "c", TopicsUnknown: true}, info)
+
+	// api key without decoder - DescribeAcls v0
+	info, err = DecodeRequestInfo([]byte{0x00, 0x1d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x01})
+	a.Nil(err)
+	a.True(info.TopicsUnknown)
+
+	// OffsetFetch v2 with null topics - all topics of the group
+	info, err = DecodeRequestInfo([]byte{0x00, 0x09, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0x00, 0x01, 'g', 0xff, 0xff, 0xff, 0xff})
+	a.Nil(err)
+	a.True(info.TopicsUnknown)
+}
+
+func TestDecodeRequestInfoDescribeConfigsV1(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x20, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0xff, 0xff,
+		// resources
+		0x00, 0x00, 0x00, 0x02,
+		// broker 1
+		0x04, 0x00, 0x01, '1', 0xff, 0xff, 0xff, 0xff,
+		// topic t1
+		0x02, 0x00, 0x02, 't', '1', 0x00, 0x00, 0x00, 0x00,
+		// include_synonyms
+		0x00,
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal([]string{"t1"}, info.Topics)
+	a.False(info.TopicsUnknown)
+}
+
+func TestDecodeRequestInfoCreatePartitionsV0(t *testing.T) {
+	a := assert.New(t)
+
+	request := []byte{
+		0x00, 0x25, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0xff, 0xff,
+		// topic_partitions
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x02, 't', '1', 0x00, 0x00, 0x00, 0x03, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x02, 't', '2', 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02,
+		// timeout, validate_only
+		0x00, 0x00, 0x75, 0x30, 0x00,
+	}
+	info, err := DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal([]string{"t1", "t2"}, info.Topics)
+}
+
+func TestDecodeRequestInfoErrors(t *testing.T) {
+	a := assert.New(t)
+
+	// truncated DeleteTopics v0
+	_, err := DecodeRequestInfo([]byte{0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01})
+	a.NotNil(err)
+
+	// truncated header
+	_, err = DecodeRequestInfo([]byte{0x00, 0x14, 0x00, 0x00, 0x00, 0x00})
+	a.NotNil(err)
+}
+
+func TestDecodeRequestHeader(t *testing.T) {
+	a := assert.New(t)
+
+	// the body of the unsupported version is not decoded
+	info, err := DecodeRequestHeader([]byte{0x00, 0x00, 0x7f, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x02, 'c', '1', 0xca, 0xfe})
+	a.Nil(err)
+	a.Equal(&RequestInfo{ApiKey: 0, ApiVersion: 0x7f00, CorrelationID: 9, ClientID: "c1"}, info)
+
+	_, err = DecodeRequestHeader([]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00})
+	a.NotNil(err)
+}
@@ -0,0 +1,163 @@
+package protocol
+
+const errorCodeKeyName = "error_code"
+
+var (
+	// responseErrorsSchemaVersions are the responses whose error codes can be decoded
+	responseErrorsSchemaVersions = map[int16][]Schema{
+		apiKeyProduce:         topicPrefixResponseSchemaVersions[apiKeyProduce],
+		apiKeyFetch:           fetchResponseSchemaVersions,
+		apiKeyListOffsets:     topicPrefixResponseSchemaVersions[apiKeyListOffsets],
+		apiKeyMetadata:        metadataResponseSchemaVersions,
+		apiKeyOffsetCommit:    topicPrefixResponseSchemaVersions[apiKeyOffsetCommit],
+		apiKeyOffsetFetch:     topicPrefixResponseSchemaVersions[apiKeyOffsetFetch],
+		apiKeyFindCoordinator: findCoordinatorResponseSchemaVersions,
+		apiKeyJoinGroup:       createJoinGroupResponseSchemaVersions(),
+		apiKeyHeartbeat:       createHeartbeatResponseSchemaVersions(),
+		apiKeyLeaveGroup:      createLeaveGroupResponseSchemaVersions(),
+		apiKeySyncGroup:       createSyncGroupResponseSchemaVersions(),
+		apiKeyDescribeGroups:  groupResponseSchemaVersions[apiKeyDescribeGroups],
+		apiKeyListGroups:      groupResponseSchemaVersions[apiKeyListGroups],
+	}
+)
+
+func createJoinGroupResponseSchemaVersions() []Schema {
+	memberV0 := NewSchema("join_group_response_member_v0",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "metadata", ty: typeBytes},
+	)
+
+	memberV5 := NewSchema("join_group_response_member_v5",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: "metadata", ty: typeBytes},
+	)
+
+	joinGroupResponseV0 := NewSchema("join_group_response_v0",
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "protocol_name", ty: typeStr},
+		&field{name: "leader", ty: typeStr},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+	)
+
+	joinGroupResponseV2 := NewSchema("join_group_response_v2",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "protocol_name", ty: typeStr},
+		&field{name: "leader", ty: typeStr},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "members", ty: memberV0},
+	)
+
+	joinGroupResponseV5 := NewSchema("join_group_response_v5",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "generation_id", ty: typeInt32},
+		&field{name: "protocol_name", ty: typeStr},
+		&field{name: "leader", ty: typeStr},
+		&field{name: "member_id", ty: typeStr},
+		&array{name: "members", ty: memberV5},
+	)
+
+	return []Schema{joinGroupResponseV0, joinGroupResponseV0, joinGroupResponseV2, joinGroupResponseV2, joinGroupResponseV2, joinGroupResponseV5}
+}
+
+func createHeartbeatResponseSchemaVersions() []Schema {
+	heartbeatResponseV0 := NewSchema("heartbeat_response_v0",
+		&field{name: errorCodeKeyName, ty: typeInt16},
+	)
+
+	heartbeatResponseV1 := NewSchema("heartbeat_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+	)
+
+	return []Schema{heartbeatResponseV0, heartbeatResponseV1, heartbeatResponseV1, heartbeatResponseV1}
+}
+
+func createLeaveGroupResponseSchemaVersions() []Schema {
+	leaveGroupResponseV0 := NewSchema("leave_group_response_v0",
+		&field{name: errorCodeKeyName, ty: typeInt16},
+	)
+
+	leaveGroupResponseV1 := NewSchema("leave_group_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+	)
+
+	memberV3 := NewSchema("leave_group_response_member_v3",
+		&field{name: "member_id", ty: typeStr},
+		&field{name: "group_instance_id", ty: typeNullableStr},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+	)
+
+	leaveGroupResponseV3 := NewSchema("leave_group_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&array{name: "members", ty: memberV3},
+	)
+
+	return []Schema{leaveGroupResponseV0, leaveGroupResponseV1, leaveGroupResponseV1, leaveGroupResponseV3}
+}
+
+func createSyncGroupResponseSchemaVersions() []Schema {
+	syncGroupResponseV0 := NewSchema("sync_group_response_v0",
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "assignment", ty: typeBytes},
+	)
+
+	syncGroupResponseV1 := NewSchema("sync_group_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: errorCodeKeyName, ty: typeInt16},
+		&field{name: "assignment", ty: typeBytes},
+	)
+
+	return []Schema{syncGroupResponseV0, syncGroupResponseV1, syncGroupResponseV1, syncGroupResponseV1}
+}
+
+// ResponseErrorsSupported returns true if the error codes of the response can be decoded by ResponseErrorCodes
+func ResponseErrorsSupported(apiKey int16, apiVersion int16) bool {
+	schemas, ok := responseErrorsSchemaVersions[apiKey]
+	return ok && apiVersion >= 0 && int(apiVersion) < len(schemas)
+}
+
+// ResponseErrorCodes returns the error codes of the response which are not NONE, one for each topic, partition, group or member with an error
+func ResponseErrorCodes(apiKey int16, apiVersion int16, response []byte) ([]KError, error) {
+	schemas, ok := responseErrorsSchemaVersions[apiKey]
+	if !ok {
+		return nil, nil
+	}
+	schema, err := getResponseSchema(apiKey, apiVersion, schemas)
+	if err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	return appendErrorCodes(nil, body), nil
+}
+
+func appendErrorCodes(errorCodes []KError, s *Struct) []KError {
+	for _, f := range s.schema.fields {
+		name := f.def.GetName()
+		switch value := s.Get(name).(type) {
+		case int16:
+			if name == errorCodeKeyName && value != int16(ErrNoError) {
+				errorCodes = append(errorCodes, KError(value))
+			}
+		case *Struct:
+			errorCodes = appendErrorCodes(errorCodes, value)
+		case []interface{}:
+			for _, elem := range value {
+				if e, ok := elem.(*Struct); ok {
+					errorCodes = appendErrorCodes(errorCodes, e)
+				}
+			}
+		}
+	}
+	return errorCodes
+}
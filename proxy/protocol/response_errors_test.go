@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestResponseErrorCodesAllVersions(t *testing.T) {
+	a := assert.New(t)
+
+	for apiKey, schemas := range responseErrorsSchemaVersions {
+		for apiVersion, schema := range schemas {
+			a.True(ResponseErrorsSupported(apiKey, int16(apiVersion)))
+			// the error codes of the test value are 1
+			response, err := EncodeSchema(testSchemaValue(schema, "").(*Struct), schema)
+			a.Nil(err)
+			errorCodes, err := ResponseErrorCodes(apiKey, int16(apiVersion), response)
+			a.Nil(err, "api key %d version %d", apiKey, apiVersion)
+			a.NotEmpty(errorCodes, "api key %d version %d", apiKey, apiVersion)
+			for _, errorCode := range errorCodes {
+				a.Equal(ErrOffsetOutOfRange, errorCode)
+			}
+		}
+		a.False(ResponseErrorsSupported(apiKey, int16(len(schemas))))
+	}
+	a.False(ResponseErrorsSupported(apiKeyApiVersions, 0))
+}
+
+func TestResponseErrorCodes(t *testing.T) {
+	a := assert.New(t)
+
+	// heartbeat v1: throttle_time_ms, error_code NOT_COORDINATOR
+	errorCodes, err := ResponseErrorCodes(apiKeyHeartbeat, 1, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x10})
+	a.Nil(err)
+	a.Equal([]KError{ErrNotCoordinatorForConsumer}, errorCodes)
+
+	errorCodes, err = ResponseErrorCodes(apiKeyHeartbeat, 1, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	a.Nil(err)
+	a.Empty(errorCodes)
+
+	// produce v0 with the partitions 0 NOT_LEADER_FOR_PARTITION and 1 NONE
+	errorCodes, err = ResponseErrorCodes(apiKeyProduce, 0, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 't', 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07,
+	})
+	a.Nil(err)
+	a.Equal([]KError{ErrNotLeaderForPartition}, errorCodes)
+
+	_, err = ResponseErrorCodes(apiKeyHeartbeat, 1, []byte{0x00, 0x00, 0x00, 0x00, 0x00})
+	a.NotNil(err)
+	_, err = ResponseErrorCodes(apiKeyHeartbeat, 4, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	a.EqualError(err, "Unsupported response schema version 4 for key 12 ")
+
+	errorCodes, err = ResponseErrorCodes(apiKeyApiVersions, 0, []byte{0x00, 0x22})
+	a.Nil(err)
+	a.Nil(errorCodes)
+}
@@ -14,7 +14,12 @@ const (
 	hostKeyName    = "host"
 	portKeyName    = "port"
 
-	coordinatorKeyName = "coordinator"
+	coordinatorKeyName  = "coordinator"
+	coordinatorsKeyName = "coordinators"
+
+	// the flexible versions start with the tagged fields of the response header v1
+	responseHeaderTaggedFieldsKeyName = "response_header_tagged_fields"
+	taggedFieldsKeyName               = "tagged_fields"
 )
 
 var (
@@ -113,7 +118,155 @@ func createMetadataResponseSchemaVersions() []Schema {
 
 	metadataResponseV6 := metadataResponseV5
 
-	return []Schema{metadataResponseV0, metadataResponseV1, metadataResponseV2, metadataResponseV3, metadataResponseV4, metadataResponseV5, metadataResponseV6}
+	partitionMetadataV7 := NewSchema("partition_metadata_v7",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "leader", ty: typeInt32},
+		&field{name: "leader_epoch", ty: typeInt32},
+		&array{name: "replicas", ty: typeInt32},
+		&array{name: "isr", ty: typeInt32},
+		&array{name: "offline_replicas", ty: typeInt32},
+	)
+
+	topicMetadataV7 := NewSchema("topic_metadata_v7",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "topic", ty: typeStr},
+		&field{name: "is_internal", ty: typeBool},
+		&array{name: "partition_metadata", ty: partitionMetadataV7},
+	)
+
+	metadataResponseV7 := NewSchema("metadata_response_v7",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: brokersKeyName, ty: metadataBrokerV1},
+		&field{name: "cluster_id", ty: typeNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&array{name: "topic_metadata", ty: topicMetadataV7},
+	)
+
+	topicMetadataV8 := NewSchema("topic_metadata_v8",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "topic", ty: typeStr},
+		&field{name: "is_internal", ty: typeBool},
+		&array{name: "partition_metadata", ty: partitionMetadataV7},
+		&field{name: "topic_authorized_operations", ty: typeInt32},
+	)
+
+	metadataResponseV8 := NewSchema("metadata_response_v8",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: brokersKeyName, ty: metadataBrokerV1},
+		&field{name: "cluster_id", ty: typeNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&array{name: "topic_metadata", ty: topicMetadataV8},
+		&field{name: "cluster_authorized_operations", ty: typeInt32},
+	)
+
+	// flexible versions
+	metadataBrokerV9 := NewSchema("metadata_broker_v9",
+		&field{name: "node_id", ty: typeInt32},
+		&field{name: hostKeyName, ty: typeCompactStr},
+		&field{name: portKeyName, ty: typeInt32},
+		&field{name: "rack", ty: typeCompactNullableStr},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	partitionMetadataV9 := NewSchema("partition_metadata_v9",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "leader", ty: typeInt32},
+		&field{name: "leader_epoch", ty: typeInt32},
+		&compactArray{name: "replicas", ty: typeInt32},
+		&compactArray{name: "isr", ty: typeInt32},
+		&compactArray{name: "offline_replicas", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	topicMetadataV9 := NewSchema("topic_metadata_v9",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "topic", ty: typeCompactStr},
+		&field{name: "is_internal", ty: typeBool},
+		&compactArray{name: "partition_metadata", ty: partitionMetadataV9},
+		&field{name: "topic_authorized_operations", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	metadataResponseV9 := NewSchema("metadata_response_v9",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: brokersKeyName, ty: metadataBrokerV9},
+		&field{name: "cluster_id", ty: typeCompactNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&compactArray{name: "topic_metadata", ty: topicMetadataV9},
+		&field{name: "cluster_authorized_operations", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	topicMetadataV10 := NewSchema("topic_metadata_v10",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "topic", ty: typeCompactStr},
+		&field{name: "topic_id", ty: typeUuid},
+		&field{name: "is_internal", ty: typeBool},
+		&compactArray{name: "partition_metadata", ty: partitionMetadataV9},
+		&field{name: "topic_authorized_operations", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	metadataResponseV10 := NewSchema("metadata_response_v10",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: brokersKeyName, ty: metadataBrokerV9},
+		&field{name: "cluster_id", ty: typeCompactNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&compactArray{name: "topic_metadata", ty: topicMetadataV10},
+		&field{name: "cluster_authorized_operations", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	// v11 drops the cluster authorized operations
+	metadataResponseV11 := NewSchema("metadata_response_v11",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: brokersKeyName, ty: metadataBrokerV9},
+		&field{name: "cluster_id", ty: typeCompactNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&compactArray{name: "topic_metadata", ty: topicMetadataV10},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	// v12 returns the null topic name when the topic is requested by its id
+	topicMetadataV12 := NewSchema("topic_metadata_v12",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "topic", ty: typeCompactNullableStr},
+		&field{name: "topic_id", ty: typeUuid},
+		&field{name: "is_internal", ty: typeBool},
+		&compactArray{name: "partition_metadata", ty: partitionMetadataV9},
+		&field{name: "topic_authorized_operations", ty: typeInt32},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	metadataResponseV12 := NewSchema("metadata_response_v12",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: brokersKeyName, ty: metadataBrokerV9},
+		&field{name: "cluster_id", ty: typeCompactNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&compactArray{name: "topic_metadata", ty: topicMetadataV12},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	// v13 adds the top-level error code
+	metadataResponseV13 := NewSchema("metadata_response_v13",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: brokersKeyName, ty: metadataBrokerV9},
+		&field{name: "cluster_id", ty: typeCompactNullableStr},
+		&field{name: "controller_id", ty: typeInt32},
+		&compactArray{name: "topic_metadata", ty: topicMetadataV12},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	return []Schema{metadataResponseV0, metadataResponseV1, metadataResponseV2, metadataResponseV3, metadataResponseV4, metadataResponseV5, metadataResponseV6,
+		metadataResponseV7, metadataResponseV8, metadataResponseV9, metadataResponseV10, metadataResponseV11, metadataResponseV12, metadataResponseV13}
 }
 
 func createFindCoordinatorResponseSchemaVersions() []Schema {
@@ -137,10 +290,43 @@ func createFindCoordinatorResponseSchemaVersions() []Schema {
 
 	findCoordinatorResponseV2 := findCoordinatorResponseV1
 
-	return []Schema{findCoordinatorResponseV0, findCoordinatorResponseV1, findCoordinatorResponseV2}
+	findCoordinatorBrokerV3 := NewSchema("find_coordinator_broker_v3",
+		&field{name: "node_id", ty: typeInt32},
+		&field{name: hostKeyName, ty: typeCompactStr},
+		&field{name: portKeyName, ty: typeInt32},
+	)
+
+	findCoordinatorResponseV3 := NewSchema("find_coordinator_response_v3",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "error_message", ty: typeCompactNullableStr},
+		&field{name: coordinatorKeyName, ty: findCoordinatorBrokerV3},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	// v4 looks up the coordinators of the batched keys (KIP-699)
+	findCoordinatorCoordinatorV4 := NewSchema("find_coordinator_coordinator_v4",
+		&field{name: "key", ty: typeCompactStr},
+		&field{name: "node_id", ty: typeInt32},
+		&field{name: hostKeyName, ty: typeCompactStr},
+		&field{name: portKeyName, ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "error_message", ty: typeCompactNullableStr},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	findCoordinatorResponseV4 := NewSchema("find_coordinator_response_v4",
+		&field{name: responseHeaderTaggedFieldsKeyName, ty: typeTaggedFields},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&compactArray{name: coordinatorsKeyName, ty: findCoordinatorCoordinatorV4},
+		&field{name: taggedFieldsKeyName, ty: typeTaggedFields},
+	)
+
+	return []Schema{findCoordinatorResponseV0, findCoordinatorResponseV1, findCoordinatorResponseV2, findCoordinatorResponseV3, findCoordinatorResponseV4}
 }
 
-func modifyMetadataResponse(decodedStruct *Struct, fn config.NetAddressMappingFunc) error {
+func modifyMetadataResponse(decodedStruct *Struct, fn config.NetAddressMappingFunc, hostFn AdvertisedHostFunc) error {
 	if decodedStruct == nil {
 		return errors.New("decoded struct must not be nil")
 	}
@@ -152,58 +338,57 @@ func modifyMetadataResponse(decodedStruct *Struct, fn config.NetAddressMappingFu
 		return errors.New("brokers list not found")
 	}
 	for _, brokerElement := range brokersArray {
-		broker := brokerElement.(*Struct)
-		host, ok := broker.Get(hostKeyName).(string)
-		if !ok {
-			return errors.New("broker.host not found")
-		}
-		port, ok := broker.Get(portKeyName).(int32)
-		if !ok {
-			return errors.New("broker.port not found")
-		}
-
-		if host == "" && port <= 0 {
-			continue
-		}
-
-		newHost, newPort, err := fn(host, port)
-		if err != nil {
+		if err := mapNetAddress(brokerElement.(*Struct), "broker", fn, hostFn); err != nil {
 			return err
 		}
-		if host != newHost {
-			err := broker.Replace(hostKeyName, newHost)
-			if err != nil {
-				return err
-			}
-		}
-		if port != newPort {
-			err = broker.Replace(portKeyName, int32(newPort))
-			if err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
 
-func modifyFindCoordinatorResponse(decodedStruct *Struct, fn config.NetAddressMappingFunc) error {
+func modifyFindCoordinatorResponse(decodedStruct *Struct, fn config.NetAddressMappingFunc, hostFn AdvertisedHostFunc) error {
 	if decodedStruct == nil {
 		return errors.New("decoded struct must not be nil")
 	}
 	if fn == nil {
 		return errors.New("net address mapper must not be nil")
 	}
-	coordinator, ok := decodedStruct.Get(coordinatorKeyName).(*Struct)
+	coordinators, err := findCoordinators(decodedStruct)
+	if err != nil {
+		return err
+	}
+	for _, coordinator := range coordinators {
+		if err = mapNetAddress(coordinator, "coordinator", fn, hostFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findCoordinators returns the coordinator of the FindCoordinator response or the coordinators of the batched keys (v4+)
+func findCoordinators(decodedStruct *Struct) ([]*Struct, error) {
+	if coordinator, ok := decodedStruct.Get(coordinatorKeyName).(*Struct); ok {
+		return []*Struct{coordinator}, nil
+	}
+	coordinatorsArray, ok := decodedStruct.Get(coordinatorsKeyName).([]interface{})
 	if !ok {
-		return errors.New("coordinator not found")
+		return nil, errors.New("coordinator not found")
+	}
+	coordinators := make([]*Struct, 0, len(coordinatorsArray))
+	for _, coordinatorElement := range coordinatorsArray {
+		coordinators = append(coordinators, coordinatorElement.(*Struct))
 	}
-	host, ok := coordinator.Get(hostKeyName).(string)
+	return coordinators, nil
+}
+
+// mapNetAddress replaces the host and the port of the broker or coordinator struct
+func mapNetAddress(s *Struct, name string, fn config.NetAddressMappingFunc, hostFn AdvertisedHostFunc) error {
+	host, ok := s.Get(hostKeyName).(string)
 	if !ok {
-		return errors.New("coordinator.host not found")
+		return fmt.Errorf("%s.host not found", name)
 	}
-	port, ok := coordinator.Get(portKeyName).(int32)
+	port, ok := s.Get(portKeyName).(int32)
 	if !ok {
-		return errors.New("coordinator.port not found")
+		return fmt.Errorf("%s.port not found", name)
 	}
 
 	if host == "" && port <= 0 {
@@ -214,14 +399,23 @@ func modifyFindCoordinatorResponse(decodedStruct *Struct, fn config.NetAddressMa
 	if err != nil {
 		return err
 	}
+	if hostFn != nil {
+		nodeID, ok := s.Get(nodeIDKeyName).(int32)
+		if !ok {
+			return fmt.Errorf("%s.node_id not found", name)
+		}
+		if newHost, err = hostFn(nodeID); err != nil {
+			return err
+		}
+	}
 	if host != newHost {
-		err := coordinator.Replace(hostKeyName, newHost)
+		err := s.Replace(hostKeyName, newHost)
 		if err != nil {
 			return err
 		}
 	}
 	if port != newPort {
-		err = coordinator.Replace(portKeyName, int32(newPort))
+		err = s.Replace(portKeyName, int32(newPort))
 		if err != nil {
 			return err
 		}
@@ -233,12 +427,16 @@ type ResponseModifier interface {
 	Apply(resp []byte) ([]byte, error)
 }
 
-type modifyResponseFunc func(decodedStruct *Struct, fn config.NetAddressMappingFunc) error
+type modifyResponseFunc func(decodedStruct *Struct, fn config.NetAddressMappingFunc, hostFn AdvertisedHostFunc) error
+
+// AdvertisedHostFunc returns the advertised host of the broker with the node id, it replaces the host of the net address mapping
+type AdvertisedHostFunc func(nodeID int32) (string, error)
 
 type responseModifier struct {
 	schema                Schema
 	modifyResponseFunc    modifyResponseFunc
 	netAddressMappingFunc config.NetAddressMappingFunc
+	advertisedHostFunc    AdvertisedHostFunc
 }
 
 func (f *responseModifier) Apply(resp []byte) ([]byte, error) {
@@ -246,25 +444,29 @@ func (f *responseModifier) Apply(resp []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = f.modifyResponseFunc(decodedStruct, f.netAddressMappingFunc)
+	err = f.modifyResponseFunc(decodedStruct, f.netAddressMappingFunc, f.advertisedHostFunc)
 	if err != nil {
 		return nil, err
 	}
 	return EncodeSchema(decodedStruct, f.schema)
 }
 
-func GetResponseModifier(apiKey int16, apiVersion int16, addressMappingFunc config.NetAddressMappingFunc) (ResponseModifier, error) {
+// GetResponseModifier returns the modifier of the broker addresses of the response, the hosts are replaced by the advertised host
+// function when it is not nil
+func GetResponseModifier(apiKey int16, apiVersion int16, addressMappingFunc config.NetAddressMappingFunc, advertisedHostFunc AdvertisedHostFunc) (ResponseModifier, error) {
 	switch apiKey {
 	case apiKeyMetadata:
-		return newResponseModifier(apiKey, apiVersion, addressMappingFunc, metadataResponseSchemaVersions, modifyMetadataResponse)
+		return newResponseModifier(apiKey, apiVersion, addressMappingFunc, advertisedHostFunc, metadataResponseSchemaVersions, modifyMetadataResponse)
 	case apiKeyFindCoordinator:
-		return newResponseModifier(apiKey, apiVersion, addressMappingFunc, findCoordinatorResponseSchemaVersions, modifyFindCoordinatorResponse)
+		return newResponseModifier(apiKey, apiVersion, addressMappingFunc, advertisedHostFunc, findCoordinatorResponseSchemaVersions, modifyFindCoordinatorResponse)
+	case apiKeyDescribeCluster:
+		return newDescribeClusterModifier(apiVersion, addressMappingFunc, advertisedHostFunc)
 	default:
 		return nil, nil
 	}
 }
 
-func newResponseModifier(apiKey int16, apiVersion int16, netAddressMappingFunc config.NetAddressMappingFunc, schemas []Schema, modifyResponseFunc modifyResponseFunc) (ResponseModifier, error) {
+func newResponseModifier(apiKey int16, apiVersion int16, netAddressMappingFunc config.NetAddressMappingFunc, advertisedHostFunc AdvertisedHostFunc, schemas []Schema, modifyResponseFunc modifyResponseFunc) (ResponseModifier, error) {
 	schema, err := getResponseSchema(apiKey, apiVersion, schemas)
 	if err != nil {
 		return nil, err
@@ -273,6 +475,7 @@ func newResponseModifier(apiKey int16, apiVersion int16, netAddressMappingFunc c
 		schema:                schema,
 		modifyResponseFunc:    modifyResponseFunc,
 		netAddressMappingFunc: netAddressMappingFunc,
+		advertisedHostFunc:    advertisedHostFunc,
 	}, nil
 }
 
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -357,7 +358,7 @@ func TestMetadataResponseV0(t *testing.T) {
 			return "aws.com", 34999, nil
 		}
 		return "", 0, errors.New("unexpected data")
-	})
+	}, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -395,6 +396,29 @@ func TestMetadataResponseV0(t *testing.T) {
 		"[partition_metadata]",
 	}
 	a.Equal(expected, dc.AttrValues())
+
+	// the hosts are replaced by the advertised hosts of the node ids, the ports are mapped
+	modifier, err = GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, func(nodeID int32) (string, error) {
+		return fmt.Sprintf("b%d.proxy.example.com", nodeID), nil
+	})
+	a.Nil(err)
+	resp, err = modifier.Apply(bytes)
+	a.Nil(err)
+	s, err = DecodeSchema(resp, schema)
+	a.Nil(err)
+	dc = NewDecodeCheck()
+	dc.Traverse(s)
+	a.Equal([]string{
+		"[brokers]",
+		"brokers struct",
+		"node_id int32 44031",
+		"host string b44031.proxy.example.com",
+		"port int32 34001",
+		"brokers struct",
+		"node_id int32 66051",
+		"host string b66051.proxy.example.com",
+		"port int32 34002",
+	}, dc.AttrValues()[:9])
 }
 
 func TestMetadataResponseV1(t *testing.T) {
@@ -520,7 +544,7 @@ func TestMetadataResponseV1(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -701,7 +725,7 @@ func TestMetadataResponseV2(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -887,7 +911,7 @@ func TestMetadataResponseV3(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1074,7 +1098,7 @@ func TestMetadataResponseV4(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1265,7 +1289,7 @@ func TestMetadataResponseV5(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1461,7 +1485,7 @@ func TestMetadataResponseV6(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyMetadata, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1557,7 +1581,7 @@ func TestFindCoordinatorResponseV0(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1621,7 +1645,7 @@ func TestFindCoordinatorResponseV1(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1687,7 +1711,7 @@ func TestFindCoordinatorResponseV2(t *testing.T) {
 	a.Nil(err)
 	a.Equal(bytes, resp)
 
-	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier)
+	modifier, err := GetResponseModifier(apiKeyFindCoordinator, apiVersion, testResponseModifier, nil)
 	a.Nil(err)
 	resp, err = modifier.Apply(resp)
 	a.Nil(err)
@@ -1775,3 +1799,275 @@ func (t *decodeCheck) Dump() {
 		fmt.Printf("\"%s\",\n", v)
 	}
 }
+
+func TestMetadataResponseV5NullArrayRoundTrip(t *testing.T) {
+	bytes := []byte{
+		// throttle_time_ms
+		0x00, 0x00, 0x00, 0x00,
+		// brokers
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x09, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't',
+		0x00, 0x00, 0x00, 0x33, // 51
+		0xff, 0xff, // rack null
+		// cluster_id null, controller_id
+		0xff, 0xff,
+		0x00, 0x00, 0x00, 0x01,
+		// topic_metadata
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00,
+		0x00, 0x02, 't', '1',
+		0x00,
+		// partition_metadata
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		// replicas
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+		// isr
+		0x00, 0x00, 0x00, 0x00,
+		// offline_replicas null
+		0xff, 0xff, 0xff, 0xff,
+	}
+
+	a := assert.New(t)
+
+	s, err := DecodeSchema(bytes, metadataResponseSchemaVersions[5])
+	a.Nil(err)
+	topicMetadata := s.Get("topic_metadata").([]interface{})
+	partitionMetadata := topicMetadata[0].(*Struct).Get("partition_metadata").([]interface{})
+	a.Nil(partitionMetadata[0].(*Struct).Get("offline_replicas"))
+	a.Equal([]interface{}{}, partitionMetadata[0].(*Struct).Get("isr"))
+
+	// null and empty arrays are encoded as they were received
+	resp, err := EncodeSchema(s, metadataResponseSchemaVersions[5])
+	a.Nil(err)
+	a.Equal(bytes, resp)
+
+	modifier, err := GetResponseModifier(apiKeyMetadata, 5, testResponseModifier, nil)
+	a.Nil(err)
+	resp, err = modifier.Apply(bytes)
+	a.Nil(err)
+	a.Len(resp, len(bytes)-2) // localhost:51 is replaced by myhost1:34001
+	a.Equal(bytes[len(bytes)-12:], resp[len(resp)-12:])
+}
+
+// testWire writes the responses of all versions as specified by the Kafka protocol, independently of the schemas
+type testWire struct {
+	buf      []byte
+	flexible bool
+}
+
+func (w *testWire) int16(v int16) {
+	w.buf = append(w.buf, byte(v>>8), byte(v))
+}
+
+func (w *testWire) int32(v int32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *testWire) uvarint(v uint64) {
+	for v >= 0x80 {
+		w.buf = append(w.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *testWire) str(v *string) {
+	switch {
+	case w.flexible && v == nil:
+		w.uvarint(0)
+	case w.flexible:
+		w.uvarint(uint64(len(*v) + 1))
+		w.buf = append(w.buf, *v...)
+	case v == nil:
+		w.int16(-1)
+	default:
+		w.int16(int16(len(*v)))
+		w.buf = append(w.buf, *v...)
+	}
+}
+
+func (w *testWire) arrayLength(n int) {
+	if w.flexible {
+		w.uvarint(uint64(n + 1))
+	} else {
+		w.int32(int32(n))
+	}
+}
+
+func (w *testWire) int32Array(vs ...int32) {
+	w.arrayLength(len(vs))
+	for _, v := range vs {
+		w.int32(v)
+	}
+}
+
+// taggedFields writes an unknown tagged field which must be preserved
+func (w *testWire) taggedFields() {
+	if w.flexible {
+		w.buf = append(w.buf, 0x01, 0x05, 0x02, 0xca, 0xfe)
+	}
+}
+
+func testStr(v string) *string {
+	return &v
+}
+
+func testMetadataResponse(version int16, brokers [][2]interface{}) []byte {
+	w := &testWire{flexible: version >= 9}
+	// response header
+	w.taggedFields()
+	if version >= 3 {
+		w.int32(1) // throttle_time_ms
+	}
+	w.arrayLength(len(brokers))
+	for i, broker := range brokers {
+		w.int32(int32(i)) // node_id
+		w.str(testStr(broker[0].(string)))
+		w.int32(broker[1].(int32))
+		if version >= 1 {
+			w.str(testStr("rack")) // rack
+		}
+		w.taggedFields()
+	}
+	if version >= 2 {
+		w.str(nil) // cluster_id
+	}
+	if version >= 1 {
+		w.int32(1) // controller_id
+	}
+	w.arrayLength(1)
+	w.int16(0) // error_code
+	w.str(testStr("foo"))
+	if version >= 10 {
+		w.buf = append(w.buf, bytes.Repeat([]byte{0xab}, 16)...) // topic_id
+	}
+	if version >= 1 {
+		w.buf = append(w.buf, 0x00) // is_internal
+	}
+	w.arrayLength(1)
+	w.int16(0) // error_code
+	w.int32(0) // partition
+	w.int32(1) // leader
+	if version >= 7 {
+		w.int32(3) // leader_epoch
+	}
+	w.int32Array(0, 1) // replicas
+	w.int32Array(1)    // isr
+	if version >= 5 {
+		w.int32Array() // offline_replicas
+	}
+	w.taggedFields()
+	if version >= 8 {
+		w.int32(-2147483648) // topic_authorized_operations
+	}
+	w.taggedFields()
+	if version >= 8 && version <= 10 {
+		w.int32(-2147483648) // cluster_authorized_operations
+	}
+	if version >= 13 {
+		w.int16(0) // error_code
+	}
+	w.taggedFields()
+	return w.buf
+}
+
+func testFindCoordinatorResponse(version int16, coordinators [][2]interface{}) []byte {
+	w := &testWire{flexible: version >= 3}
+	// response header
+	w.taggedFields()
+	if version >= 1 {
+		w.int32(1) // throttle_time_ms
+	}
+	if version >= 4 {
+		w.arrayLength(len(coordinators))
+		for i, coordinator := range coordinators {
+			w.str(testStr(fmt.Sprintf("group-%d", i)))
+			w.int32(int32(i)) // node_id
+			w.str(testStr(coordinator[0].(string)))
+			w.int32(coordinator[1].(int32))
+			w.int16(0) // error_code
+			w.str(nil) // error_message
+			w.taggedFields()
+		}
+	} else {
+		w.int16(0) // error_code
+		if version >= 1 {
+			w.str(nil) // error_message
+		}
+		w.int32(0) // node_id
+		w.str(testStr(coordinators[0][0].(string)))
+		w.int32(coordinators[0][1].(int32))
+	}
+	w.taggedFields()
+	return w.buf
+}
+
+func TestMetadataResponseAllVersions(t *testing.T) {
+	a := assert.New(t)
+
+	brokers := [][2]interface{}{{"localhost", int32(51)}, {"kafka.org", int32(53503)}}
+	mapped := [][2]interface{}{{"myhost1", int32(34001)}, {"myhost3", int32(34003)}}
+	for version := int16(0); version < int16(len(metadataResponseSchemaVersions)); version++ {
+		response := testMetadataResponse(version, brokers)
+
+		s, err := DecodeSchema(response, metadataResponseSchemaVersions[version])
+		a.Nil(err, "version %d", version)
+		encoded, err := EncodeSchema(s, metadataResponseSchemaVersions[version])
+		a.Nil(err, "version %d", version)
+		a.Equal(response, encoded, "version %d", version)
+
+		modifier, err := GetResponseModifier(apiKeyMetadata, version, testResponseModifier, nil)
+		a.Nil(err, "version %d", version)
+		result, err := modifier.Apply(response)
+		a.Nil(err, "version %d", version)
+		a.Equal(testMetadataResponse(version, mapped), result, "version %d", version)
+	}
+	_, err := GetResponseModifier(apiKeyMetadata, int16(len(metadataResponseSchemaVersions)), testResponseModifier, nil)
+	a.NotNil(err)
+}
+
+func TestFindCoordinatorResponseAllVersions(t *testing.T) {
+	a := assert.New(t)
+
+	coordinators := [][2]interface{}{{"localhost", int32(51)}, {"kafka.org", int32(53503)}}
+	mapped := [][2]interface{}{{"myhost1", int32(34001)}, {"myhost3", int32(34003)}}
+	for version := int16(0); version < int16(len(findCoordinatorResponseSchemaVersions)); version++ {
+		response := testFindCoordinatorResponse(version, coordinators)
+
+		s, err := DecodeSchema(response, findCoordinatorResponseSchemaVersions[version])
+		a.Nil(err, "version %d", version)
+		encoded, err := EncodeSchema(s, findCoordinatorResponseSchemaVersions[version])
+		a.Nil(err, "version %d", version)
+		a.Equal(response, encoded, "version %d", version)
+
+		modifier, err := GetResponseModifier(apiKeyFindCoordinator, version, testResponseModifier, nil)
+		a.Nil(err, "version %d", version)
+		result, err := modifier.Apply(response)
+		a.Nil(err, "version %d", version)
+		a.Equal(testFindCoordinatorResponse(version, mapped), result, "version %d", version)
+	}
+	_, err := GetResponseModifier(apiKeyFindCoordinator, int16(len(findCoordinatorResponseSchemaVersions)), testResponseModifier, nil)
+	a.NotNil(err)
+}
+
+func TestMetadataResponseV12NullTopicName(t *testing.T) {
+	a := assert.New(t)
+
+	response := testMetadataResponse(12, [][2]interface{}{{"localhost", int32(51)}})
+	// the topic requested by its id has the null name
+	offset := bytes.Index(response, []byte{0x04, 'f', 'o', 'o'})
+	response = append(append(append([]byte{}, response[:offset]...), 0x00), response[offset+4:]...)
+
+	s, err := DecodeSchema(response, metadataResponseSchemaVersions[12])
+	a.Nil(err)
+	topic := s.Get("topic_metadata").([]interface{})[0].(*Struct)
+	a.Nil(topic.Get("topic"))
+	a.Len(topic.Get("topic_id"), 16)
+	encoded, err := EncodeSchema(s, metadataResponseSchemaVersions[12])
+	a.Nil(err)
+	a.Equal(response, encoded)
+}
@@ -1,16 +1,25 @@
 package protocol
 
-type SaslAuthenticateRequestV0 struct {
+import "github.com/pkg/errors"
+
+type SaslAuthenticateRequestV0orV1 struct {
+	Version       int16 // not encoded / decoded
 	SaslAuthBytes []byte
 }
 
-func (r *SaslAuthenticateRequestV0) encode(pe packetEncoder) error {
+func (r *SaslAuthenticateRequestV0orV1) encode(pe packetEncoder) error {
+	if r.Version != 0 && r.Version != 1 {
+		return errors.New("SaslAuthenticateRequestV0orV1 expects version 0 or 1")
+	}
 	if err := pe.putBytes(r.SaslAuthBytes); err != nil {
 		return err
 	}
 	return nil
 }
-func (r *SaslAuthenticateRequestV0) decode(pd packetDecoder) (err error) {
+func (r *SaslAuthenticateRequestV0orV1) decode(pd packetDecoder) (err error) {
+	if r.Version != 0 && r.Version != 1 {
+		return errors.New("SaslAuthenticateRequestV0orV1 expects version 0 or 1")
+	}
 	if r.SaslAuthBytes, err = pd.getBytes(); err != nil {
 		return err
 	}
@@ -18,30 +27,38 @@ func (r *SaslAuthenticateRequestV0) decode(pd packetDecoder) (err error) {
 	return nil
 }
 
-func (r *SaslAuthenticateRequestV0) key() int16 {
+func (r *SaslAuthenticateRequestV0orV1) key() int16 {
 	return 36
 }
 
-func (r *SaslAuthenticateRequestV0) version() int16 {
-	return 0
+func (r *SaslAuthenticateRequestV0orV1) version() int16 {
+	return r.Version
 }
 
-type SaslAuthenticateResponseV0 struct {
-	Err           KError
-	ErrMsg        *string
-	SaslAuthBytes []byte
+type SaslAuthenticateResponseV0orV1 struct {
+	Version           int16 // not encoded / decoded
+	Err               KError
+	ErrMsg            *string
+	SaslAuthBytes     []byte
+	SessionLifetimeMs int64 // version 1 (KIP-368), 0 if the session does not expire
 }
 
-func (r *SaslAuthenticateResponseV0) encode(pe packetEncoder) error {
+func (r *SaslAuthenticateResponseV0orV1) encode(pe packetEncoder) error {
 	pe.putInt16(int16(r.Err))
 
 	if err := pe.putNullableString(r.ErrMsg); err != nil {
 		return err
 	}
-	return pe.putBytes(r.SaslAuthBytes)
+	if err := pe.putBytes(r.SaslAuthBytes); err != nil {
+		return err
+	}
+	if r.Version >= 1 {
+		pe.putInt64(r.SessionLifetimeMs)
+	}
+	return nil
 }
 
-func (r *SaslAuthenticateResponseV0) decode(pd packetDecoder) error {
+func (r *SaslAuthenticateResponseV0orV1) decode(pd packetDecoder) error {
 	kerr, err := pd.getInt16()
 	if err != nil {
 		return err
@@ -54,5 +71,10 @@ func (r *SaslAuthenticateResponseV0) decode(pd packetDecoder) error {
 	if r.SaslAuthBytes, err = pd.getBytes(); err != nil {
 		return err
 	}
+	if r.Version >= 1 {
+		if r.SessionLifetimeMs, err = pd.getInt64(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSaslAuthenticateRequest(t *testing.T) {
+	a := assert.New(t)
+
+	buf, err := Encode(&Request{ClientID: "c", Body: &SaslAuthenticateRequestV0orV1{Version: 1, SaslAuthBytes: []byte("\x00u\x00p")}})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x24, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 'c', 0x00, 0x00, 0x00, 0x04, 0x00, 'u', 0x00, 'p'}, buf)
+
+	request := &Request{Body: &SaslAuthenticateRequestV0orV1{Version: 0}}
+	a.EqualError(Decode(buf, request), "kafka: error decoding packet: expected request key,version 36,0 but got 36,1")
+	request = &Request{Body: &SaslAuthenticateRequestV0orV1{Version: 1}}
+	a.Nil(Decode(buf, request))
+	a.Equal([]byte("\x00u\x00p"), request.Body.(*SaslAuthenticateRequestV0orV1).SaslAuthBytes)
+}
+
+func TestSaslAuthenticateResponse(t *testing.T) {
+	a := assert.New(t)
+
+	errMsg := "invalid credentials"
+	for _, response := range []*SaslAuthenticateResponseV0orV1{
+		{Version: 0, Err: ErrSASLAuthenticationFailed, ErrMsg: &errMsg, SaslAuthBytes: []byte{}},
+		{Version: 1, Err: ErrNoError, SaslAuthBytes: []byte("token"), SessionLifetimeMs: 60000},
+	} {
+		buf, err := Encode(response)
+		a.Nil(err)
+		decoded := &SaslAuthenticateResponseV0orV1{Version: response.Version}
+		a.Nil(Decode(buf, decoded))
+		a.Equal(response, decoded)
+	}
+
+	// the session lifetime is not encoded in version 0
+	buf, err := Encode(&SaslAuthenticateResponseV0orV1{Version: 0, Err: ErrNoError, SaslAuthBytes: []byte{}, SessionLifetimeMs: 60000})
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00}, buf)
+}
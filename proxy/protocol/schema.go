@@ -8,10 +8,20 @@ import (
 
 var (
 	typeBool        = &Bool{}
+	typeInt8        = &Int8{}
 	typeInt16       = &Int16{}
 	typeInt32       = &Int32{}
+	typeInt64       = &Int64{}
 	typeStr         = &Str{}
 	typeNullableStr = &NullableStr{}
+	typeBytes       = &Bytes{}
+
+	// flexible versions (KIP-482)
+	typeCompactStr         = &CompactStr{}
+	typeCompactNullableStr = &CompactNullableStr{}
+	typeCompactBytes       = &CompactBytes{}
+	typeUuid               = &Uuid{}
+	typeTaggedFields       = &TaggedFields{}
 )
 
 type EncoderDecoder interface {
@@ -75,6 +85,23 @@ func (f *Bool) encode(pe packetEncoder, value interface{}) error {
 	return nil
 }
 
+// Field int8
+
+type Int8 struct{}
+
+func (f *Int8) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getInt8()
+}
+
+func (f *Int8) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(int8)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a int8", value)}
+	}
+	pe.putInt8(in)
+	return nil
+}
+
 // Field int16
 
 type Int16 struct{}
@@ -108,6 +135,39 @@ func (f *Int32) encode(pe packetEncoder, value interface{}) error {
 	return nil
 }
 
+// Field int64
+
+type Int64 struct{}
+
+func (f *Int64) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getInt64()
+}
+
+func (f *Int64) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(int64)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a int64", value)}
+	}
+	pe.putInt64(in)
+	return nil
+}
+
+// Field nullable bytes
+
+type Bytes struct{}
+
+func (f *Bytes) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getBytes()
+}
+
+func (f *Bytes) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]byte)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []byte", value)}
+	}
+	return pe.putBytes(in)
+}
+
 // Field string
 
 type Str struct {
@@ -147,6 +207,126 @@ func (f *NullableStr) encode(pe packetEncoder, value interface{}) error {
 	return pe.putNullableString(in)
 }
 
+// Field compact string
+
+type CompactStr struct{}
+
+func (f *CompactStr) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getCompactString()
+}
+
+func (f *CompactStr) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(string)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a string", value)}
+	}
+	return pe.putCompactString(in)
+}
+
+// Field compact nullable string
+
+type CompactNullableStr struct{}
+
+func (f *CompactNullableStr) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getCompactNullableString()
+}
+
+func (f *CompactNullableStr) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.(*string)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a *string", value)}
+	}
+	return pe.putCompactNullableString(in)
+}
+
+// Field compact nullable bytes
+
+type CompactBytes struct{}
+
+func (f *CompactBytes) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getCompactBytes()
+}
+
+func (f *CompactBytes) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]byte)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []byte", value)}
+	}
+	return pe.putCompactBytes(in)
+}
+
+// Field uuid
+
+type Uuid struct{}
+
+func (f *Uuid) decode(pd packetDecoder) (interface{}, error) {
+	return pd.getRawBytes(16)
+}
+
+func (f *Uuid) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]byte)
+	if !ok || len(in) != 16 {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a 16 bytes uuid", value)}
+	}
+	return pe.putRawBytes(in)
+}
+
+// Field tagged fields
+
+// TaggedField is the raw tagged field of the flexible versions. The tagged fields are not interpreted, they are encoded as they were received.
+type TaggedField struct {
+	Tag  uint64
+	Data []byte
+}
+
+type TaggedFields struct{}
+
+func (f *TaggedFields) decode(pd packetDecoder) (interface{}, error) {
+	n, err := pd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(pd.remaining()) {
+		return nil, ErrInsufficientData
+	}
+	result := make([]TaggedField, 0, n)
+	for i := uint64(0); i < n; i++ {
+		tag, err := pd.getUVarint()
+		if err != nil {
+			return nil, err
+		}
+		length, err := pd.getUVarint()
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(pd.remaining()) {
+			return nil, ErrInsufficientData
+		}
+		data, err := pd.getRawBytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, TaggedField{Tag: tag, Data: data})
+	}
+	return result, nil
+}
+
+func (f *TaggedFields) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]TaggedField)
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []TaggedField", value)}
+	}
+	pe.putUVarint(uint64(len(in)))
+	for _, taggedField := range in {
+		pe.putUVarint(taggedField.Tag)
+		pe.putUVarint(uint64(len(taggedField.Data)))
+		if err := pe.putRawBytes(taggedField.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type array struct {
 	name string
 	ty   EncoderDecoder
@@ -157,6 +337,10 @@ func (f *array) decode(pd packetDecoder) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if n == -1 {
+		// null array
+		return []interface{}(nil), nil
+	}
 	// We could allocate the capacity at once, but in case of malformed payload we could allocate too much memory.
 	result := make([]interface{}, 0)
 
@@ -176,6 +360,10 @@ func (f *array) encode(pe packetEncoder, value interface{}) error {
 	if !ok {
 		return SchemaEncodingError{fmt.Sprintf("value %T not a []interface{}", value)}
 	}
+	if in == nil {
+		// null array
+		return pe.putArrayLength(-1)
+	}
 
 	err := pe.putArrayLength(len(in))
 	if err != nil {
@@ -195,6 +383,58 @@ func (f *array) GetName() string {
 	return f.name
 }
 
+// compactArray is the array of the flexible versions, its length is encoded as the unsigned varint of the length plus one
+
+type compactArray struct {
+	name string
+	ty   EncoderDecoder
+}
+
+func (f *compactArray) decode(pd packetDecoder) (interface{}, error) {
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return nil, err
+	}
+	if n == -1 {
+		// null array
+		return []interface{}(nil), nil
+	}
+	result := make([]interface{}, 0)
+
+	for i := 0; i < n; i++ {
+		elem, err := f.ty.decode(pd)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, elem)
+	}
+	return result, nil
+}
+
+func (f *compactArray) encode(pe packetEncoder, value interface{}) error {
+	in, ok := value.([]interface{})
+	if !ok {
+		return SchemaEncodingError{fmt.Sprintf("value %T not a []interface{}", value)}
+	}
+	if in == nil {
+		// null array
+		return pe.putCompactArrayLength(-1)
+	}
+	if err := pe.putCompactArrayLength(len(in)); err != nil {
+		return err
+	}
+	for _, elem := range in {
+		if err := f.ty.encode(pe, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *compactArray) GetName() string {
+	return f.name
+}
+
 type Struct struct {
 	schema *schema
 	values []interface{}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// throttleTimeVersions are the versions of the responses starting with throttle_time_ms
+type throttleTimeVersions struct {
+	since    int16 // the first version with throttle_time_ms
+	flexible int16 // the first version with the response header v1 (tagged fields)
+}
+
+var throttleTimeFirstField = map[int16]throttleTimeVersions{
+	apiKeyFetch:                {since: 1, flexible: 12},
+	apiKeyListOffsets:          {since: 2, flexible: 6},
+	apiKeyMetadata:             {since: 3, flexible: 9},
+	apiKeyOffsetCommit:         {since: 3, flexible: 8},
+	apiKeyOffsetFetch:          {since: 3, flexible: 6},
+	apiKeyFindCoordinator:      {since: 1, flexible: 3},
+	apiKeyJoinGroup:            {since: 2, flexible: 6},
+	apiKeyHeartbeat:            {since: 1, flexible: 4},
+	apiKeyLeaveGroup:           {since: 1, flexible: 4},
+	apiKeySyncGroup:            {since: 1, flexible: 4},
+	apiKeyDescribeGroups:       {since: 1, flexible: 5},
+	apiKeyListGroups:           {since: 1, flexible: 3},
+	apiKeyCreateTopics:         {since: 2, flexible: 5},
+	apiKeyDeleteTopics:         {since: 1, flexible: 4},
+	apiKeyDeleteRecords:        {since: 0, flexible: 2},
+	apiKeyInitProducerId:       {since: 0, flexible: 2},
+	apiKeyOffsetForLeaderEpoch: {since: 2, flexible: 4},
+	apiKeyAddPartitionsToTxn:   {since: 0, flexible: 3},
+	apiKeyAddOffsetsToTxn:      {since: 0, flexible: 3},
+	apiKeyEndTxn:               {since: 0, flexible: 3},
+	apiKeyTxnOffsetCommit:      {since: 0, flexible: 3},
+	apiKeyDescribeConfigs:      {since: 0, flexible: 4},
+	apiKeyAlterConfigs:         {since: 0, flexible: 2},
+}
+
+// the Produce responses v1 - v8 end with throttle_time_ms, the flexible versions end with the tagged fields
+const (
+	minProduceThrottleTimeVersion = 1
+	maxProduceThrottleTimeVersion = 8
+)
+
+// ThrottleTimeSupported returns true when the throttle time of the response can be set by SetThrottleTime
+func ThrottleTimeSupported(apiKey int16, apiVersion int16) bool {
+	if apiKey == apiKeyProduce {
+		return apiVersion >= minProduceThrottleTimeVersion && apiVersion <= maxProduceThrottleTimeVersion
+	}
+	versions, ok := throttleTimeFirstField[apiKey]
+	return ok && apiVersion >= versions.since
+}
+
+// SetThrottleTime returns the copy of the response (without the size and the correlation id) whose throttle_time_ms is raised
+// to the throttle time, the greater throttle time of the broker is kept
+func SetThrottleTime(apiKey int16, apiVersion int16, response []byte, throttleTimeMs int32) ([]byte, error) {
+	if !ThrottleTimeSupported(apiKey, apiVersion) {
+		return nil, fmt.Errorf("throttle time of the response with api key %d version %d is not supported", apiKey, apiVersion)
+	}
+	offset := 0
+	if apiKey == apiKeyProduce {
+		offset = len(response) - 4
+	} else if apiVersion >= throttleTimeFirstField[apiKey].flexible {
+		var err error
+		if offset, err = skipFlexibleTaggedFields(response, 0); err != nil {
+			return nil, err
+		}
+	}
+	if offset < 0 || offset+4 > len(response) {
+		return nil, PacketDecodingError{Info: fmt.Sprintf("response with api key %d version %d is too short for the throttle time", apiKey, apiVersion)}
+	}
+	result := make([]byte, len(response))
+	copy(result, response)
+	if int32(binary.BigEndian.Uint32(result[offset:])) < throttleTimeMs {
+		binary.BigEndian.PutUint32(result[offset:], uint32(throttleTimeMs))
+	}
+	return result, nil
+}
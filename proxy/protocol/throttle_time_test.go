@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSetThrottleTime(t *testing.T) {
+	a := assert.New(t)
+
+	for version := int16(3); version < int16(len(metadataResponseSchemaVersions)); version++ {
+		response := testMetadataResponse(version, [][2]interface{}{{"localhost", int32(51)}})
+		offset := 0
+		if version >= 9 {
+			// the tagged fields of the response header
+			offset = 5
+		}
+		result, err := SetThrottleTime(apiKeyMetadata, version, response, 250)
+		a.Nil(err, "version %d", version)
+		a.Equal(int32(250), int32(binary.BigEndian.Uint32(result[offset:])), "version %d", version)
+		a.Equal(response[offset+4:], result[offset+4:], "version %d", version)
+		// the response is not modified
+		a.Equal(int32(1), int32(binary.BigEndian.Uint32(response[offset:])), "version %d", version)
+
+		// the greater throttle time of the broker is kept
+		result, err = SetThrottleTime(apiKeyMetadata, version, response, 0)
+		a.Nil(err, "version %d", version)
+		a.Equal(response, result, "version %d", version)
+	}
+
+	// the Produce response ends with the throttle time
+	result, err := SetThrottleTime(apiKeyProduce, 2, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07}, 1000)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0xe8}, result)
+
+	a.True(ThrottleTimeSupported(apiKeyFetch, 1))
+	a.False(ThrottleTimeSupported(apiKeyFetch, 0))
+	a.False(ThrottleTimeSupported(apiKeyMetadata, 2))
+	a.False(ThrottleTimeSupported(apiKeyProduce, 9))
+	a.False(ThrottleTimeSupported(apiKeyApiVersions, 3))
+	_, err = SetThrottleTime(apiKeyProduce, 9, []byte{0x00, 0x00, 0x00, 0x00}, 1000)
+	a.NotNil(err)
+	_, err = SetThrottleTime(apiKeyHeartbeat, 1, []byte{0x00}, 1000)
+	a.NotNil(err)
+	_, err = SetThrottleTime(apiKeyHeartbeat, 4, []byte{}, 1000)
+	a.NotNil(err)
+}
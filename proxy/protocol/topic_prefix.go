@@ -0,0 +1,667 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	resourceTypeKeyName = "resource_type"
+	resourceNameKeyName = "resource_name"
+)
+
+var (
+	// topicPrefixResponseSchemaVersions are the responses to the requests whose topic names can be prefixed
+	topicPrefixResponseSchemaVersions = map[int16][]Schema{
+		apiKeyProduce:                 createProduceResponseSchemaVersions(),
+		apiKeyFetch:                   fetchResponseSchemaVersions,
+		apiKeyListOffsets:             createListOffsetsResponseSchemaVersions(),
+		apiKeyMetadata:                metadataResponseSchemaVersions,
+		apiKeyOffsetCommit:            createOffsetCommitResponseSchemaVersions(),
+		apiKeyOffsetFetch:             createOffsetFetchResponseSchemaVersions(),
+		apiKeyCreateTopics:            createCreateTopicsResponseSchemaVersions(),
+		apiKeyDeleteTopics:            createDeleteTopicsResponseSchemaVersions(),
+		apiKeyDeleteRecords:           createDeleteRecordsResponseSchemaVersions(),
+		apiKeyOffsetForLeaderEpoch:    createOffsetForLeaderEpochResponseSchemaVersions(),
+		apiKeyAddPartitionsToTxn:      createAddPartitionsToTxnResponseSchemaVersions(),
+		apiKeyTxnOffsetCommit:         createTxnOffsetCommitResponseSchemaVersions(),
+		apiKeyDescribeConfigs:         createDescribeConfigsResponseSchemaVersions(),
+		apiKeyAlterConfigs:            createAlterConfigsResponseSchemaVersions(),
+		apiKeyIncrementalAlterConfigs: createAlterConfigsResponseSchemaVersions()[:1],
+		apiKeyCreatePartitions:        createCreatePartitionsResponseSchemaVersions(),
+	}
+
+	// MaxTopicPrefixVersions are the highest versions of the requests and responses which AddTopicPrefix and RemoveTopicPrefix support.
+	MaxTopicPrefixVersions = createMaxTopicPrefixVersions()
+)
+
+func createMaxTopicPrefixVersions() map[int16]int16 {
+	result := make(map[int16]int16)
+	for apiKey, responseSchemas := range topicPrefixResponseSchemaVersions {
+		maxVersion := len(requestSchemaVersions[apiKey])
+		if len(responseSchemas) < maxVersion {
+			maxVersion = len(responseSchemas)
+		}
+		result[apiKey] = int16(maxVersion - 1)
+	}
+	return result
+}
+
+func createProduceResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("produce_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+	)
+
+	partitionV2 := NewSchema("produce_response_partition_v2",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+	)
+
+	partitionV5 := NewSchema("produce_response_partition_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+	)
+
+	recordErrorV8 := NewSchema("produce_response_record_error_v8",
+		&field{name: "batch_index", ty: typeInt32},
+		&field{name: "batch_index_error_message", ty: typeNullableStr},
+	)
+
+	partitionV8 := NewSchema("produce_response_partition_v8",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "base_offset", ty: typeInt64},
+		&field{name: "log_append_time", ty: typeInt64},
+		&field{name: "log_start_offset", ty: typeInt64},
+		&array{name: "record_errors", ty: recordErrorV8},
+		&field{name: "error_message", ty: typeNullableStr},
+	)
+
+	topicResponse := func(name string, partitionResponse Schema) Schema {
+		return NewSchema(name,
+			&field{name: topicKeyName, ty: typeStr},
+			&array{name: "partition_responses", ty: partitionResponse},
+		)
+	}
+
+	produceResponseV0 := NewSchema("produce_response_v0",
+		&array{name: "responses", ty: topicResponse("produce_response_topic_v0", partitionV0)},
+	)
+
+	produceResponseV1 := NewSchema("produce_response_v1",
+		&array{name: "responses", ty: topicResponse("produce_response_topic_v1", partitionV0)},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV2 := NewSchema("produce_response_v2",
+		&array{name: "responses", ty: topicResponse("produce_response_topic_v2", partitionV2)},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV5 := NewSchema("produce_response_v5",
+		&array{name: "responses", ty: topicResponse("produce_response_topic_v5", partitionV5)},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	produceResponseV8 := NewSchema("produce_response_v8",
+		&array{name: "responses", ty: topicResponse("produce_response_topic_v8", partitionV8)},
+		&field{name: "throttle_time_ms", ty: typeInt32},
+	)
+
+	return []Schema{produceResponseV0, produceResponseV1, produceResponseV2, produceResponseV2, produceResponseV2, produceResponseV5, produceResponseV5, produceResponseV5, produceResponseV8}
+}
+
+func createListOffsetsResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("list_offsets_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&array{name: "offsets", ty: typeInt64},
+	)
+
+	partitionV1 := NewSchema("list_offsets_response_partition_v1",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "offset", ty: typeInt64},
+	)
+
+	partitionV4 := NewSchema("list_offsets_response_partition_v4",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "timestamp", ty: typeInt64},
+		&field{name: "offset", ty: typeInt64},
+		&field{name: "leader_epoch", ty: typeInt32},
+	)
+
+	topicResponse := func(name string, partitionResponse Schema) Schema {
+		return NewSchema(name,
+			&field{name: topicKeyName, ty: typeStr},
+			&array{name: "partition_responses", ty: partitionResponse},
+		)
+	}
+
+	listOffsetsResponseV0 := NewSchema("list_offsets_response_v0",
+		&array{name: "responses", ty: topicResponse("list_offsets_response_topic_v0", partitionV0)},
+	)
+
+	listOffsetsResponseV1 := NewSchema("list_offsets_response_v1",
+		&array{name: "responses", ty: topicResponse("list_offsets_response_topic_v1", partitionV1)},
+	)
+
+	listOffsetsResponseV2 := NewSchema("list_offsets_response_v2",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: topicResponse("list_offsets_response_topic_v2", partitionV1)},
+	)
+
+	listOffsetsResponseV4 := NewSchema("list_offsets_response_v4",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "responses", ty: topicResponse("list_offsets_response_topic_v4", partitionV4)},
+	)
+
+	return []Schema{listOffsetsResponseV0, listOffsetsResponseV1, listOffsetsResponseV2, listOffsetsResponseV2, listOffsetsResponseV4, listOffsetsResponseV4}
+}
+
+func createOffsetCommitResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_commit_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicV0 := NewSchema("offset_commit_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	offsetCommitResponseV0 := NewSchema("offset_commit_response_v0",
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetCommitResponseV3 := NewSchema("offset_commit_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	return []Schema{offsetCommitResponseV0, offsetCommitResponseV0, offsetCommitResponseV0, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3, offsetCommitResponseV3}
+}
+
+func createOffsetFetchResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_fetch_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "committed_offset", ty: typeInt64},
+		&field{name: "metadata", ty: typeNullableStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	partitionV5 := NewSchema("offset_fetch_response_partition_v5",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "committed_offset", ty: typeInt64},
+		&field{name: "committed_leader_epoch", ty: typeInt32},
+		&field{name: "metadata", ty: typeNullableStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicV0 := NewSchema("offset_fetch_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV5 := NewSchema("offset_fetch_response_topic_v5",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV5},
+	)
+
+	offsetFetchResponseV0 := NewSchema("offset_fetch_response_v0",
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetFetchResponseV2 := NewSchema("offset_fetch_response_v2",
+		&array{name: topicsKeyName, ty: topicV0},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	offsetFetchResponseV3 := NewSchema("offset_fetch_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	offsetFetchResponseV5 := NewSchema("offset_fetch_response_v5",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV5},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	return []Schema{offsetFetchResponseV0, offsetFetchResponseV0, offsetFetchResponseV2, offsetFetchResponseV3, offsetFetchResponseV3, offsetFetchResponseV5}
+}
+
+func createCreateTopicsResponseSchemaVersions() []Schema {
+	topicErrorV0 := NewSchema("create_topics_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicErrorV1 := NewSchema("create_topics_response_topic_v1",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "error_message", ty: typeNullableStr},
+	)
+
+	createTopicsResponseV0 := NewSchema("create_topics_response_v0",
+		&array{name: "topic_errors", ty: topicErrorV0},
+	)
+
+	createTopicsResponseV1 := NewSchema("create_topics_response_v1",
+		&array{name: "topic_errors", ty: topicErrorV1},
+	)
+
+	createTopicsResponseV2 := NewSchema("create_topics_response_v2",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "topic_errors", ty: topicErrorV1},
+	)
+
+	return []Schema{createTopicsResponseV0, createTopicsResponseV1, createTopicsResponseV2, createTopicsResponseV2, createTopicsResponseV2}
+}
+
+func createDeleteTopicsResponseSchemaVersions() []Schema {
+	topicErrorV0 := NewSchema("delete_topics_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	deleteTopicsResponseV0 := NewSchema("delete_topics_response_v0",
+		&array{name: "topic_error_codes", ty: topicErrorV0},
+	)
+
+	deleteTopicsResponseV1 := NewSchema("delete_topics_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "topic_error_codes", ty: topicErrorV0},
+	)
+
+	return []Schema{deleteTopicsResponseV0, deleteTopicsResponseV1, deleteTopicsResponseV1, deleteTopicsResponseV1}
+}
+
+func createDeleteRecordsResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("delete_records_response_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "low_watermark", ty: typeInt64},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicV0 := NewSchema("delete_records_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	deleteRecordsResponseV0 := NewSchema("delete_records_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	return []Schema{deleteRecordsResponseV0, deleteRecordsResponseV0}
+}
+
+func createOffsetForLeaderEpochResponseSchemaVersions() []Schema {
+	partitionV0 := NewSchema("offset_for_leader_epoch_response_partition_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "end_offset", ty: typeInt64},
+	)
+
+	partitionV1 := NewSchema("offset_for_leader_epoch_response_partition_v1",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "leader_epoch", ty: typeInt32},
+		&field{name: "end_offset", ty: typeInt64},
+	)
+
+	topicV0 := NewSchema("offset_for_leader_epoch_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	topicV1 := NewSchema("offset_for_leader_epoch_response_topic_v1",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV1},
+	)
+
+	offsetForLeaderEpochResponseV0 := NewSchema("offset_for_leader_epoch_response_v0",
+		&array{name: topicsKeyName, ty: topicV0},
+	)
+
+	offsetForLeaderEpochResponseV1 := NewSchema("offset_for_leader_epoch_response_v1",
+		&array{name: topicsKeyName, ty: topicV1},
+	)
+
+	offsetForLeaderEpochResponseV2 := NewSchema("offset_for_leader_epoch_response_v2",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsKeyName, ty: topicV1},
+	)
+
+	return []Schema{offsetForLeaderEpochResponseV0, offsetForLeaderEpochResponseV1, offsetForLeaderEpochResponseV2, offsetForLeaderEpochResponseV2}
+}
+
+// partitionErrorsResponseSchema is the response with the throttle time and the partition errors of the topics
+func partitionErrorsResponseSchema(name string, topicsName string) Schema {
+	partitionV0 := NewSchema(name+"_partition_v0",
+		&field{name: "partition", ty: typeInt32},
+		&field{name: "error_code", ty: typeInt16},
+	)
+
+	topicV0 := NewSchema(name+"_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&array{name: "partitions", ty: partitionV0},
+	)
+
+	return NewSchema(name+"_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: topicsName, ty: topicV0},
+	)
+}
+
+func createAddPartitionsToTxnResponseSchemaVersions() []Schema {
+	addPartitionsToTxnResponseV0 := partitionErrorsResponseSchema("add_partitions_to_txn_response", "errors")
+
+	return []Schema{addPartitionsToTxnResponseV0, addPartitionsToTxnResponseV0, addPartitionsToTxnResponseV0}
+}
+
+func createTxnOffsetCommitResponseSchemaVersions() []Schema {
+	txnOffsetCommitResponseV0 := partitionErrorsResponseSchema("txn_offset_commit_response", topicsKeyName)
+
+	return []Schema{txnOffsetCommitResponseV0, txnOffsetCommitResponseV0, txnOffsetCommitResponseV0}
+}
+
+func createDescribeConfigsResponseSchemaVersions() []Schema {
+	configEntryV0 := NewSchema("describe_configs_response_entry_v0",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+		&field{name: "read_only", ty: typeBool},
+		&field{name: "is_default", ty: typeBool},
+		&field{name: "is_sensitive", ty: typeBool},
+	)
+
+	configSynonymV1 := NewSchema("describe_configs_response_synonym_v1",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+		&field{name: "config_source", ty: typeInt8},
+	)
+
+	configEntryV1 := NewSchema("describe_configs_response_entry_v1",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+		&field{name: "read_only", ty: typeBool},
+		&field{name: "config_source", ty: typeInt8},
+		&field{name: "is_sensitive", ty: typeBool},
+		&array{name: "config_synonyms", ty: configSynonymV1},
+	)
+
+	configEntryV3 := NewSchema("describe_configs_response_entry_v3",
+		&field{name: "config_name", ty: typeStr},
+		&field{name: "config_value", ty: typeNullableStr},
+		&field{name: "read_only", ty: typeBool},
+		&field{name: "config_source", ty: typeInt8},
+		&field{name: "is_sensitive", ty: typeBool},
+		&array{name: "config_synonyms", ty: configSynonymV1},
+		&field{name: "config_type", ty: typeInt8},
+		&field{name: "config_documentation", ty: typeNullableStr},
+	)
+
+	resource := func(name string, configEntry Schema) Schema {
+		return NewSchema(name,
+			&field{name: "error_code", ty: typeInt16},
+			&field{name: "error_message", ty: typeNullableStr},
+			&field{name: resourceTypeKeyName, ty: typeInt8},
+			&field{name: resourceNameKeyName, ty: typeStr},
+			&array{name: "config_entries", ty: configEntry},
+		)
+	}
+
+	describeConfigsResponseV0 := NewSchema("describe_configs_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "resources", ty: resource("describe_configs_response_resource_v0", configEntryV0)},
+	)
+
+	describeConfigsResponseV1 := NewSchema("describe_configs_response_v1",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "resources", ty: resource("describe_configs_response_resource_v1", configEntryV1)},
+	)
+
+	describeConfigsResponseV3 := NewSchema("describe_configs_response_v3",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "resources", ty: resource("describe_configs_response_resource_v3", configEntryV3)},
+	)
+
+	return []Schema{describeConfigsResponseV0, describeConfigsResponseV1, describeConfigsResponseV1, describeConfigsResponseV3}
+}
+
+// createAlterConfigsResponseSchemaVersions are the responses to AlterConfigs and IncrementalAlterConfigs
+func createAlterConfigsResponseSchemaVersions() []Schema {
+	resourceV0 := NewSchema("alter_configs_response_resource_v0",
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "error_message", ty: typeNullableStr},
+		&field{name: resourceTypeKeyName, ty: typeInt8},
+		&field{name: resourceNameKeyName, ty: typeStr},
+	)
+
+	alterConfigsResponseV0 := NewSchema("alter_configs_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "resources", ty: resourceV0},
+	)
+
+	return []Schema{alterConfigsResponseV0, alterConfigsResponseV0}
+}
+
+func createCreatePartitionsResponseSchemaVersions() []Schema {
+	topicErrorV0 := NewSchema("create_partitions_response_topic_v0",
+		&field{name: topicKeyName, ty: typeStr},
+		&field{name: "error_code", ty: typeInt16},
+		&field{name: "error_message", ty: typeNullableStr},
+	)
+
+	createPartitionsResponseV0 := NewSchema("create_partitions_response_v0",
+		&field{name: "throttle_time_ms", ty: typeInt32},
+		&array{name: "topic_errors", ty: topicErrorV0},
+	)
+
+	return []Schema{createPartitionsResponseV0, createPartitionsResponseV0}
+}
+
+// TopicPrefixSupported checks whether the topic names of the request and its response can be prefixed, with groups
+// also the group ids and the transactional ids. The requests which never reference topics are supported without groups.
+func TopicPrefixSupported(apiKey int16, apiVersion int16, groups bool) bool {
+	if groups {
+		if maxVersion, ok := MaxGroupPrefixVersions[apiKey]; ok {
+			return apiVersion >= 0 && apiVersion <= maxVersion
+		}
+	}
+	if _, ok := requestsWithoutTopics[apiKey]; ok {
+		return true
+	}
+	maxVersion, ok := MaxTopicPrefixVersions[apiKey]
+	return ok && apiVersion >= 0 && apiVersion <= maxVersion
+}
+
+// AddTopicPrefix adds the prefix to the topic names of the request, with groups also to the group ids and the transactional ids.
+// The requests for all topics are not changed.
+// The request starts with the header (ApiKey, ApiVersion, CorrelationId, ClientId) and does not contain the size.
+func AddTopicPrefix(request []byte, prefix string, groups bool) ([]byte, error) {
+	if len(request) < 4 {
+		return nil, PacketDecodingError{Info: "request is too short"}
+	}
+	apiKey, apiVersion := int16(binary.BigEndian.Uint16(request)), int16(binary.BigEndian.Uint16(request[2:]))
+	if !TopicPrefixSupported(apiKey, apiVersion, groups) {
+		return nil, fmt.Errorf("topic prefix is not supported for api key %d version %d", apiKey, apiVersion)
+	}
+	schemas, ok := requestSchemaVersions[apiKey]
+	if groups {
+		if groupSchemas, isGroup := groupRequestSchemaVersions[apiKey]; isGroup {
+			schemas, ok = groupSchemas, true
+		}
+	}
+	if !ok {
+		// requests without topics
+		return request, nil
+	}
+	helper := realDecoder{raw: request}
+	if _, err := requestHeaderSchema.decode(&helper); err != nil {
+		return nil, err
+	}
+	schema := schemas[apiVersion]
+	body, err := DecodeSchema(request[helper.off:], schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = addTopicPrefix(body, prefix, groups); err != nil {
+		return nil, err
+	}
+	newBody, err := EncodeSchema(body, schema)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, helper.off+len(newBody))
+	result = append(result, request[:helper.off]...)
+	return append(result, newBody...), nil
+}
+
+// RemoveTopicPrefix removes the prefix from the topic names of the response, with groups also from the group ids.
+// The topics and groups without the prefix (e.g. in the response to the request for all topics) are removed from the response.
+// The response does not contain the size and CorrelationId.
+func RemoveTopicPrefix(apiKey int16, apiVersion int16, response []byte, prefix string, groups bool) ([]byte, error) {
+	schemas, ok := topicPrefixResponseSchemaVersions[apiKey]
+	if groups && !ok {
+		schemas, ok = groupResponseSchemaVersions[apiKey]
+	}
+	if !ok {
+		return response, nil
+	}
+	schema, err := getResponseSchema(apiKey, apiVersion, schemas)
+	if err != nil {
+		return nil, err
+	}
+	body, err := DecodeSchema(response, schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = removeTopicPrefix(body, prefix, groups); err != nil {
+		return nil, err
+	}
+	return EncodeSchema(body, schema)
+}
+
+// addTopicPrefix prefixes the fields named topic, the string arrays named topics and the names of topic resources,
+// with groups also the group ids, the transactional ids and the string arrays named groups
+func addTopicPrefix(s *Struct, prefix string, groups bool) error {
+	if name, ok := s.Get(resourceNameKeyName).(string); ok && isTopicResource(s) {
+		if err := s.Replace(resourceNameKeyName, prefix+name); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.schema.fields {
+		name := f.def.GetName()
+		switch value := s.Get(name).(type) {
+		case string:
+			if name == topicKeyName || (groups && isGroupField(name)) {
+				if err := s.Replace(name, prefix+value); err != nil {
+					return err
+				}
+			}
+		case *string:
+			if value != nil && groups && isGroupField(name) {
+				prefixed := prefix + *value
+				if err := s.Replace(name, &prefixed); err != nil {
+					return err
+				}
+			}
+		case *Struct:
+			if err := addTopicPrefix(value, prefix, groups); err != nil {
+				return err
+			}
+		case []interface{}:
+			for i, elem := range value {
+				switch e := elem.(type) {
+				case *Struct:
+					if err := addTopicPrefix(e, prefix, groups); err != nil {
+						return err
+					}
+				case string:
+					if name == topicsKeyName || (groups && name == groupsKeyName) {
+						value[i] = prefix + e
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removeTopicPrefix removes the prefix from the topic names and group ids, array elements of topics or groups without the prefix are removed
+func removeTopicPrefix(s *Struct, prefix string, groups bool) error {
+	for _, f := range s.schema.fields {
+		name := f.def.GetName()
+		switch value := s.Get(name).(type) {
+		case *Struct:
+			if err := removeTopicPrefix(value, prefix, groups); err != nil {
+				return err
+			}
+		case []interface{}:
+			if value == nil {
+				continue
+			}
+			result := make([]interface{}, 0, len(value))
+			for _, elem := range value {
+				if e, ok := elem.(*Struct); ok {
+					if fieldName, id := structNamespacedID(e, groups); fieldName != "" {
+						if !strings.HasPrefix(id, prefix) {
+							continue
+						}
+						if err := e.Replace(fieldName, strings.TrimPrefix(id, prefix)); err != nil {
+							return err
+						}
+					}
+					if err := removeTopicPrefix(e, prefix, groups); err != nil {
+						return err
+					}
+				}
+				result = append(result, elem)
+			}
+			if err := s.Replace(name, result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structNamespacedID returns the name of the field with the topic name (with groups also the group id) and its value
+func structNamespacedID(s *Struct, groups bool) (string, string) {
+	if isTopicResource(s) {
+		if topic, ok := s.Get(resourceNameKeyName).(string); ok {
+			return resourceNameKeyName, topic
+		}
+	}
+	if topic, ok := s.Get(topicKeyName).(string); ok {
+		return topicKeyName, topic
+	}
+	if groups {
+		if group, ok := s.Get(groupIdKeyName).(string); ok {
+			return groupIdKeyName, group
+		}
+	}
+	return "", ""
+}
+
+func isTopicResource(s *Struct) bool {
+	resourceType, ok := s.Get(resourceTypeKeyName).(int8)
+	return ok && resourceType == resourceTypeTopic
+}
+
+func isGroupField(name string) bool {
+	return name == groupIdKeyName || name == transactionalIdKeyName || name == findCoordinatorKeyName
+}
@@ -0,0 +1,259 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testSchemaValue returns the value with one element in every array and the topic t in every topic name
+func testSchemaValue(ty EncoderDecoder, name string) interface{} {
+	switch t := ty.(type) {
+	case *schema:
+		s := &Struct{schema: t}
+		for _, f := range t.fields {
+			switch def := f.def.(type) {
+			case *field:
+				s.values = append(s.values, testSchemaValue(def.ty, def.name))
+			case *array:
+				s.values = append(s.values, []interface{}{testSchemaValue(def.ty, def.name)})
+			case *compactArray:
+				s.values = append(s.values, []interface{}{testSchemaValue(def.ty, def.name)})
+			}
+		}
+		return s
+	case *array:
+		return []interface{}{testSchemaValue(t.ty, t.name)}
+	case *compactArray:
+		return []interface{}{testSchemaValue(t.ty, t.name)}
+	case *Bool:
+		return false
+	case *Int8:
+		if name == resourceTypeKeyName {
+			return int8(resourceTypeTopic)
+		}
+		return int8(1)
+	case *Int16:
+		return int16(1)
+	case *Int32:
+		return int32(1)
+	case *Int64:
+		return int64(1)
+	case *Str:
+		return "t"
+	case *NullableStr:
+		if name == transactionalIdKeyName {
+			value := "t"
+			return &value
+		}
+		return (*string)(nil)
+	case *Bytes:
+		return []byte{}
+	case *CompactStr:
+		return "t"
+	case *CompactNullableStr:
+		if name == topicKeyName {
+			value := "t"
+			return &value
+		}
+		return (*string)(nil)
+	case *Uuid:
+		return make([]byte, 16)
+	case *TaggedFields:
+		return []TaggedField{}
+	}
+	panic("unknown type")
+}
+
+func testTopicPrefixRequest(t *testing.T, apiKey int16, apiVersion int16) []byte {
+	schemas, ok := groupRequestSchemaVersions[apiKey]
+	if !ok {
+		schemas = requestSchemaVersions[apiKey]
+	}
+	schema := schemas[apiVersion]
+	body, err := EncodeSchema(testSchemaValue(schema, "").(*Struct), schema)
+	assert.Nil(t, err)
+	request := []byte{byte(apiKey >> 8), byte(apiKey), byte(apiVersion >> 8), byte(apiVersion), 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c'}
+	return append(request, body...)
+}
+
+func TestMaxTopicPrefixVersions(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(int16(8), MaxTopicPrefixVersions[apiKeyProduce])
+	a.Equal(int16(11), MaxTopicPrefixVersions[apiKeyFetch])
+	a.Equal(int16(8), MaxTopicPrefixVersions[apiKeyMetadata])
+	a.Equal(int16(0), MaxTopicPrefixVersions[apiKeyIncrementalAlterConfigs])
+	_, ok := MaxTopicPrefixVersions[apiKeyElectLeaders]
+	a.False(ok)
+
+	a.True(TopicPrefixSupported(apiKeyApiVersions, 3, false))
+	a.True(TopicPrefixSupported(apiKeyMetadata, 8, false))
+	a.False(TopicPrefixSupported(apiKeyMetadata, 9, false))
+	a.False(TopicPrefixSupported(apiKeyElectLeaders, 0, false))
+
+	a.True(TopicPrefixSupported(apiKeyJoinGroup, 7, false))
+	a.True(TopicPrefixSupported(apiKeyJoinGroup, 5, true))
+	a.False(TopicPrefixSupported(apiKeyJoinGroup, 6, true))
+	a.Equal(int16(4), MaxGroupPrefixVersions[apiKeyDescribeGroups])
+	a.Equal(int16(1), MaxGroupPrefixVersions[apiKeyDeleteGroups])
+}
+
+func TestTopicPrefixRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	for apiKey, maxVersion := range MaxTopicPrefixVersions {
+		for apiVersion := int16(0); apiVersion <= maxVersion; apiVersion++ {
+			request := testTopicPrefixRequest(t, apiKey, apiVersion)
+
+			prefixed, err := AddTopicPrefix(request, "tenant-a.", false)
+			a.Nil(err, "api key %d version %d", apiKey, apiVersion)
+			info, err := DecodeRequestInfo(prefixed)
+			a.Nil(err)
+			a.Equal([]string{"tenant-a.t"}, info.Topics, "api key %d version %d", apiKey, apiVersion)
+
+			// the responses are decoded by the response schemas
+			expected, err := EncodeErrorResponse(request, int16(ErrTopicAuthorizationFailed))
+			a.Nil(err)
+			response, err := EncodeErrorResponse(prefixed, int16(ErrTopicAuthorizationFailed))
+			a.Nil(err)
+			response, err = RemoveTopicPrefix(apiKey, apiVersion, response, "tenant-a.", false)
+			a.Nil(err, "api key %d version %d", apiKey, apiVersion)
+			a.Equal(expected, response, "api key %d version %d", apiKey, apiVersion)
+		}
+	}
+}
+
+func TestAddTopicPrefixRequestsWithoutTopics(t *testing.T) {
+	a := assert.New(t)
+
+	// ApiVersions v0
+	request := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c'}
+	result, err := AddTopicPrefix(request, "tenant-a.", false)
+	a.Nil(err)
+	a.Equal(request, result)
+
+	// ElectLeaders v0
+	_, err = AddTopicPrefix(testTopicPrefixRequest(t, apiKeyElectLeaders, 0), "tenant-a.", false)
+	a.EqualError(err, "topic prefix is not supported for api key 43 version 0")
+}
+
+func TestAddTopicPrefixMetadataAllTopics(t *testing.T) {
+	a := assert.New(t)
+
+	// Metadata v1 with null topics
+	request := []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0xff, 0xff, 0xff, 0xff}
+	result, err := AddTopicPrefix(request, "tenant-a.", false)
+	a.Nil(err)
+	a.Equal(request, result)
+}
+
+func TestRemoveTopicPrefixMetadata(t *testing.T) {
+	a := assert.New(t)
+
+	topic := func(name string) []byte {
+		buf := []byte{0x00, 0x00, 0x00, byte(len(name))}
+		buf = append(buf, name...)
+		// is_internal, partition_metadata
+		return append(buf, 0x00, 0x00, 0x00, 0x00, 0x00)
+	}
+	response := []byte{
+		// brokers
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'h', 0x00, 0x00, 0x23, 0x84, 0xff, 0xff,
+		// controller_id
+		0x00, 0x00, 0x00, 0x01,
+		// topic_metadata
+		0x00, 0x00, 0x00, 0x03,
+	}
+	response = append(response, topic("tenant-a.t1")...)
+	response = append(response, topic("tenant-b.t1")...)
+	response = append(response, topic("__consumer_offsets")...)
+
+	expected := []byte{
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'h', 0x00, 0x00, 0x23, 0x84, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+	}
+	expected = append(expected, topic("t1")...)
+
+	result, err := RemoveTopicPrefix(apiKeyMetadata, 1, response, "tenant-a.", false)
+	a.Nil(err)
+	a.Equal(expected, result)
+}
+
+func TestRemoveTopicPrefixOtherResponses(t *testing.T) {
+	a := assert.New(t)
+
+	// FindCoordinator v0
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'h', 0x00, 0x00, 0x23, 0x84}
+	result, err := RemoveTopicPrefix(apiKeyFindCoordinator, 0, response, "tenant-a.", true)
+	a.Nil(err)
+	a.Equal(response, result)
+}
+
+func TestGroupPrefixRequests(t *testing.T) {
+	a := assert.New(t)
+
+	for apiKey, maxVersion := range MaxGroupPrefixVersions {
+		for apiVersion := int16(0); apiVersion <= maxVersion; apiVersion++ {
+			request := testTopicPrefixRequest(t, apiKey, apiVersion)
+
+			// group ids are not prefixed without groups
+			result, err := AddTopicPrefix(request, "tenant-a.", false)
+			a.Nil(err)
+			a.Equal(request, result, "api key %d version %d", apiKey, apiVersion)
+
+			prefixed, err := AddTopicPrefix(request, "tenant-a.", true)
+			a.Nil(err, "api key %d version %d", apiKey, apiVersion)
+			if apiKey == apiKeyListGroups {
+				a.Equal(request, prefixed)
+				continue
+			}
+			a.True(bytes.Contains(prefixed, []byte{0x00, 0x0a, 't', 'e', 'n', 'a', 'n', 't', '-', 'a', '.', 't'}), "api key %d version %d", apiKey, apiVersion)
+		}
+	}
+}
+
+func TestGroupPrefixTransactionalProduce(t *testing.T) {
+	a := assert.New(t)
+
+	request := testTopicPrefixRequest(t, apiKeyProduce, 3)
+	prefixed, err := AddTopicPrefix(request, "tenant-a.", true)
+	a.Nil(err)
+	_, body, err := decodeRequest(prefixed)
+	a.Nil(err)
+	a.Equal("tenant-a.t", *body.Get(transactionalIdKeyName).(*string))
+
+	prefixed, err = AddTopicPrefix(request, "tenant-a.", false)
+	a.Nil(err)
+	_, body, err = decodeRequest(prefixed)
+	a.Nil(err)
+	a.Equal("t", *body.Get(transactionalIdKeyName).(*string))
+}
+
+func TestRemoveGroupPrefixListGroups(t *testing.T) {
+	a := assert.New(t)
+
+	group := func(name string) []byte {
+		buf := []byte{0x00, byte(len(name))}
+		buf = append(buf, name...)
+		return append(buf, 0x00, 0x08, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r')
+	}
+	// throttle_time_ms, error_code, groups
+	response := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+	response = append(response, group("tenant-a.g1")...)
+	response = append(response, group("tenant-b.g1")...)
+
+	expected := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	expected = append(expected, group("g1")...)
+
+	result, err := RemoveTopicPrefix(apiKeyListGroups, 1, response, "tenant-a.", true)
+	a.Nil(err)
+	a.Equal(expected, result)
+
+	result, err = RemoveTopicPrefix(apiKeyListGroups, 1, response, "tenant-a.", false)
+	a.Nil(err)
+	a.Equal(response, result)
+}
@@ -1,17 +1,24 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
-	"github.com/sirupsen/logrus"
 	"net"
 	"sync"
 )
 
 type ListenFunc func(cfg config.ListenerConfig) (l net.Listener, err error)
 
+// AddressListener announces on the listener addresses, the listening sockets may be inherited from the parent process
+// or passed by systemd. The shared listener is not closed when the listeners are handed over to another process.
+type AddressListener interface {
+	Listen(address string, reusePort bool, shared bool) (net.Listener, error)
+}
+
 type Listeners struct {
 	// Source of new connections to Kafka broker.
 	connSrc chan Conn
@@ -25,66 +32,147 @@ type Listeners struct {
 	disableDynamicListeners bool
 
 	brokerToListenerConfig map[string]config.ListenerConfig
-	lock                   sync.RWMutex
+	// the listener is started for the first broker address matching a rule
+	addressMappingRules []*addressMappingRule
+	// mappings of the address lookup service, the listener is started for the first mapping of the broker
+	addressLookup   *addressLookup
+	lookupListeners map[string]config.ListenerConfig
+	// mappings of the flags and the server mapping file, the file listeners are closed when the file mapping is removed
+	bootstrapServers     []config.ListenerConfig
+	externalServers      []config.ListenerConfig
+	serverMappingFile    string
+	fileBootstrapServers []config.ListenerConfig
+	fileExternalServers  []config.ListenerConfig
+	fileListeners        map[config.ListenerConfig]net.Listener
+	// moves the clients of the listeners whose broker address changed in the server mapping file, nil when disabled
+	brokerMigrations *brokerMigrations
+	// the connections of the TLS listeners negotiating HTTP with ALPN, nil when disabled
+	alpnHTTPListener *ALPNHTTPListener
+	// all started listeners, they are closed by Close
+	listeners []net.Listener
+	closed    bool
+	lock      sync.RWMutex
+
+	done chan bool
 }
 
-func NewListeners(cfg *config.Config) (*Listeners, error) {
+func NewListeners(cfg *config.Config, keySigner apis.KeySigner, addressListener AddressListener) (*Listeners, error) {
 
 	defaultListenerIP := cfg.Proxy.DefaultListenerIP
 
 	tcpConnOptions := TCPConnOptions{
-		KeepAlive:       cfg.Proxy.ListenerKeepAlive,
-		ReadBufferSize:  cfg.Proxy.ListenerReadBufferSize,
-		WriteBufferSize: cfg.Proxy.ListenerWriteBufferSize,
+		KeepAlive:         cfg.Proxy.ListenerKeepAlive,
+		KeepAliveInterval: cfg.Proxy.ListenerKeepAliveInterval,
+		KeepAliveCount:    cfg.Proxy.ListenerKeepAliveCount,
+		NoDelay:           cfg.Proxy.ListenerNoDelay,
+		ReadBufferSize:    cfg.Proxy.ListenerReadBufferSize,
+		WriteBufferSize:   cfg.Proxy.ListenerWriteBufferSize,
 	}
 
+	done := make(chan bool, 1)
+
 	var tlsConfig *tls.Config
 	if cfg.Proxy.TLS.Enable {
 		var err error
-		tlsConfig, err = newTLSListenerConfig(cfg)
+		tlsConfig, err = newTLSListenerConfig(cfg, keySigner)
 		if err != nil {
 			return nil, err
 		}
+		if cfg.CredentialsWatch.Enable {
+			reloader := newTLSConfigReloader("listener", tlsConfig, func() (*tls.Config, error) {
+				return newTLSListenerConfig(cfg, keySigner)
+			})
+			opts := cfg.Proxy.TLS
+			if err = watchCredentialFiles([]string{opts.ListenerCertFile, opts.ListenerKeyFile, opts.CAChainCertFile}, done, func() { reloader.reload() }); err != nil {
+				return nil, err
+			}
+			tlsConfig = &tls.Config{GetConfigForClient: reloader.getConfigForClient}
+		}
+	}
+
+	var alpnHTTPListener *ALPNHTTPListener
+	if cfg.Proxy.TLS.ListenerALPN.Enable {
+		alpnHTTPListener = NewALPNHTTPListener()
 	}
+	handshakeTimeout := cfg.Proxy.TLS.ListenerALPN.HandshakeTimeout
 
+	reusePort := cfg.Proxy.ListenerReusePort
 	listenFunc := func(cfg config.ListenerConfig) (net.Listener, error) {
+		var l net.Listener
+		var err error
+		if addressListener != nil {
+			l, err = addressListener.Listen(cfg.ListenerAddress, reusePort, false)
+		} else {
+			l, err = Listen(cfg.ListenerAddress, reusePort)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig != nil && alpnHTTPListener != nil {
+			return newALPNListener(tls.NewListener(l, tlsConfig), alpnHTTPListener, handshakeTimeout), nil
+		}
 		if tlsConfig != nil {
-			return tls.Listen("tcp", cfg.ListenerAddress, tlsConfig)
+			return tls.NewListener(l, tlsConfig), nil
 		}
-		return net.Listen("tcp", cfg.ListenerAddress)
+		return l, nil
+	}
+
+	brokerToListenerConfig, err := getBrokerToListenerConfig(
+		append(append([]config.ListenerConfig{}, cfg.Proxy.BootstrapServers...), cfg.Proxy.ServerMapping.BootstrapServers...),
+		append(append([]config.ListenerConfig{}, cfg.Proxy.ExternalServers...), cfg.Proxy.ServerMapping.ExternalServers...))
+	if err != nil {
+		return nil, err
 	}
 
-	brokerToListenerConfig, err := getBrokerToListenerConfig(cfg)
+	addressMappingRules, err := newAddressMappingRules(cfg.Proxy.AddressMappingRules)
 	if err != nil {
 		return nil, err
 	}
 
+	var lookup *addressLookup
+	if cfg.Proxy.AddressLookup.Url != "" {
+		if lookup, err = newAddressLookup(cfg.Proxy.AddressLookup.Url, cfg.Proxy.AddressLookup.TTL, cfg.Proxy.AddressLookup.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Listeners{
 		defaultListenerIP:       defaultListenerIP,
 		connSrc:                 make(chan Conn, 1),
 		brokerToListenerConfig:  brokerToListenerConfig,
+		addressMappingRules:     addressMappingRules,
+		addressLookup:           lookup,
+		lookupListeners:         make(map[string]config.ListenerConfig),
+		bootstrapServers:        cfg.Proxy.BootstrapServers,
+		externalServers:         cfg.Proxy.ExternalServers,
+		serverMappingFile:       cfg.Proxy.ServerMapping.File,
+		fileBootstrapServers:    cfg.Proxy.ServerMapping.BootstrapServers,
+		fileExternalServers:     cfg.Proxy.ServerMapping.ExternalServers,
+		fileListeners:           make(map[config.ListenerConfig]net.Listener),
+		done:                    done,
 		tcpConnOptions:          tcpConnOptions,
 		listenFunc:              listenFunc,
 		disableDynamicListeners: cfg.Proxy.DisableDynamicListeners,
+		alpnHTTPListener:        alpnHTTPListener,
 	}, nil
 }
 
-func getBrokerToListenerConfig(cfg *config.Config) (map[string]config.ListenerConfig, error) {
+func getBrokerToListenerConfig(bootstrapServers []config.ListenerConfig, externalServers []config.ListenerConfig) (map[string]config.ListenerConfig, error) {
 	brokerToListenerConfig := make(map[string]config.ListenerConfig)
 
-	for _, v := range cfg.Proxy.BootstrapServers {
+	for _, v := range bootstrapServers {
 		if lc, ok := brokerToListenerConfig[v.BrokerAddress]; ok {
 			if lc.ListenerAddress != v.ListenerAddress || lc.AdvertisedAddress != v.AdvertisedAddress {
 				return nil, fmt.Errorf("bootstrap server mapping %s configured twice: %v and %v", v.BrokerAddress, v, lc)
 			}
 			continue
 		}
-		logrus.Infof("Bootstrap server %s advertised as %s", v.BrokerAddress, v.AdvertisedAddress)
+		logger.Infof("Bootstrap server %s advertised as %s", v.BrokerAddress, v.AdvertisedAddress)
 		brokerToListenerConfig[v.BrokerAddress] = v
 	}
 
 	externalToListenerConfig := make(map[string]config.ListenerConfig)
-	for _, v := range cfg.Proxy.ExternalServers {
+	for _, v := range externalServers {
 		if lc, ok := externalToListenerConfig[v.BrokerAddress]; ok {
 			if lc.ListenerAddress != v.ListenerAddress {
 				return nil, fmt.Errorf("external server mapping %s configured twice: %s and %v", v.BrokerAddress, v.ListenerAddress, lc)
@@ -104,7 +192,7 @@ func getBrokerToListenerConfig(cfg *config.Config) (map[string]config.ListenerCo
 			}
 			continue
 		}
-		logrus.Infof("External server %s advertised as %s", v.BrokerAddress, v.AdvertisedAddress)
+		logger.Infof("External server %s advertised as %s", v.BrokerAddress, v.AdvertisedAddress)
 		brokerToListenerConfig[v.BrokerAddress] = v
 	}
 	return brokerToListenerConfig, nil
@@ -124,6 +212,24 @@ func (p *Listeners) GetNetAddressMapping(brokerHost string, brokerPort int32) (l
 	if ok {
 		return util.SplitHostPort(listenerConfig.AdvertisedAddress)
 	}
+	for _, rule := range p.addressMappingRules {
+		cfg, ok, err := rule.apply(brokerAddress)
+		if err != nil {
+			return "", 0, fmt.Errorf("address mapping rule of broker %s failed: %v", brokerAddress, err)
+		}
+		if ok {
+			return p.listenRuleInstance(cfg)
+		}
+	}
+	if p.addressLookup != nil {
+		mapping, err := p.addressLookup.lookup(brokerAddress)
+		if err != nil {
+			return "", 0, err
+		}
+		if mapping != nil {
+			return p.listenLookupInstance(mapping.listenerConfig(brokerAddress))
+		}
+	}
 	if !p.disableDynamicListeners {
 		return p.ListenDynamicInstance(brokerAddress)
 	}
@@ -141,7 +247,7 @@ func (p *Listeners) ListenDynamicInstance(brokerAddress string) (string, int32,
 	defaultListenerAddress := net.JoinHostPort(p.defaultListenerIP, fmt.Sprint(0))
 
 	cfg := config.ListenerConfig{ListenerAddress: defaultListenerAddress, BrokerAddress: brokerAddress}
-	l, err := listenInstance(p.connSrc, cfg, p.tcpConnOptions, p.listenFunc)
+	l, err := p.listen(cfg)
 	if err != nil {
 		return "", 0, err
 	}
@@ -151,13 +257,55 @@ func (p *Listeners) ListenDynamicInstance(brokerAddress string) (string, int32,
 	return p.defaultListenerIP, int32(port), nil
 }
 
+// listenRuleInstance starts the listener of the broker mapped by an address mapping rule
+func (p *Listeners) listenRuleInstance(cfg config.ListenerConfig) (string, int32, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	// double check
+	if v, ok := p.brokerToListenerConfig[cfg.BrokerAddress]; ok {
+		return util.SplitHostPort(v.AdvertisedAddress)
+	}
+	if _, err := p.listen(cfg); err != nil {
+		return "", 0, err
+	}
+	logger.Infof("Broker %s advertised as %s by an address mapping rule", cfg.BrokerAddress, cfg.AdvertisedAddress)
+	p.brokerToListenerConfig[cfg.BrokerAddress] = cfg
+	return util.SplitHostPort(cfg.AdvertisedAddress)
+}
+
+// listenLookupInstance starts the listener of the looked up mapping. The advertised address follows the lookups,
+// the listener address of the first mapping is kept.
+func (p *Listeners) listenLookupInstance(cfg config.ListenerConfig) (string, int32, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if v, ok := p.lookupListeners[cfg.BrokerAddress]; ok {
+		if v != cfg {
+			if v.ListenerAddress != cfg.ListenerAddress {
+				logger.Warnf("Address lookup changed the listener address of broker %s from '%s' to '%s', the listener is not moved", cfg.BrokerAddress, v.ListenerAddress, cfg.ListenerAddress)
+			}
+			logger.Infof("Broker %s advertised as %s by the address lookup", cfg.BrokerAddress, cfg.AdvertisedAddress)
+			p.lookupListeners[cfg.BrokerAddress] = cfg
+		}
+	} else {
+		if cfg.ListenerAddress != "" {
+			if _, err := p.listen(cfg); err != nil {
+				return "", 0, err
+			}
+		}
+		logger.Infof("Broker %s advertised as %s by the address lookup", cfg.BrokerAddress, cfg.AdvertisedAddress)
+		p.lookupListeners[cfg.BrokerAddress] = cfg
+	}
+	return util.SplitHostPort(cfg.AdvertisedAddress)
+}
+
 func (p *Listeners) ListenInstances(cfgs []config.ListenerConfig) (<-chan Conn, error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	// allows multiple local addresses to point to the remote
 	for _, v := range cfgs {
-		_, err := listenInstance(p.connSrc, v, p.tcpConnOptions, p.listenFunc)
+		_, err := p.listen(v)
 		if err != nil {
 			return nil, err
 		}
@@ -165,6 +313,47 @@ func (p *Listeners) ListenInstances(cfgs []config.ListenerConfig) (<-chan Conn,
 	return p.connSrc, nil
 }
 
+// Close stops the listeners and the watches of the files, the accepted connections are not closed
+func (p *Listeners) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	for _, l := range p.listeners {
+		l.Close()
+	}
+	p.listeners = nil
+	if p.alpnHTTPListener != nil {
+		p.alpnHTTPListener.Close()
+	}
+}
+
+// listen starts the listener of the mapping, the caller holds the lock
+func (p *Listeners) listen(cfg config.ListenerConfig) (net.Listener, error) {
+	if p.closed {
+		return nil, fmt.Errorf("listener on %s for remote %s is not started, the listeners are closed", cfg.ListenerAddress, cfg.BrokerAddress)
+	}
+	l, err := listenInstance(p.connSrc, cfg, p.tcpConnOptions, p.listenFunc)
+	if err != nil {
+		return nil, err
+	}
+	p.listeners = append(p.listeners, l)
+	return l, nil
+}
+
+// Listen announces on the TCP address, the port is shared with other processes setting SO_REUSEPORT when reusePort is set
+func Listen(address string, reusePort bool) (net.Listener, error) {
+	listenConfig := net.ListenConfig{}
+	if reusePort {
+		listenConfig.Control = setReusePort
+	}
+	return listenConfig.Listen(context.Background(), "tcp", address)
+}
+
 func listenInstance(dst chan<- Conn, cfg config.ListenerConfig, opts TCPConnOptions, listenFunc ListenFunc) (net.Listener, error) {
 	l, err := listenFunc(cfg)
 	if err != nil {
@@ -174,20 +363,20 @@ func listenInstance(dst chan<- Conn, cfg config.ListenerConfig, opts TCPConnOpti
 		for {
 			c, err := l.Accept()
 			if err != nil {
-				logrus.Infof("Error in accept for %q on %v: %v", cfg, cfg.ListenerAddress, err)
+				logger.Infof("Error in accept for %q on %v: %v", cfg, cfg.ListenerAddress, err)
 				l.Close()
 				return
 			}
 			if tcpConn, ok := c.(*net.TCPConn); ok {
 				if err := opts.setTCPConnOptions(tcpConn); err != nil {
-					logrus.Infof("WARNING: Error while setting TCP options for accepted connection %q on %v: %v", cfg, l.Addr().String(), err)
+					logger.Infof("WARNING: Error while setting TCP options for accepted connection %q on %v: %v", cfg, l.Addr().String(), err)
 				}
 			}
-			logrus.Infof("New connection for %s", cfg.BrokerAddress)
-			dst <- Conn{BrokerAddress: cfg.BrokerAddress, LocalConnection: c}
+			logger.Infof("New connection for %s", cfg.BrokerAddress)
+			dst <- Conn{BrokerAddress: cfg.BrokerAddress, ListenerAddress: cfg.ListenerAddress, LocalConnection: c}
 		}
 	})
 
-	logrus.Infof("Listening on %s (%s) for remote %s", cfg.ListenerAddress, l.Addr().String(), cfg.BrokerAddress)
+	logger.Infof("Listening on %s (%s) for remote %s", cfg.ListenerAddress, l.Addr().String(), cfg.BrokerAddress)
 	return l, nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/stretchr/testify/assert"
+	"runtime"
 	"testing"
 )
 
@@ -179,11 +180,27 @@ func TestGetBrokerToListenerConfig(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		c := &config.Config{}
-		c.Proxy.BootstrapServers = tt.bootstrapServers
-		c.Proxy.ExternalServers = tt.externalServers
-		mapping, err := getBrokerToListenerConfig(c)
+		mapping, err := getBrokerToListenerConfig(tt.bootstrapServers, tt.externalServers)
 		a.Equal(tt.err, err)
 		a.Equal(tt.mapping, mapping)
 	}
 }
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is tested on linux")
+	}
+	a := assert.New(t)
+
+	first, err := Listen("127.0.0.1:0", true)
+	a.Nil(err)
+	defer first.Close()
+	address := first.Addr().String()
+
+	second, err := Listen(address, true)
+	a.Nil(err)
+	defer second.Close()
+
+	_, err = Listen(address, false)
+	a.NotNil(err)
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+const apiKeyOffsetCommit = int16(8)
+
+var (
+	topicAuthorizationFailed         = int16(protocol.ErrTopicAuthorizationFailed)
+	groupAuthorizationFailed         = int16(protocol.ErrGroupAuthorizationFailed)
+	clusterAuthorizationFailed       = int16(protocol.ErrClusterAuthorizationFailed)
+	transactionalAuthorizationFailed = int16(protocol.ErrTransactionalIDAuthorizationFailed)
+	delegationTokenAuthorization     = int16(protocol.ErrDelegationTokenAuthorization)
+)
+
+// api keys of the requests changing the cluster and the error codes of their rejections in the read-only mode,
+// the cluster authorization errors of the requests referring to topics are replaced by the topic authorization errors
+var readOnlyApiKeys = map[int16]int16{
+	0:  topicAuthorizationFailed,         // Produce
+	8:  groupAuthorizationFailed,         // OffsetCommit
+	19: topicAuthorizationFailed,         // CreateTopics
+	20: topicAuthorizationFailed,         // DeleteTopics
+	21: topicAuthorizationFailed,         // DeleteRecords
+	22: clusterAuthorizationFailed,       // InitProducerId
+	24: transactionalAuthorizationFailed, // AddPartitionsToTxn
+	25: transactionalAuthorizationFailed, // AddOffsetsToTxn
+	26: transactionalAuthorizationFailed, // EndTxn
+	27: clusterAuthorizationFailed,       // WriteTxnMarkers
+	28: transactionalAuthorizationFailed, // TxnOffsetCommit
+	30: clusterAuthorizationFailed,       // CreateAcls
+	31: clusterAuthorizationFailed,       // DeleteAcls
+	33: clusterAuthorizationFailed,       // AlterConfigs
+	34: clusterAuthorizationFailed,       // AlterReplicaLogDirs
+	37: topicAuthorizationFailed,         // CreatePartitions
+	38: delegationTokenAuthorization,     // CreateDelegationToken
+	39: delegationTokenAuthorization,     // RenewDelegationToken
+	40: delegationTokenAuthorization,     // ExpireDelegationToken
+	42: groupAuthorizationFailed,         // DeleteGroups
+	43: clusterAuthorizationFailed,       // ElectLeaders
+	44: clusterAuthorizationFailed,       // IncrementalAlterConfigs
+	45: clusterAuthorizationFailed,       // AlterPartitionReassignments
+	47: groupAuthorizationFailed,         // OffsetDelete
+	49: clusterAuthorizationFailed,       // AlterClientQuotas
+	51: clusterAuthorizationFailed,       // AlterUserScramCredentials
+	57: clusterAuthorizationFailed,       // UpdateFeatures
+	58: clusterAuthorizationFailed,       // Envelope, the requests forwarded by the brokers to the KRaft controllers
+	64: clusterAuthorizationFailed,       // UnregisterBroker
+}
+
+// highest versions of the requests without a request schema whose error responses have a fixed layout
+var readOnlyFixedResponseVersions = map[int16]int16{
+	22: 1, // InitProducerId
+	25: 2, // AddOffsetsToTxn
+	26: 2, // EndTxn
+	39: 1, // RenewDelegationToken
+	40: 1, // ExpireDelegationToken
+	58: 0, // Envelope
+	64: 0, // UnregisterBroker
+}
+
+// ReadOnly rejects the requests changing the cluster with the authorization error responses of their APIs, so the cluster
+// can be exposed to the analysts and the debugging tools safely. The versions of the rejected APIs announced by ApiVersions
+// are limited to the versions whose error responses can be encoded, the connections sending the requests
+// without an error response are closed.
+type ReadOnly struct {
+	apiKeys map[int16]int16
+}
+
+// NewReadOnly creates the read-only mode, the offset commits of the consumer groups are allowed when allowOffsetCommit is set
+func NewReadOnly(allowOffsetCommit bool) *ReadOnly {
+	r := &ReadOnly{apiKeys: make(map[int16]int16, len(readOnlyApiKeys))}
+	for apiKey, errorCode := range readOnlyApiKeys {
+		r.apiKeys[apiKey] = errorCode
+	}
+	if allowOffsetCommit {
+		delete(r.apiKeys, apiKeyOffsetCommit)
+	}
+	return r
+}
+
+// blocks checks whether the requests with the api key are rejected
+func (r *ReadOnly) blocks(apiKey int16) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.apiKeys[apiKey]
+	return ok
+}
+
+// rejectable checks whether the error responses to the rejected requests with the api key can be encoded
+func (r *ReadOnly) rejectable(apiKey int16, apiVersion int16) bool {
+	maxVersion, ok := readOnlyFixedResponseVersions[apiKey]
+	if !ok {
+		maxVersion, ok = protocol.MaxErrorResponseVersion(apiKey)
+	}
+	return ok && apiVersion >= 0 && apiVersion <= maxVersion
+}
+
+// maxVersions returns the highest versions of the rejected APIs whose error responses can be encoded
+func (r *ReadOnly) maxVersions() map[int16]int16 {
+	result := make(map[int16]int16)
+	for apiKey := range r.apiKeys {
+		if maxVersion, ok := readOnlyFixedResponseVersions[apiKey]; ok {
+			result[apiKey] = maxVersion
+		} else if maxVersion, ok := protocol.MaxErrorResponseVersion(apiKey); ok {
+			result[apiKey] = maxVersion
+		}
+	}
+	return result
+}
+
+// reject returns the error response to the rejected request (without the size field), it is nil when the client
+// does not expect a response (Produce with acks 0)
+func (r *ReadOnly) reject(clientAddress string, request []byte) ([]byte, error) {
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return nil, err
+	}
+	proxyReadOnlyRejectedTotal.WithLabelValues(strconv.Itoa(int(info.ApiKey))).Inc()
+
+	errorCode := r.apiKeys[info.ApiKey]
+	if errorCode == clusterAuthorizationFailed && len(info.Topics) != 0 {
+		errorCode = topicAuthorizationFailed
+	}
+	var errorResponse []byte
+	if _, ok := readOnlyFixedResponseVersions[info.ApiKey]; ok {
+		errorResponse = fixedErrorResponse(info.ApiKey, errorCode)
+	} else if errorResponse, err = protocol.EncodeErrorResponse(request, errorCode); err != nil {
+		return nil, errors.Wrapf(err, "request %s version %d from %s was rejected in the read-only mode", protocol.ApiKeyName(info.ApiKey), info.ApiVersion, clientAddress)
+	}
+	logger.Debugf("Request %s version %d for topics %v from %s was rejected in the read-only mode", protocol.ApiKeyName(info.ApiKey), info.ApiVersion, info.Topics, clientAddress)
+	return errorResponse, nil
+}
+
+// fixedErrorResponse encodes the error response (without the size and the correlation id) of the api key in readOnlyFixedResponseVersions
+func fixedErrorResponse(apiKey int16, errorCode int16) []byte {
+	switch apiKey {
+	case 22:
+		// throttle_time_ms, error_code, producer_id -1, producer_epoch -1
+		response := make([]byte, 16)
+		binary.BigEndian.PutUint16(response[4:], uint16(errorCode))
+		binary.BigEndian.PutUint64(response[6:], ^uint64(0))
+		binary.BigEndian.PutUint16(response[14:], ^uint16(0))
+		return response
+	case 39, 40:
+		// error_code, expiry_timestamp_ms, throttle_time_ms
+		response := make([]byte, 14)
+		binary.BigEndian.PutUint16(response, uint16(errorCode))
+		return response
+	case 58:
+		// flexible: header tagged fields, response_data null, error_code, tagged fields
+		response := make([]byte, 5)
+		binary.BigEndian.PutUint16(response[2:], uint16(errorCode))
+		return response
+	case 64:
+		// flexible: header tagged fields, throttle_time_ms, error_code, error_message null, tagged fields
+		response := make([]byte, 9)
+		binary.BigEndian.PutUint16(response[5:], uint16(errorCode))
+		return response
+	default:
+		// throttle_time_ms, error_code
+		response := make([]byte, 6)
+		binary.BigEndian.PutUint16(response[4:], uint16(errorCode))
+		return response
+	}
+}
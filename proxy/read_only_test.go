@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *ReadOnly
+	a.False(disabled.blocks(apiKeyProduce))
+
+	r := NewReadOnly(false)
+	a.True(r.blocks(apiKeyProduce))
+	a.True(r.blocks(apiKeyOffsetCommit))
+	a.False(r.blocks(apiKeyFetch))
+	a.False(r.blocks(apiKeyMetadata))
+	a.False(NewReadOnly(true).blocks(apiKeyOffsetCommit))
+
+	a.True(r.rejectable(apiKeyProduce, 8))
+	a.False(r.rejectable(apiKeyProduce, 9))
+	a.True(r.rejectable(22, 1))
+	a.False(r.rejectable(22, 2))
+	a.False(r.rejectable(30, 0))
+
+	maxVersions := r.maxVersions()
+	a.Equal(int16(8), maxVersions[apiKeyProduce])
+	a.Equal(int16(1), maxVersions[22])
+	_, ok := maxVersions[30]
+	a.False(ok)
+
+	request := testProduceRequest("t1", testRecordSet("v1"))
+	response, err := r.reject("10.0.0.5:51000", request)
+	a.Nil(err)
+	expected, err := protocol.EncodeErrorResponse(request, int16(protocol.ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Equal(expected, response)
+
+	// InitProducerId v1 with null transactional id
+	request = []byte{0x00, 0x16, 0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01, 'c', 0xff, 0xff, 0x00, 0x00, 0x03, 0xe8}
+	response, err = r.reject("10.0.0.5:51000", request)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, response)
+
+	// Envelope v0 forwarded by a broker to the KRaft controller
+	a.True(r.rejectable(58, 0))
+	a.Equal(int16(0), maxVersions[58])
+	request = []byte{0x00, 0x3a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x01, 'b', 0x00, 0x02, 0x00, 0x01, 0x01, 0x00}
+	response, err = r.reject("10.0.0.6:51000", request)
+	a.Nil(err)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x1f, 0x00}, response)
+}
+
+func TestDefaultRequestHandlerReadOnly(t *testing.T) {
+	a := assert.New(t)
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		readOnly:                   NewReadOnly(false),
+	}
+	withSize := func(request []byte) []byte {
+		return append([]byte{0x00, 0x00, 0x00, byte(len(request))}, request...)
+	}
+
+	// the substitute request is sent to the broker, the error response is sent to the client
+	request := testProduceRequest("t1", testRecordSet("v1"))
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(withSize(request))
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(apiKeyApiApiVersions, int16((<-received)[5]))
+	requestKeyVersion := <-openRequestsChannel
+	expected, err := protocol.EncodeErrorResponse(request, int16(protocol.ErrTopicAuthorizationFailed))
+	a.Nil(err)
+	a.Equal(expected, requestKeyVersion.LocalResponse)
+
+	// the connection sending CreateAcls without an error response is closed
+	client2, local2 := net.Pipe()
+	defer client2.Close()
+	go func() {
+		client2.Write(withSize([]byte{0x00, 0x1e, 0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00}))
+	}()
+	_, err = defaultRequestHandler.handleRequest(remote, local2, requestsCtx)
+	a.EqualError(err, "api key 30 version 1 is not allowed in the read-only mode")
+	a.Len(openRequestsChannel, 0)
+}
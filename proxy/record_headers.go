@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	recordHeaderPrincipal       = "principal"
+	recordHeaderClientIP        = "client-ip"
+	recordHeaderClientID        = "client-id"
+	recordHeaderProxyInstanceID = "proxy-instance-id"
+)
+
+type recordHeader struct {
+	name   []byte
+	source string
+}
+
+// RecordHeaders adds the headers with the client identity to every record of the Produce requests.
+// The headers with the same names sent by the client are removed as they cannot be trusted.
+type RecordHeaders struct {
+	headers    []recordHeader
+	instanceID string
+}
+
+// NewRecordHeaders creates the headers given as name=source. The hostname is used when the instance id is empty.
+func NewRecordHeaders(headers []string, instanceID string) (*RecordHeaders, error) {
+	h := &RecordHeaders{instanceID: instanceID}
+	for _, value := range headers {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("record header '%s' must be name=source", value)
+		}
+		switch pair[1] {
+		case recordHeaderPrincipal, recordHeaderClientIP, recordHeaderClientID, recordHeaderProxyInstanceID:
+		default:
+			return nil, fmt.Errorf("unknown source of record header '%s'", value)
+		}
+		h.headers = append(h.headers, recordHeader{name: []byte(pair[0]), source: pair[1]})
+	}
+	if h.instanceID == "" {
+		var err error
+		if h.instanceID, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *RecordHeaders) enabled() bool {
+	return h != nil && len(h.headers) != 0
+}
+
+// inject adds the headers to the records of the Produce request (without the size field), other requests are not changed
+func (h *RecordHeaders) inject(principal string, clientAddress string, request []byte) ([]byte, error) {
+	if int16(binary.BigEndian.Uint16(request)) != apiKeyProduce {
+		return request, nil
+	}
+	info, err := protocol.DecodeRequestInfo(request)
+	if err != nil {
+		return nil, err
+	}
+	clientIP := clientAddress
+	if host, _, err := net.SplitHostPort(clientAddress); err == nil {
+		clientIP = host
+	}
+	values := map[string]string{
+		recordHeaderPrincipal:       principal,
+		recordHeaderClientIP:        clientIP,
+		recordHeaderClientID:        info.ClientID,
+		recordHeaderProxyInstanceID: h.instanceID,
+	}
+	headers := make([]protocol.RecordHeader, 0, len(h.headers))
+	for _, header := range h.headers {
+		headers = append(headers, protocol.RecordHeader{Key: header.name, Value: []byte(values[header.source])})
+	}
+
+	return protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		batches, rest, err := protocol.DecodeRecordBatches(recordSet)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, &apis.FrameRejection{ErrorCode: int16(protocol.ErrInvalidRecord), Reason: fmt.Sprintf("record set of topic %s contains a partial batch", topic)}
+		}
+		for _, batch := range batches {
+			if batch.Magic() != 2 {
+				return nil, &apis.FrameRejection{ErrorCode: int16(protocol.ErrUnsupportedForMessageFormat), Reason: fmt.Sprintf("records of topic %s with magic %d have no headers", topic, batch.Magic())}
+			}
+			if batch.Records == nil {
+				return nil, &apis.FrameRejection{ErrorCode: int16(protocol.ErrUnsupportedCompressionType), Reason: fmt.Sprintf("headers cannot be added to records of topic %s with compression %d", topic, batch.Compression())}
+			}
+			for _, record := range batch.Records {
+				record.Headers = h.replaceHeaders(record.Headers, headers)
+			}
+		}
+		return protocol.EncodeRecordBatches(batches, rest), nil
+	})
+}
+
+func (h *RecordHeaders) replaceHeaders(recordHeaders []protocol.RecordHeader, headers []protocol.RecordHeader) []protocol.RecordHeader {
+	result := make([]protocol.RecordHeader, 0, len(recordHeaders)+len(headers))
+	for _, recordHeader := range recordHeaders {
+		if !h.injected(recordHeader.Key) {
+			result = append(result, recordHeader)
+		}
+	}
+	return append(result, headers...)
+}
+
+func (h *RecordHeaders) injected(name []byte) bool {
+	for _, header := range h.headers {
+		if bytes.Equal(header.name, name) {
+			return true
+		}
+	}
+	return false
+}
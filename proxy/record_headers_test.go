@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testProduceRecordHeaders(t *testing.T, request []byte) [][]protocol.RecordHeader {
+	result := make([][]protocol.RecordHeader, 0)
+	_, err := protocol.ModifyProduceRecordSets(request, func(topic string, recordSet []byte) ([]byte, error) {
+		batches, _, err := protocol.DecodeRecordBatches(recordSet)
+		assert.Nil(t, err)
+		for _, batch := range batches {
+			for _, record := range batch.Records {
+				result = append(result, record.Headers)
+			}
+		}
+		return recordSet, nil
+	})
+	assert.Nil(t, err)
+	return result
+}
+
+func TestNewRecordHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewRecordHeaders([]string{"x-principal"}, "proxy-1")
+	a.EqualError(err, "record header 'x-principal' must be name=source")
+
+	_, err = NewRecordHeaders([]string{"x-principal=user"}, "proxy-1")
+	a.EqualError(err, "unknown source of record header 'x-principal=user'")
+
+	h, err := NewRecordHeaders(nil, "proxy-1")
+	a.Nil(err)
+	a.False(h.enabled())
+
+	h, err = NewRecordHeaders([]string{"x-proxy=proxy-instance-id"}, "")
+	a.Nil(err)
+	a.True(h.enabled())
+	a.NotEmpty(h.instanceID)
+}
+
+func TestRecordHeadersInject(t *testing.T) {
+	a := assert.New(t)
+
+	h, err := NewRecordHeaders([]string{"x-principal=principal", "x-client-ip=client-ip", "x-client-id=client-id", "x-proxy=proxy-instance-id"}, "proxy-1")
+	a.Nil(err)
+
+	request, err := h.inject("alice", "10.0.0.1:53412", testProduceRequest("t1", testRecordSet("v1")))
+	a.Nil(err)
+	a.Equal([][]protocol.RecordHeader{{
+		{Key: []byte("x-principal"), Value: []byte("alice")},
+		{Key: []byte("x-client-ip"), Value: []byte("10.0.0.1")},
+		{Key: []byte("x-client-id"), Value: []byte("c")},
+		{Key: []byte("x-proxy"), Value: []byte("proxy-1")},
+	}}, testProduceRecordHeaders(t, request))
+}
+
+func TestRecordHeadersInjectReplacesClientHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	batches, rest, err := protocol.DecodeRecordBatches(testRecordSet("v1"))
+	a.Nil(err)
+	batches[0].Records[0].Headers = []protocol.RecordHeader{
+		{Key: []byte("x-principal"), Value: []byte("admin")},
+		{Key: []byte("trace-id"), Value: []byte("42")},
+	}
+	recordSet := protocol.EncodeRecordBatches(batches, rest)
+
+	h, err := NewRecordHeaders([]string{"x-principal=principal"}, "proxy-1")
+	a.Nil(err)
+	request, err := h.inject("alice", "10.0.0.1:53412", testProduceRequest("t1", recordSet))
+	a.Nil(err)
+	a.Equal([][]protocol.RecordHeader{{
+		{Key: []byte("trace-id"), Value: []byte("42")},
+		{Key: []byte("x-principal"), Value: []byte("alice")},
+	}}, testProduceRecordHeaders(t, request))
+}
+
+func TestRecordHeadersInjectRejectsPartialBatch(t *testing.T) {
+	a := assert.New(t)
+
+	h, err := NewRecordHeaders([]string{"x-principal=principal"}, "proxy-1")
+	a.Nil(err)
+	recordSet := testRecordSet("v1")
+	_, err = h.inject("alice", "10.0.0.1:53412", testProduceRequest("t1", recordSet[:len(recordSet)-1]))
+	rejection, ok := err.(*apis.FrameRejection)
+	a.True(ok)
+	a.Equal(int16(protocol.ErrInvalidRecord), rejection.ErrorCode)
+}
+
+func TestRecordHeadersInjectOtherRequests(t *testing.T) {
+	a := assert.New(t)
+
+	h, err := NewRecordHeaders([]string{"x-principal=principal"}, "proxy-1")
+	a.Nil(err)
+	// ApiVersions v0
+	request := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c'}
+	result, err := h.inject("alice", "10.0.0.1:53412", request)
+	a.Nil(err)
+	a.Equal(request, result)
+}
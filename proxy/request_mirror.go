@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// RequestSample is the decoded metadata of a sampled request, the payload is not mirrored
+type RequestSample struct {
+	Time          time.Time `json:"time"`
+	Broker        string    `json:"broker"`
+	Client        string    `json:"client"`
+	Principal     string    `json:"principal,omitempty"`
+	ClientID      string    `json:"client_id,omitempty"`
+	ApiKey        int16     `json:"api_key"`
+	ApiName       string    `json:"api_name"`
+	ApiVersion    int16     `json:"api_version"`
+	CorrelationID int32     `json:"correlation_id"`
+	Size          int       `json:"size"`
+	// empty when the request refers to no topics or its topics cannot be decoded
+	Topics []string `json:"topics,omitempty"`
+}
+
+// requestMirrorSink writes the batches of the request samples
+type requestMirrorSink interface {
+	write(batch []RequestSample) error
+	close() error
+}
+
+// RequestMirror copies the metadata of a sample of the requests to the file, the topic of the default cluster or the HTTP endpoint
+// for the offline traffic analysis and the capacity planning, independently of the request logging. The samples are written
+// in batches, a batch is written when it is full or after the flush interval. The samples are dropped when the queue is full
+// or the batch cannot be written.
+type RequestMirror struct {
+	sampleRate    float64
+	sink          requestMirrorSink
+	batchSize     int
+	flushInterval time.Duration
+
+	queue     chan RequestSample
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRequestMirror creates the request mirror to the sink of the configuration, the topic is produced to over the dial function
+func NewRequestMirror(conf *config.Config, bootstrapServers []string, dial func(brokerAddress string) (net.Conn, error)) (*RequestMirror, error) {
+	opts := conf.Events.RequestMirror
+	var sink requestMirrorSink
+	switch {
+	case opts.File != "":
+		file, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, errors.Wrap(err, "request mirror file cannot be opened")
+		}
+		sink = &fileMirrorSink{file: file}
+	case opts.Topic != "":
+		if len(bootstrapServers) == 0 {
+			return nil, errors.New("request mirror topic requires bootstrap servers")
+		}
+		sink = &topicMirrorSink{producer: newTopicProducer(opts.Topic, bootstrapServers, dial, conf.Kafka.ReadTimeout, conf.Kafka.ClientID)}
+	case opts.Url != "":
+		sink = &httpMirrorSink{url: opts.Url, httpClient: &http.Client{Timeout: opts.Timeout}}
+	default:
+		return nil, errors.New("request mirror requires a file, topic or url")
+	}
+	return newRequestMirror(opts.SampleRate, sink, opts.BatchSize, opts.FlushInterval, opts.QueueSize), nil
+}
+
+func newRequestMirror(sampleRate float64, sink requestMirrorSink, batchSize int, flushInterval time.Duration, queueSize int) *RequestMirror {
+	return &RequestMirror{
+		sampleRate:    sampleRate,
+		sink:          sink,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		queue:         make(chan RequestSample, queueSize),
+		done:          make(chan struct{}),
+	}
+}
+
+// samples decides whether the request is mirrored, false when the mirror is disabled
+func (m *RequestMirror) samples() bool {
+	return m != nil && rand.Float64() < m.sampleRate
+}
+
+// mirror queues the sample, the sample is dropped when the queue is full
+func (m *RequestMirror) mirror(sample RequestSample) {
+	select {
+	case m.queue <- sample:
+	default:
+		proxyRequestMirrorDroppedTotal.WithLabelValues("queue").Inc()
+	}
+}
+
+// Run writes the queued samples until the mirror is closed, the remaining samples are written on close
+func (m *RequestMirror) Run() {
+	defer func() {
+		if err := m.sink.close(); err != nil {
+			logger.Warnf("Closing the request mirror failed: %v", err)
+		}
+	}()
+	ticker := time.NewTicker(m.flushInterval)
+	defer ticker.Stop()
+	batch := make([]RequestSample, 0, m.batchSize)
+	for {
+		select {
+		case sample := <-m.queue:
+			batch = append(batch, sample)
+			if len(batch) >= m.batchSize {
+				batch = m.flush(batch)
+			}
+		case <-ticker.C:
+			batch = m.flush(batch)
+		case <-m.done:
+			for {
+				select {
+				case sample := <-m.queue:
+					batch = append(batch, sample)
+					if len(batch) >= m.batchSize {
+						batch = m.flush(batch)
+					}
+				default:
+					m.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *RequestMirror) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// flush writes the batch and returns the emptied batch
+func (m *RequestMirror) flush(batch []RequestSample) []RequestSample {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := m.sink.write(batch); err != nil {
+		logger.Warnf("Writing %d request samples to the request mirror failed, the samples are dropped: %v", len(batch), err)
+		proxyRequestMirrorDroppedTotal.WithLabelValues("write").Add(float64(len(batch)))
+	} else {
+		proxyRequestMirrorSamplesTotal.Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+// fileMirrorSink appends the samples as JSON lines to the file
+type fileMirrorSink struct {
+	file *os.File
+}
+
+func (s *fileMirrorSink) write(batch []RequestSample) error {
+	w := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(w)
+	for _, sample := range batch {
+		if err := encoder.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (s *fileMirrorSink) close() error {
+	return s.file.Close()
+}
+
+// topicMirrorSink produces the samples as JSON records to the topic
+type topicMirrorSink struct {
+	producer *topicProducer
+}
+
+func (s *topicMirrorSink) write(batch []RequestSample) error {
+	firstTimestamp := batch[0].Time.UnixNano() / int64(time.Millisecond)
+	for _, sample := range batch {
+		if timestamp := sample.Time.UnixNano() / int64(time.Millisecond); timestamp < firstTimestamp {
+			firstTimestamp = timestamp
+		}
+	}
+	records := make([]*protocol.Record, 0, len(batch))
+	for _, sample := range batch {
+		value, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		records = append(records, &protocol.Record{
+			TimestampDelta: sample.Time.UnixNano()/int64(time.Millisecond) - firstTimestamp,
+			Value:          value,
+		})
+	}
+	return s.producer.produce(protocol.EncodeRecordBatch(firstTimestamp, records))
+}
+
+func (s *topicMirrorSink) close() error {
+	s.producer.close()
+	return nil
+}
+
+// httpMirrorSink posts the samples as JSON arrays to the URL
+type httpMirrorSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (s *httpMirrorSink) write(batch []RequestSample) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	response, err := s.httpClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("request mirror endpoint responded with status %s", response.Status)
+	}
+	return nil
+}
+
+func (s *httpMirrorSink) close() error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestMirrorFile(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "request-mirror")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := config.NewConfig()
+	c.Events.RequestMirror.SampleRate = 1
+	c.Events.RequestMirror.File = filepath.Join(dir, "samples.json")
+	c.Events.RequestMirror.BatchSize = 2
+	m, err := NewRequestMirror(c, nil, nil)
+	a.Nil(err)
+	a.True(m.samples())
+	done := make(chan struct{})
+	go func() {
+		m.Run()
+		close(done)
+	}()
+
+	// the client view of the request is mirrored without the records
+	ctx := &RequestsLoopContext{brokerAddress: "kafka-0:9092", clientAddress: "10.0.0.1:50000", principal: "alice", requestMirror: m}
+	ctx.mirrorRequest(testProduceRequest("orders", testRecordSet("secret")))
+	ctx.mirrorRequest(protocol.EncodeApiVersionsRequest(7, "legacy"))
+	ctx.mirrorRequest([]byte{0x00})
+	m.Close()
+	<-done
+
+	file, err := os.Open(c.Events.RequestMirror.File)
+	a.Nil(err)
+	defer file.Close()
+	var samples []RequestSample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		a.NotContains(scanner.Text(), "secret")
+		var sample RequestSample
+		a.Nil(json.Unmarshal(scanner.Bytes(), &sample))
+		samples = append(samples, sample)
+	}
+	a.Len(samples, 2)
+	a.Equal("Produce", samples[0].ApiName)
+	a.Equal(int16(3), samples[0].ApiVersion)
+	a.Equal("alice", samples[0].Principal)
+	a.Equal("c", samples[0].ClientID)
+	a.Equal([]string{"orders"}, samples[0].Topics)
+	a.Equal("ApiVersions", samples[1].ApiName)
+	a.Equal(int32(7), samples[1].CorrelationID)
+	a.Equal("10.0.0.1:50000", samples[1].Client)
+
+	var disabled *RequestMirror
+	a.False(disabled.samples())
+}
+
+func TestRequestMirrorSinks(t *testing.T) {
+	a := assert.New(t)
+
+	posted := make(chan []RequestSample, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var samples []RequestSample
+		a.Nil(json.NewDecoder(r.Body).Decode(&samples))
+		posted <- samples
+	}))
+	defer server.Close()
+
+	sample := RequestSample{Time: time.Now(), Broker: "kafka-0:9092", ApiName: "Metadata", ApiKey: apiKeyMetadata, ApiVersion: 1}
+	httpSink := &httpMirrorSink{url: server.URL, httpClient: &http.Client{Timeout: time.Second}}
+	a.Nil(httpSink.write([]RequestSample{sample}))
+	a.Equal("Metadata", (<-posted)[0].ApiName)
+
+	// the samples are produced to the leader fetched from the bootstrap server
+	produced := make(chan []byte, 1)
+	topicSink := &topicMirrorSink{producer: newTopicProducer("t1", []string{"shadow-0:9092"}, testShadowDial(a, produced), time.Second, "test")}
+	defer topicSink.close()
+	a.Nil(topicSink.write([]RequestSample{sample}))
+	_, err := protocol.ModifyProduceRecordSets(<-produced, func(topic string, recordSet []byte) ([]byte, error) {
+		batches, _, err := protocol.DecodeRecordBatches(recordSet)
+		a.Nil(err)
+		var produced RequestSample
+		a.Nil(json.Unmarshal(batches[0].Records[0].Value, &produced))
+		a.Equal("kafka-0:9092", produced.Broker)
+		return recordSet, nil
+	})
+	a.Nil(err)
+
+	// the batch which cannot be written is dropped
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	m := newRequestMirror(1, &httpMirrorSink{url: notFound.URL, httpClient: &http.Client{}}, 10, time.Hour, 1)
+	drops := testCounterValue(a, proxyRequestMirrorDroppedTotal.WithLabelValues("write"))
+	a.Len(m.flush([]RequestSample{sample}), 0)
+	a.Equal(drops+1, testCounterValue(a, proxyRequestMirrorDroppedTotal.WithLabelValues("write")))
+	drops = testCounterValue(a, proxyRequestMirrorDroppedTotal.WithLabelValues("queue"))
+	m.mirror(sample)
+	m.mirror(sample)
+	a.Equal(drops+1, testCounterValue(a, proxyRequestMirrorDroppedTotal.WithLabelValues("queue")))
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+// timesOut returns true when the request is answered with REQUEST_TIMED_OUT after the request timeout, the requests whose error response
+// cannot be encoded do not time out
+func (ctx *RequestsLoopContext) timesOut(requestKeyVersion *protocol.RequestKeyVersion) bool {
+	if ctx.requestTimeout <= 0 || requestKeyVersion.DropResponse {
+		return false
+	}
+	maxVersion, ok := protocol.MaxErrorResponseVersion(requestKeyVersion.ApiKey)
+	return ok && requestKeyVersion.ApiVersion >= 0 && requestKeyVersion.ApiVersion <= maxVersion
+}
+
+// streamTimedRequest writes the body of the Produce request to the broker while the topics and the partitions of its REQUEST_TIMED_OUT
+// response are decoded, the request is not buffered. The deadline is set when the whole request was written.
+func (ctx *RequestsLoopContext) streamTimedRequest(dst DeadlineWriter, src DeadlineReader, requestKeyVersion *protocol.RequestKeyVersion) (readErr bool, err error) {
+	body := &io.LimitedReader{R: src, N: int64(requestKeyVersion.Length - 4)}
+	tee := &requestTee{src: body, dst: dst}
+	correlationID, response, waitTime, decodeErr := protocol.EncodeProduceTimeoutResponse(tee, requestKeyVersion.ApiVersion)
+	if tee.err != nil {
+		return tee.readErr, tee.err
+	}
+	if body.N > 0 {
+		// the rest of the request which cannot be decoded
+		ctx.buf = ctx.tuning.requestBuffer(ctx.buf)
+		if readErr, err = myCopyN(dst, body, body.N, ctx.buf); err != nil {
+			return readErr, err
+		}
+	}
+	if decodeErr != nil {
+		logger.Debugf("Timeout response to the request with api key %d version %d from %s cannot be encoded: %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.clientAddress, decodeErr)
+		return false, nil
+	}
+	if response != nil {
+		requestKeyVersion.Deadline = time.Now().Add(ctx.requestTimeout + waitTime)
+		requestKeyVersion.TimeoutResponse = response
+		requestKeyVersion.CorrelationID = correlationID
+	}
+	return false, nil
+}
+
+// requestTee writes the bytes read from the client to the broker, the error tells the side which failed
+type requestTee struct {
+	src     io.Reader
+	dst     io.Writer
+	readErr bool
+	err     error
+}
+
+func (t *requestTee) Read(p []byte) (int, error) {
+	n, err := t.src.Read(p)
+	if n > 0 {
+		if _, werr := t.dst.Write(p[:n]); werr != nil {
+			t.readErr, t.err = false, werr
+			return n, werr
+		}
+	}
+	if err != nil && err != io.EOF {
+		t.readErr, t.err = true, err
+	}
+	return n, err
+}
+
+// setRequestDeadline sets the deadline and the REQUEST_TIMED_OUT response of the request sent to the broker. The time the broker may hold
+// the request e.g. the max wait time of Fetch is added to the request timeout. The requests without an error response do not time out.
+func (ctx *RequestsLoopContext) setRequestDeadline(requestKeyVersion *protocol.RequestKeyVersion, request []byte) {
+	response, waitTime, err := protocol.EncodeTimeoutResponse(request)
+	if err != nil {
+		logger.Debugf("Timeout response to the request with api key %d version %d from %s cannot be encoded: %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.clientAddress, err)
+		return
+	}
+	if response == nil {
+		return
+	}
+	requestKeyVersion.Deadline = time.Now().Add(ctx.requestTimeout + waitTime)
+	requestKeyVersion.TimeoutResponse = response
+	requestKeyVersion.CorrelationID = int32(binary.BigEndian.Uint32(request[4:]))
+}
+
+// awaitResponseHeader reads the header of the broker response when the requests time out. The request awaiting the response is received
+// before the header, when its deadline passes the REQUEST_TIMED_OUT response is written to the client and nil is returned. The broker
+// responses to the timed out requests are discarded, the connection is kept.
+func (ctx *ResponsesLoopContext) awaitResponseHeader(dst DeadlineWriter, src DeadlineReader, responseHeaderBuf []byte) (requestKeyVersion *protocol.RequestKeyVersion, readErr bool, err error) {
+	for {
+		if ctx.awaitedRequest == nil {
+			select {
+			case request := <-ctx.openRequestsChannel:
+				ctx.awaitedRequest = &request
+			default:
+			}
+		}
+		// the idle connection wakes up to receive the request sent meanwhile
+		deadline := time.Now().Add(ctx.requestTimeout)
+		if ctx.awaitedRequest != nil {
+			deadline = ctx.awaitedRequest.Deadline
+		}
+		if err = src.SetReadDeadline(deadline); err != nil {
+			return nil, true, err
+		}
+		var n int
+		if n, err = io.ReadFull(src, responseHeaderBuf); err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				return nil, true, err
+			}
+			if n == 0 {
+				if ctx.awaitedRequest != nil && !ctx.awaitedRequest.Deadline.IsZero() && !time.Now().Before(ctx.awaitedRequest.Deadline) {
+					return nil, false, ctx.writeTimeoutResponse(dst)
+				}
+				continue
+			}
+			// the response is arriving
+			if err = src.SetReadDeadline(time.Now().Add(ctx.timeout)); err != nil {
+				return nil, true, err
+			}
+			if _, err = io.ReadFull(src, responseHeaderBuf[n:]); err != nil {
+				return nil, true, err
+			}
+		}
+		if len(ctx.timedOutRequests) == 0 {
+			break
+		}
+		if err = ctx.discardTimedOutResponse(src, responseHeaderBuf); err != nil {
+			return nil, true, err
+		}
+	}
+	if ctx.awaitedRequest == nil {
+		requestKeyVersion, err = receiveRequestKeyVersion(ctx.openRequestsChannel, openRequestReceiveTimeout)
+		return requestKeyVersion, true, err
+	}
+	requestKeyVersion, ctx.awaitedRequest = ctx.awaitedRequest, nil
+	return requestKeyVersion, false, nil
+}
+
+// writeTimeoutResponse answers the awaited request with the REQUEST_TIMED_OUT response, the broker response is expected later
+func (ctx *ResponsesLoopContext) writeTimeoutResponse(dst DeadlineWriter) error {
+	request := ctx.awaitedRequest
+	ctx.awaitedRequest = nil
+	ctx.timedOutRequests = append(ctx.timedOutRequests, request.CorrelationID)
+	defer ctx.pendingResponses.done()
+
+	proxyRequestTimeoutsTotal.WithLabelValues(ctx.brokerAddress, strconv.Itoa(int(request.ApiKey))).Inc()
+	logger.Debugf("Request with api key %d version %d and correlation id %d to %s timed out", request.ApiKey, request.ApiVersion, request.CorrelationID, ctx.brokerAddress)
+	if ctx.capture.enabled(request.ApiKey) {
+		ctx.captureResponse(request.CorrelationID, request.TimeoutResponse)
+	}
+	if err := dst.SetWriteDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return err
+	}
+	return writeResponse(dst, request.CorrelationID, request.TimeoutResponse)
+}
+
+// discardTimedOutResponse discards the broker response to the oldest timed out request, the responses arrive in the order of the requests
+func (ctx *ResponsesLoopContext) discardTimedOutResponse(src DeadlineReader, responseHeaderBuf []byte) error {
+	var responseHeader protocol.ResponseHeader
+	if err := protocol.Decode(responseHeaderBuf, &responseHeader); err != nil {
+		return err
+	}
+	if responseHeader.CorrelationID != ctx.timedOutRequests[0] {
+		return fmt.Errorf("response from %s has correlation id %d, expected the response to the timed out request with correlation id %d", ctx.brokerAddress, responseHeader.CorrelationID, ctx.timedOutRequests[0])
+	}
+	ctx.timedOutRequests = ctx.timedOutRequests[1:]
+	if err := src.SetReadDeadline(time.Now().Add(ctx.timeout)); err != nil {
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, src, int64(responseHeader.Length-4))
+	return err
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDefaultHandlersRequestTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 2)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		requestTimeout:             50 * time.Millisecond,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+	}
+	// the request is sent to the broker with the deadline and the REQUEST_TIMED_OUT response
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(testMetadataRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	start := time.Now()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(testMetadataRequest, <-received)
+	requestKeyVersion := <-openRequestsChannel
+	expected, err := protocol.EncodeErrorResponse(testMetadataRequest[4:], int16(protocol.ErrRequestTimedOut))
+	a.Nil(err)
+	a.Equal(expected, requestKeyVersion.TimeoutResponse)
+	a.Equal(int32(1), requestKeyVersion.CorrelationID)
+	a.True(requestKeyVersion.Deadline.After(start.Add(50*time.Millisecond - time.Nanosecond)))
+
+	// the client receives the REQUEST_TIMED_OUT response, the late broker response is discarded and the connection is kept
+	openRequestsChannel <- requestKeyVersion
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		requestTimeout:      50 * time.Millisecond,
+		buf:                 make([]byte, 16),
+	}
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	a.Equal([]int32{1}, responsesCtx.timedOutRequests)
+
+	openRequestsChannel <- protocol.RequestKeyVersion{ApiKey: 12, ApiVersion: 0}
+	go func() {
+		// the response to the timed out request and the response to the next request
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01})
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x02, 0x02, 0x02})
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	a.Empty(responsesCtx.timedOutRequests)
+	proxyClientSide.Close()
+	clientReceived := <-received
+	a.Equal(append(append([]byte{0x00, 0x00, 0x00, byte(len(expected) + 4), 0x00, 0x00, 0x00, 0x01}, expected...), 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x02, 0x02, 0x02), clientReceived)
+
+	// the broker response out of order closes the connection
+	responsesCtx.timedOutRequests = []int32{3}
+	go func() {
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x04, 0x04, 0x04})
+		io.Copy(ioutil.Discard, brokerSide)
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.EqualError(err, "response from  has correlation id 4, expected the response to the timed out request with correlation id 3")
+	brokerSide.Close()
+}
+
+func TestDefaultHandlersProduceRequestTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		requestTimeout:             50 * time.Millisecond,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+	}
+	request := []byte{
+		0x00, 0x00, 0x00, 0x32,
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x05,
+		0x00, 0x02, 'c', '1',
+		// transactional_id null, acks, timeout
+		0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x03, 0xe8,
+		// topic_data with the record set of 10 bytes
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 't', '1',
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a,
+	}
+	// the Produce request is streamed to the broker, its REQUEST_TIMED_OUT response is decoded on the way
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(request)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	start := time.Now()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	a.Equal(int64(0), requestsCtx.bufferedBytes)
+	remote.Close()
+	a.Equal(request, <-received)
+	requestKeyVersion := <-openRequestsChannel
+	expected, err := protocol.EncodeErrorResponse(request[4:], int16(protocol.ErrRequestTimedOut))
+	a.Nil(err)
+	a.Equal(expected, requestKeyVersion.TimeoutResponse)
+	a.Equal(int32(5), requestKeyVersion.CorrelationID)
+	// the timeout of the Produce request is added
+	a.True(requestKeyVersion.Deadline.After(start.Add(time.Second + 50*time.Millisecond - time.Nanosecond)))
+}
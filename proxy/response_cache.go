@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResponseCache keeps the client responses to the ApiVersions and Metadata requests of each broker for a short time.
+// The cached response is written to the client without a round trip to the broker when no other response of the connection is pending.
+type ResponseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]responseCacheEntry
+}
+
+type responseCacheEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+// NewResponseCache creates the response cache, the responses expire after the ttl
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{ttl: ttl, entries: make(map[string]responseCacheEntry)}
+}
+
+// cacheable returns true when the responses to the requests with the api key are cached
+func (c *ResponseCache) cacheable(apiKey int16) bool {
+	return c != nil && (apiKey == apiKeyApiApiVersions || apiKey == apiKeyMetadata)
+}
+
+func (c *ResponseCache) get(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.response
+}
+
+// put caches the response without errors, the expired responses are removed
+func (c *ResponseCache) put(key string, apiKey int16, apiVersion int16, response []byte) {
+	if !cacheableResponse(apiKey, apiVersion, response) {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = responseCacheEntry{response: response, expires: now.Add(c.ttl)}
+}
+
+// cacheableResponse returns true when the response contains no error codes
+func cacheableResponse(apiKey int16, apiVersion int16, response []byte) bool {
+	if apiKey == apiKeyApiApiVersions {
+		return len(response) >= 2 && binary.BigEndian.Uint16(response) == 0
+	}
+	errorCodes, err := protocol.ResponseErrorCodes(apiKey, apiVersion, response)
+	return err == nil && len(errorCodes) == 0
+}
+
+// responseCacheKey returns the key of the request (without the size) sent to the broker. The correlation id and the client id are not a part of the key.
+func responseCacheKey(brokerAddress string, request []byte) (string, error) {
+	// ApiKey => int16, ApiVersion => int16, CorrelationId => int32, ClientId => nullable string
+	if len(request) < 10 {
+		return "", errors.New("request header is too short")
+	}
+	end := 10
+	if clientIDLength := int16(binary.BigEndian.Uint16(request[8:])); clientIDLength > 0 {
+		end += int(clientIDLength)
+	}
+	if len(request) < end {
+		return "", errors.New("client id of the request is too long")
+	}
+	return brokerAddress + "/" + string(request[:4]) + string(request[end:]), nil
+}
+
+// pendingResponses counts the client requests whose responses were not written to the client yet
+type pendingResponses struct {
+	count int32
+}
+
+func (p *pendingResponses) add() {
+	if p != nil {
+		atomic.AddInt32(&p.count, 1)
+	}
+}
+
+func (p *pendingResponses) done() {
+	if p != nil {
+		atomic.AddInt32(&p.count, -1)
+	}
+}
+
+// none returns true when the response can be written to the client by the requests loop
+func (p *pendingResponses) none() bool {
+	return p != nil && atomic.LoadInt32(&p.count) == 0
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// ApiVersions v0 requests (without the size) with the correlation id 1 and 2 and different client ids
+var (
+	testApiVersionsRequest1 = []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'a'}
+	testApiVersionsRequest2 = []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x02, 'b', 'c'}
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	a := assert.New(t)
+
+	key1, err := responseCacheKey("kafka-0:9092", testApiVersionsRequest1)
+	a.Nil(err)
+	key2, err := responseCacheKey("kafka-0:9092", testApiVersionsRequest2)
+	a.Nil(err)
+	a.Equal(key1, key2)
+
+	key3, err := responseCacheKey("kafka-1:9092", testApiVersionsRequest1)
+	a.Nil(err)
+	a.NotEqual(key1, key3)
+	key4, err := responseCacheKey("kafka-0:9092", testMetadataRequest[4:])
+	a.Nil(err)
+	a.NotEqual(key1, key4)
+
+	// null client id
+	_, err = responseCacheKey("kafka-0:9092", []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff})
+	a.Nil(err)
+	_, err = responseCacheKey("kafka-0:9092", []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x05, 'a'})
+	a.NotNil(err)
+	_, err = responseCacheKey("kafka-0:9092", []byte{0x00, 0x12, 0x00, 0x00})
+	a.NotNil(err)
+}
+
+func TestResponseCache(t *testing.T) {
+	a := assert.New(t)
+
+	cache := NewResponseCache(50 * time.Millisecond)
+	a.True(cache.cacheable(apiKeyMetadata))
+	a.True(cache.cacheable(apiKeyApiApiVersions))
+	a.False(cache.cacheable(apiKeyFindCoordinator))
+	var nilCache *ResponseCache
+	a.False(nilCache.cacheable(apiKeyMetadata))
+
+	// error responses are not cached
+	cache.put("versions-error", apiKeyApiApiVersions, 0, []byte{0x00, 0x23, 0x00, 0x00, 0x00, 0x00})
+	a.Nil(cache.get("versions-error"))
+	cache.put("metadata-error", apiKeyMetadata, 4, testMetadataErrorResponse)
+	a.Nil(cache.get("metadata-error"))
+
+	metadataResponse := append([]byte{}, testMetadataErrorResponse...)
+	metadataResponse[19] = 0x00
+	cache.put("metadata", apiKeyMetadata, 4, metadataResponse)
+	a.Equal(metadataResponse, cache.get("metadata"))
+
+	time.Sleep(100 * time.Millisecond)
+	a.Nil(cache.get("metadata"))
+	a.Empty(cache.entries)
+}
+
+func TestDefaultHandlersResponseCache(t *testing.T) {
+	a := assert.New(t)
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 1)
+	pending := &pendingResponses{}
+	nextResponseHandlerChannel := make(chan ResponseHandler, 1)
+	cache := NewResponseCache(time.Minute)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: nextResponseHandlerChannel,
+		timeout:                    time.Second,
+		brokerAddress:              "kafka-0:9092",
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		responseCache:              cache,
+		pendingResponses:           pending,
+	}
+	withSize := func(request []byte) []byte {
+		return append([]byte{0x00, 0x00, 0x00, byte(len(request))}, request...)
+	}
+
+	// the first request is sent to the broker
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(withSize(testApiVersionsRequest1))
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(withSize(testApiVersionsRequest1), <-received)
+	a.False(pending.none())
+	<-requestsCtx.nextRequestHandlerChannel
+	<-nextResponseHandlerChannel
+
+	// the broker response is cached
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel: openRequestsChannel,
+		timeout:             time.Second,
+		buf:                 make([]byte, 16),
+		responseCache:       cache,
+		pendingResponses:    pending,
+	}
+	go func() {
+		brokerSide.Write([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		brokerSide.Close()
+	}()
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	proxyClientSide.Close()
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, <-received)
+	a.True(pending.none())
+
+	// the second request is answered from the cache with its correlation id, nothing is sent to the broker
+	remote, broker = net.Pipe()
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	go func() {
+		client.Write(withSize(testApiVersionsRequest2))
+	}()
+	response := make([]byte, 14)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(client, response)
+		readErr <- err
+	}()
+	_, err = defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	a.Nil(<-readErr)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, response)
+	remote.Close()
+	a.Empty(<-received)
+	a.Len(openRequestsChannel, 0)
+	a.Equal(defaultRequestHandler, <-requestsCtx.nextRequestHandlerChannel)
+	a.Len(nextResponseHandlerChannel, 0)
+	client.Close()
+}
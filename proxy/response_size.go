@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// setOversizedResponse sets the MESSAGE_TOO_LARGE response sent instead of the Fetch response larger than the max Fetch response size.
+// The requests without an error response are not limited.
+func (ctx *RequestsLoopContext) setOversizedResponse(requestKeyVersion *protocol.RequestKeyVersion, request []byte) {
+	response, err := protocol.EncodeErrorResponse(request, int16(protocol.ErrMessageSizeTooLarge))
+	if err != nil {
+		logger.Debugf("Oversized response to the request with api key %d version %d from %s cannot be encoded: %v", requestKeyVersion.ApiKey, requestKeyVersion.ApiVersion, ctx.clientAddress, err)
+		return
+	}
+	requestKeyVersion.OversizedResponse = response
+}
+
+// oversized checks whether the broker response (with the correlation id) to the request exceeds the max Fetch response size
+func (ctx *ResponsesLoopContext) oversized(requestKeyVersion *protocol.RequestKeyVersion, responseHeader *protocol.ResponseHeader) bool {
+	return requestKeyVersion.OversizedResponse != nil && requestKeyVersion.LocalResponse == nil && ctx.maxFetchResponseSize > 0 && int(responseHeader.Length-4) > ctx.maxFetchResponseSize
+}
+
+// rejectOversizedResponse discards the broker response without buffering it and writes the MESSAGE_TOO_LARGE response to the client
+func (ctx *ResponsesLoopContext) rejectOversizedResponse(dst DeadlineWriter, src DeadlineReader, requestKeyVersion *protocol.RequestKeyVersion, responseHeader *protocol.ResponseHeader) (readErr bool, err error) {
+	defer ctx.pendingResponses.done()
+	proxyOversizedResponsesTotal.WithLabelValues(ctx.brokerAddress).Inc()
+	logger.Warnf("Fetch response of %d bytes from %s to client %s exceeds the max Fetch response size of %d bytes and is rejected, the client max bytes should be lowered",
+		responseHeader.Length-4, ctx.brokerAddress, ctx.metricLabels.clientIP, ctx.maxFetchResponseSize)
+
+	responseDeadline := time.Now().Add(ctx.timeout)
+	if err = src.SetReadDeadline(responseDeadline); err != nil {
+		return true, err
+	}
+	if _, err = io.CopyN(ioutil.Discard, src, int64(responseHeader.Length-4)); err != nil {
+		return true, err
+	}
+	if err = dst.SetWriteDeadline(responseDeadline); err != nil {
+		return false, err
+	}
+	return false, writeResponse(dst, responseHeader.CorrelationID, requestKeyVersion.OversizedResponse)
+}
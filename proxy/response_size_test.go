@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// Fetch v4 request of the partition 0 of the topic orders
+var testFetchRequest = []byte{0x00, 0x00, 0x00, 0x3c,
+	0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x05, 0x00, 0x01, 'c',
+	0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x10, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 'o', 'r', 'd', 'e', 'r', 's',
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00}
+
+func TestDefaultHandlersMaxFetchResponseSize(t *testing.T) {
+	a := assert.New(t)
+
+	openRequestsChannel := make(chan protocol.RequestKeyVersion, 2)
+	requestsCtx := &RequestsLoopContext{
+		openRequestsChannel:        openRequestsChannel,
+		nextRequestHandlerChannel:  make(chan RequestHandler, 1),
+		nextResponseHandlerChannel: make(chan ResponseHandler, 1),
+		timeout:                    time.Second,
+		buf:                        make([]byte, 16),
+		localSasl:                  &LocalSasl{},
+		requestAuthz:               &RequestAuthz{},
+		maxFetchResponseSize:       16,
+	}
+	// the request is sent to the broker with the MESSAGE_TOO_LARGE response
+	client, local := net.Pipe()
+	remote, broker := net.Pipe()
+	go func() {
+		client.Write(testFetchRequest)
+		client.Close()
+	}()
+	received := make(chan []byte, 1)
+	go func() {
+		buf, _ := ioutil.ReadAll(broker)
+		received <- buf
+	}()
+	_, err := defaultRequestHandler.handleRequest(remote, local, requestsCtx)
+	a.Nil(err)
+	remote.Close()
+	a.Equal(testFetchRequest, <-received)
+	requestKeyVersion := <-openRequestsChannel
+	expected, err := protocol.EncodeErrorResponse(testFetchRequest[4:], int16(protocol.ErrMessageSizeTooLarge))
+	a.Nil(err)
+	a.Equal(expected, requestKeyVersion.OversizedResponse)
+
+	// the larger broker response is discarded, the smaller one is sent to the client
+	brokerSide, proxySide := net.Pipe()
+	proxyClientSide, clientSide := net.Pipe()
+	responsesCtx := &ResponsesLoopContext{
+		openRequestsChannel:  openRequestsChannel,
+		timeout:              time.Second,
+		buf:                  make([]byte, 16),
+		maxFetchResponseSize: 16,
+	}
+	go func() {
+		buf, _ := ioutil.ReadAll(clientSide)
+		received <- buf
+	}()
+	openRequestsChannel <- requestKeyVersion
+	openRequestsChannel <- requestKeyVersion
+	go func() {
+		brokerSide.Write(append([]byte{0x00, 0x00, 0x00, 0x15, 0x00, 0x00, 0x00, 0x05}, make([]byte, 17)...))
+		brokerSide.Write(append([]byte{0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x06}, make([]byte, 16)...))
+	}()
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	_, err = defaultResponseHandler.handleResponse(proxyClientSide, proxySide, responsesCtx)
+	a.Nil(err)
+	proxyClientSide.Close()
+	brokerSide.Close()
+	a.Equal(append(append([]byte{0x00, 0x00, 0x00, byte(len(expected) + 4), 0x00, 0x00, 0x00, 0x05}, expected...),
+		append([]byte{0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x06}, make([]byte, 16)...)...), <-received)
+}
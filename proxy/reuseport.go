@@ -0,0 +1,20 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package proxy
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+// setReusePort sets SO_REUSEPORT on the listening socket, the connections are balanced by the kernel between the sockets of the port
+func setReusePort(network string, address string, c syscall.RawConn) error {
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
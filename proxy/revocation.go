@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	revocationSideListener = "listener"
+	revocationSideBroker   = "broker"
+
+	revocationReasonRevoked         = "revoked"
+	revocationReasonCRLUnavailable  = "crl_unavailable"
+	revocationReasonOCSPUnavailable = "ocsp_unavailable"
+
+	maxRevocationResponseSize = 16 << 20
+	crlRetryInterval          = time.Minute
+)
+
+// revocationChecker checks the revocation of the peer certificates with the CRLs and the OCSP responders of the certificates.
+// A revoked certificate is always rejected, a certificate with the unknown revocation status (the CRL can not be loaded
+// or the OCSP responder fails) is rejected only in the hard fail mode.
+type revocationChecker struct {
+	side     string
+	crls     []*crlSource
+	ocsp     bool
+	hardFail bool
+	client   *http.Client
+
+	mu        sync.Mutex
+	responses map[string]*ocsp.Response // good OCSP responses by the issuer and the serial number
+}
+
+// newRevocationChecker returns nil when neither the CRLs nor the OCSP are configured, the CRLs are loaded at startup
+func newRevocationChecker(side string, crls []string, ocspEnable bool, conf *config.Config) (*revocationChecker, error) {
+	if len(crls) == 0 && !ocspEnable {
+		return nil, nil
+	}
+	client := &http.Client{Timeout: conf.Revocation.Timeout}
+	checker := &revocationChecker{
+		side:      side,
+		ocsp:      ocspEnable,
+		hardFail:  conf.Revocation.HardFail,
+		client:    client,
+		responses: make(map[string]*ocsp.Response),
+	}
+	for _, location := range crls {
+		source := &crlSource{location: strings.TrimSpace(location), refreshInterval: conf.Revocation.CRLRefreshInterval, client: client}
+		if _, err := source.get(); err != nil {
+			return nil, err
+		}
+		checker.crls = append(checker.crls, source)
+	}
+	return checker, nil
+}
+
+// verifyConnection checks the verified chain of the peer, the presented chain when the verification is skipped
+func (c *revocationChecker) verifyConnection(cs tls.ConnectionState) error {
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) != 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		var staple []byte
+		if i == 0 {
+			staple = cs.OCSPResponse
+		}
+		reason, err := c.check(chain[i], chain[i+1], staple)
+		if err == nil {
+			continue
+		}
+		proxyTLSRevocationCheckFailuresTotal.WithLabelValues(c.side, reason).Inc()
+		if reason == revocationReasonRevoked || c.hardFail {
+			return err
+		}
+		logger.Warnf("Revocation status of the %s certificate '%s' is unknown: %v", c.side, chain[i].Subject, err)
+	}
+	return nil
+}
+
+// check returns the failure reason and the error when the certificate is revoked or its revocation status is unknown
+func (c *revocationChecker) check(cert *x509.Certificate, issuer *x509.Certificate, staple []byte) (string, error) {
+	for _, source := range c.crls {
+		list, err := source.get()
+		if err != nil {
+			return revocationReasonCRLUnavailable, err
+		}
+		if !bytes.Equal(list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err = list.CheckSignatureFrom(issuer); err != nil {
+			return revocationReasonCRLUnavailable, errors.Wrapf(err, "invalid signature of CRL %s", source.location)
+		}
+		for _, entry := range list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return revocationReasonRevoked, fmt.Errorf("certificate '%s' with serial number %s is revoked by CRL %s", cert.Subject, cert.SerialNumber, source.location)
+			}
+		}
+	}
+	if !c.ocsp {
+		return "", nil
+	}
+	if len(staple) != 0 {
+		resp, err := ocsp.ParseResponseForCert(staple, cert, issuer)
+		if err == nil && ocspResponseFresh(resp) {
+			return ocspStatus(cert, resp)
+		}
+		logger.Debugf("Stapled OCSP response of the %s certificate '%s' is not used: invalid or stale", c.side, cert.Subject)
+	}
+	if len(cert.OCSPServer) == 0 {
+		return "", nil
+	}
+	key := string(issuer.RawSubjectPublicKeyInfo) + cert.SerialNumber.String()
+	c.mu.Lock()
+	resp, ok := c.responses[key]
+	c.mu.Unlock()
+	if ok && ocspResponseFresh(resp) {
+		return "", nil
+	}
+	resp, err := c.queryOCSP(cert, issuer)
+	if err != nil {
+		return revocationReasonOCSPUnavailable, errors.Wrapf(err, "OCSP request to %s failed", cert.OCSPServer[0])
+	}
+	reason, err := ocspStatus(cert, resp)
+	if err == nil && !resp.NextUpdate.IsZero() {
+		c.mu.Lock()
+		c.responses[key] = resp
+		c.mu.Unlock()
+	}
+	return reason, err
+}
+
+func (c *revocationChecker) queryOCSP(cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, cert.OCSPServer[0], bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(data, cert, issuer)
+}
+
+func ocspResponseFresh(resp *ocsp.Response) bool {
+	return resp.NextUpdate.IsZero() || time.Now().Before(resp.NextUpdate)
+}
+
+func ocspStatus(cert *x509.Certificate, resp *ocsp.Response) (string, error) {
+	switch resp.Status {
+	case ocsp.Good:
+		return "", nil
+	case ocsp.Revoked:
+		return revocationReasonRevoked, fmt.Errorf("certificate '%s' with serial number %s is revoked by OCSP", cert.Subject, cert.SerialNumber)
+	default:
+		return revocationReasonOCSPUnavailable, fmt.Errorf("OCSP status of certificate '%s' with serial number %s is unknown", cert.Subject, cert.SerialNumber)
+	}
+}
+
+// crlSource is a PEM or DER encoded CRL file or http(s) URL, it is reloaded after the refresh interval or when its next update is due.
+// The previous CRL is used when the reload fails.
+type crlSource struct {
+	location        string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu       sync.Mutex
+	list     *x509.RevocationList
+	nextLoad time.Time
+}
+
+func (s *crlSource) get() (*x509.RevocationList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.list != nil && now.Before(s.nextLoad) {
+		return s.list, nil
+	}
+	list, err := s.load()
+	if err != nil {
+		err = errors.Wrapf(err, "loading of CRL %s failed", s.location)
+		if s.list == nil {
+			return nil, err
+		}
+		logger.Warnf("%v, the previous CRL is used", err)
+		s.nextLoad = now.Add(crlRetryInterval)
+		return s.list, nil
+	}
+	s.list = list
+	s.nextLoad = now.Add(s.refreshInterval)
+	if list.NextUpdate.After(now) && list.NextUpdate.Before(s.nextLoad) {
+		s.nextLoad = list.NextUpdate
+	}
+	return s.list, nil
+}
+
+func (s *crlSource) load() (*x509.RevocationList, error) {
+	var data []byte
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		resp, err := s.client.Get(s.location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(s.location); err != nil {
+			return nil, err
+		}
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block type %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
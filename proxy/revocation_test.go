@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type testRevocationCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestRevocationCA(a *assert.Assertions) *testRevocationCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "revocation-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	a.Nil(err)
+	cert, err := x509.ParseCertificate(der)
+	a.Nil(err)
+	return &testRevocationCA{cert: cert, key: key}
+}
+
+func (ca *testRevocationCA) issue(a *assert.Assertions, serial int64, ocspServer string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "broker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if ocspServer != "" {
+		template.OCSPServer = []string{ocspServer}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	a.Nil(err)
+	cert, err := x509.ParseCertificate(der)
+	a.Nil(err)
+	return cert
+}
+
+func (ca *testRevocationCA) crlFile(a *assert.Assertions, revoked ...int64) string {
+	entries := make([]x509.RevocationListEntry, 0)
+	for _, serial := range revoked {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	a.Nil(err)
+	file, err := ioutil.TempFile("", "crl-")
+	a.Nil(err)
+	defer file.Close()
+	a.Nil(pem.Encode(file, &pem.Block{Type: "X509 CRL", Bytes: der}))
+	return file.Name()
+}
+
+func (ca *testRevocationCA) ocspResponse(a *assert.Assertions, cert *x509.Certificate, status int) []byte {
+	resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now(),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now(),
+	}, ca.key)
+	a.Nil(err)
+	return resp
+}
+
+func testRevocationConfig() *config.Config {
+	c := config.NewConfig()
+	c.Revocation.Timeout = time.Second
+	return c
+}
+
+func TestRevocationCheckerCRL(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newTestRevocationCA(a)
+	good := ca.issue(a, 2, "")
+	revoked := ca.issue(a, 3, "")
+	crl := ca.crlFile(a, 3)
+	defer os.Remove(crl)
+
+	checker, err := newRevocationChecker(revocationSideListener, []string{crl}, false, testRevocationConfig())
+	a.Nil(err)
+
+	failures := testCounterValue(a, proxyTLSRevocationCheckFailuresTotal.WithLabelValues(revocationSideListener, revocationReasonRevoked))
+	a.Nil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{good, ca.cert}}}))
+	err = checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{revoked, ca.cert}}})
+	a.EqualError(err, "certificate 'CN=broker' with serial number 3 is revoked by CRL "+crl)
+	a.Equal(failures+1, testCounterValue(a, proxyTLSRevocationCheckFailuresTotal.WithLabelValues(revocationSideListener, revocationReasonRevoked)))
+
+	// the CRL of another CA is not used
+	other := newTestRevocationCA(a)
+	a.Nil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{other.issue(a, 3, ""), other.cert}}}))
+
+	checker, err = newRevocationChecker(revocationSideListener, nil, false, testRevocationConfig())
+	a.Nil(err)
+	a.Nil(checker)
+	_, err = newRevocationChecker(revocationSideListener, []string{"/not/existing/crl"}, false, testRevocationConfig())
+	a.NotNil(err)
+}
+
+func TestRevocationCheckerOCSP(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newTestRevocationCA(a)
+	statuses := make(map[string]int)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, ok := statuses[req.SerialNumber.String()]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(ca.ocspResponse(a, &x509.Certificate{SerialNumber: req.SerialNumber}, status))
+	}))
+	defer server.Close()
+
+	good := ca.issue(a, 2, server.URL)
+	revoked := ca.issue(a, 3, server.URL)
+	unavailable := ca.issue(a, 4, server.URL)
+	statuses["2"] = ocsp.Good
+	statuses["3"] = ocsp.Revoked
+
+	conf := testRevocationConfig()
+	checker, err := newRevocationChecker(revocationSideBroker, nil, true, conf)
+	a.Nil(err)
+
+	a.Nil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{good, ca.cert}}}))
+	// cached until the next update
+	a.Nil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{good, ca.cert}}}))
+	a.Equal(1, requests)
+
+	err = checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{revoked, ca.cert}}})
+	a.EqualError(err, "certificate 'CN=broker' with serial number 3 is revoked by OCSP")
+
+	// the stapled response is used instead of the responder
+	err = checker.verifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{unavailable, ca.cert}, OCSPResponse: ca.ocspResponse(a, unavailable, ocsp.Revoked)})
+	a.EqualError(err, "certificate 'CN=broker' with serial number 4 is revoked by OCSP")
+	a.Equal(2, requests)
+
+	// soft fail
+	failures := testCounterValue(a, proxyTLSRevocationCheckFailuresTotal.WithLabelValues(revocationSideBroker, revocationReasonOCSPUnavailable))
+	a.Nil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{unavailable, ca.cert}}}))
+	a.Equal(failures+1, testCounterValue(a, proxyTLSRevocationCheckFailuresTotal.WithLabelValues(revocationSideBroker, revocationReasonOCSPUnavailable)))
+
+	conf.Revocation.HardFail = true
+	checker, err = newRevocationChecker(revocationSideBroker, nil, true, conf)
+	a.Nil(err)
+	a.NotNil(checker.verifyConnection(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{unavailable, ca.cert}}}))
+}
@@ -12,16 +12,36 @@ import (
 
 const (
 	SASLPlain = "PLAIN"
+
+	maxSASLTokenSize = 64 * 1024
 )
 
-type SASLPlainAuth struct {
-	clientID string
+// saslAuthenticator authenticates the connection to the broker
+type saslAuthenticator interface {
+	authenticate(transport saslTransport) error
+}
 
-	writeTimeout time.Duration
-	readTimeout  time.Duration
+// saslTransport carries the SASL handshake and the SASL tokens to the broker
+type saslTransport interface {
+	handshake(mechanism string) error
+	// exchange sends the SASL token and returns the SASL token of the broker
+	exchange(token []byte) ([]byte, error)
+}
+
+// saslRoundTrip sends the request to the broker and returns the response payload without the size and the correlation id
+type saslRoundTrip func(request *protocol.Request) ([]byte, error)
+
+// saslCredentialsProvider provides the current username and password e.g. the credentials fetched from Vault
+type saslCredentialsProvider interface {
+	credentials() (string, string, error)
+}
 
+type SASLPlainAuth struct {
 	username string
 	password string
+
+	// when set, the username and the password are obtained by the provider
+	credentialsProvider saslCredentialsProvider
 }
 
 // In SASL Plain, Kafka expects the auth header to be in the following format
@@ -39,83 +59,120 @@ type SASLPlainAuth struct {
 // When credentials are valid, Kafka returns a 4 byte array of null characters.
 // When credentials are invalid, Kafka closes the connection. This does not seem to be the ideal way
 // of responding to bad credentials but thats how its being done today.
-func (b *SASLPlainAuth) sendAndReceiveSASLPlainAuth(conn DeadlineReaderWriter) error {
-
-	handshakeErr := b.sendAndReceiveSASLPlainHandshake(conn)
-	if handshakeErr != nil {
-		return handshakeErr
+func (b *SASLPlainAuth) authenticate(transport saslTransport) error {
+	username, password := b.username, b.password
+	if b.credentialsProvider != nil {
+		var err error
+		if username, password, err = b.credentialsProvider.credentials(); err != nil {
+			return err
+		}
 	}
-	length := 1 + len(b.username) + 1 + len(b.password)
-	authBytes := make([]byte, length+4) //4 byte length header + auth data
-	binary.BigEndian.PutUint32(authBytes, uint32(length))
-	copy(authBytes[4:], []byte("\x00"+b.username+"\x00"+b.password))
-
-	err := conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-	if err != nil {
+	if err := transport.handshake(SASLPlain); err != nil {
 		return err
 	}
-	_, err = conn.Write(authBytes)
-	if err != nil {
-		return errors.Wrap(err, "Failed to write SASL auth header")
+	// If the credentials are valid, we would get an empty token.
+	// Otherwise, the broker closes the connection and we get an EOF
+	if _, err := transport.exchange([]byte("\x00" + username + "\x00" + password)); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("SASL/PLAIN auth for user %s failed", username)
+		}
+		return err
 	}
+	return nil
+}
 
-	err = conn.SetReadDeadline(time.Now().Add(b.readTimeout))
-	if err != nil {
-		return err
+// rawSASLTransport sends the SASL tokens prefixed with the size after the SaslHandshake v0
+type rawSASLTransport struct {
+	conn     DeadlineReaderWriter
+	clientID string
+
+	writeTimeout time.Duration
+	readTimeout  time.Duration
+}
+
+func (t *rawSASLTransport) handshake(mechanism string) error {
+	roundTrip := func(request *protocol.Request) ([]byte, error) {
+		return sendAndReceive(t.conn, request, t.writeTimeout, t.readTimeout)
 	}
+	return sendAndReceiveSASLHandshake(roundTrip, t.clientID, 0, mechanism)
+}
+
+// exchange returns io.EOF when the broker closes the connection
+func (t *rawSASLTransport) exchange(token []byte) ([]byte, error) {
+	buf := make([]byte, 4+len(token))
+	binary.BigEndian.PutUint32(buf, uint32(len(token)))
+	copy(buf[4:], token)
 
+	if err := t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := t.conn.Write(buf); err != nil {
+		return nil, errors.Wrap(err, "Failed to write SASL auth token")
+	}
+	if err := t.conn.SetReadDeadline(time.Now().Add(t.readTimeout)); err != nil {
+		return nil, err
+	}
 	header := make([]byte, 4)
-	_, err = io.ReadFull(conn, header)
-	// If the credentials are valid, we would get a 4 byte response filled with null characters.
-	// Otherwise, the broker closes the connection and we get an EOF
-	if err != nil {
+	if _, err := io.ReadFull(t.conn, header); err != nil {
 		if err == io.EOF {
-			return fmt.Errorf("SASL/PLAIN auth for user %s failed", b.username)
+			return nil, err
 		}
-		return errors.Wrap(err, "Failed to read response while authenticating with SASL")
+		return nil, errors.Wrap(err, "Failed to read response while authenticating with SASL")
 	}
-	return nil
+	length := binary.BigEndian.Uint32(header)
+	if length > maxSASLTokenSize {
+		return nil, fmt.Errorf("SASL auth token of length %d too large", length)
+	}
+	response := make([]byte, length)
+	if _, err := io.ReadFull(t.conn, response); err != nil {
+		return nil, errors.Wrap(err, "Failed to read response while authenticating with SASL")
+	}
+	return response, nil
 }
 
-func (b *SASLPlainAuth) sendAndReceiveSASLPlainHandshake(conn DeadlineReaderWriter) error {
+// saslAuthenticateTransport sends the SASL tokens in the SaslAuthenticate requests after the SaslHandshake v1 (KIP-152),
+// the broker returns the lifetime of the session (KIP-368)
+type saslAuthenticateTransport struct {
+	clientID  string
+	roundTrip saslRoundTrip
 
-	req := &protocol.Request{
-		ClientID: b.clientID,
-		Body:     &protocol.SaslHandshakeRequestV0orV1{Version: 0, Mechanism: SASLPlain},
-	}
-	reqBuf, err := protocol.Encode(req)
-	if err != nil {
-		return err
-	}
-	sizeBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
+	// 0 if the session does not expire
+	sessionLifetime time.Duration
+}
 
-	err = conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-	if err != nil {
-		return err
-	}
+func (t *saslAuthenticateTransport) handshake(mechanism string) error {
+	return sendAndReceiveSASLHandshake(t.roundTrip, t.clientID, 1, mechanism)
+}
 
-	_, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil))
+func (t *saslAuthenticateTransport) exchange(token []byte) ([]byte, error) {
+	payload, err := t.roundTrip(&protocol.Request{
+		ClientID: t.clientID,
+		Body:     &protocol.SaslAuthenticateRequestV0orV1{Version: 1, SaslAuthBytes: token},
+	})
 	if err != nil {
-		return errors.Wrap(err, "Failed to send SASL handshake")
+		return nil, errors.Wrap(err, "Failed to send SASL authenticate")
 	}
-
-	err = conn.SetReadDeadline(time.Now().Add(b.readTimeout))
-	if err != nil {
-		return err
+	res := &protocol.SaslAuthenticateResponseV0orV1{Version: 1}
+	if err = protocol.Decode(payload, res); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse SASL authenticate")
 	}
-
-	//wait for the response
-	header := make([]byte, 8) // response header
-	_, err = io.ReadFull(conn, header)
-	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL handshake header")
+	if res.Err != protocol.ErrNoError {
+		if res.ErrMsg != nil {
+			return nil, errors.Wrap(res.Err, *res.ErrMsg)
+		}
+		return nil, res.Err
 	}
-	length := binary.BigEndian.Uint32(header[:4])
-	payload := make([]byte, length-4)
-	_, err = io.ReadFull(conn, payload)
+	t.sessionLifetime = time.Duration(res.SessionLifetimeMs) * time.Millisecond
+	return res.SaslAuthBytes, nil
+}
+
+func sendAndReceiveSASLHandshake(roundTrip saslRoundTrip, clientID string, version int16, mechanism string) error {
+	payload, err := roundTrip(&protocol.Request{
+		ClientID: clientID,
+		Body:     &protocol.SaslHandshakeRequestV0orV1{Version: version, Mechanism: mechanism},
+	})
 	if err != nil {
-		return errors.Wrap(err, "Failed to read SASL handshake payload")
+		return errors.Wrap(err, "Failed to send SASL handshake")
 	}
 	res := &protocol.SaslHandshakeResponseV0orV1{}
 	err = protocol.Decode(payload, res)
@@ -127,3 +184,39 @@ func (b *SASLPlainAuth) sendAndReceiveSASLPlainHandshake(conn DeadlineReaderWrit
 	}
 	return nil
 }
+
+// sendAndReceive sends the request on the connection and returns the response payload without the size and the correlation id
+func sendAndReceive(conn DeadlineReaderWriter, request *protocol.Request, writeTimeout time.Duration, readTimeout time.Duration) ([]byte, error) {
+	reqBuf, err := protocol.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	sizeBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
+
+	if err = conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(bytes.Join([][]byte{sizeBuf, reqBuf}, nil)); err != nil {
+		return nil, err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return nil, err
+	}
+	responseHeaderBuf := make([]byte, 8) // Size => int32, CorrelationId => int32
+	if _, err = io.ReadFull(conn, responseHeaderBuf); err != nil {
+		return nil, err
+	}
+	var responseHeader protocol.ResponseHeader
+	if err = protocol.Decode(responseHeaderBuf, &responseHeader); err != nil {
+		return nil, err
+	}
+	if responseHeader.Length > protocol.MaxResponseSize {
+		return nil, protocol.PacketDecodingError{Info: fmt.Sprintf("message of length %d too large", responseHeader.Length)}
+	}
+	payload := make([]byte, int(responseHeader.Length-4))
+	if _, err = io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -13,30 +13,38 @@ import (
 	"time"
 )
 
+var (
+	// versions of the local SASL requests
+	localSaslMaxVersions = map[int16]int16{apiKeySaslHandshake: 1, apiKeySaslAuthenticate: 1}
+)
+
 type LocalSasl struct {
 	enabled            bool
 	timeout            time.Duration
+	sessionLifetime    time.Duration // 0 - the sessions do not expire and the clients cannot re-authenticate
 	localAuthenticator apis.PasswordAuthenticator
+	// the connections of the authenticated principals are counted, nil when they are not limited
+	connectionLimits *ConnectionLimits
 }
 
-func (p *LocalSasl) receiveAndSendSASLPlainAuthV1(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (err error) {
+func (p *LocalSasl) receiveAndSendSASLPlainAuthV1(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (username string, err error) {
 	if err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 1); err != nil {
-		return err
+		return "", err
 	}
-	if err = p.receiveAndSendAuthV1(conn); err != nil {
-		return err
+	if username, err = p.receiveAndSendAuthV1(conn); err != nil {
+		return "", err
 	}
-	return nil
+	return username, nil
 }
 
-func (p *LocalSasl) receiveAndSendSASLPlainAuthV0(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (err error) {
+func (p *LocalSasl) receiveAndSendSASLPlainAuthV0(conn DeadlineReaderWriter, readKeyVersionBuf []byte) (username string, err error) {
 	if err = p.receiveAndSendSaslV0orV1(conn, readKeyVersionBuf, 0); err != nil {
-		return err
+		return "", err
 	}
-	if err = p.receiveAndSendAuthV0(conn); err != nil {
-		return err
+	if username, err = p.receiveAndSendAuthV0(conn); err != nil {
+		return "", err
 	}
-	return nil
+	return username, nil
 }
 
 func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersionBuf []byte, version int16) (err error) {
@@ -74,15 +82,8 @@ func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersi
 		return err
 	}
 
-	var saslResult error
-	saslErr := protocol.ErrNoError
-	if saslReqV0orV1.Mechanism != SASLPlain {
-		saslResult = fmt.Errorf("PLAIN mechanism expected, but got %s", saslReqV0orV1.Mechanism)
-		saslErr = protocol.ErrUnsupportedSASLMechanism
-	}
-
-	saslResV0 := &protocol.SaslHandshakeResponseV0orV1{Err: saslErr, EnabledMechanisms: []string{SASLPlain}}
-	newResponseBuf, err := protocol.Encode(saslResV0)
+	saslRes, saslResult := p.handshake(saslReqV0orV1)
+	newResponseBuf, err := protocol.Encode(saslRes)
 	if err != nil {
 		return err
 	}
@@ -99,123 +100,221 @@ func (p *LocalSasl) receiveAndSendSaslV0orV1(conn DeadlineReaderWriter, keyVersi
 	return saslResult
 }
 
-func (p *LocalSasl) receiveAndSendAuthV1(conn DeadlineReaderWriter) (err error) {
+func (p *LocalSasl) receiveAndSendAuthV1(conn DeadlineReaderWriter) (username string, err error) {
 	requestDeadline := time.Now().Add(p.timeout)
 	err = conn.SetDeadline(requestDeadline)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	keyVersionBuf := make([]byte, 8) // Size => int32 + ApiKey => int16 + ApiVersion => int16
 	if _, err = io.ReadFull(conn, keyVersionBuf); err != nil {
-		return err
+		return "", err
 	}
 	requestKeyVersion := &protocol.RequestKeyVersion{}
 	if err = protocol.Decode(keyVersionBuf, requestKeyVersion); err != nil {
-		return err
+		return "", err
 	}
-	if !(requestKeyVersion.ApiKey == 36 && requestKeyVersion.ApiVersion == 0) {
-		return errors.New("SaslAuthenticate version 0 is expected")
+	if !(requestKeyVersion.ApiKey == apiKeySaslAuthenticate && (requestKeyVersion.ApiVersion == 0 || requestKeyVersion.ApiVersion == 1)) {
+		return "", errors.New("SaslAuthenticate version 0 or 1 is expected")
 	}
 
 	if int32(requestKeyVersion.Length) > protocol.MaxRequestSize {
-		return protocol.PacketDecodingError{Info: fmt.Sprintf("sasl authenticate message of length %d too large", requestKeyVersion.Length)}
+		return "", protocol.PacketDecodingError{Info: fmt.Sprintf("sasl authenticate message of length %d too large", requestKeyVersion.Length)}
 	}
 
 	resp := make([]byte, int(requestKeyVersion.Length-4))
 	if _, err = io.ReadFull(conn, resp); err != nil {
-		return err
+		return "", err
 	}
 	payload := bytes.Join([][]byte{keyVersionBuf[4:], resp}, nil)
 
-	saslAuthReqV0 := &protocol.SaslAuthenticateRequestV0{}
-	req := &protocol.Request{Body: saslAuthReqV0}
+	saslAuthReq := &protocol.SaslAuthenticateRequestV0orV1{Version: requestKeyVersion.ApiVersion}
+	req := &protocol.Request{Body: saslAuthReq}
 	if err = protocol.Decode(payload, req); err != nil {
-		return err
+		return "", err
 	}
 
-	authErr := p.doLocalAuth(saslAuthReqV0.SaslAuthBytes)
-
-	var saslAuthResV0 *protocol.SaslAuthenticateResponseV0
+	username, saslAuthRes, authErr := p.authenticate(saslAuthReq, "", newAuthContext(conn))
 	if authErr == nil {
-		saslAuthResV0 = &protocol.SaslAuthenticateResponseV0{Err: protocol.ErrNoError, SaslAuthBytes: make([]byte, 4)}
-	} else {
-		errMsg := authErr.Error()
-		saslAuthResV0 = &protocol.SaslAuthenticateResponseV0{Err: protocol.ErrSASLAuthenticationFailed, ErrMsg: &errMsg, SaslAuthBytes: make([]byte, 4)}
+		principal := username
+		defer func() {
+			// the connection is not counted when the response cannot be sent
+			if err != nil {
+				p.connectionLimits.release(principal)
+			}
+		}()
 	}
 
-	newResponseBuf, err := protocol.Encode(saslAuthResV0)
+	newResponseBuf, err := protocol.Encode(saslAuthRes)
 	if err != nil {
-		return err
+		return "", err
 	}
 	newHeaderBuf, err := protocol.Encode(&protocol.ResponseHeader{Length: int32(len(newResponseBuf) + 4), CorrelationID: req.CorrelationID})
 	if err != nil {
-		return err
+		return "", err
 	}
 	if _, err := conn.Write(newHeaderBuf); err != nil {
-		return err
+		return "", err
 	}
 	if _, err := conn.Write(newResponseBuf); err != nil {
-		return err
+		return "", err
 	}
-	return authErr
+	return username, authErr
 
 }
 
-func (p *LocalSasl) receiveAndSendAuthV0(conn DeadlineReaderWriter) (err error) {
+func (p *LocalSasl) receiveAndSendAuthV0(conn DeadlineReaderWriter) (username string, err error) {
 	requestDeadline := time.Now().Add(p.timeout)
 	err = conn.SetDeadline(requestDeadline)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	sizeBuf := make([]byte, 4) // Size => int32
 	if _, err = io.ReadFull(conn, sizeBuf); err != nil {
-		return err
+		return "", err
 	}
 
 	length := binary.BigEndian.Uint32(sizeBuf)
 	if int32(length) > protocol.MaxRequestSize {
-		return protocol.PacketDecodingError{Info: fmt.Sprintf("auth message of length %d too large", length)}
+		return "", protocol.PacketDecodingError{Info: fmt.Sprintf("auth message of length %d too large", length)}
 	}
 
 	saslAuthBytes := make([]byte, length)
 	_, err = io.ReadFull(conn, saslAuthBytes)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if err = p.doLocalAuth(saslAuthBytes); err != nil {
-		return err
+	if username, err = p.doLocalAuth(saslAuthBytes, newAuthContext(conn)); err != nil {
+		return "", err
+	}
+	if err = p.connectionLimits.acquire(username); err != nil {
+		return "", err
 	}
 	// If the credentials are valid, we would write a 4 byte response filled with null characters.
 	// Otherwise, the closes the connection i.e. return error
 	header := make([]byte, 4)
 	if _, err := conn.Write(header); err != nil {
-		return err
+		p.connectionLimits.release(username)
+		return "", err
 	}
-	return nil
+	return username, nil
 }
 
-func (p *LocalSasl) doLocalAuth(saslAuthBytes []byte) (err error) {
+func (p *LocalSasl) handshake(request *protocol.SaslHandshakeRequestV0orV1) (*protocol.SaslHandshakeResponseV0orV1, error) {
+	if request.Mechanism != SASLPlain {
+		return &protocol.SaslHandshakeResponseV0orV1{Err: protocol.ErrUnsupportedSASLMechanism, EnabledMechanisms: []string{SASLPlain}},
+			fmt.Errorf("PLAIN mechanism expected, but got %s", request.Mechanism)
+	}
+	return &protocol.SaslHandshakeResponseV0orV1{Err: protocol.ErrNoError, EnabledMechanisms: []string{SASLPlain}}, nil
+}
+
+// authenticate returns the response to the SaslAuthenticate request, the principal of the re-authentication must not change.
+// The connection of the first authentication is counted by the connection limits.
+func (p *LocalSasl) authenticate(request *protocol.SaslAuthenticateRequestV0orV1, principal string, authContext apis.AuthContext) (username string, response *protocol.SaslAuthenticateResponseV0orV1, err error) {
+	username, err = p.doLocalAuth(request.SaslAuthBytes, authContext)
+	if err == nil && principal != "" && username != principal {
+		err = fmt.Errorf("user %s cannot re-authenticate as %s", principal, username)
+	}
+	if err == nil && principal == "" {
+		err = p.connectionLimits.acquire(username)
+	}
+	response = &protocol.SaslAuthenticateResponseV0orV1{Version: request.Version, Err: protocol.ErrNoError, SaslAuthBytes: make([]byte, 4)}
+	if err != nil {
+		errMsg := err.Error()
+		response.Err = protocol.ErrSASLAuthenticationFailed
+		response.ErrMsg = &errMsg
+		return "", response, err
+	}
+	response.SessionLifetimeMs = int64(p.sessionLifetime / time.Millisecond)
+	return username, response, nil
+}
+
+// sessionExpiry returns the expiry of the session authenticated now, the zero time if the sessions do not expire
+func (p *LocalSasl) sessionExpiry() time.Time {
+	if p.sessionLifetime <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.sessionLifetime)
+}
+
+// reauthenticate answers the SaslHandshake and SaslAuthenticate requests of the client re-authentication (KIP-368).
+// The response is sent by the responses loop after the responses to the requests in flight.
+// A failed re-authentication expires the session, the connection is closed with the next request which is not a re-authentication.
+func (ctx *RequestsLoopContext) reauthenticate(request []byte) (response []byte, err error) {
+	// ApiKey => int16, ApiVersion => int16
+	apiKey, apiVersion := int16(binary.BigEndian.Uint16(request)), int16(binary.BigEndian.Uint16(request[2:]))
+	if apiVersion != 1 {
+		return nil, fmt.Errorf("SASL re-authentication requires version 1 of api key %d, got version %d", apiKey, apiVersion)
+	}
+	var reauthErr error
+	switch apiKey {
+	case apiKeySaslHandshake:
+		saslReq := &protocol.SaslHandshakeRequestV0orV1{Version: apiVersion}
+		if err = protocol.Decode(request, &protocol.Request{Body: saslReq}); err != nil {
+			return nil, err
+		}
+		var saslRes *protocol.SaslHandshakeResponseV0orV1
+		saslRes, reauthErr = ctx.localSasl.handshake(saslReq)
+		ctx.localSaslReauth = reauthErr == nil
+		response, err = protocol.Encode(saslRes)
+	default:
+		if !ctx.localSaslReauth {
+			return nil, errors.New("SaslHandshake is expected before SaslAuthenticate")
+		}
+		ctx.localSaslReauth = false
+		saslReq := &protocol.SaslAuthenticateRequestV0orV1{Version: apiVersion}
+		if err = protocol.Decode(request, &protocol.Request{Body: saslReq}); err != nil {
+			return nil, err
+		}
+		var saslRes *protocol.SaslAuthenticateResponseV0orV1
+		if _, saslRes, reauthErr = ctx.localSasl.authenticate(saslReq, ctx.principal, ctx.authContext); reauthErr == nil {
+			ctx.localSaslExpiry = ctx.localSasl.sessionExpiry()
+			logger.Debugf("user %s re-authenticated from %s", ctx.principal, ctx.clientAddress)
+		}
+		response, err = protocol.Encode(saslRes)
+	}
+	if reauthErr != nil {
+		logger.Infof("SASL re-authentication of user %s from %s failed: %v", ctx.principal, ctx.clientAddress, reauthErr)
+		ctx.localSaslReauth = false
+		ctx.localSaslExpiry = time.Now()
+	}
+	return response, err
+}
+
+func (p *LocalSasl) doLocalAuth(saslAuthBytes []byte, authContext apis.AuthContext) (username string, err error) {
 	tokens := strings.Split(string(saslAuthBytes), "\x00")
 	if len(tokens) != 3 {
-		return fmt.Errorf("invalid SASL/PLAIN request: expected 3 tokens, got %d", len(tokens))
+		return "", fmt.Errorf("invalid SASL/PLAIN request: expected 3 tokens, got %d", len(tokens))
 	}
 	if p.localAuthenticator == nil {
-		return protocol.PacketDecodingError{Info: "Listener authenticator is not set"}
+		return "", protocol.PacketDecodingError{Info: "Listener authenticator is not set"}
 	}
 
-	// logrus.Infof("user: %s , password: %s", tokens[1], tokens[2])
-	ok, status, err := p.localAuthenticator.Authenticate(tokens[1], tokens[2])
+	// logger.Infof("user: %s , password: %s", tokens[1], tokens[2])
+	var (
+		ok     bool
+		status int32
+	)
+	if authenticator, isContext := p.localAuthenticator.(apis.ContextPasswordAuthenticator); isContext {
+		ok, status, err = authenticator.AuthenticateWithContext(authContext, tokens[1], tokens[2])
+	} else {
+		ok, status, err = p.localAuthenticator.Authenticate(tokens[1], tokens[2])
+	}
 	if err != nil {
 		proxyLocalAuthTotal.WithLabelValues("error", "1").Inc()
-		return err
+		emitEvent(Event{Type: EventAuthFailure, Client: authContext.ClientAddress, Listener: authContext.ListenerAddress, Principal: tokens[1], Message: fmt.Sprintf("user %s authentication error: %v", tokens[1], err)})
+		return "", err
 	}
 	proxyLocalAuthTotal.WithLabelValues(strconv.FormatBool(ok), strconv.Itoa(int(status))).Inc()
 
 	if !ok {
-		return fmt.Errorf("user %s authentication failed", tokens[1])
+		err = fmt.Errorf("user %s authentication failed", tokens[1])
+		emitEvent(Event{Type: EventAuthFailure, Client: authContext.ClientAddress, Listener: authContext.ListenerAddress, Principal: tokens[1], Message: err.Error()})
+		return "", err
 	}
-	return nil
+	emitEvent(Event{Type: EventAuthSuccess, Client: authContext.ClientAddress, Listener: authContext.ListenerAddress, Principal: tokens[1], Message: "local SASL authentication"})
+	return tokens[1], nil
 }
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// saslConn is the connection to the broker whose SASL session expires
+type saslConn struct {
+	net.Conn
+	session *upstreamSASLSession
+}
+
+// upstreamSASLSession re-authenticates the connection to the broker before the session lifetime returned by the broker expires (KIP-368).
+// The re-authentication is done before the next request is sent, the broker closes an expired connection only when it receives a request.
+type upstreamSASLSession struct {
+	saslAuth saslAuthenticator
+	clientID string
+	reauthAt time.Time // zero if the session does not expire
+	// the connection is also re-authenticated when the watched credentials change, nil when the credentials are not watched
+	generation              *credentialsGeneration
+	authenticatedGeneration uint64
+}
+
+func newUpstreamSASLSession(saslAuth saslAuthenticator, clientID string, sessionLifetime time.Duration, generation *credentialsGeneration, authenticatedGeneration uint64) *upstreamSASLSession {
+	s := &upstreamSASLSession{saslAuth: saslAuth, clientID: clientID, generation: generation, authenticatedGeneration: authenticatedGeneration}
+	s.schedule(sessionLifetime)
+	return s
+}
+
+// schedule sets the re-authentication at 85-95% of the session lifetime like the Java client
+func (s *upstreamSASLSession) schedule(sessionLifetime time.Duration) {
+	if sessionLifetime <= 0 {
+		s.reauthAt = time.Time{}
+		return
+	}
+	s.reauthAt = time.Now().Add(time.Duration(float64(sessionLifetime) * (0.85 + 0.1*rand.Float64())))
+}
+
+func (s *upstreamSASLSession) expiring() bool {
+	if s.generation != nil && s.generation.get() != s.authenticatedGeneration {
+		return true
+	}
+	return !s.reauthAt.IsZero() && !time.Now().Before(s.reauthAt)
+}
+
+func (s *upstreamSASLSession) reauthenticate(roundTrip saslRoundTrip) error {
+	transport := &saslAuthenticateTransport{clientID: s.clientID, roundTrip: roundTrip}
+	generation := s.generation.get()
+	if err := s.saslAuth.authenticate(transport); err != nil {
+		return err
+	}
+	s.authenticatedGeneration = generation
+	s.schedule(transport.sessionLifetime)
+	return nil
+}
+
+// upstreamRoundTrip sends the request of the proxy to the broker, the response is returned by the responses loop instead of being sent to the client
+func (ctx *RequestsLoopContext) upstreamRoundTrip(dst DeadlineWriter) saslRoundTrip {
+	return func(request *protocol.Request) ([]byte, error) {
+		reqBuf, err := protocol.Encode(request)
+		if err != nil {
+			return nil, err
+		}
+		response := make(chan []byte, 1)
+		requestKeyVersion := &protocol.RequestKeyVersion{
+			Length:        int32(len(reqBuf)),
+			ApiKey:        int16(binary.BigEndian.Uint16(reqBuf)),
+			ApiVersion:    int16(binary.BigEndian.Uint16(reqBuf[2:])),
+			ProxyResponse: response,
+		}
+		// send inFlightRequest to channel before the request is written to prevent race condition in proxyResponses
+		if err = sendRequestKeyVersion(ctx.openRequestsChannel, openRequestSendTimeout, requestKeyVersion); err != nil {
+			return nil, err
+		}
+		if err = ctx.putNextResponseHandler(defaultResponseHandler); err != nil {
+			return nil, err
+		}
+		sizeBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(sizeBuf, uint32(len(reqBuf)))
+		if err = dst.SetWriteDeadline(time.Now().Add(ctx.timeout)); err != nil {
+			return nil, err
+		}
+		if _, err = dst.Write(append(sizeBuf, reqBuf...)); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(ctx.timeout)
+		defer timer.Stop()
+		select {
+		case payload := <-response:
+			return payload, nil
+		case <-timer.C:
+			return nil, errors.New("response to the request of the proxy is missing")
+		}
+	}
+}
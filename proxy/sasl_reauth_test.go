@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type testPasswordAuthenticator map[string]string
+
+func (t testPasswordAuthenticator) Authenticate(username, password string) (bool, int32, error) {
+	p, ok := t[username]
+	return ok && p == password, 0, nil
+}
+
+// testReadFrame reads the request or the response without the size
+func testReadFrame(a *assert.Assertions, conn net.Conn) []byte {
+	sizeBuf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, sizeBuf); err != nil {
+		return nil
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+	_, err := io.ReadFull(conn, buf)
+	a.Nil(err)
+	return buf
+}
+
+func testWriteRequest(a *assert.Assertions, conn net.Conn, correlationID int32, body protocol.ProtocolBody) {
+	buf, err := protocol.Encode(&protocol.Request{CorrelationID: correlationID, ClientID: "client", Body: body})
+	a.Nil(err)
+	testWriteFrame(a, conn, buf)
+}
+
+func testWriteResponse(a *assert.Assertions, conn net.Conn, request []byte, body []byte) {
+	// CorrelationId of the request
+	testWriteFrame(a, conn, append(append([]byte{}, request[4:8]...), body...))
+}
+
+func testWriteFrame(a *assert.Assertions, conn net.Conn, buf []byte) {
+	sizeBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(buf)))
+	_, err := conn.Write(append(sizeBuf, buf...))
+	a.Nil(err)
+}
+
+// testSASLAuthenticateBroker answers the SaslHandshake v1 and the SaslAuthenticate v1 request of the SASL/PLAIN authentication
+func testSASLAuthenticateBroker(a *assert.Assertions, conn net.Conn, authBytes string, sessionLifetimeMs int64) {
+	request := testReadFrame(a, conn)
+	handshake := &protocol.SaslHandshakeRequestV0orV1{Version: 1}
+	a.Nil(protocol.Decode(request, &protocol.Request{Body: handshake}))
+	a.Equal(SASLPlain, handshake.Mechanism)
+	body, err := protocol.Encode(&protocol.SaslHandshakeResponseV0orV1{Err: protocol.ErrNoError, EnabledMechanisms: []string{SASLPlain}})
+	a.Nil(err)
+	testWriteResponse(a, conn, request, body)
+
+	request = testReadFrame(a, conn)
+	authenticate := &protocol.SaslAuthenticateRequestV0orV1{Version: 1}
+	a.Nil(protocol.Decode(request, &protocol.Request{Body: authenticate}))
+	response := &protocol.SaslAuthenticateResponseV0orV1{Version: 1, Err: protocol.ErrNoError, SaslAuthBytes: []byte{}, SessionLifetimeMs: sessionLifetimeMs}
+	if string(authenticate.SaslAuthBytes) != authBytes {
+		errMsg := "Authentication failed: Invalid username or password"
+		response = &protocol.SaslAuthenticateResponseV0orV1{Version: 1, Err: protocol.ErrSASLAuthenticationFailed, ErrMsg: &errMsg, SaslAuthBytes: []byte{}}
+	}
+	body, err = protocol.Encode(response)
+	a.Nil(err)
+	testWriteResponse(a, conn, request, body)
+}
+
+func TestSASLAuthenticateTransport(t *testing.T) {
+	a := assert.New(t)
+
+	client, broker := net.Pipe()
+	defer client.Close()
+	go testSASLAuthenticateBroker(a, broker, "\x00alice\x00secret", 60000)
+	transport := &saslAuthenticateTransport{clientID: "test", roundTrip: func(request *protocol.Request) ([]byte, error) {
+		return sendAndReceive(client, request, time.Second, time.Second)
+	}}
+	a.Nil((&SASLPlainAuth{username: "alice", password: "secret"}).authenticate(transport))
+	a.Equal(time.Minute, transport.sessionLifetime)
+
+	go testSASLAuthenticateBroker(a, broker, "\x00alice\x00secret", 60000)
+	err := (&SASLPlainAuth{username: "alice", password: "wrong"}).authenticate(transport)
+	a.EqualError(err, "Authentication failed: Invalid username or password: kafka server: SASL Authentication failed.")
+}
+
+func TestUpstreamReauthentication(t *testing.T) {
+	a := assert.New(t)
+
+	proxyBroker, broker := net.Pipe()
+	client, proxyClient := net.Pipe()
+	defer client.Close()
+
+	// the session is expiring
+	session := &upstreamSASLSession{saslAuth: &SASLPlainAuth{username: "alice", password: "secret"}, clientID: "proxy", reauthAt: time.Now()}
+	cfg := ProcessorConfig{LocalSasl: &LocalSasl{}, AuthServer: &AuthServer{}, RequestAuthz: &RequestAuthz{}}
+	go copyThenClose(cfg, &saslConn{Conn: proxyBroker, session: session}, proxyClient, "127.0.0.1:9092", "broker", "client")
+
+	brokerRequest := make(chan []byte, 1)
+	go func() {
+		testSASLAuthenticateBroker(a, broker, "\x00alice\x00secret", 60000)
+		request := testReadFrame(a, broker)
+		brokerRequest <- request
+		// ApiVersions v0 response without api keys
+		testWriteFrame(a, broker, append(append([]byte{}, request[4:8]...), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00))
+	}()
+
+	// ApiVersions v0 request
+	request := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0xff, 0xff}
+	testWriteFrame(a, client, request)
+	a.Equal([]byte{0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, testReadFrame(a, client))
+	a.Equal(request, <-brokerRequest)
+
+	// re-authentication at 85-95% of the session lifetime
+	a.True(session.reauthAt.After(time.Now().Add(50 * time.Second)))
+	a.True(session.reauthAt.Before(time.Now().Add(58 * time.Second)))
+	a.False(session.expiring())
+}
+
+func TestLocalReauthentication(t *testing.T) {
+	a := assert.New(t)
+
+	proxyBroker, broker := net.Pipe()
+	client, proxyClient := net.Pipe()
+	defer client.Close()
+
+	localSasl := &LocalSasl{enabled: true, timeout: time.Second, sessionLifetime: time.Minute, localAuthenticator: testPasswordAuthenticator{"alice": "secret", "bob": "secret"}}
+	cfg := ProcessorConfig{LocalSasl: localSasl, AuthServer: &AuthServer{}, RequestAuthz: &RequestAuthz{}}
+	go copyThenClose(cfg, proxyBroker, proxyClient, "127.0.0.1:9092", "broker", "client")
+
+	substitutes := make(chan []byte, 4)
+	go func() {
+		for {
+			request := testReadFrame(a, broker)
+			if request == nil {
+				close(substitutes)
+				return
+			}
+			substitutes <- request
+			testWriteFrame(a, broker, append(append([]byte{}, request[4:8]...), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00))
+		}
+	}()
+	authenticate := func(correlationID int32, authBytes string) *protocol.SaslAuthenticateResponseV0orV1 {
+		testWriteRequest(a, client, correlationID, &protocol.SaslHandshakeRequestV0orV1{Version: 1, Mechanism: SASLPlain})
+		response := testReadFrame(a, client)
+		a.Equal(correlationID, int32(binary.BigEndian.Uint32(response)))
+		handshake := &protocol.SaslHandshakeResponseV0orV1{}
+		a.Nil(protocol.Decode(response[4:], handshake))
+		a.Equal(protocol.ErrNoError, handshake.Err)
+
+		testWriteRequest(a, client, correlationID+1, &protocol.SaslAuthenticateRequestV0orV1{Version: 1, SaslAuthBytes: []byte(authBytes)})
+		response = testReadFrame(a, client)
+		a.Equal(correlationID+1, int32(binary.BigEndian.Uint32(response)))
+		authenticate := &protocol.SaslAuthenticateResponseV0orV1{Version: 1}
+		a.Nil(protocol.Decode(response[4:], authenticate))
+		return authenticate
+	}
+
+	response := authenticate(1, "\x00alice\x00secret")
+	a.Equal(protocol.ErrNoError, response.Err)
+	a.Equal(int64(60000), response.SessionLifetimeMs)
+	a.Len(substitutes, 0)
+
+	// the responses to the re-authentication are sent after the broker responses to the substitute requests
+	response = authenticate(3, "\x00alice\x00secret")
+	a.Equal(protocol.ErrNoError, response.Err)
+	a.Equal(int64(60000), response.SessionLifetimeMs)
+	a.Equal(int16(apiKeyApiApiVersions), int16(binary.BigEndian.Uint16(<-substitutes)))
+	a.Equal(int16(apiKeyApiApiVersions), int16(binary.BigEndian.Uint16(<-substitutes)))
+
+	// the principal must not change
+	response = authenticate(5, "\x00bob\x00secret")
+	a.Equal(protocol.ErrSASLAuthenticationFailed, response.Err)
+	a.Equal("user alice cannot re-authenticate as bob", *response.ErrMsg)
+	<-substitutes
+	<-substitutes
+
+	// the session expired by the failed re-authentication closes the connection
+	// the connection can be closed before the request is written completely
+	client.Write([]byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0xff, 0xff})
+	a.Nil(testReadFrame(a, client))
+	_, ok := <-substitutes
+	a.False(ok)
+}
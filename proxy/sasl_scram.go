@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	SASLSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// SASLSCRAMAuth authenticates with SASL/SCRAM (https://tools.ietf.org/html/rfc5802). Delegation tokens are SCRAM credentials
+// with the token id as the user name and the base64 encoded token HMAC as the password, they are marked by the tokenauth extension.
+type SASLSCRAMAuth struct {
+	mechanism string
+
+	username  string
+	password  string
+	tokenAuth bool
+
+	// when set, the connections are authenticated with the delegation token obtained by the provider
+	tokenProvider *DelegationTokenProvider
+	// when set, the username and the password are obtained by the provider
+	credentialsProvider saslCredentialsProvider
+}
+
+func (b *SASLSCRAMAuth) authenticate(transport saslTransport) error {
+	username, password, tokenAuth := b.username, b.password, b.tokenAuth
+	if b.tokenProvider != nil {
+		var err error
+		if username, password, err = b.tokenProvider.credentials(); err != nil {
+			return err
+		}
+		tokenAuth = true
+	} else if b.credentialsProvider != nil {
+		var err error
+		if username, password, err = b.credentialsProvider.credentials(); err != nil {
+			return err
+		}
+	}
+	if err := transport.handshake(b.mechanism); err != nil {
+		return err
+	}
+	client, err := newSCRAMClient(b.mechanism, username, password, tokenAuth)
+	if err != nil {
+		return err
+	}
+	serverFirst, err := transport.exchange(client.firstMessage())
+	if err != nil {
+		return b.authError(username, err)
+	}
+	clientFinal, err := client.finalMessage(serverFirst)
+	if err != nil {
+		return err
+	}
+	serverFinal, err := transport.exchange(clientFinal)
+	if err != nil {
+		return b.authError(username, err)
+	}
+	return client.verify(serverFinal)
+}
+
+// authError reports the broker closing the connection as the failed authentication like SASL/PLAIN
+func (b *SASLSCRAMAuth) authError(username string, err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("SASL/%s auth for user %s failed", b.mechanism, username)
+	}
+	return err
+}
+
+// scramClient keeps the state of one SCRAM authentication
+type scramClient struct {
+	hash      func() hash.Hash
+	username  string
+	password  string
+	tokenAuth bool
+
+	nonce           string
+	clientFirstBare string
+	saltedPassword  []byte
+	authMessage     string
+}
+
+func newSCRAMClient(mechanism string, username string, password string, tokenAuth bool) (*scramClient, error) {
+	var h func() hash.Hash
+	switch mechanism {
+	case SASLSCRAMSHA256:
+		h = sha256.New
+	case SASLSCRAMSHA512:
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism %s", mechanism)
+	}
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &scramClient{hash: h, username: username, password: password, tokenAuth: tokenAuth, nonce: base64.StdEncoding.EncodeToString(nonce)}, nil
+}
+
+// gs2Header is the header without channel binding and authorization identity
+const gs2Header = "n,,"
+
+func (c *scramClient) firstMessage() []byte {
+	c.clientFirstBare = "n=" + scramName(c.username) + ",r=" + c.nonce
+	if c.tokenAuth {
+		c.clientFirstBare += ",tokenauth=true"
+	}
+	return []byte(gs2Header + c.clientFirstBare)
+}
+
+func (c *scramClient) finalMessage(serverFirst []byte) ([]byte, error) {
+	attrs, err := scramAttributes(string(serverFirst))
+	if err != nil {
+		return nil, err
+	}
+	nonce, salt64, iterations := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return nil, errors.New("SCRAM server nonce is invalid")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, errors.Wrap(err, "SCRAM salt is invalid")
+	}
+	iterationCount, err := strconv.Atoi(iterations)
+	if err != nil || iterationCount <= 0 {
+		return nil, fmt.Errorf("SCRAM iteration count '%s' is invalid", iterations)
+	}
+	c.saltedPassword = util.PBKDF2(c.hash, []byte(c.password), salt, iterationCount)
+
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(gs2Header)) + ",r=" + nonce
+	c.authMessage = c.clientFirstBare + "," + string(serverFirst) + "," + clientFinalWithoutProof
+
+	clientKey := c.hmac(c.saltedPassword, "Client Key")
+	storedKey := c.hash()
+	storedKey.Write(clientKey)
+	clientSignature := c.hmac(storedKey.Sum(nil), c.authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (c *scramClient) verify(serverFinal []byte) error {
+	attrs, err := scramAttributes(string(serverFinal))
+	if err != nil {
+		return err
+	}
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM authentication of user %s failed: %s", c.username, e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return errors.Wrap(err, "SCRAM server signature is invalid")
+	}
+	serverKey := c.hmac(c.saltedPassword, "Server Key")
+	if !hmac.Equal(signature, c.hmac(serverKey, c.authMessage)) {
+		return errors.New("SCRAM server signature does not match")
+	}
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramName escapes the user name as saslname
+func scramName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+func scramAttributes(message string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, fmt.Errorf("SCRAM message '%s' is invalid", message)
+		}
+		attrs[attr[:1]] = attr[2:]
+	}
+	return attrs, nil
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSCRAMClientSHA256(t *testing.T) {
+	a := assert.New(t)
+
+	// test vector of https://tools.ietf.org/html/rfc7677#section-3
+	client, err := newSCRAMClient(SASLSCRAMSHA256, "user", "pencil", false)
+	a.Nil(err)
+	client.nonce = "rOprNGfwEbeRWgbNEkqO"
+	a.Equal("n,,n=user,r=rOprNGfwEbeRWgbNEkqO", string(client.firstMessage()))
+
+	clientFinal, err := client.finalMessage([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	a.Nil(err)
+	a.Equal("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", string(clientFinal))
+	a.Nil(client.verify([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")))
+	a.EqualError(client.verify([]byte("v=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")), "SCRAM server signature does not match")
+	a.EqualError(client.verify([]byte("e=invalid-proof")), "SCRAM authentication of user user failed: invalid-proof")
+
+	_, err = client.finalMessage([]byte("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	a.EqualError(err, "SCRAM server nonce is invalid")
+}
+
+func TestSCRAMClientTokenAuth(t *testing.T) {
+	a := assert.New(t)
+
+	client, err := newSCRAMClient(SASLSCRAMSHA512, "token=1,a", "hmac", true)
+	a.Nil(err)
+	a.True(strings.HasPrefix(string(client.firstMessage()), "n,,n=token=3D1=2Ca,r="))
+	a.True(strings.HasSuffix(string(client.firstMessage()), ",tokenauth=true"))
+
+	_, err = newSCRAMClient(SASLPlain, "user", "pencil", false)
+	a.EqualError(err, "unsupported SCRAM mechanism PLAIN")
+}
+
+// testSCRAMServer answers the SaslHandshake and the SCRAM-SHA-256 authentication of the user, the first client message is recorded
+func testSCRAMServer(conn net.Conn, username string, password string, clientFirst chan<- string) {
+	defer conn.Close()
+	readToken := func() []byte {
+		sizeBuf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, sizeBuf); err != nil {
+			return nil
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil
+		}
+		return buf
+	}
+	writeToken := func(token []byte) {
+		sizeBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(sizeBuf, uint32(len(token)))
+		conn.Write(append(sizeBuf, token...))
+	}
+	if readToken() == nil {
+		return
+	}
+	// SaslHandshake v0 response with mechanisms
+	handshake, _ := protocol.Encode(&protocol.SaslHandshakeResponseV0orV1{Err: protocol.ErrNoError, EnabledMechanisms: []string{SASLSCRAMSHA256}})
+	writeToken(append([]byte{0x00, 0x00, 0x00, 0x00}, handshake...))
+
+	first := string(readToken())
+	clientFirst <- first
+	attrs, _ := scramAttributes(strings.TrimPrefix(first, gs2Header))
+	if attrs["n"] != username {
+		return
+	}
+	salt := []byte("salt")
+	serverFirst := "r=" + attrs["r"] + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	writeToken([]byte(serverFirst))
+
+	final := string(readToken())
+	clientFinalWithoutProof := final[:strings.LastIndex(final, ",p=")]
+	authMessage := strings.TrimPrefix(first, gs2Header) + "," + serverFirst + "," + clientFinalWithoutProof
+	saltedPassword := util.PBKDF2(sha256.New, []byte(password), salt, 4096)
+	mac := hmac.New(sha256.New, saltedPassword)
+	mac.Write([]byte("Server Key"))
+	signature := hmac.New(sha256.New, mac.Sum(nil))
+	signature.Write([]byte(authMessage))
+	writeToken([]byte("v=" + base64.StdEncoding.EncodeToString(signature.Sum(nil))))
+}
+
+func TestSASLSCRAMAuth(t *testing.T) {
+	a := assert.New(t)
+
+	auth := &SASLSCRAMAuth{mechanism: SASLSCRAMSHA256, username: "alice", password: "secret"}
+	transport := func(conn net.Conn) saslTransport {
+		return &rawSASLTransport{conn: conn, clientID: "test", writeTimeout: time.Second, readTimeout: time.Second}
+	}
+
+	client, server := net.Pipe()
+	clientFirst := make(chan string, 1)
+	go testSCRAMServer(server, "alice", "secret", clientFirst)
+	a.Nil(auth.authenticate(transport(client)))
+	a.NotContains(<-clientFirst, "tokenauth")
+
+	client, server = net.Pipe()
+	go testSCRAMServer(server, "alice", "other", clientFirst)
+	a.EqualError(auth.authenticate(transport(client)), "SCRAM server signature does not match")
+	<-clientFirst
+
+	client, server = net.Pipe()
+	go testSCRAMServer(server, "bob", "secret", clientFirst)
+	a.EqualError(auth.authenticate(transport(client)), "SASL/SCRAM-SHA-256 auth for user alice failed")
+	<-clientFirst
+}
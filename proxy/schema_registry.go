@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+	// Magic => int8 (0), SchemaId => int32
+	schemaRegistryWireHeaderLength = 5
+
+	maxSchemaRegistryResponseSize = 4 << 20
+	maxSchemaValidationResults    = 4096
+)
+
+type SchemaRegistryOptions struct {
+	Url          string
+	Username     string
+	Password     string
+	Topics       []string // the topic ending with * is a prefix, all topics are validated when empty
+	ValidateKeys bool
+	CacheTTL     time.Duration
+	Timeout      time.Duration
+}
+
+type schemaValidation struct {
+	subject string
+	id      int32
+}
+
+type schemaValidationResult struct {
+	reason  string // empty for valid schemas
+	expires time.Time
+}
+
+type registeredSchema struct {
+	Schema     string            `json:"schema"`
+	SchemaType string            `json:"schemaType,omitempty"`
+	References []json.RawMessage `json:"references,omitempty"`
+}
+
+type schemaRegistryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// SchemaValidation rejects the Produce requests with records whose schema id is unknown to the schema registry or whose schema
+// is not registered under nor compatible with the subject of the topic (TopicNameStrategy).
+type SchemaValidation struct {
+	options SchemaRegistryOptions
+	client  *http.Client
+
+	mu      sync.Mutex
+	results map[schemaValidation]schemaValidationResult
+}
+
+func NewSchemaValidation(options SchemaRegistryOptions) (*SchemaValidation, error) {
+	if options.Url == "" {
+		return nil, errors.New("schema registry url is required")
+	}
+	if _, err := url.Parse(options.Url); err != nil {
+		return nil, errors.Wrap(err, "invalid schema registry url")
+	}
+	options.Url = strings.TrimSuffix(options.Url, "/")
+	return &SchemaValidation{
+		options: options,
+		client:  &http.Client{},
+		results: make(map[schemaValidation]schemaValidationResult),
+	}, nil
+}
+
+func (v *SchemaValidation) validated(topic string) bool {
+	if len(v.options.Topics) == 0 {
+		return true
+	}
+	for _, pattern := range v.options.Topics {
+		if pattern == topic || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterRequest implements apis.FrameFilter
+func (v *SchemaValidation) FilterRequest(request []byte) ([]byte, error) {
+	if int16(binary.BigEndian.Uint16(request)) != apiKeyProduce {
+		return request, nil
+	}
+	// record sets are not modified
+	if _, err := protocol.ModifyProduceRecordSets(request, v.validateRecordSet); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (v *SchemaValidation) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if apiKey == apiKeyApiVersions {
+		// clients must not use Produce versions whose record sets cannot be decoded
+		if err := protocol.LimitApiVersions(apiVersion, response, protocol.MaxRecordSetsVersions); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (v *SchemaValidation) validateRecordSet(topic string, recordSet []byte) ([]byte, error) {
+	if !v.validated(topic) {
+		return recordSet, nil
+	}
+	batches, rest, err := protocol.DecodeRecordBatches(recordSet)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, rejectInvalidRecord("record set of topic %s contains a partial batch", topic)
+	}
+	for _, batch := range batches {
+		if batch.Records == nil {
+			return nil, rejectInvalidRecord("records of topic %s cannot be validated: magic %d, compression %d", topic, batch.Magic(), batch.Compression())
+		}
+		for _, record := range batch.Records {
+			if err = v.validateData(topic, topic+"-value", "value", record.Value); err != nil {
+				return nil, err
+			}
+			if v.options.ValidateKeys {
+				if err = v.validateData(topic, topic+"-key", "key", record.Key); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return recordSet, nil
+}
+
+func (v *SchemaValidation) validateData(topic string, subject string, name string, data []byte) error {
+	if data == nil {
+		// null keys and tombstones
+		return nil
+	}
+	if len(data) < schemaRegistryWireHeaderLength || data[0] != 0 {
+		return rejectInvalidRecord("record %s of topic %s does not start with a schema id", name, topic)
+	}
+	id := int32(binary.BigEndian.Uint32(data[1:]))
+	reason, err := v.validate(subject, id)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return rejectInvalidRecord("record %s of topic %s: %s", name, topic, reason)
+	}
+	return nil
+}
+
+// validate returns the reason why the schema is not valid for the subject, the results are cached
+func (v *SchemaValidation) validate(subject string, id int32) (string, error) {
+	key := schemaValidation{subject: subject, id: id}
+	now := time.Now()
+
+	v.mu.Lock()
+	result, ok := v.results[key]
+	v.mu.Unlock()
+	if ok && now.Before(result.expires) {
+		return result.reason, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.options.Timeout)
+	defer cancel()
+	reason, err := v.check(ctx, subject, id)
+	if err != nil {
+		return "", errors.Wrapf(err, "schema %d of subject %s cannot be validated", id, subject)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.results) >= maxSchemaValidationResults {
+		v.results = make(map[schemaValidation]schemaValidationResult)
+	}
+	v.results[key] = schemaValidationResult{reason: reason, expires: now.Add(v.options.CacheTTL)}
+	return reason, nil
+}
+
+func (v *SchemaValidation) check(ctx context.Context, subject string, id int32) (string, error) {
+	schema := &registeredSchema{}
+	status, registryErr, err := v.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, schema)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return fmt.Sprintf("unknown schema id %d", id), nil
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("schema registry status %d: %s", status, registryErr.Message)
+	}
+
+	// registered under the subject
+	status, registryErr, err = v.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject), schema, &struct{}{})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case status == http.StatusOK:
+		return "", nil
+	case status == http.StatusNotFound && registryErr.ErrorCode == 40401:
+		return fmt.Sprintf("subject %s not found", subject), nil
+	case status != http.StatusNotFound:
+		return "", fmt.Errorf("schema registry status %d: %s", status, registryErr.Message)
+	}
+
+	// compatible with the latest version of the subject
+	compatibility := &struct {
+		IsCompatible bool `json:"is_compatible"`
+	}{}
+	status, registryErr, err = v.do(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", schema, compatibility)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("schema registry status %d: %s", status, registryErr.Message)
+	}
+	if !compatibility.IsCompatible {
+		return fmt.Sprintf("schema id %d is incompatible with subject %s", id, subject), nil
+	}
+	return "", nil
+}
+
+// do sends the request to the schema registry, the response body is decoded into result for the status 200 and into the returned error otherwise
+func (v *SchemaValidation) do(ctx context.Context, method string, path string, body interface{}, result interface{}) (int, *schemaRegistryError, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, v.options.Url+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", schemaRegistryContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", schemaRegistryContentType)
+	}
+	if v.options.Username != "" {
+		req.SetBasicAuth(v.options.Username, v.options.Password)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSchemaRegistryResponseSize))
+	if err != nil {
+		return 0, nil, err
+	}
+	registryErr := &schemaRegistryError{}
+	if resp.StatusCode == http.StatusOK {
+		err = json.Unmarshal(data, result)
+	} else if len(data) != 0 {
+		if json.Unmarshal(data, registryErr) != nil {
+			registryErr.Message = string(data)
+		}
+	}
+	return resp.StatusCode, registryErr, err
+}
+
+func rejectInvalidRecord(format string, args ...interface{}) error {
+	return &apis.FrameRejection{ErrorCode: int16(protocol.ErrInvalidRecord), Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testSchemaRegistry(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "user:secret", user+":"+password)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /schemas/ids/1", "GET /schemas/ids/2", "GET /schemas/ids/3":
+			w.Write([]byte(`{"schema":"\"string\""}`))
+		case "POST /subjects/orders-value":
+			// schema 1 is registered, 2 and 3 are not
+			if *requests == 2 {
+				w.Write([]byte(`{"subject":"orders-value","version":1,"id":1}`))
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		case "POST /compatibility/subjects/orders-value/versions/latest":
+			w.Write([]byte(`{"is_compatible":false}`))
+		case "POST /subjects/payments-value":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40401,"message":"Subject not found"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		}
+	}))
+}
+
+func testRejection(t *testing.T, err error) string {
+	rejection, ok := err.(*apis.FrameRejection)
+	if !assert.True(t, ok, "%v", err) {
+		return ""
+	}
+	assert.Equal(t, int16(87), rejection.ErrorCode)
+	return rejection.Reason
+}
+
+func TestSchemaValidation(t *testing.T) {
+	a := assert.New(t)
+
+	requests := 0
+	server := testSchemaRegistry(t, &requests)
+	defer server.Close()
+
+	v, err := NewSchemaValidation(SchemaRegistryOptions{Url: server.URL, Username: "user", Password: "secret", Topics: []string{"orders", "pay*"}, CacheTTL: time.Minute, Timeout: time.Second})
+	a.Nil(err)
+
+	// registered schema is cached
+	request := testProduceRequest("orders", testRecordSet("\x00\x00\x00\x00\x01v1"))
+	for i := 0; i < 2; i++ {
+		result, err := v.FilterRequest(request)
+		a.Nil(err)
+		a.Equal(request, result)
+	}
+	a.Equal(2, requests)
+
+	_, err = v.FilterRequest(testProduceRequest("orders", testRecordSet("\x00\x00\x00\x00\x02v1")))
+	a.Equal("record value of topic orders: schema id 2 is incompatible with subject orders-value", testRejection(t, err))
+
+	_, err = v.FilterRequest(testProduceRequest("orders", testRecordSet("\x00\x00\x00\x00\x09v1")))
+	a.Equal("record value of topic orders: unknown schema id 9", testRejection(t, err))
+
+	_, err = v.FilterRequest(testProduceRequest("payments", testRecordSet("\x00\x00\x00\x00\x03v1")))
+	a.Equal("record value of topic payments: subject payments-value not found", testRejection(t, err))
+
+	_, err = v.FilterRequest(testProduceRequest("orders", testRecordSet("v1")))
+	a.Equal("record value of topic orders does not start with a schema id", testRejection(t, err))
+
+	// not validated topic
+	requests = 0
+	_, err = v.FilterRequest(testProduceRequest("other", testRecordSet("v1")))
+	a.Nil(err)
+	a.Equal(0, requests)
+}
+
+func TestSchemaValidationRegistryError(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error_code":50001,"message":"Error in the backend data store"}`))
+	}))
+	defer server.Close()
+
+	v, err := NewSchemaValidation(SchemaRegistryOptions{Url: server.URL, CacheTTL: time.Minute, Timeout: time.Second})
+	a.Nil(err)
+	_, err = v.FilterRequest(testProduceRequest("orders", testRecordSet("\x00\x00\x00\x00\x01v1")))
+	a.EqualError(err, "schema 1 of subject orders-value cannot be validated: schema registry status 500: Error in the backend data store")
+	_, ok := err.(*apis.FrameRejection)
+	a.False(ok)
+}
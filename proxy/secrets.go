@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/kms"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const secretRetryInterval = 30 * time.Second
+
+// secretSource holds the secret fetched from Vault or a cloud secret manager, it is fetched again when it is used after two thirds of its lifetime.
+// The previous secret is used until it expires when the fetch fails.
+type secretSource struct {
+	path            string
+	refreshInterval time.Duration
+	// fetch returns the secret and its lifetime, 0 when the secret does not expire
+	fetch func() (interface{}, time.Duration, error)
+
+	mu        sync.Mutex
+	value     interface{}
+	expiry    time.Time // zero when the secret does not expire
+	nextFetch time.Time
+}
+
+func (s *secretSource) get() (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.value != nil && now.Before(s.nextFetch) {
+		return s.value, nil
+	}
+	value, lifetime, err := s.fetch()
+	if err != nil {
+		err = errors.Wrapf(err, "fetching of secret %s failed", s.path)
+		if s.value == nil || (!s.expiry.IsZero() && !now.Before(s.expiry)) {
+			return nil, err
+		}
+		logger.Warnf("%v, the previous secret is used", err)
+		s.nextFetch = now.Add(secretRetryInterval)
+		return s.value, nil
+	}
+	s.value = value
+	if lifetime > 0 {
+		s.expiry = now.Add(lifetime)
+		s.nextFetch = now.Add(lifetime * 2 / 3)
+	} else {
+		s.expiry = time.Time{}
+		s.nextFetch = now.Add(s.refreshInterval)
+	}
+	logger.Infof("Secret %s fetched, next fetch at %v", s.path, s.nextFetch.Format(time.RFC3339))
+	return s.value, nil
+}
+
+type userPassword struct {
+	username string
+	password string
+}
+
+// secretCredentials are the SASL username and password given as the references to the secrets of the cloud secret managers,
+// they are resolved again after the refresh interval to pick up the rotated secrets
+type secretCredentials struct {
+	source *secretSource
+}
+
+func newSecretCredentials(conf *config.Config, username string, password string) (*secretCredentials, error) {
+	resolver := kms.NewSecretResolver(conf.Secrets.Timeout)
+	fetch := func() (interface{}, time.Duration, error) {
+		resolvedUsername, err := resolver.Resolve(context.Background(), username)
+		if err != nil {
+			return nil, 0, err
+		}
+		resolvedPassword, err := resolver.Resolve(context.Background(), password)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &userPassword{username: resolvedUsername, password: resolvedPassword}, 0, nil
+	}
+	path := password
+	if kms.IsSecretReference(username) {
+		path = username
+	}
+	c := &secretCredentials{source: &secretSource{path: path, refreshInterval: conf.Secrets.RefreshInterval, fetch: fetch}}
+	if _, err := c.source.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// credentials implements saslCredentialsProvider
+func (c *secretCredentials) credentials() (string, string, error) {
+	value, err := c.source.get()
+	if err != nil {
+		return "", "", err
+	}
+	credentials := value.(*userPassword)
+	return credentials.username, credentials.password, nil
+}
@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"sync"
+	"time"
+)
+
+// shutdownPollInterval is the interval of the checks whether the connections were drained
+const shutdownPollInterval = 500 * time.Millisecond
+
+// the label names of the metrics cannot be changed after the registration, the labels of the first started server are used
+var metricsLabelsOnce sync.Once
+
+// Server runs the listeners and the client of the proxy, so kafka-proxy can be embedded in other Go services.
+// The plugins of the command are replaced by the implementations given by the options.
+type Server struct {
+	cfg  *config.Config
+	opts serverOptions
+
+	lock      sync.Mutex
+	connset   *ConnSet
+	listeners *Listeners
+	client    *Client
+	done      chan struct{}
+}
+
+type serverOptions struct {
+	keySigner             apis.KeySigner
+	passwordAuthenticator apis.PasswordAuthenticator
+	tokenProvider         apis.TokenProvider
+	tokenInfo             apis.TokenInfo
+	requestAuthorizer     apis.RequestAuthorizer
+	frameFilter           apis.FrameFilter
+	keyManagementService  apis.KeyManagementService
+	addressListener       AddressListener
+	registerer            prometheus.Registerer
+	logger                Logger
+	eventHandlers         []EventHandler
+}
+
+// Option configures the server
+type Option func(*serverOptions)
+
+// WithKeySigner holds the private key of the listener certificate (Proxy.TLS.ListenerKeySigner)
+func WithKeySigner(keySigner apis.KeySigner) Option {
+	return func(o *serverOptions) { o.keySigner = keySigner }
+}
+
+// WithPasswordAuthenticator authenticates the clients by local SASL (Auth.Local)
+func WithPasswordAuthenticator(passwordAuthenticator apis.PasswordAuthenticator) Option {
+	return func(o *serverOptions) { o.passwordAuthenticator = passwordAuthenticator }
+}
+
+// WithTokenProvider provides the tokens sent to the gateway server (Auth.Gateway.Client)
+func WithTokenProvider(tokenProvider apis.TokenProvider) Option {
+	return func(o *serverOptions) { o.tokenProvider = tokenProvider }
+}
+
+// WithTokenInfo verifies the tokens of the gateway clients (Auth.Gateway.Server)
+func WithTokenInfo(tokenInfo apis.TokenInfo) Option {
+	return func(o *serverOptions) { o.tokenInfo = tokenInfo }
+}
+
+// WithRequestAuthorizer authorizes the requests of the clients (Auth.Authz)
+func WithRequestAuthorizer(requestAuthorizer apis.RequestAuthorizer) Option {
+	return func(o *serverOptions) { o.requestAuthorizer = requestAuthorizer }
+}
+
+// WithFrameFilter filters the requests and the responses (Proxy.Filter)
+func WithFrameFilter(frameFilter apis.FrameFilter) Option {
+	return func(o *serverOptions) { o.frameFilter = frameFilter }
+}
+
+// WithKeyManagementService provides the keys of the record encryption (Encryption)
+func WithKeyManagementService(keyManagementService apis.KeyManagementService) Option {
+	return func(o *serverOptions) { o.keyManagementService = keyManagementService }
+}
+
+// WithAddressListener announces on the listener addresses e.g. with the sockets inherited from the parent process or passed by systemd
+func WithAddressListener(addressListener AddressListener) Option {
+	return func(o *serverOptions) { o.addressListener = addressListener }
+}
+
+// WithRegisterer registers the connection metrics with the registerer instead of the default prometheus registerer.
+// The other metrics of the proxy are always registered with the default prometheus registerer.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(o *serverOptions) { o.registerer = registerer }
+}
+
+// WithLogger replaces the logger of the proxy e.g. by a zap sugared logger, the logger is shared by all servers of the process
+func WithLogger(l Logger) Option {
+	return func(o *serverOptions) { o.logger = l }
+}
+
+// WithEventHandler passes the events of the proxy e.g. the opened connections and the failed authentications to the handler
+// while the server is running. The handlers receive the events of all servers of the process.
+func WithEventHandler(handler EventHandler) Option {
+	return func(o *serverOptions) { o.eventHandlers = append(o.eventHandlers, handler) }
+}
+
+// New creates the server with the configuration, the configuration is validated
+func New(cfg *config.Config, opts ...Option) (*Server, error) {
+	if cfg == nil {
+		return nil, errors.New("config must not be nil")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{cfg: cfg, opts: serverOptions{registerer: prometheus.DefaultRegisterer}, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	if s.opts.logger != nil {
+		SetLogger(s.opts.logger)
+	}
+	return s, nil
+}
+
+// Start starts the listeners and serves the connections in the background. The server is closed when ctx is done,
+// Shutdown stops the server gracefully.
+func (s *Server) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.client != nil {
+		return errors.New("server was already started")
+	}
+	connset := NewConnSet()
+	collector := NewCollector(connset)
+	if err := s.opts.registerer.Register(collector); err != nil {
+		return errors.Wrap(err, "connection metrics were not registered")
+	}
+	metricsLabelsOnce.Do(func() { SetMetricsLabels(s.cfg.Http.MetricsLabels) })
+	removeEventHandlers := make([]func(), 0, len(s.opts.eventHandlers))
+	for _, handler := range s.opts.eventHandlers {
+		removeEventHandlers = append(removeEventHandlers, AddEventHandler(handler))
+	}
+	cleanup := func() {
+		for _, remove := range removeEventHandlers {
+			remove()
+		}
+		s.opts.registerer.Unregister(collector)
+	}
+
+	listeners, err := NewListeners(s.cfg, s.opts.keySigner, s.opts.addressListener)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	connSrc, err := listeners.ListenInstances(s.cfg.Proxy.BootstrapServers)
+	if err == nil {
+		err = listeners.ListenServerMappingFile()
+	}
+	var tunnelServer *TunnelServer
+	if err == nil && s.cfg.Tunnel.Server.ListenAddress != "" {
+		tunnelServer, err = s.listenTunnel()
+	}
+	netAddressMappingFunc := listeners.GetNetAddressMapping
+	if err == nil && s.cfg.Kubernetes.Service != "" {
+		netAddressMappingFunc, err = kubernetesNetAddressMapping(s.cfg, netAddressMappingFunc)
+	}
+	var client *Client
+	if err == nil {
+		client, err = NewClient(connset, s.cfg, netAddressMappingFunc, s.opts.passwordAuthenticator, s.opts.tokenProvider, s.opts.tokenInfo, s.opts.requestAuthorizer, s.opts.frameFilter, s.opts.keyManagementService)
+	}
+	if err != nil {
+		if tunnelServer != nil {
+			tunnelServer.Close()
+		}
+		listeners.Close()
+		cleanup()
+		return err
+	}
+	listeners.setBrokerMigrations(client.brokerMigrations)
+	s.connset, s.listeners, s.client = connset, listeners, client
+
+	go func() {
+		defer close(s.done)
+		logger.Infof("Ready for new connections")
+		client.Run(connSrc)
+		if tunnelServer != nil {
+			tunnelServer.Close()
+		}
+		cleanup()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// listenTunnel starts the tunnel server, which dials the brokers for the tunnel clients
+func (s *Server) listenTunnel() (*TunnelServer, error) {
+	tunnelServer, err := NewTunnelServer(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	address := s.cfg.Tunnel.Server.ListenAddress
+	var listener net.Listener
+	if s.opts.addressListener != nil {
+		listener, err = s.opts.addressListener.Listen(address, false, false)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "tunnel server %s", address)
+	}
+	if s.cfg.Tunnel.Server.Transport == "quic" {
+		// the QUIC connections are accepted on the UDP port of the TLS listener
+		packetConn, err := net.ListenPacket("udp", listener.Addr().String())
+		if err != nil {
+			listener.Close()
+			return nil, errors.Wrapf(err, "tunnel server %s", address)
+		}
+		go withRecover(func() {
+			if err := tunnelServer.ServeQUIC(packetConn); err != nil {
+				logger.Errorf("Tunnel server %s stopped accepting QUIC connections: %v", address, err)
+			}
+		})
+	}
+	go withRecover(func() {
+		if err := tunnelServer.Serve(listener); err != nil {
+			logger.Errorf("Tunnel server %s stopped: %v", address, err)
+		}
+	})
+	return tunnelServer, nil
+}
+
+// Shutdown stops the listeners and waits until the clients close their connections. The remaining connections
+// are closed when ctx is done, the error of ctx is returned then.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	listeners, client := s.listeners, s.client
+	s.lock.Unlock()
+	if client == nil {
+		return errors.New("server was not started")
+	}
+	listeners.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && s.Connections() != 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	client.Close()
+	<-s.done
+	return err
+}
+
+// Close stops the listeners and closes the connections
+func (s *Server) Close() {
+	s.lock.Lock()
+	listeners, client := s.listeners, s.client
+	s.lock.Unlock()
+	if client == nil {
+		return
+	}
+	listeners.Close()
+	client.Close()
+}
+
+// Done is closed when the server is stopped
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Connections returns the number of the open connections
+func (s *Server) Connections() int {
+	s.lock.Lock()
+	connset := s.connset
+	s.lock.Unlock()
+	if connset == nil {
+		return 0
+	}
+	count := 0
+	for _, n := range connset.Count() {
+		count += n
+	}
+	return count
+}
+
+// Client returns the client of the started server e.g. for the HTTP endpoints of the broker drains and the maintenance mode
+func (s *Server) Client() *Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.client
+}
+
+// HTTPListener returns the listener of the HTTP connections accepted by the TLS listeners of the started server with ALPN,
+// nil when Proxy.TLS.ListenerALPN is disabled
+func (s *Server) HTTPListener() net.Listener {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listeners == nil || s.listeners.alpnHTTPListener == nil {
+		return nil
+	}
+	return s.listeners.alpnHTTPListener
+}
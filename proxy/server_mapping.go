@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/libs/util"
+	"github.com/pkg/errors"
+)
+
+// ListenServerMappingFile starts the listeners of the server mapping file and watches the file for changes. On change the listeners
+// of the removed bootstrap server mappings are closed, the accepted connections are kept, and the listeners of the added mappings are started.
+// The previous mappings are kept when the file is invalid.
+func (p *Listeners) ListenServerMappingFile() error {
+	if p.serverMappingFile == "" {
+		return nil
+	}
+	if err := p.updateServerMappings(p.fileBootstrapServers, p.fileExternalServers); err != nil {
+		return err
+	}
+	if err := util.WatchForUpdates(p.serverMappingFile, p.done, p.reloadServerMappingFile); err != nil {
+		return errors.Wrap(err, "cannot watch server mapping file")
+	}
+	return nil
+}
+
+func (p *Listeners) reloadServerMappingFile() {
+	bootstrapServers, externalServers, err := config.LoadServerMappingFile(p.serverMappingFile)
+	if err != nil {
+		logger.Errorf("error while reloading server mapping file: %v", err)
+		return
+	}
+	if err = p.updateServerMappings(bootstrapServers, externalServers); err != nil {
+		logger.Errorf("error while reloading server mapping file: %v", err)
+		return
+	}
+	logger.Infof("reloaded %d bootstrap and %d external server mappings from server mapping file %s", len(bootstrapServers), len(externalServers), p.serverMappingFile)
+	emitEvent(Event{Type: EventConfigReloaded, Message: "server mapping file " + p.serverMappingFile})
+}
+
+// setBrokerMigrations moves the clients of the listeners whose broker address changed on the next reloads
+func (p *Listeners) setBrokerMigrations(brokerMigrations *brokerMigrations) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.brokerMigrations = brokerMigrations
+}
+
+// updateServerMappings replaces the mappings of the server mapping file, the mappings of the dynamic listeners are kept.
+// A listener which cannot be started is retried on the next update.
+func (p *Listeners) updateServerMappings(bootstrapServers []config.ListenerConfig, externalServers []config.ListenerConfig) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	brokerToListenerConfig, err := getBrokerToListenerConfig(
+		append(append([]config.ListenerConfig{}, p.bootstrapServers...), bootstrapServers...),
+		append(append([]config.ListenerConfig{}, p.externalServers...), externalServers...))
+	if err != nil {
+		return err
+	}
+	fileBrokers := make(map[string]bool)
+	for _, v := range append(append([]config.ListenerConfig{}, p.fileBootstrapServers...), p.fileExternalServers...) {
+		fileBrokers[v.BrokerAddress] = true
+	}
+	for brokerAddress, v := range p.brokerToListenerConfig {
+		if _, ok := brokerToListenerConfig[brokerAddress]; !ok && !fileBrokers[brokerAddress] {
+			brokerToListenerConfig[brokerAddress] = v
+		}
+	}
+
+	listenerConfigs := make(map[config.ListenerConfig]bool)
+	for _, v := range bootstrapServers {
+		listenerConfigs[v] = true
+	}
+	// started by ListenInstances
+	for _, v := range p.bootstrapServers {
+		delete(listenerConfigs, v)
+	}
+	for cfg, l := range p.fileListeners {
+		if !listenerConfigs[cfg] {
+			logger.Infof("Closing listener on %s for remote %s", cfg.ListenerAddress, cfg.BrokerAddress)
+			l.Close()
+			delete(p.fileListeners, cfg)
+			for i, listener := range p.listeners {
+				if listener == l {
+					p.listeners = append(p.listeners[:i], p.listeners[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	var listenErr error
+	for cfg := range listenerConfigs {
+		if _, ok := p.fileListeners[cfg]; ok {
+			continue
+		}
+		l, err := p.listen(cfg)
+		if err != nil {
+			listenErr = err
+			continue
+		}
+		p.fileListeners[cfg] = l
+	}
+	// the clients of the listener whose broker address changed are moved to the new address
+	previousBrokers := make(map[string]string)
+	for _, v := range p.fileBootstrapServers {
+		previousBrokers[v.ListenerAddress] = v.BrokerAddress
+	}
+	for _, v := range bootstrapServers {
+		if previous, ok := previousBrokers[v.ListenerAddress]; ok && previous != v.BrokerAddress {
+			p.brokerMigrations.migrate(previous, v.BrokerAddress)
+		}
+	}
+	p.brokerToListenerConfig = brokerToListenerConfig
+	p.fileBootstrapServers = bootstrapServers
+	p.fileExternalServers = externalServers
+	return listenErr
+}
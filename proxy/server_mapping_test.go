@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestServerMappingFileReload(t *testing.T) {
+	a := assert.New(t)
+
+	mappingFile, err := ioutil.TempFile("", "server-mapping")
+	a.Nil(err)
+	defer os.Remove(mappingFile.Name())
+	writeMappings := func(mappings string) {
+		a.Nil(ioutil.WriteFile(mappingFile.Name(), []byte(mappings), 0644))
+	}
+	writeMappings(`
+bootstrap-server-mapping:
+  - kafka-1:9092,127.0.0.1:0,proxy-1:32401
+external-server-mapping:
+  - kafka-2:9092,proxy-2:32402
+`)
+
+	c := config.NewConfig()
+	c.Proxy.DefaultListenerIP = "127.0.0.1"
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: "kafka-0:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-0:32400"}}
+	c.Proxy.ServerMapping.File = mappingFile.Name()
+	a.Nil(c.InitServerMappingFile())
+	listeners, err := NewListeners(c, nil, nil)
+	a.Nil(err)
+	_, err = listeners.ListenInstances(c.Proxy.BootstrapServers)
+	a.Nil(err)
+	// the file is not watched, the reloads are called by the test
+	a.Nil(listeners.updateServerMappings(listeners.fileBootstrapServers, listeners.fileExternalServers))
+	a.Len(listeners.fileListeners, 1)
+
+	host, port, err := listeners.GetNetAddressMapping("kafka-1", 9092)
+	a.Nil(err)
+	a.Equal("proxy-1", host)
+	a.Equal(int32(32401), port)
+	host, _, err = listeners.GetNetAddressMapping("kafka-2", 9092)
+	a.Nil(err)
+	a.Equal("proxy-2", host)
+	host, _, err = listeners.GetNetAddressMapping("kafka-9", 9092)
+	a.Nil(err)
+	a.Equal("127.0.0.1", host)
+
+	removedListener := listeners.fileListeners[config.ListenerConfig{BrokerAddress: "kafka-1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "proxy-1:32401"}]
+	a.NotNil(removedListener)
+	writeMappings(`
+bootstrap-server-mapping:
+  - kafka-3:9092,127.0.0.1:0,proxy-3:32403
+`)
+	listeners.reloadServerMappingFile()
+	a.Len(listeners.fileListeners, 1)
+	_, err = net.Dial("tcp", removedListener.Addr().String())
+	a.NotNil(err)
+
+	host, _, err = listeners.GetNetAddressMapping("kafka-3", 9092)
+	a.Nil(err)
+	a.Equal("proxy-3", host)
+	host, _, err = listeners.GetNetAddressMapping("kafka-0", 9092)
+	a.Nil(err)
+	a.Equal("proxy-0", host)
+	// the dynamic listener is kept, the removed mappings are not
+	a.Contains(listeners.brokerToListenerConfig, "kafka-9:9092")
+	a.NotContains(listeners.brokerToListenerConfig, "kafka-1:9092")
+	a.NotContains(listeners.brokerToListenerConfig, "kafka-2:9092")
+
+	// the previous mappings are kept when the file is invalid or conflicts with the flags
+	for _, mappings := range []string{
+		"bootstrap-server-mapping: [kafka-4:9092]",
+		"bootstrap-server-mapping: ['kafka-0:9092,127.0.0.1:0,proxy-4:32404']",
+	} {
+		writeMappings(mappings)
+		listeners.reloadServerMappingFile()
+		host, _, err = listeners.GetNetAddressMapping("kafka-3", 9092)
+		a.Nil(err)
+		a.Equal("proxy-3", host)
+		host, _, err = listeners.GetNetAddressMapping("kafka-0", 9092)
+		a.Nil(err)
+		a.Equal("proxy-0", host)
+	}
+	a.Len(listeners.fileListeners, 1)
+}
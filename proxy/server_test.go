@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// testAddressListener passes the started listeners to the test
+type testAddressListener chan net.Listener
+
+func (l testAddressListener) Listen(address string, reusePort bool, shared bool) (net.Listener, error) {
+	listener, err := Listen(address, reusePort)
+	if err == nil {
+		l <- listener
+	}
+	return listener, err
+}
+
+func testConnections(s *Server, expected int) int {
+	for i := 0; i < 100 && s.Connections() != expected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.Connections()
+}
+
+func TestServer(t *testing.T) {
+	a := assert.New(t)
+
+	broker, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer broker.Close()
+	go func() {
+		for {
+			conn, err := broker.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, err = New(nil)
+	a.EqualError(err, "config must not be nil")
+
+	c := config.NewConfig()
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: broker.Addr().String(), ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "127.0.0.1:32400"}}
+	listeners := make(testAddressListener, 1)
+	events := make(chan Event, 10)
+	s, err := New(c, WithAddressListener(listeners), WithRegisterer(prometheus.NewRegistry()), WithEventHandler(EventHandlerFunc(func(event Event) {
+		select {
+		case events <- event:
+		default:
+		}
+	})))
+	a.Nil(err)
+	a.Nil(s.Client())
+	a.EqualError(s.Shutdown(context.Background()), "server was not started")
+
+	a.Nil(s.Start(context.Background()))
+	a.NotNil(s.Client())
+	a.EqualError(s.Start(context.Background()), "server was already started")
+	listener := <-listeners
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	a.Nil(err)
+	a.Equal(1, testConnections(s, 1))
+	event := <-events
+	a.Equal(EventConnectionOpened, event.Type)
+	a.Equal(broker.Addr().String(), event.Broker)
+	a.Equal(client.LocalAddr().String(), event.Client)
+
+	// the listeners are closed at once, the connections when the shutdown times out
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.Equal(context.DeadlineExceeded, s.Shutdown(ctx))
+	_, err = listener.Accept()
+	a.NotNil(err)
+	<-s.Done()
+	a.Equal(0, testConnections(s, 0))
+	client.Close()
+}
+
+func TestServerContext(t *testing.T) {
+	a := assert.New(t)
+
+	c := config.NewConfig()
+	c.Proxy.BootstrapServers = []config.ListenerConfig{{BrokerAddress: "127.0.0.1:9092", ListenerAddress: "127.0.0.1:0", AdvertisedAddress: "127.0.0.1:32400"}}
+	s, err := New(c, WithRegisterer(prometheus.NewRegistry()))
+	a.Nil(err)
+
+	// the server is closed with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	a.Nil(s.Start(ctx))
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		a.Fail("server was not closed")
+	}
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/pkg/errors"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shadowMetadataRefreshBackoff is the minimal interval between the Metadata requests to the shadow cluster
+const shadowMetadataRefreshBackoff = time.Second
+
+// ProduceShadow copies the Produce requests sent to the brokers to the shadow cluster. The copies are split by the leaders
+// of the partitions in the shadow cluster and sent asynchronously, the clients receive only the responses of the primary cluster.
+// The copies which cannot be queued or sent are dropped. The transactional requests are not shadowed, the idempotent
+// batches are accepted by the shadow cluster only when the shadowing starts together with the producer.
+type ProduceShadow struct {
+	name             string
+	bootstrapServers []string
+	topics           []*regexp.Regexp
+	dial             func(brokerAddress string) (net.Conn, error)
+	timeout          time.Duration
+	clientID         string
+	queueSize        int
+
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// owned by the dispatcher
+	leaders       *protocol.PartitionLeaders
+	knownTopics   map[string]struct{}
+	refreshed     time.Time
+	correlationID int32
+	senders       map[string]*shadowSender
+
+	// set when a copy failed, the leaders may have moved
+	stale int32
+}
+
+// shadowSender sends the copies to one broker of the shadow cluster over its own connection
+type shadowSender struct {
+	brokerAddress string
+	queue         chan shadowRequest
+}
+
+type shadowRequest struct {
+	request []byte
+	acks    int16
+}
+
+// NewProduceShadow creates the shadow of the Produce requests to the cluster with the bootstrap servers.
+// The topics are regexps matching the whole topic name, all topics are shadowed when empty.
+func NewProduceShadow(name string, bootstrapServers []string, topics []string, queueSize int, dial func(brokerAddress string) (net.Conn, error), timeout time.Duration, clientID string) (*ProduceShadow, error) {
+	if queueSize <= 0 {
+		return nil, errors.New("shadow queue size must be greater than 0")
+	}
+	s := &ProduceShadow{
+		name:             name,
+		bootstrapServers: bootstrapServers,
+		dial:             dial,
+		timeout:          timeout,
+		clientID:         clientID,
+		queueSize:        queueSize,
+		queue:            make(chan []byte, queueSize),
+		done:             make(chan struct{}),
+		knownTopics:      make(map[string]struct{}),
+		senders:          make(map[string]*shadowSender),
+	}
+	for _, topic := range topics {
+		pattern, err := regexp.Compile("^(?:" + topic + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "shadow topic '%s'", topic)
+		}
+		s.topics = append(s.topics, pattern)
+	}
+	return s, nil
+}
+
+// shadows checks whether the requests with the api key are copied to the shadow cluster
+func (s *ProduceShadow) shadows(apiKey int16) bool {
+	return s != nil && apiKey == apiKeyProduce
+}
+
+// shadow queues the copy of the Produce request (without the size field), the request must not be modified afterwards
+func (s *ProduceShadow) shadow(request []byte) {
+	select {
+	case s.queue <- request:
+	default:
+		proxyShadowProduceDroppedTotal.WithLabelValues("queue").Inc()
+	}
+}
+
+func (s *ProduceShadow) selected(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	for _, pattern := range s.topics {
+		if pattern.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run dispatches the queued copies to the senders until the shadow is closed
+func (s *ProduceShadow) Run() {
+	logger.Infof("Produce requests are shadowed to cluster %s %v", s.name, s.bootstrapServers)
+	for {
+		select {
+		case <-s.done:
+			return
+		case request := <-s.queue:
+			s.dispatch(request)
+		}
+	}
+}
+
+func (s *ProduceShadow) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *ProduceShadow) dispatch(request []byte) {
+	split, err := protocol.SplitProduceRequest(request, s.selected, s.leaders.Leader)
+	if err == nil && (len(split.Unknown) != 0 || atomic.LoadInt32(&s.stale) == 1) && s.refresh(split.Unknown) {
+		split, err = protocol.SplitProduceRequest(request, s.selected, s.leaders.Leader)
+	}
+	if err != nil {
+		if err == protocol.ErrTransactionalProduceRequest {
+			proxyShadowProduceDroppedTotal.WithLabelValues("transactional").Inc()
+		} else {
+			logger.Debugf("Produce request is not shadowed: %v", err)
+			proxyShadowProduceDroppedTotal.WithLabelValues("invalid").Inc()
+		}
+		return
+	}
+	if len(split.Unknown) != 0 {
+		logger.Debugf("Leaders of topics %v are unknown in shadow cluster %s", split.Unknown, s.name)
+		proxyShadowProduceDroppedTotal.WithLabelValues("metadata").Inc()
+	}
+	for brokerAddress, shadowed := range split.Requests {
+		sender := s.sender(brokerAddress)
+		select {
+		case sender.queue <- shadowRequest{request: shadowed, acks: split.Acks}:
+		default:
+			proxyShadowProduceDroppedTotal.WithLabelValues("queue").Inc()
+		}
+	}
+}
+
+// refresh fetches the leaders of the known topics and the new topics, it returns false when the leaders were not refreshed
+func (s *ProduceShadow) refresh(newTopics []string) bool {
+	for _, topic := range newTopics {
+		s.knownTopics[topic] = struct{}{}
+	}
+	if time.Since(s.refreshed) < shadowMetadataRefreshBackoff {
+		return false
+	}
+	s.refreshed = time.Now()
+
+	topics := make([]string, 0, len(s.knownTopics))
+	for topic := range s.knownTopics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	s.correlationID++
+	request := protocol.EncodeShadowMetadataRequest(s.correlationID, s.clientID, topics)
+	for _, brokerAddress := range s.bootstrapServers {
+		leaders, err := s.fetchLeaders(brokerAddress, request)
+		if err != nil {
+			logger.Infof("Metadata request to %s of shadow cluster %s failed: %v", brokerAddress, s.name, err)
+			continue
+		}
+		s.leaders = leaders
+		atomic.StoreInt32(&s.stale, 0)
+		return true
+	}
+	return false
+}
+
+func (s *ProduceShadow) fetchLeaders(brokerAddress string, request []byte) (*protocol.PartitionLeaders, error) {
+	conn, err := s.dial(brokerAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	response, err := roundTripRequest(conn, request, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.DecodeShadowMetadataResponse(response)
+}
+
+func (s *ProduceShadow) sender(brokerAddress string) *shadowSender {
+	sender, ok := s.senders[brokerAddress]
+	if !ok {
+		sender = &shadowSender{brokerAddress: brokerAddress, queue: make(chan shadowRequest, s.queueSize)}
+		s.senders[brokerAddress] = sender
+		go withRecover(func() { s.runSender(sender) })
+	}
+	return sender
+}
+
+// runSender sends the copies one by one, the responses are awaited unless the producer does not expect them
+func (s *ProduceShadow) runSender(sender *shadowSender) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-s.done:
+			return
+		case shadowed := <-sender.queue:
+			var err error
+			if conn == nil {
+				if conn, err = s.dial(sender.brokerAddress); err != nil {
+					s.failed(sender, "send", err)
+					continue
+				}
+			}
+			reason, err := s.send(conn, shadowed)
+			if err != nil {
+				s.failed(sender, reason, err)
+				if reason == "send" {
+					conn.Close()
+					conn = nil
+				}
+				continue
+			}
+			proxyShadowProduceRequestsTotal.WithLabelValues(sender.brokerAddress).Inc()
+		}
+	}
+}
+
+// send returns the drop reason of the failed copy
+func (s *ProduceShadow) send(conn net.Conn, shadowed shadowRequest) (string, error) {
+	if shadowed.acks == 0 {
+		if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+			return "send", err
+		}
+		if err := writeRequest(conn, shadowed.request); err != nil {
+			return "send", err
+		}
+		return "", nil
+	}
+	response, err := roundTripRequest(conn, shadowed.request, s.timeout)
+	if err != nil {
+		return "send", err
+	}
+	apiVersion := int16(binary.BigEndian.Uint16(shadowed.request[2:]))
+	errorCodes, err := protocol.ResponseErrorCodes(apiKeyProduce, apiVersion, response)
+	if err != nil {
+		return "send", err
+	}
+	if len(errorCodes) != 0 {
+		return "error", fmt.Errorf("response errors %v", errorCodes)
+	}
+	return "", nil
+}
+
+func (s *ProduceShadow) failed(sender *shadowSender, reason string, err error) {
+	logger.Debugf("Produce request to %s of shadow cluster %s failed: %v", sender.brokerAddress, s.name, err)
+	proxyShadowProduceDroppedTotal.WithLabelValues(reason).Inc()
+	atomic.StoreInt32(&s.stale, 1)
+}
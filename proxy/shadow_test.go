@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Metadata v1 response with the broker 1 leading the partition 0 of the topic
+func testShadowMetadataResponse(host string, topic string) []byte {
+	response := []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, byte(len(host))}
+	response = append(response, host...)
+	response = append(response, 0x00, 0x00, 0x23, 0x84, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, byte(len(topic)))
+	response = append(response, topic...)
+	// is_internal, partition_metadata
+	response = append(response, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01)
+	// replicas, isr
+	return append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01)
+}
+
+// Produce v3 response without errors for the partition 0 of the topic
+func testShadowProduceResponse(topic string) []byte {
+	response := []byte{0x00, 0x00, 0x00, 0x01, 0x00, byte(len(topic))}
+	response = append(response, topic...)
+	response = append(response, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, make([]byte, 8)...)
+	return append(response, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00)
+}
+
+// testShadowDial answers the Metadata requests of the bootstrap server and records the Produce requests of the leader
+func testShadowDial(a *assert.Assertions, produced chan<- []byte) func(string) (net.Conn, error) {
+	return func(brokerAddress string) (net.Conn, error) {
+		if brokerAddress != "shadow-0:9092" && brokerAddress != "shadow-1:9092" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			for {
+				request := testReadFrame(a, server)
+				if request == nil {
+					return
+				}
+				switch int16(binary.BigEndian.Uint16(request)) {
+				case apiKeyMetadata:
+					testWriteResponse(a, server, request, testShadowMetadataResponse("shadow-1", "t1"))
+				case apiKeyProduce:
+					produced <- request
+					testWriteResponse(a, server, request, testShadowProduceResponse("t1"))
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func TestNewProduceShadow(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewProduceShadow("shadow", []string{"shadow-0:9092"}, []string{"t("}, 10, nil, time.Second, "test")
+	a.NotNil(err)
+	_, err = NewProduceShadow("shadow", []string{"shadow-0:9092"}, nil, 0, nil, time.Second, "test")
+	a.EqualError(err, "shadow queue size must be greater than 0")
+
+	s, err := NewProduceShadow("shadow", []string{"shadow-0:9092"}, []string{"orders\\..*"}, 10, nil, time.Second, "test")
+	a.Nil(err)
+	a.True(s.shadows(apiKeyProduce))
+	a.False(s.shadows(apiKeyFetch))
+	a.True(s.selected("orders.eu"))
+	a.False(s.selected("x.orders.eu"))
+
+	var disabled *ProduceShadow
+	a.False(disabled.shadows(apiKeyProduce))
+}
+
+func TestProduceShadow(t *testing.T) {
+	a := assert.New(t)
+
+	metadataDrops := testCounterValue(a, proxyShadowProduceDroppedTotal.WithLabelValues("metadata"))
+	transactionalDrops := testCounterValue(a, proxyShadowProduceDroppedTotal.WithLabelValues("transactional"))
+
+	produced := make(chan []byte, 10)
+	s, err := NewProduceShadow("shadow", []string{"shadow-0:9092"}, nil, 10, testShadowDial(a, produced), time.Second, "test")
+	a.Nil(err)
+	go s.Run()
+	defer s.Close()
+
+	// the leader of t1 is fetched from the bootstrap server
+	request := testProduceRequest("t1", testRecordSet("v1"))
+	s.shadow(request)
+	a.Equal(request, <-produced)
+
+	// t2 is unknown to the shadow cluster, the transactional requests are not shadowed
+	s.shadow(testProduceRequest("t2", testRecordSet("v2")))
+	transactional := testProduceRequest("t1", testRecordSet("v3"))
+	transactional = append(append(append([]byte{}, transactional[:11]...), 0x00, 0x02, 't', 'x'), transactional[13:]...)
+	s.shadow(transactional)
+	request = testProduceRequest("t1", testRecordSet("v4"))
+	s.shadow(request)
+	a.Equal(request, <-produced)
+	a.Equal(metadataDrops+1, testCounterValue(a, proxyShadowProduceDroppedTotal.WithLabelValues("metadata")))
+	a.Equal(transactionalDrops+1, testCounterValue(a, proxyShadowProduceDroppedTotal.WithLabelValues("transactional")))
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	slowConsumerPolicyLog        = "log"
+	slowConsumerPolicyThrottle   = "throttle"
+	slowConsumerPolicyDisconnect = "disconnect"
+)
+
+// SlowConsumers detects the clients which do not read the Fetch responses. A write of a Fetch response to the client blocked longer
+// than the threshold is logged and counted, the policy throttles the next Fetch request of the client or closes the connection,
+// so a stuck consumer does not hold the proxy buffers.
+type SlowConsumers struct {
+	threshold time.Duration
+	policy    string
+}
+
+func NewSlowConsumers(threshold time.Duration, policy string) *SlowConsumers {
+	return &SlowConsumers{threshold: threshold, policy: policy}
+}
+
+// connection returns the slow consumer detection of the connection, nil when the detection is not configured
+func (s *SlowConsumers) connection(clientAddress string, brokerAddress string) *slowConsumer {
+	if s == nil {
+		return nil
+	}
+	return &slowConsumer{SlowConsumers: s, clientAddress: clientAddress, brokerAddress: brokerAddress}
+}
+
+// slowConsumer is shared by the requests and the responses loop of the connection
+type slowConsumer struct {
+	*SlowConsumers
+	clientAddress string
+	brokerAddress string
+	// nanoseconds the next Fetch request is delayed by, set by the responses loop
+	throttle int64
+}
+
+func (c *slowConsumer) detects(apiKey int16) bool {
+	return c != nil && apiKey == apiKeyFetch
+}
+
+// writer returns the writer of the Fetch response timing the writes to the client, the deadline is the deadline of the response
+func (c *slowConsumer) writer(dst DeadlineWriter, deadline time.Time) DeadlineWriter {
+	return &slowConsumerWriter{DeadlineWriter: dst, consumer: c, deadline: deadline}
+}
+
+// detected counts and logs the slow consumer, with the throttle policy the next Fetch request is delayed by the blocked time
+func (c *slowConsumer) detected(blocked time.Duration) {
+	proxySlowConsumersTotal.WithLabelValues(c.brokerAddress, c.policy).Inc()
+	switch c.policy {
+	case slowConsumerPolicyLog:
+		logger.Infof("Client %s is a slow consumer, the write of the Fetch response from %s blocked for %v", c.clientAddress, c.brokerAddress, blocked)
+	case slowConsumerPolicyThrottle:
+		atomic.StoreInt64(&c.throttle, int64(blocked))
+		logger.Infof("Client %s is a slow consumer, the write of the Fetch response from %s blocked for %v, the next Fetch request is delayed", c.clientAddress, c.brokerAddress, blocked)
+	}
+}
+
+// throttleFetch delays the Fetch request of the throttled slow consumer
+func (c *slowConsumer) throttleFetch(apiKey int16) {
+	if !c.detects(apiKey) || c.policy != slowConsumerPolicyThrottle {
+		return
+	}
+	if delay := time.Duration(atomic.SwapInt64(&c.throttle, 0)); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type slowConsumerWriter struct {
+	DeadlineWriter
+	consumer *slowConsumer
+	deadline time.Time
+	detected bool
+}
+
+func (w *slowConsumerWriter) Write(p []byte) (n int, err error) {
+	start := time.Now()
+	if w.consumer.policy == slowConsumerPolicyDisconnect {
+		// the write blocked longer than the threshold fails
+		deadline := start.Add(w.consumer.threshold)
+		if deadline.After(w.deadline) {
+			deadline = w.deadline
+		}
+		if err = w.DeadlineWriter.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+	n, err = w.DeadlineWriter.Write(p)
+	blocked := time.Since(start)
+	if blocked < w.consumer.threshold {
+		return n, err
+	}
+	if !w.detected {
+		// once per response
+		w.detected = true
+		w.consumer.detected(blocked)
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && w.consumer.policy == slowConsumerPolicyDisconnect {
+		return n, fmt.Errorf("client %s is a slow consumer, the write of the Fetch response from %s blocked for %v", w.consumer.clientAddress, w.consumer.brokerAddress, blocked)
+	}
+	return n, err
+}
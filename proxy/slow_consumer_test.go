@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowConsumerThrottle(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *SlowConsumers
+	a.Nil(disabled.connection("client:1234", "broker:9092"))
+	a.False(disabled.connection("client:1234", "broker:9092").detects(apiKeyFetch))
+
+	consumer := NewSlowConsumers(20*time.Millisecond, slowConsumerPolicyThrottle).connection("client:1234", "broker:9092")
+	a.True(consumer.detects(apiKeyFetch))
+	a.False(consumer.detects(apiKeyProduce))
+
+	proxySide, clientSide := net.Pipe()
+	defer proxySide.Close()
+	go func() {
+		// the first write is read at once, the second after a while
+		buf := make([]byte, 4)
+		clientSide.Read(buf)
+		time.Sleep(50 * time.Millisecond)
+		ioutil.ReadAll(clientSide)
+	}()
+	dst := consumer.writer(proxySide, time.Now().Add(time.Second))
+	_, err := dst.Write([]byte{0x00, 0x00, 0x00, 0x01})
+	a.Nil(err)
+	a.Zero(atomic.LoadInt64(&consumer.throttle))
+	_, err = dst.Write([]byte{0x01})
+	a.Nil(err)
+	throttle := time.Duration(atomic.LoadInt64(&consumer.throttle))
+	a.True(throttle >= 20*time.Millisecond)
+
+	// the next Fetch request is delayed once
+	start := time.Now()
+	consumer.throttleFetch(apiKeyFetch)
+	a.True(time.Since(start) >= throttle)
+	a.Zero(atomic.LoadInt64(&consumer.throttle))
+	clientSide.Close()
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	a := assert.New(t)
+
+	consumer := NewSlowConsumers(20*time.Millisecond, slowConsumerPolicyDisconnect).connection("client:1234", "broker:9092")
+	proxySide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	// the client does not read the response
+	start := time.Now()
+	_, err := consumer.writer(proxySide, time.Now().Add(time.Second)).Write([]byte{0x00, 0x00, 0x00, 0x01})
+	a.Error(err)
+	a.Contains(err.Error(), "client client:1234 is a slow consumer, the write of the Fetch response from broker:9092 blocked for")
+	a.True(time.Since(start) < time.Second)
+
+	// the disconnect policy does not delay the next Fetch request
+	consumer.throttleFetch(apiKeyFetch)
+	a.Zero(atomic.LoadInt64(&consumer.throttle))
+}
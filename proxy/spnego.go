@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"net"
+	"strings"
+)
+
+// forwardProxyKerberos obtains the Kerberos service tickets of the HTTP forward proxy for the Negotiate (SPNEGO) authentication
+type forwardProxyKerberos struct {
+	client *client.Client
+	// service principal name of the proxy e.g. HTTP/proxy.corp.local
+	spn string
+}
+
+// newForwardProxyKerberos returns nil when the CONNECT requests are not authenticated with negotiate. The credentials come from the
+// credentials cache, the keytab of the URL user or the URL credentials, in this order.
+func newForwardProxyKerberos(c *config.Config, forwardProxy config.ForwardProxyConfig) (*forwardProxyKerberos, error) {
+	if c.ForwardProxy.HTTP.Auth != "negotiate" {
+		return nil, nil
+	}
+	conf := c.ForwardProxy.HTTP.Kerberos
+	krb5conf, err := krb5config.Load(conf.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos config %s cannot be loaded: %v", conf.ConfigFile, err)
+	}
+	spn := conf.ServicePrincipal
+	if spn == "" {
+		host, _, err := net.SplitHostPort(forwardProxy.Address)
+		if err != nil {
+			return nil, err
+		}
+		spn = "HTTP/" + host
+	}
+	// the Active Directory KDCs do not support FAST
+	settings := client.DisablePAFXFAST(true)
+	username, realm := kerberosPrincipal(forwardProxy.Username, conf.Realm, krb5conf)
+	var cl *client.Client
+	switch {
+	case conf.CCacheFile != "":
+		ccache, err := credentials.LoadCCache(conf.CCacheFile)
+		if err != nil {
+			return nil, fmt.Errorf("kerberos credentials cache %s cannot be loaded: %v", conf.CCacheFile, err)
+		}
+		if cl, err = client.NewFromCCache(ccache, krb5conf, settings); err != nil {
+			return nil, fmt.Errorf("kerberos credentials cache %s: %v", conf.CCacheFile, err)
+		}
+	case conf.KeytabFile != "":
+		if username == "" {
+			return nil, errors.New("kerberos keytab requires the user of the forward proxy URL")
+		}
+		kt, err := keytab.Load(conf.KeytabFile)
+		if err != nil {
+			return nil, fmt.Errorf("kerberos keytab %s cannot be loaded: %v", conf.KeytabFile, err)
+		}
+		cl = client.NewWithKeytab(username, realm, kt, krb5conf, settings)
+	case username != "" && forwardProxy.Password.Value() != "":
+		cl = client.NewWithPassword(username, realm, forwardProxy.Password.Value(), krb5conf, settings)
+	default:
+		return nil, errors.New("negotiate authentication requires the Kerberos credentials cache, the keytab or the credentials of the forward proxy URL")
+	}
+	return &forwardProxyKerberos{client: cl, spn: spn}, nil
+}
+
+// kerberosPrincipal returns the user name and the realm of the URL user given as user@REALM, DOMAIN\user or user
+func kerberosPrincipal(user string, realm string, krb5conf *krb5config.Config) (string, string) {
+	if i := strings.LastIndex(user, "@"); i >= 0 {
+		return user[:i], user[i+1:]
+	}
+	if i := strings.Index(user, `\`); i >= 0 {
+		// the NetBIOS domain of NTLM is not the realm
+		user = user[i+1:]
+	}
+	if realm == "" {
+		realm = krb5conf.LibDefaults.DefaultRealm
+	}
+	return user, realm
+}
+
+// token returns the SPNEGO token with the Kerberos service ticket of the proxy, the TGT and the service ticket are cached by the client
+func (k *forwardProxyKerberos) token() (string, error) {
+	s := spnego.SPNEGOClient(k.client, k.spn)
+	if err := s.AcquireCred(); err != nil {
+		return "", err
+	}
+	contextToken, err := s.InitSecContext()
+	if err != nil {
+		return "", err
+	}
+	b, err := contextToken.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
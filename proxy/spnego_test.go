@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the KDC is not reachable, the tickets come from the credentials cache
+const testKrb5Conf = `[libdefaults]
+  default_realm = CORP.LOCAL
+  dns_lookup_kdc = false
+[realms]
+  CORP.LOCAL = {
+    kdc = 127.0.0.1:1
+  }
+`
+
+func appendTestCCachePrincipal(buf *bytes.Buffer, nameType int32, realm string, components ...string) {
+	binary.Write(buf, binary.BigEndian, nameType)
+	binary.Write(buf, binary.BigEndian, int32(len(components)))
+	for _, s := range append([]string{realm}, components...) {
+		binary.Write(buf, binary.BigEndian, int32(len(s)))
+		buf.WriteString(s)
+	}
+}
+
+func appendTestCCacheCredential(a *assert.Assertions, buf *bytes.Buffer, server types.PrincipalName) {
+	appendTestCCachePrincipal(buf, nametype.KRB_NT_PRINCIPAL, "CORP.LOCAL", "alice")
+	appendTestCCachePrincipal(buf, server.NameType, "CORP.LOCAL", server.NameString...)
+	// aes256-cts-hmac-sha1-96 session key
+	binary.Write(buf, binary.BigEndian, int16(18))
+	binary.Write(buf, binary.BigEndian, int32(32))
+	buf.Write(make([]byte, 32))
+	now := time.Now()
+	for _, t := range []time.Time{now, now, now.Add(time.Hour), now.Add(time.Hour)} {
+		binary.Write(buf, binary.BigEndian, int32(t.Unix()))
+	}
+	// is_skey, flags, addresses, authdata
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	ticket, err := (&messages.Ticket{
+		TktVNO:  5,
+		Realm:   "CORP.LOCAL",
+		SName:   server,
+		EncPart: types.EncryptedData{EType: 18, KVNO: 1, Cipher: []byte("encrypted with the key of the service")},
+	}).Marshal()
+	a.Nil(err)
+	binary.Write(buf, binary.BigEndian, int32(len(ticket)))
+	buf.Write(ticket)
+	// second ticket
+	binary.Write(buf, binary.BigEndian, int32(0))
+}
+
+// testKerberosFiles writes the krb5.conf and the credentials cache of alice with the TGT and the service ticket of the proxy
+func testKerberosFiles(a *assert.Assertions, dir string) (string, string) {
+	krb5conf := filepath.Join(dir, "krb5.conf")
+	a.Nil(ioutil.WriteFile(krb5conf, []byte(testKrb5Conf), 0600))
+
+	buf := &bytes.Buffer{}
+	// version 4 without the header fields
+	buf.Write([]byte{0x05, 0x04, 0x00, 0x00})
+	appendTestCCachePrincipal(buf, nametype.KRB_NT_PRINCIPAL, "CORP.LOCAL", "alice")
+	appendTestCCacheCredential(a, buf, types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/CORP.LOCAL"))
+	appendTestCCacheCredential(a, buf, types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/proxy.corp.local"))
+	ccache := filepath.Join(dir, "ccache")
+	a.Nil(ioutil.WriteFile(ccache, buf.Bytes(), 0600))
+	return krb5conf, ccache
+}
+
+// testNegotiateTicket returns the service principal of the Kerberos ticket in the SPNEGO token of the CONNECT request
+func testNegotiateTicket(req *http.Request) string {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Header.Get("Proxy-Authorization"), "Negotiate "))
+	if err != nil {
+		return ""
+	}
+	var token spnego.SPNEGOToken
+	if err = token.Unmarshal(b); err != nil || !token.Init {
+		return ""
+	}
+	var krb5Token spnego.KRB5Token
+	if err = krb5Token.Unmarshal(token.NegTokenInit.MechTokenBytes); err != nil {
+		return ""
+	}
+	return krb5Token.APReq.Ticket.SName.PrincipalNameString() + "@" + krb5Token.APReq.Ticket.Realm
+}
+
+func TestNewForwardProxyKerberos(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kerberos")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	krb5conf, ccache := testKerberosFiles(a, dir)
+
+	c := new(config.Config)
+	c.ForwardProxy.HTTP.Auth = "basic"
+	kerberos, err := newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: "proxy.corp.local:3128"})
+	a.Nil(err)
+	a.Nil(kerberos)
+
+	c.ForwardProxy.HTTP.Auth = "negotiate"
+	c.ForwardProxy.HTTP.Kerberos.ConfigFile = krb5conf
+	_, err = newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: "proxy.corp.local:3128"})
+	a.EqualError(err, "negotiate authentication requires the Kerberos credentials cache, the keytab or the credentials of the forward proxy URL")
+
+	kerberos, err = newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: "proxy.corp.local:3128", Username: `CORP\alice`, Password: "secret"})
+	a.Nil(err)
+	a.Equal("HTTP/proxy.corp.local", kerberos.spn)
+	a.Equal("alice", kerberos.client.Credentials.UserName())
+	a.Equal("CORP.LOCAL", kerberos.client.Credentials.Domain())
+
+	c.ForwardProxy.HTTP.Kerberos.CCacheFile = ccache
+	kerberos, err = newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: "127.0.0.1:3128"})
+	a.Nil(err)
+	a.Equal("HTTP/127.0.0.1", kerberos.spn)
+
+	user, realm := kerberosPrincipal("bob@EXAMPLE.COM", "", nil)
+	a.Equal("bob", user)
+	a.Equal("EXAMPLE.COM", realm)
+}
+
+func TestHttpProxyNegotiate(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kerberos")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	krb5conf, ccache := testKerberosFiles(a, dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	tickets := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			tickets <- ""
+			return
+		}
+		tickets <- testNegotiateTicket(req)
+		testEchoTunnel(conn, br)
+	}()
+
+	c := new(config.Config)
+	c.ForwardProxy.HTTP.Auth = "negotiate"
+	c.ForwardProxy.HTTP.Kerberos.ConfigFile = krb5conf
+	c.ForwardProxy.HTTP.Kerberos.CCacheFile = ccache
+	c.ForwardProxy.HTTP.Kerberos.ServicePrincipal = "HTTP/proxy.corp.local"
+	kerberos, err := newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: ln.Addr().String()})
+	a.Nil(err)
+
+	dialer := &httpProxy{
+		forwardDialer: directDialer{dialTimeout: 3 * time.Second},
+		network:       "tcp",
+		hostPort:      ln.Addr().String(),
+		auth:          "negotiate",
+		kerberos:      kerberos,
+	}
+	conn, err := dialer.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	a.Equal("HTTP/proxy.corp.local@CORP.LOCAL", <-tickets)
+	testPingTunnel(a, conn)
+}
+
+func TestHttpProxyNegotiateFallbackNTLM(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kerberos")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	krb5conf, ccache := testKerberosFiles(a, dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	tickets := make(chan string, 1)
+	authorized := make(chan bool, 2)
+	go func() {
+		// the service ticket is rejected, NTLM is authenticated on the next connection
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			tickets <- ""
+		} else {
+			tickets <- testNegotiateTicket(req)
+		}
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Negotiate\r\nProxy-Authenticate: NTLM\r\nContent-Length: 0\r\n\r\n"))
+		conn.Close()
+		for {
+			conn, err = ln.Accept()
+			if err != nil {
+				return
+			}
+			authorized <- testNTLMProxy(conn, serverChallenge)
+			conn.Close()
+		}
+	}()
+
+	c := new(config.Config)
+	c.ForwardProxy.HTTP.Auth = "negotiate"
+	c.ForwardProxy.HTTP.Kerberos.ConfigFile = krb5conf
+	c.ForwardProxy.HTTP.Kerberos.CCacheFile = ccache
+	c.ForwardProxy.HTTP.Kerberos.ServicePrincipal = "HTTP/proxy.corp.local"
+	kerberos, err := newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: ln.Addr().String()})
+	a.Nil(err)
+
+	dialer := &httpProxy{
+		forwardDialer: directDialer{dialTimeout: 3 * time.Second},
+		network:       "tcp",
+		hostPort:      ln.Addr().String(),
+		username:      `CORP\alice`,
+		password:      "secret",
+		auth:          "negotiate",
+		kerberos:      kerberos,
+	}
+	conn, err := dialer.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	a.Equal("HTTP/proxy.corp.local@CORP.LOCAL", <-tickets)
+	// the proxy authorizes the client after the tunnel is closed
+	testPingTunnel(a, conn)
+	a.True(<-authorized)
+
+	// the ticket of the unreachable KDC cannot be obtained
+	c.ForwardProxy.HTTP.Kerberos.CCacheFile = ""
+	kerberos, err = newForwardProxyKerberos(c, config.ForwardProxyConfig{Address: ln.Addr().String(), Username: `CORP\alice`, Password: "secret"})
+	a.Nil(err)
+	dialer.kerberos = kerberos
+	conn, err = dialer.Dial("tcp", "kafka-0:9092")
+	a.Nil(err)
+	// the proxy authorizes the client after the tunnel is closed
+	testPingTunnel(a, conn)
+	a.True(<-authorized)
+}
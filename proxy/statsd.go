@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdMaxPacketSize keeps the UDP packets below the common network MTU
+const statsdMaxPacketSize = 1432
+
+var (
+	statsdNameReplacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+	statsdTagReplacer  = strings.NewReplacer("|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+)
+
+// StatsdExporter pushes the metrics of the gatherer to a StatsD or DogStatsD endpoint.
+// The counters are sent as the increments since the previous push, the histograms and summaries as the counters of their count and sum.
+type StatsdExporter struct {
+	gatherer  prometheus.Gatherer
+	conn      net.Conn
+	dogstatsd bool
+	prefix    string
+	tags      []string
+	interval  time.Duration
+
+	// the last pushed values of the counters
+	counters map[string]float64
+
+	stopPush chan struct{}
+	stopOnce sync.Once
+}
+
+func NewStatsdExporter(gatherer prometheus.Gatherer, address string, dogstatsd bool, prefix string, tags []string, interval time.Duration) (*StatsdExporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to statsd %s", address)
+	}
+	logger.Infof("Metrics are pushed to statsd %s every %v", address, interval)
+	return &StatsdExporter{
+		gatherer:  gatherer,
+		conn:      conn,
+		dogstatsd: dogstatsd,
+		prefix:    prefix,
+		tags:      tags,
+		interval:  interval,
+		counters:  make(map[string]float64),
+		stopPush:  make(chan struct{}),
+	}, nil
+}
+
+// Run pushes the metrics until the exporter is closed, the metrics are pushed for the last time on close
+func (e *StatsdExporter) Run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	defer e.conn.Close()
+	for {
+		select {
+		case <-ticker.C:
+			e.logPush()
+		case <-e.stopPush:
+			e.logPush()
+			return
+		}
+	}
+}
+
+func (e *StatsdExporter) Close() {
+	e.stopOnce.Do(func() {
+		close(e.stopPush)
+	})
+}
+
+func (e *StatsdExporter) logPush() {
+	if err := e.push(); err != nil {
+		logger.Warnf("Pushing metrics to statsd failed: %v", err)
+	}
+}
+
+func (e *StatsdExporter) push() error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// the gathered metrics are pushed despite of the error
+		logger.Warnf("Gathering of the metrics had error: %v", err)
+	}
+	w := &statsdWriter{conn: e.conn}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name, tags := e.series(family.GetName(), m.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				e.counter(w, name, tags, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				w.write(name, m.GetGauge().GetValue(), "g", tags)
+			case dto.MetricType_UNTYPED:
+				w.write(name, m.GetUntyped().GetValue(), "g", tags)
+			case dto.MetricType_HISTOGRAM:
+				e.counter(w, name+".count", tags, float64(m.GetHistogram().GetSampleCount()))
+				e.counter(w, name+".sum", tags, m.GetHistogram().GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				e.counter(w, name+".count", tags, float64(m.GetSummary().GetSampleCount()))
+				e.counter(w, name+".sum", tags, m.GetSummary().GetSampleSum())
+			}
+		}
+	}
+	return w.flush()
+}
+
+// counter writes the increment of the counter since the previous push
+func (e *StatsdExporter) counter(w *statsdWriter, name string, tags string, value float64) {
+	key := name + tags
+	delta := value - e.counters[key]
+	if delta < 0 {
+		// counter was reset
+		delta = value
+	}
+	e.counters[key] = value
+	if delta != 0 {
+		w.write(name, delta, "c", tags)
+	}
+}
+
+// series returns the metric name and the tags suffix. The label names and values are appended to the name of the StatsD metric and
+// sent as tags to DogStatsD
+func (e *StatsdExporter) series(name string, labels []*dto.LabelPair) (string, string) {
+	name = e.prefix + name
+	if !e.dogstatsd {
+		for _, label := range labels {
+			if label.GetValue() != "" {
+				name += "." + label.GetName() + "." + statsdNameReplacer.Replace(label.GetValue())
+			}
+		}
+		return name, ""
+	}
+	tags := make([]string, 0, len(e.tags)+len(labels))
+	for _, tag := range e.tags {
+		tags = append(tags, statsdTagReplacer.Replace(tag))
+	}
+	for _, label := range labels {
+		tags = append(tags, label.GetName()+":"+statsdTagReplacer.Replace(label.GetValue()))
+	}
+	if len(tags) == 0 {
+		return name, ""
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// statsdWriter sends the lines in packets of at most statsdMaxPacketSize bytes
+type statsdWriter struct {
+	conn net.Conn
+	buf  []byte
+	err  error
+}
+
+func (w *statsdWriter) write(name string, value float64, metricType string, tags string) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType + tags
+	if len(w.buf) != 0 && len(w.buf)+1+len(line) > statsdMaxPacketSize {
+		w.flush()
+	}
+	if len(w.buf) != 0 {
+		w.buf = append(w.buf, '\n')
+	}
+	w.buf = append(w.buf, line...)
+}
+
+func (w *statsdWriter) flush() error {
+	if len(w.buf) != 0 {
+		if _, err := w.conn.Write(w.buf); err != nil && w.err == nil {
+			w.err = err
+		}
+		w.buf = w.buf[:0]
+	}
+	return w.err
+}
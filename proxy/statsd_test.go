@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStatsdRegistry(a *assert.Assertions) (*prometheus.Registry, *prometheus.CounterVec, prometheus.Gauge, prometheus.Histogram) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"broker", "api_key"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "open_connections", Help: "Connections"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency"})
+	a.Nil(registry.Register(counter))
+	a.Nil(registry.Register(gauge))
+	a.Nil(registry.Register(histogram))
+	return registry, counter, gauge, histogram
+}
+
+func testStatsdLines(a *assert.Assertions, conn net.PacketConn) []string {
+	buf := make([]byte, 65536)
+	a.Nil(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	a.Nil(err)
+	lines := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatsdExporter(t *testing.T) {
+	a := assert.New(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.Nil(err)
+	defer server.Close()
+
+	registry, counter, gauge, histogram := testStatsdRegistry(a)
+	exporter, err := NewStatsdExporter(registry, server.LocalAddr().String(), false, "kafka_proxy.", nil, time.Minute)
+	a.Nil(err)
+	defer exporter.conn.Close()
+
+	counter.WithLabelValues("kafka-0:9092", "3").Add(5)
+	counter.WithLabelValues("kafka-1.example.com:9092", "0").Inc()
+	gauge.Set(2)
+	histogram.Observe(0.5)
+	a.Nil(exporter.push())
+	a.Equal([]string{
+		"kafka_proxy.latency_seconds.count:1|c",
+		"kafka_proxy.latency_seconds.sum:0.5|c",
+		"kafka_proxy.open_connections:2|g",
+		"kafka_proxy.requests_total.api_key.0.broker.kafka-1_example_com_9092:1|c",
+		"kafka_proxy.requests_total.api_key.3.broker.kafka-0_9092:5|c",
+	}, testStatsdLines(a, server))
+
+	// the increments since the previous push
+	counter.WithLabelValues("kafka-0:9092", "3").Add(2)
+	a.Nil(exporter.push())
+	a.Equal([]string{
+		"kafka_proxy.open_connections:2|g",
+		"kafka_proxy.requests_total.api_key.3.broker.kafka-0_9092:2|c",
+	}, testStatsdLines(a, server))
+}
+
+func TestDogStatsdExporter(t *testing.T) {
+	a := assert.New(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.Nil(err)
+	defer server.Close()
+
+	registry, counter, gauge, _ := testStatsdRegistry(a)
+	exporter, err := NewStatsdExporter(registry, server.LocalAddr().String(), true, "", []string{"env:prod"}, time.Minute)
+	a.Nil(err)
+	defer exporter.conn.Close()
+
+	counter.WithLabelValues("kafka-0:9092", "3").Add(5)
+	gauge.Set(2)
+	a.Nil(exporter.push())
+	a.Equal([]string{
+		"open_connections:2|g|#env:prod",
+		"requests_total:5|c|#env:prod,api_key:3,broker:kafka-0:9092",
+	}, testStatsdLines(a, server))
+}
+
+func TestStatsdWriterPackets(t *testing.T) {
+	a := assert.New(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.Nil(err)
+	defer server.Close()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	a.Nil(err)
+	defer conn.Close()
+
+	w := &statsdWriter{conn: conn}
+	name := strings.Repeat("m", 100)
+	for i := 0; i < 20; i++ {
+		w.write(name, float64(i), "g", "")
+	}
+	a.Nil(w.flush())
+
+	lines := 0
+	for lines < 20 {
+		buf := make([]byte, 65536)
+		a.Nil(server.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		a.Nil(err)
+		a.True(n <= statsdMaxPacketSize)
+		lines += len(strings.Split(string(buf[:n]), "\n"))
+	}
+	a.Equal(20, lines)
+}
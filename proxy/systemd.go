@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const systemdListenFdsStart = 3
+
+// SocketActivation provides the listening sockets passed by systemd socket activation (sd_listen_fds). The socket
+// is used by the listener whose address has the port of the socket and the same or the unspecified IP.
+type SocketActivation struct {
+	lock      sync.Mutex
+	listeners []*net.TCPListener
+}
+
+// NewSocketActivation takes the sockets passed by systemd to the process
+func NewSocketActivation() (*SocketActivation, error) {
+	defer func() {
+		// the sockets are not passed to the processes started later
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets were passed by systemd socket activation, LISTEN_PID is not the proxy process")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, errors.Errorf("no sockets were passed by systemd socket activation, LISTEN_FDS is '%s'", os.Getenv("LISTEN_FDS"))
+	}
+	files := make([]*os.File, 0, count)
+	for fd := systemdListenFdsStart; fd < systemdListenFdsStart+count; fd++ {
+		files = append(files, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+	}
+	return newSocketActivation(files)
+}
+
+func newSocketActivation(files []*os.File) (*SocketActivation, error) {
+	s := &SocketActivation{}
+	for _, file := range files {
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "systemd socket %s", file.Name())
+		}
+		tcpListener, ok := l.(*net.TCPListener)
+		if !ok {
+			l.Close()
+			return nil, errors.Errorf("systemd socket %s is not a TCP listening socket", file.Name())
+		}
+		logger.Infof("Received socket %s from systemd", tcpListener.Addr())
+		s.listeners = append(s.listeners, tcpListener)
+	}
+	return s, nil
+}
+
+// Listen returns the socket passed by systemd for the address or announces on the address when there is none
+func (s *SocketActivation) Listen(address string, reusePort bool, shared bool) (net.Listener, error) {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", address); err == nil && tcpAddr.Port != 0 {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, l := range s.listeners {
+			if activatedAddressMatches(tcpAddr, l.Addr().(*net.TCPAddr)) {
+				s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+				logger.Infof("Listener %s uses the systemd socket %s", address, l.Addr())
+				return l, nil
+			}
+		}
+	}
+	return Listen(address, reusePort)
+}
+
+func activatedAddressMatches(address *net.TCPAddr, activated *net.TCPAddr) bool {
+	if address.Port != activated.Port {
+		return false
+	}
+	return address.IP == nil || address.IP.IsUnspecified() || activated.IP.IsUnspecified() || address.IP.Equal(activated.IP)
+}
+
+// SystemdNotify sends the service state e.g. READY=1 to systemd (sd_notify), it does nothing when NOTIFY_SOCKET is not set
+func SystemdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "systemd notify socket")
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// SystemdWatchdogInterval returns the interval of the watchdog keepalives (half of WatchdogSec),
+// it is 0 when the systemd watchdog is not enabled for the process
+func SystemdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
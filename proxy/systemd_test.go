@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSocketActivation(t *testing.T) {
+	a := assert.New(t)
+
+	activated, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	port := activated.Addr().(*net.TCPAddr).Port
+	file, err := activated.(*net.TCPListener).File()
+	a.Nil(err)
+	activated.Close()
+
+	s, err := newSocketActivation([]*os.File{file})
+	a.Nil(err)
+
+	// the listener on all interfaces uses the systemd socket
+	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(port))
+	l, err := s.Listen(address, false, false)
+	a.Nil(err)
+	defer l.Close()
+	a.Equal(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), l.Addr().String())
+
+	// the socket is used once, the other listeners are opened by the proxy
+	_, err = s.Listen(address, false, false)
+	a.NotNil(err)
+	dynamic, err := s.Listen("127.0.0.1:0", false, false)
+	a.Nil(err)
+	dynamic.Close()
+
+	a.True(activatedAddressMatches(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9092}, &net.TCPAddr{IP: net.IPv6unspecified, Port: 9092}))
+	a.True(activatedAddressMatches(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9092}, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9092}))
+	a.False(activatedAddressMatches(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9092}, &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 9092}))
+	a.False(activatedAddressMatches(&net.TCPAddr{Port: 9092}, &net.TCPAddr{Port: 9093}))
+}
+
+func TestSystemdNotify(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(SystemdNotify("READY=1"))
+
+	dir, err := ioutil.TempDir("", "systemd-notify")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	a.Nil(err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	a.Nil(SystemdNotify("READY=1"))
+	buf := make([]byte, 64)
+	a.Nil(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	a.Nil(err)
+	a.Equal("READY=1", string(buf[:n]))
+
+	a.Equal(time.Duration(0), SystemdWatchdogInterval())
+	os.Setenv("WATCHDOG_USEC", "2000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	a.Equal(time.Second, SystemdWatchdogInterval())
+	os.Setenv("WATCHDOG_PID", "1")
+	defer os.Unsetenv("WATCHDOG_PID")
+	a.Equal(time.Duration(0), SystemdWatchdogInterval())
+}
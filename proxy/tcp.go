@@ -6,9 +6,12 @@ import (
 )
 
 type TCPConnOptions struct {
-	KeepAlive       time.Duration
-	ReadBufferSize  int
-	WriteBufferSize int
+	KeepAlive         time.Duration
+	KeepAliveInterval time.Duration // between the unacknowledged keep-alive probes, 0 - system default
+	KeepAliveCount    int           // unacknowledged keep-alive probes before the connection is dropped, 0 - system default
+	NoDelay           bool          // TCP_NODELAY, false enables the Nagle's algorithm
+	ReadBufferSize    int
+	WriteBufferSize   int
 }
 
 func (opts TCPConnOptions) setTCPConnOptions(tcpConn *net.TCPConn) error {
@@ -19,6 +22,14 @@ func (opts TCPConnOptions) setTCPConnOptions(tcpConn *net.TCPConn) error {
 		if err := tcpConn.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
 			return err
 		}
+		if opts.KeepAliveInterval > 0 || opts.KeepAliveCount > 0 {
+			if err := setKeepAliveProbes(tcpConn, opts.KeepAliveInterval, opts.KeepAliveCount); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tcpConn.SetNoDelay(opts.NoDelay); err != nil {
+		return err
 	}
 	if opts.ReadBufferSize > 0 {
 		if err := tcpConn.SetReadBuffer(opts.ReadBufferSize); err != nil {
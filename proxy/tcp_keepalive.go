@@ -0,0 +1,33 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd
+// +build linux darwin dragonfly freebsd netbsd
+
+package proxy
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"time"
+)
+
+// setKeepAliveProbes sets TCP_KEEPINTVL and TCP_KEEPCNT, the values which are 0 keep the system defaults
+func setKeepAliveProbes(tcpConn *net.TCPConn, interval time.Duration, count int) error {
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if controlErr := rawConn.Control(func(fd uintptr) {
+		if interval > 0 {
+			// whole seconds, at least one
+			secs := int((interval + time.Second - 1) / time.Second)
+			if err = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs); err != nil {
+				return
+			}
+		}
+		if count > 0 {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count)
+		}
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
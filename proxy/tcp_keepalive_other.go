@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+)
+
+func setKeepAliveProbes(tcpConn *net.TCPConn, interval time.Duration, count int) error {
+	return fmt.Errorf("keep-alive probe interval and count are not supported on %s", runtime.GOOS)
+}
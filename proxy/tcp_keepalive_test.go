@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetTCPConnOptions(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	tcpConn := conn.(*net.TCPConn)
+
+	opts := TCPConnOptions{KeepAlive: 30 * time.Second, KeepAliveInterval: 1500 * time.Millisecond, KeepAliveCount: 3, NoDelay: false}
+	a.Nil(opts.setTCPConnOptions(tcpConn))
+
+	sockopt := func(level, name int) int {
+		rawConn, err := tcpConn.SyscallConn()
+		a.Nil(err)
+		var value int
+		a.Nil(rawConn.Control(func(fd uintptr) {
+			value, err = unix.GetsockoptInt(int(fd), level, name)
+		}))
+		a.Nil(err)
+		return value
+	}
+	a.Equal(1, sockopt(unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+	a.Equal(30, sockopt(unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+	a.Equal(2, sockopt(unix.IPPROTO_TCP, unix.TCP_KEEPINTVL))
+	a.Equal(3, sockopt(unix.IPPROTO_TCP, unix.TCP_KEEPCNT))
+	a.Equal(0, sockopt(unix.IPPROTO_TCP, unix.TCP_NODELAY))
+
+	opts.NoDelay = true
+	a.Nil(opts.setTCPConnOptions(tcpConn))
+	a.Equal(1, sockopt(unix.IPPROTO_TCP, unix.TCP_NODELAY))
+}
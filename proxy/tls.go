@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"strings"
@@ -48,46 +52,57 @@ var (
 		"ECDHE-RSA-3DES-EDE-CBC-SHA":         tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
 		"RSA-3DES-EDE-CBC-SHA":               tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
 	}
+
+	supportedVersionsMap = map[string]uint16{
+		"TLS1.0": tls.VersionTLS10,
+		"TLS1.1": tls.VersionTLS11,
+		"TLS1.2": tls.VersionTLS12,
+		"TLS1.3": tls.VersionTLS13,
+	}
 )
 
-func newTLSListenerConfig(conf *config.Config) (*tls.Config, error) {
+// newTLSListenerConfig returns the listener config, the private key of the certificate is held by the key signer when it is not nil
+func newTLSListenerConfig(conf *config.Config, keySigner apis.KeySigner) (*tls.Config, error) {
 	opts := conf.Proxy.TLS
 
-	if opts.ListenerKeyFile == "" || opts.ListenerCertFile == "" {
-		return nil, errors.New("Listener key and cert files must not be empty")
-	}
-	certPEMBlock, err := ioutil.ReadFile(opts.ListenerCertFile)
-	if err != nil {
-		return nil, err
-	}
-	keyPEMBlock, err := ioutil.ReadFile(opts.ListenerKeyFile)
-	if err != nil {
-		return nil, err
-	}
-	keyPEMBlock, err = decryptPEM(keyPEMBlock, opts.ListenerKeyPassword)
-	if err != nil {
-		return nil, err
-	}
-	cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+	cipherSuites, err := getCipherSuites(opts.ListenerCipherSuites)
 	if err != nil {
 		return nil, err
 	}
-	cipherSuites, err := getCipherSuites(opts.ListenerCipherSuites)
+	curvePreferences, err := getCurvePreferences(opts.ListenerCurvePreferences)
 	if err != nil {
 		return nil, err
 	}
-	curvePreferences, err := getCurvePreferences(opts.ListenerCurvePreferences)
+	minVersion, maxVersion, err := getTLSVersions(opts.ListenerMinVersion, opts.ListenerMaxVersion, tls.VersionTLS12)
 	if err != nil {
 		return nil, err
 	}
 
 	cfg := &tls.Config{
-		Certificates:             []tls.Certificate{cert},
 		ClientAuth:               tls.NoClientCert,
 		PreferServerCipherSuites: true,
-		MinVersion:               tls.VersionTLS12,
+		MinVersion:               minVersion,
+		MaxVersion:               maxVersion,
 		CurvePreferences:         curvePreferences,
 		CipherSuites:             cipherSuites,
+		SessionTicketsDisabled:   opts.ListenerSessionTicketsDisable,
+	}
+	if opts.ListenerALPN.Enable {
+		cfg.NextProtos = append(append([]string{}, alpnHTTPProtocols...), opts.ListenerALPN.KafkaProtocols...)
+	}
+	if opts.ListenerVaultPKI.Path != "" {
+		// the certificate is issued again before it expires
+		cert, err := newVaultCertificate(conf, opts.ListenerVaultPKI)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = cert.getCertificate
+	} else {
+		cert, err := newTLSListenerCertificate(conf, keySigner)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
 	}
 	if opts.CAChainCertFile != "" {
 		caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
@@ -100,10 +115,45 @@ func newTLSListenerConfig(conf *config.Config) (*tls.Config, error) {
 		}
 		cfg.ClientCAs = clientCAs
 		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+		checker, err := newRevocationChecker(revocationSideListener, opts.ListenerCRLs, opts.ListenerOCSPEnable, conf)
+		if err != nil {
+			return nil, err
+		}
+		if checker != nil {
+			cfg.VerifyConnection = checker.verifyConnection
+		}
 	}
 	return cfg, nil
 }
 
+func newTLSListenerCertificate(conf *config.Config, keySigner apis.KeySigner) (tls.Certificate, error) {
+	opts := conf.Proxy.TLS
+
+	if keySigner != nil {
+		if opts.ListenerCertFile == "" {
+			return tls.Certificate{}, errors.New("Listener cert file must not be empty")
+		}
+		return newKeySignerCertificate(opts.ListenerCertFile, keySigner, opts.ListenerKeySigner.Timeout)
+	}
+	if opts.ListenerKeyFile == "" || opts.ListenerCertFile == "" {
+		return tls.Certificate{}, errors.New("Listener key and cert files must not be empty")
+	}
+	certPEMBlock, err := ioutil.ReadFile(opts.ListenerCertFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEMBlock, err := ioutil.ReadFile(opts.ListenerKeyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEMBlock, err = decryptPEM(keyPEMBlock, opts.ListenerKeyPassword.Value())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+}
+
 func getCipherSuites(enabledCipherSuites []string) ([]uint16, error) {
 	suites := make([]uint16, 0)
 	for _, suite := range enabledCipherSuites {
@@ -134,22 +184,156 @@ func getCurvePreferences(enabledCurvePreferences []string) ([]tls.CurveID, error
 	return curvePreferences, nil
 }
 
+// getTLSVersions returns the min and max version, the max version 0 is the highest supported version
+func getTLSVersions(minVersion, maxVersion string, defaultMinVersion uint16) (uint16, uint16, error) {
+	min, err := getTLSVersion(minVersion, defaultMinVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := getTLSVersion(maxVersion, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max != 0 && min > max {
+		return 0, 0, errors.Errorf("TLS min version '%s' is greater than max version '%s'", minVersion, maxVersion)
+	}
+	return min, max, nil
+}
+
+func getTLSVersion(version string, defaultVersion uint16) (uint16, error) {
+	if version == "" {
+		return defaultVersion, nil
+	}
+	v, ok := supportedVersionsMap[strings.TrimSpace(version)]
+	if !ok {
+		return 0, errors.Errorf("invalid TLS version '%s' selected", version)
+	}
+	return v, nil
+}
+
 func newTLSClientConfig(conf *config.Config) (*tls.Config, error) {
 	// https://blog.cloudflare.com/exposing-go-on-the-internet/
 	opts := conf.Kafka.TLS
 
-	cfg := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	cfg, err := newClientTLSConfig(opts.InsecureSkipVerify, opts.ClientCertFile, opts.ClientKeyFile, opts.ClientKeyPassword.Value(), opts.CAChainCertFile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ClientVaultPKI.Path != "" {
+		cert, err := newVaultCertificate(conf, opts.ClientVaultPKI)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = cert.getClientCertificate
+	}
+	// the Go defaults are used unless configured
+	if len(opts.CipherSuites) != 0 {
+		if cfg.CipherSuites, err = getCipherSuites(opts.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.CurvePreferences) != 0 {
+		if cfg.CurvePreferences, err = getCurvePreferences(opts.CurvePreferences); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MinVersion, cfg.MaxVersion, err = getTLSVersions(opts.MinVersion, opts.MaxVersion, 0); err != nil {
+		return nil, err
+	}
+	cfg.SessionTicketsDisabled = opts.SessionTicketsDisable
+	if len(opts.PinnedSPKIHashes) != 0 {
+		if cfg.VerifyPeerCertificate, err = newPinnedSPKIVerifier(opts.PinnedSPKIHashes); err != nil {
+			return nil, err
+		}
+	}
+	checker, err := newRevocationChecker(revocationSideBroker, opts.CRLs, opts.OCSPEnable, conf)
+	if err != nil {
+		return nil, err
+	}
+	if checker != nil {
+		cfg.VerifyConnection = checker.verifyConnection
+	}
+	return cfg, nil
+}
+
+// newPinnedSPKIVerifier returns the verification of the broker certificate chain against the base64 encoded SHA-256 hashes
+// of the subject public key info. A certificate of the verified chains must be pinned, when the CA verification is skipped
+// the presented chain must be signed up to a pinned certificate.
+func newPinnedSPKIVerifier(pins []string) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, errors.Errorf("invalid pinned SPKI hash '%s', a base64 encoded SHA-256 is expected", pin)
+		}
+		hashes = append(hashes, hash)
+	}
+	pinned := func(cert *x509.Certificate) bool {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, v := range hashes {
+			if bytes.Equal(v, hash[:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) != 0 {
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					if pinned(cert) {
+						return nil
+					}
+				}
+			}
+			return errors.New("none of the broker certificates matches the pinned SPKI hashes")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return errors.New("broker did not present a certificate")
+		}
+		roots := x509.NewCertPool()
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs {
+			if pinned(cert) {
+				roots.AddCert(cert)
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return errors.Wrap(err, "none of the broker certificates matches the pinned SPKI hashes")
+		}
+		return nil
+	}, nil
+}
 
-	if opts.ClientCertFile != "" && opts.ClientKeyFile != "" {
-		certPEMBlock, err := ioutil.ReadFile(opts.ClientCertFile)
+func newForwardProxyTLSConfig(conf *config.Config) (*tls.Config, error) {
+	opts := conf.ForwardProxy.TLS
+
+	return newClientTLSConfig(opts.InsecureSkipVerify, opts.ClientCertFile, opts.ClientKeyFile, opts.ClientKeyPassword.Value(), opts.CAChainCertFile)
+}
+
+func newClientTLSConfig(insecureSkipVerify bool, clientCertFile, clientKeyFile, clientKeyPassword, caChainCertFile string) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if clientCertFile != "" && clientKeyFile != "" {
+		certPEMBlock, err := ioutil.ReadFile(clientCertFile)
 		if err != nil {
 			return nil, err
 		}
-		keyPEMBlock, err := ioutil.ReadFile(opts.ClientKeyFile)
+		keyPEMBlock, err := ioutil.ReadFile(clientKeyFile)
 		if err != nil {
 			return nil, err
 		}
-		keyPEMBlock, err = decryptPEM(keyPEMBlock, opts.ClientKeyPassword)
+		keyPEMBlock, err = decryptPEM(keyPEMBlock, clientKeyPassword)
 		if err != nil {
 			return nil, err
 		}
@@ -161,8 +345,8 @@ func newTLSClientConfig(conf *config.Config) (*tls.Config, error) {
 		cfg.BuildNameToCertificate()
 	}
 
-	if opts.CAChainCertFile != "" {
-		caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+	if caChainCertFile != "" {
+		caCertPEMBlock, err := ioutil.ReadFile(caChainCertFile)
 		if err != nil {
 			return nil, err
 		}
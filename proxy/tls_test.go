@@ -2,12 +2,17 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"github.com/armon/go-socks5"
 	"github.com/grepplabs/kafka-proxy/config"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"strings"
@@ -25,7 +30,7 @@ func TestDefaultCipherSuites(t *testing.T) {
 	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
 	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
 
-	serverConfig, err := newTLSListenerConfig(c)
+	serverConfig, err := newTLSListenerConfig(c, nil)
 	a.Nil(err)
 	a.Equal(len(defaultCipherSuites), len(serverConfig.CipherSuites))
 	a.Equal(len(defaultCurvePreferences), len(serverConfig.CurvePreferences))
@@ -43,12 +48,150 @@ func TestEnabledCipherSuites(t *testing.T) {
 	c.Proxy.TLS.ListenerCipherSuites = []string{"ECDHE-ECDSA-AES256-GCM-SHA384", "ECDHE-RSA-AES256-GCM-SHA384"}
 	c.Proxy.TLS.ListenerCurvePreferences = []string{"P521"}
 
-	serverConfig, err := newTLSListenerConfig(c)
+	serverConfig, err := newTLSListenerConfig(c, nil)
 	a.Nil(err)
 	a.Equal(2, len(serverConfig.CipherSuites))
 	a.Equal(1, len(serverConfig.CurvePreferences))
 }
 
+func TestTLSVersions(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+
+	serverConfig, err := newTLSListenerConfig(c, nil)
+	a.Nil(err)
+	a.Equal(uint16(tls.VersionTLS12), serverConfig.MinVersion)
+	a.Equal(uint16(0), serverConfig.MaxVersion)
+	a.False(serverConfig.SessionTicketsDisabled)
+
+	c.Proxy.TLS.ListenerMinVersion = "TLS1.3"
+	c.Proxy.TLS.ListenerSessionTicketsDisable = true
+	serverConfig, err = newTLSListenerConfig(c, nil)
+	a.Nil(err)
+	a.Equal(uint16(tls.VersionTLS13), serverConfig.MinVersion)
+	a.True(serverConfig.SessionTicketsDisabled)
+
+	c.Proxy.TLS.ListenerMaxVersion = "TLS1.2"
+	_, err = newTLSListenerConfig(c, nil)
+	a.EqualError(err, "TLS min version 'TLS1.3' is greater than max version 'TLS1.2'")
+	c.Proxy.TLS.ListenerMaxVersion = "SSL3.0"
+	_, err = newTLSListenerConfig(c, nil)
+	a.EqualError(err, "invalid TLS version 'SSL3.0' selected")
+}
+
+func TestTLSClientOptions(t *testing.T) {
+	a := assert.New(t)
+
+	c := new(config.Config)
+	clientConfig, err := newTLSClientConfig(c)
+	a.Nil(err)
+	a.Nil(clientConfig.CipherSuites)
+	a.Nil(clientConfig.CurvePreferences)
+	a.Equal(uint16(0), clientConfig.MinVersion)
+
+	c.Kafka.TLS.CipherSuites = []string{"ECDHE-RSA-AES256-GCM-SHA384"}
+	c.Kafka.TLS.CurvePreferences = []string{"X25519", "P256"}
+	c.Kafka.TLS.MinVersion = "TLS1.2"
+	c.Kafka.TLS.MaxVersion = "TLS1.2"
+	c.Kafka.TLS.SessionTicketsDisable = true
+	clientConfig, err = newTLSClientConfig(c)
+	a.Nil(err)
+	a.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, clientConfig.CipherSuites)
+	a.Equal([]tls.CurveID{tls.X25519, tls.CurveP256}, clientConfig.CurvePreferences)
+	a.Equal(uint16(tls.VersionTLS12), clientConfig.MinVersion)
+	a.Equal(uint16(tls.VersionTLS12), clientConfig.MaxVersion)
+	a.True(clientConfig.SessionTicketsDisabled)
+
+	c.Kafka.TLS.CipherSuites = []string{"RC4"}
+	_, err = newTLSClientConfig(c)
+	a.EqualError(err, "invalid cipher suite 'RC4' selected")
+}
+
+func TestTLS13OnlyListener(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Proxy.TLS.ListenerMinVersion = "TLS1.3"
+	c.Kafka.TLS.CAChainCertFile = bundle.ServerCert.Name()
+
+	c1, c2, stop, err := makeTLSPipe(c)
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	pingPong(t, c1, c2)
+	stop()
+
+	c.Kafka.TLS.MaxVersion = "TLS1.2"
+	_, _, _, err = makeTLSPipe(c)
+	a.NotNil(err)
+}
+
+func testSPKIHash(a *assert.Assertions, certFile string) string {
+	data, err := ioutil.ReadFile(certFile)
+	a.Nil(err)
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	a.Nil(err)
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestTLSPinnedSPKIHashes(t *testing.T) {
+	a := assert.New(t)
+
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	c := new(config.Config)
+	c.Proxy.TLS.ListenerCertFile = bundle.ServerCert.Name()
+	c.Proxy.TLS.ListenerKeyFile = bundle.ServerKey.Name()
+	c.Kafka.TLS.CAChainCertFile = bundle.CACert.Name()
+
+	// pinned CA in addition to the CA verification
+	c.Kafka.TLS.PinnedSPKIHashes = []string{testSPKIHash(a, bundle.ClientCert.Name()), "sha256/" + testSPKIHash(a, bundle.CACert.Name())}
+	c1, c2, stop, err := makeTLSPipe(c)
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	pingPong(t, c1, c2)
+	stop()
+
+	c.Kafka.TLS.PinnedSPKIHashes = []string{testSPKIHash(a, bundle.ClientCert.Name())}
+	_, _, _, err = makeTLSPipe(c)
+	a.NotNil(err)
+
+	// pinned server certificate instead of the CA verification
+	c.Kafka.TLS.CAChainCertFile = ""
+	c.Kafka.TLS.InsecureSkipVerify = true
+	c.Kafka.TLS.PinnedSPKIHashes = []string{testSPKIHash(a, bundle.ServerCert.Name())}
+	c1, c2, stop, err = makeTLSPipe(c)
+	if err != nil {
+		a.FailNow(err.Error())
+	}
+	pingPong(t, c1, c2)
+	stop()
+
+	// the CA is not presented by the server
+	c.Kafka.TLS.PinnedSPKIHashes = []string{testSPKIHash(a, bundle.CACert.Name())}
+	_, _, _, err = makeTLSPipe(c)
+	a.NotNil(err)
+
+	c.Kafka.TLS.PinnedSPKIHashes = []string{"abc"}
+	_, err = newTLSClientConfig(c)
+	a.EqualError(err, "invalid pinned SPKI hash 'abc', a base64 encoded SHA-256 is expected")
+}
+
 func TestTLSUnknownAuthorityNoCAChainCert(t *testing.T) {
 	a := assert.New(t)
 
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"strings"
+)
+
+const (
+	topicMetricsProduce = "produce"
+	topicMetricsFetch   = "fetch"
+
+	// topicMetricsOther is the label of the topics which are not in the allowlist
+	topicMetricsOther = "<other>"
+)
+
+// TopicMetrics counts the bytes and the records of the record sets per topic of the Produce requests and the Fetch responses.
+// The frames are not modified, the frames which cannot be decoded (e.g. the flexible Produce and Fetch versions) are not counted.
+type TopicMetrics struct {
+	topics []string // the topic ending with * is a prefix, all topics are counted when empty
+}
+
+// NewTopicMetrics creates the metrics of the topics in the allowlist, the other topics are counted together as <other>
+func NewTopicMetrics(topics []string) *TopicMetrics {
+	return &TopicMetrics{topics: topics}
+}
+
+func (m *TopicMetrics) topicLabel(topic string) string {
+	if len(m.topics) == 0 {
+		return topic
+	}
+	for _, pattern := range m.topics {
+		if pattern == topic || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))) {
+			return topic
+		}
+	}
+	return topicMetricsOther
+}
+
+// FilterRequest implements apis.FrameFilter
+func (m *TopicMetrics) FilterRequest(request []byte) ([]byte, error) {
+	if int16(binary.BigEndian.Uint16(request)) != apiKeyProduce {
+		return request, nil
+	}
+	// record sets are not modified
+	if _, err := protocol.ModifyProduceRecordSets(request, m.countRecordSets(topicMetricsProduce)); err != nil {
+		logger.Debugf("Topic metrics of the Produce request cannot be counted: %v", err)
+	}
+	return request, nil
+}
+
+// FilterResponse implements apis.FrameFilter
+func (m *TopicMetrics) FilterResponse(apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if apiKey != apiKeyFetch {
+		return response, nil
+	}
+	if _, err := protocol.ModifyFetchRecordSets(apiVersion, response, m.countRecordSets(topicMetricsFetch)); err != nil {
+		logger.Debugf("Topic metrics of the Fetch response version %d cannot be counted: %v", apiVersion, err)
+	}
+	return response, nil
+}
+
+func (m *TopicMetrics) countRecordSets(api string) protocol.ModifyRecordSetFunc {
+	return func(topic string, recordSet []byte) ([]byte, error) {
+		records, err := protocol.CountRecords(recordSet)
+		if err != nil {
+			return nil, err
+		}
+		label := m.topicLabel(topic)
+		proxyTopicBytesTotal.WithLabelValues(label, api).Add(float64(len(recordSet)))
+		proxyTopicRecordsTotal.WithLabelValues(label, api).Add(float64(records))
+		return recordSet, nil
+	}
+}
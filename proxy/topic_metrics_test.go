@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTopicMetricsTopicLabel(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewTopicMetrics([]string{"orders", "payments-*"})
+	a.Equal("orders", m.topicLabel("orders"))
+	a.Equal("payments-eu", m.topicLabel("payments-eu"))
+	a.Equal(topicMetricsOther, m.topicLabel("orders-eu"))
+	a.Equal("orders-eu", NewTopicMetrics(nil).topicLabel("orders-eu"))
+}
+
+func TestTopicMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewTopicMetrics([]string{"metrics-produce", "metrics-fetch"})
+	recordSet := append(testRecordSet("v1"), testRecordSet("v2")...)
+	producedBytes := testCounterValue(a, proxyTopicBytesTotal.WithLabelValues("metrics-produce", topicMetricsProduce))
+	producedRecords := testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-produce", topicMetricsProduce))
+	fetchedBytes := testCounterValue(a, proxyTopicBytesTotal.WithLabelValues("metrics-fetch", topicMetricsFetch))
+	fetchedRecords := testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-fetch", topicMetricsFetch))
+	notProduced := testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-fetch", topicMetricsProduce))
+
+	request := testProduceRequest("metrics-produce", recordSet)
+	result, err := m.FilterRequest(request)
+	a.Nil(err)
+	a.Equal(request, result)
+	a.Equal(producedBytes+float64(len(recordSet)), testCounterValue(a, proxyTopicBytesTotal.WithLabelValues("metrics-produce", topicMetricsProduce)))
+	a.Equal(producedRecords+2, testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-produce", topicMetricsProduce)))
+
+	response := testFetchResponse("metrics-fetch", recordSet)
+	result, err = m.FilterResponse(apiKeyFetch, 5, response)
+	a.Nil(err)
+	a.Equal(response, result)
+	a.Equal(fetchedBytes+float64(len(recordSet)), testCounterValue(a, proxyTopicBytesTotal.WithLabelValues("metrics-fetch", topicMetricsFetch)))
+	a.Equal(fetchedRecords+2, testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-fetch", topicMetricsFetch)))
+	a.Equal(notProduced, testCounterValue(a, proxyTopicRecordsTotal.WithLabelValues("metrics-fetch", topicMetricsProduce)))
+
+	// the frames which cannot be decoded are forwarded
+	response = []byte{0x00, 0x01}
+	result, err = m.FilterResponse(apiKeyFetch, 5, response)
+	a.Nil(err)
+	a.Equal(response, result)
+}
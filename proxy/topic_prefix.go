@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"strings"
+)
+
+// TopicPrefixes namespaces the topics of the clients. The prefix of the client is added to the topic names of the requests
+// and removed from the topic names of the responses, the topics of other clients are not visible.
+// With groups the consumer group ids and the transactional ids are namespaced as well.
+type TopicPrefixes struct {
+	defaultPrefix string
+	principals    map[string]string
+	groups        bool
+}
+
+// NewTopicPrefixes creates the prefixes of the principals given as principal=prefix, the default prefix is used for other clients.
+func NewTopicPrefixes(defaultPrefix string, principals []string, groups bool) (*TopicPrefixes, error) {
+	p := &TopicPrefixes{defaultPrefix: defaultPrefix, principals: make(map[string]string), groups: groups}
+	for _, value := range principals {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("topic prefix '%s' must be principal=prefix", value)
+		}
+		p.principals[pair[0]] = pair[1]
+	}
+	return p, nil
+}
+
+func (p *TopicPrefixes) enabled() bool {
+	return p != nil
+}
+
+// prefix returns the topic prefix of the principal, the empty principal is not authenticated
+func (p *TopicPrefixes) prefix(principal string) string {
+	if prefix, ok := p.principals[principal]; ok && principal != "" {
+		return prefix
+	}
+	return p.defaultPrefix
+}
+
+// addPrefix adds the prefix to the topic names of the request (without the size field). The requests which cannot be prefixed are rejected.
+func (p *TopicPrefixes) addPrefix(prefix string, request []byte) ([]byte, error) {
+	if prefix == "" {
+		return request, nil
+	}
+	apiKey, apiVersion := int16(binary.BigEndian.Uint16(request)), int16(binary.BigEndian.Uint16(request[2:]))
+	if !protocol.TopicPrefixSupported(apiKey, apiVersion, p.groups) {
+		return nil, &apis.FrameRejection{ErrorCode: int16(protocol.ErrTopicAuthorizationFailed), Reason: fmt.Sprintf("api key %d version %d cannot be used with topic prefix %s", apiKey, apiVersion, prefix)}
+	}
+	return protocol.AddTopicPrefix(request, prefix, p.groups)
+}
+
+// removePrefix removes the prefix from the topic names of the response (without the size and the correlation id)
+func (p *TopicPrefixes) removePrefix(prefix string, apiKey int16, apiVersion int16, response []byte) ([]byte, error) {
+	if prefix == "" {
+		return response, nil
+	}
+	return protocol.RemoveTopicPrefix(apiKey, apiVersion, response, prefix, p.groups)
+}
+
+// maxVersions returns the highest versions of the requests which can be prefixed
+func (p *TopicPrefixes) maxVersions() map[int16]int16 {
+	if !p.groups {
+		return protocol.MaxTopicPrefixVersions
+	}
+	result := make(map[int16]int16)
+	for _, versions := range []map[int16]int16{protocol.MaxTopicPrefixVersions, protocol.MaxGroupPrefixVersions} {
+		for apiKey, maxVersion := range versions {
+			if v, ok := result[apiKey]; !ok || maxVersion < v {
+				result[apiKey] = maxVersion
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"github.com/grepplabs/kafka-proxy/pkg/apis"
+	"github.com/grepplabs/kafka-proxy/proxy/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTopicPrefixesPrefix(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewTopicPrefixes("", []string{"alice"}, false)
+	a.EqualError(err, "topic prefix 'alice' must be principal=prefix")
+
+	p, err := NewTopicPrefixes("shared.", []string{"alice=tenant-a.", "admin="}, false)
+	a.Nil(err)
+	a.True(p.enabled())
+	a.Equal("tenant-a.", p.prefix("alice"))
+	a.Equal("", p.prefix("admin"))
+	a.Equal("shared.", p.prefix("bob"))
+	a.Equal("shared.", p.prefix(""))
+}
+
+func TestTopicPrefixesAddPrefix(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := NewTopicPrefixes("tenant-a.", nil, false)
+	a.Nil(err)
+
+	request, err := p.addPrefix("tenant-a.", testProduceRequest("t1", testRecordSet("v1")))
+	a.Nil(err)
+	info, err := protocol.DecodeRequestInfo(request)
+	a.Nil(err)
+	a.Equal([]string{"tenant-a.t1"}, info.Topics)
+
+	// no prefix
+	request = testProduceRequest("t1", testRecordSet("v1"))
+	result, err := p.addPrefix("", request)
+	a.Nil(err)
+	a.Equal(request, result)
+
+	// Metadata v9 requests cannot be decoded
+	_, err = p.addPrefix("tenant-a.", []byte{0x00, 0x03, 0x00, 0x09, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'c', 0xff, 0xff, 0xff, 0xff, 0x00})
+	rejection, ok := err.(*apis.FrameRejection)
+	a.True(ok)
+	a.Equal(int16(protocol.ErrTopicAuthorizationFailed), rejection.ErrorCode)
+}
+
+func TestTopicPrefixesMaxVersions(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := NewTopicPrefixes("tenant-a.", nil, false)
+	a.Nil(err)
+	_, ok := p.maxVersions()[11]
+	a.False(ok)
+
+	p, err = NewTopicPrefixes("tenant-a.", nil, true)
+	a.Nil(err)
+	versions := p.maxVersions()
+	a.Equal(int16(5), versions[11])
+	a.Equal(int16(8), versions[0])
+}
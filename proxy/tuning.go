@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// TuningSettings are the settings of the processors adjusted at runtime
+type TuningSettings struct {
+	// open requests of a connection awaiting the broker response
+	MaxOpenRequests    int `json:"max_open_requests"`
+	RequestBufferSize  int `json:"request_buffer_size"`
+	ResponseBufferSize int `json:"response_buffer_size"`
+}
+
+// ProcessorTuning holds the max open requests and the buffer sizes read by the processors, so they can be tuned on a live proxy
+// by the HTTP tuning endpoint. The buffer sizes apply to the next requests and responses of all connections, the max open requests
+// to the connections opened after the change.
+type ProcessorTuning struct {
+	maxOpenRequests    int32
+	requestBufferSize  int32
+	responseBufferSize int32
+}
+
+func NewProcessorTuning(settings TuningSettings) *ProcessorTuning {
+	return &ProcessorTuning{
+		maxOpenRequests:    int32(settings.MaxOpenRequests),
+		requestBufferSize:  int32(settings.RequestBufferSize),
+		responseBufferSize: int32(settings.ResponseBufferSize),
+	}
+}
+
+// Settings returns the current settings, the zero settings when the tuning is not configured
+func (t *ProcessorTuning) Settings() TuningSettings {
+	if t == nil {
+		return TuningSettings{}
+	}
+	return TuningSettings{
+		MaxOpenRequests:    int(atomic.LoadInt32(&t.maxOpenRequests)),
+		RequestBufferSize:  int(atomic.LoadInt32(&t.requestBufferSize)),
+		ResponseBufferSize: int(atomic.LoadInt32(&t.responseBufferSize)),
+	}
+}
+
+// Update changes the settings which are greater than 0, the other settings are kept
+func (t *ProcessorTuning) Update(settings TuningSettings) error {
+	if settings.MaxOpenRequests < 0 || settings.RequestBufferSize < 0 || settings.ResponseBufferSize < 0 {
+		return errors.New("max open requests and buffer sizes must be greater than 0")
+	}
+	if settings.MaxOpenRequests > 0 {
+		atomic.StoreInt32(&t.maxOpenRequests, int32(settings.MaxOpenRequests))
+	}
+	if settings.RequestBufferSize > 0 {
+		atomic.StoreInt32(&t.requestBufferSize, int32(settings.RequestBufferSize))
+	}
+	if settings.ResponseBufferSize > 0 {
+		atomic.StoreInt32(&t.responseBufferSize, int32(settings.ResponseBufferSize))
+	}
+	current := t.Settings()
+	logger.Infof("Processor tuning changed: max open requests %d, request buffer size %d, response buffer size %d",
+		current.MaxOpenRequests, current.RequestBufferSize, current.ResponseBufferSize)
+	return nil
+}
+
+func (t *ProcessorTuning) requestBuffer(buf []byte) []byte {
+	if t == nil {
+		return buf
+	}
+	return resizeBuffer(buf, int(atomic.LoadInt32(&t.requestBufferSize)))
+}
+
+func (t *ProcessorTuning) responseBuffer(buf []byte) []byte {
+	if t == nil {
+		return buf
+	}
+	return resizeBuffer(buf, int(atomic.LoadInt32(&t.responseBufferSize)))
+}
+
+// resizeBuffer returns the buffer of the size, the buffer is kept when its size was not changed
+func resizeBuffer(buf []byte, size int) []byte {
+	if size <= 0 || size == len(buf) {
+		return buf
+	}
+	return make([]byte, size)
+}
+
+// ServeHTTP returns the settings on GET and changes the settings given in the body on POST or PUT
+func (t *ProcessorTuning) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		settings := TuningSettings{}
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, fmt.Sprintf("invalid tuning: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := t.Update(settings); err != nil {
+			http.Error(w, fmt.Sprintf("invalid tuning: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Settings())
+}
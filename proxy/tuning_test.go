@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProcessorTuning(t *testing.T) {
+	a := assert.New(t)
+
+	var disabled *ProcessorTuning
+	a.Equal(TuningSettings{}, disabled.Settings())
+	buf := make([]byte, 16)
+	a.Len(disabled.requestBuffer(buf), 16)
+
+	tuning := NewProcessorTuning(TuningSettings{MaxOpenRequests: 256, RequestBufferSize: 16, ResponseBufferSize: 32})
+	// the processors read the settings of the new connections
+	p := newProcessor(ProcessorConfig{Tuning: tuning}, "broker:9092", "client:1234")
+	a.Equal(256, cap(p.openRequestsChannel))
+	a.Equal(16, p.requestBufferSize)
+	a.Equal(32, p.responseBufferSize)
+
+	a.Len(tuning.requestBuffer(buf), 16)
+	a.True(&buf[0] == &tuning.requestBuffer(buf)[0])
+
+	a.Nil(tuning.Update(TuningSettings{RequestBufferSize: 64}))
+	a.Equal(TuningSettings{MaxOpenRequests: 256, RequestBufferSize: 64, ResponseBufferSize: 32}, tuning.Settings())
+	a.Len(tuning.requestBuffer(buf), 64)
+	a.Len(tuning.responseBuffer(buf), 32)
+
+	a.EqualError(tuning.Update(TuningSettings{MaxOpenRequests: -1}), "max open requests and buffer sizes must be greater than 0")
+	a.Equal(256, tuning.Settings().MaxOpenRequests)
+
+	a.Nil(tuning.Update(TuningSettings{MaxOpenRequests: 512}))
+	p = newProcessor(ProcessorConfig{Tuning: tuning}, "broker:9092", "client:1234")
+	a.Equal(512, cap(p.openRequestsChannel))
+	a.Equal(64, p.requestBufferSize)
+}
+
+func TestProcessorTuningHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	tuning := NewProcessorTuning(TuningSettings{MaxOpenRequests: 256, RequestBufferSize: 4096, ResponseBufferSize: 4096})
+	server := httptest.NewServer(tuning)
+	defer server.Close()
+
+	do := func(method string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		a.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		a.Nil(err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		a.Nil(err)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	status, body := do(http.MethodGet, "")
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"max_open_requests":256,"request_buffer_size":4096,"response_buffer_size":4096}`, body)
+
+	status, body = do(http.MethodPost, `{"max_open_requests":512,"response_buffer_size":8192}`)
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"max_open_requests":512,"request_buffer_size":4096,"response_buffer_size":8192}`, body)
+
+	status, body = do(http.MethodPut, `{"request_buffer_size":1024}`)
+	a.Equal(http.StatusOK, status)
+	a.Equal(`{"max_open_requests":512,"request_buffer_size":1024,"response_buffer_size":8192}`, body)
+
+	status, _ = do(http.MethodPut, `{"request_buffer_size":-1}`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodPost, `{`)
+	a.Equal(http.StatusBadRequest, status)
+	status, _ = do(http.MethodDelete, "")
+	a.Equal(http.StatusMethodNotAllowed, status)
+	a.Equal(1024, tuning.Settings().RequestBufferSize)
+}
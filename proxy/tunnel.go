@@ -0,0 +1,538 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/hashicorp/yamux"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	tunnelSideClient = "client"
+	tunnelSideServer = "server"
+
+	// the tunnel server answers the stream header with the status, the error status is followed by the error message
+	tunnelStatusOK    = 0
+	tunnelStatusError = 1
+)
+
+// tunnelDialer opens the broker connections as the streams multiplexed over the mutually authenticated TLS connections
+// to the tunnel server, which dials the brokers. The TLS connections are established lazily and re-established after
+// they are lost, the streams are spread over them in turn. The Kafka TLS and SASL run end-to-end within the streams.
+// With the WebSocket transport the TLS connections are upgraded to the WebSocket before they are multiplexed.
+// With the QUIC transport the QUIC connections are dialed first, the TLS connection is used when the QUIC one cannot be established.
+type tunnelDialer struct {
+	dialer        Dialer
+	timeout       time.Duration
+	address       string
+	webSocketPath string
+	tlsConfig     *tls.Config
+	muxConfig     *yamux.Config
+	quicTLSConfig *tls.Config
+	quicConfig    *quic.Config
+	compression   byte
+
+	lock     sync.Mutex
+	sessions []tunnelSession
+	next     int
+	closed   bool
+}
+
+// newTunnelDialer creates the dialer of the streams, the tunnel server is dialed with the dialer e.g. through the forward proxy
+func newTunnelDialer(c *config.Config, timeout time.Duration, dialer Dialer) (*tunnelDialer, error) {
+	opts := c.Tunnel.Client
+	tlsConfig, err := newClientTLSConfig(false, opts.CertFile, opts.KeyFile, opts.KeyPassword.Value(), opts.CAChainCertFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "tunnel client TLS config")
+	}
+	compression, err := tunnelCompressionCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	d := &tunnelDialer{
+		dialer:      dialer,
+		timeout:     timeout,
+		address:     opts.Address,
+		tlsConfig:   tlsConfig,
+		muxConfig:   newTunnelMuxConfig(c),
+		compression: compression,
+		sessions:    make([]tunnelSession, opts.Connections),
+	}
+	switch opts.Transport {
+	case "websocket":
+		d.webSocketPath = c.Tunnel.WebSocketPath
+	case "quic":
+		d.quicTLSConfig = newTunnelQUICTLSConfig(tlsConfig)
+		d.quicConfig = newTunnelQUICConfig(c, timeout)
+	}
+	logger.Infof("Kafka clients will connect through the tunnel server %s over %d %s connections", opts.Address, opts.Connections, opts.Transport)
+	return d, nil
+}
+
+func newTunnelMuxConfig(c *config.Config) *yamux.Config {
+	muxConfig := yamux.DefaultConfig()
+	muxConfig.KeepAliveInterval = c.Tunnel.KeepAliveInterval
+	muxConfig.LogOutput = ioutil.Discard
+	return muxConfig
+}
+
+func (d *tunnelDialer) Dial(network, addr string) (net.Conn, error) {
+	session, err := d.session()
+	if err != nil {
+		return nil, forwardProxyError{err: err}
+	}
+	stream, err := session.OpenStream()
+	if err != nil {
+		session.Close()
+		return nil, forwardProxyError{err: err}
+	}
+	compression, err := openTunnelStream(stream, addr, d.compression, d.timeout)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	conn, err := newTunnelCompression(stream, compression, tunnelSideClient)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return newTunnelStream(conn, tunnelSideClient), nil
+}
+
+// session returns the next connection to the tunnel server, the lost connection is re-established
+func (d *tunnelDialer) session() (tunnelSession, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return nil, errors.New("tunnel dialer is closed")
+	}
+	i := d.next % len(d.sessions)
+	d.next++
+	if session := d.sessions[i]; session != nil && !session.IsClosed() {
+		return session, nil
+	}
+	if d.quicConfig != nil {
+		session, err := d.dialQUIC()
+		if err == nil {
+			logger.Infof("Tunnel connection %d to %s was established over QUIC", i, d.address)
+			trackTunnelSession(session.(quicTunnelSession).conn.Context().Done(), tunnelSideClient)
+			d.sessions[i] = session
+			return session, nil
+		}
+		logger.Infof("Tunnel connection %d to %s over QUIC failed, falling back to TLS: %v", i, d.address, err)
+	}
+	dialer := tlsDialer{timeout: d.timeout, rawDialer: d.dialer, config: d.tlsConfig}
+	conn, err := dialer.Dial("tcp", d.address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "tunnel server %s", d.address)
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if d.webSocketPath != "" {
+		websocket, err := websocketHandshake(conn, d.address, d.webSocketPath, d.timeout)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "tunnel server %s", d.address)
+		}
+		conn = websocket
+	}
+	session, err := yamux.Client(conn, d.muxConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	logger.Infof("Tunnel connection %d to %s was established", i, d.address)
+	trackTunnelSession(session.CloseChan(), tunnelSideClient)
+	d.sessions[i] = yamuxTunnelSession{session}
+	return d.sessions[i], nil
+}
+
+func (d *tunnelDialer) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	for _, session := range d.sessions {
+		if session != nil {
+			session.Close()
+		}
+	}
+	closeDialer(d.dialer)
+}
+
+// openTunnelStream sends the broker address and the requested compression and waits until the tunnel server has dialed the broker.
+// The compression accepted by the tunnel server is returned.
+func openTunnelStream(stream net.Conn, addr string, compression byte, timeout time.Duration) (byte, error) {
+	if timeout > 0 {
+		if err := stream.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, err
+		}
+	}
+	header := make([]byte, 2, 3+len(addr))
+	binary.BigEndian.PutUint16(header, uint16(len(addr)))
+	header = append(append(header, addr...), compression)
+	if _, err := stream.Write(header); err != nil {
+		return 0, forwardProxyError{err: err}
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return 0, forwardProxyError{err: err}
+	}
+	if status[0] != tunnelStatusOK {
+		message, err := readTunnelString(stream)
+		if err != nil {
+			return 0, forwardProxyError{err: err}
+		}
+		return 0, errors.Errorf("tunnel server: %s", message)
+	}
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return 0, forwardProxyError{err: err}
+	}
+	return status[0], stream.SetDeadline(time.Time{})
+}
+
+func readTunnelString(r io.Reader) (string, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// trackTunnelSession counts the tunnel connection until it is closed
+func trackTunnelSession(closed <-chan struct{}, side string) {
+	sessions := proxyTunnelSessions.WithLabelValues(side)
+	sessions.Inc()
+	go func() {
+		<-closed
+		sessions.Dec()
+	}()
+}
+
+// tunnelStream counts the open streams
+type tunnelStream struct {
+	net.Conn
+	side      string
+	closeOnce sync.Once
+}
+
+func newTunnelStream(conn net.Conn, side string) *tunnelStream {
+	proxyTunnelStreams.WithLabelValues(side).Inc()
+	return &tunnelStream{Conn: conn, side: side}
+}
+
+func (s *tunnelStream) Close() error {
+	s.closeOnce.Do(func() {
+		proxyTunnelStreams.WithLabelValues(s.side).Dec()
+	})
+	return s.Conn.Close()
+}
+
+// TunnelServer accepts the mutually authenticated TLS connections of the tunnel clients and dials the brokers
+// for the streams multiplexed over them, so only the port of the tunnel server has to be opened between the networks.
+// The bytes of the streams are relayed unchanged unless the client requested the compression accepted by the server.
+// With the QUIC transport the server accepts the QUIC connections and the TLS connections of the clients falling back.
+type TunnelServer struct {
+	tlsConfig      *tls.Config
+	muxConfig      *yamux.Config
+	quicConfig     *quic.Config
+	dialer         directDialer
+	allowedBrokers []string
+	compressions   map[byte]bool
+	webSocketPath  string
+
+	lock         sync.Mutex
+	listener     net.Listener
+	packetConn   net.PacketConn
+	quicListener *quic.Listener
+	sessions     map[tunnelSession]struct{}
+	closed       bool
+}
+
+func NewTunnelServer(c *config.Config) (*TunnelServer, error) {
+	tlsConfig, err := newTunnelServerTLSConfig(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "tunnel server TLS config")
+	}
+	compressions := map[byte]bool{tunnelCompressionNone: true}
+	for _, name := range c.Tunnel.Server.Compressions {
+		compression, err := tunnelCompressionCodec(name)
+		if err != nil {
+			return nil, err
+		}
+		compressions[compression] = true
+	}
+	var webSocketPath string
+	if c.Tunnel.Server.Transport == "websocket" {
+		webSocketPath = c.Tunnel.WebSocketPath
+	}
+	return &TunnelServer{
+		tlsConfig:  tlsConfig,
+		muxConfig:  newTunnelMuxConfig(c),
+		quicConfig: newTunnelQUICConfig(c, c.Kafka.DialTimeout),
+		dialer: directDialer{
+			dialTimeout:   c.Kafka.DialTimeout,
+			keepAlive:     c.Kafka.KeepAlive,
+			fallbackDelay: c.Kafka.DialFallbackDelay,
+			source:        sourceAddress(c.Kafka.DialSourceAddress),
+		},
+		allowedBrokers: c.Tunnel.Server.AllowedBrokers,
+		compressions:   compressions,
+		webSocketPath:  webSocketPath,
+		sessions:       make(map[tunnelSession]struct{}),
+	}, nil
+}
+
+func newTunnelServerTLSConfig(c *config.Config) (*tls.Config, error) {
+	opts := c.Tunnel.Server
+	certPEMBlock, err := ioutil.ReadFile(opts.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEMBlock, err := ioutil.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if keyPEMBlock, err = decryptPEM(keyPEMBlock, opts.KeyPassword.Value()); err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+	if err != nil {
+		return nil, err
+	}
+	caCertPEMBlock, err := ioutil.ReadFile(opts.CAChainCertFile)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if ok := clientCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+		return nil, errors.New("Failed to parse tunnel client root certificate")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Serve accepts the tunnel connections until the server is closed
+func (s *TunnelServer) Serve(listener net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.lock.Unlock()
+
+	logger.Infof("Tunnel server listening on %s", listener.Addr())
+	tlsListener := tls.NewListener(listener, s.tlsConfig)
+	if s.webSocketPath != "" {
+		return s.serveWebSocket(tlsListener)
+	}
+	for {
+		conn, err := tlsListener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go withRecover(func() { s.serveSession(conn) })
+	}
+}
+
+// Close stops accepting the tunnel connections and closes the open ones
+func (s *TunnelServer) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.quicListener != nil {
+		s.quicListener.Close()
+	}
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+	for session := range s.sessions {
+		session.Close()
+	}
+}
+
+// serveWebSocket accepts the tunnel connections upgraded to the WebSocket, the TLS handshake is done by the HTTP server
+func (s *TunnelServer) serveWebSocket(tlsListener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.webSocketPath, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocketUpgrade(w, r)
+		if err != nil {
+			logger.Infof("Tunnel connection from %s was rejected: %v", r.RemoteAddr, err)
+			return
+		}
+		go withRecover(func() { s.serveMux(conn) })
+	})
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.dialer.dialTimeout,
+		ErrorLog:          log.New(ioutil.Discard, "", 0),
+	}
+	err := server.Serve(tlsListener)
+	s.lock.Lock()
+	closed := s.closed
+	s.lock.Unlock()
+	if closed {
+		return nil
+	}
+	return err
+}
+
+func (s *TunnelServer) serveSession(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// the client certificate is verified by the handshake
+		conn.SetDeadline(time.Now().Add(s.dialer.dialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			logger.Infof("Tunnel connection from %s was rejected: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	s.serveMux(conn)
+}
+
+// serveMux accepts the streams of the tunnel connection
+func (s *TunnelServer) serveMux(conn net.Conn) {
+	session, err := yamux.Server(conn, s.muxConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if !s.addSession(yamuxTunnelSession{session}) {
+		return
+	}
+	trackTunnelSession(session.CloseChan(), tunnelSideServer)
+	logger.Infof("Tunnel connection from %s was accepted", conn.RemoteAddr())
+
+	defer s.removeSession(yamuxTunnelSession{session})
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go withRecover(func() { s.serveStream(stream) })
+	}
+}
+
+// addSession registers the accepted tunnel connection, which is closed instead when the server is closed
+func (s *TunnelServer) addSession(session tunnelSession) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		session.Close()
+		return false
+	}
+	s.sessions[session] = struct{}{}
+	return true
+}
+
+func (s *TunnelServer) removeSession(session tunnelSession) {
+	s.lock.Lock()
+	delete(s.sessions, session)
+	s.lock.Unlock()
+	session.Close()
+}
+
+// serveStream dials the broker of the stream header and relays the bytes
+func (s *TunnelServer) serveStream(stream net.Conn) {
+	defer stream.Close()
+	if s.dialer.dialTimeout > 0 {
+		stream.SetDeadline(time.Now().Add(s.dialer.dialTimeout))
+	}
+	address, err := readTunnelString(stream)
+	if err != nil {
+		return
+	}
+	compression := make([]byte, 1)
+	if _, err = io.ReadFull(stream, compression); err != nil {
+		return
+	}
+	if !s.compressions[compression[0]] {
+		logger.Debugf("Tunnel stream to %s requested the compression %s which is not accepted", address, tunnelCompressionName(compression[0]))
+		compression[0] = tunnelCompressionNone
+	}
+	if !s.allowed(address) {
+		logger.Infof("Tunnel stream to %s is not allowed", address)
+		writeTunnelError(stream, errors.Errorf("broker %s is not allowed", address))
+		return
+	}
+	broker, err := s.dialer.Dial("tcp", address)
+	if err != nil {
+		logger.Infof("Tunnel stream couldn't connect to %s: %v", address, err)
+		writeTunnelError(stream, err)
+		return
+	}
+	defer broker.Close()
+	if _, err = stream.Write([]byte{tunnelStatusOK, compression[0]}); err != nil {
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	broker.SetDeadline(time.Time{})
+
+	compressed, err := newTunnelCompression(stream, compression[0], tunnelSideServer)
+	if err != nil {
+		return
+	}
+	conn := newTunnelStream(compressed, tunnelSideServer)
+	defer conn.Close()
+	go withRecover(func() {
+		io.Copy(broker, conn)
+		broker.Close()
+	})
+	io.Copy(conn, broker)
+}
+
+func (s *TunnelServer) allowed(address string) bool {
+	if len(s.allowedBrokers) == 0 {
+		return true
+	}
+	for _, pattern := range s.allowedBrokers {
+		if ok, _ := path.Match(pattern, address); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTunnelError(w io.Writer, err error) {
+	message := err.Error()
+	if len(message) > 1024 {
+		message = message[:1024]
+	}
+	buf := make([]byte, 3, 3+len(message))
+	buf[0] = tunnelStatusError
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(message)))
+	w.Write(append(buf, message...))
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"compress/flate"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// the compression codecs of the tunnel streams, the client requests the codec in the stream header and the server
+// answers with the codec used in both directions, which is none when the server does not accept the requested one
+const (
+	tunnelCompressionNone    byte = 0
+	tunnelCompressionDeflate byte = 1
+)
+
+var tunnelCompressionCodecs = map[string]byte{
+	"none":    tunnelCompressionNone,
+	"deflate": tunnelCompressionDeflate,
+}
+
+func tunnelCompressionCodec(name string) (byte, error) {
+	codec, ok := tunnelCompressionCodecs[name]
+	if !ok {
+		return 0, errors.Errorf("tunnel compression %s is not supported", name)
+	}
+	return codec, nil
+}
+
+func tunnelCompressionName(codec byte) string {
+	for name, c := range tunnelCompressionCodecs {
+		if c == codec {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// newTunnelCompression returns the stream compressing the written and decompressing the read bytes with the codec
+func newTunnelCompression(conn net.Conn, codec byte, side string) (net.Conn, error) {
+	switch codec {
+	case tunnelCompressionNone:
+		return conn, nil
+	case tunnelCompressionDeflate:
+		compressed := &tunnelCompressionCounter{Writer: conn, counter: proxyTunnelCompressionBytesTotal.WithLabelValues(side, "compressed")}
+		writer, err := flate.NewWriter(compressed, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		return &deflateConn{
+			Conn:         conn,
+			writer:       writer,
+			reader:       flate.NewReader(conn),
+			uncompressed: proxyTunnelCompressionBytesTotal.WithLabelValues(side, "uncompressed"),
+		}, nil
+	default:
+		return nil, errors.Errorf("tunnel compression codec %d is not supported", codec)
+	}
+}
+
+type byteCounter interface {
+	Add(float64)
+}
+
+// tunnelCompressionCounter counts the compressed bytes written to the stream
+type tunnelCompressionCounter struct {
+	io.Writer
+	counter byteCounter
+}
+
+func (w *tunnelCompressionCounter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.counter.Add(float64(n))
+	return n, err
+}
+
+// deflateConn flushes each write, so the peer can decompress the Kafka frame without waiting for the next one
+type deflateConn struct {
+	net.Conn
+	uncompressed byteCounter
+
+	writeLock sync.Mutex
+	writer    *flate.Writer
+	reader    io.ReadCloser
+	closeOnce sync.Once
+}
+
+func (c *deflateConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err = c.writer.Flush(); err != nil {
+		return n, err
+	}
+	c.uncompressed.Add(float64(n))
+	return n, nil
+}
+
+func (c *deflateConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.reader.Close()
+	})
+	return c.Conn.Close()
+}
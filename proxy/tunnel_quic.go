@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/hashicorp/yamux"
+	"github.com/quic-go/quic-go"
+	"net"
+	"time"
+)
+
+// the QUIC transport carries every stream in its own QUIC stream, so a lost packet delays only the stream it belongs to
+// and the connection survives the changes of the client address e.g. NAT rebinding
+const (
+	tunnelQUICProtocol = "kafka-proxy-tunnel"
+
+	// the limit of the broker connections per QUIC connection, the yamux sessions have none
+	tunnelQUICMaxStreams = 1 << 16
+)
+
+// tunnelSession is the tunnel connection the streams are multiplexed over
+type tunnelSession interface {
+	OpenStream() (net.Conn, error)
+	IsClosed() bool
+	Close() error
+}
+
+type yamuxTunnelSession struct {
+	*yamux.Session
+}
+
+func (s yamuxTunnelSession) OpenStream() (net.Conn, error) {
+	stream, err := s.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+type quicTunnelSession struct {
+	conn    *quic.Conn
+	timeout time.Duration
+}
+
+func (s quicTunnelSession) OpenStream() (net.Conn, error) {
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	// blocks while the server limit of the streams is reached
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quicStream{Stream: stream, conn: s.conn}, nil
+}
+
+func (s quicTunnelSession) IsClosed() bool {
+	return s.conn.Context().Err() != nil
+}
+
+func (s quicTunnelSession) Close() error {
+	return s.conn.CloseWithError(0, "")
+}
+
+// quicStream is the QUIC stream as net.Conn
+type quicStream struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (s *quicStream) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *quicStream) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close closes both directions like the TCP connection, closing the QUIC stream ends only the sending
+func (s *quicStream) Close() error {
+	s.Stream.CancelRead(0)
+	return s.Stream.Close()
+}
+
+func newTunnelQUICConfig(c *config.Config, handshakeTimeout time.Duration) *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout: handshakeTimeout,
+		MaxIdleTimeout:       2 * c.Tunnel.KeepAliveInterval,
+		KeepAlivePeriod:      c.Tunnel.KeepAliveInterval,
+		MaxIncomingStreams:   tunnelQUICMaxStreams,
+	}
+}
+
+func newTunnelQUICTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{tunnelQUICProtocol}
+	return tlsConfig
+}
+
+// dialQUIC establishes the QUIC connection to the tunnel server, it is dialed directly over UDP
+func (d *tunnelDialer) dialQUIC() (tunnelSession, error) {
+	ctx := context.Background()
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	conn, err := quic.DialAddr(ctx, d.address, d.quicTLSConfig, d.quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	return quicTunnelSession{conn: conn, timeout: d.timeout}, nil
+}
+
+// ServeQUIC accepts the QUIC tunnel connections until the server is closed
+func (s *TunnelServer) ServeQUIC(packetConn net.PacketConn) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		packetConn.Close()
+		return nil
+	}
+	listener, err := quic.Listen(packetConn, newTunnelQUICTLSConfig(s.tlsConfig), s.quicConfig)
+	if err != nil {
+		s.lock.Unlock()
+		packetConn.Close()
+		return err
+	}
+	s.packetConn = packetConn
+	s.quicListener = listener
+	s.lock.Unlock()
+
+	logger.Infof("Tunnel server listening on %s (QUIC)", packetConn.LocalAddr())
+	for {
+		// the client certificate is verified by the handshake
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go withRecover(func() { s.serveQUIC(conn) })
+	}
+}
+
+// serveQUIC accepts the streams of the QUIC tunnel connection
+func (s *TunnelServer) serveQUIC(conn *quic.Conn) {
+	session := quicTunnelSession{conn: conn}
+	if !s.addSession(session) {
+		return
+	}
+	trackTunnelSession(conn.Context().Done(), tunnelSideServer)
+	logger.Infof("Tunnel connection from %s was accepted (QUIC)", conn.RemoteAddr())
+
+	defer s.removeSession(session)
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go withRecover(func() { s.serveStream(&quicStream{Stream: stream, conn: conn}) })
+	}
+}
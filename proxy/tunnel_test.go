@@ -0,0 +1,407 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/elazarl/goproxy"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/hashicorp/yamux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testTunnelConfig(bundle *CertsBundle, address string) *config.Config {
+	c := config.NewConfig()
+	c.Kafka.DialTimeout = 5 * time.Second
+	c.Tunnel.Client.Address = address
+	c.Tunnel.Client.CertFile = bundle.ClientCert.Name()
+	c.Tunnel.Client.KeyFile = bundle.ClientKey.Name()
+	c.Tunnel.Client.CAChainCertFile = bundle.CACert.Name()
+	c.Tunnel.Server.CertFile = bundle.ServerCert.Name()
+	c.Tunnel.Server.KeyFile = bundle.ServerKey.Name()
+	c.Tunnel.Server.CAChainCertFile = bundle.CACert.Name()
+	return c
+}
+
+func testTunnelServer(a *assert.Assertions, c *config.Config) (*TunnelServer, string) {
+	tunnelServer, err := NewTunnelServer(c)
+	a.Nil(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	go tunnelServer.Serve(listener)
+	return tunnelServer, listener.Addr().String()
+}
+
+// testTunnelBroker echoes the bytes
+func testTunnelBroker(a *assert.Assertions) net.Listener {
+	broker, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	go func() {
+		for {
+			conn, err := broker.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return broker
+}
+
+func TestTunnel(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	broker := testTunnelBroker(a)
+	defer broker.Close()
+
+	c := testTunnelConfig(bundle, "")
+	c.Tunnel.Server.AllowedBrokers = []string{"127.0.0.1:*"}
+	tunnelServer, address := testTunnelServer(a, c)
+	defer tunnelServer.Close()
+
+	c.Tunnel.Client.Address = address
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer dialer.Close()
+
+	// the streams are spread over the tunnel connections
+	for i := 0; i < 3; i++ {
+		conn, err := dialer.Dial("tcp", broker.Addr().String())
+		a.Nil(err)
+		_, err = conn.Write([]byte("ping"))
+		a.Nil(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		a.Nil(err)
+		a.Equal("ping", string(buf))
+		a.Nil(conn.Close())
+	}
+	a.NotNil(dialer.sessions[0])
+	a.NotNil(dialer.sessions[1])
+	a.True(dialer.sessions[0] != dialer.sessions[1])
+
+	// the broker is not allowed
+	_, err = dialer.Dial("tcp", "localhost:9092")
+	a.EqualError(err, "tunnel server: broker localhost:9092 is not allowed")
+	a.False(isForwardProxyError(err))
+
+	// the lost tunnel connection is re-established
+	dialer.sessions[0].Close()
+	dialer.next = 0
+	conn, err := dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	conn.Close()
+
+	// the tunnel server is unreachable
+	tunnelServer.Close()
+	dialer.sessions[0].Close()
+	dialer.next = 0
+	_, err = dialer.Dial("tcp", broker.Addr().String())
+	a.NotNil(err)
+	a.True(isForwardProxyError(err))
+}
+
+func TestTunnelCompression(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	broker := testTunnelBroker(a)
+	defer broker.Close()
+
+	c := testTunnelConfig(bundle, "")
+	tunnelServer, address := testTunnelServer(a, c)
+	defer tunnelServer.Close()
+	c.Tunnel.Client.Address = address
+	c.Tunnel.Client.Compression = "deflate"
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer dialer.Close()
+
+	uncompressed := testCounterValue(a, proxyTunnelCompressionBytesTotal.WithLabelValues(tunnelSideClient, "uncompressed"))
+	compressed := testCounterValue(a, proxyTunnelCompressionBytesTotal.WithLabelValues(tunnelSideClient, "compressed"))
+	conn, err := dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	a.IsType(&deflateConn{}, conn.(*tunnelStream).Conn)
+	payload := bytes.Repeat([]byte("uncompressed record "), 1000)
+	_, err = conn.Write(payload)
+	a.Nil(err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal(payload, buf)
+	a.Nil(conn.Close())
+	a.Equal(uncompressed+float64(len(payload)), testCounterValue(a, proxyTunnelCompressionBytesTotal.WithLabelValues(tunnelSideClient, "uncompressed")))
+	a.True(testCounterValue(a, proxyTunnelCompressionBytesTotal.WithLabelValues(tunnelSideClient, "compressed"))-compressed < float64(len(payload)/10))
+
+	// the compression is not accepted by the tunnel server
+	c.Tunnel.Server.Compressions = []string{}
+	plainServer, address := testTunnelServer(a, c)
+	defer plainServer.Close()
+	c.Tunnel.Client.Address = address
+	plainDialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer plainDialer.Close()
+	conn, err = plainDialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	a.IsType(&yamux.Stream{}, conn.(*tunnelStream).Conn)
+	_, err = conn.Write([]byte("ping"))
+	a.Nil(err)
+	_, err = io.ReadFull(conn, buf[:4])
+	a.Nil(err)
+	a.Equal("ping", string(buf[:4]))
+}
+
+func TestTunnelWebSocket(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	broker := testTunnelBroker(a)
+	defer broker.Close()
+
+	c := testTunnelConfig(bundle, "")
+	c.Kafka.DialTimeout = time.Second
+	c.Tunnel.Server.Transport = "websocket"
+	tunnelServer, address := testTunnelServer(a, c)
+	defer tunnelServer.Close()
+
+	// the tunnel server is dialed through the HTTP proxy
+	forwardProxy := httptest.NewServer(goproxy.NewProxyHttpServer())
+	defer forwardProxy.Close()
+	c.Tunnel.Client.Address = address
+	c.Tunnel.Client.Transport = "websocket"
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, &httpProxy{
+		forwardDialer: directDialer{dialTimeout: c.Kafka.DialTimeout},
+		network:       "tcp",
+		hostPort:      forwardProxy.Listener.Addr().String(),
+	})
+	a.Nil(err)
+	defer dialer.Close()
+
+	conn, err := dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("record"), 20000)
+	_, err = conn.Write(payload)
+	a.Nil(err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal(payload, buf)
+
+	// the TLS transport is not accepted by the websocket tunnel server
+	c.Tunnel.Client.Transport = "tls"
+	tlsDialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer tlsDialer.Close()
+	_, err = tlsDialer.Dial("tcp", broker.Addr().String())
+	a.NotNil(err)
+}
+
+func TestTunnelQUIC(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	broker := testTunnelBroker(a)
+	defer broker.Close()
+
+	// the tunnel server accepts the QUIC connections on the UDP port of the listen address
+	c := testTunnelConfig(bundle, "")
+	c.Tunnel.Server.ListenAddress = "127.0.0.1:0"
+	c.Tunnel.Server.Transport = "quic"
+	listeners := make(testAddressListener, 1)
+	s, err := New(c, WithAddressListener(listeners), WithRegisterer(prometheus.NewRegistry()))
+	a.Nil(err)
+	a.Nil(s.Start(context.Background()))
+	defer s.Close()
+	listener := <-listeners
+
+	c.Tunnel.Client.Address = listener.Addr().String()
+	c.Tunnel.Client.Transport = "quic"
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer dialer.Close()
+
+	conn, err := dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	a.IsType(&quicStream{}, conn.(*tunnelStream).Conn)
+	a.IsType(quicTunnelSession{}, dialer.sessions[0])
+	payload := bytes.Repeat([]byte("record"), 20000)
+	_, err = conn.Write(payload)
+	a.Nil(err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal(payload, buf)
+	a.Nil(conn.Close())
+
+	// the TLS connections of the clients falling back are accepted too
+	c.Tunnel.Client.Transport = "tls"
+	tlsDialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer tlsDialer.Close()
+	conn, err = tlsDialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	a.IsType(yamuxTunnelSession{}, tlsDialer.sessions[0])
+	a.Nil(conn.Close())
+
+	// the lost QUIC connection is re-established
+	dialer.sessions[0].Close()
+	dialer.next = 0
+	conn, err = dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	a.IsType(quicTunnelSession{}, dialer.sessions[0])
+	a.Nil(conn.Close())
+}
+
+func TestTunnelQUICFallbackTLS(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+	broker := testTunnelBroker(a)
+	defer broker.Close()
+
+	// the tunnel server does not accept the QUIC connections
+	c := testTunnelConfig(bundle, "")
+	c.Kafka.DialTimeout = time.Second
+	tunnelServer, address := testTunnelServer(a, c)
+	defer tunnelServer.Close()
+
+	c.Tunnel.Client.Address = address
+	c.Tunnel.Client.Transport = "quic"
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer dialer.Close()
+
+	conn, err := dialer.Dial("tcp", broker.Addr().String())
+	a.Nil(err)
+	defer conn.Close()
+	a.IsType(yamuxTunnelSession{}, dialer.sessions[0])
+	_, err = conn.Write([]byte("ping"))
+	a.Nil(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	a.Nil(err)
+	a.Equal("ping", string(buf))
+}
+
+func TestWebsocketConn(t *testing.T) {
+	a := assert.New(t)
+
+	c1, c2 := net.Pipe()
+	client := &websocketConn{Conn: c1, reader: bufio.NewReader(c1), client: true}
+	server := &websocketConn{Conn: c2, reader: bufio.NewReader(c2)}
+
+	// the masked frames of the client with the 16 and 64 bit lengths
+	for _, size := range []int{10, 1000, 70000} {
+		payload := bytes.Repeat([]byte{'k'}, size)
+		go client.Write(payload)
+		buf := make([]byte, size)
+		_, err := io.ReadFull(server, buf)
+		a.Nil(err)
+		a.Equal(payload, buf)
+	}
+
+	// the ping is answered by the reader
+	go func() {
+		server.writeFrame(websocketOpPing, []byte("ping"))
+		server.Write([]byte("data"))
+	}()
+	pong := make(chan string, 1)
+	go func() {
+		opcode, length, err := server.readHeader()
+		payload := make([]byte, length)
+		if err == nil && opcode == websocketOpPong {
+			err = server.readPayload(payload)
+		}
+		if err != nil {
+			payload = []byte(err.Error())
+		}
+		pong <- string(payload)
+	}()
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(client, buf)
+	a.Nil(err)
+	a.Equal("data", string(buf))
+	a.Equal("ping", <-pong)
+
+	// the close frame ends the stream
+	go server.Close()
+	_, err = client.Read(buf)
+	a.Equal(io.EOF, err)
+	client.Close()
+}
+
+func TestTunnelClientCertificateRequired(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	tunnelServer, address := testTunnelServer(a, testTunnelConfig(bundle, ""))
+	defer tunnelServer.Close()
+
+	caCert, err := ioutil.ReadFile(bundle.CACert.Name())
+	a.Nil(err)
+	rootCAs := x509.NewCertPool()
+	a.True(rootCAs.AppendCertsFromPEM(caCert))
+	conn, err := tls.Dial("tcp", address, &tls.Config{RootCAs: rootCAs})
+	if err != nil {
+		// the handshake failed
+		return
+	}
+	defer conn.Close()
+	muxConfig := yamux.DefaultConfig()
+	muxConfig.LogOutput = ioutil.Discard
+	session, err := yamux.Client(conn, muxConfig)
+	a.Nil(err)
+	stream, err := session.OpenStream()
+	if err == nil {
+		_, err = openTunnelStream(stream, "127.0.0.1:9092", tunnelCompressionNone, time.Second)
+		a.NotNil(err)
+	}
+}
+
+func TestServerTunnel(t *testing.T) {
+	a := assert.New(t)
+	bundle := NewCertsBundle()
+	defer bundle.Close()
+
+	// the tunnel server does not need the bootstrap servers
+	c := testTunnelConfig(bundle, "")
+	c.Tunnel.Server.ListenAddress = "127.0.0.1:0"
+	a.Nil(c.Validate())
+	listeners := make(testAddressListener, 1)
+	s, err := New(c, WithAddressListener(listeners), WithRegisterer(prometheus.NewRegistry()))
+	a.Nil(err)
+	a.Nil(s.Start(context.Background()))
+	listener := <-listeners
+
+	c.Tunnel.Client.Address = listener.Addr().String()
+	dialer, err := newTunnelDialer(c, c.Kafka.DialTimeout, directDialer{dialTimeout: c.Kafka.DialTimeout})
+	a.Nil(err)
+	defer dialer.Close()
+	_, err = dialer.Dial("tcp", "127.0.0.1:1")
+	a.Contains(err.Error(), "tunnel server: ")
+
+	// the tunnel server is closed with the server
+	s.Close()
+	<-s.Done()
+	dialer.sessions[0].Close()
+	dialer.next = 0
+	_, err = dialer.Dial("tcp", "127.0.0.1:1")
+	a.True(isForwardProxyError(err))
+}
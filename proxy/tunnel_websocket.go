@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the tunnel connection is carried in the binary messages of the WebSocket (RFC 6455), so it passes the middleboxes
+// which permit only HTTP(S)
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketOpContinuation = 0x0
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+
+	websocketMaxControlPayload = 125
+)
+
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// websocketHandshake upgrades the client connection to the WebSocket
+func websocketHandshake(conn net.Conn, host string, path string, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request, err := http.NewRequest(http.MethodGet, "https://"+host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	if err = request.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("websocket upgrade responded with status %s", response.Status)
+	}
+	if !strings.EqualFold(response.Header.Get("Upgrade"), "websocket") || response.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, errors.New("websocket upgrade response is invalid")
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &websocketConn{Conn: conn, reader: reader, client: true}, nil
+}
+
+// websocketUpgrade upgrades the server connection of the WebSocket request
+func websocketUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "websocket upgrade is required", http.StatusBadRequest)
+		return nil, errors.New("request is not a websocket upgrade")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade is not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{Conn: conn, reader: buf.Reader}, nil
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketConn writes each write as one binary message and reads the payloads of the binary messages.
+// The pings are answered, the client masks the written frames.
+type websocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+	client bool
+
+	// owned by the reader
+	remaining int64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		opcode, length, err := c.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case websocketOpBinary, websocketOpContinuation:
+			c.remaining = length
+		case websocketOpClose:
+			return 0, io.EOF
+		case websocketOpPing, websocketOpPong:
+			if length > websocketMaxControlPayload {
+				return 0, errors.New("websocket control frame is too long")
+			}
+			payload := make([]byte, length)
+			if err = c.readPayload(payload); err != nil {
+				return 0, err
+			}
+			if opcode == websocketOpPing {
+				if err = c.writeFrame(websocketOpPong, payload); err != nil {
+					return 0, err
+				}
+			}
+		default:
+			return 0, errors.Errorf("websocket opcode %d is not supported", opcode)
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= int64(n)
+	return n, err
+}
+
+func (c *websocketConn) readHeader() (byte, int64, error) {
+	header := make([]byte, 2, 8)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, 0, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(c.reader, header[:2]); err != nil {
+			return 0, 0, err
+		}
+		length = int64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		header = header[:8]
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			return 0, 0, err
+		}
+		length = int64(binary.BigEndian.Uint64(header))
+		if length < 0 {
+			return 0, 0, errors.New("websocket frame is too long")
+		}
+	}
+	c.masked = masked
+	c.maskPos = 0
+	if c.masked {
+		if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return opcode, length, nil
+}
+
+func (c *websocketConn) readPayload(p []byte) error {
+	if _, err := io.ReadFull(c.reader, p); err != nil {
+		return err
+	}
+	c.unmask(p)
+	return nil
+}
+
+func (c *websocketConn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(websocketOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame writes the payload as the final frame, the payload of the client is masked in a copy
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= websocketMaxControlPayload:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if !c.client {
+		_, err := c.Conn.Write(append(frame, payload...))
+		return err
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	offset := len(frame)
+	frame = append(frame, payload...)
+	for i := range frame[offset:] {
+		frame[offset+i] ^= mask[i%4]
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends the close frame and closes the connection
+func (c *websocketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(websocketOpClose, nil)
+	})
+	return c.Conn.Close()
+}
@@ -35,7 +35,7 @@ func makeTLSPipe(conf *config.Config) (c1, c2 net.Conn, stop func(), err error)
 		rawDialer: rawDialer,
 		config:    clientConfig,
 	}
-	serverConfig, err := newTLSListenerConfig(conf)
+	serverConfig, err := newTLSListenerConfig(conf, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -117,7 +117,7 @@ func makeTLSSocks5ProxyPipe(conf *config.Config, authenticator socks5.Authentica
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	serverConfig, err := newTLSListenerConfig(conf)
+	serverConfig, err := newTLSListenerConfig(conf, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -228,7 +228,7 @@ func makeTLSHttpProxyPipe(conf *config.Config, proxyusername, proxypassword stri
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	serverConfig, err := newTLSListenerConfig(conf)
+	serverConfig, err := newTLSListenerConfig(conf, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxVaultResponseSize = 1 << 20
+
+// vaultClient reads and writes the secrets with the Vault HTTP API
+type vaultClient struct {
+	address   string
+	token     string
+	tokenFile string
+	client    *http.Client
+}
+
+type vaultSecret struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+func newVaultClient(conf *config.Config) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.Vault.CACertFile != "" {
+		caCertPEMBlock, err := ioutil.ReadFile(conf.Vault.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if ok := rootCAs.AppendCertsFromPEM(caCertPEMBlock); !ok {
+			return nil, errors.New("Failed to parse Vault root certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	return &vaultClient{
+		address:   strings.TrimSuffix(conf.Vault.Address, "/"),
+		token:     conf.Vault.Token.Value(),
+		tokenFile: conf.Vault.TokenFile,
+		client:    &http.Client{Timeout: conf.Vault.Timeout, Transport: transport},
+	}, nil
+}
+
+// do sends the request to the path, the body is nil for reads
+func (v *vaultClient) do(method string, path string, body interface{}) (*vaultSecret, error) {
+	token := v.token
+	if v.tokenFile != "" {
+		data, err := ioutil.ReadFile(v.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	url := v.address + "/v1/" + strings.Trim(path, "/")
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxVaultResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault request to %s failed with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	secret := &vaultSecret{}
+	if err = json.Unmarshal(data, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// vaultCertificate is the certificate issued by the Vault PKI secrets engine
+type vaultCertificate struct {
+	source *secretSource
+}
+
+func newVaultCertificate(conf *config.Config, pki config.VaultPKIConfig) (*vaultCertificate, error) {
+	client, err := newVaultClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"common_name": pki.CommonName}
+	if len(pki.AltNames) != 0 {
+		body["alt_names"] = strings.Join(pki.AltNames, ",")
+	}
+	if pki.TTL > 0 {
+		body["ttl"] = pki.TTL.String()
+	}
+	fetch := func() (interface{}, time.Duration, error) {
+		secret, err := client.do(http.MethodPost, pki.Path, body)
+		if err != nil {
+			return nil, 0, err
+		}
+		cert, err := vaultSecretCertificate(secret)
+		if err != nil {
+			return nil, 0, err
+		}
+		return cert, time.Until(cert.Leaf.NotAfter), nil
+	}
+	c := &vaultCertificate{source: &secretSource{path: pki.Path, refreshInterval: conf.Vault.RefreshInterval, fetch: fetch}}
+	if _, err = c.source.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func vaultSecretCertificate(secret *vaultSecret) (*tls.Certificate, error) {
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	if certPEM == "" || keyPEM == "" {
+		return nil, errors.New("certificate or private_key is missing in the Vault response")
+	}
+	chain := []string{certPEM}
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			if pem, ok := ca.(string); ok {
+				chain = append(chain, pem)
+			}
+		}
+	} else if issuingCA, ok := secret.Data["issuing_ca"].(string); ok {
+		chain = append(chain, issuingCA)
+	}
+	cert, err := tls.X509KeyPair([]byte(strings.Join(chain, "\n")), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (c *vaultCertificate) certificate() (*tls.Certificate, error) {
+	value, err := c.source.get()
+	if err != nil {
+		return nil, err
+	}
+	return value.(*tls.Certificate), nil
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (c *vaultCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate()
+}
+
+// getClientCertificate implements tls.Config.GetClientCertificate
+func (c *vaultCertificate) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.certificate()
+}
+
+// vaultCredentials are the SASL username and password of a KV version 1 or 2 secret or of the database secrets engine
+type vaultCredentials struct {
+	source *secretSource
+}
+
+func newVaultCredentials(conf *config.Config, path string) (*vaultCredentials, error) {
+	client, err := newVaultClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	fetch := func() (interface{}, time.Duration, error) {
+		secret, err := client.do(http.MethodGet, path, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		data := secret.Data
+		// KV version 2 nests the secret with its metadata
+		if nested, ok := data["data"].(map[string]interface{}); ok {
+			if _, ok = data["metadata"]; ok {
+				data = nested
+			}
+		}
+		username, _ := data["username"].(string)
+		password, _ := data["password"].(string)
+		if username == "" || password == "" {
+			return nil, 0, errors.New("username or password is missing in the Vault secret")
+		}
+		return &userPassword{username: username, password: password}, time.Duration(secret.LeaseDuration) * time.Second, nil
+	}
+	c := &vaultCredentials{source: &secretSource{path: path, refreshInterval: conf.Vault.RefreshInterval, fetch: fetch}}
+	if _, err = c.source.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// credentials implements saslCredentialsProvider
+func (c *vaultCredentials) credentials() (string, string, error) {
+	value, err := c.source.get()
+	if err != nil {
+		return "", "", err
+	}
+	credentials := value.(*userPassword)
+	return credentials.username, credentials.password, nil
+}
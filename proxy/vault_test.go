@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/grepplabs/kafka-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testVaultServer serves the PKI issue endpoint pki/issue/kafka, the KV version 2 secret secret/data/kafka
+// and the database credentials database/creds/kafka, each response has a new serial number or user name
+func testVaultServer(a *assert.Assertions, ca *testRevocationCA) (*httptest.Server, *int32, *int32) {
+	var requests, failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if atomic.LoadInt32(&failing) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var response map[string]interface{}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/pki/issue/kafka":
+			var body map[string]string
+			a.Nil(json.NewDecoder(r.Body).Decode(&body))
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			a.Nil(err)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(int64(n)),
+				Subject:      pkix.Name{CommonName: body["common_name"]},
+				DNSNames:     []string{body["common_name"]},
+				NotBefore:    time.Now().Add(-time.Minute),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+			a.Nil(err)
+			keyDER, err := x509.MarshalECPrivateKey(key)
+			a.Nil(err)
+			response = map[string]interface{}{"data": map[string]interface{}{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+				"ca_chain":    []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))},
+			}}
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/kafka":
+			response = map[string]interface{}{"lease_duration": 0, "data": map[string]interface{}{
+				"data":     map[string]interface{}{"username": "alice", "password": fmt.Sprintf("secret-%d", n)},
+				"metadata": map[string]interface{}{"version": n},
+			}}
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/kafka":
+			response = map[string]interface{}{"lease_id": "database/creds/kafka/1", "lease_duration": 3600, "data": map[string]interface{}{
+				"username": fmt.Sprintf("v-kafka-%d", n), "password": "secret",
+			}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		a.Nil(json.NewEncoder(w).Encode(response))
+	}))
+	return server, &requests, &failing
+}
+
+func testVaultConfig(address string) *config.Config {
+	c := config.NewConfig()
+	c.Vault.Address = address
+	c.Vault.Token = "s.token"
+	c.Vault.Timeout = time.Second
+	return c
+}
+
+func TestVaultCertificate(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newTestRevocationCA(a)
+	server, requests, failing := testVaultServer(a, ca)
+	defer server.Close()
+
+	c := testVaultConfig(server.URL)
+	c.Proxy.TLS.ListenerVaultPKI = config.VaultPKIConfig{Path: "pki/issue/kafka", CommonName: "localhost", TTL: time.Hour}
+	serverConfig, err := newTLSListenerConfig(c, nil)
+	a.Nil(err)
+	a.Equal(int32(1), atomic.LoadInt32(requests))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	handshake := func() *x509.Certificate {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		go tls.Server(c1, serverConfig).Handshake()
+		client := tls.Client(c2, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+		client.SetDeadline(time.Now().Add(3 * time.Second))
+		a.Nil(client.Handshake())
+		return client.ConnectionState().PeerCertificates[0]
+	}
+	a.Equal(int64(1), handshake().SerialNumber.Int64())
+
+	// the certificate is issued again after two thirds of its lifetime
+	cert, err := newVaultCertificate(c, c.Proxy.TLS.ListenerVaultPKI)
+	a.Nil(err)
+	a.True(cert.source.nextFetch.After(time.Now().Add(35 * time.Minute)))
+	cert.source.nextFetch = time.Now()
+	renewed, err := cert.certificate()
+	a.Nil(err)
+	a.Equal(int64(3), renewed.Leaf.SerialNumber.Int64())
+
+	// the previous certificate is used until it expires
+	atomic.StoreInt32(failing, 1)
+	cert.source.nextFetch = time.Now()
+	previous, err := cert.certificate()
+	a.Nil(err)
+	a.Equal(renewed, previous)
+	cert.source.nextFetch = time.Now()
+	cert.source.expiry = time.Now()
+	_, err = cert.certificate()
+	a.NotNil(err)
+
+	_, err = newVaultCertificate(c, config.VaultPKIConfig{Path: "pki/issue/unknown"})
+	a.NotNil(err)
+}
+
+func TestVaultCredentials(t *testing.T) {
+	a := assert.New(t)
+
+	ca := newTestRevocationCA(a)
+	server, _, _ := testVaultServer(a, ca)
+	defer server.Close()
+
+	c := testVaultConfig(server.URL)
+	credentials, err := newVaultCredentials(c, "secret/data/kafka")
+	a.Nil(err)
+	username, password, err := credentials.credentials()
+	a.Nil(err)
+	a.Equal("alice", username)
+	a.Equal("secret-1", password)
+	a.True(credentials.source.expiry.IsZero())
+
+	// the KV secrets are fetched after the refresh interval
+	credentials.source.nextFetch = time.Now()
+	_, password, err = credentials.credentials()
+	a.Nil(err)
+	a.Equal("secret-2", password)
+
+	c.Kafka.SASL.Enable = true
+	c.Kafka.SASL.VaultPath = "database/creds/kafka"
+	auth, err := newSASLAuthenticator(c)
+	a.Nil(err)
+	plainAuth := auth.(*SASLPlainAuth)
+	username, _, err = plainAuth.credentialsProvider.credentials()
+	a.Nil(err)
+	a.Equal("v-kafka-3", username)
+	source := plainAuth.credentialsProvider.(*vaultCredentials).source
+	a.WithinDuration(time.Now().Add(time.Hour), source.expiry, time.Minute)
+
+	c.Vault.Token = "s.invalid"
+	_, err = newSASLAuthenticator(c)
+	a.NotNil(err)
+}